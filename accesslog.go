@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"drive-gallery/backend"
+)
+
+// accessRecorder notes the status and body size of a response for the access log.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *accessRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// viewerOptedOut reports whether the viewer asked not to be tracked, with Do Not Track or Global
+// Privacy Control.
+func viewerOptedOut(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
+
+// withAccessLog records GET and HEAD requests of a media route under prefix, whose path ends
+// with the file ID, in the access log (see backend.InitAccessLog).
+func withAccessLog(kind, prefix string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !backend.AccessLogEnabled() || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			handler(w, r)
+			return
+		}
+		if viewerOptedOut(r) {
+			backend.NoteAccessOptOut()
+			handler(w, r)
+			return
+		}
+		rec := &accessRecorder{ResponseWriter: w}
+		handler(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		fileID := strings.TrimPrefix(r.URL.Path, prefix)
+		backend.LogAccess(kind, fileID, rec.status, rec.bytes, clientIP(r), r.Referer(), r.UserAgent())
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"drive-gallery/backend"
+)
+
+// callerKey marks the context of a request with the caller withAuthz verified, so handlers don't
+// verify the token again.
+type callerKey struct{}
+
+// requestCaller returns the signed-in user who sent r, from an "Authorization: Bearer <Firebase ID
+// token>" header. Requests without the header are anonymous and return nil. An invalid or expired
+// token is an error rather than anonymous, so a client with a stale token notices.
+func requestCaller(r *http.Request) (*backend.Caller, error) {
+	if caller, ok := r.Context().Value(callerKey{}).(*backend.Caller); ok {
+		return caller, nil // Verified by withAuthz
+	}
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, nil
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return nil, errors.New("authorization header is not a bearer token")
+	}
+	return backend.VerifyIDToken(r.Context(), token)
+}
+
+// viewerContext returns the context of r, marked with backend.WithPublicViewer unless r is from a
+// signed-in user, so listings leave out private folders and files showing people hidden from public
+// galleries, and with backend.WithModerator for editors and admins, who are shown uploads awaiting
+// approval. An invalid token counts as anonymous here, as listings don't require sign-in.
+func viewerContext(r *http.Request) context.Context {
+	caller, err := requestCaller(r)
+	if err != nil || caller == nil {
+		return backend.WithPublicViewer(r.Context())
+	}
+	if caller.Can(backend.PermissionModerate) {
+		return backend.WithModerator(r.Context())
+	}
+	return r.Context()
+}
+
+// callerUID returns the UID of caller, or "" for anonymous requests.
+func callerUID(caller *backend.Caller) string {
+	if caller == nil {
+		return ""
+	}
+	return caller.UID
+}
+
+// authorizeFileChanges checks that the caller may delete or edit the files with the given IDs:
+// their uploader, an editor or an admin (see backend.Caller.CanModifyFile), and that none of them
+// is in an archived folder. Otherwise it writes a 401, 403 or 409 JSON error, listing the
+// offending IDs, and returns false. It returns the caller, to attribute the changes to.
+func authorizeFileChanges(w http.ResponseWriter, r *http.Request, ids []string) (*backend.Caller, bool) {
+	writeError := func(status int, body map[string]interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+
+	caller, err := requestCaller(r)
+	if err != nil {
+		log.Printf("Rejected request with invalid ID token: %v", err)
+		writeError(http.StatusUnauthorized, map[string]interface{}{"error": tr(r, "Invalid or expired ID token")})
+		return nil, false
+	}
+	if caller == nil {
+		writeError(http.StatusUnauthorized, map[string]interface{}{"error": tr(r, "Sign-in required")})
+		return nil, false
+	}
+	forbidden, err := backend.FilesNotModifiableBy(r.Context(), caller, ids)
+	if err != nil {
+		log.Printf("Error checking file permissions of %s: %v", caller.UID, err)
+		writeError(http.StatusInternalServerError, map[string]interface{}{"error": tr(r, "Unable to check permissions: %v", err)})
+		return nil, false
+	}
+	if len(forbidden) > 0 {
+		log.Printf("User %s may not change files %v", caller.UID, forbidden)
+		writeError(http.StatusForbidden, map[string]interface{}{
+			"error":     tr(r, "Only the uploader, editors and admins may change these files"),
+			"forbidden": forbidden,
+		})
+		return nil, false
+	}
+	archived, err := backend.FilesInArchivedFolders(r.Context(), ids)
+	if err != nil {
+		log.Printf("Error checking folders of files %v: %v", ids, err)
+		writeError(http.StatusInternalServerError, map[string]interface{}{"error": tr(r, "Unable to check permissions: %v", err)})
+		return nil, false
+	}
+	if len(archived) > 0 {
+		writeError(http.StatusConflict, map[string]interface{}{
+			"error":    tr(r, "Files in archived folders cannot be changed"),
+			"archived": archived,
+		})
+		return nil, false
+	}
+	return caller, true
+}
+
+// requireModerator checks that the caller is signed in with the editor or admin role. Otherwise it
+// writes a 401 or 403 JSON error and returns false.
+func requireModerator(w http.ResponseWriter, r *http.Request) (*backend.Caller, bool) {
+	return requireRole(w, r, tr(r, "Editor or admin role required"), backend.RoleEditor, backend.RoleAdmin)
+}
+
+// requireAdmin is requireModerator for actions reserved to admins.
+func requireAdmin(w http.ResponseWriter, r *http.Request) (*backend.Caller, bool) {
+	return requireRole(w, r, tr(r, "Admin role required"), backend.RoleAdmin)
+}
+
+// requireRole checks that the caller is signed in with one of roles, writing a 401 JSON error,
+// or a 403 with forbiddenMessage, otherwise.
+func requireRole(w http.ResponseWriter, r *http.Request, forbiddenMessage string, roles ...string) (*backend.Caller, bool) {
+	caller, err := requestCaller(r)
+	if err == nil && caller != nil && slices.Contains(roles, caller.Role) {
+		return caller, true
+	}
+	writeAuthError(w, r, caller, err, forbiddenMessage)
+	return nil, false
+}
+
+// writeAuthError writes the JSON error for a caller who may not send r, as returned by
+// requestCaller: 401 for an invalid token or an anonymous caller, 403 with forbiddenMessage for
+// a signed-in one.
+func writeAuthError(w http.ResponseWriter, r *http.Request, caller *backend.Caller, err error, forbiddenMessage string) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case err != nil:
+		log.Printf("Rejected request with invalid ID token: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Invalid or expired ID token")})
+	case caller == nil:
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Sign-in required")})
+	default:
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": forbiddenMessage})
+	}
+}
+
+// withAuthz enforces the permission each route needs (see backend.RequiredPermission) before
+// the handler runs, answering 401 or 403 otherwise. Handlers keep their own checks, e.g. that
+// only the uploader, editors and admins may change a file.
+func withAuthz(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		permission := backend.RequiredPermission(r.Method, r.URL.Path)
+		if permission == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		caller, err := requestCaller(r)
+		if err == nil && caller.Can(permission) {
+			if caller != nil {
+				r = r.WithContext(context.WithValue(r.Context(), callerKey{}, caller))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		setCorsHeaders(w) // So browsers let the frontend read the error
+		if err == nil && caller != nil {
+			log.Printf("User %s (%s) lacks permission %s for %s %s", caller.UID, caller.EffectiveRole(), permission, r.Method, r.URL.Path)
+		}
+		writeAuthError(w, r, caller, err, permissionMessage(r, permission))
+	})
+}
+
+// permissionMessage returns the 403 error message for lacking permission.
+func permissionMessage(r *http.Request, permission string) string {
+	switch permission {
+	case backend.PermissionUpload:
+		return tr(r, "Uploading requires the editor or admin role")
+	case backend.PermissionEdit, backend.PermissionModerate:
+		return tr(r, "Editor or admin role required")
+	default:
+		return tr(r, "Admin role required")
+	}
+}
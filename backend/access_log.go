@@ -0,0 +1,409 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	bigquery "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/iterator"
+)
+
+// Classes of AccessEntry.UAClass.
+const (
+	UABrowser = "browser"
+	UAMobile  = "mobile"
+	UABot     = "bot"
+	UACLI     = "cli"
+	UAOther   = "other"
+)
+
+// Access log buffering: entries are written in batches, and dropped rather than slowing media
+// requests down when the sink can't keep up.
+const (
+	accessLogBuffer        = 10000
+	accessLogBatch         = 500
+	accessLogFlushInterval = 10 * time.Second
+	defaultAccessRetention = 30 // Days
+)
+
+// accessLogPrefix is where the GCS sink stores its objects in the bucket.
+const accessLogPrefix = "access-logs/"
+
+// AccessEntry is an access log record of a media or thumbnail request. It holds nothing that
+// identifies a viewer: the IP is truncated to its network (/24, /48) and hashed with a salt that
+// changes every day, so it counts distinct viewers of a day but can't be reversed or followed
+// across days; the referrer is cut to its origin and the user agent to a class.
+type AccessEntry struct {
+	Time     time.Time `json:"time"`
+	FileID   string    `json:"fileId"`
+	Kind     string    `json:"kind"` // "media" or "thumbnail"
+	Status   int       `json:"status"`
+	Bytes    int64     `json:"bytes"`
+	IPHash   string    `json:"ipHash,omitempty"`
+	Referrer string    `json:"referrer,omitempty"` // Origin of the referring page
+	UAClass  string    `json:"uaClass"`            // UABrowser, UAMobile, UABot, UACLI or UAOther
+}
+
+// AccessLogSink stores access log entries. Prune deletes the entries older than before, for the
+// configured retention; sinks whose storage expires entries itself do nothing.
+type AccessLogSink interface {
+	Name() string
+	Write(ctx context.Context, entries []AccessEntry) error
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// AccessLogStats are the counters of the access log for GalleryStats.
+type AccessLogStats struct {
+	Sink     string `json:"sink"` // Empty when access logging is off
+	Written  int64  `json:"written"`
+	Dropped  int64  `json:"dropped"`  // Entries lost to a full buffer or failed writes
+	OptedOut int64  `json:"optedOut"` // Requests not logged for Do Not Track or Global Privacy Control
+}
+
+var (
+	accessLogMu    sync.Mutex
+	accessLogSink  AccessLogSink
+	accessLogQueue chan AccessEntry
+	accessStats    AccessLogStats
+	accessSalt     []byte
+)
+
+// InitAccessLog starts access logging to the sink ACCESS_LOG_SINK names: "stdout" (JSON lines),
+// "gcs" (NDJSON objects under access-logs/ in ACCESS_LOG_BUCKET, or the default bucket) or
+// "bigquery" (streamed into the table ACCESS_LOG_BIGQUERY_TABLE, "project.dataset.table").
+// Entries older than ACCESS_LOG_RETENTION_DAYS (30) are deleted once a day. ACCESS_LOG_SALT keys
+// the IP hashes; set the same value on every instance, or distinct viewers are counted per
+// instance. Without ACCESS_LOG_SINK nothing is logged.
+func InitAccessLog(ctx context.Context) error {
+	var sink AccessLogSink
+	switch name := os.Getenv("ACCESS_LOG_SINK"); name {
+	case "":
+		return nil
+	case "stdout":
+		sink = stdoutAccessSink{}
+	case "gcs":
+		bucket, err := bucketHandle(os.Getenv("ACCESS_LOG_BUCKET"))
+		if err != nil {
+			return err
+		}
+		sink = &gcsAccessSink{bucket: bucket, instance: newID()}
+	case "bigquery":
+		project, table, ok := strings.Cut(os.Getenv("ACCESS_LOG_BIGQUERY_TABLE"), ".")
+		dataset, table, ok2 := strings.Cut(table, ".")
+		if !ok || !ok2 {
+			return fmt.Errorf("ACCESS_LOG_BIGQUERY_TABLE must be \"project.dataset.table\", got %q", os.Getenv("ACCESS_LOG_BIGQUERY_TABLE"))
+		}
+		service, err := bigquery.NewService(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create BigQuery client: %v", err)
+		}
+		sink = &bigQueryAccessSink{service: service, project: project, dataset: dataset, table: table}
+	default:
+		return fmt.Errorf("unknown ACCESS_LOG_SINK %q (use stdout, gcs or bigquery)", name)
+	}
+
+	retention := defaultAccessRetention
+	if value := os.Getenv("ACCESS_LOG_RETENTION_DAYS"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days <= 0 {
+			return fmt.Errorf("invalid ACCESS_LOG_RETENTION_DAYS %q", value)
+		}
+		retention = days
+	}
+	salt := []byte(os.Getenv("ACCESS_LOG_SALT"))
+	if len(salt) == 0 {
+		salt = make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("failed to generate access log salt: %v", err)
+		}
+		log.Printf("WARNING: ACCESS_LOG_SALT is not set; viewers are counted per instance and restart")
+	}
+
+	accessLogMu.Lock()
+	accessLogSink, accessSalt = sink, salt
+	accessLogQueue = make(chan AccessEntry, accessLogBuffer)
+	accessStats.Sink = sink.Name()
+	queue := accessLogQueue
+	accessLogMu.Unlock()
+
+	go runAccessLog(ctx, sink, queue, retention)
+	log.Printf("Access log: %s, kept %d days", sink.Name(), retention)
+	return nil
+}
+
+// LogAccess queues an access log entry of a request from ip with the given Referer and
+// User-Agent headers. It never blocks; without a sink it does nothing.
+func LogAccess(kind, fileID string, status int, bytes int64, ip, referrer, userAgent string) {
+	accessLogMu.Lock()
+	queue, salt := accessLogQueue, accessSalt
+	accessLogMu.Unlock()
+	if queue == nil {
+		return
+	}
+	at := now().UTC()
+	entry := AccessEntry{
+		Time:     at,
+		FileID:   fileID,
+		Kind:     kind,
+		Status:   status,
+		Bytes:    bytes,
+		IPHash:   hashViewerIP(salt, at, ip),
+		Referrer: referrerOrigin(referrer),
+		UAClass:  ClassifyUserAgent(userAgent),
+	}
+	select {
+	case queue <- entry:
+	default:
+		accessLogMu.Lock()
+		accessStats.Dropped++
+		accessLogMu.Unlock()
+	}
+}
+
+// NoteAccessOptOut counts a request not logged because the viewer opted out.
+func NoteAccessOptOut() {
+	accessLogMu.Lock()
+	defer accessLogMu.Unlock()
+	if accessLogQueue != nil {
+		accessStats.OptedOut++
+	}
+}
+
+// AccessLogEnabled reports whether InitAccessLog configured a sink.
+func AccessLogEnabled() bool {
+	accessLogMu.Lock()
+	defer accessLogMu.Unlock()
+	return accessLogQueue != nil
+}
+
+// AccessStats returns the counters of the access log.
+func AccessStats() AccessLogStats {
+	accessLogMu.Lock()
+	defer accessLogMu.Unlock()
+	return accessStats
+}
+
+// runAccessLog writes queued entries in batches until ctx is done, and prunes the sink daily.
+func runAccessLog(ctx context.Context, sink AccessLogSink, queue <-chan AccessEntry, retentionDays int) {
+	ticker := time.NewTicker(accessLogFlushInterval)
+	defer ticker.Stop()
+	prune := time.NewTicker(24 * time.Hour)
+	defer prune.Stop()
+	pruneSink := func() {
+		if err := sink.Prune(ctx, now().AddDate(0, 0, -retentionDays)); err != nil {
+			log.Printf("Error pruning access log in %s: %v", sink.Name(), err)
+		}
+	}
+	pruneSink()
+
+	var batch []AccessEntry
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := sink.Write(ctx, batch)
+		accessLogMu.Lock()
+		if err != nil {
+			accessStats.Dropped += int64(len(batch))
+		} else {
+			accessStats.Written += int64(len(batch))
+		}
+		accessLogMu.Unlock()
+		if err != nil {
+			log.Printf("Error writing %d access log entries to %s: %v", len(batch), sink.Name(), err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case entry := <-queue:
+			batch = append(batch, entry)
+			if len(batch) >= accessLogBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-prune.C:
+			pruneSink()
+		}
+	}
+}
+
+// hashViewerIP returns a short keyed hash of the network of ip for the day of at, or "" if ip
+// is not an IP address.
+func hashViewerIP(salt []byte, at time.Time, ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		parsed = v4.Mask(net.CIDRMask(24, 32))
+	} else {
+		parsed = parsed.Mask(net.CIDRMask(48, 128))
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(at.Format("2006-01-02") + "|" + parsed.String()))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// referrerOrigin returns the scheme and host of a Referer header, dropping the path and query,
+// which may identify the viewer or carry signed URLs.
+func referrerOrigin(referrer string) string {
+	u, err := url.Parse(referrer)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// ClassifyUserAgent reduces a User-Agent header to one of the UA classes.
+func ClassifyUserAgent(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case ua == "":
+		return UAOther
+	case strings.Contains(ua, "bot") || strings.Contains(ua, "crawler") || strings.Contains(ua, "spider") || strings.Contains(ua, "preview"):
+		return UABot
+	case strings.HasPrefix(ua, "drive-gallery") || strings.HasPrefix(ua, "go-http-client") || strings.HasPrefix(ua, "curl/"):
+		return UACLI
+	case strings.Contains(ua, "mobile") || strings.Contains(ua, "android") || strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad"):
+		return UAMobile
+	case strings.HasPrefix(ua, "mozilla/"):
+		return UABrowser
+	default:
+		return UAOther
+	}
+}
+
+// stdoutAccessSink prints entries as JSON lines, for a log collector to pick up; retention is the
+// collector's.
+type stdoutAccessSink struct{}
+
+func (stdoutAccessSink) Name() string { return "stdout" }
+
+func (stdoutAccessSink) Write(ctx context.Context, entries []AccessEntry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := enc.Encode(map[string]interface{}{"accessLog": entry}); err != nil {
+			return err
+		}
+	}
+	_, err := os.Stdout.Write(buf.Bytes())
+	return err
+}
+
+func (stdoutAccessSink) Prune(ctx context.Context, before time.Time) error { return nil }
+
+// gcsAccessSink writes every batch as an NDJSON object named by date, so a day's entries can be
+// loaded into an analytics tool by prefix.
+type gcsAccessSink struct {
+	bucket   *gcs.BucketHandle
+	instance string // Keeps the objects of instances flushing at once apart
+}
+
+func (s *gcsAccessSink) Name() string { return "gcs" }
+
+func (s *gcsAccessSink) Write(ctx context.Context, entries []AccessEntry) error {
+	at := entries[0].Time
+	name := fmt.Sprintf("%s%s/%s-%d.ndjson", accessLogPrefix, at.Format("2006/01/02"), s.instance, at.UnixNano())
+	w := s.bucket.Object(name).NewWriter(ctx)
+	w.ContentType = "application/x-ndjson"
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// Prune deletes the objects of days before before.
+func (s *gcsAccessSink) Prune(ctx context.Context, before time.Time) error {
+	cutoff := accessLogPrefix + before.UTC().Format("2006/01/02")
+	it := s.bucket.Objects(ctx, &gcs.Query{Prefix: accessLogPrefix})
+	deleted := 0
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list access log objects: %v", err)
+		}
+		if attrs.Name >= cutoff {
+			continue // Names sort by date
+		}
+		if err := s.bucket.Object(attrs.Name).Delete(ctx); err != nil {
+			return fmt.Errorf("failed to delete access log object %s: %v", attrs.Name, err)
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		log.Printf("Access log: deleted %d objects older than %s.", deleted, before.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// bigQueryAccessSink streams entries into a table with the columns of AccessEntry; partition it
+// by day on time so Prune's deletes stay cheap.
+type bigQueryAccessSink struct {
+	service                 *bigquery.Service
+	project, dataset, table string
+}
+
+func (s *bigQueryAccessSink) Name() string { return "bigquery" }
+
+func (s *bigQueryAccessSink) Write(ctx context.Context, entries []AccessEntry) error {
+	rows := make([]*bigquery.TableDataInsertAllRequestRows, 0, len(entries))
+	for _, entry := range entries {
+		rows = append(rows, &bigquery.TableDataInsertAllRequestRows{Json: map[string]bigquery.JsonValue{
+			"time":     entry.Time.Format(time.RFC3339Nano),
+			"fileId":   entry.FileID,
+			"kind":     entry.Kind,
+			"status":   entry.Status,
+			"bytes":    entry.Bytes,
+			"ipHash":   entry.IPHash,
+			"referrer": entry.Referrer,
+			"uaClass":  entry.UAClass,
+		}})
+	}
+	resp, err := s.service.Tabledata.InsertAll(s.project, s.dataset, s.table, &bigquery.TableDataInsertAllRequest{Rows: rows}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to insert into %s.%s.%s: %v", s.project, s.dataset, s.table, err)
+	}
+	if len(resp.InsertErrors) > 0 {
+		return fmt.Errorf("BigQuery rejected %d of %d rows", len(resp.InsertErrors), len(rows))
+	}
+	return nil
+}
+
+// Prune deletes the rows before before. Rows still in the streaming buffer can't be deleted, but
+// those are recent.
+func (s *bigQueryAccessSink) Prune(ctx context.Context, before time.Time) error {
+	query := fmt.Sprintf("DELETE FROM `%s.%s.%s` WHERE time < TIMESTAMP(\"%s\")", s.project, s.dataset, s.table, before.UTC().Format(time.RFC3339))
+	useLegacy := false
+	if _, err := s.service.Jobs.Query(s.project, &bigquery.QueryRequest{Query: query, UseLegacySql: &useLegacy}).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to delete old rows of %s.%s.%s: %v", s.project, s.dataset, s.table, err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetFolder returns the metadata of a logical folder, or nil if it does not exist.
+func GetFolder(ctx context.Context, folderID string) (*FolderMetadata, error) {
+	doc, err := Client.Collection(FoldersCollection).Doc(folderID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get folder %s: %v", folderID, err)
+	}
+	var folder FolderMetadata
+	if err := doc.DataTo(&folder); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal folder metadata: %v", err)
+	}
+	return &folder, nil
+}
+
+// FindFolderByName returns the logical folder with the given name, or nil if there is none. A
+// folder renamed since is found by its previous name, unless another folder has taken it, so
+// uploads and tools using the old name keep reaching it.
+func FindFolderByName(ctx context.Context, name string) (*FolderMetadata, error) {
+	folder, err := findFolderWhere(ctx, "name", "==", name)
+	if err != nil || folder != nil {
+		return folder, err
+	}
+	return findFolderWhere(ctx, "previousNames", "array-contains", name)
+}
+
+// findFolderWhere returns the first folder matching a filter, or nil if there is none.
+func findFolderWhere(ctx context.Context, path, op string, value interface{}) (*FolderMetadata, error) {
+	iter := Client.Collection(FoldersCollection).Where(path, op, value).Limit(1).Documents(ctx)
+	defer iter.Stop()
+	doc, err := iter.Next()
+	if err == iterator.Done {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Firestore for folder '%v': %v", value, err)
+	}
+	var folder FolderMetadata
+	if err := doc.DataTo(&folder); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal folder metadata: %v", err)
+	}
+	return &folder, nil
+}
+
+// SetFolderLocalizedName sets the display name of a logical folder in lang, one of
+// FolderLanguages. An empty name removes it, so the default name is shown again.
+func SetFolderLocalizedName(ctx context.Context, folderID, lang, name string) error {
+	supported := false
+	for _, l := range FolderLanguages {
+		supported = supported || l == lang
+	}
+	if !supported {
+		return fmt.Errorf("unsupported language '%s' (supported: %v)", lang, FolderLanguages)
+	}
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return err
+	}
+	if folder == nil {
+		return notFound("folder %s", folderID)
+	}
+
+	var value interface{} = name
+	if name == "" {
+		value = firestore.Delete
+	}
+	if _, err := Client.Collection(FoldersCollection).Doc(folderID).Update(ctx, []firestore.Update{{FieldPath: firestore.FieldPath{"names", lang}, Value: value}}); err != nil {
+		return fmt.Errorf("failed to set %s name of folder %s: %v", lang, folderID, err)
+	}
+	log.Printf("Folder %s %s name set to '%s'.", folderID, lang, name)
+	recordFolderChange(ctx, folderID, FolderChange{Type: ChangeEdited, Name: folder.Name, Fields: []string{"names." + lang}})
+	return nil
+}
+
+// ListAllFilesInFolder returns every file of a logical folder without pagination, read a page
+// at a time (see pagedQuery).
+func ListAllFilesInFolder(ctx context.Context, folderID string) ([]FileMetadata, error) {
+	query := Client.Collection(FilesCollection).Where("folderId", "==", folderID).OrderBy(firestore.DocumentID, firestore.Asc)
+	var files []FileMetadata
+	err := pagedQuery(ctx, query, 0, 0, func(docs []*firestore.DocumentSnapshot) error {
+		for _, doc := range docs {
+			var file FileMetadata
+			if err := doc.DataTo(&file); err != nil {
+				return fmt.Errorf("failed to unmarshal file metadata from doc %s: %v", doc.Ref.ID, err)
+			}
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to iterate files: %v", err)
+	}
+	return files, nil
+}
+
+// DeleteFolder deletes a logical folder together with all of its files in Storage and Firestore.
+// It returns the number of files deleted. The folder document is only removed if every file was
+// deleted, so a file under legal hold keeps it; a folder under legal hold is a LegalHoldError.
+func DeleteFolder(ctx context.Context, folderID string) (int, error) {
+	if err := checkFolderLegalHold(ctx, folderID); err != nil {
+		return 0, err
+	}
+	files, err := ListAllFilesInFolder(ctx, folderID)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for start := 0; start < len(files); start += maxBatchDeleteIDs {
+		end := start + maxBatchDeleteIDs
+		if end > len(files) {
+			end = len(files)
+		}
+		ids := make([]string, 0, end-start)
+		for _, f := range files[start:end] {
+			ids = append(ids, f.ID)
+		}
+		result, err := DeleteFilesByIDs(ctx, ids)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += len(result.Deleted) + len(result.NotFound)
+		if len(result.Failed) > 0 {
+			return deleted, fmt.Errorf("failed to delete %d files of folder %s", len(result.Failed), folderID)
+		}
+	}
+
+	if _, err := Client.Collection(FoldersCollection).Doc(folderID).Delete(ctx); err != nil {
+		return deleted, fmt.Errorf("failed to delete folder %s: %v", folderID, err)
+	}
+	if err := deleteFolderChanges(ctx, folderID); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	deleteFolderSnapshot(ctx, folderID)
+	recordGalleryChange(ctx, FolderChange{Type: ChangeRemoved, FolderID: folderID})
+	log.Printf("Folder %s deleted with %d files.", folderID, deleted)
+	return deleted, nil
+}
+
+// FileFieldUpdate sets fields of a single file document.
+type FileFieldUpdate struct {
+	ID     string
+	Fields map[string]interface{} // Firestore field path -> new value
+}
+
+// BulkUpdateFiles applies updates with a Firestore BulkWriter, which batches and parallelizes the
+// writes, so thousands of documents are updated in minutes instead of one request per file.
+// It returns an error message for every document that could not be updated, keyed by ID.
+func BulkUpdateFiles(ctx context.Context, updates []FileFieldUpdate) map[string]string {
+	writer := Client.BulkWriter(ctx)
+	jobs := make(map[string]*firestore.BulkWriterJob, len(updates))
+	failed := make(map[string]string)
+	for _, update := range updates {
+		fields := make([]firestore.Update, 0, len(update.Fields)+1)
+		for path, value := range update.Fields {
+			fields = append(fields, firestore.Update{Path: path, Value: value})
+		}
+		if mimeType, ok := update.Fields["mimeType"].(string); ok {
+			if _, ok := update.Fields["mediaType"]; !ok {
+				fields = append(fields, firestore.Update{Path: "mediaType", Value: mediaTypeOf(mimeType)}) // Keep the denormalized field in sync
+			}
+		}
+		ref := Client.Collection(FilesCollection).Doc(update.ID)
+		if err := waitForWrite(ctx, ref); err != nil {
+			failed[update.ID] = err.Error()
+			continue
+		}
+		job, err := writer.Update(ref, fields)
+		if err != nil {
+			failed[update.ID] = err.Error()
+			continue
+		}
+		jobs[update.ID] = job
+	}
+
+	writer.End() // Blocks until every enqueued write has completed
+	for id, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			failed[id] = err.Error()
+		}
+	}
+	log.Printf("Bulk update finished: %d updated, %d failed.", len(updates)-len(failed), len(failed))
+	return failed
+}
@@ -0,0 +1,79 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/firestore"
+)
+
+// ArchivedFolderError is returned for uploads to an archived folder, which is read-only.
+type ArchivedFolderError struct {
+	FolderID string
+	Name     string
+}
+
+func (e *ArchivedFolderError) Error() string {
+	return fmt.Sprintf("folder '%s' is archived", e.Name)
+}
+
+func (e *ArchivedFolderError) Is(target error) bool { return target == ErrConflict }
+
+// SetFolderArchived archives a folder, making it read-only, or unarchives it. Archived folders
+// are still listed and readable, but uploads, deletes and metadata edits of their files are
+// rejected. It returns the updated folder.
+func SetFolderArchived(ctx context.Context, folderID string, archived bool) (*FolderMetadata, error) {
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil {
+		return nil, notFound("folder %s", folderID)
+	}
+	if folder.Archived == archived {
+		return folder, nil
+	}
+	folder.Archived = archived
+	if _, err := Client.Collection(FoldersCollection).Doc(folderID).Update(ctx, []firestore.Update{{Path: "archived", Value: archived}}); err != nil {
+		return nil, fmt.Errorf("failed to update folder %s: %v", folderID, err)
+	}
+	log.Printf("Folder %s archived: %t", folderID, archived)
+	recordFolderChange(ctx, folderID, FolderChange{Type: ChangeEdited, Name: folder.Name, Fields: []string{"archived"}})
+	BroadcastEvent(EventFolderUpdated, *folder)
+	return folder, nil
+}
+
+// FilesInArchivedFolders returns the IDs of the given files that belong to an archived folder.
+// Unknown IDs are ignored.
+func FilesInArchivedFolders(ctx context.Context, ids []string) ([]string, error) {
+	refs := make([]*firestore.DocumentRef, len(ids))
+	for i, id := range ids {
+		refs[i] = Client.Collection(FilesCollection).Doc(id)
+	}
+	docs, err := Client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file metadata from Firestore: %v", err)
+	}
+	archived := make(map[string]bool) // By folder ID
+	locked := []string{}
+	for i, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		folderID, _ := doc.Data()["folderId"].(string)
+		isArchived, ok := archived[folderID]
+		if !ok {
+			folder, err := GetFolder(ctx, folderID)
+			if err != nil {
+				return nil, err
+			}
+			isArchived = folder != nil && folder.Archived
+			archived[folderID] = isArchived
+		}
+		if isArchived {
+			locked = append(locked, ids[i])
+		}
+	}
+	return locked, nil
+}
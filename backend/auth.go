@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/v4/auth"
+)
+
+// AuthClient is the global Firebase Auth client, used to verify the ID tokens of signed-in users.
+var AuthClient *auth.Client
+
+// Caller is the signed-in user who sent a request.
+type Caller struct {
+	UID   string `json:"uid"`
+	Email string `json:"email,omitempty"`
+	Role  string `json:"role,omitempty"` // RoleAdmin, RoleEditor, RoleViewer, or empty for ordinary users
+}
+
+// VerifyIDToken checks a Firebase ID token, as sent by the frontend after sign-in, and returns
+// the user it belongs to, with the role stored in UsersCollection or else the token's.
+func VerifyIDToken(ctx context.Context, idToken string) (*Caller, error) {
+	if AuthClient == nil {
+		return nil, fmt.Errorf("Firebase Auth client not initialized")
+	}
+	token, err := AuthClient.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+	caller := &Caller{UID: token.UID}
+	if email, ok := token.Claims["email"].(string); ok {
+		caller.Email = email
+	}
+	if role, ok := token.Claims["role"].(string); ok {
+		caller.Role = role
+	}
+	if Client != nil {
+		applyStoredRole(ctx, caller)
+	}
+	return caller, nil
+}
+
+// Roles, set as the "role" custom claim of a Firebase Auth user or stored with SetUserRole.
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+)
+
+// CanModifyFile reports whether the caller may delete or edit a file: its uploader can, and so can
+// editors and admins. Files uploaded anonymously can only be changed by editors and admins.
+func (c *Caller) CanModifyFile(file FileMetadata) bool {
+	if c == nil {
+		return false
+	}
+	if c.Role == RoleAdmin || c.Role == RoleEditor {
+		return true
+	}
+	return file.UploaderUID != "" && file.UploaderUID == c.UID
+}
+
+// FilesNotModifiableBy returns the IDs among ids of existing files the caller may not change (see
+// CanModifyFile). Missing files are not included.
+func FilesNotModifiableBy(ctx context.Context, caller *Caller, ids []string) ([]string, error) {
+	refs := make([]*firestore.DocumentRef, len(ids))
+	for i, id := range ids {
+		refs[i] = Client.Collection(FilesCollection).Doc(id)
+	}
+	docs, err := Client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file metadata from Firestore: %v", err)
+	}
+	forbidden := []string{}
+	for i, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		var file FileMetadata
+		if err := doc.DataTo(&file); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal file metadata from doc %s: %v", ids[i], err)
+		}
+		if !caller.CanModifyFile(file) {
+			forbidden = append(forbidden, ids[i])
+		}
+	}
+	return forbidden, nil
+}
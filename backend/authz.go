@@ -0,0 +1,262 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/v4/auth"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UsersCollection holds the roles admins gave users, by UID. A stored role takes precedence over
+// the "role" custom claim of the user's ID token.
+const UsersCollection = "users"
+
+// RoleViewer is the role of signed-in users without another one: with enforceRoles they can view
+// the gallery but not change it. Anonymous visitors have the permissions of viewers.
+const RoleViewer = "viewer"
+
+// Roles are the roles a user can be given, least privileged first.
+var Roles = []string{RoleViewer, RoleEditor, RoleAdmin}
+
+// Permissions, granted by roles and required per route by RequiredPermission.
+const (
+	PermissionUpload         = "upload"          // Upload and import files, and change one's own uploads
+	PermissionEdit           = "edit"            // Change folders, tags and the files of others
+	PermissionDeleteFolders  = "delete_folders"  // Delete folders with all of their files
+	PermissionManageProfiles = "manage_profiles" // Create, edit, reorder and delete member profiles
+	PermissionManageUsers    = "manage_users"    // Give users roles
+	PermissionModerate       = "moderate"        // Use the /api/admin endpoints: stats, reports, duplicates and maintenance jobs
+)
+
+// rolePermissions are the permissions of each role.
+var rolePermissions = map[string][]string{
+	RoleViewer: nil,
+	RoleEditor: {PermissionUpload, PermissionEdit, PermissionModerate},
+	RoleAdmin:  {PermissionUpload, PermissionEdit, PermissionDeleteFolders, PermissionManageProfiles, PermissionManageUsers, PermissionModerate},
+}
+
+// unenforcedPermissions are granted to everyone when enforceRoles is turned off: what a frontend
+// that cannot sign in needs to upload and manage profiles. Handlers still apply their own checks,
+// e.g. that only the uploader, editors and admins may change a file.
+var unenforcedPermissions = []string{PermissionUpload, PermissionEdit, PermissionManageProfiles}
+
+// userRoleCacheTTL is how long the stored role of a user is cached for verifying their requests;
+// changes made on this instance apply immediately.
+const userRoleCacheTTL = time.Minute
+
+// User is the stored role of a user.
+type User struct {
+	UID       string    `json:"uid" firestore:"uid"`
+	Email     string    `json:"email,omitempty" firestore:"email,omitempty"`
+	Role      string    `json:"role" firestore:"role"`
+	UpdatedBy string    `json:"updatedBy,omitempty" firestore:"updatedBy,omitempty"` // UID of the admin
+	CreatedAt time.Time `json:"createdAt" firestore:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" firestore:"updatedAt"`
+}
+
+// InvalidRoleError is returned for giving a user a role that is not one of Roles.
+type InvalidRoleError struct {
+	Role string
+}
+
+func (e *InvalidRoleError) Error() string {
+	return fmt.Sprintf("unknown role %q (valid: %s)", e.Role, strings.Join(Roles, ", "))
+}
+
+type cachedUserRole struct {
+	role     string // Empty without a stored role
+	cachedAt time.Time
+}
+
+var (
+	userRoleCacheMu sync.Mutex
+	userRoleCache   = map[string]cachedUserRole{}
+)
+
+// enforceRoles reports whether viewers and anonymous visitors are limited to reading, which they
+// are unless the ENFORCE_ROLES environment variable is "false", e.g. for local development with a
+// frontend that does not sign in. Then only /api/admin and deleting folders need a role.
+func enforceRoles() bool {
+	return os.Getenv("ENFORCE_ROLES") != "false"
+}
+
+// publicUploads reports whether viewers and anonymous visitors may upload, from the
+// PUBLIC_UPLOADS environment variable ("true"), e.g. for fan-submission folders. Only meaningful
+// with enforceRoles, as everyone may upload otherwise.
+func publicUploads() bool {
+	return os.Getenv("PUBLIC_UPLOADS") == "true"
+}
+
+// EffectiveRole returns the role the caller's permissions derive from: its role, or RoleViewer
+// for anonymous callers and users without one.
+func (c *Caller) EffectiveRole() string {
+	if c == nil || !slices.Contains(Roles, c.Role) {
+		return RoleViewer
+	}
+	return c.Role
+}
+
+// Permissions returns the permissions of the caller, which may be nil for anonymous callers.
+func (c *Caller) Permissions() []string {
+	permissions := slices.Clone(rolePermissions[c.EffectiveRole()])
+	granted := unenforcedPermissions
+	if enforceRoles() {
+		granted = nil
+		if publicUploads() {
+			granted = []string{PermissionUpload}
+		}
+	}
+	for _, permission := range granted {
+		if !slices.Contains(permissions, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+// Can reports whether the caller, nil for anonymous callers, has a permission. The empty
+// permission is granted to everyone.
+func (c *Caller) Can(permission string) bool {
+	return permission == "" || slices.Contains(c.Permissions(), permission)
+}
+
+// RequiredPermission returns the permission a request needs, or "" if anyone may send it. The
+// /api/admin endpoints need PermissionModerate for every method, and changing roles there
+// PermissionManageUsers. Otherwise reading (GET and HEAD) needs none, nor do a few requests
+// anonymous visitors and Drive send: Drive notifications, abuse reports, manifest verification and
+// the local /api/dev endpoints. Uploading and changing one's own files needs PermissionUpload,
+// deleting folders PermissionDeleteFolders and changing profiles PermissionManageProfiles;
+// everything else needs PermissionEdit. CORS preflights (OPTIONS) need none. Handlers still check
+// the finer rules, e.g. that only the uploader, editors and admins may change a file.
+func RequiredPermission(method, path string) string {
+	switch {
+	case method == "OPTIONS":
+		return ""
+	case strings.HasPrefix(path, "/api/admin/users"):
+		return PermissionManageUsers
+	case strings.HasPrefix(path, "/api/admin/"):
+		return PermissionModerate
+	case method == "GET", method == "HEAD":
+		return ""
+	case path == "/webhook", path == "/api/manifests/verify", strings.HasPrefix(path, "/api/dev/"):
+		return ""
+	case strings.HasPrefix(path, "/api/files/") && strings.HasSuffix(path, "/report"):
+		return ""
+	case path == "/api/profiles", strings.HasPrefix(path, "/api/profiles/"), path == "/api/upload/icon":
+		return PermissionManageProfiles
+	case method == "DELETE" && strings.HasPrefix(path, "/api/folders/") && !strings.Contains(strings.TrimPrefix(path, "/api/folders/"), "/"):
+		return PermissionDeleteFolders
+	case strings.HasPrefix(path, "/api/upload/"), path == "/api/drive/upload", strings.HasPrefix(path, "/api/import/"),
+		strings.HasPrefix(path, "/api/files/"), path == "/api/update/file-metadata":
+		return PermissionUpload
+	}
+	return PermissionEdit
+}
+
+// applyStoredRole replaces the role of caller with the one stored in UsersCollection, if any.
+// When Firestore cannot be read, the role of the ID token is kept and the error logged.
+func applyStoredRole(ctx context.Context, caller *Caller) {
+	userRoleCacheMu.Lock()
+	cached, ok := userRoleCache[caller.UID]
+	userRoleCacheMu.Unlock()
+	if !ok || now().Sub(cached.cachedAt) > userRoleCacheTTL {
+		user, err := GetUser(ctx, caller.UID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("Warning: using the token role of %s: %v", caller.UID, err)
+			return
+		}
+		cached = cachedUserRole{cachedAt: now()}
+		if user != nil {
+			cached.role = user.Role
+		}
+		userRoleCacheMu.Lock()
+		userRoleCache[caller.UID] = cached
+		userRoleCacheMu.Unlock()
+	}
+	if cached.role != "" {
+		caller.Role = cached.role
+	}
+}
+
+// GetUser returns the stored role of a user. A user without one is ErrNotFound.
+func GetUser(ctx context.Context, uid string) (*User, error) {
+	doc, err := Client.Collection(UsersCollection).Doc(uid).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, notFound("user %s", uid)
+		}
+		return nil, fmt.Errorf("failed to get user %s: %v", uid, err)
+	}
+	var user User
+	if err := doc.DataTo(&user); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user %s: %v", uid, err)
+	}
+	return &user, nil
+}
+
+// ListUsers returns the users with a stored role, by UID.
+func ListUsers(ctx context.Context) ([]User, error) {
+	iter := Client.Collection(UsersCollection).OrderBy(firestore.DocumentID, firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+	users := []User{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %v", err)
+		}
+		var user User
+		if err := doc.DataTo(&user); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal user %s: %v", doc.Ref.ID, err)
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// SetUserRole stores the role of a Firebase Auth user, attributed to the actor of ctx, and
+// returns the user. An unknown role is an InvalidRoleError, and a UID without a Firebase Auth
+// user ErrNotFound.
+func SetUserRole(ctx context.Context, uid, role string) (*User, error) {
+	if !slices.Contains(Roles, role) {
+		return nil, &InvalidRoleError{Role: role}
+	}
+	if AuthClient == nil {
+		return nil, fmt.Errorf("Firebase Auth client not initialized")
+	}
+	record, err := AuthClient.GetUser(ctx, uid)
+	if err != nil {
+		if auth.IsUserNotFound(err) {
+			return nil, notFound("user %s", uid)
+		}
+		return nil, fmt.Errorf("failed to get Firebase Auth user %s: %v", uid, err)
+	}
+
+	user := &User{UID: uid, Email: record.Email, Role: role, UpdatedBy: actorFrom(ctx), CreatedAt: now(), UpdatedAt: now()}
+	if existing, err := GetUser(ctx, uid); err == nil {
+		user.CreatedAt = existing.CreatedAt
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if _, err := Client.Collection(UsersCollection).Doc(uid).Set(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to save user %s: %v", uid, err)
+	}
+	userRoleCacheMu.Lock()
+	userRoleCache[uid] = cachedUserRole{role: role, cachedAt: now()}
+	userRoleCacheMu.Unlock()
+	log.Printf("User %s (%s) is now %s", uid, user.Email, role)
+	return user, nil
+}
@@ -0,0 +1,193 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	gcs "cloud.google.com/go/storage"
+)
+
+// Fields that BackfillFiles can fill in on documents created before they existed.
+const (
+	BackfillHash       = "hash"       // SHA256 of the content; downloads the object
+	BackfillMediaType  = "mediaType"  // Derived from mimeType
+	BackfillNameSearch = "nameSearch" // Lowercase name
+	BackfillSize       = "size"       // Object size from Storage attrs
+	BackfillColor      = "color"      // Dominant color of images; downloads the object
+	BackfillPHash      = "phash"      // Perceptual hash of images; downloads the object
+	BackfillDimensions = "dimensions" // Width and height of images; reads the image header
+	BackfillDuration   = "duration"   // Length of MP4 and MOV videos; reads the movie header
+)
+
+// BackfillProgress is the running state of a backfill. Passing a saved progress back to
+// BackfillFiles resumes an interrupted run after LastDocID.
+type BackfillProgress struct {
+	Field     string            `json:"field"`
+	LastDocID string            `json:"lastDocId"`
+	Scanned   int               `json:"scanned"`
+	Updated   int               `json:"updated"`
+	Failed    map[string]string `json:"failed,omitempty"` // Error message by document ID
+}
+
+// BackfillFiles scans the files collection in document ID order, starting after
+// progress.LastDocID, and fills in progress.Field on every document that is missing it. Documents are
+// read and written pageSize at a time; onPage is called after each page so callers can report
+// progress and persist a checkpoint. With dryRun set, nothing is written.
+func BackfillFiles(ctx context.Context, progress *BackfillProgress, pageSize int, dryRun bool, onPage func(*BackfillProgress) error) error {
+	switch progress.Field {
+	case BackfillHash, BackfillMediaType, BackfillNameSearch, BackfillSize, BackfillColor, BackfillPHash, BackfillDimensions, BackfillDuration:
+	default:
+		return fmt.Errorf("unknown backfill field '%s'", progress.Field)
+	}
+	if progress.Failed == nil {
+		progress.Failed = make(map[string]string)
+	}
+	query := Client.Collection(FilesCollection).OrderBy(firestore.DocumentID, firestore.Asc)
+	if progress.LastDocID != "" {
+		query = query.StartAfter(progress.LastDocID)
+	}
+	return pagedQuery(ctx, query, pageSize, 0, func(docs []*firestore.DocumentSnapshot) error {
+		var updates []FileFieldUpdate
+		for _, doc := range docs {
+			var file FileMetadata
+			if err := doc.DataTo(&file); err != nil {
+				progress.Failed[doc.Ref.ID] = fmt.Sprintf("failed to unmarshal file metadata: %v", err)
+				continue
+			}
+			var fields map[string]interface{}
+			bucket, err := fileBucket(&file)
+			if err == nil {
+				fields, err = backfillFields(ctx, bucket, progress.Field, &file)
+			}
+			if err != nil {
+				progress.Failed[doc.Ref.ID] = err.Error()
+				continue
+			}
+			if fields != nil {
+				updates = append(updates, FileFieldUpdate{ID: doc.Ref.ID, Fields: fields})
+			}
+		}
+
+		progress.Updated += len(updates)
+		if !dryRun && len(updates) > 0 {
+			for id, msg := range BulkUpdateFiles(ctx, updates) {
+				progress.Failed[id] = msg
+				progress.Updated--
+			}
+		}
+		progress.Scanned += len(docs)
+		progress.LastDocID = docs[len(docs)-1].Ref.ID
+		return onPage(progress)
+	})
+}
+
+// backfillFields returns the document fields to set for a backfill of field, or nil if they are
+// already set. Most backfills set the field of their name; dimensions sets width and height.
+func backfillFields(ctx context.Context, bucket *gcs.BucketHandle, field string, file *FileMetadata) (map[string]interface{}, error) {
+	if field == BackfillDimensions {
+		if file.Width != 0 || mediaTypeOf(file.MimeType) != "image" {
+			return nil, nil
+		}
+		reader, err := bucket.Object(file.StoragePath).NewReader(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open storage object %s: %v", file.StoragePath, err)
+		}
+		defer reader.Close()
+		config, _, err := image.DecodeConfig(reader)
+		if err != nil {
+			return nil, nil // Formats without a decoder are left without dimensions
+		}
+		return map[string]interface{}{"width": config.Width, "height": config.Height}, nil
+	}
+	value, err := backfillValue(ctx, bucket, field, file)
+	if value == nil || err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{field: value}, nil
+}
+
+// backfillValue returns the value field should be set to for file, or nil if it is already set.
+func backfillValue(ctx context.Context, bucket *gcs.BucketHandle, field string, file *FileMetadata) (interface{}, error) {
+	switch field {
+	case BackfillMediaType:
+		if file.MediaType != "" {
+			return nil, nil
+		}
+		return mediaTypeOf(file.MimeType), nil
+
+	case BackfillNameSearch:
+		if file.NameSearch != "" || file.Name == "" {
+			return nil, nil
+		}
+		return strings.ToLower(file.Name), nil
+
+	case BackfillSize:
+		if file.Size != 0 {
+			return nil, nil
+		}
+		attrs, err := bucket.Object(file.StoragePath).Attrs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get storage object attributes for %s: %v", file.StoragePath, err)
+		}
+		return attrs.Size, nil
+
+	case BackfillHash:
+		if file.Hash != "" {
+			return nil, nil
+		}
+		reader, err := bucket.Object(file.StoragePath).NewReader(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open storage object %s: %v", file.StoragePath, err)
+		}
+		defer reader.Close()
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, reader); err != nil {
+			return nil, fmt.Errorf("failed to read storage object %s: %v", file.StoragePath, err)
+		}
+		return hex.EncodeToString(hasher.Sum(nil)), nil
+
+	case BackfillColor, BackfillPHash:
+		if (field == BackfillColor && file.Color != "") || (field == BackfillPHash && file.PHash != "") || mediaTypeOf(file.MimeType) != "image" {
+			return nil, nil
+		}
+		reader, err := bucket.Object(file.StoragePath).NewReader(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open storage object %s: %v", file.StoragePath, err)
+		}
+		defer reader.Close()
+		info, err := AnalyzeImage(reader)
+		if _, unsupported := err.(*UnsupportedImageError); unsupported {
+			return nil, nil // HEIC, WebP and the like have no decoder; they keep the default placeholder
+		}
+		if err != nil {
+			return nil, err
+		}
+		if field == BackfillPHash {
+			return info.PHash, nil
+		}
+		return info.Color, nil
+
+	case BackfillDuration:
+		if file.Duration != 0 {
+			return nil, nil
+		}
+		size := file.Size
+		if size == 0 {
+			attrs, err := bucket.Object(file.StoragePath).Attrs(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get storage object attributes for %s: %v", file.StoragePath, err)
+			}
+			size = attrs.Size
+		}
+		if duration := storedVideoDuration(ctx, bucket, file.StoragePath, file.MimeType, nil, size); duration > 0 {
+			return duration, nil
+		}
+	}
+	return nil, nil
+}
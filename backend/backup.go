@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// BackupVersion is the format version written to new backups.
+const BackupVersion = 1
+
+// Backup is a snapshot of the gallery's Firestore metadata. Storage objects are not included.
+type Backup struct {
+	Version   int              `json:"version"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Folders   []FolderMetadata `json:"folders"`
+	Files     []FileMetadata   `json:"files"`
+	Profiles  []Profile        `json:"profiles"`
+}
+
+// RestoreStats counts the documents written by RestoreBackup.
+type RestoreStats struct {
+	Folders  int `json:"folders"`
+	Files    int `json:"files"`
+	Profiles int `json:"profiles"`
+	Skipped  int `json:"skipped"` // Existing documents left untouched because overwrite was false
+}
+
+// CreateBackup reads all folders, files and profiles from Firestore.
+func CreateBackup(ctx context.Context) (*Backup, error) {
+	backup := &Backup{Version: BackupVersion, CreatedAt: now()}
+
+	folders, err := ListFoldersFromFirestore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	backup.Folders = folders
+
+	query := Client.Collection(FilesCollection).OrderBy(firestore.DocumentID, firestore.Asc)
+	err = pagedQuery(ctx, query, 0, 0, func(docs []*firestore.DocumentSnapshot) error {
+		for _, doc := range docs {
+			var file FileMetadata
+			if err := doc.DataTo(&file); err != nil {
+				return fmt.Errorf("failed to unmarshal file metadata from doc %s: %v", doc.Ref.ID, err)
+			}
+			backup.Files = append(backup.Files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to iterate files: %v", err)
+	}
+
+	profiles, err := GetProfiles(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	backup.Profiles = profiles
+
+	log.Printf("Backup created: %d folders, %d files, %d profiles.", len(backup.Folders), len(backup.Files), len(backup.Profiles))
+	return backup, nil
+}
+
+// RestoreBackup writes the documents of a backup back to Firestore, keeping their IDs.
+// Unless overwrite is set, documents that already exist are skipped.
+func RestoreBackup(ctx context.Context, backup *Backup, overwrite bool) (*RestoreStats, error) {
+	if backup.Version != BackupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", backup.Version)
+	}
+	stats := &RestoreStats{}
+
+	writer := Client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	write := func(ref *firestore.DocumentRef, data interface{}) (bool, error) {
+		if !overwrite {
+			snap, err := ref.Get(ctx)
+			if err == nil && snap.Exists() {
+				stats.Skipped++
+				return false, nil
+			}
+		}
+		if err := waitForWrite(ctx, ref); err != nil {
+			return false, err
+		}
+		job, err := writer.Set(ref, data)
+		if err != nil {
+			return false, fmt.Errorf("failed to enqueue write for %s: %v", ref.Path, err)
+		}
+		jobs = append(jobs, job)
+		return true, nil
+	}
+
+	for _, folder := range backup.Folders {
+		ok, err := write(Client.Collection(FoldersCollection).Doc(folder.ID), folder)
+		if err != nil {
+			return stats, err
+		}
+		if ok {
+			stats.Folders++
+		}
+	}
+	for _, file := range backup.Files {
+		ok, err := write(Client.Collection(FilesCollection).Doc(file.ID), file)
+		if err != nil {
+			return stats, err
+		}
+		if ok {
+			stats.Files++
+		}
+	}
+	for _, profile := range backup.Profiles {
+		// Same fields as CreateProfile/UpdateProfile and ReorderProfiles write
+		ok, err := write(Client.Collection(profileCollection).Doc(profile.ID), map[string]interface{}{
+			"name":     profile.Name,
+			"bio":      profile.Bio,
+			"iconURL":  profile.IconURL,
+			"archived": profile.Archived,
+			"order":    profile.Order,
+		})
+		if err != nil {
+			return stats, err
+		}
+		if ok {
+			stats.Profiles++
+		}
+	}
+
+	writer.End() // Blocks until every enqueued write has completed
+	var firstErr error
+	failed := 0
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return stats, fmt.Errorf("%d writes failed, first error: %v", failed, firstErr)
+	}
+	log.Printf("Backup restored: %d folders, %d files, %d profiles (%d skipped).", stats.Folders, stats.Files, stats.Profiles, stats.Skipped)
+	return stats, nil
+}
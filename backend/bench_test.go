@@ -0,0 +1,126 @@
+package backend
+
+// Benchmarks of the CPU-bound parts of listing and uploading files, which run without Firebase:
+//
+//	go test ./backend -run '^$' -bench . -benchmem
+//
+// tools/loadgen measures the same paths end to end against a running backend.
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+	"time"
+)
+
+// benchListingPage is the size of a listing page, as the frontend requests it.
+const benchListingPage = 100
+
+// benchFiles returns a page of listed images with dimensions and perceptual hashes.
+func benchFiles(n int) []FileMetadata {
+	files := make([]FileMetadata, n)
+	for i := range files {
+		files[i] = FileMetadata{
+			ID:          fmt.Sprintf("file-%04d", i),
+			Name:        fmt.Sprintf("IMG_%04d.jpg", i),
+			MimeType:    "image/jpeg",
+			StoragePath: fmt.Sprintf("folder/IMG_%04d.jpg", i),
+			DownloadURL: fmt.Sprintf("https://storage.googleapis.com/bucket/folder/IMG_%04d.jpg", i),
+			FolderID:    "folder",
+			CreatedAt:   time.Unix(1700000000+int64(i), 0),
+			Width:       4000,
+			Height:      3000,
+			PHash:       fmt.Sprintf("%016x", uint64(i)*0x9e3779b97f4a7c15),
+		}
+	}
+	return files
+}
+
+// benchJPEG returns a width×height JPEG photo-like gradient.
+func benchJPEG(b *testing.B, width, height int) []byte {
+	b.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: uint8((x + y) % 256), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		b.Fatalf("failed to encode JPEG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkAttachAccessURLs(b *testing.B) {
+	folder := &FolderMetadata{ID: "folder", Name: "第1回"}
+	files := benchFiles(benchListingPage)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := AttachAccessURLs(folder, files); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSignedListingURLs(b *testing.B) {
+	files := benchFiles(benchListingPage)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, f := range files {
+			_ = MediaURL(f.ID, true)
+			_ = srcset(f, true)
+		}
+	}
+}
+
+func BenchmarkHideNearDuplicates(b *testing.B) {
+	files := benchFiles(benchListingPage)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		HideNearDuplicates(files, 8)
+	}
+}
+
+func BenchmarkUploadChecksums(b *testing.B) {
+	content := bytes.Repeat([]byte("drive-gallery "), 5<<20/14) // About 5 MiB, a typical photo
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := CalculateFileHash(content); err != nil {
+			b.Fatal(err)
+		}
+		crc32.Checksum(content, crc32cTable)
+	}
+}
+
+func BenchmarkAnalyzeImage(b *testing.B) {
+	content := benchJPEG(b, 1600, 1200)
+	b.SetBytes(int64(len(content)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := AnalyzeImage(bytes.NewReader(content)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMakeThumbnail(b *testing.B) {
+	content := benchJPEG(b, 1600, 1200)
+	b.SetBytes(int64(len(content)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := MakeThumbnail(bytes.NewReader(content), ThumbnailSize); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
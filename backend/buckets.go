@@ -0,0 +1,104 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	gcs "cloud.google.com/go/storage"
+)
+
+// Files can be stored in buckets other than the default one (FIREBASE_STORAGE_BUCKET), e.g. to
+// keep videos in a bucket in another region: a folder can be assigned a bucket with
+// SetFolderBucket, and media types can be mapped to buckets with the MEDIA_TYPE_BUCKETS
+// environment variable ("video=gallery-videos,image=gallery-images"). The bucket of every file is
+// recorded in FileMetadata.Bucket, so a changed assignment only applies to new uploads.
+
+// bucketHandle returns the named bucket, or the default bucket for "".
+func bucketHandle(name string) (*gcs.BucketHandle, error) {
+	if name == "" {
+		bucket, err := StorageClient.DefaultBucket()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get default storage bucket: %v", err)
+		}
+		return bucket, nil
+	}
+	bucket, err := StorageClient.Bucket(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage bucket %s: %v", name, err)
+	}
+	return bucket, nil
+}
+
+// fileBucket returns the bucket a file is stored in.
+func fileBucket(file *FileMetadata) (*gcs.BucketHandle, error) {
+	return bucketHandle(file.Bucket)
+}
+
+// mediaTypeBuckets returns the buckets of MEDIA_TYPE_BUCKETS by media type ("image", "video" or
+// "other", see mediaTypeOf).
+func mediaTypeBuckets() map[string]string {
+	buckets := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv("MEDIA_TYPE_BUCKETS"), ",") {
+		mediaType, bucket, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || bucket == "" {
+			continue
+		}
+		buckets[strings.TrimSpace(mediaType)] = strings.TrimSpace(bucket)
+	}
+	return buckets
+}
+
+// bucketFor returns the name of the bucket a new file of mimeType in folder is stored in, "" for
+// the default bucket. The folder's bucket takes precedence over the media type's. folder may be nil.
+func bucketFor(folder *FolderMetadata, mimeType string) string {
+	if folder != nil && folder.Bucket != "" {
+		return folder.Bucket
+	}
+	return mediaTypeBuckets()[mediaTypeOf(mimeType)]
+}
+
+// uploadBucket returns the bucket a new file of mimeType in the folder folderID is stored in,
+// with its name as recorded in FileMetadata.Bucket.
+func uploadBucket(ctx context.Context, folderID, mimeType string) (string, *gcs.BucketHandle, error) {
+	var folder *FolderMetadata
+	if folderID != "" {
+		var err error
+		if folder, err = GetFolder(ctx, folderID); err != nil {
+			return "", nil, err
+		}
+	}
+	name := bucketFor(folder, mimeType)
+	bucket, err := bucketHandle(name)
+	return name, bucket, err
+}
+
+// SetFolderBucket stores the new uploads of a folder in the named bucket, or in the default
+// bucket again for "". Files already uploaded stay where they are. The bucket must exist and be
+// accessible with the backend's credentials.
+func SetFolderBucket(ctx context.Context, folderID, bucketName string) error {
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return err
+	}
+	if folder == nil {
+		return notFound("folder %s", folderID)
+	}
+	if bucketName != "" {
+		bucket, err := bucketHandle(bucketName)
+		if err != nil {
+			return err
+		}
+		if _, err := bucket.Attrs(ctx); err != nil {
+			return fmt.Errorf("bucket %s is not accessible: %v", bucketName, err)
+		}
+	}
+	if _, err := Client.Collection(FoldersCollection).Doc(folderID).Update(ctx, []firestore.Update{{Path: "bucket", Value: bucketName}}); err != nil {
+		return fmt.Errorf("failed to set bucket of folder %s: %v", folderID, err)
+	}
+	log.Printf("Folder %s now stores new uploads in bucket '%s'.", folderID, bucketName)
+	return nil
+}
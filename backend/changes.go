@@ -0,0 +1,254 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// ChangesCollection is the subcollection of a folder document recording its changes in the order
+// they were committed. Entries are only ever added, and deleted with the folder. The top-level
+// collection of the same name records the changes that outlive a folder's log: deleted folders
+// and profiles. GET /api/sync reads both as one collection group.
+const ChangesCollection = "changes"
+
+// Types of FolderChange.
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeEdited  = "edited"
+)
+
+// Limits of one ListFolderChanges page.
+const (
+	DefaultChangesPageSize = 100
+	MaxChangesPageSize     = 500
+)
+
+// FolderChange is an entry of a change log: a file added, removed or edited, the folder itself
+// added or edited, or, in the top-level log, a folder removed or a profile changed.
+type FolderChange struct {
+	ID        string    `json:"id" firestore:"-"`                                    // Firestore document ID
+	Type      string    `json:"type" firestore:"type"`                               // ChangeAdded, ChangeRemoved or ChangeEdited
+	FolderID  string    `json:"folderId,omitempty" firestore:"folderId,omitempty"`   // Stored only in the top-level log; a folder's log implies it
+	FileID    string    `json:"fileId,omitempty" firestore:"fileId,omitempty"`       // Empty for changes of the folder itself
+	ProfileID string    `json:"profileId,omitempty" firestore:"profileId,omitempty"` // Empty with Fields ["order"] for a reorder of all profiles
+	Name      string    `json:"name,omitempty" firestore:"name,omitempty"`           // File name, or folder name for changes of the folder
+	Fields    []string  `json:"fields,omitempty" firestore:"fields,omitempty"`       // Fields an edit changed
+	Actor     string    `json:"actor,omitempty" firestore:"actor,omitempty"`         // UID of the signed-in user; empty for anonymous users and the CLI
+	Reason    string    `json:"reason,omitempty" firestore:"reason,omitempty"`       // Why a file was taken down (see TakeDownFile)
+	Cursor    string    `json:"cursor" firestore:"-"`                                // Pass as since to list the changes after this one
+	At        time.Time `json:"at" firestore:"at,serverTimestamp"`                   // Commit time, which orders the log
+}
+
+// FolderChanges is a page of a folder's change log, oldest first.
+type FolderChanges struct {
+	Changes []FolderChange `json:"changes"`
+	Cursor  string         `json:"cursor"`  // Of the last change, or the since given if there is none; pass it as since next time
+	HasMore bool           `json:"hasMore"` // More changes follow the page
+}
+
+func init() {
+	RegisterUploadHook(StageIndex, recordUploadChange)
+}
+
+// recordUploadChange is the StageIndex hook recording a saved upload as added to its folder.
+// Uploads awaiting approval are recorded by ApproveUploads instead.
+func recordUploadChange(ctx context.Context, u *Upload) error {
+	if u.File.PendingApproval {
+		return nil
+	}
+	recordFolderChange(ctx, u.File.FolderID, FolderChange{Type: ChangeAdded, FileID: u.File.ID, Name: u.File.Name, Actor: u.Source.UploaderUID})
+	return nil
+}
+
+type actorKey struct{}
+
+// WithActor returns a context attributing the changes made with it to the user uid.
+func WithActor(ctx context.Context, uid string) context.Context {
+	return context.WithValue(ctx, actorKey{}, uid)
+}
+
+func actorFrom(ctx context.Context) string {
+	uid, _ := ctx.Value(actorKey{}).(string)
+	return uid
+}
+
+// changeCursor encodes the position of a change in the log: its commit time and document ID,
+// which breaks ties between changes committed together.
+func changeCursor(change FolderChange) string {
+	return strconv.FormatInt(change.At.UnixNano(), 10) + "." + change.ID
+}
+
+func parseChangeCursor(cursor string) (time.Time, string, error) {
+	nanos, id, ok := strings.Cut(cursor, ".")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if !ok || err != nil || id == "" {
+		return time.Time{}, "", &InvalidCursorError{Cursor: cursor}
+	}
+	return time.Unix(0, n).UTC(), id, nil
+}
+
+// InvalidCursorError is returned by ListFolderChanges for a since it did not hand out.
+type InvalidCursorError struct {
+	Cursor string
+}
+
+func (e *InvalidCursorError) Error() string {
+	return fmt.Sprintf("invalid changes cursor %q", e.Cursor)
+}
+
+// recordFolderChange appends a change to a folder's log, attributed to the actor of ctx unless
+// change.Actor is set. The change has already happened, so failures are logged, not returned.
+// Commit timestamps come from Firestore, so the log is ordered even across instances: a change
+// committed after a listing always sorts after its cursor.
+func recordFolderChange(ctx context.Context, folderID string, change FolderChange) {
+	if folderID == "" {
+		return
+	}
+	if change.Actor == "" {
+		change.Actor = actorFrom(ctx)
+	}
+	ref := Client.Collection(FoldersCollection).Doc(folderID).Collection(ChangesCollection).Doc(newID())
+	if _, err := ref.Create(ctx, change); err != nil {
+		log.Printf("Error recording %s change of folder %s: %v", change.Type, folderID, err)
+	}
+}
+
+// recordGalleryChange appends a change of a profile, or the removal of a folder, to the
+// top-level log, like recordFolderChange.
+func recordGalleryChange(ctx context.Context, change FolderChange) {
+	if change.Actor == "" {
+		change.Actor = actorFrom(ctx)
+	}
+	if _, err := Client.Collection(ChangesCollection).Doc(newID()).Create(ctx, change); err != nil {
+		log.Printf("Error recording %s change: %v", change.Type, err)
+	}
+}
+
+// ListFolderChanges returns up to limit changes of a folder after the cursor since, oldest first.
+// Without since it returns the latest limit changes, as the start of an activity feed; clients
+// keeping a cache then pass the returned cursor to learn what to invalidate. A folder hidden from
+// the viewer of ctx (see IsFolderVisible) is ErrNotFound, and changes of files withheld from it,
+// such as uploads awaiting approval, are left out; the page may then come out short.
+func ListFolderChanges(ctx context.Context, folderID, since string, limit int) (*FolderChanges, error) {
+	if limit <= 0 {
+		limit = DefaultChangesPageSize
+	}
+	limit = min(limit, MaxChangesPageSize)
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil || !IsFolderVisible(ctx, folder) {
+		return nil, notFound("folder %s", folderID)
+	}
+
+	changes := Client.Collection(FoldersCollection).Doc(folderID).Collection(ChangesCollection)
+	var query firestore.Query
+	if since == "" {
+		query = changes.OrderBy("at", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc).Limit(limit + 1)
+	} else {
+		at, id, err := parseChangeCursor(since)
+		if err != nil {
+			return nil, err
+		}
+		query = changes.OrderBy("at", firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc).StartAfter(at, id).Limit(limit + 1)
+	}
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changes of folder %s: %v", folderID, err)
+	}
+
+	page := &FolderChanges{Changes: []FolderChange{}, Cursor: since, HasMore: len(docs) > limit}
+	if page.HasMore {
+		docs = docs[:limit]
+	}
+	for _, doc := range docs {
+		var change FolderChange
+		if err := doc.DataTo(&change); err != nil {
+			log.Printf("Error unmarshaling change %s of folder %s: %v", doc.Ref.ID, folderID, err)
+			continue
+		}
+		change.ID = doc.Ref.ID
+		change.FolderID = folderID
+		change.Cursor = changeCursor(change)
+		page.Changes = append(page.Changes, change)
+	}
+	if since == "" {
+		// Read newest first to get the latest; the page is oldest first like any other, and older
+		// changes are not "more" to a client following the log
+		for i, j := 0, len(page.Changes)-1; i < j; i, j = i+1, j-1 {
+			page.Changes[i], page.Changes[j] = page.Changes[j], page.Changes[i]
+		}
+		page.HasMore = false
+	}
+	if n := len(page.Changes); n > 0 {
+		page.Cursor = page.Changes[n-1].Cursor
+	}
+	if page.Changes, err = withoutWithheldChanges(ctx, page.Changes); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// withoutWithheldChanges drops the changes of files withheld from the viewer of ctx (see
+// fileWithheld). Changes of deleted files are kept, as their removal is what they record.
+func withoutWithheldChanges(ctx context.Context, changes []FolderChange) ([]FolderChange, error) {
+	withheld, err := fileWithheld(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var refs []*firestore.DocumentRef
+	seen := make(map[string]bool)
+	for _, change := range changes {
+		if change.FileID != "" && !seen[change.FileID] {
+			seen[change.FileID] = true
+			refs = append(refs, Client.Collection(FilesCollection).Doc(change.FileID))
+		}
+	}
+	if len(refs) == 0 {
+		return changes, nil
+	}
+	docs, err := Client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files of changes: %v", err)
+	}
+	hidden := make(map[string]bool)
+	for _, doc := range docs {
+		var file FileMetadata
+		if !doc.Exists() || doc.DataTo(&file) != nil {
+			continue
+		}
+		if withheld(file) {
+			hidden[doc.Ref.ID] = true
+		}
+	}
+	return slices.DeleteFunc(changes, func(c FolderChange) bool { return hidden[c.FileID] }), nil
+}
+
+// deleteFolderChanges deletes the change log of a deleted folder, which Firestore keeps otherwise.
+func deleteFolderChanges(ctx context.Context, folderID string) error {
+	refs, err := Client.Collection(FoldersCollection).Doc(folderID).Collection(ChangesCollection).DocumentRefs(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to list changes of folder %s: %v", folderID, err)
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	writer := Client.BulkWriter(ctx)
+	for _, ref := range refs {
+		if _, err := writer.Delete(ref); err != nil {
+			writer.End()
+			return fmt.Errorf("failed to delete changes of folder %s: %v", folderID, err)
+		}
+	}
+	writer.End()
+	return nil
+}
@@ -0,0 +1,71 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// crc32cTable is the Castagnoli table Cloud Storage uses for object CRC32C checksums.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ExpectedChecksums are checksums the client computed before uploading. Empty fields are not checked.
+type ExpectedChecksums struct {
+	SHA256 string // Hex
+	CRC32C string // Base64 of the big-endian value, as in the x-goog-hash header and "gsutil hash"
+}
+
+// ChecksumMismatchError reports that uploaded content does not match the checksum the client
+// supplied or the one Storage computed for the stored object. The object is not kept.
+type ChecksumMismatchError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch: expected %s, got %s", e.Algorithm, e.Expected, e.Actual)
+}
+
+// encodeCRC32C formats a CRC32C value the way Cloud Storage does.
+func encodeCRC32C(crc uint32) string {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, crc)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// verify compares the checksums of the received content with the expected ones.
+func (e ExpectedChecksums) verify(sha256Hex string, crc uint32) error {
+	if e.SHA256 != "" && !strings.EqualFold(e.SHA256, sha256Hex) {
+		return &ChecksumMismatchError{Algorithm: "SHA-256", Expected: strings.ToLower(e.SHA256), Actual: sha256Hex}
+	}
+	if e.CRC32C != "" && e.CRC32C != encodeCRC32C(crc) {
+		return &ChecksumMismatchError{Algorithm: "CRC32C", Expected: e.CRC32C, Actual: encodeCRC32C(crc)}
+	}
+	return nil
+}
+
+// verifyObjectSHA256 reads a stored object and compares its SHA-256 with the expected hex value.
+func verifyObjectSHA256(ctx context.Context, obj *gcs.ObjectHandle, expected string) error {
+	reader, err := obj.NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open storage object %s: %v", obj.ObjectName(), err)
+	}
+	defer reader.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return fmt.Errorf("failed to read storage object %s: %v", obj.ObjectName(), err)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); !strings.EqualFold(expected, actual) {
+		return &ChecksumMismatchError{Algorithm: "SHA-256", Expected: strings.ToLower(expected), Actual: actual}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Clock provides the current time. Timestamps the backend stores or compares, used for ordering,
+// pagination cursors and expiry, come from it so tests can control them. Only the rate limiters
+// (write_limiter.go, drive_limiter.go) call time.Now(), as they pace real calls with real timers,
+// which a FixedClock would stall.
+type Clock interface {
+	Now() time.Time
+}
+
+// IDGenerator provides new Firestore document IDs.
+type IDGenerator interface {
+	NewID() string
+}
+
+var (
+	// DefaultClock is the clock used by the backend package.
+	DefaultClock Clock = systemClock{}
+	// DefaultIDGenerator is the ID generator used by the backend package.
+	DefaultIDGenerator IDGenerator = uuidGenerator{}
+)
+
+// now returns the current time according to DefaultClock.
+func now() time.Time {
+	return DefaultClock.Now()
+}
+
+// newID returns a new document ID according to DefaultIDGenerator.
+func newID() string {
+	return DefaultIDGenerator.NewID()
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+type uuidGenerator struct{}
+
+func (uuidGenerator) NewID() string { return uuid.New().String() }
+
+// FixedClock is a Clock that returns a settable time, for deterministic tests and tooling.
+type FixedClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+// NewFixedClock returns a FixedClock set to t.
+func NewFixedClock(t time.Time) *FixedClock {
+	return &FixedClock{t: t}
+}
+
+// Now returns the clock's current time.
+func (c *FixedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Advance moves the clock forward by d.
+func (c *FixedClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// SequentialIDGenerator returns predictable IDs of the form "{prefix}-000001".
+type SequentialIDGenerator struct {
+	mu     sync.Mutex
+	Prefix string
+	n      int
+}
+
+// NewID returns the next ID in the sequence.
+func (g *SequentialIDGenerator) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.n++
+	return fmt.Sprintf("%s-%06d", g.Prefix, g.n)
+}
@@ -0,0 +1,79 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"cloud.google.com/go/firestore"
+)
+
+// maxArrayContainsAny is the most values Firestore accepts in an array-contains-any filter.
+const maxArrayContainsAny = 30
+
+// CountFolderFiles returns how many files of a folder the viewer of ctx can list, with
+// aggregation queries instead of reading the files, e.g. for the badge of a folder: files pending
+// approval are not counted, nor, for public viewers, files showing a hidden profile. mediaType
+// "image" or "video" counts only those; "" counts all. A missing folder, or one hidden from the
+// viewer (see IsFolderVisible), is ErrNotFound.
+func CountFolderFiles(ctx context.Context, folderID, mediaType string) (int64, error) {
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return 0, err
+	}
+	if folder == nil || !IsFolderVisible(ctx, folder) {
+		return 0, notFound("folder %s", folderID)
+	}
+	query := Client.Collection(FilesCollection).Where("folderId", "==", folderID)
+	if mediaType != "" {
+		query = query.Where("mediaType", "==", mediaType) // Denormalized from mimeType, see BackfillMediaType
+	}
+	count, err := countVisibleFiles(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count files of folder %s: %v", folderID, err)
+	}
+
+	hidden, err := hiddenPeopleFor(ctx)
+	if err != nil || len(hidden) == 0 {
+		return count, err
+	}
+	ids := make([]string, 0, len(hidden))
+	for id := range hidden {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	// A file showing hidden profiles of several chunks is subtracted once per chunk, so with more
+	// than maxArrayContainsAny hidden profiles the count may be slightly low, never too high.
+	for chunk := range slices.Chunk(ids, maxArrayContainsAny) {
+		showing, err := countVisibleFiles(ctx, query.Where("people", "array-contains-any", chunk))
+		if err != nil {
+			return 0, fmt.Errorf("failed to count files of folder %s showing hidden profiles: %v", folderID, err)
+		}
+		count -= showing
+	}
+	return max(count, 0), nil
+}
+
+// countVisibleFiles counts the files matching query that are not pending approval. Since the
+// pendingApproval field is only stored when true, they are counted and subtracted.
+func countVisibleFiles(ctx context.Context, query firestore.Query) (int64, error) {
+	total, err := countDocuments(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	pending, err := countDocuments(ctx, query.Where("pendingApproval", "==", true))
+	if err != nil {
+		return 0, err
+	}
+	return total - pending, nil
+}
+
+// FolderExists reports whether a folder exists and is visible to the viewer of ctx (see
+// IsFolderVisible), with a single document read, e.g. to validate a deep link before listing it.
+func FolderExists(ctx context.Context, folderID string) (bool, error) {
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return false, err
+	}
+	return folder != nil && IsFolderVisible(ctx, folder), nil
+}
@@ -0,0 +1,100 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Licenses a file can be published under (FileMetadata.License).
+const (
+	LicenseCCBY              = "CC-BY"
+	LicenseCCBYSA            = "CC-BY-SA"
+	LicenseCCBYNC            = "CC-BY-NC"
+	LicenseCC0               = "CC0"
+	LicenseAllRightsReserved = "all-rights-reserved"
+)
+
+// Licenses are the valid values of FileMetadata.License.
+var Licenses = []string{LicenseCCBY, LicenseCCBYSA, LicenseCCBYNC, LicenseCC0, LicenseAllRightsReserved}
+
+// licenseURLs are the deeds of the Creative Commons licenses, listed in credits.
+var licenseURLs = map[string]string{
+	LicenseCCBY:   "https://creativecommons.org/licenses/by/4.0/",
+	LicenseCCBYSA: "https://creativecommons.org/licenses/by-sa/4.0/",
+	LicenseCCBYNC: "https://creativecommons.org/licenses/by-nc/4.0/",
+	LicenseCC0:    "https://creativecommons.org/publicdomain/zero/1.0/",
+}
+
+// MaxPhotographerNameLength bounds FileMetadata.PhotographerName, in bytes.
+const MaxPhotographerNameLength = 200
+
+// Credit returns the credit line of a file, e.g. "Taro Yamada (CC-BY)", or "" if it has neither
+// a photographer nor a license.
+func (f *FileMetadata) Credit() string {
+	switch {
+	case f.PhotographerName != "" && f.License != "":
+		return fmt.Sprintf("%s (%s)", f.PhotographerName, f.License)
+	case f.PhotographerName != "":
+		return f.PhotographerName
+	default:
+		return f.License
+	}
+}
+
+// BuildFolderCredits returns the credits.txt of a folder: the photographer and license of each
+// of its files that has them, by relative path, followed by the deeds of the licenses used. Files
+// are those a viewer of ctx can list, like BuildSlideshow. A missing folder is ErrNotFound.
+func BuildFolderCredits(ctx context.Context, folderID string) ([]byte, error) {
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil || !IsFolderVisible(ctx, folder) {
+		return nil, notFound("folder %s", folderID)
+	}
+	files, err := ListAllFilesInFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	hidden, err := hiddenPeopleFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files = withoutHiddenPeople(files, hidden)
+	sort.Slice(files, func(i, j int) bool { return files[i].RelativePath < files[j].RelativePath })
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Credits: %s\nGenerated %s\n\n", folder.Name, now().Format(time.RFC3339))
+	used := make(map[string]bool)
+	credited := 0
+	for _, f := range files {
+		credit := f.Credit()
+		if f.PendingApproval || credit == "" {
+			continue
+		}
+		path := f.RelativePath
+		if path == "" {
+			path = f.Name
+		}
+		fmt.Fprintf(&buf, "%s: %s\n", path, credit)
+		used[f.License] = true
+		credited++
+	}
+	if credited == 0 {
+		buf.WriteString("No file has a photographer or license.\n")
+	}
+	var deeds bytes.Buffer
+	for _, license := range Licenses {
+		if url := licenseURLs[license]; used[license] && url != "" {
+			fmt.Fprintf(&deeds, "%s: %s\n", license, url)
+		}
+	}
+	if deeds.Len() > 0 {
+		buf.WriteString("\nLicenses:\n")
+		buf.Write(deeds.Bytes())
+	}
+	return buf.Bytes(), nil
+}
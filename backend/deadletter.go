@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// DeadLettersCollection holds notifications whose processing failed, so they can be replayed.
+const DeadLettersCollection = "deadLetters"
+
+// DeadLetter is a Drive notification that could not be processed.
+type DeadLetter struct {
+	ID            string    `json:"id" firestore:"id"`
+	FileID        string    `json:"fileId" firestore:"fileId"`
+	ResourceState string    `json:"resourceState" firestore:"resourceState"`
+	ChannelID     string    `json:"channelId" firestore:"channelId"`
+	MessageNumber string    `json:"messageNumber" firestore:"messageNumber"`
+	Error         string    `json:"error" firestore:"error"` // Error of the last attempt
+	Attempts      int       `json:"attempts" firestore:"attempts"`
+	CreatedAt     time.Time `json:"createdAt" firestore:"createdAt"`
+	LastAttemptAt time.Time `json:"lastAttemptAt" firestore:"lastAttemptAt"`
+}
+
+// ReplayResult reports the outcome of ReplayDeadLetters.
+type ReplayResult struct {
+	Replayed []string          `json:"replayed"` // IDs of dead letters processed and removed
+	Failed   map[string]string `json:"failed"`   // Error messages keyed by ID; these stay in the collection
+}
+
+// RecordDeadLetter stores a notification that failed with processErr.
+func RecordDeadLetter(ctx context.Context, change DriveChange, channelID, messageNumber string, processErr error) error {
+	letter := DeadLetter{
+		ID:            newID(),
+		FileID:        change.FileID,
+		ResourceState: change.ResourceState,
+		ChannelID:     channelID,
+		MessageNumber: messageNumber,
+		Error:         processErr.Error(),
+		Attempts:      1,
+		CreatedAt:     now(),
+		LastAttemptAt: now(),
+	}
+	if _, err := Client.Collection(DeadLettersCollection).Doc(letter.ID).Set(ctx, letter); err != nil {
+		return fmt.Errorf("failed to record dead letter: %v", err)
+	}
+	log.Printf("Recorded dead letter %s for Drive file %s (%s): %v", letter.ID, change.FileID, change.ResourceState, processErr)
+	return nil
+}
+
+// ListDeadLetters returns the recorded dead letters, oldest first.
+func ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	docs, err := Client.Collection(DeadLettersCollection).OrderBy("createdAt", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %v", err)
+	}
+	letters := make([]DeadLetter, 0, len(docs))
+	for _, doc := range docs {
+		var letter DeadLetter
+		if err := doc.DataTo(&letter); err != nil {
+			log.Printf("Error unmarshaling dead letter %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetters processes every dead letter again, in the order they were recorded.
+// Successful ones are deleted; failed ones keep their document with the new error and attempt count.
+// The Drive metadata of all changed files is fetched up front in batches, rather than one
+// request per letter.
+func ReplayDeadLetters(ctx context.Context) (*ReplayResult, error) {
+	letters, err := ListDeadLetters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var fileIDs []string
+	for _, letter := range letters {
+		if eventType := driveEventType(letter.ResourceState); eventType != "" && eventType != EventDriveFileRemoved {
+			fileIDs = append(fileIDs, letter.FileID)
+		}
+	}
+	files, lookupErrs := GetDriveFiles(ctx, fileIDs)
+
+	result := &ReplayResult{Replayed: []string{}, Failed: map[string]string{}}
+	for _, letter := range letters {
+		ref := Client.Collection(DeadLettersCollection).Doc(letter.ID)
+		err, lookupFailed := lookupErrs[letter.FileID]
+		if !lookupFailed || driveEventType(letter.ResourceState) == EventDriveFileRemoved {
+			err = ProcessDriveChange(ctx, DriveChange{FileID: letter.FileID, ResourceState: letter.ResourceState, File: files[letter.FileID]})
+		}
+		if err != nil {
+			result.Failed[letter.ID] = err.Error()
+			if _, uerr := ref.Update(ctx, []firestore.Update{
+				{Path: "error", Value: err.Error()},
+				{Path: "attempts", Value: firestore.Increment(1)},
+				{Path: "lastAttemptAt", Value: now()},
+			}); uerr != nil {
+				log.Printf("Error updating dead letter %s: %v", letter.ID, uerr)
+			}
+			continue
+		}
+		if _, err := ref.Delete(ctx); err != nil {
+			// Replaying it again only broadcasts a duplicate event
+			log.Printf("Error deleting replayed dead letter %s: %v", letter.ID, err)
+		}
+		result.Replayed = append(result.Replayed, letter.ID)
+	}
+	return result, nil
+}
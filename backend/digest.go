@@ -0,0 +1,92 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// EventFilesUploaded is broadcast instead of individual file_uploaded events when several files
+// are uploaded to a folder within the upload digest window, and so sent to the outgoing webhooks
+// subscribed to it, e.g. for chat or push messages. Data: UploadDigest.
+const EventFilesUploaded = "files_uploaded"
+
+// maxDigestFiles bounds the files listed in an UploadDigest; Count is always the full number.
+const maxDigestFiles = 50
+
+// UploadDigest summarizes the files uploaded to a folder within one digest window.
+type UploadDigest struct {
+	FolderID   string         `json:"folderId"`
+	FolderName string         `json:"folderName"`
+	Count      int            `json:"count"`
+	Message    string         `json:"message"` // e.g. "12 files added to 第1回"
+	Files      []FileMetadata `json:"files"`   // The first maxDigestFiles files
+	Since      time.Time      `json:"since"`   // Upload time of the first file
+}
+
+var (
+	digestMu       sync.Mutex
+	digestWindow   time.Duration
+	pendingDigests = make(map[string]*UploadDigest) // By folder ID
+)
+
+// SetUploadDigestWindow sets how long file_uploaded events of a folder are collected before they
+// are sent. Within a window a single upload is still sent as file_uploaded, several as one
+// files_uploaded digest. Zero, the default, sends every event immediately.
+func SetUploadDigestWindow(window time.Duration) {
+	digestMu.Lock()
+	defer digestMu.Unlock()
+	digestWindow = window
+}
+
+// notifyFileUploaded broadcasts a file_uploaded event, or adds the file to its folder's pending
+// digest when a digest window is set.
+func notifyFileUploaded(file FileMetadata) {
+	digestMu.Lock()
+	if digestWindow <= 0 {
+		digestMu.Unlock()
+		BroadcastEvent(EventFileUploaded, file)
+		return
+	}
+	defer digestMu.Unlock()
+	digest, ok := pendingDigests[file.FolderID]
+	if !ok {
+		digest = &UploadDigest{FolderID: file.FolderID, Since: file.CreatedAt}
+		pendingDigests[file.FolderID] = digest
+		time.AfterFunc(digestWindow, func() { flushDigest(file.FolderID) })
+	}
+	digest.Count++
+	if len(digest.Files) < maxDigestFiles {
+		digest.Files = append(digest.Files, file)
+	}
+}
+
+// flushDigest sends the pending digest of a folder.
+func flushDigest(folderID string) {
+	digestMu.Lock()
+	digest := pendingDigests[folderID]
+	delete(pendingDigests, folderID)
+	digestMu.Unlock()
+	if digest == nil {
+		return
+	}
+	if digest.Count == 1 {
+		BroadcastEvent(EventFileUploaded, digest.Files[0])
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	digest.FolderName = digest.FolderID
+	if name, err := GetFolderNameFromFirestore(ctx, folderID, ""); err != nil {
+		log.Printf("Warning: Could not get name of folder %s for upload digest: %v", folderID, err)
+	} else {
+		digest.FolderName = name
+	}
+	digest.Message = fmt.Sprintf("%d files added to %s", digest.Count, digest.FolderName)
+	log.Printf("Upload digest: %s", digest.Message)
+
+	BroadcastEvent(EventFilesUploaded, *digest)
+}
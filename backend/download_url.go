@@ -0,0 +1,165 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	gcs "cloud.google.com/go/storage"
+)
+
+// SignedDownloadTTL is how long a signed download URL stays valid. V4 signed URLs cannot
+// be valid for more than 7 days, so signed DownloadURLs have to be regenerated periodically.
+const SignedDownloadTTL = 7 * 24 * time.Hour
+
+// downloadURLMode returns how DownloadURL is derived, from the DOWNLOAD_URL_MODE environment
+// variable: "public" (default) uses the object's MediaLink, "signed" a V4 signed GET URL for
+// buckets that are not publicly readable.
+func downloadURLMode() string {
+	if os.Getenv("DOWNLOAD_URL_MODE") == "signed" {
+		return "signed"
+	}
+	return "public"
+}
+
+// deriveDownloadURL returns the DownloadURL of an object according to the current mode.
+func deriveDownloadURL(bucket *gcs.BucketHandle, attrs *gcs.ObjectAttrs) (string, error) {
+	if downloadURLMode() != "signed" {
+		return attrs.MediaLink, nil // MediaLink is the public download URL
+	}
+	url, err := bucket.SignedURL(attrs.Name, &gcs.SignedURLOptions{
+		Scheme:  gcs.SigningSchemeV4,
+		Method:  "GET",
+		Expires: now().Add(SignedDownloadTTL),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign download URL for %s: %v", attrs.Name, err)
+	}
+	return url, nil
+}
+
+// RegenerateResult summarizes a download URL regeneration.
+type RegenerateResult struct {
+	Mode    string            `json:"mode"`
+	Scanned int               `json:"scanned"`
+	Updated int               `json:"updated"`
+	Failed  map[string]string `json:"failed"` // Error message by file ID
+}
+
+// RegenerateDownloadURLs re-derives the DownloadURL of files from their Storage objects and
+// saves those that changed, e.g. after objects were moved, ACLs changed or signed URLs expired.
+// With ids given only those files are processed, with folderID only that folder's files, and
+// otherwise every file in the collection.
+func RegenerateDownloadURLs(ctx context.Context, ids []string, folderID string) (*RegenerateResult, error) {
+	result := &RegenerateResult{Mode: downloadURLMode(), Failed: make(map[string]string)}
+	privateFolders := make(map[string]bool) // Loaded on first use
+	isPrivate := func(folderID string) bool {
+		private, ok := privateFolders[folderID]
+		if !ok {
+			folder, err := GetFolder(ctx, folderID)
+			private = err != nil || (folder != nil && folder.IsPrivate()) // Never publish when unsure
+			privateFolders[folderID] = private
+		}
+		return private
+	}
+
+	regenerate := func(files []FileMetadata) {
+		var updates []FileFieldUpdate
+		for _, file := range files {
+			result.Scanned++
+			bucket, err := fileBucket(&file)
+			if err != nil {
+				result.Failed[file.ID] = err.Error()
+				continue
+			}
+			if result.Mode == "public" && !isPrivate(file.FolderID) {
+				// Restore public read access in case the object's ACL was changed
+				if err := bucket.Object(file.StoragePath).ACL().Set(ctx, gcs.AllUsers, gcs.RoleReader); err != nil {
+					log.Printf("Warning: Could not set public ACL for file %s: %v", file.StoragePath, err)
+				}
+			}
+			attrs, err := bucket.Object(file.StoragePath).Attrs(ctx)
+			if err != nil {
+				result.Failed[file.ID] = fmt.Sprintf("failed to get storage object attributes for %s: %v", file.StoragePath, err)
+				continue
+			}
+			url, err := deriveDownloadURL(bucket, attrs)
+			if err != nil {
+				result.Failed[file.ID] = err.Error()
+				continue
+			}
+			if url != file.DownloadURL {
+				updates = append(updates, FileFieldUpdate{ID: file.ID, Fields: map[string]interface{}{"downloadUrl": url}})
+			}
+		}
+		if len(updates) == 0 {
+			return
+		}
+		failed := BulkUpdateFiles(ctx, updates)
+		for id, msg := range failed {
+			result.Failed[id] = msg
+		}
+		result.Updated += len(updates) - len(failed)
+	}
+
+	switch {
+	case len(ids) > 0:
+		const batchSize = 100
+		for start := 0; start < len(ids); start += batchSize {
+			end := start + batchSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			refs := make([]*firestore.DocumentRef, 0, end-start)
+			for _, id := range ids[start:end] {
+				refs = append(refs, Client.Collection(FilesCollection).Doc(id))
+			}
+			docs, err := Client.GetAll(ctx, refs)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get files: %v", err)
+			}
+			var files []FileMetadata
+			for _, doc := range docs {
+				if !doc.Exists() {
+					result.Failed[doc.Ref.ID] = "file not found"
+					continue
+				}
+				var file FileMetadata
+				if err := doc.DataTo(&file); err != nil {
+					result.Failed[doc.Ref.ID] = fmt.Sprintf("failed to unmarshal file metadata: %v", err)
+					continue
+				}
+				files = append(files, file)
+			}
+			regenerate(files)
+		}
+
+	default:
+		query := Client.Collection(FilesCollection).Query
+		if folderID != "" {
+			query = query.Where("folderId", "==", folderID)
+		}
+		err := pagedQuery(ctx, query.OrderBy(firestore.DocumentID, firestore.Asc), 0, 0, func(docs []*firestore.DocumentSnapshot) error {
+			var files []FileMetadata
+			for _, doc := range docs {
+				var file FileMetadata
+				if err := doc.DataTo(&file); err != nil {
+					result.Failed[doc.Ref.ID] = fmt.Sprintf("failed to unmarshal file metadata: %v", err)
+					continue
+				}
+				files = append(files, file)
+			}
+			regenerate(files)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Download URLs regenerated (%s): %d scanned, %d updated, %d failed.", result.Mode, result.Scanned, result.Updated, len(result.Failed))
+	return result, nil
+}
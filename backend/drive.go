@@ -0,0 +1,235 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+var (
+	// DriveService is the global Google Drive API client. It is nil until InitDrive succeeds.
+	DriveService *drive.Service
+	// RootFolderID is the Drive folder uploads go to when no folder is given, from DRIVE_ROOT_FOLDER_ID.
+	RootFolderID string
+)
+
+// driveFileFields are the Drive file fields loaded into DriveFile.
+const driveFileFields = "id, name, mimeType, thumbnailLink, webViewLink, parents, trashed, size, createdTime, " +
+	"imageMediaMetadata(width, height, time), videoMediaMetadata(width, height, durationMillis)"
+
+// driveFolderMimeType is the MIME type Drive uses for folders.
+const driveFolderMimeType = "application/vnd.google-apps.folder"
+
+// driveListPageSize is the number of files requested per Drive list call (the API maximum).
+const driveListPageSize = 1000
+
+// DriveFile is the Drive metadata of a file or folder.
+type DriveFile struct {
+	ID            string              `json:"id"`
+	Name          string              `json:"name"`
+	MimeType      string              `json:"mimeType"`
+	ThumbnailLink string              `json:"thumbnailLink,omitempty"` // Short-lived
+	ThumbnailURL  string              `json:"thumbnailUrl,omitempty"`  // Backend proxy of ThumbnailLink that does not expire
+	WebViewLink   string              `json:"webViewLink,omitempty"`
+	Parents       []string            `json:"parents,omitempty"` // IDs of the containing Drive folders
+	Trashed       bool                `json:"trashed"`
+	Size          int64               `json:"size,omitempty"` // Bytes; 0 for folders and Google Docs
+	CreatedTime   time.Time           `json:"createdTime"`
+	Image         *DriveImageMetadata `json:"image,omitempty"`
+	Video         *DriveVideoMetadata `json:"video,omitempty"`
+}
+
+// DriveImageMetadata is what Drive extracts from an image.
+type DriveImageMetadata struct {
+	Width  int64  `json:"width"`
+	Height int64  `json:"height"`
+	Time   string `json:"time,omitempty"` // EXIF date taken, "2006:01:02 15:04:05" without a time zone
+}
+
+// DriveVideoMetadata is what Drive extracts from a video.
+type DriveVideoMetadata struct {
+	Width          int64 `json:"width"`
+	Height         int64 `json:"height"`
+	DurationMillis int64 `json:"durationMillis"`
+}
+
+// newDriveFile converts a Drive API file loaded with driveFileFields.
+func newDriveFile(f *drive.File) DriveFile {
+	file := DriveFile{
+		ID:            f.Id,
+		Name:          f.Name,
+		MimeType:      f.MimeType,
+		ThumbnailLink: f.ThumbnailLink,
+		WebViewLink:   f.WebViewLink,
+		Parents:       f.Parents,
+		Trashed:       f.Trashed,
+		Size:          f.Size,
+	}
+	if f.ThumbnailLink != "" {
+		file.ThumbnailURL = DriveThumbnailURL(f.Id)
+		rememberDriveThumbnailLink(f.Id, f.ThumbnailLink)
+	}
+	if t, err := time.Parse(time.RFC3339, f.CreatedTime); err == nil {
+		file.CreatedTime = t
+	}
+	if m := f.ImageMediaMetadata; m != nil {
+		file.Image = &DriveImageMetadata{Width: m.Width, Height: m.Height, Time: m.Time}
+	}
+	if m := f.VideoMediaMetadata; m != nil {
+		file.Video = &DriveVideoMetadata{Width: m.Width, Height: m.Height, DurationMillis: m.DurationMillis}
+	}
+	return file
+}
+
+// DriveUpload is a file created in Drive by UploadFileToDrive.
+type DriveUpload struct {
+	ID          string `json:"id"`
+	WebViewLink string `json:"webViewLink"`
+}
+
+// InitDrive initializes the Drive API client with the same credentials as InitFirebase.
+// If serviceAccountJSONPath is empty, it uses Application Default Credentials. DRIVE_API_ENDPOINT
+// (e.g. "http://localhost:9010") sends every call to a stand-in for the Drive API instead.
+func InitDrive(ctx context.Context, serviceAccountJSONPath string) error {
+	RootFolderID = os.Getenv("DRIVE_ROOT_FOLDER_ID")
+	// Full access: watched files are read and uploads may go to folders shared with the service account
+	opts := []option.ClientOption{option.WithScopes(drive.DriveScope)}
+	if endpoint := os.Getenv("DRIVE_API_ENDPOINT"); endpoint != "" {
+		endpoint = strings.TrimRight(endpoint, "/") + "/"
+		opts = append(opts, option.WithEndpoint(endpoint+"drive/v3/"), option.WithoutAuthentication())
+		driveBatchURL = endpoint + "batch/drive/v3"
+		log.Printf("Drive API endpoint: %s", endpoint)
+	} else if serviceAccountJSONPath != "" {
+		opts = append(opts, option.WithCredentialsFile(serviceAccountJSONPath))
+	}
+	srv, err := drive.NewService(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("error creating Drive client: %v", err)
+	}
+	client, _, err := htransport.NewClient(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("error creating Drive HTTP client: %v", err)
+	}
+	DriveService = srv
+	driveHTTPClient = client
+	log.Println("Google Drive client initialized successfully.")
+	return nil
+}
+
+// GetDriveFile returns the Drive metadata of a file.
+func GetDriveFile(ctx context.Context, fileID string) (*DriveFile, error) {
+	if DriveService == nil {
+		return nil, fmt.Errorf("Drive client not initialized")
+	}
+	var f *drive.File
+	err := callDrive(ctx, 1, func() error {
+		var err error
+		f, err = DriveService.Files.Get(fileID).Fields(driveFileFields).SupportsAllDrives(true).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Drive file %s: %v", fileID, err)
+	}
+	file := newDriveFile(f)
+	return &file, nil
+}
+
+// ListFoldersInRootFolder returns the folders directly inside RootFolderID.
+func ListFoldersInRootFolder(ctx context.Context) ([]DriveFile, error) {
+	if RootFolderID == "" {
+		return nil, fmt.Errorf("DRIVE_ROOT_FOLDER_ID is not set")
+	}
+	return listDriveFiles(ctx, fmt.Sprintf("'%s' in parents and mimeType = '%s' and trashed = false", escapeDriveQuery(RootFolderID), driveFolderMimeType))
+}
+
+// ListFilesInFolder returns the files directly inside a Drive folder, without subfolders.
+func ListFilesInFolder(ctx context.Context, folderID string) ([]DriveFile, error) {
+	return listDriveFiles(ctx, fmt.Sprintf("'%s' in parents and mimeType != '%s' and trashed = false", escapeDriveQuery(folderID), driveFolderMimeType))
+}
+
+// listDriveFiles returns every file matching a Drive search query, following all result pages.
+func listDriveFiles(ctx context.Context, query string) ([]DriveFile, error) {
+	if DriveService == nil {
+		return nil, fmt.Errorf("Drive client not initialized")
+	}
+	var files []DriveFile
+	pageToken := ""
+	for {
+		// Each page is a call of its own, so a rate limited page is retried without restarting the listing
+		var page *drive.FileList
+		err := callDrive(ctx, 1, func() error {
+			var err error
+			page, err = DriveService.Files.List().
+				Q(query).
+				Fields("nextPageToken, files(" + driveFileFields + ")").
+				OrderBy("name").
+				PageSize(driveListPageSize).
+				PageToken(pageToken).
+				SupportsAllDrives(true).
+				IncludeItemsFromAllDrives(true).
+				Context(ctx).
+				Do()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Drive files: %v", err)
+		}
+		for _, f := range page.Files {
+			files = append(files, newDriveFile(f))
+		}
+		if page.NextPageToken == "" {
+			return files, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// escapeDriveQuery escapes a value for use inside single quotes in a Drive search query.
+func escapeDriveQuery(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
+
+// UploadFileToDrive streams content into a new file in the Drive folder folderID, or RootFolderID
+// if folderID is empty. Content is sent in chunks with a resumable upload, so it is never held in
+// memory as a whole and a failed chunk is retried rather than the whole file. An empty mimeType
+// lets Drive detect it.
+func UploadFileToDrive(ctx context.Context, folderID, name, mimeType string, content io.Reader) (*DriveUpload, error) {
+	if DriveService == nil {
+		return nil, fmt.Errorf("Drive client not initialized")
+	}
+	if folderID == "" {
+		folderID = RootFolderID
+	}
+	if folderID == "" {
+		return nil, fmt.Errorf("no Drive folder given and DRIVE_ROOT_FOLDER_ID is not set")
+	}
+
+	// The content can only be read once, so the upload waits for the limiter but is not retried
+	if err := waitForDrive(ctx, 1); err != nil {
+		return nil, err
+	}
+	mediaOpts := []googleapi.MediaOption{googleapi.ChunkSize(googleapi.DefaultUploadChunkSize)}
+	if mimeType != "" {
+		mediaOpts = append(mediaOpts, googleapi.ContentType(mimeType))
+	}
+	f, err := DriveService.Files.Create(&drive.File{Name: name, MimeType: mimeType, Parents: []string{folderID}}).
+		Media(content, mediaOpts...).
+		Fields("id, webViewLink").
+		SupportsAllDrives(true).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload %s to Drive folder %s: %v", name, folderID, err)
+	}
+	log.Printf("Uploaded %s to Drive folder %s as %s", name, folderID, f.Id)
+	return &DriveUpload{ID: f.Id, WebViewLink: f.WebViewLink}, nil
+}
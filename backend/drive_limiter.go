@@ -0,0 +1,293 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Drive API calls share one limiter, so webhook bursts, dead letter replays and Drive listings
+// stay within the project's quota (12,000 queries per minute per project and per user by
+// default) instead of getting the project temporarily blocked. Calls rejected for exceeding a
+// rate limit are retried with exponential backoff.
+const (
+	defaultDriveRequestsPerSecond = 10
+	driveBurst                    = 20
+	driveMaxRetries               = 5
+	driveInitialBackoff           = time.Second
+	driveMaxBackoff               = 32 * time.Second
+	// driveBatchSize is the most calls the Drive batch endpoint accepts in one request.
+	driveBatchSize = 100
+)
+
+// driveBatchURL is the batch endpoint of the Drive API v3; InitDrive moves it along with
+// DRIVE_API_ENDPOINT.
+var driveBatchURL = "https://www.googleapis.com/batch/drive/v3"
+
+var driveLimiter = rate.NewLimiter(rate.Limit(driveRequestsPerSecond()), driveBurst)
+
+// driveRequestsPerSecond returns DRIVE_REQUESTS_PER_SECOND, or the default if it is unset or invalid.
+func driveRequestsPerSecond() float64 {
+	value := os.Getenv("DRIVE_REQUESTS_PER_SECOND")
+	if value == "" {
+		return defaultDriveRequestsPerSecond
+	}
+	qps, err := strconv.ParseFloat(value, 64)
+	if err != nil || qps <= 0 {
+		log.Printf("WARNING: Invalid DRIVE_REQUESTS_PER_SECOND %q, using %d", value, defaultDriveRequestsPerSecond)
+		return defaultDriveRequestsPerSecond
+	}
+	return qps
+}
+
+// DriveQuotaStats counts the Drive API calls made through the limiter.
+type DriveQuotaStats struct {
+	Calls       int64   `json:"calls"`       // API calls, counting each call of a batch
+	Delayed     int64   `json:"delayed"`     // Calls that waited for the limiter
+	WaitSeconds float64 `json:"waitSeconds"` // Total time spent waiting for the limiter
+	RateLimited int64   `json:"rateLimited"` // Calls Drive rejected with 403 rateLimitExceeded or 429
+	Retries     int64   `json:"retries"`
+}
+
+var driveStats = struct {
+	sync.Mutex
+	DriveQuotaStats
+}{}
+
+// DriveStats returns the counters of the Drive API limiter.
+func DriveStats() DriveQuotaStats {
+	driveStats.Lock()
+	defer driveStats.Unlock()
+	return driveStats.DriveQuotaStats
+}
+
+// waitForDrive blocks until the limiter admits n calls.
+func waitForDrive(ctx context.Context, n int) error {
+	started := time.Now()
+	for i := 0; i < n; i++ {
+		if err := driveLimiter.Wait(ctx); err != nil {
+			return fmt.Errorf("waiting for the Drive API rate limit: %v", err)
+		}
+	}
+	waited := time.Since(started)
+	driveStats.Lock()
+	defer driveStats.Unlock()
+	driveStats.Calls += int64(n)
+	if waited > time.Millisecond {
+		driveStats.Delayed += int64(n)
+		driveStats.WaitSeconds += waited.Seconds()
+	}
+	return nil
+}
+
+// isDriveRateLimit reports whether Drive rejected a call for exceeding a rate limit, which
+// succeeds when retried later. Daily limits and other 403s are not retried.
+func isDriveRateLimit(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	if apiErr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
+
+// isDriveRetryable reports whether a failed call should be retried: rate limits and server errors.
+func isDriveRetryable(err error) bool {
+	var apiErr *googleapi.Error
+	return isDriveRateLimit(err) || (errors.As(err, &apiErr) && apiErr.Code >= 500)
+}
+
+// callDrive runs fn, a Drive API call counting as cost calls, within the limiter, retrying rate
+// limit and server errors with exponential backoff and jitter. fn must be safe to repeat.
+func callDrive(ctx context.Context, cost int, fn func() error) error {
+	backoff := driveInitialBackoff
+	for attempt := 0; ; attempt++ {
+		if err := waitForDrive(ctx, cost); err != nil {
+			return err
+		}
+		err := fn()
+		if err == nil || !isDriveRetryable(err) || attempt == driveMaxRetries {
+			return err
+		}
+		driveStats.Lock()
+		if isDriveRateLimit(err) {
+			driveStats.RateLimited++
+		}
+		driveStats.Retries++
+		driveStats.Unlock()
+
+		sleep := backoff + time.Duration(rand.Int63n(int64(backoff)))
+		log.Printf("Drive API call failed, retrying in %s (%d/%d): %v", sleep.Round(time.Millisecond), attempt+1, driveMaxRetries, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(sleep):
+		}
+		backoff = min(backoff*2, driveMaxBackoff)
+	}
+}
+
+// GetDriveFiles returns the Drive metadata of several files, keyed by ID, fetched with the Drive
+// batch endpoint up to driveBatchSize at a time, so a burst of changes costs one HTTP request per
+// batch. Files that could not be loaded are reported in the error map; missing files as
+// ErrNotFound.
+func GetDriveFiles(ctx context.Context, ids []string) (map[string]*DriveFile, map[string]error) {
+	files := make(map[string]*DriveFile, len(ids))
+	failed := make(map[string]error)
+	seen := make(map[string]bool, len(ids))
+	var unique []string
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	for start := 0; start < len(unique); start += driveBatchSize {
+		batch := unique[start:min(start+driveBatchSize, len(unique))]
+		var results map[string]*DriveFile
+		var errs map[string]error
+		err := callDrive(ctx, len(batch), func() error {
+			var err error
+			results, errs, err = getDriveFileBatch(ctx, batch)
+			return err
+		})
+		for _, id := range batch {
+			switch {
+			case err != nil:
+				failed[id] = err
+			case results[id] != nil:
+				files[id] = results[id]
+			case isDriveRetryable(errs[id]):
+				// Rate limited within the batch; fetch it alone with backoff
+				if file, err := GetDriveFile(ctx, id); err != nil {
+					failed[id] = err
+				} else {
+					files[id] = file
+				}
+			default:
+				failed[id] = errs[id]
+			}
+		}
+	}
+	return files, failed
+}
+
+// getDriveFileBatch sends one batch request getting the files ids. The returned error is that of
+// the batch request itself; the error map holds the failures of single files.
+func getDriveFileBatch(ctx context.Context, ids []string) (map[string]*DriveFile, map[string]error, error) {
+	if driveHTTPClient == nil {
+		return nil, nil, fmt.Errorf("Drive client not initialized")
+	}
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	query := url.Values{"fields": {driveFileFields}, "supportsAllDrives": {"true"}}.Encode()
+	for i, id := range ids {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"application/http"},
+			"Content-ID":   {fmt.Sprintf("<item%d>", i)},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		fmt.Fprintf(part, "GET /drive/v3/files/%s?%s\r\n\r\n", url.PathEscape(id), query)
+	}
+	writer.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, driveBatchURL, &body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+	resp, err := driveHTTPClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send Drive batch request: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, nil, err
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, nil, fmt.Errorf("unexpected Drive batch response type %q", resp.Header.Get("Content-Type"))
+	}
+
+	files := make(map[string]*DriveFile, len(ids))
+	errs := make(map[string]error)
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read Drive batch response: %v", err)
+		}
+		// Responses carry the Content-ID of their request as <response-item{i}>
+		contentID := strings.Trim(part.Header.Get("Content-ID"), "<>")
+		i, err := strconv.Atoi(strings.TrimPrefix(contentID, "response-item"))
+		if err != nil || i < 0 || i >= len(ids) {
+			continue
+		}
+		id := ids[i]
+		itemResp, err := http.ReadResponse(bufio.NewReader(part), req)
+		if err != nil {
+			errs[id] = fmt.Errorf("failed to read Drive batch response for %s: %v", id, err)
+			continue
+		}
+		if err := googleapi.CheckResponse(itemResp); err != nil {
+			itemResp.Body.Close()
+			var apiErr *googleapi.Error
+			if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+				errs[id] = notFound("Drive file %s", id)
+			} else {
+				errs[id] = err
+			}
+			continue
+		}
+		var f drive.File
+		err = json.NewDecoder(itemResp.Body).Decode(&f)
+		itemResp.Body.Close()
+		if err != nil {
+			errs[id] = fmt.Errorf("failed to decode Drive file %s: %v", id, err)
+			continue
+		}
+		file := newDriveFile(&f)
+		files[id] = &file
+	}
+	for _, id := range ids {
+		if files[id] == nil && errs[id] == nil {
+			errs[id] = fmt.Errorf("no response for Drive file %s in batch", id)
+		}
+	}
+	return files, errs, nil
+}
@@ -0,0 +1,182 @@
+package backend
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DriveChannelsCollection tracks the message numbers received on each Drive push channel, by
+// channel ID, so replayed notifications are ignored. Entries expire driveChannelTTL after their
+// last message, well after Drive stops using a channel.
+const DriveChannelsCollection = "driveChannels"
+
+const (
+	driveChannelTTL = 30 * 24 * time.Hour
+	// driveReplayWindow is how far below the highest message number of a channel a notification
+	// is still accepted, if its number was not seen yet: Drive numbers messages in order, but
+	// retries and concurrent deliveries can arrive out of order.
+	driveReplayWindow = 64
+	// DefaultDriveWebhookMaxSkew is how far the Date of a notification may be from the server's
+	// clock, unless SetDriveWebhookMaxSkew changes it.
+	DefaultDriveWebhookMaxSkew = 5 * time.Minute
+)
+
+func init() {
+	RegisterTemporaryCollection(DriveChannelsCollection)
+}
+
+var (
+	driveWebhookMu   sync.Mutex
+	driveMaxSkew     = DefaultDriveWebhookMaxSkew
+	driveToken       string
+	driveChannelLock sync.Mutex // Serializes claims on this instance; transactions cover the others
+)
+
+// SetDriveWebhookMaxSkew sets how far the Date header of a Drive notification may be from the
+// server's clock before it is rejected.
+func SetDriveWebhookMaxSkew(skew time.Duration) {
+	driveWebhookMu.Lock()
+	defer driveWebhookMu.Unlock()
+	driveMaxSkew = skew
+}
+
+func currentDriveMaxSkew() time.Duration {
+	driveWebhookMu.Lock()
+	defer driveWebhookMu.Unlock()
+	return driveMaxSkew
+}
+
+// SetDriveWebhookToken sets the token Drive push channels are registered with: notifications
+// must carry it as X-Goog-Channel-Token. Without one every notification is rejected, as anyone
+// could send them.
+func SetDriveWebhookToken(token string) {
+	driveWebhookMu.Lock()
+	defer driveWebhookMu.Unlock()
+	driveToken = token
+}
+
+// DriveWebhookToken returns the token set by SetDriveWebhookToken.
+func DriveWebhookToken() string {
+	driveWebhookMu.Lock()
+	defer driveWebhookMu.Unlock()
+	return driveToken
+}
+
+// driveChannelState is the document of a channel in DriveChannelsCollection.
+type driveChannelState struct {
+	ChannelID   string    `firestore:"channelId"`
+	LastMessage int64     `firestore:"lastMessage"` // Highest message number received
+	Seen        []int64   `firestore:"seen"`        // Numbers received within driveReplayWindow of LastMessage
+	UpdatedAt   time.Time `firestore:"updatedAt"`
+	ExpireAt    time.Time `firestore:"expireAt"`
+}
+
+// DriveReplayError is returned for a Drive notification whose message number was already
+// received on its channel, or is too old to tell.
+type DriveReplayError struct {
+	ChannelID     string
+	MessageNumber int64
+	Stale         bool // Below the replay window rather than a duplicate
+}
+
+func (e *DriveReplayError) Error() string {
+	if e.Stale {
+		return fmt.Sprintf("message %d of channel %s is stale", e.MessageNumber, e.ChannelID)
+	}
+	return fmt.Sprintf("message %d of channel %s was already received", e.MessageNumber, e.ChannelID)
+}
+
+// checkDriveNotification validates the headers of a Drive notification: a channel ID, the
+// channel token set by SetDriveWebhookToken, a numeric message number, a Date within the allowed
+// clock skew if there is one, and a channel that has not expired. Drive does not promise a Date
+// header, so without one the token and the message number, which claimDriveMessage accepts only
+// once, are what guard against forged and replayed notifications. It returns the message number.
+func checkDriveNotification(r *http.Request) (int64, error) {
+	channelID := r.Header.Get("X-Goog-Channel-ID")
+	if channelID == "" {
+		return 0, fmt.Errorf("no X-Goog-Channel-ID")
+	}
+	token := DriveWebhookToken()
+	if token == "" {
+		return 0, fmt.Errorf("no channel token is configured")
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Goog-Channel-Token")), []byte(token)) != 1 {
+		return 0, fmt.Errorf("invalid X-Goog-Channel-Token for channel %s", channelID)
+	}
+	number, err := strconv.ParseInt(r.Header.Get("X-Goog-Message-Number"), 10, 64)
+	if err != nil || number < 1 {
+		return 0, fmt.Errorf("invalid X-Goog-Message-Number %q", r.Header.Get("X-Goog-Message-Number"))
+	}
+	skew := currentDriveMaxSkew()
+	if date := r.Header.Get("Date"); date != "" {
+		sent, err := http.ParseTime(date)
+		if err != nil {
+			return 0, fmt.Errorf("invalid Date %q", date)
+		}
+		if d := now().Sub(sent); d > skew || d < -skew {
+			return 0, fmt.Errorf("Date %s is more than %s off", date, skew)
+		}
+	}
+	if expiration := r.Header.Get("X-Goog-Channel-Expiration"); expiration != "" {
+		if expires, err := http.ParseTime(expiration); err == nil && now().Sub(expires) > skew {
+			return 0, fmt.Errorf("channel %s expired at %s", channelID, expiration)
+		}
+	}
+	return number, nil
+}
+
+// claimDriveMessage records a message number received on a channel. A number already received,
+// or below the replay window, is a DriveReplayError.
+func claimDriveMessage(ctx context.Context, channelID string, number int64) error {
+	driveChannelLock.Lock()
+	defer driveChannelLock.Unlock()
+	ref := Client.Collection(DriveChannelsCollection).Doc(channelID)
+	return Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		state := driveChannelState{ChannelID: channelID}
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := doc.DataTo(&state); err != nil {
+				return err
+			}
+			if number <= state.LastMessage-driveReplayWindow {
+				return &DriveReplayError{ChannelID: channelID, MessageNumber: number, Stale: true}
+			}
+			if slices.Contains(state.Seen, number) {
+				return &DriveReplayError{ChannelID: channelID, MessageNumber: number}
+			}
+		}
+		state.LastMessage = max(state.LastMessage, number)
+		state.Seen = slices.DeleteFunc(append(state.Seen, number), func(n int64) bool {
+			return n <= state.LastMessage-driveReplayWindow
+		})
+		state.UpdatedAt = now()
+		state.ExpireAt = ExpiresAt(driveChannelTTL)
+		return tx.Set(ref, state)
+	})
+}
+
+// releaseDriveMessage forgets a claimed message number whose notification failed, so Drive's
+// retry of it is accepted.
+func releaseDriveMessage(ctx context.Context, channelID string, number int64) {
+	driveChannelLock.Lock()
+	defer driveChannelLock.Unlock()
+	ref := Client.Collection(DriveChannelsCollection).Doc(channelID)
+	_, err := ref.Update(ctx, []firestore.Update{{Path: "seen", Value: firestore.ArrayRemove(number)}})
+	if err != nil {
+		log.Printf("ERROR: Failed to release message %d of channel %s; its retry will be ignored: %v", number, channelID, err)
+	}
+}
@@ -0,0 +1,188 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Drive's thumbnailLink URLs expire within hours, so listings of Drive folders point at the
+// /api/drive/thumbnail proxy instead. The proxy keeps each file's link until it is
+// driveThumbnailLinkTTL old or Drive rejects it, then asks the Drive API for a fresh one, and
+// keeps fetched thumbnails for driveThumbnailBytesTTL.
+const (
+	driveThumbnailLinkTTL  = time.Hour
+	driveThumbnailBytesTTL = 10 * time.Minute
+	// driveThumbnailCacheBytes bounds the memory held by cached thumbnails.
+	driveThumbnailCacheBytes = 32 << 20
+	// maxDriveThumbnailBytes bounds a single thumbnail read from Drive.
+	maxDriveThumbnailBytes = 4 << 20
+	// maxDriveThumbnailLinks is the number of links kept before stale ones are dropped.
+	maxDriveThumbnailLinks = 10000
+)
+
+// driveHTTPClient sends authorized requests for thumbnailLink URLs, which need the same
+// credentials as the Drive API for files that are not public. It is set by InitDrive.
+var driveHTTPClient *http.Client
+
+// driveThumbnailSize matches the size suffix of a thumbnailLink, e.g. "=s220".
+var driveThumbnailSize = regexp.MustCompile(`=s\d+$`)
+
+type driveThumbnailLink struct {
+	url     string
+	fetched time.Time
+}
+
+type driveThumbnail struct {
+	content     []byte
+	contentType string
+	fetched     time.Time
+}
+
+var driveThumbnails = struct {
+	sync.Mutex
+	links map[string]driveThumbnailLink
+	bytes map[string]driveThumbnail // Keyed by file ID and size
+	size  int                       // Sum of the cached thumbnails' lengths
+}{links: make(map[string]driveThumbnailLink), bytes: make(map[string]driveThumbnail)}
+
+// DriveThumbnailURL returns the path of the thumbnail proxy for a Drive file.
+func DriveThumbnailURL(fileID string) string {
+	return "/api/drive/thumbnail/" + url.PathEscape(fileID)
+}
+
+// rememberDriveThumbnailLink keeps a thumbnailLink seen in a listing, so the proxy does not need
+// to look it up again.
+func rememberDriveThumbnailLink(fileID, link string) {
+	if link == "" {
+		return
+	}
+	driveThumbnails.Lock()
+	defer driveThumbnails.Unlock()
+	if len(driveThumbnails.links) >= maxDriveThumbnailLinks {
+		for id, l := range driveThumbnails.links {
+			if now().Sub(l.fetched) >= driveThumbnailLinkTTL {
+				delete(driveThumbnails.links, id)
+			}
+		}
+	}
+	driveThumbnails.links[fileID] = driveThumbnailLink{url: link, fetched: now()}
+}
+
+// driveThumbnailLinkFor returns the thumbnailLink of a file, from the cache unless it is stale or
+// refresh is set.
+func driveThumbnailLinkFor(ctx context.Context, fileID string, refresh bool) (string, error) {
+	driveThumbnails.Lock()
+	link, ok := driveThumbnails.links[fileID]
+	driveThumbnails.Unlock()
+	if ok && !refresh && now().Sub(link.fetched) < driveThumbnailLinkTTL {
+		return link.url, nil
+	}
+	file, err := GetDriveFile(ctx, fileID)
+	if err != nil {
+		return "", err
+	}
+	if file.ThumbnailLink == "" {
+		return "", notFound("thumbnail of Drive file %s", fileID)
+	}
+	rememberDriveThumbnailLink(fileID, file.ThumbnailLink)
+	return file.ThumbnailLink, nil
+}
+
+// DriveThumbnail returns the thumbnail of a Drive file and its content type, size pixels on the
+// longest side (0 for Drive's default), refreshing an expired thumbnailLink through the Drive API.
+func DriveThumbnail(ctx context.Context, fileID string, size int) ([]byte, string, error) {
+	if driveHTTPClient == nil {
+		return nil, "", fmt.Errorf("Drive client not initialized")
+	}
+	key := fmt.Sprintf("%s@%d", fileID, size)
+	driveThumbnails.Lock()
+	cached, ok := driveThumbnails.bytes[key]
+	driveThumbnails.Unlock()
+	if ok && now().Sub(cached.fetched) < driveThumbnailBytesTTL {
+		return cached.content, cached.contentType, nil
+	}
+
+	link, err := driveThumbnailLinkFor(ctx, fileID, false)
+	if err != nil {
+		return nil, "", err
+	}
+	content, contentType, status, err := fetchDriveThumbnail(ctx, link, size)
+	if err == nil && (status == http.StatusForbidden || status == http.StatusNotFound) {
+		// The link has expired; a fresh one from the Drive API is valid again
+		if link, err = driveThumbnailLinkFor(ctx, fileID, true); err != nil {
+			return nil, "", err
+		}
+		content, contentType, status, err = fetchDriveThumbnail(ctx, link, size)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if status != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to fetch thumbnail of Drive file %s: status %d", fileID, status)
+	}
+	cacheDriveThumbnail(key, driveThumbnail{content: content, contentType: contentType, fetched: now()})
+	return content, contentType, nil
+}
+
+// fetchDriveThumbnail downloads a thumbnailLink, resized to size if it is not 0. Only transport
+// errors are returned as errors, so callers can react to the status.
+func fetchDriveThumbnail(ctx context.Context, link string, size int) ([]byte, string, int, error) {
+	if size > 0 && driveThumbnailSize.MatchString(link) {
+		link = driveThumbnailSize.ReplaceAllString(link, fmt.Sprintf("=s%d", size))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("invalid thumbnailLink: %v", err)
+	}
+	if err := waitForDrive(ctx, 1); err != nil {
+		return nil, "", 0, err
+	}
+	resp, err := driveHTTPClient.Do(req)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("failed to fetch Drive thumbnail: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", resp.StatusCode, nil
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxDriveThumbnailBytes))
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("failed to read Drive thumbnail: %v", err)
+	}
+	return content, resp.Header.Get("Content-Type"), resp.StatusCode, nil
+}
+
+// cacheDriveThumbnail keeps a thumbnail, first dropping expired ones and then, while the cache
+// would exceed driveThumbnailCacheBytes, the oldest.
+func cacheDriveThumbnail(key string, thumb driveThumbnail) {
+	driveThumbnails.Lock()
+	defer driveThumbnails.Unlock()
+	if old, ok := driveThumbnails.bytes[key]; ok {
+		driveThumbnails.size -= len(old.content)
+		delete(driveThumbnails.bytes, key)
+	}
+	for k, t := range driveThumbnails.bytes {
+		if now().Sub(t.fetched) >= driveThumbnailBytesTTL {
+			driveThumbnails.size -= len(t.content)
+			delete(driveThumbnails.bytes, k)
+		}
+	}
+	for driveThumbnails.size+len(thumb.content) > driveThumbnailCacheBytes && len(driveThumbnails.bytes) > 0 {
+		oldest := ""
+		for k, t := range driveThumbnails.bytes {
+			if oldest == "" || t.fetched.Before(driveThumbnails.bytes[oldest].fetched) {
+				oldest = k
+			}
+		}
+		driveThumbnails.size -= len(driveThumbnails.bytes[oldest].content)
+		delete(driveThumbnails.bytes, oldest)
+	}
+	driveThumbnails.bytes[key] = thumb
+	driveThumbnails.size += len(thumb.content)
+}
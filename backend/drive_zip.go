@@ -0,0 +1,359 @@
+package backend
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// JobImportDriveZip is the Job type of ImportDriveZip. Its items are the entries of the zip, and
+// its Result holds the "folderName" and the counts of "imported", "deduplicated" and "skipped"
+// entries.
+const JobImportDriveZip = "import_drive_zip"
+
+// Default limits of ImportDriveZip, in MiB, unless SetDriveZipLimits changes them. The zip is
+// spooled to os.TempDir, which is memory on Cloud Run unless a volume is mounted there, while
+// entries are streamed into Storage.
+const (
+	DefaultDriveZipMaxMiB      = 1024 // The zip in Drive
+	DefaultDriveZipMaxEntryMiB = 512  // Uncompressed bytes of an entry
+)
+
+var (
+	driveZipMu           sync.Mutex
+	driveZipMaxSize      int64 = DefaultDriveZipMaxMiB << 20
+	driveZipMaxEntrySize int64 = DefaultDriveZipMaxEntryMiB << 20
+)
+
+// SetDriveZipLimits sets the largest zip ImportDriveZip downloads and the largest entry it
+// imports, in bytes. Values that are not positive keep the current limit.
+func SetDriveZipLimits(maxSize, maxEntrySize int64) {
+	driveZipMu.Lock()
+	defer driveZipMu.Unlock()
+	if maxSize > 0 {
+		driveZipMaxSize = maxSize
+	}
+	if maxEntrySize > 0 {
+		driveZipMaxEntrySize = maxEntrySize
+	}
+}
+
+func driveZipLimits() (maxSize, maxEntrySize int64) {
+	driveZipMu.Lock()
+	defer driveZipMu.Unlock()
+	return driveZipMaxSize, driveZipMaxEntrySize
+}
+
+// driveZipImportTimeout bounds a zip import running in the background.
+const driveZipImportTimeout = 2 * time.Hour
+
+// zipMimeTypes are the MIME types Drive gives zip files.
+var zipMimeTypes = []string{"application/zip", "application/x-zip-compressed", "application/x-zip"}
+
+// InvalidDriveZipError is returned for importing a Drive file that is not a zip ImportDriveZip
+// accepts.
+type InvalidDriveZipError struct {
+	FileID string
+	Reason string
+}
+
+func (e *InvalidDriveZipError) Error() string {
+	return fmt.Sprintf("Drive file %s cannot be imported: %s", e.FileID, e.Reason)
+}
+
+// ImportDriveZip imports the files of a zip stored in Drive, e.g. an archive a photographer
+// shared by link, into the folder folderName, created if needed. The zip is downloaded and
+// unpacked in the background, and each entry goes through the upload pipeline like a file
+// uploaded to /api/upload/file, with the entry's path in the zip as relative path; the progress
+// is tracked by the returned Job. Missing Drive files are ErrNotFound, files that are not zips
+// or too large an InvalidDriveZipError, and an archived folder an ArchivedFolderError.
+func ImportDriveZip(ctx context.Context, driveFileID, folderName string, source SourceInfo) (*Job, error) {
+	if DriveService == nil {
+		return nil, fmt.Errorf("Drive client not initialized")
+	}
+	var f *drive.File
+	err := callDrive(ctx, 1, func() error {
+		var err error
+		f, err = DriveService.Files.Get(driveFileID).Fields("id, name, mimeType, size").SupportsAllDrives(true).Context(ctx).Do()
+		return err
+	})
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return nil, notFound("Drive file %s", driveFileID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Drive file %s: %v", driveFileID, err)
+	}
+	if !isZipFile(f.Name, f.MimeType) {
+		return nil, &InvalidDriveZipError{FileID: driveFileID, Reason: fmt.Sprintf("%s (%s) is not a zip", f.Name, f.MimeType)}
+	}
+	if maxSize, _ := driveZipLimits(); f.Size > maxSize {
+		return nil, &InvalidDriveZipError{FileID: driveFileID, Reason: fmt.Sprintf("%d bytes is more than %d", f.Size, maxSize)}
+	}
+	// Resolved now, so an archived folder is reported rather than failing every entry
+	if _, err := resolveFolderID(ctx, folderName); err != nil {
+		return nil, err
+	}
+
+	job, err := startJob(ctx, JobImportDriveZip, map[string]string{"driveFileId": driveFileID, "folderName": folderName})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), driveZipImportTimeout)
+		defer cancel()
+		job.finish(ctx, importDriveZip(ctx, job, driveFileID, folderName, source))
+	}()
+	return job, nil
+}
+
+// isZipFile reports whether a Drive file is a zip, by MIME type or, since Drive often stores
+// them as application/octet-stream, by extension.
+func isZipFile(name, mimeType string) bool {
+	for _, t := range zipMimeTypes {
+		if mimeType == t {
+			return true
+		}
+	}
+	return strings.EqualFold(path.Ext(name), ".zip")
+}
+
+// importDriveZip downloads the zip to a temporary file, which archive/zip needs to seek in, and
+// uploads its entries, recording them in job.
+func importDriveZip(ctx context.Context, job *Job, driveFileID, folderName string, source SourceInfo) error {
+	maxSize, maxEntrySize := driveZipLimits()
+	tmp, err := os.CreateTemp("", "drive-zip-*.zip")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var resp *http.Response
+	err = callDrive(ctx, 1, func() error {
+		var err error
+		resp, err = DriveService.Files.Get(driveFileID).SupportsAllDrives(true).Context(ctx).Download()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download Drive file %s: %v", driveFileID, err)
+	}
+	size, err := io.Copy(tmp, io.LimitReader(resp.Body, maxSize+1))
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to download Drive file %s: %v", driveFileID, err)
+	}
+	if size > maxSize {
+		return fmt.Errorf("Drive file %s is larger than %d bytes", driveFileID, maxSize)
+	}
+
+	archive, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return fmt.Errorf("failed to open zip: %v", err)
+	}
+	var entries []*zip.File
+	skipped := 0
+	for _, entry := range archive.File {
+		if isImportableZipEntry(entry) {
+			entries = append(entries, entry)
+		} else if !entry.FileInfo().IsDir() {
+			skipped++
+		}
+	}
+	job.setTotal(ctx, len(entries))
+	imported, deduplicated := 0, 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		dedup, err := importZipEntry(ctx, entry, folderName, source, maxEntrySize)
+		if err == nil && dedup {
+			deduplicated++
+		} else if err == nil {
+			imported++
+		}
+		var archivedErr *ArchivedFolderError
+		if errors.As(err, &archivedErr) {
+			return err // Archived meanwhile; the other entries would fail alike
+		}
+		job.itemDone(ctx, entry.Name, err)
+	}
+	job.setResult("imported", strconv.Itoa(imported))
+	job.setResult("deduplicated", strconv.Itoa(deduplicated))
+	job.setResult("skipped", strconv.Itoa(skipped))
+	log.Printf("Imported Drive zip %s into '%s': %d files, %d already stored, %d skipped.", driveFileID, folderName, imported, deduplicated, skipped)
+	return nil
+}
+
+// isImportableZipEntry reports whether a zip entry is a file to import: not a directory, not
+// hidden (.DS_Store, macOS __MACOSX resource forks) and with a path inside the folder.
+func isImportableZipEntry(entry *zip.File) bool {
+	if entry.FileInfo().IsDir() {
+		return false
+	}
+	name := path.Clean(strings.ReplaceAll(entry.Name, `\`, "/"))
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return false
+		}
+	}
+	return true
+}
+
+// importZipEntry uploads one entry of a zip through the upload pipeline and reports whether its
+// content was already stored. The entry is never held in memory: it is read once to hash it and
+// detect its type, and once more to stream it into Storage, where it is checked like a direct
+// upload (see FinalizeDirectUploads).
+func importZipEntry(ctx context.Context, entry *zip.File, folderName string, source SourceInfo, maxEntrySize int64) (bool, error) {
+	if entry.UncompressedSize64 > uint64(maxEntrySize) {
+		return false, fmt.Errorf("%d bytes is more than %d", entry.UncompressedSize64, maxEntrySize)
+	}
+	sum, err := scanZipEntry(entry, maxEntrySize)
+	if err != nil {
+		return false, err
+	}
+	existingFile, err := FindFileByHash(ctx, sum.hash)
+	if err != nil {
+		return false, err
+	}
+	if existingFile != nil {
+		log.Printf("File with hash %s already exists: %s. Skipping zip entry %s.", sum.hash, existingFile.DownloadURL, entry.Name)
+		return true, nil
+	}
+
+	relativePath := path.Clean(strings.ReplaceAll(entry.Name, `\`, "/"))
+	mimeType := mime.TypeByExtension(path.Ext(relativePath))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(sum.head)
+	}
+	// reconcileUploadMimeType only sees uploads in memory, so the type is checked here
+	check := checkMimeType(relativePath, mimeType, sum.head)
+	if check.Corrected != "" {
+		mimeType = check.Corrected
+	}
+	folderID, err := resolveFolderID(ctx, folderName)
+	if err != nil {
+		return false, err
+	}
+	bucketName, bucket, err := uploadBucket(ctx, folderID, mimeType)
+	if err != nil {
+		return false, err
+	}
+	source.ModifiedAt = entry.Modified
+	storagePath, pathStrategy := storagePathFor(StoragePathInput{FolderID: folderID, FolderName: folderName, RelativePath: relativePath, Hash: sum.hash, CapturedAt: source.ModifiedAt})
+	if err := checkStoragePathFree(ctx, bucketName, storagePath); err != nil {
+		return false, err
+	}
+	if err := storeZipEntry(ctx, entry, bucket.Object(storagePath), mimeType, sum.crc, maxEntrySize); err != nil {
+		return false, err
+	}
+
+	u := &Upload{
+		FolderID:     folderID,
+		FolderName:   folderName,
+		RelativePath: relativePath,
+		MimeType:     mimeType,
+		MimeMismatch: check.Mismatch,
+		Hash:         sum.hash,
+		Source:       source,
+		Bucket:       bucket,
+		BucketName:   bucketName,
+		StoragePath:  storagePath,
+		PathStrategy: pathStrategy,
+	}
+	if err := runUploadChecks(ctx, u); err != nil {
+		if delErr := bucket.Object(storagePath).Delete(ctx); delErr != nil {
+			log.Printf("Warning: Could not delete rejected object %s: %v", storagePath, delErr)
+		}
+		return false, err
+	}
+	_, err = publishStoredObject(ctx, u)
+	return false, err
+}
+
+// zipEntrySum is what the first read of a zip entry learns about its content.
+type zipEntrySum struct {
+	hash string // SHA-256, hex encoded like CalculateFileHash
+	crc  uint32 // CRC32C, to verify the object written to Storage
+	head []byte // The first mimeSniffLength bytes
+}
+
+// scanZipEntry reads an entry to hash it and keep its head. The declared size may lie, so the
+// read is bounded as well.
+func scanZipEntry(entry *zip.File, maxEntrySize int64) (*zipEntrySum, error) {
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open entry: %v", err)
+	}
+	defer rc.Close()
+	sha, crc := sha256.New(), crc32.New(crc32cTable)
+	head := &headBuffer{limit: mimeSniffLength}
+	n, err := io.Copy(io.MultiWriter(sha, crc, head), io.LimitReader(rc, maxEntrySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read entry: %v", err)
+	}
+	if n > maxEntrySize {
+		return nil, fmt.Errorf("entry is larger than %d bytes", maxEntrySize)
+	}
+	return &zipEntrySum{hash: hex.EncodeToString(sha.Sum(nil)), crc: crc.Sum32(), head: head.data}, nil
+}
+
+// storeZipEntry streams an entry into obj and checks that Storage received the content that was
+// hashed, deleting the object otherwise.
+func storeZipEntry(ctx context.Context, entry *zip.File, obj *gcs.ObjectHandle, mimeType string, crc uint32, maxEntrySize int64) error {
+	rc, err := entry.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open entry: %v", err)
+	}
+	defer rc.Close()
+	wc := obj.NewWriter(ctx)
+	wc.ContentType = mimeType
+	if _, err := io.Copy(wc, io.LimitReader(rc, maxEntrySize)); err != nil {
+		wc.Close()
+		return fmt.Errorf("failed to write file to storage: %v", err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("failed to close storage writer: %v", err)
+	}
+	if stored := wc.Attrs().CRC32C; stored != crc {
+		if err := obj.Delete(ctx); err != nil {
+			log.Printf("ERROR: Failed to delete corrupted object %s: %v", obj.ObjectName(), err)
+		}
+		return &ChecksumMismatchError{Algorithm: "CRC32C", Expected: encodeCRC32C(crc), Actual: encodeCRC32C(stored)}
+	}
+	return nil
+}
+
+// headBuffer keeps the first limit bytes written to it and discards the rest.
+type headBuffer struct {
+	limit int
+	data  []byte
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if n := b.limit - len(b.data); n > 0 {
+		b.data = append(b.data, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
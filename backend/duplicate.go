@@ -0,0 +1,183 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// JobDuplicateFolder is the Job type of DuplicateFolder. Its Result holds the new "folderId".
+const JobDuplicateFolder = "duplicate_folder"
+
+// duplicateConcurrency is the number of files DuplicateFolder copies at once.
+const duplicateConcurrency = 8
+
+// duplicateTimeout bounds a folder duplication running in the background.
+const duplicateTimeout = time.Hour
+
+// FolderExistsError is returned when a folder is to be created under a name already in use.
+type FolderExistsError struct {
+	Name string
+}
+
+func (e *FolderExistsError) Error() string {
+	return fmt.Sprintf("folder '%s' already exists", e.Name)
+}
+
+func (e *FolderExistsError) Is(target error) bool { return target == ErrConflict }
+
+// DuplicateFolder creates the folder newName with copies of all files of folderID, e.g. to
+// build a "best of" folder before pruning it. Storage objects are copied server-side and the
+// file metadata cloned with new IDs; the copy keeps the source's visibility and bucket but not
+// its slug or localized names. The new folder is created right away and the files are copied in
+// the background; their progress is tracked by the returned Job.
+func DuplicateFolder(ctx context.Context, folderID, newName string) (*FolderMetadata, *Job, error) {
+	source, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if source == nil {
+		return nil, nil, notFound("folder %s", folderID)
+	}
+	existing, err := FindFolderByName(ctx, newName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if existing != nil {
+		return nil, nil, &FolderExistsError{Name: newName}
+	}
+
+	folder := FolderMetadata{
+		ID:         newID(),
+		Name:       newName,
+		CreatedAt:  now(),
+		Visibility: source.Visibility,
+		Bucket:     source.Bucket,
+	}
+	if folder.Slug, err = uniqueFolderSlug(ctx, Slugify(newName), folder.ID); err != nil {
+		return nil, nil, err
+	}
+	if _, err := Client.Collection(FoldersCollection).Doc(folder.ID).Set(ctx, folder); err != nil {
+		return nil, nil, fmt.Errorf("failed to create folder '%s': %v", newName, err)
+	}
+	log.Printf("Created folder '%s' (%s) as a copy of %s", newName, folder.ID, folderID)
+	recordFolderChange(ctx, folder.ID, FolderChange{Type: ChangeAdded, Name: newName})
+	BroadcastEvent(EventFolderCreated, folder)
+
+	job, err := startJob(ctx, JobDuplicateFolder, map[string]string{"sourceFolderId": folderID, "folderId": folder.ID})
+	if err != nil {
+		return nil, nil, err
+	}
+	actor := actorFrom(ctx)
+	go func() {
+		// The copies are recorded as added by whoever started the duplication
+		ctx, cancel := context.WithTimeout(WithActor(context.Background(), actor), duplicateTimeout)
+		defer cancel()
+		job.finish(ctx, copyFolderFiles(ctx, job, folderID, &folder))
+	}()
+	return &folder, job, nil
+}
+
+// copyFolderFiles copies the files of sourceID into folder, recording each file in job.
+func copyFolderFiles(ctx context.Context, job *Job, sourceID string, folder *FolderMetadata) error {
+	files, err := ListAllFilesInFolder(ctx, sourceID)
+	if err != nil {
+		return err
+	}
+	job.setTotal(ctx, len(files))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, duplicateConcurrency)
+	for _, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(file FileMetadata) {
+			defer func() { <-sem; wg.Done() }()
+			err := copyFile(ctx, file, sourceID, folder)
+			if err != nil {
+				log.Printf("Error copying file %s to folder %s: %v", file.ID, folder.ID, err)
+			}
+			job.itemDone(ctx, file.ID, err)
+		}(file)
+	}
+	wg.Wait()
+	log.Printf("Copied %d files of folder %s to %s.", len(files), sourceID, folder.ID)
+	return ctx.Err()
+}
+
+// copyFile copies a file's Storage object into folder, in the bucket the folder stores new
+// uploads in, and saves its metadata under a new ID. Under a content-named storage path strategy
+// within one bucket the copy shares the object, so only metadata is written.
+func copyFile(ctx context.Context, file FileMetadata, sourceID string, folder *FolderMetadata) error {
+	relativePath := file.RelativePath
+	if relativePath == "" {
+		relativePath = strings.TrimPrefix(file.StoragePath, sourceID+"/")
+	}
+	if relativePath == file.StoragePath {
+		relativePath = file.Name
+	}
+	storagePath, pathStrategy := storagePathFor(StoragePathInput{FolderID: folder.ID, FolderName: folder.Name, RelativePath: relativePath, Hash: file.Hash, CapturedAt: file.CapturedAt})
+	src, err := fileBucket(&file)
+	if err != nil {
+		return err
+	}
+	bucketName := bucketFor(folder, file.MimeType)
+	bucket, err := bucketHandle(bucketName)
+	if err != nil {
+		return err
+	}
+	dst := bucket.Object(storagePath)
+	shared := storagePath == file.StoragePath && bucketName == file.Bucket
+	var attrs *gcs.ObjectAttrs
+	if shared {
+		attrs, err = dst.Attrs(ctx)
+	} else {
+		attrs, err = dst.CopierFrom(src.Object(file.StoragePath)).Run(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %v", file.StoragePath, err)
+	}
+	// deleteCopy removes the copied object when the copy fails; a shared object is left alone
+	deleteCopy := func() {
+		if shared {
+			return
+		}
+		if delErr := dst.Delete(ctx); delErr != nil {
+			log.Printf("ERROR: Failed to delete orphaned storage object %s: %v", storagePath, delErr)
+		}
+	}
+	if downloadURLMode() == "public" && !folder.IsPrivate() {
+		if err := dst.ACL().Set(ctx, gcs.AllUsers, gcs.RoleReader); err != nil {
+			log.Printf("Warning: Could not set public ACL for file %s: %v", storagePath, err)
+		}
+	}
+	if file.DownloadURL, err = deriveDownloadURL(bucket, attrs); err != nil {
+		deleteCopy()
+		return err
+	}
+
+	file.ID = newID()
+	file.FolderID = folder.ID
+	file.StoragePath = storagePath
+	file.PathStrategy = pathStrategy
+	file.RelativePath = relativePath
+	file.Bucket = bucketName
+	ref := Client.Collection(FilesCollection).Doc(file.ID)
+	if err = waitForWrite(ctx, ref); err == nil {
+		_, err = ref.Set(ctx, file)
+	}
+	if err != nil {
+		deleteCopy()
+		return fmt.Errorf("failed to save file metadata: %v", err)
+	}
+	recordFolderChange(ctx, folder.ID, FolderChange{Type: ChangeAdded, FileID: file.ID, Name: file.Name})
+	return nil
+}
@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"context"
+)
+
+// EmbedItem is an image shown in the embeddable gallery widget.
+type EmbedItem struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	Thumbnail string `json:"thumbnail,omitempty"` // Path on the backend
+	Credit    string `json:"credit,omitempty"`    // Photographer and license (see FileMetadata.Credit)
+}
+
+// EmbedGallery is the payload of the embeddable gallery widget: a folder and its latest images.
+type EmbedGallery struct {
+	FolderID string      `json:"folderId"`
+	Name     string      `json:"name"`
+	Slug     string      `json:"slug,omitempty"`
+	Items    []EmbedItem `json:"items"`
+}
+
+// BuildEmbedGallery returns the limit most recently uploaded images of a folder, with the folder
+// name localized to lang. A missing folder is ErrNotFound.
+func BuildEmbedGallery(ctx context.Context, folderID, lang string, limit int) (*EmbedGallery, error) {
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil || !IsFolderVisible(ctx, folder) {
+		return nil, notFound("folder %s", folderID)
+	}
+	files, _, err := ListFilesFromFirestore(ctx, folderID, int64(limit), "", "image", "")
+	if err != nil {
+		return nil, err
+	}
+	if err := AttachAccessURLs(folder, files); err != nil {
+		return nil, err
+	}
+
+	gallery := &EmbedGallery{FolderID: folder.ID, Name: folder.LocalizedName(lang), Slug: folder.Slug, Items: make([]EmbedItem, 0, len(files))}
+	for _, f := range files {
+		gallery.Items = append(gallery.Items, EmbedItem{ID: f.ID, Name: f.Name, URL: f.DownloadURL, Thumbnail: f.ThumbnailURL, Credit: f.Credit()})
+	}
+	return gallery, nil
+}
@@ -0,0 +1,59 @@
+package backend
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoggedError is an error line written to the standard logger.
+type LoggedError struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// errorLog is an io.Writer for the standard logger that keeps the most recent lines
+// mentioning an error, so they can be shown on the admin dashboard.
+type errorLog struct {
+	mu      sync.Mutex
+	entries []LoggedError // Ring buffer, oldest entry at next once full
+	next    int
+	size    int
+}
+
+// RecentErrors collects the last 50 error lines. Install it with
+// log.SetOutput(io.MultiWriter(os.Stderr, backend.RecentErrors)).
+var RecentErrors = &errorLog{size: 50}
+
+// Write records every line of p that contains "error" (case-insensitive). It never fails.
+func (l *errorLog) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if !strings.Contains(strings.ToLower(line), "error") {
+			continue
+		}
+		l.add(LoggedError{Time: now(), Message: line})
+	}
+	return len(p), nil
+}
+
+func (l *errorLog) add(entry LoggedError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) < l.size {
+		l.entries = append(l.entries, entry)
+		return
+	}
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % l.size
+}
+
+// List returns the recorded errors, newest first.
+func (l *errorLog) List() []LoggedError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := make([]LoggedError, 0, len(l.entries))
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		list = append(list, l.entries[(l.next+i)%len(l.entries)])
+	}
+	return list
+}
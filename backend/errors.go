@@ -0,0 +1,19 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors that handlers map to HTTP statuses. Functions wrap them with what was not
+// found or what conflicted, so check with errors.Is. Lookups that callers use to test for
+// existence (GetFile, GetFolder and the Find functions) return nil instead of ErrNotFound.
+var (
+	ErrNotFound = errors.New("not found") // The document does not exist (404)
+	ErrConflict = errors.New("conflict")  // The change conflicts with the current state (409)
+)
+
+// notFound returns an error matching ErrNotFound, e.g. notFound("folder %s", id).
+func notFound(format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), ErrNotFound)
+}
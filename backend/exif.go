@@ -0,0 +1,153 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"strings"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// EXIFInfo is what is read from the EXIF header of a JPEG image.
+type EXIFInfo struct {
+	Make        string // Camera manufacturer, e.g. "Canon"
+	Model       string // Camera model, e.g. "Canon EOS R6"
+	Orientation int    // 1-8 as defined by EXIF, 0 if absent; 1 is upright
+}
+
+// exifHeaderSize is how much of an object is read for its EXIF header, which JPEG writers put
+// in the APP1 segment right after the start of the image.
+const exifHeaderSize = 128 << 10
+
+func init() {
+	RegisterUploadHook(StageStore, readUploadedEXIF)
+}
+
+// EXIF tags of IFD0 read by ReadEXIF.
+const (
+	exifTagMake        = 0x010f
+	exifTagModel       = 0x0110
+	exifTagOrientation = 0x0112
+)
+
+// ReadEXIF returns the EXIF header of a JPEG image from its first bytes, or nil for other
+// formats and images without one. Only IFD0 is read, so no maker notes or thumbnails.
+func ReadEXIF(data []byte) *EXIFInfo {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xff {
+			return nil
+		}
+		marker := data[pos+1]
+		if marker == 0xd8 || (marker >= 0xd0 && marker <= 0xd7) || marker == 0x01 || marker == 0xff {
+			pos++ // Markers without a length, and fill bytes
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			return nil // Image data starts; EXIF comes before it
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+		if segment := data[pos+4 : end]; marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return readTIFF(segment[6:])
+		}
+		pos = end
+	}
+	return nil
+}
+
+// readTIFF reads the tags ReadEXIF returns from the first IFD of a TIFF structure.
+func readTIFF(tiff []byte) *EXIFInfo {
+	if len(tiff) < 8 {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return nil
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil
+	}
+	info := &EXIFInfo{}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		tag, typ, n := order.Uint16(tiff[entry:]), order.Uint16(tiff[entry+2:]), int(order.Uint32(tiff[entry+4:]))
+		value := tiff[entry+8 : entry+12]
+		switch {
+		case typ == 2 && (tag == exifTagMake || tag == exifTagModel): // ASCII
+			if n > 4 {
+				offset := int(order.Uint32(value))
+				if offset < 0 || n > len(tiff) || offset > len(tiff)-n {
+					continue
+				}
+				value = tiff[offset : offset+n]
+			} else {
+				value = value[:n]
+			}
+			s := strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+			if tag == exifTagMake {
+				info.Make = s
+			} else {
+				info.Model = s
+			}
+		case typ == 3 && tag == exifTagOrientation: // SHORT
+			if o := int(order.Uint16(value)); o >= 1 && o <= 8 {
+				info.Orientation = o
+			}
+		}
+	}
+	return info
+}
+
+// storedEXIF returns the EXIF header of an uploaded JPEG image, from content if the server has
+// it or else by reading the start of the object; nil for other files.
+func storedEXIF(ctx context.Context, bucket *gcs.BucketHandle, storagePath, mimeType string, content []byte) *EXIFInfo {
+	if mimeType != "image/jpeg" {
+		return nil
+	}
+	if content == nil {
+		reader, err := bucket.Object(storagePath).NewRangeReader(ctx, 0, exifHeaderSize)
+		if err != nil {
+			log.Printf("Warning: Could not read %s for its EXIF header: %v", storagePath, err)
+			return nil
+		}
+		defer reader.Close()
+		if content, err = io.ReadAll(reader); err != nil {
+			log.Printf("Warning: Could not read %s for its EXIF header: %v", storagePath, err)
+			return nil
+		}
+	}
+	return ReadEXIF(content)
+}
+
+// readUploadedEXIF records the camera model and orientation of JPEG images.
+func readUploadedEXIF(ctx context.Context, u *Upload) error {
+	if info := storedEXIF(ctx, u.Bucket, u.StoragePath, u.MimeType, u.Content); info != nil {
+		u.File.CameraModel = info.Model
+		if info.Orientation > 1 {
+			u.File.Orientation = info.Orientation
+		}
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// StaticSiteWriter stores the files of a static site export.
+type StaticSiteWriter interface {
+	// WriteFile stores content at name, a slash-separated path relative to the site root.
+	WriteFile(ctx context.Context, name, contentType string, content io.Reader) error
+}
+
+// DirSiteWriter writes a static site into a local directory.
+type DirSiteWriter struct {
+	Dir string
+}
+
+// WriteFile implements StaticSiteWriter.
+func (d DirSiteWriter) WriteFile(ctx context.Context, name, contentType string, content io.Reader) error {
+	p := filepath.Join(d.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %v", name, err)
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", p, err)
+	}
+	if _, err := io.Copy(f, content); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %v", p, err)
+	}
+	return f.Close()
+}
+
+// BucketSiteWriter writes a static site into a Cloud Storage bucket under Prefix.
+type BucketSiteWriter struct {
+	Bucket *gcs.BucketHandle
+	Prefix string
+}
+
+// WriteFile implements StaticSiteWriter.
+func (b BucketSiteWriter) WriteFile(ctx context.Context, name, contentType string, content io.Reader) error {
+	objectName := path.Join(b.Prefix, name)
+	wc := b.Bucket.Object(objectName).NewWriter(ctx)
+	wc.ContentType = contentType
+	if _, err := io.Copy(wc, content); err != nil {
+		wc.Close()
+		return fmt.Errorf("failed to write gs object %s: %v", objectName, err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("failed to write gs object %s: %v", objectName, err)
+	}
+	return nil
+}
+
+// StaticExportOptions control ExportStaticSite.
+type StaticExportOptions struct {
+	Lang      string // Language of folder names (see FolderMetadata.LocalizedName); empty for the default names
+	Originals bool   // Copy the original files into the site; otherwise pages link to their download URLs
+}
+
+// StaticExportStats summarizes a static site export.
+type StaticExportStats struct {
+	Folders    int `json:"folders"`
+	Files      int `json:"files"`
+	Thumbnails int `json:"thumbnails"`
+	Originals  int `json:"originals"`
+	Failed     int `json:"failed"` // Files that could not be read or written; they are listed without a copy or thumbnail
+}
+
+// StaticFile is a file as listed in an exported folder's files.json.
+type StaticFile struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	MimeType   string    `json:"mimeType"`
+	MediaType  string    `json:"mediaType"`
+	Size       int64     `json:"size,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	CapturedAt time.Time `json:"capturedAt"`
+	URL        string    `json:"url"`                 // Relative to the folder page when the original was exported
+	Thumbnail  string    `json:"thumbnail,omitempty"` // Relative to the folder page
+}
+
+// staticFolder is a folder as listed in the exported folders.json.
+type staticFolder struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Names     map[string]string `json:"names,omitempty"`
+	Slug      string            `json:"slug,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Path      string            `json:"path"` // Directory of the folder page, e.g. "g/dai-1-kai/"
+	Files     []StaticFile      `json:"-"`
+}
+
+// ExportStaticSite renders every folder into a static site that can be hosted without the
+// backend, e.g. on GitHub Pages:
+//
+//	index.html, folders.json                 folder list
+//	g/{slug}/index.html, g/{slug}/files.json  one page per folder (the ID when there is no slug)
+//	g/{slug}/thumbs/{fileID}.jpg              thumbnails of JPEG, PNG and GIF images
+//	g/{slug}/media/{fileID}{ext}              originals, with opts.Originals
+//
+// The site is public: folders hidden from public viewers (see IsFolderVisible), and files pending
+// approval or showing a profile hidden from public galleries, are left out. progress, if not nil,
+// is called after each folder.
+func ExportStaticSite(ctx context.Context, w StaticSiteWriter, opts StaticExportOptions, progress func(folder FolderMetadata, stats StaticExportStats)) (*StaticExportStats, error) {
+	folders, err := ListFoldersFromFirestore(WithPublicViewer(ctx))
+	if err != nil {
+		return nil, err
+	}
+	hidden, err := HiddenProfileIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := &StaticExportStats{}
+	var exported []staticFolder
+	for _, folder := range folders {
+		dir := folder.Slug
+		if dir == "" {
+			dir = folder.ID
+		}
+		sf := staticFolder{ID: folder.ID, Name: folder.LocalizedName(opts.Lang), Names: folder.Names, Slug: folder.Slug, CreatedAt: folder.CreatedAt, Path: "g/" + dir + "/"}
+
+		files, err := ListAllFilesInFolder(ctx, folder.ID)
+		if err != nil {
+			return stats, err
+		}
+		for _, f := range files {
+			if f.PendingApproval || showsHiddenPerson(f, hidden) {
+				continue
+			}
+			file, err := exportStaticFile(ctx, w, sf.Path, f, opts.Originals, stats)
+			if err != nil {
+				log.Printf("Static export: %v", err)
+				stats.Failed++
+			}
+			sf.Files = append(sf.Files, file)
+			stats.Files++
+		}
+
+		if err := writeStaticJSON(ctx, w, sf.Path+"files.json", sf.Files); err != nil {
+			return stats, err
+		}
+		if err := writeStaticPage(ctx, w, sf.Path+"index.html", staticFolderTemplate, sf); err != nil {
+			return stats, err
+		}
+		exported = append(exported, sf)
+		stats.Folders++
+		if progress != nil {
+			progress(folder, *stats)
+		}
+	}
+
+	if err := writeStaticJSON(ctx, w, "folders.json", exported); err != nil {
+		return stats, err
+	}
+	if err := writeStaticPage(ctx, w, "index.html", staticIndexTemplate, exported); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// exportStaticFile copies the original of f into the folder directory dir when originals is set
+// and writes its thumbnail. The returned StaticFile is usable even if an error is returned.
+func exportStaticFile(ctx context.Context, w StaticSiteWriter, dir string, f FileMetadata, originals bool, stats *StaticExportStats) (StaticFile, error) {
+	file := StaticFile{
+		ID:         f.ID,
+		Name:       f.Name,
+		MimeType:   f.MimeType,
+		MediaType:  mediaTypeOf(f.MimeType),
+		Size:       f.Size,
+		CreatedAt:  f.CreatedAt,
+		CapturedAt: f.CapturedAt,
+		URL:        f.DownloadURL,
+	}
+	if !originals && file.MediaType != "image" {
+		return file, nil
+	}
+
+	bucket, err := fileBucket(&f)
+	if err != nil {
+		return file, err
+	}
+	reader, err := bucket.Object(f.StoragePath).NewReader(ctx)
+	if err != nil {
+		return file, fmt.Errorf("failed to read %s: %v", f.StoragePath, err)
+	}
+	defer reader.Close()
+	// Images are buffered to make the thumbnail from the same download
+	var content io.Reader = reader
+	var buf bytes.Buffer
+	if file.MediaType == "image" {
+		content = io.TeeReader(reader, &buf)
+	}
+
+	if originals {
+		name := "media/" + f.ID + strings.ToLower(path.Ext(f.Name))
+		if err := w.WriteFile(ctx, dir+name, f.MimeType, content); err != nil {
+			return file, err
+		}
+		file.URL = name
+		stats.Originals++
+	} else if _, err := io.Copy(io.Discard, content); err != nil {
+		return file, fmt.Errorf("failed to read %s: %v", f.StoragePath, err)
+	}
+
+	if file.MediaType == "image" {
+		thumb, err := makeFileThumbnail(&buf, &f, ThumbnailSize)
+		if err != nil {
+			// HEIC, WebP and other formats without a decoder are shown without a thumbnail
+			return file, nil
+		}
+		name := "thumbs/" + f.ID + ".jpg"
+		if err := w.WriteFile(ctx, dir+name, "image/jpeg", bytes.NewReader(thumb)); err != nil {
+			return file, err
+		}
+		file.Thumbnail = name
+		stats.Thumbnails++
+	}
+	return file, nil
+}
+
+// writeStaticJSON writes v as indented JSON.
+func writeStaticJSON(ctx context.Context, w StaticSiteWriter, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", name, err)
+	}
+	return w.WriteFile(ctx, name, "application/json", bytes.NewReader(data))
+}
+
+// writeStaticPage renders an HTML page.
+func writeStaticPage(ctx context.Context, w StaticSiteWriter, name string, tmpl *template.Template, data interface{}) error {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render %s: %v", name, err)
+	}
+	return w.WriteFile(ctx, name, "text/html; charset=utf-8", &buf)
+}
+
+// staticStyle is shared by the exported pages.
+const staticStyle = `<style>
+body { margin: 0 auto; max-width: 1200px; padding: 1rem; font-family: sans-serif; }
+ul { list-style: none; padding: 0; }
+li { margin: 0.5rem 0; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(160px, 1fr)); gap: 6px; }
+.grid a { display: flex; align-items: center; justify-content: center; aspect-ratio: 1; background: #eee; color: #333; text-decoration: none; overflow: hidden; font-size: 0.8rem; word-break: break-all; }
+.grid img { width: 100%; height: 100%; object-fit: cover; }
+</style>`
+
+var staticIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Luke Avenue</title>
+` + staticStyle + `
+</head>
+<body>
+<h1>Luke Avenue</h1>
+<ul>
+{{- range .}}
+<li><a href="{{.Path}}">📁 {{.Name}}</a> ({{len .Files}})</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+var staticFolderTemplate = template.Must(template.New("folder").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}}</title>
+` + staticStyle + `
+</head>
+<body>
+<p><a href="../../">← Luke Avenue</a></p>
+<h1>{{.Name}}</h1>
+<div class="grid">
+{{- range .Files}}
+<a href="{{.URL}}" title="{{.Name}}">{{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="{{.Name}}" loading="lazy">{{else if eq .MediaType "video"}}▶ {{.Name}}{{else}}{{.Name}}{{end}}</a>
+{{- end}}
+</div>
+</body>
+</html>
+`))
@@ -0,0 +1,484 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Face grouping finds the faces in uploaded images and groups similar ones into clusters, which
+// editors label with the profile of the person, so their photos can be found. It is biometric
+// processing, so it only runs when FACE_GROUPING=enabled; only the embeddings are stored, never
+// crops of faces, and PurgeFaceData deletes all of them.
+const (
+	FacesCollection        = "faces"        // One document per face found, with its embedding
+	FaceClustersCollection = "faceClusters" // One document per group of similar faces
+)
+
+// faceMatchThreshold is the cosine similarity from which a face joins a cluster, for the
+// normalized embeddings of common face recognition models.
+const faceMatchThreshold = 0.6
+
+// maxClusterSamples bounds the files kept per cluster to show editors who it is.
+const maxClusterSamples = 4
+
+// FaceEmbedder finds the faces in an image and returns an embedding for each, vectors of the
+// same length that are close for faces of the same person.
+type FaceEmbedder interface {
+	Name() string
+	EmbedFaces(ctx context.Context, content []byte, mimeType string) ([][]float64, error)
+}
+
+var (
+	faceEmbedderMu sync.Mutex
+	// faceEmbedder is nil unless face grouping is enabled.
+	faceEmbedder FaceEmbedder
+	// faceClusterMu serializes the assignment of faces to clusters, so concurrent uploads of the
+	// same person on this instance don't start separate clusters.
+	faceClusterMu sync.Mutex
+)
+
+func init() {
+	RegisterUploadHook(StageIndex, groupUploadFaces)
+}
+
+// SetFaceEmbedder replaces the configured FaceEmbedder; nil turns face grouping off.
+func SetFaceEmbedder(embedder FaceEmbedder) {
+	faceEmbedderMu.Lock()
+	defer faceEmbedderMu.Unlock()
+	faceEmbedder = embedder
+}
+
+func currentFaceEmbedder() FaceEmbedder {
+	faceEmbedderMu.Lock()
+	defer faceEmbedderMu.Unlock()
+	return faceEmbedder
+}
+
+// FaceGroupingEnabled reports whether uploads are run through face grouping.
+func FaceGroupingEnabled() bool {
+	return currentFaceEmbedder() != nil
+}
+
+// InitFaceGrouping enables face grouping with FACE_GROUPING=enabled, sending images to the
+// embedding service at FACE_EMBEDDING_URL (with FACE_EMBEDDING_API_KEY as bearer token, if set).
+// Without the flag nothing is sent anywhere, whatever else is configured.
+func InitFaceGrouping() {
+	if os.Getenv("FACE_GROUPING") != "enabled" {
+		if os.Getenv("FACE_EMBEDDING_URL") != "" {
+			log.Printf("WARNING: FACE_EMBEDDING_URL is set but face grouping is off; set FACE_GROUPING=enabled to opt in")
+		}
+		return
+	}
+	endpoint := os.Getenv("FACE_EMBEDDING_URL")
+	if endpoint == "" {
+		log.Fatalf("FACE_GROUPING=enabled requires FACE_EMBEDDING_URL")
+	}
+	SetFaceEmbedder(&httpFaceEmbedder{
+		endpoint: endpoint,
+		apiKey:   os.Getenv("FACE_EMBEDDING_API_KEY"),
+		client:   &http.Client{Timeout: 30 * time.Second},
+	})
+	log.Printf("Face grouping: enabled, embeddings from %s", endpoint)
+}
+
+// httpFaceEmbedder posts an image as the request body to an embedding service, which answers
+// {"faces": [{"embedding": [...]}, ...]}.
+type httpFaceEmbedder struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+}
+
+func (e *httpFaceEmbedder) Name() string { return "http" }
+
+func (e *httpFaceEmbedder) EmbedFaces(ctx context.Context, content []byte, mimeType string) ([][]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mimeType)
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send image to the face embedding service: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("face embedding service rejected image: status %d", resp.StatusCode)
+	}
+	var body struct {
+		Faces []struct {
+			Embedding []float64 `json:"embedding"`
+		} `json:"faces"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode face embeddings: %v", err)
+	}
+	embeddings := make([][]float64, 0, len(body.Faces))
+	for _, face := range body.Faces {
+		if len(face.Embedding) > 0 {
+			embeddings = append(embeddings, face.Embedding)
+		}
+	}
+	return embeddings, nil
+}
+
+// Face is a face found in a file: its embedding and the cluster it was grouped into.
+type Face struct {
+	ID        string    `json:"id" firestore:"-"`
+	FileID    string    `json:"fileId" firestore:"fileId"`
+	FolderID  string    `json:"folderId" firestore:"folderId"`
+	ClusterID string    `json:"clusterId" firestore:"clusterId"`
+	Embedding []float64 `json:"-" firestore:"embedding"` // Normalized to length 1
+	CreatedAt time.Time `json:"createdAt" firestore:"createdAt"`
+}
+
+// FaceCluster is a group of similar faces, presumably of one person. Once labeled with a profile
+// (see LabelFaceCluster), the files of its faces are tagged with it.
+type FaceCluster struct {
+	ID            string    `json:"id" firestore:"-"`
+	ProfileID     string    `json:"profileId,omitempty" firestore:"profileId,omitempty"`
+	FaceCount     int       `json:"faceCount" firestore:"faceCount"`
+	SampleFileIDs []string  `json:"sampleFileIds" firestore:"sampleFileIds"` // Files to show who it is
+	Centroid      []float64 `json:"-" firestore:"centroid"`                  // Normalized mean of the embeddings
+	CreatedAt     time.Time `json:"createdAt" firestore:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt" firestore:"updatedAt"`
+}
+
+// groupUploadFaces is the StageIndex hook grouping the faces of uploaded images when face
+// grouping is enabled.
+func groupUploadFaces(ctx context.Context, u *Upload) error {
+	embedder := currentFaceEmbedder()
+	if embedder == nil || mediaTypeOf(u.MimeType) != "image" {
+		return nil
+	}
+	content := u.Content
+	if content == nil {
+		reader, err := u.Bucket.Object(u.StoragePath).NewReader(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read %s for face grouping: %v", u.StoragePath, err)
+		}
+		defer reader.Close()
+		if content, err = io.ReadAll(reader); err != nil {
+			return fmt.Errorf("failed to read %s for face grouping: %v", u.StoragePath, err)
+		}
+	}
+	embeddings, err := embedder.EmbedFaces(ctx, content, u.MimeType)
+	if err != nil {
+		return err
+	}
+	return addFaces(ctx, u.File, embeddings)
+}
+
+// addFaces stores the faces found in a file, each in the most similar cluster from
+// faceMatchThreshold on or in a new one, and tags the file with the profiles of labeled clusters.
+func addFaces(ctx context.Context, file *FileMetadata, embeddings [][]float64) error {
+	if len(embeddings) == 0 {
+		return nil
+	}
+	faceClusterMu.Lock()
+	defer faceClusterMu.Unlock()
+	clusters, err := ListFaceClusters(ctx)
+	if err != nil {
+		return err
+	}
+
+	var people []string
+	for _, embedding := range embeddings {
+		embedding = normalizeEmbedding(embedding)
+		var best *FaceCluster
+		bestSimilarity := faceMatchThreshold
+		for i := range clusters {
+			if s := cosineSimilarity(embedding, clusters[i].Centroid); s >= bestSimilarity {
+				best, bestSimilarity = &clusters[i], s
+			}
+		}
+		if best == nil {
+			clusters = append(clusters, FaceCluster{ID: newID(), SampleFileIDs: []string{}, CreatedAt: now()})
+			best = &clusters[len(clusters)-1]
+		}
+		best.Centroid = mergeCentroid(best.Centroid, best.FaceCount, embedding)
+		best.FaceCount++
+		if len(best.SampleFileIDs) < maxClusterSamples && !slices.Contains(best.SampleFileIDs, file.ID) {
+			best.SampleFileIDs = append(best.SampleFileIDs, file.ID)
+		}
+		best.UpdatedAt = now()
+		if _, err := Client.Collection(FaceClustersCollection).Doc(best.ID).Set(ctx, best); err != nil {
+			return fmt.Errorf("failed to save face cluster %s: %v", best.ID, err)
+		}
+		face := Face{FileID: file.ID, FolderID: file.FolderID, ClusterID: best.ID, Embedding: embedding, CreatedAt: now()}
+		if _, err := Client.Collection(FacesCollection).Doc(newID()).Set(ctx, face); err != nil {
+			return fmt.Errorf("failed to save face of file %s: %v", file.ID, err)
+		}
+		if best.ProfileID != "" && !slices.Contains(file.People, best.ProfileID) && !slices.Contains(people, best.ProfileID) {
+			people = append(people, best.ProfileID)
+		}
+	}
+	log.Printf("Grouped %d faces of file %s.", len(embeddings), file.ID)
+	if len(people) == 0 {
+		return nil
+	}
+	if _, err := Client.Collection(FilesCollection).Doc(file.ID).Update(ctx, []firestore.Update{{Path: "people", Value: firestore.ArrayUnion(toInterfaces(people)...)}}); err != nil {
+		return fmt.Errorf("failed to tag people of file %s: %v", file.ID, err)
+	}
+	file.People = append(file.People, people...)
+	recordFolderChange(ctx, file.FolderID, FolderChange{Type: ChangeEdited, FileID: file.ID, Name: file.Name, Fields: []string{"people"}})
+	return nil
+}
+
+// normalizeEmbedding scales v to length 1, so similarity is a dot product.
+func normalizeEmbedding(v []float64) []float64 {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+	norm := math.Sqrt(sum)
+	out := make([]float64, len(v))
+	if norm == 0 {
+		return out
+	}
+	for i, x := range v {
+		out[i] = x / norm
+	}
+	return out
+}
+
+// cosineSimilarity of two normalized embeddings; 0 if their lengths differ, e.g. after the
+// embedding model changed.
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot float64
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return dot
+}
+
+// mergeCentroid adds a normalized embedding to the centroid of count faces.
+func mergeCentroid(centroid []float64, count int, embedding []float64) []float64 {
+	if len(centroid) != len(embedding) {
+		return embedding
+	}
+	merged := make([]float64, len(centroid))
+	for i := range centroid {
+		merged[i] = centroid[i]*float64(count) + embedding[i]
+	}
+	return normalizeEmbedding(merged)
+}
+
+// toInterfaces converts strings for firestore.ArrayUnion and ArrayRemove.
+func toInterfaces(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
+
+// ListFaceClusters returns the face clusters, those with the most faces first.
+func ListFaceClusters(ctx context.Context) ([]FaceCluster, error) {
+	docs, err := Client.Collection(FaceClustersCollection).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list face clusters: %v", err)
+	}
+	clusters := make([]FaceCluster, 0, len(docs))
+	for _, doc := range docs {
+		var cluster FaceCluster
+		if err := doc.DataTo(&cluster); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal face cluster %s: %v", doc.Ref.ID, err)
+		}
+		cluster.ID = doc.Ref.ID
+		clusters = append(clusters, cluster)
+	}
+	slices.SortStableFunc(clusters, func(a, b FaceCluster) int {
+		if a.FaceCount != b.FaceCount {
+			return b.FaceCount - a.FaceCount
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return clusters, nil
+}
+
+// LabelFaceCluster labels a face cluster with the profile of the person, or clears the label for
+// an empty profileID. The files of its faces are tagged with the profile (see SetFilePeople) and
+// untagged from the previous label. A missing cluster is ErrNotFound, and an unknown or deleted
+// profile an UnknownProfileError.
+func LabelFaceCluster(ctx context.Context, clusterID, profileID string) (*FaceCluster, error) {
+	ref := Client.Collection(FaceClustersCollection).Doc(clusterID)
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, notFound("face cluster %s", clusterID)
+		}
+		return nil, fmt.Errorf("failed to get face cluster %s: %v", clusterID, err)
+	}
+	var cluster FaceCluster
+	if err := doc.DataTo(&cluster); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal face cluster %s: %v", clusterID, err)
+	}
+	cluster.ID = clusterID
+	if profileID != "" {
+		profile, err := GetProfile(ctx, profileID)
+		if err != nil || profile.Deleted {
+			return nil, &UnknownProfileError{ID: profileID}
+		}
+	}
+	previous := cluster.ProfileID
+	if previous == profileID {
+		return &cluster, nil
+	}
+
+	var label interface{} = firestore.Delete
+	if profileID != "" {
+		label = profileID
+	}
+	cluster.ProfileID = profileID
+	cluster.UpdatedAt = now()
+	if _, err := ref.Update(ctx, []firestore.Update{{Path: "profileId", Value: label}, {Path: "updatedAt", Value: cluster.UpdatedAt}}); err != nil {
+		return nil, fmt.Errorf("failed to label face cluster %s: %v", clusterID, err)
+	}
+
+	faces, err := Client.Collection(FacesCollection).Where("clusterId", "==", clusterID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list faces of cluster %s: %v", clusterID, err)
+	}
+	files := make(map[string]string) // Folder IDs by file ID
+	for _, doc := range faces {
+		var face Face
+		if err := doc.DataTo(&face); err == nil {
+			files[face.FileID] = face.FolderID
+		}
+	}
+	// One update per change, as a write cannot transform the same field twice
+	writer := Client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for fileID := range files {
+		fileRef := Client.Collection(FilesCollection).Doc(fileID)
+		if previous != "" {
+			if job, err := writer.Update(fileRef, []firestore.Update{{Path: "people", Value: firestore.ArrayRemove(previous)}}); err == nil {
+				jobs = append(jobs, job)
+			}
+		}
+		if profileID != "" {
+			if job, err := writer.Update(fileRef, []firestore.Update{{Path: "people", Value: firestore.ArrayUnion(profileID)}}); err == nil {
+				jobs = append(jobs, job)
+			}
+		}
+	}
+	writer.End() // Blocks until every enqueued write has completed
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil && status.Code(err) != codes.NotFound {
+			log.Printf("Error tagging a file of face cluster %s: %v", clusterID, err)
+		}
+	}
+	folders := make(map[string]bool)
+	for _, folderID := range files {
+		if !folders[folderID] {
+			folders[folderID] = true
+			recordFolderChange(ctx, folderID, FolderChange{Type: ChangeEdited, Fields: []string{"people"}})
+		}
+	}
+	log.Printf("Face cluster %s labeled '%s' (was '%s'); %d files retagged.", clusterID, profileID, previous, len(files))
+	return &cluster, nil
+}
+
+// FacePurge is the outcome of PurgeFaceData.
+type FacePurge struct {
+	Faces    int `json:"faces"`
+	Clusters int `json:"clusters"`
+}
+
+// PurgeFaceData deletes every face embedding and cluster, e.g. when face grouping is given up.
+// Profiles files were tagged with stay, as editors confirmed them by labeling.
+func PurgeFaceData(ctx context.Context) (*FacePurge, error) {
+	faceClusterMu.Lock()
+	defer faceClusterMu.Unlock()
+	purge := &FacePurge{}
+	var err error
+	if purge.Faces, err = deleteCollection(ctx, FacesCollection); err != nil {
+		return purge, err
+	}
+	if purge.Clusters, err = deleteCollection(ctx, FaceClustersCollection); err != nil {
+		return purge, err
+	}
+	log.Printf("Purged face data: %d faces, %d clusters.", purge.Faces, purge.Clusters)
+	return purge, nil
+}
+
+// deleteCollection deletes every document of a top-level collection and returns how many.
+func deleteCollection(ctx context.Context, collection string) (int, error) {
+	refs, err := Client.Collection(collection).DocumentRefs(ctx).GetAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list %s: %v", collection, err)
+	}
+	writer := Client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(refs))
+	for _, ref := range refs {
+		job, err := writer.Delete(ref)
+		if err != nil {
+			writer.End()
+			return 0, fmt.Errorf("failed to delete %s: %v", collection, err)
+		}
+		jobs = append(jobs, job)
+	}
+	writer.End() // Blocks until every enqueued write has completed
+	deleted := 0
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return deleted, fmt.Errorf("failed to delete %s: %v", collection, err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// deleteFileFaces deletes the faces of a deleted file, so no embedding outlives its photo.
+func deleteFileFaces(ctx context.Context, fileID string) {
+	docs, err := Client.Collection(FacesCollection).Where("fileId", "==", fileID).Documents(ctx).GetAll()
+	if err != nil {
+		log.Printf("Error listing faces of deleted file %s: %v", fileID, err)
+		return
+	}
+	for _, doc := range docs {
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			log.Printf("Error deleting face %s of deleted file %s: %v", doc.Ref.ID, fileID, err)
+			continue
+		}
+		if clusterID, _ := doc.Data()["clusterId"].(string); clusterID != "" {
+			Client.Collection(FaceClustersCollection).Doc(clusterID).Update(ctx, []firestore.Update{{Path: "faceCount", Value: firestore.Increment(-1)}})
+		}
+	}
+}
+
+// unlabelProfileClusters clears the label of the face clusters of a deleted profile.
+func unlabelProfileClusters(ctx context.Context, profileID string) {
+	docs, err := Client.Collection(FaceClustersCollection).Where("profileId", "==", profileID).Documents(ctx).GetAll()
+	if err != nil {
+		log.Printf("Error listing face clusters of deleted profile %s: %v", profileID, err)
+		return
+	}
+	for _, doc := range docs {
+		if _, err := doc.Ref.Update(ctx, []firestore.Update{{Path: "profileId", Value: firestore.Delete}}); err != nil {
+			log.Printf("Error unlabeling face cluster %s of deleted profile %s: %v", doc.Ref.ID, profileID, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// fileAccessFields are read for every listed file whatever fields were selected: AttachAccessURLs
+// builds URLs from them, constraints, approval, hidden people and near-duplicate hiding filter by
+// them, and pagedQuery continues listings after a document by its sort fields.
+var fileAccessFields = []string{"id", "folderId", "mimeType", "storagePath", "downloadUrl", "bucket", "width", "height", "size", "phash", "pendingApproval", "people", "createdAt", "capturedAt"}
+
+// JSONFieldNames returns the JSON names of the fields of the struct type of v, which may be a
+// pointer or slice of it, mapped to their Firestore names; fields set per response, with the
+// Firestore tag "-", map to "".
+func JSONFieldNames(v interface{}) map[string]string {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	names := make(map[string]string, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if !sf.IsExported() || name == "-" || name == "" {
+			continue
+		}
+		stored, _, _ := strings.Cut(sf.Tag.Get("firestore"), ",")
+		if stored == "-" {
+			stored = ""
+		} else if stored == "" {
+			stored = sf.Name
+		}
+		names[name] = stored
+	}
+	return names
+}
+
+type fileFieldsKey struct{}
+
+// WithFileFields returns a context under which file listings read only the Firestore fields
+// needed for the FileMetadata JSON fields jsonFields, with Select, so a grid view listing names and
+// thumbnails doesn't transfer hashes, paths and EXIF-derived fields. Without it, or with no
+// fields, whole documents are read. Unknown names are ignored; callers validate them against
+// JSONFieldNames.
+func WithFileFields(ctx context.Context, jsonFields []string) context.Context {
+	if len(jsonFields) == 0 {
+		return ctx
+	}
+	stored := JSONFieldNames(FileMetadata{})
+	paths := append([]string(nil), fileAccessFields...)
+	seen := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		seen[p] = true
+	}
+	for _, name := range jsonFields {
+		if p := stored[name]; p != "" && !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	return context.WithValue(ctx, fileFieldsKey{}, paths)
+}
+
+// selectFileFields applies the selection of WithFileFields to a files query.
+func selectFileFields(ctx context.Context, query firestore.Query) firestore.Query {
+	if paths, ok := ctx.Value(fileFieldsKey{}).([]string); ok {
+		return query.Select(paths...)
+	}
+	return query
+}
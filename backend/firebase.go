@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash/crc32"
 	"log"
 	"os" // Add os import
 	"strings" // Add strings import
@@ -14,9 +15,10 @@ import (
 	gcs "cloud.google.com/go/storage" // Google Cloud Storage client for ACL
 	firebase "firebase.google.com/go/v4"
 	"firebase.google.com/go/v4/storage"
-	"github.com/google/uuid" // Import uuid package
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -38,6 +40,89 @@ type FileMetadata struct {
 	FolderID    string    `json:"folderId" firestore:"folderId"`       // Corresponds to a logical folder
 	Hash        string    `json:"hash" firestore:"hash"`               // SHA256 hash for deduplication
 	CreatedAt   time.Time `json:"createdAt" firestore:"createdAt"`
+	// CapturedAt is when the photo or video was taken. Without better information it falls back
+	// to the file's modification time on the uploader's machine, then to the upload time.
+	CapturedAt   time.Time `json:"capturedAt" firestore:"capturedAt"`
+	OriginalPath string    `json:"originalPath,omitempty" firestore:"originalPath,omitempty"` // Absolute path on the uploading machine
+	Size         int64     `json:"size,omitempty" firestore:"size,omitempty"`                 // Object size in bytes
+	MediaType    string    `json:"mediaType,omitempty" firestore:"mediaType,omitempty"`       // "image", "video" or "other", derived from MimeType for equality filters
+	NameSearch   string    `json:"nameSearch,omitempty" firestore:"nameSearch,omitempty"`     // Lowercase Name for case-insensitive prefix search
+	ThumbnailURL string    `json:"thumbnailUrl,omitempty" firestore:"-"`                      // Backend path of the thumbnail, set per response by AttachAccessURLs
+	UploaderUID  string    `json:"uploaderUid,omitempty" firestore:"uploaderUid,omitempty"`   // Firebase Auth UID of the signed-in uploader; empty for anonymous uploads
+	Color        string    `json:"color,omitempty" firestore:"color,omitempty"`               // Dominant color of an image ("#rrggbb"), for placeholders
+	PHash        string    `json:"phash,omitempty" firestore:"phash,omitempty"`               // Perceptual hash of an image, for near-duplicate detection
+	Width        int       `json:"width,omitempty" firestore:"width,omitempty"`               // Image width in pixels
+	Height       int       `json:"height,omitempty" firestore:"height,omitempty"`             // Image height in pixels
+	Duration     float64   `json:"duration,omitempty" firestore:"duration,omitempty"`         // Video length in seconds (MP4 and MOV)
+
+	// Srcset maps image widths ("320", "768", "1600") to resized renditions and "original" to
+	// DownloadURL, for responsive images; set per response by AttachAccessURLs.
+	Srcset map[string]string `json:"srcset,omitempty" firestore:"-"`
+
+	// PathStrategy is the StoragePathStrategy StoragePath was built with; empty for files stored
+	// before strategies existed, which use PathByFolder.
+	PathStrategy string `json:"pathStrategy,omitempty" firestore:"pathStrategy,omitempty"`
+	// RelativePath is the path of the file inside its folder, including the name. Files stored
+	// before it was recorded have it as the part of StoragePath after the folder ID.
+	RelativePath string `json:"relativePath,omitempty" firestore:"relativePath,omitempty"`
+	// Bucket is the Storage bucket of the object; empty for the default bucket.
+	Bucket string `json:"bucket,omitempty" firestore:"bucket,omitempty"`
+	// MediaURL is the backend path streaming the original with conditional GET and range
+	// support; set per response by AttachAccessURLs.
+	MediaURL string `json:"mediaUrl,omitempty" firestore:"-"`
+	// URLExpiresAt is when the signed URLs of a file in a private folder expire; set per
+	// response by AttachAccessURLs.
+	URLExpiresAt *time.Time `json:"urlExpiresAt,omitempty" firestore:"-"`
+	// MimeMismatch describes how the file's extension, declared MIME type and content disagree,
+	// as found at upload or by ReconcileMimeTypes; empty if they agree.
+	MimeMismatch string `json:"mimeMismatch,omitempty" firestore:"mimeMismatch,omitempty"`
+	// Tags label the file, e.g. those its folder's UploadSettings add to every upload.
+	Tags []string `json:"tags,omitempty" firestore:"tags,omitempty"`
+	// PendingApproval files were uploaded to a folder requiring approval and are hidden from
+	// listings until ApproveUploads.
+	PendingApproval bool `json:"pendingApproval,omitempty" firestore:"pendingApproval,omitempty"`
+	// Watermark files get WATERMARK_IMAGE drawn on their thumbnails and renditions.
+	Watermark bool `json:"watermark,omitempty" firestore:"watermark,omitempty"`
+	// CameraModel is the camera model from the EXIF header of a JPEG image.
+	CameraModel string `json:"cameraModel,omitempty" firestore:"cameraModel,omitempty"`
+	// Orientation is the EXIF orientation of a JPEG image stored sideways or mirrored (2-8; see
+	// EXIFInfo), which its thumbnails are turned upright by; 0 when upright.
+	Orientation int `json:"orientation,omitempty" firestore:"orientation,omitempty"`
+	// OriginalGeneration is the Storage generation of the object as uploaded, kept as a noncurrent
+	// version by Object Versioning after TransformFile replaced it; 0 for unedited files.
+	OriginalGeneration int64 `json:"originalGeneration,omitempty" firestore:"originalGeneration,omitempty"`
+	// LegalHold files cannot be deleted, e.g. while under license or dispute, until an admin
+	// clears it with SetFileLegalHold; files of a folder under hold cannot be either.
+	LegalHold bool `json:"legalHold,omitempty" firestore:"legalHold,omitempty"`
+	// People are the IDs of the profiles of the people appearing in the file (see SetFilePeople).
+	// Files showing a profile with HideFromPublic are left out of listings for public viewers.
+	People []string `json:"people,omitempty" firestore:"people,omitempty"`
+	// License is what the file may be reused under, one of Licenses; PhotographerName credits who
+	// took it. Both are given at upload and listed in credits (see BuildFolderCredits).
+	License          string `json:"license,omitempty" firestore:"license,omitempty"`
+	PhotographerName string `json:"photographerName,omitempty" firestore:"photographerName,omitempty"`
+}
+
+// mediaTypeOf derives the denormalized mediaType field from a MIME type.
+func mediaTypeOf(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	default:
+		return "other"
+	}
+}
+
+// SourceInfo describes an uploaded file as it existed on the uploader's machine, and as its
+// uploader credits it.
+type SourceInfo struct {
+	ModifiedAt       time.Time // File modification time; zero if unknown
+	OriginalPath     string    // Absolute path; empty if unknown
+	UploaderUID      string    // Firebase Auth UID of the signed-in uploader; empty if anonymous
+	License          string    // One of Licenses; empty if not given
+	PhotographerName string    // Empty if not given
 }
 
 // FolderMetadata represents the metadata of a logical folder stored in Firestore.
@@ -45,6 +130,44 @@ type FolderMetadata struct {
 	ID        string    `json:"id" firestore:"id"` // Firestore document ID
 	Name      string    `json:"name" firestore:"name"`
 	CreatedAt time.Time `json:"createdAt" firestore:"createdAt"`
+	Slug      string    `json:"slug,omitempty" firestore:"slug,omitempty"` // Unique, URL-friendly name for public links (/g/{slug})
+	// Names are display names by language ("ja", "en") for bilingual galleries; Name is the default
+	Names      map[string]string `json:"names,omitempty" firestore:"names,omitempty"`
+	Visibility string            `json:"visibility,omitempty" firestore:"visibility,omitempty"` // VisibilityPrivate or VisibilityPublic (empty)
+	Archived   bool              `json:"archived,omitempty" firestore:"archived,omitempty"`     // Read-only: uploads, deletes and edits are rejected (see SetFolderArchived)
+	Bucket     string            `json:"bucket,omitempty" firestore:"bucket,omitempty"`         // Storage bucket of new uploads; empty for the default (see SetFolderBucket)
+	// UploadSettings are the rules for new uploads (see SetFolderUploadSettings); nil for none.
+	UploadSettings *UploadSettings `json:"uploadSettings,omitempty" firestore:"uploadSettings,omitempty"`
+	// PreviousNames and PreviousSlugs are what the folder was called before RenameFolder, most
+	// recent last, so lookups by an old name or link still find it.
+	PreviousNames []string `json:"previousNames,omitempty" firestore:"previousNames,omitempty"`
+	PreviousSlugs []string `json:"previousSlugs,omitempty" firestore:"previousSlugs,omitempty"`
+	// LegalHold keeps the folder and its files from being deleted until an admin clears it (see
+	// SetFolderLegalHold).
+	LegalHold bool `json:"legalHold,omitempty" firestore:"legalHold,omitempty"`
+	// PublishAt embargoes the folder: it is hidden from public listings until then (see
+	// SetFolderPublishAt). PublishPending is set until EventFolderPublished has been sent.
+	PublishAt      *time.Time `json:"publishAt,omitempty" firestore:"publishAt,omitempty"`
+	PublishPending bool       `json:"-" firestore:"publishPending,omitempty"`
+	// ExpireAt closes the folder: then ExpireAction (FolderExpireArchive or FolderExpireHide) is
+	// applied by the folder scheduler (see SetFolderExpireAt). Folders are not a temporary
+	// collection, so TTL policies on expireAt never delete them. ExpirePending is set until the
+	// expiry was applied, ExpiryWarned once EventFolderExpiring was sent.
+	ExpireAt      *time.Time `json:"expireAt,omitempty" firestore:"expireAt,omitempty"`
+	ExpireAction  string     `json:"expireAction,omitempty" firestore:"expireAction,omitempty"`
+	ExpirePending bool       `json:"-" firestore:"expirePending,omitempty"`
+	ExpiryWarned  bool       `json:"-" firestore:"expiryWarned,omitempty"`
+}
+
+// FolderLanguages are the languages a folder can have a localized display name in.
+var FolderLanguages = []string{"ja", "en"}
+
+// LocalizedName returns the display name of the folder in lang, or Name if it has none in lang.
+func (f FolderMetadata) LocalizedName(lang string) string {
+	if name := f.Names[lang]; name != "" {
+		return name
+	}
+	return f.Name
 }
 
 const FilesCollection = "files"
@@ -75,6 +198,10 @@ func InitFirebase(ctx context.Context, projectID, serviceAccountJSONPath string)
 	if serviceAccountJSONPath != "" {
 		opts = append(opts, option.WithCredentialsFile(serviceAccountJSONPath))
 		log.Printf("Initializing Firebase Admin SDK with service account key: %s and project ID: %s", serviceAccountJSONPath, projectID)
+	} else if os.Getenv("FIRESTORE_EMULATOR_HOST") != "" && os.Getenv("STORAGE_EMULATOR_HOST") != "" {
+		// The emulators accept any request, so tests and local development need no credentials
+		opts = append(opts, option.WithoutAuthentication())
+		log.Printf("Initializing Firebase Admin SDK against the Firestore and Storage emulators with project ID: %s", projectID)
 	} else {
 		log.Printf("Initializing Firebase Admin SDK with Application Default Credentials and project ID: %s", projectID)
 	}
@@ -97,7 +224,13 @@ func InitFirebase(ctx context.Context, projectID, serviceAccountJSONPath string)
 		return fmt.Errorf("error getting Firebase Storage client: %v", err)
 	}
 
-	log.Println("Firebase Admin SDK, Firestore client, and Storage client initialized successfully.")
+	AuthClient, err = App.Auth(ctx)
+	if err != nil {
+		log.Printf("ERROR: Failed to get Firebase Auth client: %v", err)
+		return fmt.Errorf("error getting Firebase Auth client: %v", err)
+	}
+
+	log.Println("Firebase Admin SDK, Firestore client, Storage client, and Auth client initialized successfully.")
 	return nil
 }
 
@@ -111,163 +244,302 @@ func CalculateFileHash(content []byte) (string, error) {
 	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
 
-// UploadFileToStorageAndFirestore uploads a file to Firebase Storage and saves its metadata to Firestore.
-// It handles deduplication based on content hash. The bucketName is derived from the StorageClient.
-// It now also handles folder creation if the specified folderName does not exist in Firestore.
-func UploadFileToStorageAndFirestore(ctx context.Context, folderName, relativePath, mimeType string, content []byte) (string, error) {
-	fileHash, err := CalculateFileHash(content)
+// FindFileByHash returns the metadata of a stored file with the given content hash,
+// or nil if no such file exists.
+func FindFileByHash(ctx context.Context, hash string) (*FileMetadata, error) {
+	iter := Client.Collection(FilesCollection).Where("hash", "==", hash).Limit(1).Documents(ctx)
+	defer iter.Stop()
+	doc, err := iter.Next()
+	if err == iterator.Done {
+		return nil, nil
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to calculate file hash: %v", err)
+		return nil, fmt.Errorf("failed to query Firestore for existing hash: %v", err)
 	}
+	var existingFile FileMetadata
+	if err := doc.DataTo(&existingFile); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal existing file metadata: %v", err)
+	}
+	return &existingFile, nil
+}
 
-	// 1. Determine folderID: Find existing folder or create a new one
-	var folderID string
-	if folderName != "" {
-		// Try to find an existing folder by name
-		iter := Client.Collection(FoldersCollection).Where("name", "==", folderName).Limit(1).Documents(ctx)
-		doc, err := iter.Next()
-		if err == nil {
-			// Folder found
-			var existingFolder FolderMetadata
-			if err := doc.DataTo(&existingFolder); err != nil {
-				return "", fmt.Errorf("failed to unmarshal existing folder metadata: %v", err)
-			}
-			folderID = existingFolder.ID
-			log.Printf("Found existing folder '%s' with ID: %s", folderName, folderID)
-		} else if err == iterator.Done {
-			// Folder not found, create a new one
-			newFolderID := uuid.New().String()
-			newFolder := FolderMetadata{
-				ID:        newFolderID,
-				Name:      folderName,
-				CreatedAt: time.Now(),
+// maxHashesPerInQuery is the maximum number of values Firestore accepts in an "in" filter.
+const maxHashesPerInQuery = 30
+
+// FindFilesByHashes looks up several content hashes at once and returns the metadata
+// of the stored files keyed by hash. Hashes without a stored file are absent from the map.
+func FindFilesByHashes(ctx context.Context, hashes []string) (map[string]FileMetadata, error) {
+	found := make(map[string]FileMetadata)
+	for start := 0; start < len(hashes); start += maxHashesPerInQuery {
+		end := start + maxHashesPerInQuery
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		iter := Client.Collection(FilesCollection).Where("hash", "in", hashes[start:end]).Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
 			}
-			_, err := Client.Collection(FoldersCollection).Doc(newFolderID).Set(ctx, newFolder)
 			if err != nil {
-				return "", fmt.Errorf("failed to create new folder '%s': %v", folderName, err)
+				iter.Stop()
+				return nil, fmt.Errorf("failed to query Firestore for existing hashes: %v", err)
+			}
+			var file FileMetadata
+			if err := doc.DataTo(&file); err != nil {
+				iter.Stop()
+				return nil, fmt.Errorf("failed to unmarshal file metadata from doc %s: %v", doc.Ref.ID, err)
+			}
+			if _, ok := found[file.Hash]; !ok {
+				found[file.Hash] = file
 			}
-			folderID = newFolderID
-			log.Printf("Created new folder '%s' with ID: %s", folderName, folderID)
-		} else {
-			return "", fmt.Errorf("failed to query Firestore for folder '%s': %v", folderName, err)
 		}
-	} else {
-		// If no folderName is provided, use a default or handle as root.
-		// For now, let's assume a default "root" folder or handle as empty folderID.
-		// If folderName is empty, we'll use an empty string for folderID, which means files go to the root of the bucket.
-		folderID = "" // This means files will be in the root of the bucket, but still associated with an empty folderID in Firestore
-		log.Println("No folder name provided, files will be uploaded to the root or a default folder.")
+		iter.Stop()
 	}
+	return found, nil
+}
+
+// UploadFileToStorageAndFirestore uploads a file to Firebase Storage and saves its metadata to Firestore.
+// It handles deduplication based on content hash. The bucketName is derived from the StorageClient.
+// It now also handles folder creation if the specified folderName does not exist in Firestore.
+// The source describes the file on the uploader's machine and may be empty.
+// The content is checked against the expected checksums and against the CRC32C Storage reports for
+// the written object; on mismatch a *ChecksumMismatchError is returned and nothing is kept.
+// The file passes through the upload pipeline (see UploadStage), so registered hooks may reject
+// or transform it.
+// It returns the stored file's metadata and whether an existing file with the same content was
+// returned instead of storing a new one.
+func UploadFileToStorageAndFirestore(ctx context.Context, folderName, relativePath, mimeType string, content []byte, source SourceInfo, expected ExpectedChecksums) (*FileMetadata, bool, error) {
+	// 1. Determine folderID: Find existing folder or create a new one
+	folderID, err := resolveFolderID(ctx, folderName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	u := &Upload{FolderID: folderID, FolderName: folderName, RelativePath: relativePath, MimeType: mimeType, Content: content, Source: source, Expected: expected}
+	if err := runUploadChecks(ctx, u); err != nil {
+		return nil, false, err
+	}
+	// The bucket depends on the MIME type and folder, which transform hooks may have changed
+	folderID, folderName = u.FolderID, u.FolderName
+	u.BucketName, u.Bucket, err = uploadBucket(ctx, folderID, u.MimeType)
+	if err != nil {
+		return nil, false, err
+	}
+	bucket := u.Bucket
+	if u.Hash, err = CalculateFileHash(u.Content); err != nil {
+		return nil, false, fmt.Errorf("failed to calculate file hash: %v", err)
+	}
+	crc := crc32.Checksum(u.Content, crc32cTable)
 
 	// 2. Check for existing file with the same hash in Firestore
 	// This check should ideally also consider the folderID to avoid false positives across different logical folders
 	// For now, we keep it global for simplicity, but be aware of potential issues if same file content is allowed in different folders.
-	iter := Client.Collection(FilesCollection).Where("hash", "==", fileHash).Limit(1).Documents(ctx)
-	doc, err := iter.Next()
-	if err == nil {
-		// File with same hash already exists, return its download URL
-		var existingFile FileMetadata
-		if err := doc.DataTo(&existingFile); err != nil {
-			return "", fmt.Errorf("failed to unmarshal existing file metadata: %v", err)
-		}
-		log.Printf("File with hash %s already exists: %s. Returning existing URL.", fileHash, existingFile.DownloadURL)
-		return existingFile.DownloadURL, nil
+	existingFile, err := FindFileByHash(ctx, u.Hash)
+	if err != nil {
+		return nil, false, err
 	}
-	if err != iterator.Done {
-		return "", fmt.Errorf("failed to query Firestore for existing hash: %v", err)
+	if existingFile != nil {
+		// File with same hash already exists, return it
+		log.Printf("File with hash %s already exists: %s. Returning existing file.", u.Hash, existingFile.DownloadURL)
+		return existingFile, true, nil
 	}
 
 	// 3. If not exists, upload to Firebase Storage
-	bucket, err := StorageClient.DefaultBucket()
+	u.StoragePath, u.PathStrategy = storagePathFor(StoragePathInput{FolderID: folderID, FolderName: folderName, RelativePath: u.RelativePath, Hash: u.Hash, CapturedAt: source.ModifiedAt})
+	wc := bucket.Object(u.StoragePath).NewWriter(ctx)
+	wc.ContentType = u.MimeType
+	if _, err := wc.Write(u.Content); err != nil {
+		return nil, false, fmt.Errorf("failed to write file to storage: %v", err)
+	}
+	if err := wc.Close(); err != nil {
+		return nil, false, fmt.Errorf("failed to close storage writer: %v", err)
+	}
+	if stored := wc.Attrs().CRC32C; stored != crc {
+		if err := bucket.Object(u.StoragePath).Delete(ctx); err != nil {
+			log.Printf("ERROR: Failed to delete corrupted object %s: %v", u.StoragePath, err)
+		}
+		return nil, false, &ChecksumMismatchError{Algorithm: "CRC32C", Expected: encodeCRC32C(crc), Actual: encodeCRC32C(stored)}
+	}
+
+	// 4. Publish the object and save metadata to Firestore
+	fileMetadata, err := publishStoredObject(ctx, u)
 	if err != nil {
-		return "", fmt.Errorf("failed to get default storage bucket: %v", err)
+		return nil, false, err
 	}
+	return fileMetadata, false, nil
+}
 
-	// Construct storagePath using folderID and relativePath
-	// relativePath already contains the full path including filename (e.g., "subfolder/image.jpg")
+// resolveFolderID returns the ID of the logical folder with the given name, creating it if it does not exist.
+// An empty folderName maps to an empty folderID, i.e. the root of the bucket.
+func resolveFolderID(ctx context.Context, folderName string) (string, error) {
+	if folderName == "" {
+		// If folderName is empty, we'll use an empty string for folderID, which means files go to the root of the bucket.
+		log.Println("No folder name provided, files will be uploaded to the root or a default folder.")
+		return "", nil
+	}
+
+	// Try to find an existing folder by name, or by a name it had before it was renamed
+	existingFolder, err := FindFolderByName(ctx, folderName)
+	if err != nil {
+		return "", err
+	}
+	if existingFolder != nil {
+		log.Printf("Found existing folder '%s' with ID: %s", existingFolder.Name, existingFolder.ID)
+		if existingFolder.Archived {
+			return "", &ArchivedFolderError{FolderID: existingFolder.ID, Name: existingFolder.Name}
+		}
+		return existingFolder.ID, nil
+	}
+
+	// Folder not found, create a new one
+	newFolder := FolderMetadata{
+		ID:        newID(),
+		Name:      folderName,
+		CreatedAt: now(),
+	}
+	if newFolder.Slug, err = uniqueFolderSlug(ctx, Slugify(folderName), newFolder.ID); err != nil {
+		return "", err
+	}
+	if _, err := Client.Collection(FoldersCollection).Doc(newFolder.ID).Set(ctx, newFolder); err != nil {
+		return "", fmt.Errorf("failed to create new folder '%s': %v", folderName, err)
+	}
+	log.Printf("Created new folder '%s' with ID: %s", folderName, newFolder.ID)
+	recordFolderChange(ctx, newFolder.ID, FolderChange{Type: ChangeAdded, Name: folderName})
+	BroadcastEvent(EventFolderCreated, newFolder)
+	return newFolder.ID, nil
+}
+
+// objectStoragePath builds the Storage object path of a file inside a logical folder.
+// relativePath already contains the full path including filename (e.g., "subfolder/image.jpg").
+func objectStoragePath(folderID, relativePath string) string {
 	storagePath := relativePath
 	if folderID != "" {
 		storagePath = fmt.Sprintf("%s/%s", folderID, relativePath)
 	}
 	// Clean up relativePath to ensure it doesn't start with a slash if it's a root file
-	storagePath = strings.TrimPrefix(storagePath, "/")
+	return strings.TrimPrefix(storagePath, "/")
+}
 
-	wc := bucket.Object(storagePath).NewWriter(ctx)
-	wc.ContentType = mimeType
-	if _, err := wc.Write(content); err != nil {
-		return "", fmt.Errorf("failed to write file to storage: %v", err)
-	}
-	if err := wc.Close(); err != nil {
-		return "", fmt.Errorf("failed to close storage writer: %v", err)
+// publishStoredObject makes the stored object of an upload downloadable and saves its metadata
+// to Firestore, running the store, index and notify stages of the upload pipeline.
+// u.Content is the object's content if the server has it, or nil to read it back when needed.
+// If the metadata cannot be saved, the object is deleted so no orphan is left behind.
+func publishStoredObject(ctx context.Context, u *Upload) (*FileMetadata, error) {
+	bucket, storagePath := u.Bucket, u.StoragePath
+	folder, err := GetFolder(ctx, u.FolderID)
+	if err != nil {
+		return nil, err
 	}
-
-	// Make the file public (optional, depending on security rules)
-	if err := bucket.Object(storagePath).ACL().Set(ctx, gcs.AllUsers, gcs.RoleReader); err != nil {
-		log.Printf("Warning: Could not set public ACL for file %s: %v", storagePath, err)
+	u.Folder = folder
+	// Make the file public (optional, depending on security rules); signed download URLs and
+	// private folders don't need it
+	if downloadURLMode() == "public" && (folder == nil || !folder.IsPrivate()) {
+		if err := bucket.Object(storagePath).ACL().Set(ctx, gcs.AllUsers, gcs.RoleReader); err != nil {
+			log.Printf("Warning: Could not set public ACL for file %s: %v", storagePath, err)
+		}
 	}
 
 	attrs, err := bucket.Object(storagePath).Attrs(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to get storage object attributes: %v", err)
+		return nil, fmt.Errorf("failed to get storage object attributes: %v", err)
+	}
+	downloadURL, err := deriveDownloadURL(bucket, attrs)
+	if err != nil {
+		return nil, err
 	}
-	downloadURL := attrs.MediaLink // MediaLink is the public download URL
 
-	// 4. Save metadata to Firestore
-	fileDocID := uuid.New().String()
+	fileDocID := newID()
 	log.Printf("Generated Firestore document ID: %s", fileDocID)
 
 	// Extract filename from relativePath for FileMetadata.Name
-	fileName := relativePath
-	if lastSlash := strings.LastIndex(relativePath, "/"); lastSlash != -1 {
-		fileName = relativePath[lastSlash+1:]
+	fileName := u.RelativePath
+	if lastSlash := strings.LastIndex(u.RelativePath, "/"); lastSlash != -1 {
+		fileName = u.RelativePath[lastSlash+1:]
 	}
 
-	fileMetadata := FileMetadata{
-		ID:          fileDocID,
-		Name:        fileName, // Use extracted filename
-		MimeType:    mimeType,
-		StoragePath: storagePath,
-		DownloadURL: downloadURL,
-		FolderID:    folderID, // Use the determined folderID (UUID)
-		Hash:        fileHash,
-		CreatedAt:   time.Now(),
+	createdAt := now()
+	capturedAt := u.Source.ModifiedAt
+	if capturedAt.IsZero() {
+		capturedAt = createdAt
 	}
 
-	log.Printf("Attempting to save file metadata to Firestore: %+v", fileMetadata)
-
-	_, err = Client.Collection(FilesCollection).Doc(fileDocID).Set(ctx, fileMetadata)
+	u.File = &FileMetadata{
+		ID:               fileDocID,
+		Name:             fileName, // Use extracted filename
+		MimeType:         u.MimeType,
+		StoragePath:      storagePath,
+		DownloadURL:      downloadURL,
+		FolderID:         u.FolderID, // Use the determined folderID (UUID)
+		Hash:             u.Hash,
+		CreatedAt:        createdAt,
+		CapturedAt:       capturedAt,
+		OriginalPath:     u.Source.OriginalPath,
+		UploaderUID:      u.Source.UploaderUID,
+		Size:             attrs.Size,
+		MediaType:        mediaTypeOf(u.MimeType),
+		NameSearch:       strings.ToLower(fileName),
+		PathStrategy:     u.PathStrategy,
+		RelativePath:     u.RelativePath,
+		Bucket:           u.BucketName,
+		MimeMismatch:     u.MimeMismatch,
+		License:          u.Source.License,
+		PhotographerName: u.Source.PhotographerName,
+	}
+	err = runUploadHooks(ctx, StageStore, u)
+	if err == nil {
+		log.Printf("Attempting to save file metadata to Firestore: %+v", *u.File)
+		ref := Client.Collection(FilesCollection).Doc(fileDocID)
+		if err = waitForWrite(ctx, ref); err == nil { // Batch uploads finalize many files at once
+			_, err = ref.Set(ctx, u.File)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to save file metadata to Firestore: %v", err)
+		}
+	}
 	if err != nil {
-		log.Printf("ERROR: Failed to save file metadata to Firestore for %s: %v. Attempting to delete from Storage.", storagePath, err)
+		log.Printf("ERROR: Failed to store %s: %v. Attempting to delete from Storage.", storagePath, err)
 		if delErr := bucket.Object(storagePath).Delete(ctx); delErr != nil {
 			log.Printf("ERROR: Failed to delete orphaned storage object %s: %v", storagePath, delErr)
 		}
-		return "", fmt.Errorf("failed to save file metadata to Firestore: %v", err)
+		return nil, err
 	}
 
 	log.Printf("File uploaded to Storage and metadata saved to Firestore: %s", downloadURL)
-	return downloadURL, nil
+	runUploadHooks(ctx, StageIndex, u)
+	runUploadHooks(ctx, StageNotify, u)
+	return u.File, nil
 }
 
 // UpdateFileMetadata updates the mimeType of an existing file metadata in Firestore.
 func UpdateFileMetadata(ctx context.Context, firestoreDocID, newMimeType string) error {
 	_, err := Client.Collection(FilesCollection).Doc(firestoreDocID).Update(ctx, []firestore.Update{
 		{Path: "mimeType", Value: newMimeType},
+		{Path: "mediaType", Value: mediaTypeOf(newMimeType)},
 	})
+	if status.Code(err) == codes.NotFound {
+		return notFound("file %s", firestoreDocID)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update file metadata for doc ID %s: %v", firestoreDocID, err)
 	}
 	log.Printf("File metadata for doc ID %s updated with new mimeType: %s", firestoreDocID, newMimeType)
+	if file, err := GetFile(ctx, firestoreDocID); err == nil && file != nil {
+		recordFolderChange(ctx, file.FolderID, FolderChange{Type: ChangeEdited, FileID: firestoreDocID, Name: file.Name, Fields: []string{"mimeType"}})
+	}
 	return nil
 }
 
-// ListFilesFromFirestore lists file metadata from Firestore based on folderID and filterType.
-// It supports pagination using lastDocID (Firestore document ID of the last item from previous page).
-func ListFilesFromFirestore(ctx context.Context, folderID string, pageSize int64, lastDocID string, filterType string) ([]FileMetadata, string, error) {
-	log.Printf("ListFilesFromFirestore called for folderID: %s, pageSize: %d, lastDocID: %s, filterType: %s", folderID, pageSize, lastDocID, filterType)
+// filesQuery builds the query of ListFilesFromFirestore, starting after the document lastDocID.
+func filesQuery(ctx context.Context, folderID, lastDocID, filterType, sortBy string) (firestore.Query, error) {
+	orderField := "createdAt"
+	if sortBy == "capturedAt" {
+		orderField = "capturedAt" // Files uploaded before capturedAt existed are not included
+	}
 
 	// Revert to original query with OrderBy and StartAfter
-	query := Client.Collection(FilesCollection).Where("folderId", "==", folderID).OrderBy("createdAt", firestore.Desc)
-	log.Printf("Query: Filtering by folderId and ordering by createdAt Desc.")
+	query := Client.Collection(FilesCollection).Where("folderId", "==", folderID).OrderBy(orderField, firestore.Desc)
+	log.Printf("Query: Filtering by folderId and ordering by %s Desc.", orderField)
 
 	// Apply filterType
 	switch filterType {
@@ -286,35 +558,186 @@ func ListFilesFromFirestore(ctx context.Context, folderID string, pageSize int64
 		lastDocSnap, err := Client.Collection(FilesCollection).Doc(lastDocID).Get(ctx)
 		if err != nil {
 			log.Printf("ERROR: Failed to get last document snapshot for ID %s: %v", lastDocID, err)
-			return nil, "", fmt.Errorf("failed to get last document snapshot: %v", err)
+			return firestore.Query{}, fmt.Errorf("failed to get last document snapshot: %v", err)
 		}
 		query = query.StartAfter(lastDocSnap)
 	}
 
-	iter := query.Limit(int(pageSize)).Documents(ctx)
-	defer iter.Stop()
+	return selectFileFields(ctx, query), nil
+}
 
+// ListFilesFromFirestore lists file metadata from Firestore based on folderID and filterType.
+// It supports pagination using lastDocID (Firestore document ID of the last item from previous page).
+// sortBy selects the order: "capturedAt" for shoot date, anything else for upload date (createdAt).
+// Files awaiting approval are left out, and for a public viewer (see WithPublicViewer) files
+// showing people hidden from public galleries.
+func ListFilesFromFirestore(ctx context.Context, folderID string, pageSize int64, lastDocID string, filterType string, sortBy string) ([]FileMetadata, string, error) {
+	log.Printf("ListFilesFromFirestore called for folderID: %s, pageSize: %d, lastDocID: %s, filterType: %s, sortBy: %s", folderID, pageSize, lastDocID, filterType, sortBy)
+
+	query, err := filesQuery(ctx, folderID, lastDocID, filterType, sortBy)
+	if err != nil {
+		return nil, "", err
+	}
+	hidden, err := hiddenPeopleFor(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	// Large pages are read in chunks, so a pageSize of thousands doesn't make one huge query
 	var files []FileMetadata
 	var newLastDocID string
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
+	err = pagedQuery(ctx, query, 0, int(pageSize), func(docs []*firestore.DocumentSnapshot) error {
+		for _, doc := range docs {
+			var file FileMetadata
+			if err := doc.DataTo(&file); err != nil {
+				log.Printf("ERROR: Failed to unmarshal file metadata from doc %s: %v", doc.Ref.ID, err)
+				return fmt.Errorf("failed to unmarshal file metadata: %v", err)
+			}
+			newLastDocID = doc.Ref.ID // Update lastDocID for next page
+			if file.PendingApproval || showsHiddenPerson(file, hidden) {
+				continue // The page comes out short rather than showing uploads awaiting approval
+			}
+			files = append(files, file)
 		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to iterate files: %v", err)
+		return nil, "", fmt.Errorf("failed to iterate files: %v", err)
+	}
+
+	log.Printf("ListFilesFromFirestore returning %d files. NextPageToken: %s (Note: OrderBy/StartAfter temporarily removed)", len(files), newLastDocID)
+	return files, newLastDocID, nil
+}
+
+// FileConstraints selects files by size and dimensions, e.g. print-quality shots with MinWidth 1920.
+// Zero fields don't restrict anything. Files without recorded dimensions never pass a minimum.
+type FileConstraints struct {
+	MinWidth  int
+	MinHeight int
+	MinSize   int64 // Bytes
+	MaxSize   int64 // Bytes
+}
+
+// IsZero reports whether c doesn't restrict anything.
+func (c FileConstraints) IsZero() bool {
+	return c == FileConstraints{}
+}
+
+// Matches reports whether file meets c.
+func (c FileConstraints) Matches(file FileMetadata) bool {
+	return file.Width >= c.MinWidth && file.Height >= c.MinHeight &&
+		file.Size >= c.MinSize && (c.MaxSize == 0 || file.Size <= c.MaxSize)
+}
+
+// maxConstraintScanPages bounds the pages ListFilesMatching reads for one page of results, so a
+// rare match in a large folder costs several short pages rather than one long request.
+const maxConstraintScanPages = 10
+
+// ListFilesMatching is ListFilesFromFirestore for files that meet c. Firestore can only range
+// filter on one field, so pages are filtered here and further pages read until pageSize files
+// match, the folder ends or maxConstraintScanPages pages were read; the returned page may then be
+// short or empty while the page token still points further.
+func ListFilesMatching(ctx context.Context, folderID string, pageSize int64, lastDocID string, filterType string, sortBy string, c FileConstraints) ([]FileMetadata, string, error) {
+	if c.IsZero() {
+		return ListFilesFromFirestore(ctx, folderID, pageSize, lastDocID, filterType, sortBy)
+	}
+	files := []FileMetadata{}
+	for scanned := 0; scanned < maxConstraintScanPages && int64(len(files)) < pageSize; scanned++ {
+		page, _, err := ListFilesFromFirestore(ctx, folderID, pageSize, lastDocID, filterType, sortBy)
 		if err != nil {
-			log.Printf("ERROR: Failed to iterate files: %v", err)
-			return nil, "", fmt.Errorf("failed to iterate files: %v", err)
+			return nil, "", err
 		}
-		var file FileMetadata
-		if err := doc.DataTo(&file); err != nil {
-			log.Printf("ERROR: Failed to unmarshal file metadata from doc %s: %v", doc.Ref.ID, err)
-			return nil, "", fmt.Errorf("failed to unmarshal file metadata: %v", err)
+		for _, file := range page {
+			if int64(len(files)) == pageSize {
+				break
+			}
+			if c.Matches(file) {
+				files = append(files, file)
+			}
+			lastDocID = file.ID
+		}
+		if int64(len(page)) < pageSize {
+			break
 		}
-		files = append(files, file)
-		newLastDocID = doc.Ref.ID // Update lastDocID for next page
 	}
+	return files, lastDocID, nil
+}
 
-	log.Printf("ListFilesFromFirestore returning %d files. NextPageToken: %s (Note: OrderBy/StartAfter temporarily removed)", len(files), newLastDocID)
+// StreamFilesMatching calls fn with every file of a folder after lastDocID that meets c, in the
+// order of ListFilesFromFirestore, reading one page of documents at a time (see pagedQuery), so
+// very large folders are listed without holding them in memory. A limit above zero stops
+// after that many files. An error from fn stops the stream and is returned.
+func StreamFilesMatching(ctx context.Context, folderID, lastDocID, filterType, sortBy string, c FileConstraints, limit int64, fn func(*FileMetadata) error) error {
+	query, err := filesQuery(ctx, folderID, lastDocID, filterType, sortBy)
+	if err != nil {
+		return err
+	}
+	hidden, err := hiddenPeopleFor(ctx)
+	if err != nil {
+		return err
+	}
+	var sent int64
+	return pagedQuery(ctx, query, 0, 0, func(docs []*firestore.DocumentSnapshot) error {
+		for _, doc := range docs {
+			var file FileMetadata
+			if err := doc.DataTo(&file); err != nil {
+				return fmt.Errorf("failed to unmarshal file metadata from doc %s: %v", doc.Ref.ID, err)
+			}
+			if file.PendingApproval || !c.Matches(file) || showsHiddenPerson(file, hidden) {
+				continue
+			}
+			if err := fn(&file); err != nil {
+				return err
+			}
+			sent++
+			if limit > 0 && sent == limit {
+				return errStopPaging
+			}
+		}
+		return nil
+	})
+}
+
+// ListFilesByUploader lists the files uploaded by a user across all folders, newest first, with
+// the same pagination as ListFilesFromFirestore. It needs a composite index on
+// files (uploaderUid, createdAt desc).
+func ListFilesByUploader(ctx context.Context, uploaderUID string, pageSize int64, lastDocID string) ([]FileMetadata, string, error) {
+	query := Client.Collection(FilesCollection).Where("uploaderUid", "==", uploaderUID).OrderBy("createdAt", firestore.Desc)
+	files, newLastDocID, err := listFilesPage(ctx, query, pageSize, lastDocID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list files of uploader %s: %v", uploaderUID, err)
+	}
+	return files, newLastDocID, nil
+}
+
+// listFilesPage reads a page of pageSize files of query after the file lastDocID, and returns it
+// with the ID of its last file.
+func listFilesPage(ctx context.Context, query firestore.Query, pageSize int64, lastDocID string) ([]FileMetadata, string, error) {
+	query = selectFileFields(ctx, query)
+	if lastDocID != "" {
+		lastDocSnap, err := Client.Collection(FilesCollection).Doc(lastDocID).Get(ctx)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to get last document snapshot: %v", err)
+		}
+		query = query.StartAfter(lastDocSnap)
+	}
+
+	var files []FileMetadata
+	var newLastDocID string
+	err := pagedQuery(ctx, query, 0, int(pageSize), func(docs []*firestore.DocumentSnapshot) error {
+		for _, doc := range docs {
+			var file FileMetadata
+			if err := doc.DataTo(&file); err != nil {
+				return fmt.Errorf("failed to unmarshal file metadata: %v", err)
+			}
+			files = append(files, file)
+			newLastDocID = doc.Ref.ID
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to iterate files: %v", err)
+	}
 	return files, newLastDocID, nil
 }
 
@@ -325,6 +748,8 @@ func ListFilesFromFirestore(ctx context.Context, folderID string, pageSize int64
 // We will return unique folderIDs found in files collection.
 // ListFoldersFromFirestore lists logical folders from Firestore.
 // This function now queries the dedicated "folders" collection.
+// Folders scheduled for publication later or hidden by their expiry are left out for public
+// viewers (see IsFolderVisible).
 func ListFoldersFromFirestore(ctx context.Context) ([]FolderMetadata, error) {
 	iter := Client.Collection(FoldersCollection).OrderBy("createdAt", firestore.Desc).Documents(ctx) // Order by createdAt for consistent listing
 	defer iter.Stop()
@@ -342,6 +767,9 @@ func ListFoldersFromFirestore(ctx context.Context) ([]FolderMetadata, error) {
 		if err := doc.DataTo(&folder); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal folder metadata: %v", err)
 		}
+		if !IsFolderVisible(ctx, &folder) {
+			continue
+		}
 		folders = append(folders, folder)
 	}
 	return folders, nil
@@ -349,12 +777,13 @@ func ListFoldersFromFirestore(ctx context.Context) ([]FolderMetadata, error) {
 
 // GetFolderNameFromFirestore retrieves the name of a specific folder by its ID.
 // This function now queries the dedicated "folders" collection.
-func GetFolderNameFromFirestore(ctx context.Context, folderID string) (string, error) {
+// The name is localized to lang (see FolderMetadata.LocalizedName); an empty lang returns the default name.
+// A missing folder is ErrNotFound, and so is an embargoed one for a public viewer.
+func GetFolderNameFromFirestore(ctx context.Context, folderID, lang string) (string, error) {
 	doc, err := Client.Collection(FoldersCollection).Doc(folderID).Get(ctx)
 	if err != nil {
-		// If document not found, return a default name or an error indicating it's not a known folder
-		if doc == nil || !doc.Exists() {
-			return "Unknown Folder", nil // Or return an error if strict
+		if status.Code(err) == codes.NotFound {
+			return "", notFound("folder %s", folderID)
 		}
 		return "", fmt.Errorf("failed to get folder document: %v", err)
 	}
@@ -362,19 +791,63 @@ func GetFolderNameFromFirestore(ctx context.Context, folderID string) (string, e
 	if err := doc.DataTo(&folder); err != nil {
 		return "", fmt.Errorf("failed to unmarshal folder metadata: %v", err)
 	}
-	return folder.Name, nil
+	if !IsFolderVisible(ctx, &folder) {
+		return "", notFound("folder %s", folderID)
+	}
+	return folder.LocalizedName(lang), nil
 }
 
 // DeleteFileFromStorageAndFirestore deletes a file from Firebase Storage and its metadata from Firestore.
+// An object shared with other files (content-named paths, duplicated folders) is kept until
+// the last of them is deleted. Files under legal hold, or in a folder under it, are not deleted:
+// that is a LegalHoldError.
 func DeleteFileFromStorageAndFirestore(ctx context.Context, storagePath, firestoreDocID string) error {
+	return deleteStoredFile(ctx, storagePath, firestoreDocID, "")
+}
+
+// deleteStoredFile is DeleteFileFromStorageAndFirestore, recording reason with the removal in
+// the change log.
+func deleteStoredFile(ctx context.Context, storagePath, firestoreDocID, reason string) error {
+	// Other files with the same object, and this file's folder
+	sharing, err := Client.Collection(FilesCollection).Where("storagePath", "==", storagePath).Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to query files stored at %s: %v", storagePath, err)
+	}
+	folderID, fileName, bucketName := "", "", ""
+	held := false
+	buckets := make(map[string]int) // Files stored at storagePath by bucket
+	for _, doc := range sharing {
+		name, _ := doc.Data()["bucket"].(string)
+		if doc.Ref.ID == firestoreDocID {
+			folderID, _ = doc.Data()["folderId"].(string)
+			fileName, _ = doc.Data()["name"].(string)
+			held, _ = doc.Data()["legalHold"].(bool)
+			bucketName = name
+		} else {
+			buckets[name]++
+		}
+	}
+	if err := checkFileLegalHold(ctx, firestoreDocID, folderID, held); err != nil {
+		return err
+	}
+
 	// 1. Delete from Firebase Storage
-	bucket, err := StorageClient.DefaultBucket()
+	bucket, err := bucketHandle(bucketName)
 	if err != nil {
-		return fmt.Errorf("failed to get default storage bucket: %v", err)
+		return err
 	}
-	if err := bucket.Object(storagePath).Delete(ctx); err != nil {
-		return fmt.Errorf("failed to delete file from storage %s: %v", storagePath, err)
+	if buckets[bucketName] == 0 {
+		// An object already gone is a stale record, which deleting should clean up
+		if err := bucket.Object(storagePath).Delete(ctx); err != nil && err != gcs.ErrObjectNotExist {
+			return fmt.Errorf("failed to delete file from storage %s: %v", storagePath, err)
+		}
+	}
+	thumbnails, err := StorageClient.DefaultBucket()
+	if err != nil {
+		return fmt.Errorf("failed to get default storage bucket: %v", err)
 	}
+	deleteCachedThumbnails(ctx, thumbnails, firestoreDocID)
+	deleteFileFaces(ctx, firestoreDocID)
 
 	// 2. Delete from Firestore
 	_, err = Client.Collection(FilesCollection).Doc(firestoreDocID).Delete(ctx)
@@ -383,5 +856,59 @@ func DeleteFileFromStorageAndFirestore(ctx context.Context, storagePath, firesto
 	}
 
 	log.Printf("File %s deleted from Storage and Firestore.", storagePath)
+	recordFolderChange(ctx, folderID, FolderChange{Type: ChangeRemoved, FileID: firestoreDocID, Name: fileName, Reason: reason})
+	BroadcastEvent(EventFileDeleted, map[string]string{"id": firestoreDocID, "storagePath": storagePath, "folderId": folderID})
 	return nil
 }
+
+// maxBatchDeleteIDs is the number of files DeleteFolder removes per DeleteFilesByIDs call.
+const maxBatchDeleteIDs = 100
+
+// BatchDeleteResult reports the outcome of DeleteFilesByIDs.
+type BatchDeleteResult struct {
+	Deleted  []string          `json:"deleted"`  // IDs of files removed from Storage and Firestore
+	NotFound []string          `json:"notFound"` // IDs without a Firestore document
+	Failed   map[string]string `json:"failed"`   // Error messages keyed by ID
+}
+
+// DeleteFilesByIDs deletes several files from Firebase Storage and Firestore.
+// A failure on one file does not stop the others; per-file errors are reported in the result.
+func DeleteFilesByIDs(ctx context.Context, ids []string) (*BatchDeleteResult, error) {
+	result := &BatchDeleteResult{Deleted: []string{}, NotFound: []string{}, Failed: map[string]string{}}
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	refs := make([]*firestore.DocumentRef, len(ids))
+	for i, id := range ids {
+		refs[i] = Client.Collection(FilesCollection).Doc(id)
+	}
+	docs, err := Client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file metadata from Firestore: %v", err)
+	}
+
+	for i, doc := range docs {
+		id := ids[i]
+		if !doc.Exists() {
+			result.NotFound = append(result.NotFound, id)
+			continue
+		}
+		var file FileMetadata
+		if err := doc.DataTo(&file); err != nil {
+			result.Failed[id] = fmt.Sprintf("failed to unmarshal file metadata: %v", err)
+			continue
+		}
+		err := waitForWrite(ctx, refs[i])
+		if err == nil {
+			err = DeleteFileFromStorageAndFirestore(ctx, file.StoragePath, id)
+		}
+		if err != nil {
+			log.Printf("Error deleting file %s: %v", id, err)
+			result.Failed[id] = err.Error()
+			continue
+		}
+		result.Deleted = append(result.Deleted, id)
+	}
+	return result, nil
+}
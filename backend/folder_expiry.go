@@ -0,0 +1,201 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+const (
+	// EventFolderExpiring is broadcast the expiry warning lead before a folder's ExpireAt, so
+	// contributors can finish their uploads. Data: FolderMetadata.
+	EventFolderExpiring = "folder_expiring"
+	// EventFolderExpired is broadcast when the ExpireAt of a folder has come and its ExpireAction
+	// was applied. Data: FolderMetadata.
+	EventFolderExpired = "folder_expired"
+)
+
+// What happens to a folder when its ExpireAt has come.
+const (
+	FolderExpireArchive = "archive" // Archived: read-only, but still listed
+	FolderExpireHide    = "hide"    // Hidden from public viewers, like an embargoed folder
+)
+
+// FolderExpireActions are the valid values of FolderMetadata.ExpireAction.
+var FolderExpireActions = []string{FolderExpireArchive, FolderExpireHide}
+
+// DefaultFolderExpiryWarning is how long before its expiry EventFolderExpiring is sent, unless
+// SetFolderExpiryWarning changes it.
+const DefaultFolderExpiryWarning = 24 * time.Hour
+
+var expiryWarning = DefaultFolderExpiryWarning // Guarded by scheduledMu
+
+// SetFolderExpiryWarning sets how long before its expiry EventFolderExpiring is sent for a
+// folder. Zero sends no warnings.
+func SetFolderExpiryWarning(lead time.Duration) {
+	scheduledMu.Lock()
+	defer scheduledMu.Unlock()
+	expiryWarning = lead
+}
+
+func currentExpiryWarning() time.Duration {
+	scheduledMu.Lock()
+	defer scheduledMu.Unlock()
+	return expiryWarning
+}
+
+// IsHiddenByExpiry reports whether the folder has expired with FolderExpireHide.
+func (f *FolderMetadata) IsHiddenByExpiry() bool {
+	return f.ExpireAction == FolderExpireHide && f.ExpireAt != nil && !f.ExpireAt.After(now())
+}
+
+// SetFolderExpireAt makes a folder expire at expireAt, e.g. a fan-submission folder open for a
+// week: it is then archived or hidden from public viewers, depending on action (one of
+// FolderExpireActions; empty is FolderExpireArchive), by the folder scheduler, which sends
+// EventFolderExpiring beforehand. nil removes the expiry; a folder that already expired is not
+// unarchived. It returns the updated folder.
+func SetFolderExpireAt(ctx context.Context, folderID string, expireAt *time.Time, action string) (*FolderMetadata, error) {
+	if action == "" {
+		action = FolderExpireArchive
+	}
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil {
+		return nil, notFound("folder %s", folderID)
+	}
+	folder.ExpireAt = expireAt
+	folder.ExpireAction = ""
+	folder.ExpirePending = expireAt != nil
+	folder.ExpiryWarned = false
+	updates := []firestore.Update{
+		{Path: "expireAt", Value: firestore.Delete},
+		{Path: "expireAction", Value: firestore.Delete},
+		{Path: "expirePending", Value: firestore.Delete},
+		{Path: "expiryWarned", Value: firestore.Delete},
+	}
+	if expireAt != nil {
+		folder.ExpireAction = action
+		updates[0].Value = *expireAt
+		updates[1].Value = action
+		updates[2].Value = true
+	}
+	if _, err := Client.Collection(FoldersCollection).Doc(folderID).Update(ctx, updates); err != nil {
+		return nil, fmt.Errorf("failed to update folder %s: %v", folderID, err)
+	}
+	log.Printf("Folder %s expiry set at %v (%s)", folderID, expireAt, folder.ExpireAction)
+	recordFolderChange(ctx, folderID, FolderChange{Type: ChangeEdited, Name: folder.Name, Fields: []string{"expireAt"}})
+	if folder.isPubliclyVisible() {
+		BroadcastEvent(EventFolderUpdated, *folder)
+	}
+	if expireAt != nil {
+		scheduleFolderExpiry(folderID, *expireAt, currentSchedulerInterval())
+	}
+	return folder, nil
+}
+
+// scheduleDueExpiries sets timers for the expiry warnings and expiries due within horizon.
+func scheduleDueExpiries(ctx context.Context, horizon time.Duration) error {
+	docs, err := Client.Collection(FoldersCollection).Where("expirePending", "==", true).Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to query folders pending expiry: %v", err)
+	}
+	for _, doc := range docs {
+		var folder FolderMetadata
+		if err := doc.DataTo(&folder); err != nil {
+			log.Printf("Error reading folder %s pending expiry: %v", doc.Ref.ID, err)
+			continue
+		}
+		if folder.ExpireAt == nil {
+			continue
+		}
+		scheduleFolderExpiry(folder.ID, *folder.ExpireAt, horizon)
+	}
+	return nil
+}
+
+// scheduleFolderExpiry sets the timers of a folder's expiry warning and expiry, if due within
+// horizon.
+func scheduleFolderExpiry(folderID string, expireAt time.Time, horizon time.Duration) {
+	if lead := currentExpiryWarning(); lead > 0 {
+		scheduleFolderEvent(EventFolderExpiring, folderID, expireAt.Add(-lead), horizon, warnFolderExpiry)
+	}
+	scheduleFolderEvent(EventFolderExpired, folderID, expireAt, horizon, expireFolder)
+}
+
+// warnFolderExpiry claims the expiry warning of a folder, and sends EventFolderExpiring if this
+// call did. Folders whose expiry has already come get no warning.
+func warnFolderExpiry(ctx context.Context, folderID string) error {
+	lead := currentExpiryWarning()
+	var folder *FolderMetadata
+	ref := Client.Collection(FoldersCollection).Doc(folderID)
+	err := Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		folder = nil
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var f FolderMetadata
+		if err := doc.DataTo(&f); err != nil {
+			return err
+		}
+		if !f.ExpirePending || f.ExpiryWarned || f.ExpireAt == nil {
+			return nil // Already sent, or expiry removed
+		}
+		if now().Before(f.ExpireAt.Add(-lead)) || !now().Before(*f.ExpireAt) {
+			return nil // Rescheduled, or too late
+		}
+		folder = &f
+		return tx.Update(ref, []firestore.Update{{Path: "expiryWarned", Value: true}})
+	})
+	if err != nil || folder == nil {
+		return err
+	}
+	folder.ExpiryWarned = true
+	log.Printf("Folder %s expires at %v.", folderID, folder.ExpireAt)
+	BroadcastEvent(EventFolderExpiring, *folder)
+	return nil
+}
+
+// expireFolder claims the expiry of a folder whose ExpireAt has come and applies its
+// ExpireAction, then sends EventFolderExpired if this call did.
+func expireFolder(ctx context.Context, folderID string) error {
+	var folder *FolderMetadata
+	ref := Client.Collection(FoldersCollection).Doc(folderID)
+	err := Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		folder = nil
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var f FolderMetadata
+		if err := doc.DataTo(&f); err != nil {
+			return err
+		}
+		if !f.ExpirePending || f.ExpireAt == nil || f.ExpireAt.After(now()) {
+			return nil // Already expired, or rescheduled
+		}
+		folder = &f
+		updates := []firestore.Update{{Path: "expirePending", Value: firestore.Delete}}
+		if f.ExpireAction == FolderExpireArchive {
+			updates = append(updates, firestore.Update{Path: "archived", Value: true})
+		}
+		return tx.Update(ref, updates)
+	})
+	if err != nil || folder == nil {
+		return err
+	}
+	folder.ExpirePending = false
+	log.Printf("Folder %s expired (%s).", folderID, folder.ExpireAction)
+	if folder.ExpireAction == FolderExpireArchive && !folder.Archived {
+		folder.Archived = true
+		recordFolderChange(ctx, folderID, FolderChange{Type: ChangeEdited, Name: folder.Name, Fields: []string{"archived"}})
+		BroadcastEvent(EventFolderUpdated, *folder)
+	}
+	BroadcastEvent(EventFolderExpired, *folder)
+	return nil
+}
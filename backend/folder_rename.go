@@ -0,0 +1,282 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/drive/v3"
+)
+
+// JobRenameFolder is the Job type of the Storage moves of RenameFolder. Its Result holds the
+// "folderId" and "oldName".
+const JobRenameFolder = "rename_folder"
+
+// renameTimeout bounds the Storage moves of a rename running in the background.
+const renameTimeout = time.Hour
+
+// maxPreviousNames bounds the previous names and slugs kept per folder.
+const maxPreviousNames = 20
+
+// FolderRename is the outcome of RenameFolder.
+type FolderRename struct {
+	Folder  *FolderMetadata `json:"folder"`
+	OldName string          `json:"oldName"`
+	OldSlug string          `json:"oldSlug,omitempty"` // Set when the slug followed the name
+	// DriveFolderID is the folder of the same name in DRIVE_ROOT_FOLDER_ID renamed along; empty if none.
+	DriveFolderID string `json:"driveFolderId,omitempty"`
+}
+
+// RenameFolder renames a logical folder and everything derived from its name:
+//   - the old name is recorded in PreviousNames, so FindFolderByName still finds the folder by it
+//   - a slug generated from the old name is regenerated, and the old one recorded in PreviousSlugs
+//     for redirects (static exports use the slug as directory)
+//   - a Drive folder of the old name in DRIVE_ROOT_FOLDER_ID is renamed too
+//
+// Objects stored under the old name by the PathByFolderName strategy are moved afterwards with
+// MoveFolderNameObjects or StartFolderNameMoves; other strategies use the folder ID. Renaming to the current name changes nothing.
+func RenameFolder(ctx context.Context, folderID, newName string) (*FolderRename, error) {
+	newName = strings.TrimSpace(newName)
+	if newName == "" {
+		return nil, fmt.Errorf("folder name cannot be empty")
+	}
+	// Only current names conflict; a previous name of another folder can be taken over
+	existing, err := findFolderWhere(ctx, "name", "==", newName)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.ID != folderID {
+		return nil, &FolderExistsError{Name: newName}
+	}
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil {
+		return nil, notFound("folder %s", folderID)
+	}
+	rename := &FolderRename{Folder: folder, OldName: folder.Name}
+	if newName == folder.Name {
+		return rename, nil
+	}
+
+	folder.PreviousNames = rememberName(folder.PreviousNames, folder.Name, newName)
+	folder.Name = newName
+	updates := []firestore.Update{{Path: "name", Value: newName}, {Path: "previousNames", Value: folder.PreviousNames}}
+	fields := []string{"name"}
+	if folder.Slug != "" && slugFollows(folder.Slug, rename.OldName) {
+		slug, err := uniqueFolderSlug(ctx, Slugify(newName), folderID)
+		if err != nil {
+			return nil, err
+		}
+		if slug != folder.Slug {
+			rename.OldSlug = folder.Slug
+			folder.PreviousSlugs = rememberName(folder.PreviousSlugs, folder.Slug, slug)
+			folder.Slug = slug
+			updates = append(updates, firestore.Update{Path: "slug", Value: slug}, firestore.Update{Path: "previousSlugs", Value: folder.PreviousSlugs})
+			fields = append(fields, "slug")
+		}
+	}
+	if _, err := Client.Collection(FoldersCollection).Doc(folderID).Update(ctx, updates); err != nil {
+		return nil, fmt.Errorf("failed to rename folder %s: %v", folderID, err)
+	}
+	log.Printf("Folder %s renamed from '%s' to '%s'.", folderID, rename.OldName, newName)
+	recordFolderChange(ctx, folderID, FolderChange{Type: ChangeEdited, Name: newName, Fields: fields})
+	BroadcastEvent(EventFolderUpdated, *folder)
+
+	if rename.DriveFolderID, err = renameDriveFolder(ctx, rename.OldName, newName); err != nil {
+		log.Printf("Warning: Could not rename Drive folder '%s' to '%s': %v", rename.OldName, newName, err)
+	}
+	return rename, nil
+}
+
+// rememberName adds old to the end of names, dropping current (the folder may be renamed back)
+// and the oldest entries beyond maxPreviousNames.
+func rememberName(names []string, old, current string) []string {
+	var kept []string
+	for _, name := range names {
+		if name != old && name != current {
+			kept = append(kept, name)
+		}
+	}
+	kept = append(kept, old)
+	if len(kept) > maxPreviousNames {
+		kept = kept[len(kept)-maxPreviousNames:]
+	}
+	return kept
+}
+
+// slugFollows reports whether slug was generated from name by uniqueFolderSlug rather than set
+// by hand, so a rename should regenerate it.
+func slugFollows(slug, name string) bool {
+	base := Slugify(name)
+	if base == "" {
+		base = "folder"
+	}
+	if slug == base {
+		return true
+	}
+	suffix, ok := strings.CutPrefix(slug, base+"-")
+	return ok && suffix != "" && strings.Trim(suffix, "0123456789") == ""
+}
+
+// FindFolderByPreviousSlug returns the folder that had the given slug before it was renamed, or
+// nil if there is none, for redirecting old links.
+func FindFolderByPreviousSlug(ctx context.Context, slug string) (*FolderMetadata, error) {
+	return findFolderWhere(ctx, "previousSlugs", "array-contains", slug)
+}
+
+// renameDriveFolder renames the folder named oldName directly inside RootFolderID, if Drive is
+// configured and there is one, and returns its ID.
+func renameDriveFolder(ctx context.Context, oldName, newName string) (string, error) {
+	if DriveService == nil || RootFolderID == "" {
+		return "", nil
+	}
+	folders, err := ListFoldersInRootFolder(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range folders {
+		if f.Name == newName {
+			return "", fmt.Errorf("a Drive folder named '%s' already exists", newName)
+		}
+	}
+	for _, f := range folders {
+		if f.Name != oldName {
+			continue
+		}
+		err := callDrive(ctx, 1, func() error {
+			_, err := DriveService.Files.Update(f.ID, &drive.File{Name: newName}).SupportsAllDrives(true).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		log.Printf("Renamed Drive folder %s from '%s' to '%s'.", f.ID, oldName, newName)
+		return f.ID, nil
+	}
+	return "", nil
+}
+
+// folderNameFilesQuery selects the files of a folder stored by PathByFolderName.
+func folderNameFilesQuery(folderID string) firestore.Query {
+	return Client.Collection(FilesCollection).Where("folderId", "==", folderID).Where("pathStrategy", "==", PathByFolderName)
+}
+
+// MoveFolderNameObjects moves the objects of a folder's files stored by PathByFolderName under
+// an earlier name of the folder to its current name. onFile, if not nil, is called with every
+// such file and the error moving it. It returns the number of files moved.
+func MoveFolderNameObjects(ctx context.Context, folder *FolderMetadata, onFile func(file FileMetadata, err error)) (int, error) {
+	docs, err := folderNameFilesQuery(folder.ID).Documents(ctx).GetAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list files stored by folder name: %v", err)
+	}
+	moved := 0
+	for _, doc := range docs {
+		if ctx.Err() != nil {
+			break
+		}
+		var file FileMetadata
+		if err := doc.DataTo(&file); err != nil {
+			if onFile != nil {
+				onFile(FileMetadata{ID: doc.Ref.ID}, fmt.Errorf("failed to unmarshal file metadata: %v", err))
+			}
+			continue
+		}
+		ok, err := moveFolderNameObject(ctx, file, folder)
+		if err != nil {
+			log.Printf("Error moving file %s of renamed folder %s: %v", file.ID, folder.ID, err)
+		}
+		if ok {
+			moved++
+		}
+		if onFile != nil {
+			onFile(file, err)
+		}
+	}
+	log.Printf("Moved %d objects of folder %s to '%s'.", moved, folder.ID, folder.Name)
+	return moved, ctx.Err()
+}
+
+// StartFolderNameMoves runs MoveFolderNameObjects in the background after RenameFolder and
+// returns the Job tracking it, or nil if the folder has no files stored by folder name.
+func StartFolderNameMoves(ctx context.Context, rename *FolderRename) (*Job, error) {
+	folder := *rename.Folder
+	docs, err := folderNameFilesQuery(folder.ID).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query files stored by folder name: %v", err)
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	job, err := startJob(ctx, JobRenameFolder, map[string]string{"folderId": folder.ID, "oldName": rename.OldName})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), renameTimeout)
+		defer cancel()
+		if total, err := countDocuments(ctx, folderNameFilesQuery(folder.ID)); err == nil {
+			job.setTotal(ctx, int(total))
+		}
+		_, err := MoveFolderNameObjects(ctx, &folder, func(file FileMetadata, err error) {
+			job.itemDone(ctx, file.ID, err)
+		})
+		job.finish(ctx, err)
+	}()
+	return job, nil
+}
+
+// moveFolderNameObject moves the object of a file stored by PathByFolderName under the current
+// name of its folder, and then deletes the old object. It reports whether the object was moved;
+// objects already in place are not.
+func moveFolderNameObject(ctx context.Context, file FileMetadata, folder *FolderMetadata) (bool, error) {
+	relativePath := file.RelativePath
+	if relativePath == "" {
+		_, relativePath, _ = strings.Cut(file.StoragePath, "/") // Folder names never contain "/" in paths
+	}
+	storagePath := folderNamePath(folder.Name, relativePath)
+	if storagePath == file.StoragePath {
+		return false, nil
+	}
+	bucket, err := fileBucket(&file)
+	if err != nil {
+		return false, err
+	}
+	src, dst := bucket.Object(file.StoragePath), bucket.Object(storagePath)
+	attrs, err := dst.CopierFrom(src).Run(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to copy %s: %v", file.StoragePath, err)
+	}
+	deleteCopy := func() {
+		if delErr := dst.Delete(ctx); delErr != nil {
+			log.Printf("ERROR: Failed to delete orphaned storage object %s: %v", storagePath, delErr)
+		}
+	}
+	if downloadURLMode() == "public" && !folder.IsPrivate() {
+		if err := dst.ACL().Set(ctx, gcs.AllUsers, gcs.RoleReader); err != nil {
+			log.Printf("Warning: Could not set public ACL for file %s: %v", storagePath, err)
+		}
+	}
+	downloadURL, err := deriveDownloadURL(bucket, attrs)
+	if err != nil {
+		deleteCopy()
+		return false, err
+	}
+	if _, err := Client.Collection(FilesCollection).Doc(file.ID).Update(ctx, []firestore.Update{
+		{Path: "storagePath", Value: storagePath},
+		{Path: "relativePath", Value: relativePath},
+		{Path: "downloadUrl", Value: downloadURL},
+	}); err != nil {
+		deleteCopy()
+		return false, fmt.Errorf("failed to update file metadata: %v", err)
+	}
+	if err := src.Delete(ctx); err != nil && err != gcs.ErrObjectNotExist {
+		log.Printf("Warning: Could not delete moved object %s: %v", file.StoragePath, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/api/iterator"
+)
+
+// healthFailureThreshold is the number of consecutive failed checks after which the backend
+// reports not ready and rebuilds its Firebase clients.
+const healthFailureThreshold = 3
+
+// HealthStatus is the result of the background Firestore and Storage checks.
+type HealthStatus struct {
+	Ready               bool      `json:"ready"`
+	LastCheck           time.Time `json:"lastCheck"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastError           string    `json:"lastError,omitempty"`
+	Reinitializations   int       `json:"reinitializations"` // Successful client rebuilds since startup
+}
+
+var (
+	healthMu sync.Mutex
+	health   = HealthStatus{Ready: true}
+)
+
+// Health returns the current health status.
+func Health() HealthStatus {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	return health
+}
+
+// pingBackends reads one folder document and lists one Storage object.
+func pingBackends(ctx context.Context) error {
+	if _, err := Client.Collection(FoldersCollection).Limit(1).Documents(ctx).GetAll(); err != nil {
+		return fmt.Errorf("Firestore check failed: %v", err)
+	}
+	bucket, err := StorageClient.DefaultBucket()
+	if err != nil {
+		return fmt.Errorf("Storage check failed: %v", err)
+	}
+	it := bucket.Objects(ctx, nil)
+	it.PageInfo().MaxSize = 1
+	if _, err := it.Next(); err != nil && err != iterator.Done {
+		return fmt.Errorf("Storage check failed: %v", err)
+	}
+	return nil
+}
+
+// StartHealthMonitor checks Firestore and Storage every interval until ctx is cancelled.
+// After healthFailureThreshold consecutive failures the backend reports not ready, and every
+// further failed check rebuilds the clients with InitFirebase, which reloads credentials and
+// opens new gRPC connections, until a check succeeds again.
+func StartHealthMonitor(ctx context.Context, projectID, serviceAccountJSONPath string, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkCtx, cancel := context.WithTimeout(ctx, interval)
+				err := pingBackends(checkCtx)
+				cancel()
+				recordHealthCheck(err)
+				if err != nil && !Health().Ready {
+					reinitializeClients(ctx, projectID, serviceAccountJSONPath)
+				}
+			}
+		}
+	}()
+}
+
+// recordHealthCheck updates the health status with the result of a check.
+func recordHealthCheck(err error) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	health.LastCheck = now()
+	if err == nil {
+		if !health.Ready {
+			log.Printf("Health check succeeded again after %d failures, backend is ready", health.ConsecutiveFailures)
+		}
+		health.Ready = true
+		health.ConsecutiveFailures = 0
+		health.LastError = ""
+		return
+	}
+	health.ConsecutiveFailures++
+	health.LastError = err.Error()
+	log.Printf("ERROR: Health check failed (%d in a row): %v", health.ConsecutiveFailures, err)
+	if health.ConsecutiveFailures >= healthFailureThreshold {
+		health.Ready = false
+	}
+}
+
+// reinitializeClients replaces the Firebase app and clients. The old Firestore client is closed
+// once the new one is in place; requests still using it fail and are retried by their callers.
+func reinitializeClients(ctx context.Context, projectID, serviceAccountJSONPath string) {
+	log.Printf("Reinitializing Firebase clients after repeated health check failures")
+	oldClient := Client
+	if err := InitFirebase(ctx, projectID, serviceAccountJSONPath); err != nil {
+		log.Printf("ERROR: Failed to reinitialize Firebase clients: %v", err)
+		return
+	}
+	if oldClient != nil && oldClient != Client {
+		if err := oldClient.Close(); err != nil {
+			log.Printf("Warning: Could not close old Firestore client: %v", err)
+		}
+	}
+	healthMu.Lock()
+	health.Reinitializations++
+	healthMu.Unlock()
+}
@@ -0,0 +1,94 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"log"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// ImageInfo is what is derived from an image's pixels at upload and stored on its FileMetadata.
+type ImageInfo struct {
+	Color  string // Dominant color as "#rrggbb", painted as a placeholder while the thumbnail loads
+	PHash  string // Perceptual hash for finding near-duplicates (see PHashDistance)
+	Width  int
+	Height int
+}
+
+// colorSamples is about how many pixels per side AnalyzeImage samples for the dominant color.
+const colorSamples = 64
+
+// AnalyzeImage decodes a JPEG, PNG or GIF image and returns its ImageInfo. Formats without a
+// decoder return an *UnsupportedImageError.
+func AnalyzeImage(r io.Reader) (*ImageInfo, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, &UnsupportedImageError{Err: err}
+	}
+	b := img.Bounds()
+	if b.Empty() {
+		return nil, fmt.Errorf("image has no pixels")
+	}
+	return &ImageInfo{Color: dominantColor(img), PHash: perceptualHash(img), Width: b.Dx(), Height: b.Dy()}, nil
+}
+
+// dominantColor groups a grid of sampled pixels into 4096 color buckets (4 bits per channel) and
+// returns the average color of the fullest bucket, so a photo of a red car on grey asphalt gives
+// grey rather than the brownish mean of both. Transparent areas count as white.
+func dominantColor(img image.Image) string {
+	type bucket struct{ r, g, b, n uint64 }
+	var buckets [4096]bucket
+	b := img.Bounds()
+	stepX := max(1, b.Dx()/colorSamples)
+	stepY := max(1, b.Dy()/colorSamples)
+	for y := b.Min.Y; y < b.Max.Y; y += stepY {
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+			cr, cg, cb, ca := img.At(x, y).RGBA()
+			// Colors are alpha-premultiplied, so adding the missing alpha blends onto white
+			r8, g8, b8 := (cr+0xffff-ca)>>8, (cg+0xffff-ca)>>8, (cb+0xffff-ca)>>8
+			k := &buckets[(r8>>4)<<8|(g8>>4)<<4|b8>>4]
+			k.r += uint64(r8)
+			k.g += uint64(g8)
+			k.b += uint64(b8)
+			k.n++
+		}
+	}
+	best := &buckets[0]
+	for i := range buckets {
+		if buckets[i].n > best.n {
+			best = &buckets[i]
+		}
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.n, best.g/best.n, best.b/best.n)
+}
+
+// analyzeStoredImage returns the ImageInfo of an uploaded image, from content if the server has it
+// or else by reading the object. It returns nil for other files and for images that cannot be
+// decoded, which are stored without it.
+func analyzeStoredImage(ctx context.Context, bucket *gcs.BucketHandle, storagePath, mimeType string, content []byte) *ImageInfo {
+	if mediaTypeOf(mimeType) != "image" {
+		return nil
+	}
+	var r io.Reader
+	if content != nil {
+		r = bytes.NewReader(content)
+	} else {
+		reader, err := bucket.Object(storagePath).NewReader(ctx)
+		if err != nil {
+			log.Printf("Warning: Could not read %s to analyze it: %v", storagePath, err)
+			return nil
+		}
+		defer reader.Close()
+		r = reader
+	}
+	info, err := AnalyzeImage(r)
+	if err != nil {
+		log.Printf("Warning: Could not analyze image %s: %v", storagePath, err)
+		return nil
+	}
+	return info
+}
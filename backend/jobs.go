@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// JobsCollection holds the progress of long-running background operations, such as folder
+// duplication. Job documents are temporary and expire after JobTTL.
+const JobsCollection = "jobs"
+
+// JobTTL is how long a job's progress can be looked up after it started.
+const JobTTL = 7 * 24 * time.Hour
+
+// Job states.
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed" // The operation stopped; Error says why
+)
+
+// jobSaveInterval is the minimum time between progress writes of a running job.
+const jobSaveInterval = 2 * time.Second
+
+func init() {
+	RegisterTemporaryCollection(JobsCollection)
+}
+
+// Job is the progress of a background operation, for GET /api/jobs/{id}.
+type Job struct {
+	ID        string            `json:"id" firestore:"id"`
+	Type      string            `json:"type" firestore:"type"` // e.g. "duplicate_folder"
+	Status    string            `json:"status" firestore:"status"`
+	Total     int               `json:"total" firestore:"total"`
+	Done      int               `json:"done" firestore:"done"`
+	Failed    map[string]string `json:"failed,omitempty" firestore:"failed,omitempty"` // Error messages of failed items, keyed by ID
+	Error     string            `json:"error,omitempty" firestore:"error,omitempty"`
+	Result    map[string]string `json:"result,omitempty" firestore:"result,omitempty"` // Type-specific, e.g. {"folderId": ...}
+	CreatedAt time.Time         `json:"createdAt" firestore:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt" firestore:"updatedAt"`
+	ExpireAt  time.Time         `json:"-" firestore:"expireAt"`
+
+	mu    sync.Mutex
+	saved time.Time
+}
+
+// startJob creates and saves a running job.
+func startJob(ctx context.Context, jobType string, result map[string]string) (*Job, error) {
+	job := &Job{
+		ID:        newID(),
+		Type:      jobType,
+		Status:    JobRunning,
+		Result:    result,
+		CreatedAt: now(),
+		UpdatedAt: now(),
+		ExpireAt:  ExpiresAt(JobTTL),
+	}
+	if err := job.save(ctx); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// save writes the job. Callers other than startJob hold job.mu.
+func (j *Job) save(ctx context.Context) error {
+	j.UpdatedAt = now()
+	j.saved = j.UpdatedAt
+	if _, err := Client.Collection(JobsCollection).Doc(j.ID).Set(ctx, j); err != nil {
+		return fmt.Errorf("failed to save job %s: %v", j.ID, err)
+	}
+	return nil
+}
+
+// setTotal records the number of items the job processes.
+func (j *Job) setTotal(ctx context.Context, total int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Total = total
+	if err := j.save(ctx); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// itemDone records a processed item, with its error if it failed. Progress is saved at most
+// every jobSaveInterval.
+func (j *Job) itemDone(ctx context.Context, id string, itemErr error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Done++
+	if itemErr != nil {
+		if j.Failed == nil {
+			j.Failed = make(map[string]string)
+		}
+		j.Failed[id] = itemErr.Error()
+	}
+	if now().Sub(j.saved) >= jobSaveInterval {
+		if err := j.save(ctx); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+}
+
+// setResult records a type-specific result, saved with the next progress write.
+func (j *Job) setResult(key, value string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.Result == nil {
+		j.Result = make(map[string]string)
+	}
+	j.Result[key] = value
+}
+
+// finish saves the final state of the job: done, or failed with err.
+func (j *Job) finish(ctx context.Context, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Status = JobDone
+	if err != nil {
+		j.Status = JobFailed
+		j.Error = err.Error()
+	}
+	if saveErr := j.save(ctx); saveErr != nil {
+		log.Printf("Error: %v", saveErr)
+	}
+}
+
+// GetJob returns a job's progress. Jobs that do not exist or have expired are ErrNotFound.
+func GetJob(ctx context.Context, jobID string) (*Job, error) {
+	doc, err := Client.Collection(JobsCollection).Doc(jobID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, notFound("job %s", jobID)
+		}
+		return nil, fmt.Errorf("failed to get job %s: %v", jobID, err)
+	}
+	var job Job
+	if err := doc.DataTo(&job); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal job: %v", err)
+	}
+	return &job, nil
+}
@@ -0,0 +1,107 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/firestore"
+)
+
+// LegalHoldError is returned for deleting a file or folder under legal hold, or a file in a
+// folder under legal hold. Nothing is deleted until an admin clears the hold.
+type LegalHoldError struct {
+	FileID   string // Empty for a folder
+	FolderID string // Folder under hold; empty if only the file is
+}
+
+func (e *LegalHoldError) Error() string {
+	if e.FolderID != "" && e.FileID != "" {
+		return fmt.Sprintf("file %s is in folder %s, which is under legal hold", e.FileID, e.FolderID)
+	}
+	if e.FolderID != "" {
+		return fmt.Sprintf("folder %s is under legal hold", e.FolderID)
+	}
+	return fmt.Sprintf("file %s is under legal hold", e.FileID)
+}
+
+func (e *LegalHoldError) Is(target error) bool { return target == ErrConflict }
+
+// SetFileLegalHold places a file under legal hold, so it cannot be deleted, or clears the hold.
+// It returns the updated file.
+func SetFileLegalHold(ctx context.Context, fileID string, hold bool) (*FileMetadata, error) {
+	file, err := GetFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, notFound("file %s", fileID)
+	}
+	if file.LegalHold == hold {
+		return file, nil
+	}
+	file.LegalHold = hold
+	if _, err := Client.Collection(FilesCollection).Doc(fileID).Update(ctx, []firestore.Update{{Path: "legalHold", Value: legalHoldValue(hold)}}); err != nil {
+		return nil, fmt.Errorf("failed to update file %s: %v", fileID, err)
+	}
+	log.Printf("File %s legal hold: %t", fileID, hold)
+	recordFolderChange(ctx, file.FolderID, FolderChange{Type: ChangeEdited, FileID: fileID, Name: file.Name, Fields: []string{"legalHold"}})
+	return file, nil
+}
+
+// SetFolderLegalHold places a folder and all of its files under legal hold, or clears the hold
+// of the folder; holds of single files stay. It returns the updated folder.
+func SetFolderLegalHold(ctx context.Context, folderID string, hold bool) (*FolderMetadata, error) {
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil {
+		return nil, notFound("folder %s", folderID)
+	}
+	if folder.LegalHold == hold {
+		return folder, nil
+	}
+	folder.LegalHold = hold
+	if _, err := Client.Collection(FoldersCollection).Doc(folderID).Update(ctx, []firestore.Update{{Path: "legalHold", Value: legalHoldValue(hold)}}); err != nil {
+		return nil, fmt.Errorf("failed to update folder %s: %v", folderID, err)
+	}
+	log.Printf("Folder %s legal hold: %t", folderID, hold)
+	recordFolderChange(ctx, folderID, FolderChange{Type: ChangeEdited, Name: folder.Name, Fields: []string{"legalHold"}})
+	BroadcastEvent(EventFolderUpdated, *folder)
+	return folder, nil
+}
+
+// legalHoldValue is what the legalHold field is set to: true, or deleted when cleared.
+func legalHoldValue(hold bool) interface{} {
+	if hold {
+		return true
+	}
+	return firestore.Delete
+}
+
+// checkFolderLegalHold returns a LegalHoldError if the folder is under legal hold.
+func checkFolderLegalHold(ctx context.Context, folderID string) error {
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return err
+	}
+	if folder != nil && folder.LegalHold {
+		return &LegalHoldError{FolderID: folderID}
+	}
+	return nil
+}
+
+// checkFileLegalHold returns a LegalHoldError if the file or its folder is under legal hold.
+func checkFileLegalHold(ctx context.Context, fileID, folderID string, held bool) error {
+	if held {
+		return &LegalHoldError{FileID: fileID}
+	}
+	if err := checkFolderLegalHold(ctx, folderID); err != nil {
+		if holdErr, ok := err.(*LegalHoldError); ok {
+			holdErr.FileID = fileID
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package backend
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+var (
+	manifestKeyOnce sync.Once
+	manifestKey     ed25519.PrivateKey
+)
+
+// manifestSigningKey returns the Ed25519 key signing folder manifests, from the base64 32-byte
+// seed in MANIFEST_SIGNING_KEY. Without it a random key is used, so manifests only verify
+// against the public key of the instance that issued them until it restarts.
+func manifestSigningKey() ed25519.PrivateKey {
+	manifestKeyOnce.Do(func() {
+		if seed := os.Getenv("MANIFEST_SIGNING_KEY"); seed != "" {
+			b, err := base64.StdEncoding.DecodeString(seed)
+			if err != nil || len(b) != ed25519.SeedSize {
+				log.Fatalf("MANIFEST_SIGNING_KEY must be a base64-encoded %d-byte seed", ed25519.SeedSize)
+			}
+			manifestKey = ed25519.NewKeyFromSeed(b)
+			return
+		}
+		log.Printf("WARNING: MANIFEST_SIGNING_KEY is not set; folder manifests are signed with a key that changes when this instance restarts")
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatalf("Failed to generate manifest signing key: %v", err)
+		}
+		manifestKey = key
+	})
+	return manifestKey
+}
+
+// ManifestPublicKey returns the base64 Ed25519 public key folder manifests are signed with.
+func ManifestPublicKey() string {
+	return base64.StdEncoding.EncodeToString(manifestSigningKey().Public().(ed25519.PublicKey))
+}
+
+// FolderManifest is a signed receipt of the content of a folder: what was delivered, with the
+// hash and size of every file, so an uploader can later prove it and check that nothing changed.
+type FolderManifest struct {
+	FolderID    string         `json:"folderId"`
+	FolderName  string         `json:"folderName"`
+	Since       *time.Time     `json:"since,omitempty"` // Only files uploaded from then on are listed
+	GeneratedAt time.Time      `json:"generatedAt"`
+	FileCount   int            `json:"fileCount"`
+	TotalBytes  int64          `json:"totalBytes"`
+	Files       []ManifestFile `json:"files"`               // Oldest first
+	PublicKey   string         `json:"publicKey"`           // Base64 Ed25519 public key of the signature
+	Signature   string         `json:"signature,omitempty"` // Base64 Ed25519 signature of the manifest encoded as JSON without it
+}
+
+// ManifestFile is a file as listed in a FolderManifest.
+type ManifestFile struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	RelativePath string    `json:"relativePath,omitempty"`
+	SHA256       string    `json:"sha256"`
+	Size         int64     `json:"size"`
+	URL          string    `json:"url"` // DownloadURL for public folders in DOWNLOAD_URL_MODE=public, the media path otherwise
+	UploaderUID  string    `json:"uploaderUid,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// signedBytes returns what the signature of m covers: m encoded as JSON without its signature.
+func (m FolderManifest) signedBytes() ([]byte, error) {
+	m.Signature = ""
+	return json.Marshal(m)
+}
+
+// BuildFolderManifest lists the files of a folder uploaded at or after since (all of them for the
+// zero time) into a signed FolderManifest. Files awaiting approval are only listed for moderators
+// (see WithModerator), and files showing hidden people not for public viewers. A missing folder,
+// or one hidden from the viewer of ctx (see IsFolderVisible), is ErrNotFound.
+func BuildFolderManifest(ctx context.Context, folderID string, since time.Time) (*FolderManifest, error) {
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil || !IsFolderVisible(ctx, folder) {
+		return nil, notFound("folder %s", folderID)
+	}
+	files, err := ListAllFilesInFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	withheld, err := fileWithheld(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &FolderManifest{FolderID: folderID, FolderName: folder.Name, GeneratedAt: now(), Files: []ManifestFile{}, PublicKey: ManifestPublicKey()}
+	if !since.IsZero() {
+		manifest.Since = &since
+	}
+	public := downloadURLMode() == "public" && !folder.IsPrivate()
+	for _, f := range files {
+		if f.CreatedAt.Before(since) || withheld(f) {
+			continue
+		}
+		file := ManifestFile{ID: f.ID, Name: f.Name, RelativePath: f.RelativePath, SHA256: f.Hash, Size: f.Size, URL: MediaURL(f.ID, false), UploaderUID: f.UploaderUID, CreatedAt: f.CreatedAt}
+		if public {
+			file.URL = f.DownloadURL
+		}
+		manifest.Files = append(manifest.Files, file)
+		manifest.TotalBytes += f.Size
+	}
+	slices.SortFunc(manifest.Files, func(a, b ManifestFile) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	manifest.FileCount = len(manifest.Files)
+
+	data, err := manifest.signedBytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest of folder %s: %v", folderID, err)
+	}
+	manifest.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(manifestSigningKey(), data))
+	return manifest, nil
+}
+
+// ManifestVerification is the result of VerifyFolderManifest.
+type ManifestVerification struct {
+	Valid   bool     `json:"valid"`   // The signature is ours and covers the manifest as given
+	Changed []string `json:"changed"` // IDs of listed files whose content hash or size differs now
+	Missing []string `json:"missing"` // IDs of listed files that no longer exist
+}
+
+// VerifyFolderManifest checks the signature of a manifest against the current signing key and,
+// if it is valid, compares the listed files with what is stored now.
+func VerifyFolderManifest(ctx context.Context, manifest *FolderManifest) (*ManifestVerification, error) {
+	result := &ManifestVerification{Changed: []string{}, Missing: []string{}}
+	signature, err := base64.StdEncoding.DecodeString(manifest.Signature)
+	if err != nil || manifest.PublicKey != ManifestPublicKey() {
+		return result, nil
+	}
+	data, err := manifest.signedBytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	if result.Valid = ed25519.Verify(manifestSigningKey().Public().(ed25519.PublicKey), data, signature); !result.Valid {
+		return result, nil
+	}
+	const batchSize = 100
+	for start := 0; start < len(manifest.Files); start += batchSize {
+		listed := manifest.Files[start:min(start+batchSize, len(manifest.Files))]
+		refs := make([]*firestore.DocumentRef, len(listed))
+		for i, f := range listed {
+			refs[i] = Client.Collection(FilesCollection).Doc(f.ID)
+		}
+		docs, err := Client.GetAll(ctx, refs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get files: %v", err)
+		}
+		for i, doc := range docs {
+			var file FileMetadata
+			if !doc.Exists() {
+				result.Missing = append(result.Missing, listed[i].ID)
+			} else if err := doc.DataTo(&file); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal file metadata: %v", err)
+			} else if file.Hash != listed[i].SHA256 || file.Size != listed[i].Size {
+				result.Changed = append(result.Changed, listed[i].ID)
+			}
+		}
+	}
+	return result, nil
+}
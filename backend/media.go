@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// MediaObject describes the stored object of a file, as served by the media proxy.
+type MediaObject struct {
+	ContentType string
+	Size        int64
+	Generation  int64     // Changes whenever the object is rewritten; used as the ETag
+	Updated     time.Time // Last-Modified
+}
+
+// StatFileObject returns the current state of a file's stored object.
+func StatFileObject(ctx context.Context, file *FileMetadata) (*MediaObject, error) {
+	bucket, err := fileBucket(file)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := bucket.Object(file.StoragePath).Attrs(ctx)
+	if err == gcs.ErrObjectNotExist {
+		return nil, notFound("object %s", file.StoragePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attributes of %s: %v", file.StoragePath, err)
+	}
+	contentType := attrs.ContentType
+	if contentType == "" {
+		contentType = file.MimeType
+	}
+	return &MediaObject{ContentType: contentType, Size: attrs.Size, Generation: attrs.Generation, Updated: attrs.Updated}, nil
+}
+
+// OpenFileObject reads length bytes (-1 for the rest) from offset of the given generation of a
+// file's object, so a response stays consistent with the ETag it was sent with even if the
+// object is replaced meanwhile.
+func OpenFileObject(ctx context.Context, file *FileMetadata, generation, offset, length int64) (io.ReadCloser, error) {
+	bucket, err := fileBucket(file)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := bucket.Object(file.StoragePath).Generation(generation).NewRangeReader(ctx, offset, length)
+	if err == gcs.ErrObjectNotExist {
+		return nil, notFound("object %s generation %d", file.StoragePath, generation)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", file.StoragePath, err)
+	}
+	return reader, nil
+}
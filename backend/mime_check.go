@@ -0,0 +1,233 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// JobReconcileMimeTypes is the Job type of StartMimeTypeReconciliation. Its Result holds the
+// "corrected" and "flagged" counts when it is done.
+const JobReconcileMimeTypes = "reconcile_mime_types"
+
+// mimeSniffLength is the number of leading bytes http.DetectContentType considers.
+const mimeSniffLength = 512
+
+// mimeReconcileTimeout bounds a reconciliation running in the background.
+const mimeReconcileTimeout = time.Hour
+
+// mimeReconcileBatch is the number of files checked, and corrected with one BulkUpdateFiles call,
+// at a time.
+const mimeReconcileBatch = 200
+
+// MimeCheck compares the declared MIME type of a file with its extension and content.
+type MimeCheck struct {
+	Declared  string `json:"declared"`
+	Extension string `json:"extension,omitempty"` // Type of the file name's extension; empty if unknown
+	Sniffed   string `json:"sniffed,omitempty"`   // Type detected from the content; empty if not recognized
+	Corrected string `json:"corrected,omitempty"` // The type to store instead of Declared; empty if Declared stands
+	Mismatch  string `json:"mismatch,omitempty"`  // Describes the disagreement of the three; empty if they agree
+}
+
+// baseMimeType strips parameters such as charset from a MIME type.
+func baseMimeType(mimeType string) string {
+	if base, _, err := mime.ParseMediaType(mimeType); err == nil {
+		return base
+	}
+	return strings.ToLower(strings.TrimSpace(mimeType))
+}
+
+// sniffMimeType detects the type of content from its leading bytes. Only image, video and
+// audio signatures are trusted: anything else (plain text, XML such as SVG, unknown binary) is
+// reported as unrecognized, so a more specific declared type is never replaced by a generic one.
+func sniffMimeType(head []byte) string {
+	sniffed := baseMimeType(http.DetectContentType(head))
+	for _, prefix := range []string{"image/", "video/", "audio/"} {
+		if strings.HasPrefix(sniffed, prefix) {
+			return sniffed
+		}
+	}
+	return ""
+}
+
+// checkMimeType compares the declared type of the file name with the type of its extension and
+// the type sniffed from head, its leading bytes. Recognized content wins; without it, a missing or
+// generic declared type is replaced by the extension's.
+func checkMimeType(name, declared string, head []byte) MimeCheck {
+	check := MimeCheck{
+		Declared:  declared,
+		Extension: baseMimeType(mime.TypeByExtension(strings.ToLower(path.Ext(name)))),
+		Sniffed:   sniffMimeType(head),
+	}
+	base := baseMimeType(declared)
+	switch {
+	case check.Sniffed != "" && check.Sniffed != base:
+		check.Corrected = check.Sniffed
+	case check.Sniffed == "" && check.Extension != "" && (base == "" || base == "application/octet-stream"):
+		check.Corrected = check.Extension
+	}
+
+	var known []string
+	for _, t := range []string{base, check.Extension, check.Sniffed} {
+		if t != "" && t != "application/octet-stream" {
+			known = append(known, t)
+		}
+	}
+	for i := 1; i < len(known); i++ {
+		if known[i] != known[0] {
+			check.Mismatch = fmt.Sprintf("extension %q (%s), declared %s, content %s", path.Ext(name), orUnknown(check.Extension), orUnknown(declared), orUnknown(check.Sniffed))
+			break
+		}
+	}
+	return check
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// reconcileUploadMimeType is the StageTransform hook that corrects the declared type of an
+// upload from its content before it is stored, and flags disagreements on the file.
+func reconcileUploadMimeType(ctx context.Context, u *Upload) error {
+	if u.Content == nil {
+		return nil // Direct uploads are checked by StartMimeTypeReconciliation
+	}
+	check := checkMimeType(u.RelativePath, u.MimeType, u.Content[:min(len(u.Content), mimeSniffLength)])
+	if check.Corrected != "" {
+		log.Printf("Correcting MIME type of upload %s from '%s' to '%s'", u.RelativePath, u.MimeType, check.Corrected)
+		u.MimeType = check.Corrected
+	}
+	u.MimeMismatch = check.Mismatch
+	return nil
+}
+
+// CheckStoredMimeType reads the leading bytes of a file's object and compares its declared type
+// with its extension and content.
+func CheckStoredMimeType(ctx context.Context, file *FileMetadata) (*MimeCheck, error) {
+	bucket, err := fileBucket(file)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := bucket.Object(file.StoragePath).NewRangeReader(ctx, 0, mimeSniffLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", file.StoragePath, err)
+	}
+	defer reader.Close()
+	head, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", file.StoragePath, err)
+	}
+	check := checkMimeType(file.Name, file.MimeType, head)
+	return &check, nil
+}
+
+// ReconcileMimeTypes re-sniffs the stored objects of a folder's files, or of all files for
+// folderID "", and corrects wrong mimeType values in Firestore, replacing what "drive-gallery
+// metadata fix" does from local copies. Files whose extension, declared and sniffed types disagree
+// get mimeMismatch set, and it is cleared once they agree. With dryRun set nothing is written.
+// onFile, if not nil, is called with the outcome of every file. It returns the number of files
+// corrected and flagged.
+func ReconcileMimeTypes(ctx context.Context, folderID string, dryRun bool, onFile func(file FileMetadata, check *MimeCheck, err error)) (int, int, error) {
+	query := Client.Collection(FilesCollection).Query
+	if folderID != "" {
+		query = query.Where("folderId", "==", folderID)
+	}
+	query = query.OrderBy(firestore.DocumentID, firestore.Asc)
+
+	// Pages are read one at a time, as checking a page takes longer than a query stream may stay open
+	corrected, flagged := 0, 0
+	err := pagedQuery(ctx, query, mimeReconcileBatch, 0, func(docs []*firestore.DocumentSnapshot) error {
+		var updates []FileFieldUpdate
+		for _, doc := range docs {
+			var file FileMetadata
+			if err := doc.DataTo(&file); err != nil {
+				if onFile != nil {
+					onFile(FileMetadata{ID: doc.Ref.ID}, nil, fmt.Errorf("failed to unmarshal file metadata: %v", err))
+				}
+				continue
+			}
+			check, err := CheckStoredMimeType(ctx, &file)
+			if onFile != nil {
+				onFile(file, check, err)
+			}
+			if err != nil {
+				continue
+			}
+
+			fields := make(map[string]interface{})
+			if check.Corrected != "" {
+				fields["mimeType"] = check.Corrected
+				corrected++
+			}
+			if check.Mismatch != "" {
+				flagged++
+			}
+			if check.Mismatch != file.MimeMismatch {
+				if check.Mismatch == "" {
+					fields["mimeMismatch"] = firestore.Delete
+				} else {
+					fields["mimeMismatch"] = check.Mismatch
+				}
+			}
+			if len(fields) > 0 {
+				updates = append(updates, FileFieldUpdate{ID: doc.Ref.ID, Fields: fields})
+			}
+		}
+		if !dryRun && len(updates) > 0 {
+			for id, msg := range BulkUpdateFiles(ctx, updates) {
+				log.Printf("Error reconciling MIME type of file %s: %s", id, msg)
+			}
+		}
+		return nil
+	})
+	log.Printf("MIME type reconciliation of folder '%s' (dry run: %t): %d corrected, %d flagged.", folderID, dryRun, corrected, flagged)
+	return corrected, flagged, err
+}
+
+// StartMimeTypeReconciliation runs ReconcileMimeTypes in the background and returns the Job
+// tracking it. Files that cannot be read are recorded as failed items.
+func StartMimeTypeReconciliation(ctx context.Context, folderID string, dryRun bool) (*Job, error) {
+	if folderID != "" {
+		folder, err := GetFolder(ctx, folderID)
+		if err != nil {
+			return nil, err
+		}
+		if folder == nil {
+			return nil, notFound("folder %s", folderID)
+		}
+	}
+	job, err := startJob(ctx, JobReconcileMimeTypes, map[string]string{"folderId": folderID, "dryRun": strconv.FormatBool(dryRun)})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mimeReconcileTimeout)
+		defer cancel()
+		query := Client.Collection(FilesCollection).Query
+		if folderID != "" {
+			query = query.Where("folderId", "==", folderID)
+		}
+		if total, err := countDocuments(ctx, query); err == nil {
+			job.setTotal(ctx, int(total))
+		}
+		corrected, flagged, err := ReconcileMimeTypes(ctx, folderID, dryRun, func(file FileMetadata, check *MimeCheck, err error) {
+			job.itemDone(ctx, file.ID, err)
+		})
+		job.setResult("corrected", strconv.Itoa(corrected))
+		job.setResult("flagged", strconv.Itoa(flagged))
+		job.finish(ctx, err)
+	}()
+	return job, nil
+}
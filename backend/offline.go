@@ -0,0 +1,128 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Budgets of an offline manifest, in bytes.
+const (
+	DefaultOfflineBudget = 200 << 20
+	MaxOfflineBudget     = 2 << 30
+)
+
+// estimatedThumbnailBytes is what a 320-pixel JPEG thumbnail is counted as against the budget;
+// their real size is only known once they are generated.
+const estimatedThumbnailBytes = 30 << 10
+
+// Kinds of OfflineEntry.
+const (
+	OfflineThumbnail = "thumbnail"
+	OfflineOriginal  = "original"
+)
+
+// OfflineEntry is a URL for a service worker to precache.
+type OfflineEntry struct {
+	URL      string `json:"url"`      // Backend path, signed for private folders
+	Revision string `json:"revision"` // Changes whenever the content does, so caches know when to refetch
+	FileID   string `json:"fileId"`
+	Kind     string `json:"kind"`             // OfflineThumbnail or OfflineOriginal
+	Size     int64  `json:"size"`             // Bytes; estimated for thumbnails, 0 for originals stored before sizes were
+	SHA256   string `json:"sha256,omitempty"` // Of the original's content, to verify it
+}
+
+// OfflineManifest lists what to precache to view a folder offline, within a size budget.
+type OfflineManifest struct {
+	FolderID  string         `json:"folderId"`
+	Entries   []OfflineEntry `json:"entries"`
+	Bytes     int64          `json:"bytes"` // Sum of the entries' sizes
+	Budget    int64          `json:"budget"`
+	Skipped   []string       `json:"skipped"`             // IDs of files whose entry did not fit the budget
+	ExpiresAt *time.Time     `json:"expiresAt,omitempty"` // For private folders, when the signed URLs expire; fetch them before
+}
+
+// offlineRevision identifies the content of a file: its hash, or its upload time for the few
+// files stored before hashes were.
+func offlineRevision(file *FileMetadata) string {
+	if file.Hash != "" {
+		return file.Hash
+	}
+	return strconv.FormatInt(file.CreatedAt.UnixNano(), 10)
+}
+
+// BuildOfflineManifest lists the thumbnails of a folder's images and the originals of the files
+// in originals, in that order, until budget bytes are used up. Thumbnails come first, as they make
+// the folder browsable offline; originals are taken in the order given, skipping those that do
+// not fit. A missing folder is ErrNotFound, and so are originals hidden from a public viewer (see
+// WithPublicViewer).
+func BuildOfflineManifest(ctx context.Context, folderID string, budget int64, originals []string) (*OfflineManifest, error) {
+	if budget <= 0 {
+		budget = DefaultOfflineBudget
+	}
+	budget = min(budget, MaxOfflineBudget)
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil || !IsFolderVisible(ctx, folder) {
+		return nil, notFound("folder %s", folderID)
+	}
+	files, err := ListAllFilesInFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	hidden, err := hiddenPeopleFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files = withoutHiddenPeople(files, hidden)
+	if err := AttachAccessURLs(folder, files); err != nil {
+		return nil, fmt.Errorf("failed to build URLs of folder %s: %v", folderID, err)
+	}
+
+	manifest := &OfflineManifest{FolderID: folderID, Entries: []OfflineEntry{}, Budget: budget, Skipped: []string{}}
+	add := func(entry OfflineEntry) {
+		if manifest.Bytes+entry.Size > budget {
+			manifest.Skipped = append(manifest.Skipped, entry.FileID)
+			return
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+		manifest.Bytes += entry.Size
+	}
+	byID := make(map[string]*FileMetadata, len(files))
+	for i := range files {
+		file := &files[i]
+		byID[file.ID] = file
+		if file.ThumbnailURL == "" {
+			continue
+		}
+		add(OfflineEntry{
+			URL:      file.ThumbnailURL,
+			Revision: fmt.Sprintf("%s-%d", offlineRevision(file), ThumbnailSize), // Same as the thumbnail's ETag
+			FileID:   file.ID,
+			Kind:     OfflineThumbnail,
+			Size:     estimatedThumbnailBytes,
+		})
+	}
+	for _, id := range originals {
+		file, ok := byID[id]
+		if !ok {
+			return nil, notFound("file %s in folder %s", id, folderID)
+		}
+		add(OfflineEntry{
+			URL:      file.MediaURL,
+			Revision: offlineRevision(file),
+			FileID:   file.ID,
+			Kind:     OfflineOriginal,
+			Size:     file.Size,
+			SHA256:   file.Hash,
+		})
+	}
+	if folder.IsPrivate() {
+		expires := signedURLExpiry(now())
+		manifest.ExpiresAt = &expires
+	}
+	return manifest, nil
+}
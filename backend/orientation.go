@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// JobNormalizeOrientation is the Job type of StartOrientationNormalization. Its Result holds the
+// "found" and "normalized" counts when it is done.
+const JobNormalizeOrientation = "normalize_orientation"
+
+// Ways NormalizeOrientations deals with sideways images.
+const (
+	// OrientationRewrite turns the pixels upright with TransformFile, which drops the EXIF
+	// orientation, so every viewer shows the original upright. The bucket needs Object Versioning.
+	OrientationRewrite = "rewrite"
+	// OrientationThumbnails records the orientation on the file so its thumbnails and renditions
+	// are rendered upright; the original is left as it is.
+	OrientationThumbnails = "thumbnails"
+)
+
+// orientationTimeout bounds a normalization running in the background.
+const orientationTimeout = 2 * time.Hour
+
+// orientationBatch is the number of files checked at a time.
+const orientationBatch = 200
+
+// NormalizeOrientations reads the EXIF orientation of the JPEG images of a folder, or of all
+// files for folderID "", and deals with those not stored upright according to mode
+// (OrientationRewrite or OrientationThumbnails). With dryRun set nothing is changed. onFile, if
+// not nil, is called with every file checked and its orientation (0 if unknown). It returns the
+// number of sideways images found and normalized.
+func NormalizeOrientations(ctx context.Context, folderID, mode string, dryRun bool, onFile func(file FileMetadata, orientation int, err error)) (int, int, error) {
+	query := Client.Collection(FilesCollection).Where("mimeType", "==", "image/jpeg")
+	if folderID != "" {
+		query = query.Where("folderId", "==", folderID)
+	}
+	query = query.OrderBy(firestore.DocumentID, firestore.Asc)
+
+	// Pages are read one at a time, as rewriting a page takes longer than a query stream may stay open
+	found, normalized := 0, 0
+	err := pagedQuery(ctx, query, orientationBatch, 0, func(docs []*firestore.DocumentSnapshot) error {
+		for _, doc := range docs {
+			var file FileMetadata
+			if err := doc.DataTo(&file); err != nil {
+				if onFile != nil {
+					onFile(FileMetadata{ID: doc.Ref.ID}, 0, fmt.Errorf("failed to unmarshal file metadata: %v", err))
+				}
+				continue
+			}
+			orientation, changed, err := normalizeOrientation(ctx, &file, mode, dryRun)
+			if onFile != nil {
+				onFile(file, orientation, err)
+			}
+			if orientation > 1 {
+				found++
+			}
+			if changed {
+				normalized++
+			}
+		}
+		return nil
+	})
+	log.Printf("Orientation normalization (%s) of folder '%s' (dry run: %t): %d sideways, %d normalized.", mode, folderID, dryRun, found, normalized)
+	return found, normalized, err
+}
+
+// normalizeOrientation normalizes one image, returning its EXIF orientation and whether it was changed.
+func normalizeOrientation(ctx context.Context, file *FileMetadata, mode string, dryRun bool) (int, bool, error) {
+	bucket, err := fileBucket(file)
+	if err != nil {
+		return 0, false, err
+	}
+	info := storedEXIF(ctx, bucket, file.StoragePath, file.MimeType, nil)
+	if info == nil || info.Orientation <= 1 {
+		// Upright, or already rewritten; a stale orientation would turn the thumbnails sideways
+		if file.Orientation != 0 && !dryRun {
+			if _, err := Client.Collection(FilesCollection).Doc(file.ID).Update(ctx, []firestore.Update{{Path: "orientation", Value: firestore.Delete}}); err != nil {
+				return 0, false, fmt.Errorf("failed to clear orientation: %v", err)
+			}
+			deleteFileThumbnails(ctx, file.ID)
+			return 1, true, nil
+		}
+		return 1, false, nil
+	}
+	if dryRun {
+		return info.Orientation, false, nil
+	}
+	if mode == OrientationRewrite {
+		if _, err := TransformFile(ctx, file.ID, nil); err != nil {
+			return info.Orientation, false, err
+		}
+		return info.Orientation, true, nil
+	}
+	if file.Orientation == info.Orientation {
+		return info.Orientation, false, nil
+	}
+	if _, err := Client.Collection(FilesCollection).Doc(file.ID).Update(ctx, []firestore.Update{{Path: "orientation", Value: info.Orientation}}); err != nil {
+		return info.Orientation, false, fmt.Errorf("failed to record orientation: %v", err)
+	}
+	deleteFileThumbnails(ctx, file.ID)
+	return info.Orientation, true, nil
+}
+
+// deleteFileThumbnails discards the cached thumbnails of a file, so they are rendered again.
+func deleteFileThumbnails(ctx context.Context, fileID string) {
+	if bucket, err := bucketHandle(""); err == nil {
+		deleteCachedThumbnails(ctx, bucket, fileID)
+	}
+}
+
+// StartOrientationNormalization runs NormalizeOrientations in the background and returns the Job
+// tracking it. Files that cannot be read or rewritten are recorded as failed items.
+func StartOrientationNormalization(ctx context.Context, folderID, mode string, dryRun bool) (*Job, error) {
+	if mode != OrientationRewrite && mode != OrientationThumbnails {
+		return nil, fmt.Errorf("invalid mode '%s' (expected %s or %s)", mode, OrientationRewrite, OrientationThumbnails)
+	}
+	if folderID != "" {
+		folder, err := GetFolder(ctx, folderID)
+		if err != nil {
+			return nil, err
+		}
+		if folder == nil {
+			return nil, notFound("folder %s", folderID)
+		}
+	}
+	job, err := startJob(ctx, JobNormalizeOrientation, map[string]string{"folderId": folderID, "mode": mode, "dryRun": strconv.FormatBool(dryRun)})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), orientationTimeout)
+		defer cancel()
+		query := Client.Collection(FilesCollection).Where("mimeType", "==", "image/jpeg")
+		if folderID != "" {
+			query = query.Where("folderId", "==", folderID)
+		}
+		if total, err := countDocuments(ctx, query); err == nil {
+			job.setTotal(ctx, int(total))
+		}
+		found, normalized, err := NormalizeOrientations(ctx, folderID, mode, dryRun, func(file FileMetadata, orientation int, err error) {
+			job.itemDone(ctx, file.ID, err)
+		})
+		job.setResult("found", strconv.Itoa(found))
+		job.setResult("normalized", strconv.Itoa(normalized))
+		job.finish(ctx, err)
+	}()
+	return job, nil
+}
@@ -0,0 +1,330 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WebhooksCollection holds the outgoing webhooks admins registered, by ID.
+const WebhooksCollection = "webhooks"
+
+// EventWebhookPing is sent only by TestWebhook, to check a receiver. Data: {"webhookId"}.
+const EventWebhookPing = "ping"
+
+// WebhookEventTypes are the events outgoing webhooks can subscribe to: the file and folder
+// events of the WebSocket hub, and reports of files for moderators.
+var WebhookEventTypes = []string{
+	EventFileUploaded, EventFilesUploaded, EventFileDeleted, EventFileReported,
+	EventFolderCreated, EventFolderUpdated, EventFolderPublished, EventFolderExpiring, EventFolderExpired,
+}
+
+// Delivery of outgoing webhooks: each attempt times out after webhookTimeout, and failed attempts
+// (network errors, 429 and 5xx answers) are retried with exponential backoff.
+const (
+	webhookTimeout        = 10 * time.Second
+	webhookMaxAttempts    = 5
+	webhookInitialBackoff = 2 * time.Second
+	webhookMaxBackoff     = 2 * time.Minute
+	// webhookCacheTTL is how long the registered webhooks are cached for dispatching events;
+	// changes made on this instance apply immediately.
+	webhookCacheTTL = time.Minute
+)
+
+// Headers of webhook deliveries. The signature is "sha256=" and the hex HMAC-SHA256, keyed by the
+// webhook's secret, of the timestamp header, a dot and the body; receivers should recompute it
+// and reject old timestamps.
+const (
+	WebhookEventHeader     = "X-Drive-Gallery-Event"
+	WebhookDeliveryHeader  = "X-Drive-Gallery-Delivery"
+	WebhookTimestampHeader = "X-Drive-Gallery-Timestamp"
+	WebhookSignatureHeader = "X-Drive-Gallery-Signature"
+)
+
+// Webhook is an outgoing webhook: events are POSTed to URL as JSON (see WebhookPayload).
+type Webhook struct {
+	ID  string `json:"id" firestore:"id"`
+	URL string `json:"url" firestore:"url"`
+	// Secret signs the deliveries. It is only returned by CreateWebhook.
+	Secret      string    `json:"secret,omitempty" firestore:"secret"`
+	EventTypes  []string  `json:"eventTypes,omitempty" firestore:"eventTypes,omitempty"` // Empty for all WebhookEventTypes
+	Description string    `json:"description,omitempty" firestore:"description,omitempty"`
+	CreatedBy   string    `json:"createdBy,omitempty" firestore:"createdBy,omitempty"` // UID of the admin
+	CreatedAt   time.Time `json:"createdAt" firestore:"createdAt"`
+	// LastDelivery is the outcome of the latest delivery, after its retries; nil before the first.
+	LastDelivery *WebhookDelivery `json:"lastDelivery,omitempty" firestore:"lastDelivery,omitempty"`
+}
+
+// WebhookDelivery is the outcome of delivering an event to a webhook.
+type WebhookDelivery struct {
+	ID         string    `json:"id" firestore:"id"`
+	EventType  string    `json:"eventType" firestore:"eventType"`
+	StatusCode int       `json:"statusCode,omitempty" firestore:"statusCode,omitempty"` // Of the last attempt; 0 if it got no answer
+	Error      string    `json:"error,omitempty" firestore:"error,omitempty"`           // Empty if delivered
+	Attempts   int       `json:"attempts" firestore:"attempts"`
+	At         time.Time `json:"at" firestore:"at"`
+}
+
+// WebhookPayload is the JSON body of a webhook delivery.
+type WebhookPayload struct {
+	ID        string      `json:"id"` // Delivery ID, the same across retries
+	Type      string      `json:"type"`
+	Data      interface{} `json:"data"` // As in the WebSocket event
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+// InvalidWebhookError is returned for registering a webhook with an unusable URL or event type.
+type InvalidWebhookError struct {
+	Reason string
+}
+
+func (e *InvalidWebhookError) Error() string { return "invalid webhook: " + e.Reason }
+
+var (
+	webhookCacheMu  sync.Mutex
+	webhookCache    []Webhook
+	webhookCachedAt time.Time
+
+	webhookClient = &http.Client{Timeout: webhookTimeout}
+)
+
+// CreateWebhook registers an outgoing webhook for eventTypes (empty for all WebhookEventTypes)
+// with a new secret, and returns it with the secret, which is not shown again.
+func CreateWebhook(ctx context.Context, rawURL string, eventTypes []string, description string) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, &InvalidWebhookError{Reason: fmt.Sprintf("URL %q is not an http(s) URL", rawURL)}
+	}
+	for _, t := range eventTypes {
+		if !slices.Contains(WebhookEventTypes, t) {
+			return nil, &InvalidWebhookError{Reason: fmt.Sprintf("unknown event type %q", t)}
+		}
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate webhook secret: %v", err)
+	}
+	webhook := &Webhook{
+		ID:          newID(),
+		URL:         u.String(),
+		Secret:      hex.EncodeToString(secret),
+		EventTypes:  eventTypes,
+		Description: description,
+		CreatedBy:   actorFrom(ctx),
+		CreatedAt:   now(),
+	}
+	if _, err := Client.Collection(WebhooksCollection).Doc(webhook.ID).Set(ctx, webhook); err != nil {
+		return nil, fmt.Errorf("failed to save webhook: %v", err)
+	}
+	invalidateWebhookCache()
+	log.Printf("Webhook %s registered for %s", webhook.ID, webhook.URL)
+	return webhook, nil
+}
+
+// ListWebhooks returns the registered webhooks, oldest first, without their secrets.
+func ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	webhooks, err := loadWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook unregisters a webhook. A missing webhook is ErrNotFound.
+func DeleteWebhook(ctx context.Context, webhookID string) error {
+	ref := Client.Collection(WebhooksCollection).Doc(webhookID)
+	if _, err := ref.Delete(ctx, firestore.Exists); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return notFound("webhook %s", webhookID)
+		}
+		return fmt.Errorf("failed to delete webhook %s: %v", webhookID, err)
+	}
+	invalidateWebhookCache()
+	log.Printf("Webhook %s deleted", webhookID)
+	return nil
+}
+
+// TestWebhook sends EventWebhookPing to a webhook, once, and returns the outcome. A missing
+// webhook is ErrNotFound.
+func TestWebhook(ctx context.Context, webhookID string) (*WebhookDelivery, error) {
+	doc, err := Client.Collection(WebhooksCollection).Doc(webhookID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, notFound("webhook %s", webhookID)
+		}
+		return nil, fmt.Errorf("failed to get webhook %s: %v", webhookID, err)
+	}
+	var webhook Webhook
+	if err := doc.DataTo(&webhook); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhook: %v", err)
+	}
+	payload := WebhookPayload{ID: newID(), Type: EventWebhookPing, Data: map[string]string{"webhookId": webhookID}, CreatedAt: now()}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ping: %v", err)
+	}
+	delivery := &WebhookDelivery{ID: payload.ID, EventType: payload.Type, Attempts: 1, At: now()}
+	delivery.StatusCode, err = postWebhook(ctx, webhook, payload, body)
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	return delivery, nil
+}
+
+// dispatchWebhooks delivers an event to the webhooks subscribed to it, in the background. The
+// payload is built right away, so later changes to data are not sent.
+func dispatchWebhooks(eventType string, data interface{}) {
+	if !slices.Contains(WebhookEventTypes, eventType) || Client == nil {
+		return
+	}
+	payload := WebhookPayload{ID: newID(), Type: eventType, Data: data, CreatedAt: now()}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshaling %s webhook payload: %v", eventType, err)
+		return
+	}
+	payload.Data = nil // Sent as body
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+		webhooks, err := cachedWebhooks(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Error loading webhooks for %s event: %v", eventType, err)
+			return
+		}
+		for _, webhook := range webhooks {
+			if len(webhook.EventTypes) == 0 || slices.Contains(webhook.EventTypes, eventType) {
+				go deliverWebhook(webhook, payload, body)
+			}
+		}
+	}()
+}
+
+// deliverWebhook posts a payload to a webhook, retrying failed attempts, and records the outcome
+// as its LastDelivery.
+func deliverWebhook(webhook Webhook, payload WebhookPayload, body []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookMaxAttempts*(webhookTimeout+webhookMaxBackoff))
+	defer cancel()
+	delivery := WebhookDelivery{ID: payload.ID, EventType: payload.Type}
+	backoff := webhookInitialBackoff
+	for {
+		delivery.Attempts++
+		code, err := postWebhook(ctx, webhook, payload, body)
+		delivery.StatusCode, delivery.Error = code, ""
+		if err == nil {
+			break
+		}
+		delivery.Error = err.Error()
+		retryable := code == 0 || code == http.StatusTooManyRequests || code >= 500
+		if !retryable || delivery.Attempts == webhookMaxAttempts {
+			log.Printf("ERROR: Webhook %s gave up on %s delivery %s after %d attempts: %v", webhook.ID, payload.Type, payload.ID, delivery.Attempts, err)
+			break
+		}
+		log.Printf("Webhook %s delivery %s failed, retrying in %s (%d/%d): %v", webhook.ID, payload.ID, backoff, delivery.Attempts, webhookMaxAttempts, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, webhookMaxBackoff)
+	}
+	delivery.At = now()
+	if _, err := Client.Collection(WebhooksCollection).Doc(webhook.ID).Update(ctx, []firestore.Update{{Path: "lastDelivery", Value: delivery}}); err != nil && status.Code(err) != codes.NotFound {
+		log.Printf("Error recording delivery of webhook %s: %v", webhook.ID, err)
+	}
+}
+
+// postWebhook makes one signed delivery attempt and returns the status code of the answer, 0 if
+// there was none. Answers other than 2xx are errors.
+func postWebhook(ctx context.Context, webhook Webhook, payload WebhookPayload, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to build request: %v", err)
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "drive-gallery-webhook")
+	req.Header.Set(WebhookEventHeader, payload.Type)
+	req.Header.Set(WebhookDeliveryHeader, payload.ID)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhookPayload(webhook.Secret, timestamp, body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // Lets the connection be reused
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%s answered %s", webhook.URL, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhookPayload returns the hex HMAC-SHA256 of timestamp, "." and body, keyed by secret: the
+// signature of a webhook delivery without its "sha256=" prefix.
+func SignWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// loadWebhooks reads the registered webhooks, secrets included, oldest first.
+func loadWebhooks(ctx context.Context) ([]Webhook, error) {
+	docs, err := Client.Collection(WebhooksCollection).OrderBy("createdAt", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %v", err)
+	}
+	webhooks := make([]Webhook, 0, len(docs))
+	for _, doc := range docs {
+		var webhook Webhook
+		if err := doc.DataTo(&webhook); err != nil {
+			log.Printf("Error reading webhook %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+// cachedWebhooks returns loadWebhooks, cached for webhookCacheTTL.
+func cachedWebhooks(ctx context.Context) ([]Webhook, error) {
+	webhookCacheMu.Lock()
+	defer webhookCacheMu.Unlock()
+	if webhookCache != nil && now().Sub(webhookCachedAt) < webhookCacheTTL {
+		return webhookCache, nil
+	}
+	webhooks, err := loadWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	webhookCache, webhookCachedAt = webhooks, now()
+	return webhooks, nil
+}
+
+func invalidateWebhookCache() {
+	webhookCacheMu.Lock()
+	defer webhookCacheMu.Unlock()
+	webhookCache = nil
+}
@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+)
+
+// queryPageSize is the default page size of pagedQuery: few round trips, yet a page of file
+// documents stays small in memory.
+const queryPageSize = 500
+
+// errStopPaging, returned by the fn of pagedQuery, ends the iteration without an error.
+var errStopPaging = errors.New("stop paging")
+
+// pagedQuery reads the documents of query, in its order, in pages of at most pageSize
+// (queryPageSize if zero) and calls fn with each page, stopping after limit documents (all if
+// zero). Every page is a separate query starting after the last document of the previous one, so
+// only one page is held in memory and a slow fn never outlives the deadline of an open query
+// stream, as iterating over a whole collection would; ctx is checked between pages. A StartAfter
+// cursor of query applies to the first page, e.g. to resume from a checkpoint. An error from fn
+// other than errStopPaging is returned.
+func pagedQuery(ctx context.Context, query firestore.Query, pageSize, limit int, fn func(docs []*firestore.DocumentSnapshot) error) error {
+	if pageSize <= 0 {
+		pageSize = queryPageSize
+	}
+	var last *firestore.DocumentSnapshot
+	for read := 0; limit <= 0 || read < limit; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		size := pageSize
+		if limit > 0 {
+			size = min(size, limit-read)
+		}
+		page := query.Limit(size)
+		if last != nil {
+			page = page.StartAfter(last)
+		}
+		docs, err := page.Documents(ctx).GetAll()
+		if err != nil {
+			if last != nil {
+				return fmt.Errorf("failed to query documents after '%s': %v", last.Ref.ID, err)
+			}
+			return fmt.Errorf("failed to query documents: %v", err)
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+		if err := fn(docs); err != nil {
+			if errors.Is(err, errStopPaging) {
+				return nil
+			}
+			return err
+		}
+		if len(docs) < size {
+			return nil
+		}
+		read += len(docs)
+		last = docs[len(docs)-1]
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"slices"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+)
+
+// MaxFilePeople bounds the profiles a file can be tagged with.
+const MaxFilePeople = 50
+
+type publicViewerKey struct{}
+
+// WithPublicViewer marks ctx as serving an unauthenticated request, so file listings made with it
+// leave out files showing people who asked to be hidden from public galleries (see
+// Profile.HideFromPublic).
+func WithPublicViewer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, publicViewerKey{}, true)
+}
+
+func isPublicViewer(ctx context.Context) bool {
+	public, _ := ctx.Value(publicViewerKey{}).(bool)
+	return public
+}
+
+type moderatorKey struct{}
+
+// WithModerator marks ctx as serving a request of an editor or admin (see PermissionModerate), who
+// are shown the files awaiting approval that manifests and change logs leave out for others.
+func WithModerator(ctx context.Context) context.Context {
+	return context.WithValue(ctx, moderatorKey{}, true)
+}
+
+func isModerator(ctx context.Context) bool {
+	moderator, _ := ctx.Value(moderatorKey{}).(bool)
+	return moderator
+}
+
+// HiddenProfileIDs returns the IDs of the profiles hidden from public galleries, tombstones
+// included, so a former member's photos stay hidden.
+func HiddenProfileIDs(ctx context.Context) (map[string]bool, error) {
+	docs, err := Client.Collection(profileCollection).Where("hideFromPublic", "==", true).Select().Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hidden profiles: %v", err)
+	}
+	hidden := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		hidden[doc.Ref.ID] = true
+	}
+	return hidden, nil
+}
+
+// hiddenPeopleFor returns HiddenProfileIDs for a public viewer, and nil for anyone else.
+func hiddenPeopleFor(ctx context.Context) (map[string]bool, error) {
+	if !isPublicViewer(ctx) {
+		return nil, nil
+	}
+	return HiddenProfileIDs(ctx)
+}
+
+// showsHiddenPerson reports whether file is tagged with one of the hidden profiles.
+func showsHiddenPerson(file FileMetadata, hidden map[string]bool) bool {
+	for _, id := range file.People {
+		if hidden[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// fileWithheld returns a function reporting whether a file is withheld from the viewer of ctx:
+// files awaiting approval unless it is a moderator (see WithModerator), and files showing hidden
+// people if it is a public viewer.
+func fileWithheld(ctx context.Context) (func(FileMetadata) bool, error) {
+	hidden, err := hiddenPeopleFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	moderator := isModerator(ctx)
+	return func(file FileMetadata) bool {
+		return (file.PendingApproval && !moderator) || showsHiddenPerson(file, hidden)
+	}, nil
+}
+
+// withoutHiddenPeople drops the files showing one of the hidden profiles.
+func withoutHiddenPeople(files []FileMetadata, hidden map[string]bool) []FileMetadata {
+	if len(hidden) == 0 {
+		return files
+	}
+	return slices.DeleteFunc(files, func(f FileMetadata) bool { return showsHiddenPerson(f, hidden) })
+}
+
+// hiddenPeopleFingerprint identifies a set of hidden profiles in snapshot revisions, so changing
+// who is hidden regenerates the snapshots; "" for none.
+func hiddenPeopleFingerprint(hidden map[string]bool) string {
+	if len(hidden) == 0 {
+		return ""
+	}
+	ids := make([]string, 0, len(hidden))
+	for id := range hidden {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	h := fnv.New64a()
+	for _, id := range ids {
+		h.Write([]byte(id))
+		h.Write([]byte{0})
+	}
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// SetFilePeople tags a file with the profiles of the people appearing in it, replacing its
+// previous tags; an empty list clears them. Unknown and deleted profiles are an
+// UnknownProfileError. It returns the updated file.
+func SetFilePeople(ctx context.Context, fileID string, profileIDs []string) (*FileMetadata, error) {
+	file, err := GetFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, notFound("file %s", fileID)
+	}
+	var people []string
+	for _, id := range profileIDs {
+		if !slices.Contains(people, id) {
+			people = append(people, id)
+		}
+	}
+	if len(people) > MaxFilePeople {
+		return nil, fmt.Errorf("a file can show at most %d people", MaxFilePeople)
+	}
+	if len(people) > 0 {
+		refs := make([]*firestore.DocumentRef, len(people))
+		for i, id := range people {
+			refs[i] = Client.Collection(profileCollection).Doc(id)
+		}
+		docs, err := Client.GetAll(ctx, refs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get profiles: %v", err)
+		}
+		for i, doc := range docs {
+			if !doc.Exists() || profileFromDoc(doc).Deleted {
+				return nil, &UnknownProfileError{ID: people[i]}
+			}
+		}
+	}
+
+	var value interface{} = firestore.Delete
+	if len(people) > 0 {
+		value = people
+	}
+	if _, err := Client.Collection(FilesCollection).Doc(fileID).Update(ctx, []firestore.Update{{Path: "people", Value: value}}); err != nil {
+		return nil, fmt.Errorf("failed to update file %s: %v", fileID, err)
+	}
+	file.People = people
+	log.Printf("File %s tagged with %d people.", fileID, len(people))
+	recordFolderChange(ctx, file.FolderID, FolderChange{Type: ChangeEdited, FileID: fileID, Name: file.Name, Fields: []string{"people"}})
+	return file, nil
+}
+
+// ListFilesByPerson lists the files tagged with a profile across all folders, newest first, with
+// the same pagination as ListFilesFromFirestore: "photos of the drummer". It needs a composite
+// index on files (people array-contains, createdAt desc).
+func ListFilesByPerson(ctx context.Context, profileID string, pageSize int64, lastDocID string) ([]FileMetadata, string, error) {
+	query := Client.Collection(FilesCollection).Where("people", "array-contains", profileID).OrderBy("createdAt", firestore.Desc)
+	files, newLastDocID, err := listFilesPage(ctx, query, pageSize, lastDocID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list files of profile %s: %v", profileID, err)
+	}
+	return files, newLastDocID, nil
+}
+
+// profileFiles returns the files tagged with a profile.
+func profileFiles(ctx context.Context, profileID string) ([]*firestore.DocumentSnapshot, error) {
+	docs, err := Client.Collection(FilesCollection).Where("people", "array-contains", profileID).Select().Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files of profile %s: %v", profileID, err)
+	}
+	return docs, nil
+}
+
+// untagProfileFiles removes a deleted profile from the files tagged with it.
+func untagProfileFiles(ctx context.Context, profileID string) {
+	docs, err := profileFiles(ctx, profileID)
+	if err != nil {
+		log.Printf("Error untagging files of deleted profile %s: %v", profileID, err)
+		return
+	}
+	writer := Client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for _, doc := range docs {
+		job, err := writer.Update(doc.Ref, []firestore.Update{{Path: "people", Value: firestore.ArrayRemove(profileID)}})
+		if err != nil {
+			log.Printf("Error untagging file %s of deleted profile %s: %v", doc.Ref.ID, profileID, err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	writer.End() // Blocks until every enqueued write has completed
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			log.Printf("Error untagging a file of deleted profile %s: %v", profileID, err)
+		}
+	}
+	log.Printf("Untagged %d files of deleted profile %s.", len(jobs), profileID)
+}
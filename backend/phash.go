@@ -0,0 +1,181 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+// NearDuplicateDistance is the default largest Hamming distance between the perceptual hashes of
+// two images considered near-duplicates: burst shots, re-encodes and resized copies.
+const NearDuplicateDistance = 8
+
+// pHashSize is the side of the grayscale image the DCT is taken of; the hash uses its lowest 8×8
+// frequencies.
+const pHashSize = 32
+
+// perceptualHash returns the 64-bit DCT perceptual hash of an image as 16 hex digits. Each bit
+// tells whether one of the 8×8 lowest frequencies of a 32×32 grayscale version lies above their
+// median, so similar-looking images have hashes differing in few bits.
+func perceptualHash(img image.Image) string {
+	// Grayscale 32×32, each cell averaging up to 8×8 sampled source pixels
+	var gray [pHashSize][pHashSize]float64
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	for cy := 0; cy < pHashSize; cy++ {
+		y0, y1 := b.Min.Y+cy*h/pHashSize, b.Min.Y+max((cy+1)*h/pHashSize, cy*h/pHashSize+1)
+		for cx := 0; cx < pHashSize; cx++ {
+			x0, x1 := b.Min.X+cx*w/pHashSize, b.Min.X+max((cx+1)*w/pHashSize, cx*w/pHashSize+1)
+			var sum float64
+			var n int
+			for y := y0; y < y1; y += max(1, (y1-y0)/8) {
+				for x := x0; x < x1; x += max(1, (x1-x0)/8) {
+					cr, cg, cb, ca := img.At(x, y).RGBA()
+					r, g, bl := float64(cr+0xffff-ca), float64(cg+0xffff-ca), float64(cb+0xffff-ca)
+					sum += 0.299*r + 0.587*g + 0.114*bl
+					n++
+				}
+			}
+			gray[cy][cx] = sum / float64(n)
+		}
+	}
+
+	// 2D DCT-II, only the 8×8 lowest frequencies are needed
+	var cos [8][pHashSize]float64
+	for u := 0; u < 8; u++ {
+		for x := 0; x < pHashSize; x++ {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * pHashSize))
+		}
+	}
+	var coeffs [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < pHashSize; y++ {
+				for x := 0; x < pHashSize; x++ {
+					sum += gray[y][x] * cos[u][x] * cos[v][y]
+				}
+			}
+			coeffs[v*8+u] = sum
+		}
+	}
+
+	// The DC term only reflects overall brightness, so it is left out of the median
+	rest := make([]float64, 63)
+	copy(rest, coeffs[1:])
+	sort.Float64s(rest)
+	median := rest[31]
+
+	var hash uint64
+	for i, c := range coeffs {
+		if c > median {
+			hash |= 1 << uint(63-i)
+		}
+	}
+	return fmt.Sprintf("%016x", hash)
+}
+
+// PHashDistance returns the number of differing bits of two perceptual hashes, or -1 if either
+// is missing or malformed.
+func PHashDistance(a, b string) int {
+	x, errA := strconv.ParseUint(a, 16, 64)
+	y, errB := strconv.ParseUint(b, 16, 64)
+	if a == "" || b == "" || errA != nil || errB != nil {
+		return -1
+	}
+	return bits.OnesCount64(x ^ y)
+}
+
+// isNearDuplicate reports whether two files have perceptual hashes within maxDistance.
+func isNearDuplicate(a, b FileMetadata, maxDistance int) bool {
+	d := PHashDistance(a.PHash, b.PHash)
+	return d >= 0 && d <= maxDistance
+}
+
+// HideNearDuplicates returns files without the images that are near-duplicates of an earlier one,
+// so a burst of shots is shown once. Files without a perceptual hash are always kept.
+func HideNearDuplicates(files []FileMetadata, maxDistance int) []FileMetadata {
+	kept := make([]FileMetadata, 0, len(files))
+	for _, f := range files {
+		duplicate := false
+		for _, k := range kept {
+			if isNearDuplicate(f, k, maxDistance) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// GroupNearDuplicates groups files whose perceptual hashes are within maxDistance, transitively,
+// and returns the groups of two or more files in their original order.
+func GroupNearDuplicates(files []FileMetadata, maxDistance int) [][]FileMetadata {
+	parent := make([]int, len(files))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range files {
+		for j := i + 1; j < len(files); j++ {
+			if isNearDuplicate(files[i], files[j], maxDistance) {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	members := make(map[int][]FileMetadata)
+	var roots []int // In order of first appearance
+	for i, f := range files {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], f)
+	}
+	var groups [][]FileMetadata
+	for _, root := range roots {
+		if len(members[root]) > 1 {
+			groups = append(groups, members[root])
+		}
+	}
+	return groups
+}
+
+// FindNearDuplicates returns the groups of near-duplicate images in a folder, or in the whole
+// gallery if folderID is empty. Images without a perceptual hash (see the "phash" backfill) are
+// not compared.
+func FindNearDuplicates(ctx context.Context, folderID string, maxDistance int) ([][]FileMetadata, error) {
+	query := Client.Collection(FilesCollection).Where("mediaType", "==", "image")
+	if folderID != "" {
+		query = query.Where("folderId", "==", folderID)
+	}
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %v", err)
+	}
+	files := make([]FileMetadata, 0, len(docs))
+	for _, doc := range docs {
+		var file FileMetadata
+		if err := doc.DataTo(&file); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal file metadata from doc %s: %v", doc.Ref.ID, err)
+		}
+		if file.PHash != "" {
+			files = append(files, file)
+		}
+	}
+	return GroupNearDuplicates(files, maxDistance), nil
+}
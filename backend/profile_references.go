@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/firestore"
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Ways DeleteProfile deals with what refers to a profile.
+const (
+	// ProfileDeleteAnonymize deletes the profile document and strips its name from what refers to
+	// it: entries of the change log keep only its ID. Files tagged with it are untagged, so they
+	// are no longer hidden from public galleries on its behalf.
+	ProfileDeleteAnonymize = "anonymize"
+	// ProfileDeleteTombstone keeps the document as a tombstone with only the name, so references
+	// still resolve (e.g. to "former member X") while the profile is gone from every listing.
+	ProfileDeleteTombstone = "tombstone"
+)
+
+// ProfileReferences is what refers to a profile, and so dangles when it is deleted outright.
+type ProfileReferences struct {
+	ProfileID   string `json:"profileId"`
+	Changes     int    `json:"changes"`               // Entries of the top-level change log naming the profile
+	IconObjects int    `json:"iconObjects"`           // Icons uploaded to Storage under profiles/{id}/
+	Files       int    `json:"files"`                 // Files tagged with the profile (see SetFilePeople)
+	SearchIndex string `json:"searchIndex,omitempty"` // Search service that may hold a copy of the profile
+}
+
+// profileIconPrefix is the Storage prefix of a profile's icons, as written by UploadProfileIcon.
+func profileIconPrefix(profileID string) string {
+	return "profiles/" + profileID + "/"
+}
+
+// GetProfileReferences reports what refers to a profile, for a check before deleting it. A
+// missing profile is ErrNotFound.
+func GetProfileReferences(ctx context.Context, profileID string) (*ProfileReferences, error) {
+	if _, err := GetProfile(ctx, profileID); err != nil {
+		return nil, err
+	}
+	changes, err := profileChanges(ctx, profileID)
+	if err != nil {
+		return nil, err
+	}
+	icons, err := profileIcons(ctx, profileID)
+	if err != nil {
+		return nil, err
+	}
+	files, err := profileFiles(ctx, profileID)
+	if err != nil {
+		return nil, err
+	}
+	refs := &ProfileReferences{ProfileID: profileID, Changes: len(changes), IconObjects: len(icons), Files: len(files)}
+	if indexer := currentSearchIndexer(); indexer != (firestoreSearchIndexer{}) {
+		refs.SearchIndex = indexer.Name() // nameSearch lives on file documents only
+	}
+	return refs, nil
+}
+
+// profileChanges returns the change log entries naming a profile.
+func profileChanges(ctx context.Context, profileID string) ([]*firestore.DocumentSnapshot, error) {
+	docs, err := Client.Collection(ChangesCollection).Where("profileId", "==", profileID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changes of profile %s: %v", profileID, err)
+	}
+	return docs, nil
+}
+
+// profileIcons returns the names of the icon objects of a profile.
+func profileIcons(ctx context.Context, profileID string) ([]string, error) {
+	bucket, err := bucketHandle("")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	it := bucket.Objects(ctx, &gcs.Query{Prefix: profileIconPrefix(profileID)})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list icons of profile %s: %v", profileID, err)
+		}
+		names = append(names, attrs.Name)
+	}
+	return names, nil
+}
+
+// releaseProfileReferences deletes a profile's icons and its copy in the search service, and with
+// anonymize strips its name from the change log, untags its files and unlabels its face
+// clusters. Failures are logged rather than returned, since the profile is being deleted either
+// way and nothing else refers to those copies.
+func releaseProfileReferences(ctx context.Context, profileID string, anonymize bool) {
+	if icons, err := profileIcons(ctx, profileID); err != nil {
+		log.Printf("Error listing icons of deleted profile %s: %v", profileID, err)
+	} else if bucket, err := bucketHandle(""); err == nil {
+		for _, name := range icons {
+			if err := bucket.Object(name).Delete(ctx); err != nil && err != gcs.ErrObjectNotExist {
+				log.Printf("Error deleting icon %s of deleted profile %s: %v", name, profileID, err)
+			}
+		}
+	}
+
+	indexer := currentSearchIndexer()
+	if err := indexer.RemoveProfiles(ctx, []string{profileID}); err != nil {
+		log.Printf("Error removing deleted profile %s from %s: %v", profileID, indexer.Name(), err)
+	}
+
+	if !anonymize {
+		return
+	}
+	untagProfileFiles(ctx, profileID)
+	unlabelProfileClusters(ctx, profileID)
+	changes, err := profileChanges(ctx, profileID)
+	if err != nil {
+		log.Printf("Error anonymizing changes of deleted profile %s: %v", profileID, err)
+		return
+	}
+	writer := Client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for _, doc := range changes {
+		if _, ok := doc.Data()["name"]; !ok {
+			continue
+		}
+		job, err := writer.Update(doc.Ref, []firestore.Update{{Path: "name", Value: firestore.Delete}})
+		if err != nil {
+			log.Printf("Error anonymizing change %s of deleted profile %s: %v", doc.Ref.ID, profileID, err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	writer.End() // Blocks until every enqueued write has completed
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			log.Printf("Error anonymizing a change of deleted profile %s: %v", profileID, err)
+		}
+	}
+	log.Printf("Anonymized %d changes of deleted profile %s.", len(jobs), profileID)
+}
@@ -6,7 +6,7 @@ import (
 	"io"
 	"log"
 	"path/filepath"
-	"time"
+	"sort"
 
 	"cloud.google.com/go/firestore"
 	gcs "cloud.google.com/go/storage" // Google Cloud Storage client for ACL
@@ -24,12 +24,32 @@ const (
 // `firestore:"-"` はそのフィールドをFirestoreに保存しないことを意味します。
 type Profile struct {
 	ID      string `json:"id" firestore:"-"` // Firestore document ID, not stored as a field in the document
-	Name    string `json:"name"`
-	Bio     string `json:"bio"`
-	IconURL string `json:"icon_url,omitempty"`
+	Name    string `json:"name" validate:"required,max=100"`
+	Bio     string `json:"bio" validate:"max=10000"`
+	IconURL string `json:"icon_url,omitempty" validate:"max=2000"`
+	// Archived profiles are former members: hidden from the lineup but kept for history.
+	Archived bool `json:"archived"`
+	// Order is the position in the lineup, set by ReorderProfiles; 0 for profiles never placed, which come last.
+	Order int `json:"order"`
+	// Deleted profiles are tombstones left by DeleteProfile, kept only so references to them resolve.
+	Deleted bool `json:"deleted,omitempty"`
+	// HideFromPublic is the member's wish to stay out of public galleries: files tagged with the
+	// profile (see SetFilePeople) are left out of listings for visitors who are not signed in.
+	HideFromPublic bool `json:"hide_from_public"`
 	// Add other profile fields here
 }
 
+// UnknownProfileError is returned by ReorderProfiles for a profile ID that does not exist.
+type UnknownProfileError struct {
+	ID string
+}
+
+func (e *UnknownProfileError) Error() string {
+	return fmt.Sprintf("profile %s not found", e.ID)
+}
+
+func (e *UnknownProfileError) Is(target error) bool { return target == ErrNotFound }
+
 // CreateProfile creates a new profile document in Firestore.
 // It returns the ID of the newly created document.
 func CreateProfile(ctx context.Context, profile Profile) (string, error) {
@@ -39,9 +59,11 @@ func CreateProfile(ctx context.Context, profile Profile) (string, error) {
 
 	// Add a new document with an auto-generated ID to the "profiles" collection.
 	docRef, _, err := Client.Collection(profileCollection).Add(ctx, map[string]interface{}{
-		"name":    profile.Name,
-		"bio":     profile.Bio,
-		"iconURL": profile.IconURL,
+		"name":           profile.Name,
+		"bio":            profile.Bio,
+		"iconURL":        profile.IconURL,
+		"archived":       profile.Archived,
+		"hideFromPublic": profile.HideFromPublic,
 		// Add other fields here, ensure they match the Profile struct and Firestore needs
 	})
 	if err != nil {
@@ -49,6 +71,9 @@ func CreateProfile(ctx context.Context, profile Profile) (string, error) {
 		return "", fmt.Errorf("failed to create profile: %v", err)
 	}
 	log.Printf("Successfully created profile with ID: %s", docRef.ID)
+	profile.ID = docRef.ID
+	recordGalleryChange(ctx, FolderChange{Type: ChangeAdded, ProfileID: profile.ID, Name: profile.Name})
+	BroadcastEvent(EventProfileUpdated, profile)
 	return docRef.ID, nil
 }
 
@@ -72,7 +97,7 @@ func UploadProfileIcon(ctx context.Context, profileID string, file io.Reader, fi
 	// Example: profiles/{profileID}/icons/{timestamp}_{original_filename_without_ext}.{ext}
 	ext := filepath.Ext(filename)
 	baseFilename := filename[:len(filename)-len(ext)]
-	objectName := fmt.Sprintf("profiles/%s/icons/%d_%s%s", profileID, time.Now().UnixNano(), baseFilename, ext)
+	objectName := fmt.Sprintf("profiles/%s/icons/%d_%s%s", profileID, now().UnixNano(), baseFilename, ext)
 
 	wc := bucket.Object(objectName).NewWriter(ctx)
 	wc.ContentType = contentType
@@ -106,8 +131,9 @@ func UploadProfileIcon(ctx context.Context, profileID string, file io.Reader, fi
 	return publicURL, nil
 }
 
-// GetProfiles retrieves all profile documents from Firestore.
-func GetProfiles(ctx context.Context) ([]Profile, error) {
+// GetProfiles retrieves the profile documents from Firestore in lineup order (Order, then name).
+// Archived profiles are only included with includeArchived, and tombstones never.
+func GetProfiles(ctx context.Context, includeArchived bool) ([]Profile, error) {
 	if Client == nil {
 		return nil, fmt.Errorf("Firestore client not initialized")
 	}
@@ -126,56 +152,31 @@ func GetProfiles(ctx context.Context) ([]Profile, error) {
 			return nil, fmt.Errorf("failed to iterate profiles: %v", err)
 		}
 
-		docData := doc.Data()
-		p := Profile{
-			ID: doc.Ref.ID,
-		}
-
-		if nameVal, ok := docData["name"]; ok {
-			if nameStr, isStr := nameVal.(string); isStr {
-				p.Name = nameStr
-			}
-		}
-		if bioVal, ok := docData["bio"]; ok {
-			if bioStr, isStr := bioVal.(string); isStr {
-				p.Bio = bioStr
-			}
-		} else if descVal, ok := docData["description"]; ok { // Fallback to 'description' if 'bio' is not found
-			if descStr, isStr := descVal.(string); isStr {
-				p.Bio = descStr
-			}
-		}
-		if iconURLVal, ok := docData["iconURL"]; ok {
-			if iconURLStr, isStr := iconURLVal.(string); isStr {
-				p.IconURL = iconURLStr
-			}
+		p := profileFromDoc(doc)
+		if p.Deleted || (p.Archived && !includeArchived) {
+			continue
 		}
-
 		profiles = append(profiles, p)
 	}
+	sortProfiles(profiles)
 	log.Printf("Successfully retrieved %d profiles", len(profiles))
 	return profiles, nil
 }
 
-// GetProfile retrieves a single profile document by its ID from Firestore.
-func GetProfile(ctx context.Context, profileID string) (*Profile, error) {
-	if Client == nil {
-		return nil, fmt.Errorf("Firestore client not initialized")
-	}
-	if profileID == "" {
-		return nil, fmt.Errorf("profileID cannot be empty")
-	}
-
-	doc, err := Client.Collection(profileCollection).Doc(profileID).Get(ctx)
-	if err != nil {
-		if status.Code(err) == codes.NotFound {
-			log.Printf("Profile with ID %s not found", profileID)
-			return nil, nil // Or a specific "not found" error
+// sortProfiles sorts profiles by Order, with unplaced profiles (Order 0) last, then by name.
+func sortProfiles(profiles []Profile) {
+	sort.SliceStable(profiles, func(i, j int) bool {
+		oi, oj := profiles[i].Order, profiles[j].Order
+		if oi != oj {
+			return oj == 0 || (oi != 0 && oi < oj)
 		}
-		log.Printf("Error getting profile %s: %v", profileID, err)
-		return nil, fmt.Errorf("failed to get profile %s: %v", profileID, err)
-	}
+		return profiles[i].Name < profiles[j].Name
+	})
+}
 
+// profileFromDoc reads a profile document. Fields are read leniently, since older documents
+// may lack them or use "description" instead of "bio".
+func profileFromDoc(doc *firestore.DocumentSnapshot) Profile {
 	docData := doc.Data()
 	p := Profile{
 		ID: doc.Ref.ID,
@@ -200,12 +201,48 @@ func GetProfile(ctx context.Context, profileID string) (*Profile, error) {
 			p.IconURL = iconURLStr
 		}
 	}
+	if archived, ok := docData["archived"].(bool); ok {
+		p.Archived = archived
+	}
+	if order, ok := docData["order"].(int64); ok {
+		p.Order = int(order)
+	}
+	if deleted, ok := docData["deleted"].(bool); ok {
+		p.Deleted = deleted
+	}
+	if hide, ok := docData["hideFromPublic"].(bool); ok {
+		p.HideFromPublic = hide
+	}
+	return p
+}
+
+// GetProfile retrieves a single profile document by its ID from Firestore, which may be a
+// tombstone. A missing profile is ErrNotFound.
+func GetProfile(ctx context.Context, profileID string) (*Profile, error) {
+	if Client == nil {
+		return nil, fmt.Errorf("Firestore client not initialized")
+	}
+	if profileID == "" {
+		return nil, fmt.Errorf("profileID cannot be empty")
+	}
+
+	doc, err := Client.Collection(profileCollection).Doc(profileID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			log.Printf("Profile with ID %s not found", profileID)
+			return nil, notFound("profile %s", profileID)
+		}
+		log.Printf("Error getting profile %s: %v", profileID, err)
+		return nil, fmt.Errorf("failed to get profile %s: %v", profileID, err)
+	}
 
+	p := profileFromDoc(doc)
 	log.Printf("Successfully retrieved profile with ID: %s, Name: %s, Bio: %s, IconURL: %s", p.ID, p.Name, p.Bio, p.IconURL)
 	return &p, nil
 }
 
-// UpdateProfile updates an existing profile document in Firestore.
+// UpdateProfile updates an existing profile document in Firestore. A missing profile is
+// ErrNotFound.
 func UpdateProfile(ctx context.Context, profileID string, profile Profile) error {
 	if Client == nil {
 		return fmt.Errorf("Firestore client not initialized")
@@ -214,36 +251,63 @@ func UpdateProfile(ctx context.Context, profileID string, profile Profile) error
 		return fmt.Errorf("profileID cannot be empty for update")
 	}
 
-	// Use Set with MergeAll to update only provided fields, or create if not exists.
-	// If you want to strictly update existing ones, you might check existence first or use Update.
-	// For simplicity, Set with MergeAll is often used.
-	// Alternatively, use Update with a map of fields to update.
-	updateData := map[string]interface{}{
-		"name":    profile.Name,
-		"bio":     profile.Bio, // Changed from description to bio
-		"iconURL": profile.IconURL,
-		// Add other fields to update
+	// Update rather than Set, so a missing profile is not silently created.
+	updateData := []firestore.Update{
+		{Path: "name", Value: profile.Name},
+		{Path: "bio", Value: profile.Bio},
+		{Path: "iconURL", Value: profile.IconURL},
+		{Path: "archived", Value: profile.Archived},
+		{Path: "hideFromPublic", Value: profile.HideFromPublic},
+		// "order" is only changed by ReorderProfiles
 	}
 
-	_, err := Client.Collection(profileCollection).Doc(profileID).Set(ctx, updateData, firestore.MergeAll)
+	_, err := Client.Collection(profileCollection).Doc(profileID).Update(ctx, updateData)
+	if status.Code(err) == codes.NotFound {
+		return notFound("profile %s", profileID)
+	}
 	if err != nil {
 		log.Printf("Error updating profile %s in Firestore: %v", profileID, err)
 		return fmt.Errorf("failed to update profile %s: %v", profileID, err)
 	}
 	log.Printf("Successfully updated profile with ID: %s", profileID)
+	profile.ID = profileID
+	recordGalleryChange(ctx, FolderChange{Type: ChangeEdited, ProfileID: profileID, Name: profile.Name})
+	BroadcastEvent(EventProfileUpdated, profile)
 	return nil
 }
 
-// DeleteProfile deletes a profile document by its ID from Firestore.
-func DeleteProfile(ctx context.Context, profileID string) error {
+// DeleteProfile deletes a profile by its ID from Firestore, with its icons and its copy in the
+// search service. mode decides what happens to the references GetProfileReferences reports:
+// ProfileDeleteAnonymize (the default for "") deletes the document and strips the profile's name
+// from them, ProfileDeleteTombstone keeps the document with only the name so they still resolve,
+// and whether it is hidden from public galleries.
+func DeleteProfile(ctx context.Context, profileID, mode string) error {
 	if Client == nil {
 		return fmt.Errorf("Firestore client not initialized")
 	}
 	if profileID == "" {
 		return fmt.Errorf("profileID cannot be empty for delete")
 	}
+	if mode == "" {
+		mode = ProfileDeleteAnonymize
+	}
 
-	_, err := Client.Collection(profileCollection).Doc(profileID).Delete(ctx)
+	ref := Client.Collection(profileCollection).Doc(profileID)
+	var err error
+	switch mode {
+	case ProfileDeleteAnonymize:
+		_, err = ref.Delete(ctx)
+	case ProfileDeleteTombstone:
+		_, err = ref.Update(ctx, []firestore.Update{
+			{Path: "deleted", Value: true},
+			{Path: "deletedAt", Value: now()},
+			{Path: "bio", Value: ""},
+			{Path: "iconURL", Value: ""},
+			{Path: "order", Value: firestore.Delete},
+		})
+	default:
+		return fmt.Errorf("unknown profile delete mode %q", mode)
+	}
 	if err != nil {
 		if status.Code(err) == codes.NotFound {
 			log.Printf("Profile with ID %s not found for deletion, considered successful.", profileID)
@@ -252,6 +316,64 @@ func DeleteProfile(ctx context.Context, profileID string) error {
 		log.Printf("Error deleting profile %s from Firestore: %v", profileID, err)
 		return fmt.Errorf("failed to delete profile %s: %v", profileID, err)
 	}
-	log.Printf("Successfully deleted profile with ID: %s", profileID)
+	releaseProfileReferences(ctx, profileID, mode == ProfileDeleteAnonymize)
+	log.Printf("Successfully deleted profile with ID: %s (%s)", profileID, mode)
+	recordGalleryChange(ctx, FolderChange{Type: ChangeRemoved, ProfileID: profileID})
+	BroadcastEvent(EventProfileUpdated, map[string]interface{}{"id": profileID, "deleted": true})
+	return nil
+}
+
+// ReorderProfiles sets the lineup order: ids come first, in the given order, followed by the
+// profiles not listed in their current order, so a partial list moves those profiles to the top.
+// Archived profiles can be listed too and keep their place when restored.
+func ReorderProfiles(ctx context.Context, ids []string) error {
+	if Client == nil {
+		return fmt.Errorf("Firestore client not initialized")
+	}
+	profiles, err := GetProfiles(ctx, true)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(profiles))
+	for _, p := range profiles {
+		known[p.ID] = true
+	}
+	order := make([]string, 0, len(profiles))
+	listed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !known[id] {
+			return &UnknownProfileError{ID: id}
+		}
+		if listed[id] {
+			return fmt.Errorf("profile %s is listed more than once", id)
+		}
+		listed[id] = true
+		order = append(order, id)
+	}
+	for _, p := range profiles {
+		if !listed[p.ID] {
+			order = append(order, p.ID)
+		}
+	}
+
+	writer := Client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(order))
+	for i, id := range order {
+		job, err := writer.Update(Client.Collection(profileCollection).Doc(id), []firestore.Update{{Path: "order", Value: i + 1}})
+		if err != nil {
+			writer.End()
+			return fmt.Errorf("failed to reorder profile %s: %v", id, err)
+		}
+		jobs = append(jobs, job)
+	}
+	writer.End() // Blocks until every enqueued write has completed
+	for i, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return fmt.Errorf("failed to reorder profile %s: %v", order[i], err)
+		}
+	}
+	log.Printf("Reordered %d profiles.", len(order))
+	recordGalleryChange(ctx, FolderChange{Type: ChangeEdited, Fields: []string{"order"}})
+	BroadcastEvent(EventProfileUpdated, map[string]interface{}{"order": order})
 	return nil
 }
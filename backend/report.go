@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReportsCollection holds abuse reports about files, for moderating publicly shared galleries.
+const ReportsCollection = "reports"
+
+// Report statuses.
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+// Limits of the free-text fields of a report.
+const (
+	MaxReportReasonLength  = 1000
+	MaxReportContactLength = 200
+)
+
+// EventFileReported is sent to the outgoing webhooks subscribed to it when a file is reported, to
+// alert moderators outside the gallery (e.g. chat or email). It is not broadcast to WebSocket
+// clients, as reports may carry the reporter's contact. Data: FileReport.
+const EventFileReported = "file_reported"
+
+// FileReport is a visitor's report of an inappropriate file.
+type FileReport struct {
+	ID          string    `json:"id" firestore:"id"`
+	FileID      string    `json:"fileId" firestore:"fileId"`
+	FolderID    string    `json:"folderId" firestore:"folderId"`
+	FileName    string    `json:"fileName" firestore:"fileName"` // At the time of the report, in case the file is deleted
+	Reason      string    `json:"reason" firestore:"reason"`
+	Contact     string    `json:"contact,omitempty" firestore:"contact,omitempty"`         // Optional, e.g. an email address for follow-up
+	ReporterUID string    `json:"reporterUid,omitempty" firestore:"reporterUid,omitempty"` // Set when the reporter was signed in
+	Status      string    `json:"status" firestore:"status"`                               // ReportOpen or ReportResolved
+	CreatedAt   time.Time `json:"createdAt" firestore:"createdAt"`
+	ResolvedAt  time.Time `json:"resolvedAt,omitempty" firestore:"resolvedAt,omitempty"`
+	ResolvedBy  string    `json:"resolvedBy,omitempty" firestore:"resolvedBy,omitempty"` // UID of the moderator
+	Resolution  string    `json:"resolution,omitempty" firestore:"resolution,omitempty"` // Moderator's note, e.g. "removed"
+}
+
+// ReportFile records an open report about a file and sends EventFileReported to the outgoing
+// webhooks. A missing file is ErrNotFound.
+func ReportFile(ctx context.Context, fileID, reason, contact, reporterUID string) (*FileReport, error) {
+	file, err := GetFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, notFound("file %s", fileID)
+	}
+	report := FileReport{
+		ID:          newID(),
+		FileID:      file.ID,
+		FolderID:    file.FolderID,
+		FileName:    file.Name,
+		Reason:      reason,
+		Contact:     contact,
+		ReporterUID: reporterUID,
+		Status:      ReportOpen,
+		CreatedAt:   now(),
+	}
+	if _, err := Client.Collection(ReportsCollection).Doc(report.ID).Set(ctx, report); err != nil {
+		return nil, fmt.Errorf("failed to save report: %v", err)
+	}
+	log.Printf("Report %s about file %s (%s): %s", report.ID, file.ID, file.Name, reason)
+	dispatchWebhooks(EventFileReported, report)
+	return &report, nil
+}
+
+// ListOpenReports returns the reports awaiting moderation, oldest first. It needs a composite
+// index on reports (status, createdAt).
+func ListOpenReports(ctx context.Context) ([]FileReport, error) {
+	docs, err := Client.Collection(ReportsCollection).Where("status", "==", ReportOpen).OrderBy("createdAt", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list open reports: %v", err)
+	}
+	reports := make([]FileReport, 0, len(docs))
+	for _, doc := range docs {
+		var report FileReport
+		if err := doc.DataTo(&report); err != nil {
+			log.Printf("Error unmarshaling report %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// ResolveReport closes a report. A missing report is ErrNotFound.
+func ResolveReport(ctx context.Context, reportID, moderatorUID, resolution string) error {
+	_, err := Client.Collection(ReportsCollection).Doc(reportID).Update(ctx, []firestore.Update{
+		{Path: "status", Value: ReportResolved},
+		{Path: "resolvedAt", Value: now()},
+		{Path: "resolvedBy", Value: moderatorUID},
+		{Path: "resolution", Value: resolution},
+	})
+	if status.Code(err) == codes.NotFound {
+		return notFound("report %s", reportID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve report %s: %v", reportID, err)
+	}
+	log.Printf("Report %s resolved by %s: %s", reportID, moderatorUID, resolution)
+	return nil
+}
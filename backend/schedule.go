@@ -0,0 +1,189 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// EventFolderPublished is broadcast, and so sent to the outgoing webhooks subscribed to it, when
+// the PublishAt of a folder has come. Data: FolderMetadata.
+const EventFolderPublished = "folder_published"
+
+var (
+	scheduledMu sync.Mutex
+	scheduled   = make(map[string]bool) // Folder events with a pending timer, by event and folder ID
+	// schedulerInterval is the interval of StartFolderScheduler; 0 if it is not running.
+	schedulerInterval time.Duration
+)
+
+// IsPublished reports whether the folder may be shown publicly: it has no PublishAt, or it has
+// passed.
+func (f *FolderMetadata) IsPublished() bool {
+	return f.PublishAt == nil || !f.PublishAt.After(now())
+}
+
+// isPubliclyVisible reports whether public viewers may see the folder: it is published and not
+// hidden by its expiry.
+func (f *FolderMetadata) isPubliclyVisible() bool {
+	return f.IsPublished() && !f.IsHiddenByExpiry()
+}
+
+// IsFolderVisible reports whether a folder may be shown to the viewer of ctx: private folders,
+// and folders scheduled for publication later or hidden by their expiry, are hidden from public
+// viewers (see WithPublicViewer).
+func IsFolderVisible(ctx context.Context, folder *FolderMetadata) bool {
+	return !isPublicViewer(ctx) || (folder.isPubliclyVisible() && !folder.IsPrivate())
+}
+
+// SetFolderPublishAt schedules the publication of a folder: until publishAt it is hidden from
+// public listings, and at publishAt EventFolderPublished is sent. nil publishes it now. It
+// returns the updated folder.
+func SetFolderPublishAt(ctx context.Context, folderID string, publishAt *time.Time) (*FolderMetadata, error) {
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil {
+		return nil, notFound("folder %s", folderID)
+	}
+	wasPublished := folder.IsPublished()
+	folder.PublishAt = publishAt
+	folder.PublishPending = !folder.IsPublished()
+	updates := []firestore.Update{{Path: "publishAt", Value: firestore.Delete}, {Path: "publishPending", Value: firestore.Delete}}
+	if publishAt != nil {
+		updates[0].Value = *publishAt
+	}
+	if folder.PublishPending {
+		updates[1].Value = true
+	}
+	if _, err := Client.Collection(FoldersCollection).Doc(folderID).Update(ctx, updates); err != nil {
+		return nil, fmt.Errorf("failed to update folder %s: %v", folderID, err)
+	}
+	log.Printf("Folder %s publication scheduled at %v", folderID, publishAt)
+	recordFolderChange(ctx, folderID, FolderChange{Type: ChangeEdited, Name: folder.Name, Fields: []string{"publishAt"}})
+	if folder.isPubliclyVisible() {
+		BroadcastEvent(EventFolderUpdated, *folder) // An embargoed folder is not announced to everyone
+		if !wasPublished {
+			BroadcastEvent(EventFolderPublished, *folder)
+		}
+	} else {
+		scheduleFolderEvent(EventFolderPublished, folderID, *publishAt, currentSchedulerInterval(), publishFolder)
+	}
+	return folder, nil
+}
+
+// StartFolderScheduler looks every interval for the folders whose scheduled publication, expiry
+// or expiry warning is due before the next look, and sets a timer sending it on time. Several instances may set timers for the
+// same folder; the event is sent once, by whichever claims it first.
+func StartFolderScheduler(ctx context.Context, interval time.Duration) {
+	scheduledMu.Lock()
+	schedulerInterval = interval
+	scheduledMu.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			scanCtx, cancel := context.WithTimeout(ctx, interval)
+			if err := scheduleDueFolders(scanCtx, interval); err != nil {
+				log.Printf("ERROR: Folder scheduler failed: %v", err)
+			}
+			if err := scheduleDueExpiries(scanCtx, interval); err != nil {
+				log.Printf("ERROR: Folder scheduler failed: %v", err)
+			}
+			cancel()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func currentSchedulerInterval() time.Duration {
+	scheduledMu.Lock()
+	defer scheduledMu.Unlock()
+	return schedulerInterval
+}
+
+// scheduleDueFolders sets timers for the folders to publish within horizon.
+func scheduleDueFolders(ctx context.Context, horizon time.Duration) error {
+	docs, err := Client.Collection(FoldersCollection).Where("publishPending", "==", true).Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to query folders pending publication: %v", err)
+	}
+	for _, doc := range docs {
+		var folder FolderMetadata
+		if err := doc.DataTo(&folder); err != nil {
+			log.Printf("Error reading folder %s pending publication: %v", doc.Ref.ID, err)
+			continue
+		}
+		if folder.PublishAt == nil {
+			continue
+		}
+		scheduleFolderEvent(EventFolderPublished, folder.ID, *folder.PublishAt, horizon, publishFolder)
+	}
+	return nil
+}
+
+// scheduleFolderEvent calls fire with the folder ID at the given time, if that is within horizon
+// and no timer is pending for the event yet.
+func scheduleFolderEvent(event, folderID string, at time.Time, horizon time.Duration, fire func(ctx context.Context, folderID string) error) {
+	wait := at.Sub(now())
+	if wait > horizon {
+		return
+	}
+	key := event + "/" + folderID
+	scheduledMu.Lock()
+	defer scheduledMu.Unlock()
+	if scheduled[key] {
+		return
+	}
+	scheduled[key] = true
+	time.AfterFunc(max(wait, 0), func() {
+		scheduledMu.Lock()
+		delete(scheduled, key)
+		scheduledMu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := fire(ctx, folderID); err != nil {
+			log.Printf("ERROR: Failed to send %s of folder %s: %v", event, folderID, err)
+		}
+	})
+}
+
+// publishFolder claims the publication of a folder whose PublishAt has come, and sends
+// EventFolderPublished if this call did.
+func publishFolder(ctx context.Context, folderID string) error {
+	var folder *FolderMetadata
+	ref := Client.Collection(FoldersCollection).Doc(folderID)
+	err := Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		folder = nil
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var f FolderMetadata
+		if err := doc.DataTo(&f); err != nil {
+			return err
+		}
+		if !f.PublishPending || !f.IsPublished() {
+			return nil // Already sent, or rescheduled
+		}
+		folder = &f
+		return tx.Update(ref, []firestore.Update{{Path: "publishPending", Value: firestore.Delete}})
+	})
+	if err != nil || folder == nil {
+		return err
+	}
+	folder.PublishPending = false
+	log.Printf("Folder %s published.", folderID)
+	BroadcastEvent(EventFolderUpdated, *folder)
+	BroadcastEvent(EventFolderPublished, *folder)
+	return nil
+}
@@ -0,0 +1,262 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// JobSearchReindex is the Job type of StartSearchReindex. Its Result holds the indexer's name,
+// and the "files" and "profiles" counts sent to it when it is done.
+const JobSearchReindex = "search_reindex"
+
+// searchReindexBatch is the number of documents sent to the indexer at a time.
+const searchReindexBatch = 200
+
+// searchReindexTimeout bounds a re-index running in the background.
+const searchReindexTimeout = time.Hour
+
+// SearchIndexer keeps a search backend in step with the gallery's files and profiles. New uploads
+// are indexed at StageIndex; StartSearchReindex sends every document again, e.g. after search is
+// enabled on an existing gallery or the index schema changed. Indexing a document that is already
+// indexed replaces it. RemoveProfiles drops deleted profiles, which re-indexing would not.
+type SearchIndexer interface {
+	Name() string
+	IndexFiles(ctx context.Context, files []FileMetadata) error
+	IndexProfiles(ctx context.Context, profiles []Profile) error
+	RemoveProfiles(ctx context.Context, ids []string) error
+}
+
+var (
+	searchIndexerMu sync.Mutex
+	// searchIndexer defaults to the nameSearch field, the prefix search Firestore serves itself.
+	searchIndexer SearchIndexer = firestoreSearchIndexer{}
+)
+
+func init() {
+	RegisterUploadHook(StageIndex, indexUpload)
+}
+
+// SetSearchIndexer replaces the configured SearchIndexer.
+func SetSearchIndexer(indexer SearchIndexer) {
+	searchIndexerMu.Lock()
+	defer searchIndexerMu.Unlock()
+	searchIndexer = indexer
+}
+
+func currentSearchIndexer() SearchIndexer {
+	searchIndexerMu.Lock()
+	defer searchIndexerMu.Unlock()
+	return searchIndexer
+}
+
+// InitSearchIndexer configures a Meilisearch index from MEILISEARCH_URL and MEILISEARCH_API_KEY.
+// Without MEILISEARCH_URL the nameSearch field stays the search backend.
+func InitSearchIndexer() {
+	baseURL := strings.TrimRight(os.Getenv("MEILISEARCH_URL"), "/")
+	if baseURL == "" {
+		return
+	}
+	SetSearchIndexer(&meilisearchIndexer{
+		baseURL: baseURL,
+		apiKey:  os.Getenv("MEILISEARCH_API_KEY"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+	})
+	log.Printf("Search index: Meilisearch at %s", baseURL)
+}
+
+// indexUpload is the StageIndex hook sending a new file to the configured SearchIndexer.
+// Uploads awaiting approval are indexed by ApproveUploads instead.
+func indexUpload(ctx context.Context, u *Upload) error {
+	if u.File.PendingApproval {
+		return nil
+	}
+	return currentSearchIndexer().IndexFiles(ctx, []FileMetadata{*u.File})
+}
+
+// firestoreSearchIndexer maintains nameSearch, the lowercase name prefix queries match against.
+// Profiles are few and listed whole, so they have no index.
+type firestoreSearchIndexer struct{}
+
+func (firestoreSearchIndexer) Name() string { return "firestore" }
+
+func (firestoreSearchIndexer) IndexFiles(ctx context.Context, files []FileMetadata) error {
+	var updates []FileFieldUpdate
+	for _, file := range files {
+		if key := strings.ToLower(file.Name); key != file.NameSearch {
+			updates = append(updates, FileFieldUpdate{ID: file.ID, Fields: map[string]interface{}{"nameSearch": key}})
+		}
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	failed := BulkUpdateFiles(ctx, updates)
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to update nameSearch of %d of %d files", len(failed), len(updates))
+	}
+	return nil
+}
+
+func (firestoreSearchIndexer) IndexProfiles(ctx context.Context, profiles []Profile) error {
+	return nil
+}
+
+func (firestoreSearchIndexer) RemoveProfiles(ctx context.Context, ids []string) error {
+	return nil
+}
+
+// meilisearchIndexer sends documents to the "files" and "profiles" indexes of a Meilisearch
+// server, which match them by their "id".
+type meilisearchIndexer struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func (m *meilisearchIndexer) Name() string { return "meilisearch" }
+
+func (m *meilisearchIndexer) IndexFiles(ctx context.Context, files []FileMetadata) error {
+	return m.addDocuments(ctx, "files", files)
+}
+
+func (m *meilisearchIndexer) IndexProfiles(ctx context.Context, profiles []Profile) error {
+	return m.addDocuments(ctx, "profiles", profiles)
+}
+
+func (m *meilisearchIndexer) RemoveProfiles(ctx context.Context, ids []string) error {
+	return m.post(ctx, "profiles", "/documents/delete-batch", ids)
+}
+
+// addDocuments adds or replaces documents of an index. Meilisearch applies them asynchronously,
+// so a nil error means they were accepted.
+func (m *meilisearchIndexer) addDocuments(ctx context.Context, index string, documents interface{}) error {
+	return m.post(ctx, index, "/documents?primaryKey=id", documents)
+}
+
+// post sends a JSON body to an endpoint of an index, path being relative to /indexes/{index}.
+func (m *meilisearchIndexer) post(ctx context.Context, index, path string, documents interface{}) error {
+	body, err := json.Marshal(documents)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s documents: %v", index, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+"/indexes/"+index+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send %s documents to Meilisearch: %v", index, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Meilisearch rejected %s documents: status %d", index, resp.StatusCode)
+	}
+	return nil
+}
+
+// ReindexSearch sends every file and profile to the configured SearchIndexer, searchReindexBatch
+// documents at a time. onBatch, if not nil, is called with the IDs of every batch and the
+// indexer's error for it. It returns the number of files and profiles indexed.
+func ReindexSearch(ctx context.Context, onBatch func(ids []string, err error)) (int, int, error) {
+	indexer := currentSearchIndexer()
+	report := func(ids []string, err error) {
+		if err != nil {
+			log.Printf("Error indexing %d documents in %s: %v", len(ids), indexer.Name(), err)
+		}
+		if onBatch != nil {
+			onBatch(ids, err)
+		}
+	}
+
+	// Pages are read one at a time, as indexing a page may take longer than a query stream may stay open
+	query := Client.Collection(FilesCollection).OrderBy(firestore.DocumentID, firestore.Asc)
+	files := 0
+	err := pagedQuery(ctx, query, searchReindexBatch, 0, func(docs []*firestore.DocumentSnapshot) error {
+		batch := make([]FileMetadata, 0, len(docs))
+		ids := make([]string, 0, len(docs))
+		for _, doc := range docs {
+			var file FileMetadata
+			if err := doc.DataTo(&file); err != nil {
+				report([]string{doc.Ref.ID}, fmt.Errorf("failed to unmarshal file metadata: %v", err))
+				continue
+			}
+			file.ID = doc.Ref.ID
+			batch = append(batch, file)
+			ids = append(ids, file.ID)
+		}
+		if len(batch) > 0 {
+			err := indexer.IndexFiles(ctx, batch)
+			if err == nil {
+				files += len(batch)
+			}
+			report(ids, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return files, 0, err
+	}
+
+	profiles, err := GetProfiles(ctx, true)
+	if err != nil {
+		return files, 0, err
+	}
+	indexed := 0
+	for start := 0; start < len(profiles); start += searchReindexBatch {
+		batch := profiles[start:min(start+searchReindexBatch, len(profiles))]
+		ids := make([]string, len(batch))
+		for i, profile := range batch {
+			ids[i] = profile.ID
+		}
+		err := indexer.IndexProfiles(ctx, batch)
+		if err == nil {
+			indexed += len(batch)
+		}
+		report(ids, err)
+	}
+	log.Printf("Search re-index in %s: %d files and %d profiles indexed.", indexer.Name(), files, indexed)
+	return files, indexed, nil
+}
+
+// StartSearchReindex runs ReindexSearch in the background and returns the Job tracking it. The
+// documents of batches the indexer rejects are recorded as failed items.
+func StartSearchReindex(ctx context.Context) (*Job, error) {
+	job, err := startJob(ctx, JobSearchReindex, map[string]string{"indexer": currentSearchIndexer().Name()})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), searchReindexTimeout)
+		defer cancel()
+		total, err := countDocuments(ctx, Client.Collection(FilesCollection).Query)
+		if err == nil {
+			var profiles int64
+			if profiles, err = countDocuments(ctx, Client.Collection(profileCollection).Query); err == nil {
+				job.setTotal(ctx, int(total+profiles))
+			}
+		}
+		files, profiles, err := ReindexSearch(ctx, func(ids []string, err error) {
+			for _, id := range ids {
+				job.itemDone(ctx, id, err)
+			}
+		})
+		job.setResult("files", strconv.Itoa(files))
+		job.setResult("profiles", strconv.Itoa(profiles))
+		job.finish(ctx, err)
+	}()
+	return job, nil
+}
@@ -0,0 +1,236 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// SignedUploadTTL is how long a signed upload URL stays valid.
+const SignedUploadTTL = 15 * time.Minute
+
+// DirectUploadFile describes a file a client uploads straight to Storage with a signed URL.
+type DirectUploadFile struct {
+	RelativePath string    `json:"relative_path" validate:"required"`
+	MimeType     string    `json:"mime_type" validate:"required"`
+	Hash         string    `json:"hash" validate:"sha256"` // SHA256 of the content, used for deduplication
+	Size         int64     `json:"size"`
+	ModifiedAt   time.Time `json:"modified_at"`   // File modification time, optional
+	OriginalPath string    `json:"original_path"` // Absolute path on the uploading machine, optional
+	// License (one of Licenses) and PhotographerName credit the file, optional
+	License          string `json:"license" validate:"omitempty,oneof=CC-BY CC-BY-SA CC-BY-NC CC0 all-rights-reserved"`
+	PhotographerName string `json:"photographer_name" validate:"max=200"`
+}
+
+// SignedUpload is the signed PUT URL issued for a DirectUploadFile.
+type SignedUpload struct {
+	RelativePath string    `json:"relativePath"`
+	StoragePath  string    `json:"storagePath"`
+	UploadURL    string    `json:"uploadUrl,omitempty"` // Empty if the content is already stored
+	Exists       bool      `json:"exists"`
+	ExpiresAt    time.Time `json:"expiresAt,omitempty"`
+	Error        string    `json:"error,omitempty"` // Why no URL was issued, e.g. the path is in use
+}
+
+// StoragePathInUseError is returned for a direct upload to a Storage path that holds the object
+// of another file. Its object is never replaced; delete the file first.
+type StoragePathInUseError struct {
+	StoragePath string
+	FileID      string
+}
+
+func (e *StoragePathInUseError) Error() string {
+	return fmt.Sprintf("storage path %s is in use by file %s", e.StoragePath, e.FileID)
+}
+
+func (e *StoragePathInUseError) Is(target error) bool { return target == ErrConflict }
+
+// checkStoragePathFree returns a StoragePathInUseError if a file document owns the object at
+// storagePath in the bucket, or a LegalHoldError if that file is under legal hold.
+func checkStoragePathFree(ctx context.Context, bucketName, storagePath string) error {
+	docs, err := Client.Collection(FilesCollection).Where("storagePath", "==", storagePath).Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to query files stored at %s: %v", storagePath, err)
+	}
+	for _, doc := range docs {
+		var file FileMetadata
+		if err := doc.DataTo(&file); err != nil {
+			return fmt.Errorf("failed to unmarshal file metadata from doc %s: %v", doc.Ref.ID, err)
+		}
+		if file.Bucket != bucketName {
+			continue
+		}
+		if err := checkFileLegalHold(ctx, doc.Ref.ID, file.FolderID, file.LegalHold); err != nil {
+			return err
+		}
+		return &StoragePathInUseError{StoragePath: storagePath, FileID: doc.Ref.ID}
+	}
+	return nil
+}
+
+// CreateSignedUploadURLs issues signed PUT URLs for uploading files directly to Firebase Storage.
+// Files whose content hash is already stored are reported as existing and get no URL, as are
+// files whose path holds the object of another file, with the reason in SignedUpload.Error.
+// The client must send the file's MIME type as Content-Type and call FinalizeDirectUploads afterwards.
+func CreateSignedUploadURLs(ctx context.Context, folderName string, files []DirectUploadFile) ([]SignedUpload, error) {
+	folderID, err := resolveFolderID(ctx, folderName)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0, len(files))
+	for _, f := range files {
+		hashes = append(hashes, f.Hash)
+	}
+	existing, err := FindFilesByHashes(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	var folder *FolderMetadata // Nil for the root, which has no folder document
+	if folderID != "" {
+		if folder, err = GetFolder(ctx, folderID); err != nil {
+			return nil, err
+		}
+	}
+
+	expiresAt := now().Add(SignedUploadTTL)
+	uploads := make([]SignedUpload, 0, len(files))
+	for _, f := range files {
+		bucketName := bucketFor(folder, f.MimeType)
+		bucket, err := bucketHandle(bucketName)
+		if err != nil {
+			return nil, err
+		}
+		upload := SignedUpload{RelativePath: f.RelativePath}
+		upload.StoragePath, _ = storagePathFor(StoragePathInput{FolderID: folderID, FolderName: folderName, RelativePath: f.RelativePath, Hash: f.Hash, CapturedAt: f.ModifiedAt})
+		if _, ok := existing[f.Hash]; ok {
+			upload.Exists = true
+			uploads = append(uploads, upload)
+			continue
+		}
+		if err := checkStoragePathFree(ctx, bucketName, upload.StoragePath); err != nil {
+			if !errors.Is(err, ErrConflict) {
+				return nil, err
+			}
+			upload.Error = err.Error()
+			uploads = append(uploads, upload)
+			continue
+		}
+		upload.UploadURL, err = bucket.SignedURL(upload.StoragePath, &gcs.SignedURLOptions{
+			Scheme:      gcs.SigningSchemeV4,
+			Method:      "PUT",
+			ContentType: f.MimeType,
+			Expires:     expiresAt,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign upload URL for %s: %v", upload.StoragePath, err)
+		}
+		upload.ExpiresAt = expiresAt
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
+// FinalizeDirectUploads saves metadata for files uploaded with signed URLs and makes them public.
+// uploaderUID is the signed-in user who uploaded them, or empty.
+// It returns the metadata of each finalized file, keyed by relative path; files whose object is
+// missing, has an unexpected size or SHA-256, or whose path holds the object of another file are
+// reported in the error map instead. The stored object is hashed before anything else, as the
+// hash the client sent picks the duplicate it is linked to; objects of other files are never
+// deleted. Files then pass through the upload pipeline like other uploads, and the object of a
+// file a hook rejects, e.g. of a media type its folder does not allow, is deleted.
+func FinalizeDirectUploads(ctx context.Context, folderName, uploaderUID string, files []DirectUploadFile) (map[string]FileMetadata, map[string]string, error) {
+	folderID, err := resolveFolderID(ctx, folderName)
+	if err != nil {
+		return nil, nil, err
+	}
+	var folder *FolderMetadata // Nil for the root, which has no folder document
+	if folderID != "" {
+		if folder, err = GetFolder(ctx, folderID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	finalized := make(map[string]FileMetadata)
+	failed := make(map[string]string)
+	for _, f := range files {
+		bucketName := bucketFor(folder, f.MimeType)
+		bucket, err := bucketHandle(bucketName)
+		if err != nil {
+			return nil, nil, err
+		}
+		storagePath, pathStrategy := storagePathFor(StoragePathInput{FolderID: folderID, FolderName: folderName, RelativePath: f.RelativePath, Hash: f.Hash, CapturedAt: f.ModifiedAt})
+
+		// Another client may have stored the same content since the URL was issued
+		existingFile, err := FindFileByHash(ctx, f.Hash)
+		if err != nil {
+			failed[f.RelativePath] = err.Error()
+			continue
+		}
+		if existingFile != nil && existingFile.StoragePath == storagePath && existingFile.Bucket == bucketName {
+			finalized[f.RelativePath] = *existingFile // Finalized before
+			continue
+		}
+		if err := checkStoragePathFree(ctx, bucketName, storagePath); err != nil {
+			failed[f.RelativePath] = err.Error()
+			continue
+		}
+
+		attrs, err := bucket.Object(storagePath).Attrs(ctx)
+		if err != nil {
+			failed[f.RelativePath] = fmt.Sprintf("uploaded object not found: %v", err)
+			continue
+		}
+		if f.Size > 0 && attrs.Size != f.Size {
+			failed[f.RelativePath] = fmt.Sprintf("size mismatch: expected %d bytes, got %d", f.Size, attrs.Size)
+			continue
+		}
+		if err := verifyObjectSHA256(ctx, bucket.Object(storagePath), f.Hash); err != nil {
+			if delErr := bucket.Object(storagePath).Delete(ctx); delErr != nil {
+				log.Printf("Warning: Could not delete mismatched object %s: %v", storagePath, delErr)
+			}
+			failed[f.RelativePath] = err.Error()
+			continue
+		}
+
+		u := &Upload{
+			FolderID:     folderID,
+			FolderName:   folderName,
+			RelativePath: f.RelativePath,
+			MimeType:     f.MimeType,
+			Hash:         f.Hash,
+			Source:       SourceInfo{ModifiedAt: f.ModifiedAt, OriginalPath: f.OriginalPath, UploaderUID: uploaderUID, License: f.License, PhotographerName: f.PhotographerName},
+			Bucket:       bucket,
+			BucketName:   bucketName,
+			StoragePath:  storagePath,
+			PathStrategy: pathStrategy,
+		}
+		if err := runUploadChecks(ctx, u); err != nil {
+			if delErr := bucket.Object(storagePath).Delete(ctx); delErr != nil {
+				log.Printf("Warning: Could not delete rejected object %s: %v", storagePath, delErr)
+			}
+			failed[f.RelativePath] = err.Error()
+			continue
+		}
+		if existingFile != nil {
+			if err := bucket.Object(storagePath).Delete(ctx); err != nil && err != gcs.ErrObjectNotExist {
+				log.Printf("Warning: Could not delete duplicate object %s: %v", storagePath, err)
+			}
+			finalized[f.RelativePath] = *existingFile
+			continue
+		}
+
+		fileMetadata, err := publishStoredObject(ctx, u)
+		if err != nil {
+			failed[f.RelativePath] = err.Error()
+			continue
+		}
+		finalized[f.RelativePath] = *fileMetadata
+	}
+	return finalized, failed, nil
+}
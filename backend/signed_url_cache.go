@@ -0,0 +1,219 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// Private URLs are signed for windows of signedURLWindow: every URL signed within a window
+// expires PrivateURLTTL after the window started, so it stays valid for at least
+// PrivateURLTTL - signedURLWindow, and listings within a window can reuse the same signatures
+// (and the browser cache, as the URLs don't change).
+const signedURLWindow = 5 * time.Minute
+
+const (
+	// hotFolderTTL is how long after its last listing a private folder keeps being refreshed.
+	hotFolderTTL = 30 * time.Minute
+	// maxHotFolders bounds the folders signed ahead per window, most recently listed first.
+	maxHotFolders = 20
+	// signedURLRefreshLead is how long before a window starts its URLs are signed ahead.
+	signedURLRefreshLead = time.Minute
+)
+
+// signedURLExpiry returns the expiry of private URLs signed at t.
+func signedURLExpiry(t time.Time) time.Time {
+	return t.Truncate(signedURLWindow).Add(PrivateURLTTL)
+}
+
+type signedURLKey struct {
+	bucket  string
+	path    string
+	expires int64
+}
+
+var signedURLs = struct {
+	sync.Mutex
+	urls      map[signedURLKey]string
+	latest    int64 // Newest expiry cached; older windows are pruned when it advances
+	hot       map[string]time.Time
+	hits      int64
+	misses    int64
+	refreshed int64
+}{urls: make(map[signedURLKey]string), hot: make(map[string]time.Time)}
+
+// SignedURLCacheStats reports the signed URL cache for the admin dashboard.
+type SignedURLCacheStats struct {
+	Cached     int   `json:"cached"`     // URLs of the current and upcoming windows
+	Hits       int64 `json:"hits"`       // URLs served from the cache
+	Misses     int64 `json:"misses"`     // URLs signed on request
+	Refreshed  int64 `json:"refreshed"`  // URLs signed ahead for hot folders
+	HotFolders int   `json:"hotFolders"` // Private folders listed within the last 30 minutes
+}
+
+// SignedURLStats returns the counters of the signed URL cache.
+func SignedURLStats() SignedURLCacheStats {
+	signedURLs.Lock()
+	defer signedURLs.Unlock()
+	return SignedURLCacheStats{
+		Cached:     len(signedURLs.urls),
+		Hits:       signedURLs.hits,
+		Misses:     signedURLs.misses,
+		Refreshed:  signedURLs.refreshed,
+		HotFolders: len(signedURLs.hot),
+	}
+}
+
+// signedGetURL returns a GET URL of an object in the named bucket ("" for the default) that
+// expires at expires, from the cache if it was signed before.
+func signedGetURL(bucketName string, bucket *gcs.BucketHandle, path string, expires time.Time) (string, error) {
+	key := signedURLKey{bucket: bucketName, path: path, expires: expires.Unix()}
+	signedURLs.Lock()
+	if u, ok := signedURLs.urls[key]; ok {
+		signedURLs.hits++
+		signedURLs.Unlock()
+		return u, nil
+	}
+	signedURLs.misses++
+	signedURLs.Unlock()
+
+	u, err := signURL(bucket, path, expires)
+	if err != nil {
+		return "", err
+	}
+	storeSignedURL(key, u)
+	return u, nil
+}
+
+func signURL(bucket *gcs.BucketHandle, path string, expires time.Time) (string, error) {
+	u, err := bucket.SignedURL(path, &gcs.SignedURLOptions{
+		Scheme:  gcs.SigningSchemeV4,
+		Method:  "GET",
+		Expires: expires,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign download URL for %s: %v", path, err)
+	}
+	return u, nil
+}
+
+// storeSignedURL caches a URL, dropping the URLs of windows that have passed once a newer
+// window appears.
+func storeSignedURL(key signedURLKey, u string) {
+	signedURLs.Lock()
+	defer signedURLs.Unlock()
+	if key.expires > signedURLs.latest {
+		signedURLs.latest = key.expires
+		current := signedURLExpiry(now()).Unix()
+		for k := range signedURLs.urls {
+			if k.expires < current {
+				delete(signedURLs.urls, k)
+			}
+		}
+	}
+	signedURLs.urls[key] = u
+}
+
+// noteHotFolder records that a private folder was listed, so its URLs are signed ahead.
+func noteHotFolder(folderID string) {
+	signedURLs.Lock()
+	defer signedURLs.Unlock()
+	signedURLs.hot[folderID] = now()
+}
+
+// hotFolders returns the private folders listed within hotFolderTTL, most recent first, and
+// forgets the others.
+func hotFolders() []string {
+	signedURLs.Lock()
+	defer signedURLs.Unlock()
+	cutoff := now().Add(-hotFolderTTL)
+	ids := make([]string, 0, len(signedURLs.hot))
+	for id, listed := range signedURLs.hot {
+		if listed.Before(cutoff) {
+			delete(signedURLs.hot, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return signedURLs.hot[ids[i]].After(signedURLs.hot[ids[j]]) })
+	if len(ids) > maxHotFolders {
+		ids = ids[:maxHotFolders]
+	}
+	return ids
+}
+
+// refreshHotFolders signs the URLs of the files of hot private folders for the window starting
+// at windowStart, so the first listings in it are served from the cache.
+func refreshHotFolders(ctx context.Context, windowStart time.Time) {
+	expires := signedURLExpiry(windowStart)
+	for _, folderID := range hotFolders() {
+		folder, err := GetFolder(ctx, folderID)
+		if err != nil || folder == nil || !folder.IsPrivate() {
+			continue
+		}
+		files, err := ListAllFilesInFolder(ctx, folderID)
+		if err != nil {
+			log.Printf("Warning: Could not list folder %s to refresh signed URLs: %v", folderID, err)
+			continue
+		}
+		for i := range files {
+			if ctx.Err() != nil {
+				return
+			}
+			key := signedURLKey{bucket: files[i].Bucket, path: files[i].StoragePath, expires: expires.Unix()}
+			signedURLs.Lock()
+			_, ok := signedURLs.urls[key]
+			signedURLs.Unlock()
+			if ok {
+				continue
+			}
+			bucket, err := fileBucket(&files[i])
+			if err != nil {
+				log.Printf("Warning: Could not refresh signed URLs of folder %s: %v", folderID, err)
+				break
+			}
+			u, err := signURL(bucket, files[i].StoragePath, expires)
+			if err != nil {
+				log.Printf("Warning: %v", err)
+				continue
+			}
+			storeSignedURL(key, u)
+			signedURLs.Lock()
+			signedURLs.refreshed++
+			signedURLs.Unlock()
+		}
+	}
+}
+
+// StartSignedURLRefresher signs the URLs of recently listed private folders shortly before each
+// signing window starts, until ctx is done.
+func StartSignedURLRefresher(ctx context.Context) {
+	go func() {
+		for {
+			next := now().Truncate(signedURLWindow).Add(signedURLWindow)
+			timer := time.NewTimer(max(next.Add(-signedURLRefreshLead).Sub(now()), 0))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			refreshCtx, cancel := context.WithTimeout(ctx, signedURLWindow)
+			refreshHotFolders(refreshCtx, next)
+			cancel()
+			// Don't refresh the same window twice when the refresh was quick
+			if wait := next.Sub(now()); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+		}
+	}()
+}
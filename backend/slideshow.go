@@ -0,0 +1,102 @@
+package backend
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+)
+
+// slideshowPreloadAhead is how many upcoming items each slideshow item lists for preloading.
+const slideshowPreloadAhead = 2
+
+// SlideshowItem is one image or video of a slideshow.
+type SlideshowItem struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	MimeType  string   `json:"mimeType"`
+	MediaType string   `json:"mediaType"`        // "image" or "video"
+	Duration  float64  `json:"duration"`         // Seconds to show an image; 0 for videos, which play to the end
+	Preload   []string `json:"preload"`          // URLs of the next items, to fetch while this one is shown
+	Credit    string   `json:"credit,omitempty"` // Photographer and license (see FileMetadata.Credit)
+}
+
+// Slideshow is an ordered playlist of a folder's images and videos.
+type Slideshow struct {
+	FolderID string          `json:"folderId"`
+	Shuffled bool            `json:"shuffled"`
+	Seed     int64           `json:"seed,omitempty"` // Pass back to get the same order again
+	Items    []SlideshowItem `json:"items"`
+}
+
+// BuildSlideshow returns the images and videos of a folder as a playlist. A missing folder is
+// ErrNotFound. Items are ordered by shoot date, or shuffled deterministically by seed.
+// Images are shown for duration seconds. Public viewers don't get files showing people hidden from
+// public galleries (see WithPublicViewer).
+func BuildSlideshow(ctx context.Context, folderID string, shuffle bool, seed int64, duration float64) (*Slideshow, error) {
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil || !IsFolderVisible(ctx, folder) {
+		return nil, notFound("folder %s", folderID)
+	}
+	files, err := ListAllFilesInFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	hidden, err := hiddenPeopleFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files = withoutHiddenPeople(files, hidden)
+
+	if err := AttachAccessURLs(folder, files); err != nil {
+		return nil, err
+	}
+
+	var media []FileMetadata
+	for _, f := range files {
+		if t := mediaTypeOf(f.MimeType); t == "image" || t == "video" {
+			media = append(media, f)
+		}
+	}
+	sort.SliceStable(media, func(i, j int) bool {
+		a, b := media[i].CapturedAt, media[j].CapturedAt
+		if a.IsZero() {
+			a = media[i].CreatedAt
+		}
+		if b.IsZero() {
+			b = media[j].CreatedAt
+		}
+		if a.Equal(b) {
+			return media[i].ID < media[j].ID // Stable across requests, so shuffles with the same seed match
+		}
+		return a.Before(b)
+	})
+
+	slideshow := &Slideshow{FolderID: folderID, Shuffled: shuffle, Items: make([]SlideshowItem, 0, len(media))}
+	if shuffle {
+		if seed == 0 {
+			seed = now().UnixNano()
+		}
+		slideshow.Seed = seed
+		r := rand.New(rand.NewSource(seed))
+		r.Shuffle(len(media), func(i, j int) { media[i], media[j] = media[j], media[i] })
+	}
+
+	for _, f := range media {
+		item := SlideshowItem{ID: f.ID, URL: f.DownloadURL, MimeType: f.MimeType, MediaType: mediaTypeOf(f.MimeType), Credit: f.Credit()}
+		if item.MediaType == "image" {
+			item.Duration = duration
+		}
+		slideshow.Items = append(slideshow.Items, item)
+	}
+	// The slideshow loops, so the last items preload the first ones
+	for i := range slideshow.Items {
+		for k := 1; k <= slideshowPreloadAhead && k < len(slideshow.Items); k++ {
+			next := slideshow.Items[(i+k)%len(slideshow.Items)]
+			slideshow.Items[i].Preload = append(slideshow.Items[i].Preload, next.URL)
+		}
+	}
+	return slideshow, nil
+}
@@ -0,0 +1,255 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/firestore"
+)
+
+// maxSlugLength bounds generated and user-supplied folder slugs.
+const maxSlugLength = 64
+
+// slugPattern is the form of a valid slug: lowercase ASCII words separated by single hyphens.
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// hiraganaRomaji maps hiragana to Hepburn romaji. Katakana is converted to hiragana first.
+var hiraganaRomaji = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n", 'ゔ': "vu",
+	'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o",
+}
+
+// smallYVowels are the vowels of the small ya/yu/yo that form digraphs such as きゃ (kya).
+var smallYVowels = map[rune]string{'ゃ': "a", 'ゅ': "u", 'ょ': "o"}
+
+// kanjiReadings are readings of kanji common in event folder names. Other kanji have no
+// reading here and are dropped from generated slugs; such slugs can be edited afterwards.
+var kanjiReadings = map[rune]string{
+	'第': "dai", '回': "kai", '年': "nen", '月': "gatsu", '日': "nichi", '号': "gou",
+	'春': "haru", '夏': "natsu", '秋': "aki", '冬': "fuyu",
+	'公': "kou", '演': "en", '会': "kai", '祭': "matsuri", '部': "bu", '大': "dai",
+	'写': "sha", '真': "shin", '動': "dou", '画': "ga", '練': "ren", '習': "shuu",
+	'本': "hon", '番': "ban", '前': "zen", '後': "go", '新': "shin", '定': "tei", '期': "ki",
+	'記': "ki", '念': "nen", '特': "toku", '別': "betsu",
+}
+
+// toHiragana converts katakana to hiragana and leaves other runes unchanged.
+func toHiragana(r rune) rune {
+	if r >= 'ァ' && r <= 'ヶ' {
+		return r - 0x60
+	}
+	return r
+}
+
+// toHalfWidth converts full-width ASCII letters and digits to their ASCII forms.
+func toHalfWidth(r rune) rune {
+	if r >= '！' && r <= '～' {
+		return r - 0xFEE0
+	}
+	return r
+}
+
+// romanizeKana converts a run of hiragana to romaji, handling digraphs (きゃ), the small
+// っ that doubles the next consonant, and the long vowel mark, which is dropped.
+func romanizeKana(kana []rune) string {
+	var b strings.Builder
+	double := false
+	for i := 0; i < len(kana); i++ {
+		r := kana[i]
+		if r == 'っ' {
+			double = true
+			continue
+		}
+		if r == 'ー' {
+			continue
+		}
+		syllable, ok := hiraganaRomaji[r]
+		if !ok {
+			if vowel, small := smallYVowels[r]; small {
+				syllable = "y" + vowel
+			} else {
+				continue
+			}
+		}
+		if i+1 < len(kana) && strings.HasSuffix(syllable, "i") && len(syllable) > 1 {
+			if vowel, ok := smallYVowels[kana[i+1]]; ok {
+				switch stem := strings.TrimSuffix(syllable, "i"); stem {
+				case "sh", "ch", "j":
+					syllable = stem + vowel
+				default:
+					syllable = stem + "y" + vowel
+				}
+				i++
+			}
+		}
+		if double {
+			if strings.HasPrefix(syllable, "ch") {
+				b.WriteByte('t')
+			} else if c := syllable[0]; !strings.ContainsRune("aiueon", rune(c)) {
+				b.WriteByte(c)
+			}
+			double = false
+		}
+		b.WriteString(syllable)
+	}
+	return b.String()
+}
+
+// Slugify derives a URL slug from a folder name, e.g. "第1回" becomes "dai-1-kai". ASCII letters
+// and digits are kept, kana is romanized and known kanji are replaced by their reading; words of
+// different scripts are separated by hyphens. It returns "" if nothing could be transliterated.
+func Slugify(name string) string {
+	const (
+		classNone = iota
+		classASCII
+		classKana
+		classKanji
+	)
+	var words []string
+	var word strings.Builder
+	var kana []rune
+	class := classNone
+	flush := func() {
+		if class == classKana {
+			word.WriteString(romanizeKana(kana))
+			kana = kana[:0]
+		}
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+		class = classNone
+	}
+
+	for _, r := range name {
+		r = unicode.ToLower(toHalfWidth(r))
+		h := toHiragana(r)
+		var next int
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			next = classASCII
+		case (h >= 'ぁ' && h <= 'ゖ') || r == 'ー':
+			next = classKana
+		case kanjiReadings[r] != "":
+			next = classKanji
+		}
+		if next != class {
+			flush()
+			class = next
+		}
+		switch next {
+		case classASCII:
+			word.WriteRune(r)
+		case classKana:
+			kana = append(kana, h)
+		case classKanji:
+			word.WriteString(kanjiReadings[r])
+		}
+	}
+	flush()
+
+	slug := ""
+	for _, w := range words {
+		if w == "" {
+			continue
+		}
+		if len(slug)+len(w)+1 > maxSlugLength {
+			break
+		}
+		if slug != "" {
+			slug += "-"
+		}
+		slug += w
+	}
+	return slug
+}
+
+// ValidSlug reports whether slug can be used as a folder slug.
+func ValidSlug(slug string) bool {
+	return len(slug) <= maxSlugLength && slugPattern.MatchString(slug)
+}
+
+// FindFolderBySlug returns the folder with the given slug, or nil if there is none.
+func FindFolderBySlug(ctx context.Context, slug string) (*FolderMetadata, error) {
+	docs, err := Client.Collection(FoldersCollection).Where("slug", "==", slug).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query folder by slug '%s': %v", slug, err)
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	var folder FolderMetadata
+	if err := docs[0].DataTo(&folder); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal folder metadata: %v", err)
+	}
+	return &folder, nil
+}
+
+// uniqueFolderSlug returns base, or base with a numeric suffix, that no folder other than
+// folderID uses. An empty base becomes "folder".
+func uniqueFolderSlug(ctx context.Context, base, folderID string) (string, error) {
+	if base == "" {
+		base = "folder"
+	}
+	candidate := base
+	for n := 2; ; n++ {
+		existing, err := FindFolderBySlug(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil || existing.ID == folderID {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// SetFolderSlug sets the slug of a folder. An empty slug regenerates it from the folder name.
+// A slug already used by another folder is rejected; a generated one gets a numeric suffix.
+func SetFolderSlug(ctx context.Context, folderID, slug string) (string, error) {
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return "", err
+	}
+	if folder == nil {
+		return "", notFound("folder %s", folderID)
+	}
+	if slug == "" {
+		if slug, err = uniqueFolderSlug(ctx, Slugify(folder.Name), folderID); err != nil {
+			return "", err
+		}
+	} else {
+		if !ValidSlug(slug) {
+			return "", fmt.Errorf("invalid slug '%s': use lowercase letters, digits and single hyphens (at most %d characters)", slug, maxSlugLength)
+		}
+		existing, err := FindFolderBySlug(ctx, slug)
+		if err != nil {
+			return "", err
+		}
+		if existing != nil && existing.ID != folderID {
+			return "", fmt.Errorf("slug '%s' is already used by folder '%s'", slug, existing.Name)
+		}
+	}
+	if _, err := Client.Collection(FoldersCollection).Doc(folderID).Update(ctx, []firestore.Update{{Path: "slug", Value: slug}}); err != nil {
+		return "", fmt.Errorf("failed to set slug of folder %s: %v", folderID, err)
+	}
+	recordFolderChange(ctx, folderID, FolderChange{Type: ChangeEdited, Name: folder.Name, Fields: []string{"slug"}})
+	return slug, nil
+}
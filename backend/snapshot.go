@@ -0,0 +1,238 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	gcs "cloud.google.com/go/storage"
+)
+
+// snapshotPrefix is where generated folder snapshots are kept in the default bucket, by folder ID.
+const snapshotPrefix = "snapshots/"
+
+// snapshotFormat is part of every snapshot revision, so changing what a snapshot contains
+// regenerates the stored ones.
+const snapshotFormat = "1"
+
+// FolderSnapshot is the complete public content of a folder as one document, for static
+// frontends that would otherwise page through the live API. URLs in it are backend paths that
+// stay valid as long as the file exists, so a snapshot can be cached for as long as its Revision
+// is current.
+type FolderSnapshot struct {
+	Revision    string         `json:"revision"` // Changes with every change of the folder (see FolderRevision)
+	GeneratedAt time.Time      `json:"generatedAt"`
+	Folder      SnapshotFolder `json:"folder"`
+	Files       []SnapshotFile `json:"files"` // Newest first, like the default listing
+}
+
+// SnapshotFolder is a folder as described by its snapshot.
+type SnapshotFolder struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Names     map[string]string `json:"names,omitempty"`
+	Slug      string            `json:"slug,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Archived  bool              `json:"archived,omitempty"`
+}
+
+// SnapshotFile is a file as listed in a folder snapshot: its public metadata and stable URLs,
+// without Storage internals.
+type SnapshotFile struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	MimeType     string            `json:"mimeType"`
+	MediaType    string            `json:"mediaType"`
+	Size         int64             `json:"size,omitempty"`
+	Width        int               `json:"width,omitempty"`
+	Height       int               `json:"height,omitempty"`
+	Duration     float64           `json:"duration,omitempty"`
+	Color        string            `json:"color,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	CapturedAt   time.Time         `json:"capturedAt"`
+	MediaURL     string            `json:"mediaUrl"`
+	DownloadURL  string            `json:"downloadUrl,omitempty"` // Only with DOWNLOAD_URL_MODE=public; signed URLs expire
+	ThumbnailURL string            `json:"thumbnailUrl,omitempty"`
+	Srcset       map[string]string `json:"srcset,omitempty"` // By width, with "original" the media URL
+}
+
+func snapshotPath(folderID string) string {
+	return snapshotPrefix + folderID + ".json"
+}
+
+// FolderRevision identifies the current state of a folder: the cursor of the latest entry of its
+// change log, which every change of the folder and its files appends to, or its creation time if
+// it has none, and the profiles hidden from public galleries, which snapshots leave out.
+func FolderRevision(ctx context.Context, folder *FolderMetadata) (string, error) {
+	hidden, err := HiddenProfileIDs(ctx)
+	if err != nil {
+		return "", err
+	}
+	suffix := ""
+	if fingerprint := hiddenPeopleFingerprint(hidden); fingerprint != "" {
+		suffix = "-" + fingerprint
+	}
+	docs, err := Client.Collection(FoldersCollection).Doc(folder.ID).Collection(ChangesCollection).
+		OrderBy("at", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return "", fmt.Errorf("failed to get latest change of folder %s: %v", folder.ID, err)
+	}
+	for _, doc := range docs {
+		var change FolderChange
+		if err := doc.DataTo(&change); err != nil {
+			return "", fmt.Errorf("failed to unmarshal change %s of folder %s: %v", doc.Ref.ID, folder.ID, err)
+		}
+		change.ID = doc.Ref.ID
+		return snapshotFormat + "-" + changeCursor(change) + suffix, nil
+	}
+	return snapshotFormat + "-" + strconv.FormatInt(folder.CreatedAt.UnixNano(), 10) + suffix, nil
+}
+
+// BuildFolderSnapshot lists a public folder into a FolderSnapshot of the given revision. Files
+// awaiting approval are left out, and so are files showing people hidden from public galleries.
+func BuildFolderSnapshot(ctx context.Context, folder *FolderMetadata, revision string) (*FolderSnapshot, error) {
+	files, err := ListAllFilesInFolder(ctx, folder.ID)
+	if err != nil {
+		return nil, err
+	}
+	hidden, err := HiddenProfileIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files = withoutHiddenPeople(files, hidden)
+	snapshot := &FolderSnapshot{
+		Revision:    revision,
+		GeneratedAt: now(),
+		Folder:      SnapshotFolder{ID: folder.ID, Name: folder.Name, Names: folder.Names, Slug: folder.Slug, CreatedAt: folder.CreatedAt, Archived: folder.Archived},
+		Files:       []SnapshotFile{},
+	}
+	for _, f := range files {
+		if f.PendingApproval {
+			continue
+		}
+		file := SnapshotFile{
+			ID:         f.ID,
+			Name:       f.Name,
+			MimeType:   f.MimeType,
+			MediaType:  mediaTypeOf(f.MimeType),
+			Size:       f.Size,
+			Width:      f.Width,
+			Height:     f.Height,
+			Duration:   f.Duration,
+			Color:      f.Color,
+			Tags:       f.Tags,
+			CreatedAt:  f.CreatedAt,
+			CapturedAt: f.CapturedAt,
+			MediaURL:   MediaURL(f.ID, false),
+		}
+		if downloadURLMode() == "public" {
+			file.DownloadURL = f.DownloadURL
+		}
+		if file.MediaType == "image" {
+			file.ThumbnailURL = ThumbnailURL(f.ID, false)
+			file.Srcset = srcset(f, false)
+			file.Srcset["original"] = file.MediaURL
+		}
+		snapshot.Files = append(snapshot.Files, file)
+	}
+	sortFilesNewestFirst(snapshot.Files)
+	return snapshot, nil
+}
+
+// sortFilesNewestFirst orders snapshot files by upload time, newest first, then by ID.
+func sortFilesNewestFirst(files []SnapshotFile) {
+	slices.SortFunc(files, func(a, b SnapshotFile) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+}
+
+// GetFolderSnapshot returns the snapshot of a public folder as JSON, with its revision. It is
+// generated when the folder has changed since it was last requested, and otherwise read from
+// Storage. Missing, private, embargoed and hidden folders are ErrNotFound, since snapshots are public.
+func GetFolderSnapshot(ctx context.Context, folderID string) ([]byte, string, error) {
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, "", err
+	}
+	if folder == nil || folder.IsPrivate() || !folder.isPubliclyVisible() {
+		return nil, "", notFound("folder %s", folderID)
+	}
+	revision, err := FolderRevision(ctx, folder)
+	if err != nil {
+		return nil, "", err
+	}
+	bucket, err := bucketHandle("")
+	if err != nil {
+		return nil, "", err
+	}
+	obj := bucket.Object(snapshotPath(folderID))
+	if data, err := readStoredSnapshot(ctx, obj, revision); err != nil {
+		log.Printf("Warning: Could not read stored snapshot of folder %s: %v", folderID, err)
+	} else if data != nil {
+		return data, revision, nil
+	}
+
+	snapshot, err := BuildFolderSnapshot(ctx, folder, revision)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode snapshot of folder %s: %v", folderID, err)
+	}
+	// Concurrent requests may both generate it; they write the same revision
+	wc := obj.NewWriter(ctx)
+	wc.ContentType = "application/json"
+	wc.Metadata = map[string]string{"revision": revision}
+	if _, err := wc.Write(data); err != nil {
+		wc.Close()
+		log.Printf("Warning: Could not store snapshot of folder %s: %v", folderID, err)
+	} else if err := wc.Close(); err != nil {
+		log.Printf("Warning: Could not store snapshot of folder %s: %v", folderID, err)
+	}
+	log.Printf("Generated snapshot of folder %s with %d files (revision %s).", folderID, len(snapshot.Files), revision)
+	return data, revision, nil
+}
+
+// readStoredSnapshot returns the stored snapshot if it is of revision, or nil if there is none
+// or it is outdated.
+func readStoredSnapshot(ctx context.Context, obj *gcs.ObjectHandle, revision string) ([]byte, error) {
+	attrs, err := obj.Attrs(ctx)
+	if err == gcs.ErrObjectNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if attrs.Metadata["revision"] != revision {
+		return nil, nil
+	}
+	reader, err := obj.Generation(attrs.Generation).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// deleteFolderSnapshot removes the stored snapshot of a deleted folder.
+func deleteFolderSnapshot(ctx context.Context, folderID string) {
+	bucket, err := bucketHandle("")
+	if err != nil {
+		return
+	}
+	if err := bucket.Object(snapshotPath(folderID)).Delete(ctx); err != nil && err != gcs.ErrObjectNotExist {
+		log.Printf("Warning: Could not delete snapshot of folder %s: %v", folderID, err)
+	}
+}
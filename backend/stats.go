@@ -0,0 +1,196 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/api/iterator"
+)
+
+// statsDays is the number of days covered by GalleryStats.UploadsPerDay.
+const statsDays = 30
+
+// DailyCount is the number of files uploaded on one day (UTC).
+type DailyCount struct {
+	Date  string `json:"date"` // YYYY-MM-DD
+	Count int    `json:"count"`
+}
+
+// GalleryStats is an overview of the gallery for the admin dashboard.
+type GalleryStats struct {
+	Folders          int64               `json:"folders"`
+	Files            int64               `json:"files"`
+	FilesByMediaType map[string]int64    `json:"filesByMediaType"` // image, video, audio and other
+	TotalBytes       int64               `json:"totalBytes"`       // Files without a size (uploaded before it was stored, see "backfill size") count as 0
+	UploadsPerDay    []DailyCount        `json:"uploadsPerDay"`    // Last 30 days, oldest first
+	ActiveClients    int                 `json:"activeWebSocketClients"`
+	RecentErrors     []LoggedError       `json:"recentErrors"`    // Newest first
+	FirestoreWrites  WriteLimiterStats   `json:"firestoreWrites"` // Pacing of bulk writes (see waitForWrite)
+	SignedURLs       SignedURLCacheStats `json:"signedUrls"`      // Signed URL cache of private folders
+	DriveAPI         DriveQuotaStats     `json:"driveApi"`        // Throttling of Drive API calls (see callDrive)
+	AccessLog        AccessLogStats      `json:"accessLog"`       // Media access logging (see InitAccessLog)
+	GeneratedAt      time.Time           `json:"generatedAt"`
+}
+
+// GetGalleryStats aggregates folder and file counts, storage usage, recent upload activity,
+// connected WebSocket clients and recent errors. Counts use Firestore aggregation queries,
+// so no file documents are read except the last 30 days' upload timestamps.
+func GetGalleryStats(ctx context.Context) (*GalleryStats, error) {
+	stats := &GalleryStats{
+		FilesByMediaType: make(map[string]int64),
+		ActiveClients:    ActiveClients(),
+		RecentErrors:     RecentErrors.List(),
+		FirestoreWrites:  FirestoreWriteStats(),
+		SignedURLs:       SignedURLStats(),
+		DriveAPI:         DriveStats(),
+		AccessLog:        AccessStats(),
+		GeneratedAt:      now(),
+	}
+
+	var err error
+	if stats.Folders, err = countDocuments(ctx, Client.Collection(FoldersCollection).Query); err != nil {
+		return nil, err
+	}
+
+	total, byMediaType, err := mediaBreakdown(ctx, Client.Collection(FilesCollection).Query)
+	if err != nil {
+		return nil, err
+	}
+	stats.Files, stats.TotalBytes = total.Count, total.Bytes
+	for mediaType, m := range byMediaType {
+		stats.FilesByMediaType[mediaType] = m.Count
+	}
+
+	if stats.UploadsPerDay, err = uploadsPerDay(ctx, now().UTC(), statsDays); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// MediaStats is the number and total size of a group of files.
+type MediaStats struct {
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"` // Files without a size count as 0
+}
+
+// FolderStats breaks down the files of a logical folder by media type.
+type FolderStats struct {
+	FolderID    string                `json:"folderId"`
+	Files       int64                 `json:"files"`
+	Bytes       int64                 `json:"bytes"`
+	ByMediaType map[string]MediaStats `json:"byMediaType"` // image, video, audio and other
+}
+
+// GetFolderStats returns file counts and byte totals of a folder by media type. A missing
+// folder is ErrNotFound. It needs a composite index on (folderId, mimeType) in the files collection.
+func GetFolderStats(ctx context.Context, folderID string) (*FolderStats, error) {
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil {
+		return nil, notFound("folder %s", folderID)
+	}
+	total, byMediaType, err := mediaBreakdown(ctx, Client.Collection(FilesCollection).Where("folderId", "==", folderID))
+	if err != nil {
+		return nil, err
+	}
+	return &FolderStats{FolderID: folderID, Files: total.Count, Bytes: total.Bytes, ByMediaType: byMediaType}, nil
+}
+
+// mediaBreakdown aggregates the files matched by base in total and per media type. Range queries
+// on mimeType are used, so files stored before the denormalized mediaType field existed are included.
+func mediaBreakdown(ctx context.Context, base firestore.Query) (MediaStats, map[string]MediaStats, error) {
+	total, err := sumFiles(ctx, base)
+	if err != nil {
+		return MediaStats{}, nil, err
+	}
+	byMediaType := make(map[string]MediaStats)
+	other := total
+	for _, mediaType := range []string{"image", "video", "audio"} {
+		query := base.Where("mimeType", ">=", mediaType+"/").Where("mimeType", "<", mediaType+"0") // '0' sorts right after '/'
+		m, err := sumFiles(ctx, query)
+		if err != nil {
+			return MediaStats{}, nil, err
+		}
+		byMediaType[mediaType] = m
+		other.Count -= m.Count
+		other.Bytes -= m.Bytes
+	}
+	byMediaType["other"] = other
+	return total, byMediaType, nil
+}
+
+// sumFiles counts the files matched by query and sums their sizes.
+func sumFiles(ctx context.Context, query firestore.Query) (MediaStats, error) {
+	result, err := aggregate(ctx, query.NewAggregationQuery().WithCount("count").WithSum("size", "bytes"))
+	if err != nil {
+		return MediaStats{}, err
+	}
+	return MediaStats{Count: result["count"], Bytes: result["bytes"]}, nil
+}
+
+// uploadsPerDay counts the files created on each of the last days days up to today.
+func uploadsPerDay(ctx context.Context, today time.Time, days int) ([]DailyCount, error) {
+	start := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -(days - 1))
+	counts := make([]DailyCount, days)
+	index := make(map[string]int, days)
+	for i := range counts {
+		counts[i].Date = start.AddDate(0, 0, i).Format("2006-01-02")
+		index[counts[i].Date] = i
+	}
+
+	iter := Client.Collection(FilesCollection).Where("createdAt", ">=", start).Select("createdAt").Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate recent uploads: %v", err)
+		}
+		createdAt, ok := doc.Data()["createdAt"].(time.Time)
+		if !ok {
+			continue
+		}
+		if i, ok := index[createdAt.UTC().Format("2006-01-02")]; ok {
+			counts[i].Count++
+		}
+	}
+	return counts, nil
+}
+
+// countDocuments returns the number of documents matching query.
+func countDocuments(ctx context.Context, query firestore.Query) (int64, error) {
+	result, err := aggregate(ctx, query.NewAggregationQuery().WithCount("count"))
+	if err != nil {
+		return 0, err
+	}
+	return result["count"], nil
+}
+
+// aggregate runs an aggregation query and returns its numeric results by alias.
+func aggregate(ctx context.Context, query *firestore.AggregationQuery) (map[string]int64, error) {
+	result, err := query.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run aggregation query: %v", err)
+	}
+	values := make(map[string]int64, len(result))
+	for alias, v := range result {
+		value, ok := v.(*firestorepb.Value)
+		if !ok {
+			continue
+		}
+		switch n := value.GetValueType().(type) {
+		case *firestorepb.Value_IntegerValue:
+			values[alias] = n.IntegerValue
+		case *firestorepb.Value_DoubleValue: // Sums that overflow int64 are returned as doubles
+			values[alias] = int64(n.DoubleValue)
+		}
+	}
+	return values, nil
+}
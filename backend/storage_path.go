@@ -0,0 +1,131 @@
+package backend
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Built-in storage path strategies, selected with the STORAGE_PATH_STRATEGY environment variable.
+const (
+	PathByFolder     = "folder"      // {folderID}/{relativePath}, the original layout and the default
+	PathByFolderName = "folder-name" // {folder name}/{relativePath}
+	PathByDate       = "date"        // {YYYY}/{MM}/{DD}/{folderID}/{relativePath}, by CapturedAt
+	PathByHash       = "hash"        // {sha256[:2]}/{sha256}{extension}
+	PathByContent    = "cas"         // blobs/{sha256}; names and folders live only in Firestore
+)
+
+// StoragePathInput is what a StoragePathStrategy may build an object path from. Everything is
+// known before the content is uploaded, so signed upload URLs and their finalization agree.
+type StoragePathInput struct {
+	FolderID     string
+	FolderName   string
+	RelativePath string    // Path inside the folder, including the file name
+	Hash         string    // SHA256 of the content in hex
+	CapturedAt   time.Time // Modification time of the original file; zero if unknown
+}
+
+// StoragePathStrategy decides where in the bucket uploads are stored. The strategy is recorded
+// on every file (FileMetadata.PathStrategy) and the path itself in StoragePath, so files stored
+// under an earlier strategy keep resolving after it is changed.
+type StoragePathStrategy interface {
+	Name() string
+	Path(in StoragePathInput) string
+}
+
+// storagePathFunc adapts a function to a named StoragePathStrategy.
+type storagePathFunc struct {
+	name string
+	fn   func(StoragePathInput) string
+}
+
+func (s storagePathFunc) Name() string                    { return s.name }
+func (s storagePathFunc) Path(in StoragePathInput) string { return s.fn(in) }
+
+var (
+	pathStrategiesMu sync.Mutex
+	pathStrategies   = make(map[string]StoragePathStrategy)
+)
+
+func init() {
+	RegisterStoragePathStrategy(storagePathFunc{PathByFolder, func(in StoragePathInput) string {
+		return objectStoragePath(in.FolderID, in.RelativePath)
+	}})
+	RegisterStoragePathStrategy(storagePathFunc{PathByFolderName, func(in StoragePathInput) string {
+		return folderNamePath(in.FolderName, in.RelativePath)
+	}})
+	RegisterStoragePathStrategy(storagePathFunc{PathByDate, func(in StoragePathInput) string {
+		day := "undated" // Without a date the path would depend on when it is computed
+		if !in.CapturedAt.IsZero() {
+			day = in.CapturedAt.UTC().Format("2006/01/02")
+		}
+		return objectStoragePath(day+"/"+in.FolderID, in.RelativePath)
+	}})
+	RegisterStoragePathStrategy(storagePathFunc{PathByHash, func(in StoragePathInput) string {
+		hash := strings.ToLower(in.Hash)
+		if len(hash) < 2 {
+			return objectStoragePath(in.FolderID, in.RelativePath)
+		}
+		return fmt.Sprintf("%s/%s%s", hash[:2], hash, strings.ToLower(path.Ext(in.RelativePath)))
+	}})
+	RegisterStoragePathStrategy(storagePathFunc{PathByContent, func(in StoragePathInput) string {
+		hash := strings.ToLower(in.Hash)
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 64 {
+			return objectStoragePath(in.FolderID, in.RelativePath)
+		}
+		return "blobs/" + hash
+	}})
+}
+
+// folderNamePath is the PathByFolderName path of a file, which RenameFolder moves.
+func folderNamePath(folderName, relativePath string) string {
+	return objectStoragePath(strings.ReplaceAll(folderName, "/", "_"), relativePath)
+}
+
+// RegisterStoragePathStrategy adds a strategy that STORAGE_PATH_STRATEGY can select by name,
+// replacing a registered strategy of the same name.
+func RegisterStoragePathStrategy(strategy StoragePathStrategy) {
+	pathStrategiesMu.Lock()
+	defer pathStrategiesMu.Unlock()
+	pathStrategies[strategy.Name()] = strategy
+}
+
+// StoragePathStrategies returns the names of the registered strategies, sorted.
+func StoragePathStrategies() []string {
+	pathStrategiesMu.Lock()
+	defer pathStrategiesMu.Unlock()
+	names := make([]string, 0, len(pathStrategies))
+	for name := range pathStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// currentStoragePathStrategy returns the strategy named by STORAGE_PATH_STRATEGY, or PathByFolder
+// if it is unset or unknown.
+func currentStoragePathStrategy() StoragePathStrategy {
+	name := os.Getenv("STORAGE_PATH_STRATEGY")
+	pathStrategiesMu.Lock()
+	defer pathStrategiesMu.Unlock()
+	if strategy, ok := pathStrategies[name]; ok {
+		return strategy
+	}
+	if name != "" {
+		log.Printf("Warning: unknown STORAGE_PATH_STRATEGY '%s', using '%s'", name, PathByFolder)
+	}
+	return pathStrategies[PathByFolder]
+}
+
+// storagePathFor returns the object path of a new file under the current strategy, and the
+// strategy's name to record with it.
+func storagePathFor(in StoragePathInput) (string, string) {
+	strategy := currentStoragePathStrategy()
+	return strategy.Path(in), strategy.Name()
+}
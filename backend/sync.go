@@ -0,0 +1,250 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Limits of one Sync page, counted in change log entries.
+const (
+	DefaultSyncPageSize = 500
+	MaxSyncPageSize     = 2000
+)
+
+// SyncDelta is what changed in the gallery after a cursor: the change log entries, oldest first,
+// and the current state of everything they touched, so a client can update its offline cache
+// without listing again. Entities touched but since deleted are listed in Removed.
+type SyncDelta struct {
+	Changes  []FolderChange   `json:"changes"`
+	Folders  []FolderMetadata `json:"folders"`
+	Files    []FileMetadata   `json:"files"` // With access URLs, as listed by /api/files
+	Profiles []Profile        `json:"profiles"`
+	Removed  SyncRemoved      `json:"removed"`
+	Cursor   string           `json:"cursor"`  // Of the last change, or the since given if there is none
+	HasMore  bool             `json:"hasMore"` // Call again with Cursor for the rest
+}
+
+// SyncRemoved lists the IDs of deleted entities in a SyncDelta, and of those the caller may no
+// longer see, so they are dropped from its cache too. The files of a removed folder are not listed
+// one by one; they went with it.
+type SyncRemoved struct {
+	Folders  []string `json:"folders"`
+	Files    []string `json:"files"`
+	Profiles []string `json:"profiles"`
+}
+
+// syncCursor encodes the position of a change log entry across all logs: its commit time and
+// document path.
+func syncCursor(doc *firestore.DocumentSnapshot, change FolderChange) string {
+	path := doc.Ref.Path
+	if i := strings.Index(path, "/documents/"); i >= 0 {
+		path = path[i+len("/documents/"):]
+	}
+	return strconv.FormatInt(change.At.UnixNano(), 10) + "." + path
+}
+
+// Sync returns up to limit change log entries of all folders and profiles after the cursor
+// since, with the current state of the entities they changed. Without since it returns no changes,
+// only the cursor of the latest one: clients take it before listing everything, then follow it.
+// Files pending approval, and for a public viewer (see WithPublicViewer) files showing a hidden
+// profile and folders it may not see (see IsFolderVisible) with their files, are left out with
+// their changes, like in ListFilesFromFirestore. The query needs a collection group index on
+// changes.at.
+func Sync(ctx context.Context, since string, limit int) (*SyncDelta, error) {
+	if limit <= 0 {
+		limit = DefaultSyncPageSize
+	}
+	limit = min(limit, MaxSyncPageSize)
+	delta := &SyncDelta{
+		Changes:  []FolderChange{},
+		Folders:  []FolderMetadata{},
+		Files:    []FileMetadata{},
+		Profiles: []Profile{},
+		Removed:  SyncRemoved{Folders: []string{}, Files: []string{}, Profiles: []string{}},
+		Cursor:   since,
+	}
+
+	changes := Client.CollectionGroup(ChangesCollection)
+	var query firestore.Query
+	if since == "" {
+		query = changes.OrderBy("at", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc).Limit(1)
+	} else {
+		nanos, path, ok := strings.Cut(since, ".")
+		n, err := strconv.ParseInt(nanos, 10, 64)
+		var ref *firestore.DocumentRef
+		if ok && err == nil {
+			ref = Client.Doc(path) // nil unless path names a document
+		}
+		if ref == nil {
+			return nil, &InvalidCursorError{Cursor: since}
+		}
+		query = changes.OrderBy("at", firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc).StartAfter(time.Unix(0, n).UTC(), ref).Limit(limit + 1)
+	}
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read change log: %v", err)
+	}
+	if since == "" {
+		if len(docs) > 0 {
+			var change FolderChange
+			if err := docs[0].DataTo(&change); err == nil {
+				delta.Cursor = syncCursor(docs[0], change)
+			}
+		}
+		return delta, nil
+	}
+	if len(docs) > limit {
+		docs, delta.HasMore = docs[:limit], true
+	}
+
+	// The entities touched, in the order first seen
+	var folderIDs, fileIDs, profileIDs []string
+	seen := make(map[string]bool)
+	note := func(ids *[]string, kind, id string) {
+		if id != "" && !seen[kind+"/"+id] {
+			seen[kind+"/"+id] = true
+			*ids = append(*ids, id)
+		}
+	}
+	allProfiles := false
+	for _, doc := range docs {
+		var change FolderChange
+		if err := doc.DataTo(&change); err != nil {
+			log.Printf("Error unmarshaling change %s: %v", doc.Ref.Path, err)
+			continue
+		}
+		change.ID = doc.Ref.ID
+		if change.FolderID == "" && doc.Ref.Parent.Parent != nil {
+			change.FolderID = doc.Ref.Parent.Parent.ID
+		}
+		change.Cursor = syncCursor(doc, change)
+		delta.Changes = append(delta.Changes, change)
+		delta.Cursor = change.Cursor
+
+		switch {
+		case change.FileID != "":
+			note(&fileIDs, "file", change.FileID)
+		case change.ProfileID != "":
+			note(&profileIDs, "profile", change.ProfileID)
+		case change.FolderID != "":
+			note(&folderIDs, "folder", change.FolderID)
+		default:
+			allProfiles = true // A reorder
+		}
+	}
+
+	// Folders by ID, read once for their own changes and those of their files
+	folders := make(map[string]*FolderMetadata)
+	getFolder := func(id string) (*FolderMetadata, error) {
+		if folder, ok := folders[id]; ok {
+			return folder, nil
+		}
+		folder, err := GetFolder(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		folders[id] = folder
+		return folder, nil
+	}
+	if isPublicViewer(ctx) {
+		invisible := make(map[string]bool)
+		for _, change := range delta.Changes {
+			if change.FolderID == "" || change.ProfileID != "" || invisible[change.FolderID] {
+				continue
+			}
+			folder, err := getFolder(change.FolderID)
+			if err != nil {
+				return nil, err
+			}
+			if folder != nil && !IsFolderVisible(ctx, folder) {
+				invisible[folder.ID] = true
+			}
+		}
+		if len(invisible) > 0 {
+			delta.Changes = slices.DeleteFunc(delta.Changes, func(c FolderChange) bool { return c.ProfileID == "" && invisible[c.FolderID] })
+			kept := make(map[string]bool, len(delta.Changes))
+			for _, change := range delta.Changes {
+				kept[change.FileID] = true
+			}
+			fileIDs = slices.DeleteFunc(fileIDs, func(id string) bool { return !kept[id] })
+		}
+	}
+
+	for _, id := range folderIDs {
+		folder, err := getFolder(id)
+		if err != nil {
+			return nil, err
+		}
+		if folder == nil || !IsFolderVisible(ctx, folder) {
+			delta.Removed.Folders = append(delta.Removed.Folders, id)
+		} else {
+			delta.Folders = append(delta.Folders, *folder)
+		}
+	}
+
+	if len(fileIDs) > 0 {
+		refs := make([]*firestore.DocumentRef, len(fileIDs))
+		for i, id := range fileIDs {
+			refs[i] = Client.Collection(FilesCollection).Doc(id)
+		}
+		fileDocs, err := Client.GetAll(ctx, refs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get changed files: %v", err)
+		}
+		hidden, err := hiddenPeopleFor(ctx)
+		if err != nil {
+			return nil, err
+		}
+		withheld := make(map[string]bool)
+		for i, doc := range fileDocs {
+			if !doc.Exists() {
+				delta.Removed.Files = append(delta.Removed.Files, fileIDs[i])
+				continue
+			}
+			var file FileMetadata
+			if err := doc.DataTo(&file); err != nil {
+				log.Printf("Error unmarshaling file %s: %v", fileIDs[i], err)
+				continue
+			}
+			if file.PendingApproval || showsHiddenPerson(file, hidden) {
+				withheld[fileIDs[i]] = true
+				delta.Removed.Files = append(delta.Removed.Files, fileIDs[i])
+				continue
+			}
+			delta.Files = append(delta.Files, file)
+		}
+		if len(withheld) > 0 {
+			delta.Changes = slices.DeleteFunc(delta.Changes, func(c FolderChange) bool { return withheld[c.FileID] })
+		}
+		if err := AttachAccessURLsByFolder(ctx, delta.Files); err != nil {
+			return nil, err
+		}
+	}
+
+	if allProfiles || len(profileIDs) > 0 {
+		profiles, err := GetProfiles(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		current := make(map[string]bool, len(profiles))
+		for _, p := range profiles {
+			current[p.ID] = true
+			if allProfiles || seen["profile/"+p.ID] {
+				delta.Profiles = append(delta.Profiles, p)
+			}
+		}
+		for _, id := range profileIDs {
+			if !current[id] {
+				delta.Removed.Profiles = append(delta.Removed.Profiles, id)
+			}
+		}
+	}
+	return delta, nil
+}
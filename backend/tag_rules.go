@@ -0,0 +1,219 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SettingsCollection holds gallery-wide configuration, one document per feature.
+const SettingsCollection = "settings"
+
+// tagRulesDoc is the document of SettingsCollection holding the tag rules.
+const tagRulesDoc = "tagRules"
+
+// MaxTagRules bounds the number of tag rules of a gallery.
+const MaxTagRules = 100
+
+// TagRule labels or files away uploads by what they are. Its conditions are case-insensitive
+// glob patterns (see path.Match) that must all match; empty ones are ignored, but a rule needs
+// at least one. E.g. {NamePattern: "*_edited*", Tags: ["edited"]}.
+type TagRule struct {
+	Name        string   `json:"name" firestore:"name" validate:"required,max=200"`
+	NamePattern string   `json:"namePattern,omitempty" firestore:"namePattern,omitempty" validate:"max=200"` // Matched against the file name without directories
+	MimeType    string   `json:"mimeType,omitempty" firestore:"mimeType,omitempty" validate:"max=200"`       // E.g. "video/*"
+	CameraModel string   `json:"cameraModel,omitempty" firestore:"cameraModel,omitempty" validate:"max=200"` // EXIF camera model of JPEG images, e.g. "*iPhone*"
+	Tags        []string `json:"tags,omitempty" firestore:"tags,omitempty" validate:"max=20"`
+	// MoveTo is the name of the logical folder the upload is stored in instead, created if
+	// needed. The first matching rule with one wins. Direct uploads are stored before rules
+	// apply and only get the tags.
+	MoveTo string `json:"moveTo,omitempty" firestore:"moveTo,omitempty" validate:"max=200"`
+}
+
+// TagRuleInput is what tag rules match against.
+type TagRuleInput struct {
+	Name        string `json:"name" validate:"required,max=1024"`
+	MimeType    string `json:"mimeType" validate:"max=200"`
+	CameraModel string `json:"cameraModel,omitempty" validate:"max=200"`
+}
+
+// TagRuleResult is what the tag rules do to an upload.
+type TagRuleResult struct {
+	Rules  []string `json:"rules"` // Names of the matching rules, in order
+	Tags   []string `json:"tags,omitempty"`
+	MoveTo string   `json:"moveTo,omitempty"`
+}
+
+// InvalidTagRuleError is returned by SetTagRules for a rule that cannot be saved.
+type InvalidTagRuleError struct {
+	Rule   string
+	Reason string
+}
+
+func (e *InvalidTagRuleError) Error() string {
+	return fmt.Sprintf("invalid tag rule '%s': %s", e.Rule, e.Reason)
+}
+
+func init() {
+	RegisterUploadHook(StageTransform, moveUploadByTagRules)
+	RegisterUploadHook(StageStore, tagUploadByTagRules)
+}
+
+// matches reports whether every condition of the rule matches in. Patterns are checked when the
+// rules are saved, so a malformed one just doesn't match.
+func (rule TagRule) matches(in TagRuleInput) bool {
+	if rule.NamePattern == "" && rule.MimeType == "" && rule.CameraModel == "" {
+		return false
+	}
+	for _, c := range [][2]string{{rule.NamePattern, path.Base(in.Name)}, {rule.MimeType, in.MimeType}, {rule.CameraModel, in.CameraModel}} {
+		if c[0] == "" {
+			continue
+		}
+		if ok, _ := path.Match(strings.ToLower(c[0]), strings.ToLower(c[1])); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// EvaluateTagRules returns what rules do to a file, without changing anything.
+func EvaluateTagRules(rules []TagRule, in TagRuleInput) TagRuleResult {
+	result := TagRuleResult{Rules: []string{}}
+	for _, rule := range rules {
+		if !rule.matches(in) {
+			continue
+		}
+		result.Rules = append(result.Rules, rule.Name)
+		for _, tag := range rule.Tags {
+			if !containsString(result.Tags, tag) {
+				result.Tags = append(result.Tags, tag)
+			}
+		}
+		if result.MoveTo == "" {
+			result.MoveTo = rule.MoveTo
+		}
+	}
+	return result
+}
+
+// GetTagRules returns the gallery's tag rules in the order they apply.
+func GetTagRules(ctx context.Context) ([]TagRule, error) {
+	doc, err := Client.Collection(SettingsCollection).Doc(tagRulesDoc).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return []TagRule{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tag rules: %v", err)
+	}
+	var settings struct {
+		Rules []TagRule `firestore:"rules"`
+	}
+	if err := doc.DataTo(&settings); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tag rules: %v", err)
+	}
+	if settings.Rules == nil {
+		settings.Rules = []TagRule{}
+	}
+	return settings.Rules, nil
+}
+
+// SetTagRules replaces the gallery's tag rules after checking their patterns. Tags are trimmed
+// and deduplicated. They apply to uploads from then on; stored files are not re-tagged.
+func SetTagRules(ctx context.Context, rules []TagRule) ([]TagRule, error) {
+	if len(rules) > MaxTagRules {
+		return nil, &InvalidTagRuleError{Reason: fmt.Sprintf("too many rules: %d (max %d)", len(rules), MaxTagRules)}
+	}
+	for i := range rules {
+		rule := &rules[i]
+		rule.Name, rule.MoveTo = strings.TrimSpace(rule.Name), strings.TrimSpace(rule.MoveTo)
+		if rule.NamePattern == "" && rule.MimeType == "" && rule.CameraModel == "" {
+			return nil, &InvalidTagRuleError{Rule: rule.Name, Reason: "no condition"}
+		}
+		for _, pattern := range []string{rule.NamePattern, rule.MimeType, rule.CameraModel} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, &InvalidTagRuleError{Rule: rule.Name, Reason: fmt.Sprintf("invalid pattern '%s'", pattern)}
+			}
+		}
+		var tags []string
+		for _, tag := range rule.Tags {
+			if tag = strings.TrimSpace(tag); tag != "" && !containsString(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		rule.Tags = tags
+		if len(rule.Tags) == 0 && rule.MoveTo == "" {
+			return nil, &InvalidTagRuleError{Rule: rule.Name, Reason: "neither tags nor a folder to move to"}
+		}
+	}
+	if rules == nil {
+		rules = []TagRule{}
+	}
+	if _, err := Client.Collection(SettingsCollection).Doc(tagRulesDoc).Set(ctx, map[string]interface{}{"rules": rules, "updatedAt": now()}); err != nil {
+		return nil, fmt.Errorf("failed to save tag rules: %v", err)
+	}
+	log.Printf("Saved %d tag rules.", len(rules))
+	return rules, nil
+}
+
+// evaluateUploadTagRules evaluates the gallery's tag rules for an upload once, remembering the
+// result on it for the later stages.
+func evaluateUploadTagRules(ctx context.Context, u *Upload, cameraModel string) error {
+	if u.TagRules != nil {
+		return nil
+	}
+	rules, err := GetTagRules(ctx)
+	if err != nil {
+		return err
+	}
+	result := EvaluateTagRules(rules, TagRuleInput{Name: u.RelativePath, MimeType: u.MimeType, CameraModel: cameraModel})
+	u.TagRules = &result
+	return nil
+}
+
+// moveUploadByTagRules is the StageTransform hook storing an upload in the folder its tag rules
+// move it to. A folder that cannot be used, e.g. because it is archived, is logged and the upload
+// stays where it was sent. Direct uploads keep their object and only change folders in Firestore.
+func moveUploadByTagRules(ctx context.Context, u *Upload) error {
+	info := ReadEXIF(u.Content)
+	if u.Content == nil { // Direct uploads are already stored
+		info = storedEXIF(ctx, u.Bucket, u.StoragePath, u.MimeType, nil)
+	}
+	model := ""
+	if info != nil {
+		model = info.Model
+	}
+	if err := evaluateUploadTagRules(ctx, u, model); err != nil {
+		return err
+	}
+	moveTo := u.TagRules.MoveTo
+	if moveTo == "" || moveTo == u.FolderName {
+		return nil
+	}
+	folderID, err := resolveFolderID(ctx, moveTo)
+	if err != nil {
+		log.Printf("Warning: Could not move upload %s to folder '%s' by tag rules: %v", u.RelativePath, moveTo, err)
+		return nil
+	}
+	log.Printf("Tag rules move upload %s from folder '%s' to '%s'.", u.RelativePath, u.FolderName, moveTo)
+	u.FolderID, u.FolderName = folderID, moveTo
+	return nil
+}
+
+// tagUploadByTagRules is the StageStore hook adding the tags of an upload's matching rules. It
+// runs after readUploadedEXIF.
+func tagUploadByTagRules(ctx context.Context, u *Upload) error {
+	if err := evaluateUploadTagRules(ctx, u, u.File.CameraModel); err != nil {
+		return err
+	}
+	for _, tag := range u.TagRules.Tags {
+		if !containsString(u.File.Tags, tag) {
+			u.File.Tags = append(u.File.Tags, tag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TombstonesCollection keeps a document per file taken down, by file ID, so its URLs explain the
+// removal instead of answering like a file that never existed.
+const TombstonesCollection = "tombstones"
+
+// Tombstone records a file removed by TakeDownFile.
+type Tombstone struct {
+	FileID      string    `json:"fileId" firestore:"fileId"`
+	FolderID    string    `json:"folderId" firestore:"folderId"`
+	Name        string    `json:"name" firestore:"name"`
+	Reason      string    `json:"reason" firestore:"reason"`
+	Actor       string    `json:"actor,omitempty" firestore:"actor,omitempty"` // UID of the moderator
+	TakenDownAt time.Time `json:"takenDownAt" firestore:"takenDownAt"`
+}
+
+// TakeDownFile removes a file, e.g. after a rights holder's request: its object and metadata are
+// deleted like DeleteFileFromStorageAndFirestore, with reason recorded in the folder's change log,
+// and a Tombstone is left so its thumbnail and media URLs answer with the reason. Files under
+// legal hold cannot be taken down; that is a LegalHoldError.
+func TakeDownFile(ctx context.Context, fileID, reason string) (*Tombstone, error) {
+	file, err := GetFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, notFound("file %s", fileID)
+	}
+	if err := checkFileLegalHold(ctx, fileID, file.FolderID, file.LegalHold); err != nil {
+		return nil, err
+	}
+
+	// The tombstone comes first, so the URLs never answer like a missing file in between
+	tombstone := &Tombstone{FileID: fileID, FolderID: file.FolderID, Name: file.Name, Reason: reason, Actor: actorFrom(ctx), TakenDownAt: now()}
+	ref := Client.Collection(TombstonesCollection).Doc(fileID)
+	if _, err := ref.Set(ctx, tombstone); err != nil {
+		return nil, fmt.Errorf("failed to save tombstone of file %s: %v", fileID, err)
+	}
+	if err := deleteStoredFile(ctx, file.StoragePath, fileID, reason); err != nil {
+		if _, delErr := ref.Delete(ctx); delErr != nil {
+			log.Printf("ERROR: Failed to delete tombstone of file %s that was not taken down: %v", fileID, delErr)
+		}
+		return nil, err
+	}
+	log.Printf("File %s taken down: %s", fileID, reason)
+	return tombstone, nil
+}
+
+// GetTombstone returns the tombstone of a file taken down, or nil if it was not.
+func GetTombstone(ctx context.Context, fileID string) (*Tombstone, error) {
+	doc, err := Client.Collection(TombstonesCollection).Doc(fileID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get tombstone of file %s: %v", fileID, err)
+	}
+	var tombstone Tombstone
+	if err := doc.DataTo(&tombstone); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tombstone: %v", err)
+	}
+	return &tombstone, nil
+}
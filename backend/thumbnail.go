@@ -0,0 +1,109 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Register decoders for image.Decode
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// ThumbnailSize is the longest side, in pixels, of generated thumbnails.
+const ThumbnailSize = 320
+
+// thumbnailQuality is the JPEG quality of generated thumbnails.
+const thumbnailQuality = 80
+
+// UnsupportedImageError is returned by MakeThumbnail for images it cannot decode, such as HEIC or WebP.
+type UnsupportedImageError struct {
+	Err error
+}
+
+func (e *UnsupportedImageError) Error() string {
+	return fmt.Sprintf("failed to decode image: %v", e.Err)
+}
+
+// MakeThumbnail decodes a JPEG, PNG or GIF image and returns it as a JPEG whose longest side is
+// at most maxSize pixels. Each thumbnail pixel averages the source pixels it covers, and
+// transparent areas become white.
+func MakeThumbnail(r io.Reader, maxSize int) ([]byte, error) {
+	dst, err := renderThumbnail(r, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return encodeThumbnail(dst)
+}
+
+// makeFileThumbnail is MakeThumbnail for the content of a stored file: it is turned upright by
+// the file's Orientation, and gets the watermark if the file has Watermark.
+func makeFileThumbnail(r io.Reader, file *FileMetadata, maxSize int) ([]byte, error) {
+	if !file.Watermark && file.Orientation <= 1 {
+		return MakeThumbnail(r, maxSize)
+	}
+	dst, err := renderThumbnail(r, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	// Resizing first is cheaper, and the longest side is the same either way
+	if oriented, ok := OrientImage(dst, file.Orientation).(*image.RGBA); ok {
+		dst = oriented
+	}
+	if file.Watermark {
+		drawWatermark(dst)
+	}
+	return encodeThumbnail(dst)
+}
+
+// renderThumbnail is MakeThumbnail before encoding, so more can be drawn on the image.
+func renderThumbnail(r io.Reader, maxSize int) (*image.RGBA, error) {
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, &UnsupportedImageError{Err: err}
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return nil, fmt.Errorf("image has no pixels")
+	}
+	tw, th := w, h
+	if w > maxSize || h > maxSize {
+		if w >= h {
+			tw, th = maxSize, max(1, h*maxSize/w)
+		} else {
+			tw, th = max(1, w*maxSize/h), maxSize
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+			var rs, gs, bs, n uint64
+			for sy := y0; sy < max(y1, y0+1); sy++ {
+				for sx := x0; sx < max(x1, x0+1); sx++ {
+					// Colors are alpha-premultiplied, so adding the missing alpha blends onto white
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					rs += uint64(cr + 0xffff - ca)
+					gs += uint64(cg + 0xffff - ca)
+					bs += uint64(cb + 0xffff - ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(rs / n), G: uint16(gs / n), B: uint16(bs / n), A: 0xffff})
+		}
+	}
+	return dst, nil
+}
+
+// encodeThumbnail encodes a rendered thumbnail as a JPEG.
+func encodeThumbnail(dst image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+	return buf.Bytes(), nil
+}
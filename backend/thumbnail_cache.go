@@ -0,0 +1,170 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// thumbnailCachePrefix is where rendered thumbnails are kept in the bucket, by file ID and size.
+const thumbnailCachePrefix = "thumbnails/"
+
+// warmConcurrency is the number of images WarmThumbnails renders at once.
+const warmConcurrency = 4
+
+// warmTimeout bounds a queued warming job, so a stuck Storage call doesn't stall the queue.
+const warmTimeout = 30 * time.Minute
+
+func thumbnailCachePath(fileID string, size int) string {
+	return fmt.Sprintf("%s%s/%d.jpg", thumbnailCachePrefix, fileID, size)
+}
+
+// responsiveWidthsFor returns the ResponsiveWidths an image is served at: the plain thumbnail
+// and the larger sizes its longest side exceeds (all of them when its dimensions are unknown).
+func responsiveWidthsFor(file FileMetadata) []int {
+	longest := max(file.Width, file.Height)
+	widths := []int{ThumbnailSize}
+	for _, width := range ResponsiveWidths[1:] {
+		if longest == 0 || width < longest {
+			widths = append(widths, width)
+		}
+	}
+	return widths
+}
+
+// FileThumbnail returns a stored image resized to size, one of ResponsiveWidths, from the
+// thumbnail cache in Storage; on a miss it is rendered with MakeFileThumbnail and cached.
+func FileThumbnail(ctx context.Context, file *FileMetadata, size int) ([]byte, error) {
+	bucket, err := StorageClient.DefaultBucket()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default storage bucket: %v", err)
+	}
+	obj := bucket.Object(thumbnailCachePath(file.ID, size))
+	if thumb, err := readCachedThumbnail(ctx, obj); err == nil {
+		return thumb, nil
+	} else if err != gcs.ErrObjectNotExist {
+		log.Printf("Warning: Could not read cached thumbnail of %s: %v", file.ID, err)
+	}
+	thumb, err := MakeFileThumbnail(ctx, file, size)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCachedThumbnail(ctx, obj, thumb); err != nil {
+		log.Printf("Warning: Could not cache thumbnail of %s: %v", file.ID, err)
+	}
+	return thumb, nil
+}
+
+func readCachedThumbnail(ctx context.Context, obj *gcs.ObjectHandle) ([]byte, error) {
+	reader, err := obj.NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func writeCachedThumbnail(ctx context.Context, obj *gcs.ObjectHandle, thumb []byte) error {
+	wc := obj.NewWriter(ctx)
+	wc.ContentType = "image/jpeg"
+	if _, err := wc.Write(thumb); err != nil {
+		wc.Close()
+		return err
+	}
+	return wc.Close()
+}
+
+// deleteCachedThumbnails removes the cached thumbnails of a file, e.g. when it is deleted.
+func deleteCachedThumbnails(ctx context.Context, bucket *gcs.BucketHandle, fileID string) {
+	for _, size := range ResponsiveWidths {
+		if err := bucket.Object(thumbnailCachePath(fileID, size)).Delete(ctx); err != nil && err != gcs.ErrObjectNotExist {
+			log.Printf("Warning: Could not delete cached thumbnail %s: %v", thumbnailCachePath(fileID, size), err)
+		}
+	}
+}
+
+// WarmThumbnails renders and caches the thumbnails of the images in files that are not cached
+// yet, so the first visitor of a freshly uploaded folder doesn't wait for them. Failures are
+// logged and skipped; it returns the number of thumbnails rendered.
+func WarmThumbnails(ctx context.Context, files []FileMetadata) (int, error) {
+	bucket, err := StorageClient.DefaultBucket()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get default storage bucket: %v", err)
+	}
+	var (
+		mu       sync.Mutex
+		rendered int
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, warmConcurrency)
+	for i := range files {
+		if mediaTypeOf(files[i].MimeType) != "image" {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(file *FileMetadata) {
+			defer func() { <-sem; wg.Done() }()
+			for _, size := range responsiveWidthsFor(*file) {
+				obj := bucket.Object(thumbnailCachePath(file.ID, size))
+				if _, err := obj.Attrs(ctx); err == nil {
+					continue
+				}
+				thumb, err := MakeFileThumbnail(ctx, file, size)
+				if _, unsupported := err.(*UnsupportedImageError); unsupported {
+					return
+				}
+				if err == nil {
+					err = writeCachedThumbnail(ctx, obj, thumb)
+				}
+				if err != nil {
+					log.Printf("Warning: Could not warm thumbnail of %s at %dpx: %v", file.ID, size, err)
+					continue
+				}
+				mu.Lock()
+				rendered++
+				mu.Unlock()
+			}
+		}(&files[i])
+	}
+	wg.Wait()
+	return rendered, ctx.Err()
+}
+
+var (
+	warmQueue     = make(chan []FileMetadata, 16)
+	startWarmOnce sync.Once
+)
+
+// QueueThumbnailWarming warms the thumbnails of files in the background, one job at a time.
+// It returns false if the queue is full.
+func QueueThumbnailWarming(files []FileMetadata) bool {
+	startWarmOnce.Do(func() {
+		go func() {
+			for files := range warmQueue {
+				ctx, cancel := context.WithTimeout(context.Background(), warmTimeout)
+				rendered, err := WarmThumbnails(ctx, files)
+				cancel()
+				if err != nil {
+					log.Printf("Thumbnail warming stopped after %d thumbnails: %v", rendered, err)
+					continue
+				}
+				log.Printf("Warmed %d thumbnails of %d files.", rendered, len(files))
+			}
+		}()
+	})
+	select {
+	case warmQueue <- files:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,278 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+
+	"cloud.google.com/go/firestore"
+	gcs "cloud.google.com/go/storage"
+)
+
+// Operations of TransformFile.
+const (
+	TransformRotate = "rotate" // Degrees clockwise: 90, 180 or 270
+	TransformFlip   = "flip"   // Direction "horizontal" (mirror) or "vertical"
+	TransformCrop   = "crop"   // X, Y, Width and Height in pixels
+)
+
+// MaxTransformOps bounds the number of operations of a single TransformFile.
+const MaxTransformOps = 20
+
+// transformQuality is the JPEG quality of transformed images, high since they replace the original.
+const transformQuality = 92
+
+// TransformOp is one edit of TransformFile. Operations apply in order, each to the result of the
+// previous one, so a crop after a rotation is in the rotated image's pixels.
+type TransformOp struct {
+	Op        string `json:"op" validate:"required,oneof=rotate flip crop"`
+	Degrees   int    `json:"degrees,omitempty"`
+	Direction string `json:"direction,omitempty"`
+	X         int    `json:"x,omitempty"`
+	Y         int    `json:"y,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+}
+
+// InvalidTransformError is returned by TransformFile for an operation it cannot apply.
+type InvalidTransformError struct {
+	Index  int // Of the operation
+	Reason string
+}
+
+func (e *InvalidTransformError) Error() string {
+	return fmt.Sprintf("invalid operation %d: %s", e.Index, e.Reason)
+}
+
+// VersioningDisabledError is returned by TransformFile for files in a bucket without Object
+// Versioning, where overwriting the object would lose the original.
+type VersioningDisabledError struct {
+	Bucket string
+}
+
+func (e *VersioningDisabledError) Error() string {
+	return fmt.Sprintf("object versioning is not enabled on bucket %s, so the original could not be kept", e.Bucket)
+}
+
+// remap returns a w×h image whose pixel (x, y) is the pixel of src at(x, y), both relative to
+// the top-left corner of the image.
+func remap(src image.Image, w, h int, at func(x, y int) (int, int)) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy := at(x, y)
+			dst.Set(x, y, src.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+func rotateImage(src image.Image, degrees int) image.Image {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	switch degrees {
+	case 90:
+		return remap(src, h, w, func(x, y int) (int, int) { return y, h - 1 - x })
+	case 180:
+		return remap(src, w, h, func(x, y int) (int, int) { return w - 1 - x, h - 1 - y })
+	case 270:
+		return remap(src, h, w, func(x, y int) (int, int) { return w - 1 - y, x })
+	}
+	return src
+}
+
+func flipImage(src image.Image, horizontal bool) image.Image {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if horizontal {
+		return remap(src, w, h, func(x, y int) (int, int) { return w - 1 - x, y })
+	}
+	return remap(src, w, h, func(x, y int) (int, int) { return x, h - 1 - y })
+}
+
+// OrientImage turns an image as stored into the way it is meant to be seen according to its
+// EXIF orientation (see EXIFInfo), which browsers honor but image decoding ignores.
+func OrientImage(src image.Image, orientation int) image.Image {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	switch orientation {
+	case 2:
+		return flipImage(src, true)
+	case 3:
+		return rotateImage(src, 180)
+	case 4:
+		return flipImage(src, false)
+	case 5: // Transpose
+		return remap(src, h, w, func(x, y int) (int, int) { return y, x })
+	case 6:
+		return rotateImage(src, 90)
+	case 7: // Transverse
+		return remap(src, h, w, func(x, y int) (int, int) { return w - 1 - y, h - 1 - x })
+	case 8:
+		return rotateImage(src, 270)
+	}
+	return src
+}
+
+// TransformImage applies ops to an image.
+func TransformImage(img image.Image, ops []TransformOp) (image.Image, error) {
+	for i, op := range ops {
+		switch op.Op {
+		case TransformRotate:
+			if op.Degrees != 90 && op.Degrees != 180 && op.Degrees != 270 {
+				return nil, &InvalidTransformError{Index: i, Reason: "degrees must be 90, 180 or 270"}
+			}
+			img = rotateImage(img, op.Degrees)
+		case TransformFlip:
+			if op.Direction != "horizontal" && op.Direction != "vertical" {
+				return nil, &InvalidTransformError{Index: i, Reason: "direction must be horizontal or vertical"}
+			}
+			img = flipImage(img, op.Direction == "horizontal")
+		case TransformCrop:
+			w, h := img.Bounds().Dx(), img.Bounds().Dy()
+			if op.X < 0 || op.Y < 0 || op.Width <= 0 || op.Height <= 0 || op.X+op.Width > w || op.Y+op.Height > h {
+				return nil, &InvalidTransformError{Index: i, Reason: fmt.Sprintf("crop must lie within the %dx%d image", w, h)}
+			}
+			img = remap(img, op.Width, op.Height, func(x, y int) (int, int) { return op.X + x, op.Y + y })
+		default:
+			return nil, &InvalidTransformError{Index: i, Reason: fmt.Sprintf("unknown operation '%s'", op.Op)}
+		}
+	}
+	return img, nil
+}
+
+// encodeImage encodes an image in the format of mimeType, JPEG or PNG.
+func encodeImage(img image.Image, mimeType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if mimeType == "image/png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: transformQuality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// TransformFile edits a stored JPEG or PNG image in place: it is turned upright according to its
+// EXIF orientation, ops are applied, and the result replaces the object. The bucket must have
+// Object Versioning enabled, which keeps the replaced content as a noncurrent generation; the
+// generation first uploaded is recorded as OriginalGeneration. Re-encoding drops the EXIF
+// header, so the result is stored upright. Hash, size, dimensions, color and perceptual hash
+// are updated and the thumbnails are rendered again.
+func TransformFile(ctx context.Context, fileID string, ops []TransformOp) (*FileMetadata, error) {
+	file, err := GetFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, notFound("file %s", fileID)
+	}
+	if file.MimeType != "image/jpeg" && file.MimeType != "image/png" {
+		return nil, &UnsupportedImageError{Err: fmt.Errorf("only JPEG and PNG images can be transformed, not %s", file.MimeType)}
+	}
+	bucket, err := fileBucket(file)
+	if err != nil {
+		return nil, err
+	}
+	bucketAttrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bucket attributes: %v", err)
+	}
+	if !bucketAttrs.VersioningEnabled {
+		return nil, &VersioningDisabledError{Bucket: bucketAttrs.Name}
+	}
+
+	obj := bucket.Object(file.StoragePath)
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage object attributes: %v", err)
+	}
+	reader, err := obj.Generation(attrs.Generation).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", file.StoragePath, err)
+	}
+	content, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", file.StoragePath, err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, &UnsupportedImageError{Err: err}
+	}
+	if info := ReadEXIF(content); info != nil {
+		img = OrientImage(img, info.Orientation)
+	}
+	if img, err = TransformImage(img, ops); err != nil {
+		return nil, err
+	}
+	edited, err := encodeImage(img, file.MimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only replace the generation that was read, in case the file changed meanwhile
+	wc := obj.If(gcs.Conditions{GenerationMatch: attrs.Generation}).NewWriter(ctx)
+	wc.ContentType = attrs.ContentType
+	wc.CacheControl = attrs.CacheControl
+	wc.Metadata = attrs.Metadata
+	if _, err := wc.Write(edited); err != nil {
+		wc.Close()
+		return nil, fmt.Errorf("failed to write file to storage: %v", err)
+	}
+	if err := wc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close storage writer: %v", err)
+	}
+	newAttrs := wc.Attrs()
+
+	folder, err := GetFolder(ctx, file.FolderID)
+	if err != nil {
+		return nil, err
+	}
+	if downloadURLMode() == "public" && (folder == nil || !folder.IsPrivate()) {
+		if err := obj.ACL().Set(ctx, gcs.AllUsers, gcs.RoleReader); err != nil {
+			log.Printf("Warning: Could not set public ACL for file %s: %v", file.StoragePath, err)
+		}
+	}
+	if file.DownloadURL, err = deriveDownloadURL(bucket, newAttrs); err != nil {
+		return nil, err
+	}
+	if file.Hash, err = CalculateFileHash(edited); err != nil {
+		return nil, fmt.Errorf("failed to calculate file hash: %v", err)
+	}
+	file.Size = newAttrs.Size
+	file.Orientation = 0
+	if file.OriginalGeneration == 0 {
+		file.OriginalGeneration = attrs.Generation
+	}
+	info, err := AnalyzeImage(bytes.NewReader(edited))
+	if err != nil {
+		return nil, err
+	}
+	file.Color, file.PHash, file.Width, file.Height = info.Color, info.PHash, info.Width, info.Height
+
+	if _, err := Client.Collection(FilesCollection).Doc(fileID).Update(ctx, []firestore.Update{
+		{Path: "downloadUrl", Value: file.DownloadURL},
+		{Path: "hash", Value: file.Hash},
+		{Path: "size", Value: file.Size},
+		{Path: "originalGeneration", Value: file.OriginalGeneration},
+		{Path: "orientation", Value: firestore.Delete},
+		{Path: "color", Value: file.Color},
+		{Path: "phash", Value: file.PHash},
+		{Path: "width", Value: file.Width},
+		{Path: "height", Value: file.Height},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to update file metadata for doc ID %s: %v", fileID, err)
+	}
+	deleteFileThumbnails(ctx, fileID)
+	QueueThumbnailWarming([]FileMetadata{*file})
+	log.Printf("Transformed file %s with %d operations (generation %d replaced by %d).", fileID, len(ops), attrs.Generation, newAttrs.Generation)
+	recordFolderChange(ctx, file.FolderID, FolderChange{Type: ChangeEdited, FileID: fileID, Name: file.Name, Fields: []string{"content"}})
+	return file, nil
+}
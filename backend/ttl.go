@@ -0,0 +1,117 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// ExpireAtField is the timestamp field Firestore TTL policies are configured on. Temporary
+// documents (upload sessions, idempotency records, share tokens, event-outbox entries) set it
+// with ExpiresAt, and Firestore deletes them some time after it has passed.
+const ExpireAtField = "expireAt"
+
+// sweepBatchSize is the number of expired documents deleted per query by the sweeper.
+const sweepBatchSize = 500
+
+var (
+	temporaryMu          sync.Mutex
+	temporaryCollections []string
+)
+
+// RegisterTemporaryCollection marks a collection as holding documents with an expireAt field,
+// so the fallback sweeper cleans it up. Call it from an init function next to the collection name.
+func RegisterTemporaryCollection(name string) {
+	temporaryMu.Lock()
+	defer temporaryMu.Unlock()
+	for _, existing := range temporaryCollections {
+		if existing == name {
+			return
+		}
+	}
+	temporaryCollections = append(temporaryCollections, name)
+}
+
+// TemporaryCollections returns the registered temporary collections.
+func TemporaryCollections() []string {
+	temporaryMu.Lock()
+	defer temporaryMu.Unlock()
+	return append([]string(nil), temporaryCollections...)
+}
+
+// ExpiresAt returns the expireAt value for a document that should live for ttl.
+func ExpiresAt(ttl time.Duration) time.Time {
+	return now().Add(ttl)
+}
+
+// SweepExpiredDocuments deletes documents whose expireAt has passed from every temporary
+// collection and returns how many were deleted. It is the fallback for projects without
+// TTL policies; with TTL enabled it simply finds little to do.
+func SweepExpiredDocuments(ctx context.Context) (int, error) {
+	deleted := 0
+	for _, collection := range TemporaryCollections() {
+		for {
+			docs, err := Client.Collection(collection).Where(ExpireAtField, "<=", now()).Limit(sweepBatchSize).Documents(ctx).GetAll()
+			if err != nil {
+				return deleted, fmt.Errorf("failed to query expired documents in %s: %v", collection, err)
+			}
+			if len(docs) == 0 {
+				break
+			}
+
+			writer := Client.BulkWriter(ctx)
+			jobs := make([]*firestore.BulkWriterJob, 0, len(docs))
+			for _, doc := range docs {
+				if err := waitForWrite(ctx, doc.Ref); err != nil {
+					writer.End()
+					return deleted, err
+				}
+				job, err := writer.Delete(doc.Ref)
+				if err != nil {
+					writer.End()
+					return deleted, fmt.Errorf("failed to enqueue delete for %s: %v", doc.Ref.Path, err)
+				}
+				jobs = append(jobs, job)
+			}
+			writer.End()
+			for _, job := range jobs {
+				if _, err := job.Results(); err != nil {
+					return deleted, fmt.Errorf("failed to delete expired document: %v", err)
+				}
+				deleted++
+			}
+			if len(docs) < sweepBatchSize {
+				break
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// StartExpirySweeper runs SweepExpiredDocuments every interval until ctx is cancelled.
+func StartExpirySweeper(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// A sweep must not outlive its interval, or a hung query would stall every later sweep
+				sweepCtx, cancel := context.WithTimeout(ctx, interval)
+				deleted, err := SweepExpiredDocuments(sweepCtx)
+				cancel()
+				if err != nil {
+					log.Printf("ERROR: Expiry sweep failed after deleting %d documents: %v", deleted, err)
+				} else if deleted > 0 {
+					log.Printf("Expiry sweep deleted %d expired documents.", deleted)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,92 @@
+package backend
+
+import (
+	"context"
+	"path"
+)
+
+// Statuses of UploadCheckResult.
+const (
+	UploadStored    = "stored"    // The file is already at the same path of the target folder
+	UploadDuplicate = "duplicate" // The content is stored elsewhere; uploading it returns that file
+	UploadMissing   = "missing"   // The content is not stored, so it has to be uploaded
+)
+
+// UploadCheckFile describes a file a client is about to upload.
+type UploadCheckFile struct {
+	Hash         string `json:"hash" validate:"required,sha256"` // SHA-256 of the content
+	Size         int64  `json:"size"`                            // Bytes, counted in UploadCheck's totals
+	RelativePath string `json:"relativePath"`                    // Path inside the target folder, optional
+}
+
+// UploadCheckResult tells whether an UploadCheckFile is already stored.
+type UploadCheckResult struct {
+	UploadCheckFile
+	Status string        `json:"status"`         // UploadStored, UploadDuplicate or UploadMissing
+	File   *FileMetadata `json:"file,omitempty"` // The stored file, unless the status is UploadMissing
+}
+
+// UploadCheck is the answer to CheckUploads, in the order the files were given.
+type UploadCheck struct {
+	Results      []UploadCheckResult `json:"results"`
+	Stored       int                 `json:"stored"`  // Files with status UploadStored or UploadDuplicate: no bytes have to move
+	Missing      int                 `json:"missing"` // Files still to upload
+	MissingBytes int64               `json:"missingBytes"`
+}
+
+// CheckUploads tells which files are already stored before any of their bytes are sent. As
+// uploads are deduplicated across the gallery by content hash, a file stored in another folder
+// counts as stored too; folderName, which may name a folder that does not exist yet, only tells
+// those apart from files already at the same path of the target folder.
+func CheckUploads(ctx context.Context, folderName string, files []UploadCheckFile) (*UploadCheck, error) {
+	hashes := make([]string, 0, len(files))
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		if !seen[f.Hash] { // The same photo may be picked from two places
+			seen[f.Hash] = true
+			hashes = append(hashes, f.Hash)
+		}
+	}
+	existing, err := FindFilesByHashes(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+	var folder *FolderMetadata
+	if folderName != "" {
+		if folder, err = FindFolderByName(ctx, folderName); err != nil {
+			return nil, err
+		}
+	}
+
+	check := &UploadCheck{Results: make([]UploadCheckResult, 0, len(files))}
+	for _, f := range files {
+		result := UploadCheckResult{UploadCheckFile: f, Status: UploadMissing}
+		if stored, ok := existing[f.Hash]; ok {
+			result.File = &stored
+			result.Status = UploadDuplicate
+			if folder != nil && stored.FolderID == folder.ID && sameUploadPath(&stored, f.RelativePath) {
+				result.Status = UploadStored
+			}
+		}
+		if result.Status == UploadMissing {
+			check.Missing++
+			check.MissingBytes += f.Size
+		} else {
+			check.Stored++
+		}
+		check.Results = append(check.Results, result)
+	}
+	return check, nil
+}
+
+// sameUploadPath reports whether a stored file was uploaded as relativePath. Files stored before
+// relative paths were are matched by name; an empty relativePath matches any path.
+func sameUploadPath(file *FileMetadata, relativePath string) bool {
+	if relativePath == "" {
+		return true
+	}
+	if file.RelativePath != "" {
+		return file.RelativePath == relativePath
+	}
+	return file.Name == path.Base(relativePath)
+}
@@ -0,0 +1,148 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"sync"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// UploadStage is a step of the upload pipeline that hooks can be registered for. Stages run in
+// the order below; the hooks of a stage run in the order they were registered.
+type UploadStage int
+
+const (
+	StageValidate  UploadStage = iota // Before anything is stored; an error rejects the upload
+	StageScan                         // Content inspection such as moderation; an error rejects the upload
+	StageTransform                    // May replace Content and MimeType before they are hashed and stored
+	StageStore                        // The object is stored and Upload.File is filled in before it is saved; an error discards the upload
+	StageIndex                        // The metadata is saved; errors are logged
+	StageNotify                       // Last; errors are logged
+)
+
+var uploadStageNames = [...]string{"validate", "scan", "transform", "store", "index", "notify"}
+
+func (s UploadStage) String() string {
+	if s < 0 || int(s) >= len(uploadStageNames) {
+		return fmt.Sprintf("stage %d", int(s))
+	}
+	return uploadStageNames[s]
+}
+
+// Upload is the state of a file moving through the upload pipeline. Direct uploads (signed
+// URLs) are already stored when they are finalized, so they pass through every stage without
+// Content, with Bucket and StoragePath set from the start.
+type Upload struct {
+	FolderID     string
+	FolderName   string
+	RelativePath string // Path inside the folder, including the file name
+	MimeType     string
+	MimeMismatch string // Disagreement of extension, declared type and content (see checkMimeType)
+	Content      []byte // nil for direct uploads
+	Hash         string // SHA256 of the stored content in hex; set before StageStore
+	Source       SourceInfo
+	Folder       *FolderMetadata   // Set before StageStore; nil for uploads to the root
+	Expected     ExpectedChecksums // Checksums the client sent for Content
+	Bucket       *gcs.BucketHandle // Set before StageStore
+	BucketName   string            // Name of Bucket as recorded in FileMetadata.Bucket; empty for the default bucket
+	StoragePath  string            // Set before StageStore
+	PathStrategy string            // Name of the StoragePathStrategy that built StoragePath
+	File         *FileMetadata     // Set before StageStore and saved after it
+	TagRules     *TagRuleResult    // Set by the gallery's tag rules at StageTransform
+}
+
+// UploadHook is a step added to the upload pipeline with RegisterUploadHook.
+type UploadHook func(ctx context.Context, u *Upload) error
+
+var (
+	uploadHooksMu sync.Mutex
+	uploadHooks   = make(map[UploadStage][]UploadHook)
+)
+
+func init() {
+	RegisterUploadHook(StageValidate, verifyUploadChecksums)
+	RegisterUploadHook(StageTransform, reconcileUploadMimeType)
+	RegisterUploadHook(StageStore, analyzeUploadedImage)
+	RegisterUploadHook(StageStore, readUploadedVideoDuration)
+	RegisterUploadHook(StageNotify, notifyUpload)
+}
+
+// RegisterUploadHook adds hook to the end of stage. Hooks apply to every upload, so register
+// them at startup.
+func RegisterUploadHook(stage UploadStage, hook UploadHook) {
+	uploadHooksMu.Lock()
+	defer uploadHooksMu.Unlock()
+	uploadHooks[stage] = append(uploadHooks[stage], hook)
+}
+
+// runUploadHooks runs the hooks of stage. Up to StageStore the first error stops the upload and
+// is returned; from StageIndex on, errors are logged and the remaining hooks still run.
+func runUploadHooks(ctx context.Context, stage UploadStage, u *Upload) error {
+	uploadHooksMu.Lock()
+	hooks := uploadHooks[stage]
+	uploadHooksMu.Unlock()
+	for _, hook := range hooks {
+		if err := hook(ctx, u); err != nil {
+			if stage < StageIndex {
+				return err
+			}
+			log.Printf("Warning: %s hook failed for %s: %v", stage, u.StoragePath, err)
+		}
+	}
+	return nil
+}
+
+// runUploadChecks runs the hooks of the stages before an upload is stored: StageValidate,
+// StageScan and StageTransform. The first error rejects the upload.
+func runUploadChecks(ctx context.Context, u *Upload) error {
+	for _, stage := range []UploadStage{StageValidate, StageScan, StageTransform} {
+		if err := runUploadHooks(ctx, stage, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyUploadChecksums checks the received content against the checksums the client sent.
+func verifyUploadChecksums(ctx context.Context, u *Upload) error {
+	if u.Expected == (ExpectedChecksums{}) {
+		return nil
+	}
+	hash, err := CalculateFileHash(u.Content)
+	if err != nil {
+		return fmt.Errorf("failed to calculate file hash: %v", err)
+	}
+	return u.Expected.verify(hash, crc32.Checksum(u.Content, crc32cTable))
+}
+
+// analyzeUploadedImage records the dominant color, perceptual hash and dimensions of images.
+func analyzeUploadedImage(ctx context.Context, u *Upload) error {
+	if info := analyzeStoredImage(ctx, u.Bucket, u.StoragePath, u.MimeType, u.Content); info != nil {
+		u.File.Color = info.Color
+		u.File.PHash = info.PHash
+		u.File.Width = info.Width
+		u.File.Height = info.Height
+	}
+	return nil
+}
+
+// readUploadedVideoDuration records the length of MP4 and MOV videos.
+func readUploadedVideoDuration(ctx context.Context, u *Upload) error {
+	u.File.Duration = storedVideoDuration(ctx, u.Bucket, u.StoragePath, u.MimeType, u.Content, u.File.Size)
+	return nil
+}
+
+// notifyUpload sends the file_uploaded event, or adds the file to its folder's digest. Uploads
+// awaiting approval are announced by ApproveUploads instead, uploads to a folder scheduled for
+// publication later by its folder_published event, and uploads to a folder hidden by its expiry
+// not at all.
+func notifyUpload(ctx context.Context, u *Upload) error {
+	if u.File.PendingApproval || (u.Folder != nil && !u.Folder.isPubliclyVisible()) {
+		return nil
+	}
+	notifyFileUploaded(*u.File)
+	return nil
+}
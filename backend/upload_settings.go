@@ -0,0 +1,204 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// UploadSettings are a folder's rules for new uploads, so e.g. a "press photos" folder can be
+// stricter than a "fan snaps" folder. They apply when a file is stored; files already in the
+// folder keep what they were uploaded with.
+type UploadSettings struct {
+	// AllowedMediaTypes restricts uploads to these media types ("image", "video", "other"); empty allows any.
+	AllowedMediaTypes []string `json:"allowedMediaTypes,omitempty" firestore:"allowedMediaTypes,omitempty" validate:"max=3"`
+	// Tags are added to every upload.
+	Tags []string `json:"tags,omitempty" firestore:"tags,omitempty" validate:"max=20"`
+	// RequireApproval keeps uploads out of listings, events and search until ApproveUploads.
+	RequireApproval bool `json:"requireApproval,omitempty" firestore:"requireApproval,omitempty"`
+	// Watermark draws WATERMARK_IMAGE on the thumbnails and resized renditions of uploads.
+	Watermark bool `json:"watermark,omitempty" firestore:"watermark,omitempty"`
+}
+
+// MediaTypes are the values of FileMetadata.MediaType, which AllowedMediaTypes may list.
+var MediaTypes = []string{"image", "video", "other"}
+
+// MediaTypeNotAllowedError is returned for an upload of a media type its folder does not allow.
+type MediaTypeNotAllowedError struct {
+	FolderName string
+	MediaType  string
+	Allowed    []string
+}
+
+func (e *MediaTypeNotAllowedError) Error() string {
+	return fmt.Sprintf("folder '%s' does not accept %s files (allowed: %s)", e.FolderName, e.MediaType, strings.Join(e.Allowed, ", "))
+}
+
+func init() {
+	RegisterUploadHook(StageValidate, checkUploadMediaType)
+	RegisterUploadHook(StageStore, applyUploadSettings)
+}
+
+// allowsMediaType reports whether the folder's settings accept uploads of mediaType. Uploads to
+// the root have no folder and are always accepted.
+func (f *FolderMetadata) allowsMediaType(mediaType string) error {
+	if f == nil || f.UploadSettings == nil || len(f.UploadSettings.AllowedMediaTypes) == 0 {
+		return nil
+	}
+	for _, allowed := range f.UploadSettings.AllowedMediaTypes {
+		if allowed == mediaType {
+			return nil
+		}
+	}
+	return &MediaTypeNotAllowedError{FolderName: f.Name, MediaType: mediaType, Allowed: f.UploadSettings.AllowedMediaTypes}
+}
+
+// checkUploadMediaType is the StageValidate hook rejecting uploads the folder doesn't allow
+// before they are stored, or before direct uploads are finalized.
+func checkUploadMediaType(ctx context.Context, u *Upload) error {
+	if u.FolderID == "" {
+		return nil
+	}
+	folder, err := GetFolder(ctx, u.FolderID)
+	if err != nil {
+		return err
+	}
+	return folder.allowsMediaType(mediaTypeOf(u.MimeType))
+}
+
+// applyUploadSettings is the StageStore hook applying the folder's UploadSettings to the file
+// about to be saved, checking the media type again for uploads tag rules moved to another folder.
+// A rejected file is deleted from Storage by the pipeline.
+func applyUploadSettings(ctx context.Context, u *Upload) error {
+	if u.Folder == nil || u.Folder.UploadSettings == nil {
+		return nil
+	}
+	if err := u.Folder.allowsMediaType(u.File.MediaType); err != nil {
+		return err
+	}
+	settings := u.Folder.UploadSettings
+	for _, tag := range settings.Tags {
+		if !containsString(u.File.Tags, tag) {
+			u.File.Tags = append(u.File.Tags, tag)
+		}
+	}
+	u.File.PendingApproval = settings.RequireApproval
+	u.File.Watermark = settings.Watermark
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// IsZero reports whether s sets no rule.
+func (s UploadSettings) IsZero() bool {
+	return len(s.AllowedMediaTypes) == 0 && len(s.Tags) == 0 && !s.RequireApproval && !s.Watermark
+}
+
+// SetFolderUploadSettings replaces the upload settings of a folder; zero settings remove them.
+// Tags are trimmed and deduplicated.
+func SetFolderUploadSettings(ctx context.Context, folderID string, settings UploadSettings) (*FolderMetadata, error) {
+	for _, mediaType := range settings.AllowedMediaTypes {
+		if !containsString(MediaTypes, mediaType) {
+			return nil, fmt.Errorf("invalid media type '%s' (expected %s)", mediaType, strings.Join(MediaTypes, ", "))
+		}
+	}
+	var tags []string
+	for _, tag := range settings.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !containsString(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	settings.Tags = tags
+
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil {
+		return nil, notFound("folder %s", folderID)
+	}
+	folder.UploadSettings = nil
+	var value interface{} = firestore.Delete
+	if !settings.IsZero() {
+		folder.UploadSettings, value = &settings, settings
+	}
+	if _, err := Client.Collection(FoldersCollection).Doc(folderID).Update(ctx, []firestore.Update{{Path: "uploadSettings", Value: value}}); err != nil {
+		return nil, fmt.Errorf("failed to update folder %s: %v", folderID, err)
+	}
+	log.Printf("Folder %s upload settings: %+v", folderID, folder.UploadSettings)
+	recordFolderChange(ctx, folderID, FolderChange{Type: ChangeEdited, Name: folder.Name, Fields: []string{"uploadSettings"}})
+	BroadcastEvent(EventFolderUpdated, *folder)
+	return folder, nil
+}
+
+// ListPendingUploads returns the files awaiting approval in folders with RequireApproval, oldest
+// first, for moderators.
+func ListPendingUploads(ctx context.Context) ([]FileMetadata, error) {
+	docs, err := Client.Collection(FilesCollection).Where("pendingApproval", "==", true).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending uploads: %v", err)
+	}
+	files := make([]FileMetadata, 0, len(docs))
+	for _, doc := range docs {
+		var file FileMetadata
+		if err := doc.DataTo(&file); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal file metadata from doc %s: %v", doc.Ref.ID, err)
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].CreatedAt.Before(files[j].CreatedAt) })
+	return files, nil
+}
+
+// ApproveUploads publishes pending uploads: they appear in listings, and are recorded, indexed
+// and announced as uploads are when they need no approval. IDs of files that are missing or not
+// pending are returned as skipped. Rejecting an upload is deleting it.
+func ApproveUploads(ctx context.Context, ids []string) ([]FileMetadata, []string, error) {
+	refs := make([]*firestore.DocumentRef, len(ids))
+	for i, id := range ids {
+		refs[i] = Client.Collection(FilesCollection).Doc(id)
+	}
+	docs, err := Client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get file metadata from Firestore: %v", err)
+	}
+	approved := []FileMetadata{}
+	skipped := []string{}
+	for i, doc := range docs {
+		var file FileMetadata
+		if !doc.Exists() || doc.DataTo(&file) != nil || !file.PendingApproval {
+			skipped = append(skipped, ids[i])
+			continue
+		}
+		if _, err := doc.Ref.Update(ctx, []firestore.Update{{Path: "pendingApproval", Value: firestore.Delete}}, firestore.LastUpdateTime(doc.UpdateTime)); err != nil {
+			log.Printf("Error approving upload %s: %v", ids[i], err)
+			skipped = append(skipped, ids[i])
+			continue
+		}
+		file.PendingApproval = false
+		approved = append(approved, file)
+	}
+
+	for _, file := range approved {
+		recordFolderChange(ctx, file.FolderID, FolderChange{Type: ChangeAdded, FileID: file.ID, Name: file.Name})
+		notifyFileUploaded(file)
+	}
+	if len(approved) > 0 {
+		if err := currentSearchIndexer().IndexFiles(ctx, approved); err != nil {
+			log.Printf("Error indexing %d approved uploads: %v", len(approved), err)
+		}
+	}
+	log.Printf("Approved %d uploads (%d skipped).", len(approved), len(skipped))
+	return approved, skipped, nil
+}
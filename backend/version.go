@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata injected at link time, e.g.
+//
+//	go build -ldflags "-X drive-gallery/backend.GitCommit=$(git rev-parse HEAD) -X drive-gallery/backend.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Values left empty are filled from the VCS stamp embedded by the Go toolchain when available.
+var (
+	Version   = "dev"
+	GitCommit = ""
+	BuildTime = ""
+)
+
+// BuildInfo describes the running binary so bug reports can be matched to a deployment.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+	Module    string `json:"module"`
+	Modified  bool   `json:"modified,omitempty"` // Built from a dirty working tree
+}
+
+// GetBuildInfo returns the build metadata, falling back to the toolchain's VCS stamp
+// for values that were not set via -ldflags.
+func GetBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   Version,
+		GitCommit: GitCommit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.Module = bi.Main.Path
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.GitCommit == "" {
+				info.GitCommit = setting.Value
+			}
+		case "vcs.time":
+			if info.BuildTime == "" {
+				info.BuildTime = setting.Value
+			}
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// ShortCommit returns the abbreviated commit hash, or "unknown" when it is not available.
+func (b BuildInfo) ShortCommit() string {
+	if b.GitCommit == "" {
+		return "unknown"
+	}
+	if len(b.GitCommit) > 7 {
+		return b.GitCommit[:7]
+	}
+	return b.GitCommit
+}
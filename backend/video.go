@@ -0,0 +1,110 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// VideoDuration returns the duration in seconds of an MP4 or QuickTime (MOV) video, read from the
+// movie header. Only the box headers and the header itself are read, so r can be a remote object.
+func VideoDuration(r io.ReaderAt, size int64) (float64, error) {
+	moovStart, moovEnd, err := findBox(r, 0, size, "moov")
+	if err != nil {
+		return 0, err
+	}
+	mvhdStart, mvhdEnd, err := findBox(r, moovStart, moovEnd, "mvhd")
+	if err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, min(mvhdEnd-mvhdStart, 32))
+	if _, err := r.ReadAt(header, mvhdStart); err != nil && err != io.EOF {
+		return 0, fmt.Errorf("failed to read movie header: %v", err)
+	}
+	var timescale, duration uint64
+	switch {
+	case len(header) >= 20 && header[0] == 0:
+		timescale = uint64(binary.BigEndian.Uint32(header[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(header[16:20]))
+	case len(header) >= 32 && header[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(header[20:24]))
+		duration = binary.BigEndian.Uint64(header[24:32])
+	default:
+		return 0, fmt.Errorf("unsupported movie header")
+	}
+	if timescale == 0 {
+		return 0, fmt.Errorf("movie header has no timescale")
+	}
+	return float64(duration) / float64(timescale), nil
+}
+
+// findBox returns the content range of the first box of the given type between start and end.
+func findBox(r io.ReaderAt, start, end int64, boxType string) (int64, int64, error) {
+	header := make([]byte, 16)
+	for pos := start; pos+8 <= end; {
+		n, err := r.ReadAt(header, pos)
+		if n < 8 {
+			return 0, 0, fmt.Errorf("failed to read box header at %d: %v", pos, err)
+		}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0: // Extends to the end
+			size = end - pos
+		case 1: // 64-bit size follows the type
+			if n < 16 {
+				return 0, 0, fmt.Errorf("failed to read box size at %d: %v", pos, err)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize {
+			return 0, 0, fmt.Errorf("invalid box size %d at %d", size, pos)
+		}
+		if string(header[4:8]) == boxType {
+			return pos + headerSize, min(pos+size, end), nil
+		}
+		pos += size
+	}
+	return 0, 0, fmt.Errorf("no %s box found", boxType)
+}
+
+// objectReaderAt reads a Storage object with range requests.
+type objectReaderAt struct {
+	ctx context.Context
+	obj *gcs.ObjectHandle
+}
+
+func (o objectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	reader, err := o.obj.NewRangeReader(o.ctx, off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	return io.ReadFull(reader, p)
+}
+
+// storedVideoDuration returns the duration of an uploaded MP4 or MOV video, from content if the
+// server has it or else with range reads of the object. It returns 0 for other files and for
+// videos whose duration cannot be read.
+func storedVideoDuration(ctx context.Context, bucket *gcs.BucketHandle, storagePath, mimeType string, content []byte, size int64) float64 {
+	if mimeType != "video/mp4" && mimeType != "video/quicktime" {
+		return 0
+	}
+	var r io.ReaderAt = objectReaderAt{ctx: ctx, obj: bucket.Object(storagePath)}
+	if content != nil {
+		r = bytes.NewReader(content)
+	}
+	duration, err := VideoDuration(r, size)
+	if err != nil {
+		log.Printf("Warning: Could not read duration of video %s: %v", storagePath, err)
+		return 0
+	}
+	return duration
+}
@@ -0,0 +1,272 @@
+package backend
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Folder visibilities. Files of private folders have no public ACL and are only reachable through
+// short-lived signed URLs added to listing responses, which only signed-in users get (see
+// IsFolderVisible).
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// PrivateURLTTL is how long the signed thumbnail and download URLs of private folders stay valid,
+// counted from the start of the signing window they were issued in (see signedURLWindow).
+const PrivateURLTTL = 15 * time.Minute
+
+var (
+	thumbnailKeyOnce sync.Once
+	thumbnailKey     []byte
+)
+
+// CheckThumbnailSigningKey returns an error if THUMBNAIL_SIGNING_KEY is not set, unless
+// allowRandom is set for local development (DEV_MODE or the emulators). A random key would then
+// be used, so signed URLs would only work on the instance that issued them until it restarts.
+func CheckThumbnailSigningKey(allowRandom bool) error {
+	if os.Getenv("THUMBNAIL_SIGNING_KEY") == "" && !allowRandom {
+		return errors.New("THUMBNAIL_SIGNING_KEY is not set; signed thumbnail and media URLs of private folders would not verify on other instances or after a restart")
+	}
+	return nil
+}
+
+// thumbnailSigningKey returns the HMAC key of signed thumbnail URLs, from THUMBNAIL_SIGNING_KEY.
+// Without it a random key is used, which the backend only allows for local development (see
+// CheckThumbnailSigningKey).
+func thumbnailSigningKey() []byte {
+	thumbnailKeyOnce.Do(func() {
+		if key := os.Getenv("THUMBNAIL_SIGNING_KEY"); key != "" {
+			thumbnailKey = []byte(key)
+			return
+		}
+		log.Printf("WARNING: THUMBNAIL_SIGNING_KEY is not set; signed thumbnail URLs are only valid on this instance until it restarts")
+		thumbnailKey = make([]byte, 32)
+		if _, err := rand.Read(thumbnailKey); err != nil {
+			log.Fatalf("Failed to generate thumbnail signing key: %v", err)
+		}
+	})
+	return thumbnailKey
+}
+
+// thumbnailSignature signs a file ID and expiry (Unix seconds).
+func thumbnailSignature(fileID string, expires int64) string {
+	mac := hmac.New(sha256.New, thumbnailSigningKey())
+	fmt.Fprintf(mac, "%s\n%d", fileID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ThumbnailURL returns the path of a file's thumbnail on the backend. For private folders the
+// path carries a signature that expires at the end of the current signing window (see
+// signedURLExpiry).
+func ThumbnailURL(fileID string, private bool) string {
+	u := "/api/thumbnails/" + url.PathEscape(fileID)
+	if !private {
+		return u
+	}
+	expires := signedURLExpiry(now()).Unix()
+	return fmt.Sprintf("%s?expires=%d&sig=%s", u, expires, thumbnailSignature(fileID, expires))
+}
+
+// MediaURL returns the path of the media proxy streaming a file's original, signed like
+// ThumbnailURL for private folders.
+func MediaURL(fileID string, private bool) string {
+	u := "/api/media/" + url.PathEscape(fileID)
+	if !private {
+		return u
+	}
+	expires := signedURLExpiry(now()).Unix()
+	return fmt.Sprintf("%s?expires=%d&sig=%s", u, expires, thumbnailSignature(fileID, expires))
+}
+
+// ResponsiveWidths are the sizes, in pixels of the longest side, that the thumbnail endpoint
+// resizes images to with ?w=; the smallest is the plain thumbnail.
+var ResponsiveWidths = []int{ThumbnailSize, 768, 1600}
+
+// ResizedImageURL is ThumbnailURL for one of ResponsiveWidths.
+func ResizedImageURL(fileID string, width int, private bool) string {
+	u := ThumbnailURL(fileID, private)
+	if width == ThumbnailSize {
+		return u
+	}
+	sep := "?"
+	if private {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%sw=%d", u, sep, width)
+}
+
+// srcset returns an image's URLs by width for responsive images (see responsiveWidthsFor), plus
+// its DownloadURL as "original".
+func srcset(file FileMetadata, private bool) map[string]string {
+	urls := map[string]string{"original": file.DownloadURL}
+	for _, width := range responsiveWidthsFor(file) {
+		urls[strconv.Itoa(width)] = ResizedImageURL(file.ID, width, private)
+	}
+	return urls
+}
+
+// VerifyThumbnailSignature reports whether expires and sig, from a URL made by ThumbnailURL or
+// MediaURL, are valid for fileID and have not expired.
+func VerifyThumbnailSignature(fileID, expires, sig string) bool {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(thumbnailSignature(fileID, exp)))
+}
+
+// IsPrivate reports whether the folder's files are hidden behind signed URLs.
+func (f FolderMetadata) IsPrivate() bool {
+	return f.Visibility == VisibilityPrivate
+}
+
+// AttachAccessURLs sets MediaURL on files, and ThumbnailURL and Srcset on the images in files,
+// which belong to folder. Files of a private folder get signed thumbnail and media URLs, their
+// DownloadURL is replaced by a signed Storage URL, and URLExpiresAt tells clients when to list
+// them again, so responses never contain permanent links to them. Signed URLs are cached per
+// signing window, and the folder is signed ahead while it keeps being listed.
+func AttachAccessURLs(folder *FolderMetadata, files []FileMetadata) error {
+	private := folder != nil && folder.IsPrivate()
+	expires := signedURLExpiry(now())
+	if private {
+		noteHotFolder(folder.ID)
+	}
+	for i := range files {
+		if private {
+			bucket, err := fileBucket(&files[i])
+			if err != nil {
+				return err
+			}
+			signed, err := signedGetURL(files[i].Bucket, bucket, files[i].StoragePath, expires)
+			if err != nil {
+				return err
+			}
+			files[i].DownloadURL = signed
+			files[i].URLExpiresAt = &expires
+		}
+		files[i].MediaURL = MediaURL(files[i].ID, private)
+		if mediaTypeOf(files[i].MimeType) == "image" {
+			files[i].ThumbnailURL = ThumbnailURL(files[i].ID, private)
+			files[i].Srcset = srcset(files[i], private)
+		}
+	}
+	return nil
+}
+
+// AttachAccessURLsByFolder is AttachAccessURLs for files from any number of folders.
+func AttachAccessURLsByFolder(ctx context.Context, files []FileMetadata) error {
+	folders := make(map[string]*FolderMetadata)
+	for i := range files {
+		folder, ok := folders[files[i].FolderID]
+		if !ok {
+			var err error
+			if folder, err = GetFolder(ctx, files[i].FolderID); err != nil {
+				return err
+			}
+			folders[files[i].FolderID] = folder
+		}
+		if err := AttachAccessURLs(folder, files[i:i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetFile returns the metadata of a file, or nil if it does not exist.
+func GetFile(ctx context.Context, fileID string) (*FileMetadata, error) {
+	doc, err := Client.Collection(FilesCollection).Doc(fileID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get file %s: %v", fileID, err)
+	}
+	var file FileMetadata
+	if err := doc.DataTo(&file); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal file metadata: %v", err)
+	}
+	return &file, nil
+}
+
+// MakeFileThumbnail reads a stored image and returns it resized to at most maxSize pixels on the
+// longest side (see MakeThumbnail), upright and with the watermark drawn on it if the file has
+// Watermark.
+func MakeFileThumbnail(ctx context.Context, file *FileMetadata, maxSize int) ([]byte, error) {
+	bucket, err := fileBucket(file)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := bucket.Object(file.StoragePath).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", file.StoragePath, err)
+	}
+	defer reader.Close()
+	return makeFileThumbnail(reader, file, maxSize)
+}
+
+// SetFolderVisibility makes a folder public or private. Its files' public ACLs are removed or
+// restored accordingly (in "public" DOWNLOAD_URL_MODE) and the folder is saved last, so a failed
+// call can be repeated. It returns the number of files processed.
+func SetFolderVisibility(ctx context.Context, folderID, visibility string) (int, error) {
+	if visibility != VisibilityPublic && visibility != VisibilityPrivate {
+		return 0, fmt.Errorf("invalid visibility '%s' (expected '%s' or '%s')", visibility, VisibilityPublic, VisibilityPrivate)
+	}
+	folder, err := GetFolder(ctx, folderID)
+	if err != nil {
+		return 0, err
+	}
+	if folder == nil {
+		return 0, notFound("folder %s", folderID)
+	}
+	files, err := ListAllFilesInFolder(ctx, folderID)
+	if err != nil {
+		return 0, err
+	}
+
+	if downloadURLMode() == "public" {
+		for i, file := range files {
+			bucket, err := fileBucket(&file)
+			if err != nil {
+				return i, err
+			}
+			acl := bucket.Object(file.StoragePath).ACL()
+			if visibility == VisibilityPrivate {
+				err = acl.Delete(ctx, gcs.AllUsers)
+			} else {
+				err = acl.Set(ctx, gcs.AllUsers, gcs.RoleReader)
+			}
+			// Deleting an ACL entry the object does not have fails with 404
+			var apiErr *googleapi.Error
+			if err != nil && !(errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound) {
+				return i, fmt.Errorf("failed to update ACL of %s: %v", file.StoragePath, err)
+			}
+		}
+	}
+
+	if _, err := Client.Collection(FoldersCollection).Doc(folderID).Update(ctx, []firestore.Update{{Path: "visibility", Value: visibility}}); err != nil {
+		return len(files), fmt.Errorf("failed to set visibility of folder %s: %v", folderID, err)
+	}
+	log.Printf("Folder %s is now %s (%d files).", folderID, visibility, len(files))
+	recordFolderChange(ctx, folderID, FolderChange{Type: ChangeEdited, Name: folder.Name, Fields: []string{"visibility"}})
+	return len(files), nil
+}
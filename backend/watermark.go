@@ -0,0 +1,70 @@
+package backend
+
+import (
+	"image"
+	"image/draw"
+	"log"
+	"os"
+	"sync"
+)
+
+// watermarkMaxFraction bounds the width of the watermark to 1/watermarkMaxFraction of the image's
+// width; larger watermark images are scaled down.
+const watermarkMaxFraction = 4
+
+var (
+	watermarkOnce  sync.Once
+	watermarkImage image.Image
+)
+
+// loadWatermark reads the image at WATERMARK_IMAGE once, a PNG for transparency. Without it, or if it cannot be read,
+// files with Watermark are rendered without one and a warning is logged.
+func loadWatermark() image.Image {
+	watermarkOnce.Do(func() {
+		path := os.Getenv("WATERMARK_IMAGE")
+		if path == "" {
+			log.Printf("Warning: WATERMARK_IMAGE is not set; renditions of watermarked files are not watermarked")
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("Warning: Could not open watermark %s: %v", path, err)
+			return
+		}
+		defer f.Close()
+		img, _, err := image.Decode(f)
+		if err != nil {
+			log.Printf("Warning: Could not decode watermark %s: %v", path, err)
+			return
+		}
+		watermarkImage = img
+	})
+	return watermarkImage
+}
+
+// drawWatermark draws the watermark in the bottom right corner of dst, at most a quarter of its
+// width, with a margin of a fiftieth of its width.
+func drawWatermark(dst *image.RGBA) {
+	mark := loadWatermark()
+	if mark == nil {
+		return
+	}
+	b, mb := dst.Bounds(), mark.Bounds()
+	w, h := mb.Dx(), mb.Dy()
+	if limit := b.Dx() / watermarkMaxFraction; w > limit {
+		w, h = limit, h*limit/w
+	}
+	if w == 0 || h == 0 {
+		return
+	}
+	// Nearest-neighbor scaling is enough for a small logo, and keeps its transparency
+	scaled := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			scaled.Set(x, y, mark.At(mb.Min.X+x*mb.Dx()/w, mb.Min.Y+y*mb.Dy()/h))
+		}
+	}
+	margin := b.Dx() / 50
+	at := image.Rect(b.Max.X-margin-w, b.Max.Y-margin-h, b.Max.X-margin, b.Max.Y-margin)
+	draw.Draw(dst, at, scaled, image.Point{}, draw.Over)
+}
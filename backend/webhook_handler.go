@@ -1,52 +1,145 @@
 package backend
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
+// DriveChange describes a change reported by a Drive push notification.
+type DriveChange struct {
+	FileID        string     `json:"fileId"`
+	ResourceState string     `json:"resourceState"`  // add, update, remove, trash or untrash
+	File          *DriveFile `json:"file,omitempty"` // Nil for removals or if the lookup failed
+}
+
+// driveFileIDFromURI extracts the file ID from an X-Goog-Resource-URI such as
+// "https://www.googleapis.com/drive/v3/files/FILE_ID?alt=json". It returns "" if there is none.
+func driveFileIDFromURI(resourceURI string) string {
+	u, err := url.Parse(resourceURI)
+	if err != nil {
+		return ""
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i] == "files" {
+			return segments[i+1]
+		}
+	}
+	return ""
+}
+
+// driveEventType returns the WebSocket event for a Drive resource state, or "" if there is nothing to broadcast.
+func driveEventType(resourceState string) string {
+	switch resourceState {
+	case "add", "untrash":
+		return EventDriveFileAdded
+	case "update":
+		return EventDriveFileUpdated
+	case "remove", "trash":
+		return EventDriveFileRemoved
+	}
+	return ""
+}
+
+// ProcessDriveChange looks up the changed file's Drive metadata and broadcasts the change to
+// WebSocket clients. Removals are broadcast without metadata. Metadata already in change.File,
+// e.g. fetched with GetDriveFiles for a batch of changes, is not looked up again.
+func ProcessDriveChange(ctx context.Context, change DriveChange) error {
+	eventType := driveEventType(change.ResourceState)
+	if eventType == "" {
+		return fmt.Errorf("unsupported resource state %q", change.ResourceState)
+	}
+	if eventType == EventDriveFileRemoved {
+		change.File = nil
+	} else if change.File == nil {
+		file, err := GetDriveFile(ctx, change.FileID)
+		if err != nil {
+			return err
+		}
+		change.File = file
+	}
+	BroadcastEvent(eventType, change)
+	return nil
+}
+
+type simulatedFileKey struct{}
+
+// WithSimulatedDriveFile returns a context under which WebhookHandler takes file as the Drive
+// metadata of the notified file instead of looking it up, so simulated notifications work
+// without Drive credentials or for files that don't exist.
+func WithSimulatedDriveFile(ctx context.Context, file *DriveFile) context.Context {
+	return context.WithValue(ctx, simulatedFileKey{}, file)
+}
+
+func simulatedDriveFile(ctx context.Context, fileID string) *DriveFile {
+	if file, _ := ctx.Value(simulatedFileKey{}).(*DriveFile); file != nil && file.ID == fileID {
+		return file
+	}
+	return nil
+}
+
 // webhookHandler receives and processes Google Drive webhook notifications.
+// Changes to watched files are broadcast to WebSocket clients with the file's Drive metadata.
+// Notifications that fail are recorded as dead letters for ReplayDeadLetters; only if that also
+// fails is an error returned, so Drive retries the notification. Notifications with a message
+// number already received on their channel are acknowledged without being processed again, and
+// those with missing headers, a wrong channel token, a Date too far off or an expired channel
+// are rejected.
 func WebhookHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Log the received request headers for now
-	log.Printf("Received Webhook Request:")
 	channelID := r.Header.Get("X-Goog-Channel-ID")
 	resourceState := r.Header.Get("X-Goog-Resource-State")
-	resourceID := r.Header.Get("X-Goog-Resource-ID") // The ID of the file or folder that changed
+	resourceID := r.Header.Get("X-Goog-Resource-ID")       // Opaque ID of the watched resource, not the file ID
 	messageNumber := r.Header.Get("X-Goog-Message-Number") // A unique identifier for this message
+	fileID := driveFileIDFromURI(r.Header.Get("X-Goog-Resource-URI"))
+	log.Printf("Received Webhook Request: channel=%s state=%s resource=%s file=%s message=%s", channelID, resourceState, resourceID, fileID, messageNumber)
 
-	log.Printf("X-Goog-Channel-ID: %s", channelID)
-	log.Printf("X-Goog-Resource-State: %s", resourceState)
-	log.Printf("X-Goog-Resource-ID: %s", resourceID)
-	log.Printf("X-Goog-Message-Number: %s", messageNumber)
+	number, err := checkDriveNotification(r)
+	if err != nil {
+		log.Printf("Rejected webhook notification: %v", err)
+		http.Error(w, "Invalid webhook notification", http.StatusBadRequest)
+		return
+	}
+	var replayErr *DriveReplayError
+	if err := claimDriveMessage(r.Context(), channelID, number); errors.As(err, &replayErr) {
+		log.Printf("Ignored webhook notification: %v", replayErr)
+		fmt.Fprintln(w, "Webhook notification already processed")
+		return
+	} else if err != nil {
+		log.Printf("ERROR: Failed to record message %d of channel %s: %v", number, channelID, err)
+		http.Error(w, "Webhook notification could not be processed", http.StatusServiceUnavailable)
+		return
+	}
 
-	// TODO: Implement more detailed logic based on resourceState
-	switch resourceState {
-	case "add":
-		log.Printf("Resource added: %s", resourceID)
-		// Notify frontend about the new file
-	case "update":
-		log.Printf("Resource updated: %s", resourceID)
-		// Notify frontend about the updated file
-	case "remove", "trash":
-		log.Printf("Resource removed/trashed: %s", resourceID)
-		// Notify frontend about the removed file
-	case "untrash":
-		log.Printf("Resource untrashed: %s", resourceID)
-		// Notify frontend about the restored file
-	default:
+	switch {
+	case resourceState == "sync":
+		// Sent once when a channel is created; there is no change to report
+	case driveEventType(resourceState) == "":
 		log.Printf("Unknown resource state: %s for resource %s", resourceState, resourceID)
+	case fileID == "":
+		log.Printf("Webhook notification for resource %s has no file ID in its resource URI, not broadcasting", resourceID)
+	default:
+		change := DriveChange{FileID: fileID, ResourceState: resourceState, File: simulatedDriveFile(r.Context(), fileID)}
+		if err := ProcessDriveChange(r.Context(), change); err != nil {
+			log.Printf("Error processing webhook notification for Drive file %s: %v", fileID, err)
+			if err := RecordDeadLetter(r.Context(), change, channelID, messageNumber, err); err != nil {
+				log.Printf("ERROR: %v", err)
+				releaseDriveMessage(r.Context(), channelID, number)
+				http.Error(w, "Webhook notification could not be processed", http.StatusServiceUnavailable)
+				return
+			}
+		}
 	}
 
-	// For now, just acknowledge receipt
+	// Acknowledge receipt so Drive does not retry the notification
 	fmt.Fprintln(w, "Webhook notification processed")
-
-	// Actual notification logic will be based on resourceState
-	// For example, if a file is added, modified, or deleted:
-	// BroadcastMessage([]byte(fmt.Sprintf("{\"type\": \"%s\", \"resourceId\": \"%s\"}", resourceState, resourceID)))
 }
@@ -1,8 +1,12 @@
 package backend
 
 import (
+	"context"
+	"encoding/json"
 	"log"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -19,61 +23,204 @@ var upgrader = websocket.Upgrader{
 
 // client represents a single WebSocket client.
 type client struct {
-	conn *websocket.Conn
-	send chan []byte // Buffered channel of outbound messages.
+	conn        *websocket.Conn
+	send        chan []byte // Buffered channel of outbound messages.
+	id          string      // Random, so operators can tell clients apart without seeing their addresses
+	connectedAt time.Time
+	audience    audience // The most restricted events the viewer who connected may receive
+
+	filter EventFilter // Set by the client with a "filter" message; only used by the hub goroutine
+}
+
+// EventFilter is what a client asked to receive. Empty lists don't restrict anything.
+// Clients set it by sending {"type": "filter", "eventTypes": [...], "folderIds": [...]};
+// a filter message without lists resets it.
+type EventFilter struct {
+	EventTypes []string `json:"eventTypes,omitempty"` // Event types to receive, e.g. ["file_uploaded"]
+	FolderIDs  []string `json:"folderIds,omitempty"`  // Folders whose events to receive; events of no folder are not affected
+}
+
+// allows reports whether an event passes the filter. Untyped messages (BroadcastMessage) are
+// only sent to clients without an event type filter.
+func (f EventFilter) allows(eventType, folderID string) bool {
+	if len(f.EventTypes) > 0 && !contains(f.EventTypes, eventType) {
+		return false
+	}
+	if len(f.FolderIDs) > 0 && folderID != "" && !contains(f.FolderIDs, folderID) {
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// audience is who may receive an event, from everyone to moderators only. A client receives the
+// events of its audience and of the less restricted ones.
+type audience int
+
+const (
+	audienceEveryone   audience = iota
+	audienceSignedIn            // Events of private, embargoed or expired folders, or of files showing hidden people
+	audienceModerators          // Events of uploads awaiting approval
+)
+
+// viewerAudience returns the audience of the viewer of ctx (see WithPublicViewer and WithModerator).
+func viewerAudience(ctx context.Context) audience {
+	switch {
+	case isPublicViewer(ctx):
+		return audienceEveryone
+	case isModerator(ctx):
+		return audienceModerators
+	}
+	return audienceSignedIn
+}
+
+// hubMessage is a message to broadcast, with what the clients' filters are applied to.
+type hubMessage struct {
+	data      []byte
+	eventType string   // Empty for untyped messages
+	folderID  string   // Folder the event belongs to, if any
+	audience  audience // Who may receive it
+}
+
+// filterUpdate is a client's request to replace its EventFilter.
+type filterUpdate struct {
+	client *client
+	filter EventFilter
 }
 
 // hub maintains the set of active clients and broadcasts messages to the clients.
 type hub struct {
-	clients    map[*client]bool   // Registered clients.
-	broadcast  chan []byte        // Inbound messages from the clients.
-	register   chan *client       // Register requests from the clients.
-	unregister chan *client       // Unregister requests from clients.
+	clients    map[*client]bool  // Registered clients.
+	broadcast  chan hubMessage   // Inbound messages from the clients.
+	register   chan *client      // Register requests from the clients.
+	unregister chan *client      // Unregister requests from clients.
+	setFilter  chan filterUpdate // Filter messages from the clients.
+	snapshot   chan chan []ClientInfo
+}
+
+// ClientInfo describes a connected WebSocket client for GET /api/admin/ws.
+type ClientInfo struct {
+	ID            string    `json:"id"`
+	ConnectedAt   time.Time `json:"connectedAt"`
+	EventTypes    []string  `json:"eventTypes,omitempty"` // Filtered event types; empty for all
+	FolderIDs     []string  `json:"folderIds,omitempty"`  // Subscribed folders; empty for all
+	QueueDepth    int       `json:"queueDepth"`           // Messages waiting to be written
+	QueueCapacity int       `json:"queueCapacity"`        // Client is dropped when the queue is full
+}
+
+// HubStatus is the state of the WebSocket hub and its counters since startup.
+type HubStatus struct {
+	Running    bool         `json:"running"`
+	Clients    []ClientInfo `json:"clients"`
+	Broadcasts int64        `json:"broadcasts"` // Messages broadcast
+	Delivered  int64        `json:"delivered"`  // Messages queued for a client
+	Filtered   int64        `json:"filtered"`   // Messages not sent to a client because of its filter
+	Dropped    int64        `json:"dropped"`    // Messages lost because a client's queue was full; the client was disconnected
 }
 
+// Hub counters, updated by the hub goroutine.
+var hubBroadcasts, hubDelivered, hubFiltered, hubDropped atomic.Int64
+
 var h = hub{
-	broadcast:  make(chan []byte),
+	broadcast:  make(chan hubMessage),
 	register:   make(chan *client),
 	unregister: make(chan *client),
+	setFilter:  make(chan filterUpdate),
+	snapshot:   make(chan chan []ClientInfo),
 	clients:    make(map[*client]bool),
 }
 
+// activeClients mirrors len(h.clients) so it can be read outside the hub goroutine.
+var activeClients atomic.Int64
+
+// ActiveClients returns the number of connected WebSocket clients.
+func ActiveClients() int {
+	return int(activeClients.Load())
+}
+
 func (h *hub) run() {
 	for {
 		select {
 		case client := <-h.register:
 			h.clients[client] = true
+			activeClients.Store(int64(len(h.clients)))
 			log.Println("Client registered")
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
 				close(client.send)
+				activeClients.Store(int64(len(h.clients)))
 				log.Println("Client unregistered")
 			}
+		case update := <-h.setFilter:
+			if _, ok := h.clients[update.client]; !ok {
+				break
+			}
+			update.client.filter = update.filter
+			// Acknowledge with the filter now in effect
+			ack, err := json.Marshal(Event{Type: EventFilterApplied, Data: update.filter})
+			if err != nil {
+				break
+			}
+			select {
+			case update.client.send <- ack:
+			default: // A client that falls behind is closed by the next broadcast
+			}
+		case reply := <-h.snapshot:
+			clients := make([]ClientInfo, 0, len(h.clients))
+			for client := range h.clients {
+				clients = append(clients, ClientInfo{
+					ID:            client.id,
+					ConnectedAt:   client.connectedAt,
+					EventTypes:    client.filter.EventTypes,
+					FolderIDs:     client.filter.FolderIDs,
+					QueueDepth:    len(client.send),
+					QueueCapacity: cap(client.send),
+				})
+			}
+			reply <- clients
 		case message := <-h.broadcast:
-			log.Printf("Hub: Broadcasting message to %d clients: %s", len(h.clients), string(message))
+			log.Printf("Hub: Broadcasting message to %d clients: %s", len(h.clients), string(message.data))
+			hubBroadcasts.Add(1)
 			for client := range h.clients {
+				if client.audience < message.audience || !client.filter.allows(message.eventType, message.folderID) {
+					hubFiltered.Add(1)
+					continue
+				}
 				select {
-				case client.send <- message:
-					log.Printf("Hub: Sent message to client %p", client)
+				case client.send <- message.data:
+					hubDelivered.Add(1)
+					log.Printf("Hub: Sent message to client %s", client.id)
 				default:
-					log.Printf("Hub: Failed to send message to client %p, closing connection.", client)
+					hubDropped.Add(1)
+					log.Printf("Hub: Failed to send message to client %s, closing connection.", client.id)
 					close(client.send)
 					delete(h.clients, client)
+					activeClients.Store(int64(len(h.clients)))
 				}
 			}
 		}
 	}
 }
 
-// ServeWs handles websocket requests from the peer.
+// ServeWs handles websocket requests from the peer. The context of r tells who the viewer is (see
+// WithPublicViewer and WithModerator): public viewers don't receive events of folders and files
+// hidden from them, and only moderators receive events of uploads awaiting approval.
 func ServeWs(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Failed to upgrade to websocket:", err)
 		return
 	}
-	client := &client{conn: conn, send: make(chan []byte, 256)}
+	client := &client{conn: conn, send: make(chan []byte, 256), id: newID(), connectedAt: now(), audience: viewerAudience(r.Context())}
 	h.register <- client
 
 	// Allow collection of memory referenced by the caller by doing all work in
@@ -99,11 +246,25 @@ func (c *client) readPump() {
 			}
 			break
 		}
-		// For now, just log received messages.
-		// Later, this could be used for client-to-server communication if needed.
+		c.handleMessage(message)
+	}
+}
+
+// filterMessage is the message a client sends to set its EventFilter.
+type filterMessage struct {
+	Type string `json:"type"` // "filter"
+	EventFilter
+}
+
+// handleMessage applies a message received from the client. The only one understood is "filter",
+// which the hub acknowledges with a "filter_applied" event carrying the new filter.
+func (c *client) handleMessage(message []byte) {
+	var msg filterMessage
+	if err := json.Unmarshal(message, &msg); err != nil || msg.Type != "filter" {
 		log.Printf("Received message from client: %s", string(message))
-		// h.broadcast <- message // Example: Echo back to all clients
+		return
 	}
+	h.setFilter <- filterUpdate{client: c, filter: msg.EventFilter}
 }
 
 // writePump pumps messages from the hub to the websocket connection.
@@ -138,12 +299,149 @@ func BroadcastMessage(message []byte) {
 		log.Println("Error: Hub broadcast channel is nil!")
 		return
 	}
-	h.broadcast <- message
+	h.broadcast <- hubMessage{data: message}
 	log.Println("BroadcastMessage: Message sent to hub broadcast channel.")
 }
 
+// Event types broadcast to WebSocket clients when gallery data changes.
+const (
+	EventFileUploaded   = "file_uploaded"   // Data: FileMetadata; several uploads may be combined into EventFilesUploaded
+	EventFileDeleted    = "file_deleted"    // Data: {"id", "storagePath", "folderId"}
+	EventFolderCreated  = "folder_created"  // Data: FolderMetadata
+	EventFolderUpdated  = "folder_updated"  // Data: FolderMetadata, e.g. after it was archived
+	EventProfileUpdated = "profile_updated" // Data: Profile, {"id", "deleted": true}, or {"order": [...]} after a reorder
+
+	EventDriveFileAdded   = "drive_file_added"   // Data: DriveChange
+	EventDriveFileUpdated = "drive_file_updated" // Data: DriveChange
+	EventDriveFileRemoved = "drive_file_removed" // Data: DriveChange, without file metadata
+
+	EventFilterApplied = "filter_applied" // Sent only to the client that set a filter; Data: EventFilter
+)
+
+// Event is the JSON message sent to WebSocket clients.
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// hubRunning is set by InitHub. Without it nothing reads the broadcast channel, e.g. when the
+// backend package is used by the CLI, so events are dropped instead of blocking.
+var hubRunning atomic.Bool
+
+// eventAudienceTimeout bounds the lookups of eventAudience.
+const eventAudienceTimeout = 10 * time.Second
+
+// BroadcastEvent sends a typed event to the connected WebSocket clients whose filter allows it
+// and who may see what it is about (see eventAudience).
+func BroadcastEvent(eventType string, data interface{}) {
+	dispatchWebhooks(eventType, data)
+	if !hubRunning.Load() {
+		return
+	}
+	message, err := json.Marshal(Event{Type: eventType, Data: data})
+	if err != nil {
+		log.Printf("Error marshaling %s event: %v", eventType, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), eventAudienceTimeout)
+	defer cancel()
+	h.broadcast <- hubMessage{data: message, eventType: eventType, folderID: eventFolderID(data), audience: eventAudience(ctx, data)}
+}
+
+// eventAudience returns who may receive an event about data: moderators only for uploads awaiting
+// approval, signed-in users for events of folders hidden from public viewers (see
+// IsFolderVisible) and of files showing hidden people, and everyone otherwise. If that cannot be
+// looked up, the event is only sent to signed-in users.
+func eventAudience(ctx context.Context, data interface{}) audience {
+	var folder *FolderMetadata
+	var files []FileMetadata
+	switch d := data.(type) {
+	case FolderMetadata:
+		folder = &d
+	case *FolderMetadata:
+		folder = d
+	case FileMetadata:
+		files = []FileMetadata{d}
+	case *FileMetadata:
+		files = []FileMetadata{*d}
+	case UploadDigest:
+		files = d.Files
+	}
+	for _, file := range files {
+		if file.PendingApproval {
+			return audienceModerators
+		}
+	}
+	if folderID := eventFolderID(data); folder == nil && folderID != "" {
+		var err error
+		if folder, err = GetFolder(ctx, folderID); err != nil {
+			log.Printf("Warning: Could not look up folder %s of an event: %v", folderID, err)
+			return audienceSignedIn
+		}
+	}
+	if folder != nil && !IsFolderVisible(WithPublicViewer(ctx), folder) {
+		return audienceSignedIn
+	}
+	if len(files) > 0 {
+		hidden, err := HiddenProfileIDs(ctx)
+		if err != nil {
+			log.Printf("Warning: Could not look up hidden profiles for an event: %v", err)
+			return audienceSignedIn
+		}
+		for _, file := range files {
+			if showsHiddenPerson(file, hidden) {
+				return audienceSignedIn
+			}
+		}
+	}
+	return audienceEveryone
+}
+
+// eventFolderID returns the folder an event's data belongs to, or "" for events of no folder.
+func eventFolderID(data interface{}) string {
+	switch d := data.(type) {
+	case FileMetadata:
+		return d.FolderID
+	case *FileMetadata:
+		return d.FolderID
+	case FolderMetadata:
+		return d.ID
+	case *FolderMetadata:
+		return d.ID
+	case UploadDigest:
+		return d.FolderID
+	case map[string]string:
+		return d["folderId"]
+	}
+	return ""
+}
+
+// GetHubStatus returns the connected clients and the hub counters.
+func GetHubStatus(ctx context.Context) (*HubStatus, error) {
+	status := &HubStatus{
+		Running:    hubRunning.Load(),
+		Clients:    []ClientInfo{},
+		Broadcasts: hubBroadcasts.Load(),
+		Delivered:  hubDelivered.Load(),
+		Filtered:   hubFiltered.Load(),
+		Dropped:    hubDropped.Load(),
+	}
+	if !status.Running {
+		return status, nil
+	}
+	reply := make(chan []ClientInfo, 1)
+	select {
+	case h.snapshot <- reply:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	status.Clients = <-reply
+	return status, nil
+}
+
 // InitHub starts the WebSocket hub. This should be called once during application startup.
 func InitHub() {
+	hubRunning.Store(true)
 	go h.run()
 	log.Println("WebSocket hub initialized")
 }
@@ -0,0 +1,122 @@
+package backend
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/time/rate"
+)
+
+// Firestore's guidance for ramping up traffic ("500/50/5"): start at no more than 500 writes per
+// second and increase by at most 50% every 5 minutes. A single document should be written at
+// most once per second.
+const (
+	writeRampStart    = 500             // Writes per second at the start of a ramp
+	writeRampIncrease = 1.5             // Factor applied every writeRampInterval
+	writeRampInterval = 5 * time.Minute // Also the idle time after which the ramp starts over
+	maxWriteRate      = 10000           // Firestore's limit on writes per second per database
+	docWriteInterval  = time.Second
+)
+
+// WriteLimiterStats are the counters of the shared Firestore write limiter since startup.
+type WriteLimiterStats struct {
+	Writes           int64   `json:"writes"`           // Writes let through
+	Delayed          int64   `json:"delayed"`          // Writes that had to wait for their turn
+	WaitSeconds      float64 `json:"waitSeconds"`      // Total time writes spent waiting
+	Queued           int     `json:"queued"`           // Writes waiting right now
+	MaxQueued        int     `json:"maxQueued"`        // Most writes ever waiting at once
+	RatePerSecond    float64 `json:"ratePerSecond"`    // Current step of the ramp
+	DocumentThrottle int64   `json:"documentThrottle"` // Writes delayed because their document was written less than a second ago
+}
+
+// writeLimiter paces the Firestore writes of bulk operations. Writes from all operations share
+// one ramp, so concurrent uploads, duplications and restores together stay within it. Pacing
+// uses wall time rather than DefaultClock, as it throttles real requests.
+type writeLimiter struct {
+	mu        sync.Mutex
+	limiter   *rate.Limiter
+	rampStart time.Time
+	lastWrite time.Time
+	docs      map[string]time.Time // Time of the last write by document path
+	stats     WriteLimiterStats
+}
+
+var bulkWrites = &writeLimiter{
+	limiter: rate.NewLimiter(writeRampStart, writeRampStart),
+	docs:    make(map[string]time.Time),
+}
+
+// waitForWrite blocks until a write to ref fits the shared write limits. It returns ctx's error
+// if ctx is done first. Bulk operations call it before every write they issue or enqueue.
+func waitForWrite(ctx context.Context, ref *firestore.DocumentRef) error {
+	return bulkWrites.wait(ctx, ref.Path)
+}
+
+// FirestoreWriteStats returns the counters of the shared Firestore write limiter.
+func FirestoreWriteStats() WriteLimiterStats {
+	bulkWrites.mu.Lock()
+	defer bulkWrites.mu.Unlock()
+	stats := bulkWrites.stats
+	stats.RatePerSecond = float64(bulkWrites.limiter.Limit())
+	return stats
+}
+
+func (l *writeLimiter) wait(ctx context.Context, path string) error {
+	l.mu.Lock()
+	t := time.Now()
+	if t.Sub(l.lastWrite) > writeRampInterval {
+		l.rampStart = t // Idle long enough that Firestore has scaled back down
+	}
+	steps := math.Floor(float64(t.Sub(l.rampStart)) / float64(writeRampInterval))
+	l.limiter.SetLimitAt(t, rate.Limit(math.Min(writeRampStart*math.Pow(writeRampIncrease, steps), maxWriteRate)))
+
+	reservation := l.limiter.ReserveN(t, 1)
+	at := t.Add(reservation.DelayFrom(t))
+	if last, ok := l.docs[path]; ok && last.Add(docWriteInterval).After(at) {
+		at = last.Add(docWriteInterval)
+		l.stats.DocumentThrottle++
+	}
+	l.docs[path] = at
+	l.lastWrite = at
+	if len(l.docs) > 4*writeRampStart {
+		for p, last := range l.docs {
+			if t.Sub(last) > docWriteInterval {
+				delete(l.docs, p)
+			}
+		}
+	}
+
+	delay := at.Sub(t)
+	l.stats.Writes++
+	if delay <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.stats.Delayed++
+	l.stats.WaitSeconds += delay.Seconds()
+	l.stats.Queued++
+	if l.stats.Queued > l.stats.MaxQueued {
+		l.stats.MaxQueued = l.stats.Queued
+	}
+	l.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		reservation.CancelAt(time.Now())
+		err = ctx.Err()
+	}
+	l.mu.Lock()
+	l.stats.Queued--
+	if err != nil {
+		l.stats.Writes--
+	}
+	l.mu.Unlock()
+	return err
+}
@@ -0,0 +1,308 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DailyCount is the number of uploads of a day.
+type DailyCount struct {
+	Date  string `json:"date"` // YYYY-MM-DD
+	Count int    `json:"count"`
+}
+
+// LoggedError is an error the backend logged.
+type LoggedError struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// GalleryStats is the overview of the admin dashboard.
+type GalleryStats struct {
+	Folders                int64            `json:"folders"`
+	Files                  int64            `json:"files"`
+	FilesByMediaType       map[string]int64 `json:"filesByMediaType"`
+	TotalBytes             int64            `json:"totalBytes"`
+	UploadsPerDay          []DailyCount     `json:"uploadsPerDay"` // Last 30 days, oldest first
+	ActiveWebSocketClients int              `json:"activeWebSocketClients"`
+	RecentErrors           []LoggedError    `json:"recentErrors"` // Newest first
+	GeneratedAt            time.Time        `json:"generatedAt"`
+
+	// Counters of the backend's internals, as the dashboard shows them
+	FirestoreWrites json.RawMessage `json:"firestoreWrites"`
+	SignedURLs      json.RawMessage `json:"signedUrls"`
+	DriveAPI        json.RawMessage `json:"driveApi"`
+	AccessLog       json.RawMessage `json:"accessLog"`
+}
+
+// Stats returns the overview of the gallery (GET /api/admin/stats). Like the other /api/admin
+// methods it needs the token of an editor or admin, or of an admin where documented.
+func (c *Client) Stats(ctx context.Context) (*GalleryStats, error) {
+	stats, err := doData[GalleryStats](ctx, c, http.MethodGet, "/api/admin/stats", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// NearDuplicates returns groups of visually near-identical images in a folder, or in the whole
+// gallery if folderID is empty (GET /api/admin/duplicates). distance is the largest perceptual
+// hash distance within a group, from 0 to 64, or negative for the backend's default.
+func (c *Client) NearDuplicates(ctx context.Context, folderID string, distance int) ([][]File, error) {
+	query := url.Values{}
+	if folderID != "" {
+		query.Set("folderId", folderID)
+	}
+	if distance >= 0 {
+		query.Set("distance", strconv.Itoa(distance))
+	}
+	return doData[[][]File](ctx, c, http.MethodGet, "/api/admin/duplicates?"+query.Encode(), nil)
+}
+
+// WebSocketClient is a client connected to the backend's /ws.
+type WebSocketClient struct {
+	ID            string    `json:"id"`
+	ConnectedAt   time.Time `json:"connectedAt"`
+	EventTypes    []string  `json:"eventTypes,omitempty"` // Empty for all
+	FolderIDs     []string  `json:"folderIds,omitempty"`  // Empty for all
+	QueueDepth    int       `json:"queueDepth"`
+	QueueCapacity int       `json:"queueCapacity"`
+}
+
+// HubStatus is the state of the backend's WebSocket hub.
+type HubStatus struct {
+	Running    bool              `json:"running"`
+	Clients    []WebSocketClient `json:"clients"`
+	Broadcasts int64             `json:"broadcasts"`
+	Delivered  int64             `json:"delivered"`
+	Filtered   int64             `json:"filtered"` // Not sent because of a client's filter
+	Dropped    int64             `json:"dropped"`  // Lost because a client's queue was full
+}
+
+// WebSocketStatus returns the connected WebSocket clients and the hub's counters (GET
+// /api/admin/ws).
+func (c *Client) WebSocketStatus(ctx context.Context) (*HubStatus, error) {
+	status, err := doData[HubStatus](ctx, c, http.MethodGet, "/api/admin/ws", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ConcurrencyStats is the load of a concurrency limit of the backend.
+type ConcurrencyStats struct {
+	Class          string     `json:"class"` // "global", or the route class
+	Limit          int        `json:"limit"` // 0 means unlimited
+	InFlight       int        `json:"inFlight"`
+	MaxInFlight    int        `json:"maxInFlight"`
+	Admitted       int64      `json:"admitted"`
+	Rejected       int64      `json:"rejected"` // Answered with 503
+	LastRejectedAt *time.Time `json:"lastRejectedAt,omitempty"`
+}
+
+// Concurrency returns the load of the backend's global limit followed by its route classes (GET
+// /api/admin/concurrency).
+func (c *Client) Concurrency(ctx context.Context) ([]ConcurrencyStats, error) {
+	return doData[[]ConcurrencyStats](ctx, c, http.MethodGet, "/api/admin/concurrency", nil)
+}
+
+// DeadLetter is a Drive notification whose processing failed.
+type DeadLetter struct {
+	ID            string    `json:"id"`
+	FileID        string    `json:"fileId"`
+	ResourceState string    `json:"resourceState"`
+	ChannelID     string    `json:"channelId"`
+	MessageNumber string    `json:"messageNumber"`
+	Error         string    `json:"error"` // Of the last attempt
+	Attempts      int       `json:"attempts"`
+	CreatedAt     time.Time `json:"createdAt"`
+	LastAttemptAt time.Time `json:"lastAttemptAt"`
+}
+
+// DeadLetters returns the Drive notifications whose processing failed (GET
+// /api/admin/dead-letters).
+func (c *Client) DeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	return doData[[]DeadLetter](ctx, c, http.MethodGet, "/api/admin/dead-letters", nil)
+}
+
+// ReplayResult is the outcome of ReplayDeadLetters.
+type ReplayResult struct {
+	Replayed []string          `json:"replayed"` // IDs of the dead letters processed and removed
+	Failed   map[string]string `json:"failed"`   // Error messages by ID; these are kept
+}
+
+// ReplayDeadLetters processes the dead letters again (POST /api/admin/dead-letters/replay).
+func (c *Client) ReplayDeadLetters(ctx context.Context) (*ReplayResult, error) {
+	result, err := doData[ReplayResult](ctx, c, http.MethodPost, "/api/admin/dead-letters/replay", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Statuses of Report.
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+// Report is an abuse report about a file, made with ReportFile.
+type Report struct {
+	ID          string    `json:"id"`
+	FileID      string    `json:"fileId"`
+	FolderID    string    `json:"folderId"`
+	FileName    string    `json:"fileName"` // At the time of the report
+	Reason      string    `json:"reason"`
+	Contact     string    `json:"contact,omitempty"`
+	ReporterUID string    `json:"reporterUid,omitempty"` // Set when the reporter was signed in
+	Status      string    `json:"status"`                // ReportOpen or ReportResolved
+	CreatedAt   time.Time `json:"createdAt"`
+	ResolvedAt  time.Time `json:"resolvedAt,omitempty"`
+	ResolvedBy  string    `json:"resolvedBy,omitempty"`
+	Resolution  string    `json:"resolution,omitempty"`
+}
+
+// Pending is what awaits moderation, oldest first.
+type Pending struct {
+	Reports []Report `json:"reports"` // Open abuse reports
+	Uploads []File   `json:"uploads"` // Uploads to folders requiring approval
+}
+
+// Pending returns the open abuse reports and the uploads awaiting approval (GET
+// /api/admin/pending).
+func (c *Client) Pending(ctx context.Context) (*Pending, error) {
+	pending, err := doData[Pending](ctx, c, http.MethodGet, "/api/admin/pending", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+// ApproveUploads publishes up to 100 uploads awaiting approval (POST /api/admin/uploads/approve)
+// and returns them, with the IDs of those that were missing or not pending. Rejected uploads are
+// deleted with DeleteFiles.
+func (c *Client) ApproveUploads(ctx context.Context, ids []string) ([]File, []string, error) {
+	result, err := doData[struct {
+		Approved []File   `json:"approved"`
+		Skipped  []string `json:"skipped"`
+	}](ctx, c, http.MethodPost, "/api/admin/uploads/approve", map[string][]string{"ids": ids})
+	return result.Approved, result.Skipped, err
+}
+
+// ResolveReport closes an abuse report, with an optional note such as "removed" (POST
+// /api/admin/reports/{id}/resolve).
+func (c *Client) ResolveReport(ctx context.Context, reportID, resolution string) error {
+	return c.Do(ctx, http.MethodPost, "/api/admin/reports/"+url.PathEscape(reportID)+"/resolve", map[string]string{"resolution": resolution}, nil)
+}
+
+// Tombstone is what remains of a file taken down with TakeDownFile.
+type Tombstone struct {
+	FileID      string    `json:"fileId"`
+	FolderID    string    `json:"folderId"`
+	Name        string    `json:"name"`
+	Reason      string    `json:"reason"`
+	Actor       string    `json:"actor,omitempty"` // UID of the moderator
+	TakenDownAt time.Time `json:"takenDownAt"`
+}
+
+// TakeDownFile deletes a file for a legal or abuse reason and keeps a tombstone of it (POST
+// /api/admin/files/{id}/takedown).
+func (c *Client) TakeDownFile(ctx context.Context, fileID, reason string) (*Tombstone, error) {
+	tombstone, err := doData[Tombstone](ctx, c, http.MethodPost, "/api/admin/files/"+url.PathEscape(fileID)+"/takedown", map[string]string{"reason": reason})
+	if err != nil {
+		return nil, err
+	}
+	return &tombstone, nil
+}
+
+// SetFileLegalHold places a file under legal hold, which keeps it from being deleted, or clears
+// the hold, and returns the file (PUT and DELETE /api/admin/files/{id}/legal-hold). Admins only.
+func (c *Client) SetFileLegalHold(ctx context.Context, fileID string, hold bool) (*File, error) {
+	file, err := doData[File](ctx, c, legalHoldMethod(hold), "/api/admin/files/"+url.PathEscape(fileID)+"/legal-hold", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// SetFolderLegalHold places a folder and its files under legal hold, or clears the hold, and
+// returns the folder (PUT and DELETE /api/admin/folders/{id}/legal-hold). Admins only.
+func (c *Client) SetFolderLegalHold(ctx context.Context, folderID string, hold bool) (*Folder, error) {
+	folder, err := doData[Folder](ctx, c, legalHoldMethod(hold), "/api/admin/folders/"+url.PathEscape(folderID)+"/legal-hold", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// legalHoldMethod is the method placing (PUT) or clearing (DELETE) a legal hold.
+func legalHoldMethod(hold bool) string {
+	if hold {
+		return http.MethodPut
+	}
+	return http.MethodDelete
+}
+
+// RegenerateResult is the outcome of RegenerateDownloadURLs.
+type RegenerateResult struct {
+	Mode    string            `json:"mode"` // The backend's DOWNLOAD_URL_MODE
+	Scanned int               `json:"scanned"`
+	Updated int               `json:"updated"`
+	Failed  map[string]string `json:"failed"` // Error messages by file ID
+}
+
+// RegenerateDownloadURLs rewrites the download URLs of the files given by ID, of a folder, or of
+// every file if both are empty (POST /api/admin/download-urls).
+func (c *Client) RegenerateDownloadURLs(ctx context.Context, ids []string, folderID string) (*RegenerateResult, error) {
+	payload := map[string]interface{}{}
+	if len(ids) > 0 {
+		payload["ids"] = ids
+	}
+	if folderID != "" {
+		payload["folder_id"] = folderID
+	}
+	result, err := doData[RegenerateResult](ctx, c, http.MethodPost, "/api/admin/download-urls", payload)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Modes of NormalizeOrientation.
+const (
+	OrientationRewrite    = "rewrite"    // Turn the originals upright
+	OrientationThumbnails = "thumbnails" // Only render their thumbnails upright
+)
+
+// ReconcileMimeTypes starts a job correcting the MIME types of the files of a folder, or of all
+// files if folderID is empty, from their content; with dryRun it only counts them (POST
+// /api/admin/mime-types/reconcile).
+func (c *Client) ReconcileMimeTypes(ctx context.Context, folderID string, dryRun bool) (*Job, error) {
+	return c.startJob(ctx, "/api/admin/mime-types/reconcile", map[string]interface{}{"folder_id": folderID, "dry_run": dryRun})
+}
+
+// NormalizeOrientation starts a job dealing with JPEG images stored sideways in a folder, or in
+// the whole gallery if folderID is empty (POST /api/admin/orientation/normalize). mode is
+// OrientationRewrite or OrientationThumbnails.
+func (c *Client) NormalizeOrientation(ctx context.Context, folderID, mode string, dryRun bool) (*Job, error) {
+	return c.startJob(ctx, "/api/admin/orientation/normalize", map[string]interface{}{"folder_id": folderID, "mode": mode, "dry_run": dryRun})
+}
+
+// ReindexSearch starts a job sending every file and profile to the search index (POST
+// /api/admin/search/reindex).
+func (c *Client) ReindexSearch(ctx context.Context) (*Job, error) {
+	return c.startJob(ctx, "/api/admin/search/reindex", nil)
+}
+
+// startJob posts in to path and returns the job of the response.
+func (c *Client) startJob(ctx context.Context, path string, in interface{}) (*Job, error) {
+	job, err := doData[Job](ctx, c, http.MethodPost, path, in)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
@@ -0,0 +1,162 @@
+// Package client is a Go client for the Drive Gallery backend API, so tools that list, upload or
+// watch gallery files don't hand-roll HTTP and multipart requests. The drive-gallery CLI uses it
+// as well.
+//
+//	c := client.New("https://gallery.example.com")
+//	c.Token = os.Getenv("GALLERY_TOKEN") // For endpoints that need a signed-in caller
+//	for file, err := range c.ListFiles(ctx, folderID, nil) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(file.Name)
+//	}
+//
+// Every JSON endpoint has a method; media, thumbnails and embeds are fetched by their URLs, and
+// Client.Do sends any other request.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the backend API at BaseURL. Its fields must not be changed while requests are in
+// flight.
+type Client struct {
+	BaseURL    string       // e.g. "http://localhost:8080"
+	HTTPClient *http.Client // http.DefaultClient if nil
+	Token      string       // Firebase ID token, sent as "Authorization: Bearer <token>" if set
+	Language   string       // Sent as Accept-Language, e.g. "ja" for Japanese error messages
+}
+
+// New returns a Client for the backend at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// maxErrorBody bounds how much of an error response is read into an APIError.
+const maxErrorBody = 64 << 10
+
+// APIError is returned for a response with a status code other than 2xx.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string      // The "error" of a JSON body, or the body as text
+	Header     http.Header // e.g. Retry-After of 429 and 503 responses
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// RetryAfter returns the Retry-After of the response, given in seconds or as an HTTP date, or 0
+// without one.
+func (e *APIError) RetryAfter() time.Duration {
+	v := e.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// newAPIError reads the error message of resp.
+func newAPIError(req *http.Request, resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	message := strings.TrimSpace(string(body))
+	var jsonBody struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &jsonBody) == nil && jsonBody.Error != "" {
+		message = jsonBody.Error
+	}
+	return &APIError{Method: req.Method, Path: req.URL.Path, StatusCode: resp.StatusCode, Message: message, Header: resp.Header}
+}
+
+// Do sends a request to path, e.g. "/api/profiles", with in encoded as its JSON body (none if
+// nil), and decodes the JSON response into out (discarded if nil).
+func (c *Client) Do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req, out)
+}
+
+// doData sends a request like Do and returns the "data" member of the JSON response.
+func doData[T any](ctx context.Context, c *Client, method, path string, in interface{}) (T, error) {
+	var body struct {
+		Data T `json:"data"`
+	}
+	err := c.Do(ctx, method, path, in, &body)
+	return body.Data, err
+}
+
+// newRequest returns a request to path with the client's token and language.
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.Language != "" {
+		req.Header.Set("Accept-Language", c.Language)
+	}
+	return req, nil
+}
+
+// send sends req and decodes the JSON response into out, unless out is nil. Responses other than
+// 2xx are an APIError.
+func (c *Client) send(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newAPIError(req, resp)
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body) // So the connection can be reused
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: failed to decode response: %v", req.Method, req.URL.Path, err)
+	}
+	return nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DriveFile is a file or folder in Google Drive.
+type DriveFile struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	MimeType     string    `json:"mimeType"`
+	ThumbnailURL string    `json:"thumbnailUrl,omitempty"` // Backend proxy of the Drive thumbnail
+	WebViewLink  string    `json:"webViewLink,omitempty"`
+	Parents      []string  `json:"parents,omitempty"`
+	Trashed      bool      `json:"trashed"`
+	Size         int64     `json:"size,omitempty"` // 0 for folders and Google Docs
+	CreatedTime  time.Time `json:"createdTime"`
+}
+
+// DriveFolders returns the Drive folders inside the backend's DRIVE_ROOT_FOLDER_ID (GET
+// /api/drive/folders).
+func (c *Client) DriveFolders(ctx context.Context) ([]DriveFile, error) {
+	return doData[[]DriveFile](ctx, c, http.MethodGet, "/api/drive/folders", nil)
+}
+
+// DriveFiles returns the files of a Drive folder (GET /api/drive/files/{folderId}).
+func (c *Client) DriveFiles(ctx context.Context, folderID string) ([]DriveFile, error) {
+	return doData[[]DriveFile](ctx, c, http.MethodGet, "/api/drive/files/"+url.PathEscape(folderID), nil)
+}
+
+// UploadToDrive streams content into a Drive folder as name and returns the ID and link of the
+// new Drive file (POST /api/drive/upload). mimeType is optional.
+func (c *Client) UploadToDrive(ctx context.Context, folderID, name, mimeType string, content io.Reader) (*DriveFile, error) {
+	// The backend reads the fields before the file, so the form is written as it is sent
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		err := writer.WriteField("drive_folder_id", folderID)
+		if err == nil && mimeType != "" {
+			err = writer.WriteField("mime_type", mimeType)
+		}
+		var part io.Writer
+		if err == nil {
+			part, err = writer.CreateFormFile("file", name)
+		}
+		if err == nil {
+			_, err = io.Copy(part, content)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/drive/upload", pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	var body struct {
+		Data DriveFile `json:"data"`
+	}
+	if err := c.send(req, &body); err != nil {
+		return nil, err
+	}
+	return &body.Data, nil
+}
+
+// DriveZipImport is what ImportDriveZip imports. Only FileID and FolderName are required.
+type DriveZipImport struct {
+	FileID           string `json:"fileId"`     // Drive file of the zip
+	FolderName       string `json:"folderName"` // Logical folder, created if it does not exist
+	License          string `json:"license,omitempty"`
+	PhotographerName string `json:"photographerName,omitempty"`
+}
+
+// ImportDriveZip starts a job uploading the files of a zip stored in Drive to a folder (POST
+// /api/import/drive-zip). Needs the token of an editor or admin.
+func (c *Client) ImportDriveZip(ctx context.Context, in DriveZipImport) (*Job, error) {
+	return c.startJob(ctx, "/api/import/drive-zip", in)
+}
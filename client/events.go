@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Event types sent by the backend when gallery data changes.
+const (
+	EventFileUploaded   = "file_uploaded"   // Data: File
+	EventFilesUploaded  = "files_uploaded"  // Data: a digest of several uploads to a folder
+	EventFileDeleted    = "file_deleted"    // Data: {"id", "storagePath", "folderId"}
+	EventFolderCreated  = "folder_created"  // Data: Folder
+	EventFolderUpdated  = "folder_updated"  // Data: Folder
+	EventProfileUpdated = "profile_updated" // Data: a profile, {"id", "deleted": true}, or {"order": [...]}
+	EventFilterApplied  = "filter_applied"  // Data: EventFilter, after Subscribe set it
+)
+
+// Event is a message received by Subscribe. Decode Data according to Type.
+type Event struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// EventFilter selects the events Subscribe receives. Empty lists don't restrict anything; events
+// of no folder are not affected by FolderIDs.
+type EventFilter struct {
+	EventTypes []string `json:"eventTypes,omitempty"`
+	FolderIDs  []string `json:"folderIds,omitempty"`
+}
+
+// Subscribe connects to the backend's WebSocket (/ws) and calls fn with every event passing
+// filter until ctx is done, the connection fails, or fn returns an error, which is returned.
+// Events sent while the connection is down are not replayed; use GET /api/folders/{id}/changes to
+// catch up after reconnecting.
+func (c *Client) Subscribe(ctx context.Context, filter EventFilter, fn func(Event) error) error {
+	wsURL := c.BaseURL + "/ws"
+	if rest, ok := strings.CutPrefix(wsURL, "http"); ok {
+		wsURL = "ws" + rest // http → ws, https → wss
+	}
+	header := http.Header{}
+	if c.Token != "" {
+		header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.Language != "" {
+		header.Set("Accept-Language", c.Language)
+	}
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("failed to connect to %s: %d %s", wsURL, resp.StatusCode, http.StatusText(resp.StatusCode))
+		}
+		return fmt.Errorf("failed to connect to %s: %v", wsURL, err)
+	}
+	defer conn.Close()
+	// Unblock ReadMessage when ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if len(filter.EventTypes) > 0 || len(filter.FolderIDs) > 0 {
+		msg := struct {
+			Type string `json:"type"`
+			EventFilter
+		}{Type: "filter", EventFilter: filter}
+		if err := conn.WriteJSON(msg); err != nil {
+			return fmt.Errorf("failed to send event filter: %v", err)
+		}
+	}
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to read event: %v", err)
+		}
+		var event Event
+		if err := json.Unmarshal(message, &event); err != nil || event.Type == "" {
+			continue // Untyped broadcast
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// FaceCluster is a group of similar faces found in uploads, for editors to label with the
+// profile of the person.
+type FaceCluster struct {
+	ID            string    `json:"id"`
+	ProfileID     string    `json:"profileId,omitempty"` // Empty until labeled
+	FaceCount     int       `json:"faceCount"`
+	SampleFileIDs []string  `json:"sampleFileIds"` // Files to show who it is
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// FaceClusters returns the face clusters, those with the most faces first, and whether the
+// backend groups faces at all (GET /api/admin/faces/clusters).
+func (c *Client) FaceClusters(ctx context.Context) ([]FaceCluster, bool, error) {
+	var body struct {
+		Data    []FaceCluster `json:"data"`
+		Enabled bool          `json:"enabled"`
+	}
+	if err := c.Do(ctx, http.MethodGet, "/api/admin/faces/clusters", nil, &body); err != nil {
+		return nil, false, err
+	}
+	return body.Data, body.Enabled, nil
+}
+
+// LabelFaceCluster labels a face cluster with a profile, or clears the label if profileID is
+// empty, and returns the cluster (PUT /api/admin/faces/clusters/{id}).
+func (c *Client) LabelFaceCluster(ctx context.Context, clusterID, profileID string) (*FaceCluster, error) {
+	cluster, err := doData[FaceCluster](ctx, c, http.MethodPut, "/api/admin/faces/clusters/"+url.PathEscape(clusterID), map[string]string{"profileId": profileID})
+	if err != nil {
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+// PurgeFaces deletes all face embeddings and clusters and returns how many of each were deleted
+// (DELETE /api/admin/faces). Admins only; the people files were tagged with stay.
+func (c *Client) PurgeFaces(ctx context.Context) (faces, clusters int, err error) {
+	purge, err := doData[struct {
+		Faces    int `json:"faces"`
+		Clusters int `json:"clusters"`
+	}](ctx, c, http.MethodDelete, "/api/admin/faces", nil)
+	return purge.Faces, purge.Clusters, err
+}
@@ -0,0 +1,229 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File is a file of the gallery, with the fields most tools need.
+type File struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	MimeType     string    `json:"mimeType"`
+	StoragePath  string    `json:"storagePath"`
+	DownloadURL  string    `json:"downloadUrl"`
+	FolderID     string    `json:"folderId"`
+	Hash         string    `json:"hash"` // SHA-256 of the content
+	CreatedAt    time.Time `json:"createdAt"`
+	CapturedAt   time.Time `json:"capturedAt"`
+	RelativePath string    `json:"relativePath,omitempty"` // Empty for files stored before it was recorded
+	OriginalPath string    `json:"originalPath,omitempty"`
+	Size         int64     `json:"size,omitempty"`
+	MediaType    string    `json:"mediaType,omitempty"` // "image", "video" or "other"
+	ThumbnailURL string    `json:"thumbnailUrl,omitempty"`
+	MediaURL     string    `json:"mediaUrl,omitempty"` // Backend path of the content, signed for private folders
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	Duration     float64   `json:"duration,omitempty"` // Seconds, for videos
+	License      string    `json:"license,omitempty"`
+	Photographer string    `json:"photographerName,omitempty"`
+	UploaderUID  string    `json:"uploaderUid,omitempty"` // Empty for anonymous uploads
+	Tags         []string  `json:"tags,omitempty"`
+	People       []string  `json:"people,omitempty"` // IDs of the profiles tagged with SetFilePeople
+	CameraModel  string    `json:"cameraModel,omitempty"`
+
+	PendingApproval bool `json:"pendingApproval,omitempty"` // Hidden until ApproveUploads
+	LegalHold       bool `json:"legalHold,omitempty"`       // Cannot be deleted (see SetFileLegalHold)
+}
+
+// Path returns the path of the file inside its folder.
+func (f File) Path() string {
+	if f.RelativePath != "" {
+		return f.RelativePath
+	}
+	return strings.TrimPrefix(f.StoragePath, f.FolderID+"/")
+}
+
+// DefaultPageSize is the number of files ListFiles requests per page.
+const DefaultPageSize = 500
+
+// ListFilesOptions select and order the files of ListFiles. The zero value lists all files,
+// newest first.
+type ListFilesOptions struct {
+	PageSize int    // Files per request; DefaultPageSize if zero
+	Filter   string // "image" or "video"
+	Sort     string // "capturedAt" to order by shoot date instead of upload date
+}
+
+// ListFiles iterates over the files of a folder (GET /api/files/{folderId}), requesting the next
+// page as the previous one is used up. An error ends the iteration.
+func (c *Client) ListFiles(ctx context.Context, folderID string, opts *ListFilesOptions) iter.Seq2[File, error] {
+	if opts == nil {
+		opts = &ListFilesOptions{}
+	}
+	query := url.Values{}
+	if opts.Filter != "" {
+		query.Set("filter", opts.Filter)
+	}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	return c.listPages(ctx, "/api/files/"+url.PathEscape(folderID), query, opts.PageSize)
+}
+
+// ListMyFiles iterates over the files the caller uploaded across all folders, newest first (GET
+// /api/me/files), like ListFiles. Needs a token. pageSize is DefaultPageSize if zero.
+func (c *Client) ListMyFiles(ctx context.Context, pageSize int) iter.Seq2[File, error] {
+	return c.listPages(ctx, "/api/me/files", url.Values{}, pageSize)
+}
+
+// ListProfileFiles iterates over the files tagged with a profile across all folders, newest first
+// (GET /api/profiles/{id}/files), like ListFiles. Needs a token, as it spans private folders.
+func (c *Client) ListProfileFiles(ctx context.Context, profileID string, pageSize int) iter.Seq2[File, error] {
+	return c.listPages(ctx, "/api/profiles/"+url.PathEscape(profileID)+"/files", url.Values{}, pageSize)
+}
+
+// listPages iterates over a file listing paginated with pageSize and pageToken.
+func (c *Client) listPages(ctx context.Context, path string, query url.Values, pageSize int) iter.Seq2[File, error] {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	return func(yield func(File, error) bool) {
+		query := maps.Clone(query)
+		pageToken := ""
+		for {
+			query.Set("pageSize", strconv.Itoa(pageSize))
+			if pageToken != "" {
+				query.Set("pageToken", pageToken)
+			}
+			var body struct {
+				Data          []File `json:"data"`
+				NextPageToken string `json:"nextPageToken"`
+			}
+			if err := c.Do(ctx, http.MethodGet, path+"?"+query.Encode(), nil, &body); err != nil {
+				yield(File{}, err)
+				return
+			}
+			for _, file := range body.Data {
+				if !yield(file, nil) {
+					return
+				}
+			}
+			// The API returns the last document ID even on the final page, so stop on a short page
+			if body.NextPageToken == "" || len(body.Data) < pageSize {
+				return
+			}
+			pageToken = body.NextPageToken
+		}
+	}
+}
+
+// maxExistsHashes is the backend's limit of hashes per /api/files/exists request.
+const maxExistsHashes = 100
+
+// ExistingHashes returns which of the SHA-256 hashes are already stored in the gallery (GET
+// /api/files/exists), in requests of up to 100 hashes.
+func (c *Client) ExistingHashes(ctx context.Context, hashes []string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	for start := 0; start < len(hashes); start += maxExistsHashes {
+		end := min(start+maxExistsHashes, len(hashes))
+		var body struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		path := "/api/files/exists?" + url.Values{"hash": {strings.Join(hashes[start:end], ",")}}.Encode()
+		if err := c.Do(ctx, http.MethodGet, path, nil, &body); err != nil {
+			return nil, err
+		}
+		for hash := range body.Data {
+			existing[hash] = true
+		}
+	}
+	return existing, nil
+}
+
+// maxBatchDelete is the backend's limit of IDs per /api/files/batch-delete request.
+const maxBatchDelete = 100
+
+// DeleteFiles deletes files by ID (POST /api/files/batch-delete), in requests of up to 100 IDs,
+// and returns the reason each file that was not deleted failed, by ID. Needs the token of the
+// uploader of the files, or of an editor or admin.
+func (c *Client) DeleteFiles(ctx context.Context, ids []string) (map[string]string, error) {
+	failed := make(map[string]string)
+	for start := 0; start < len(ids); start += maxBatchDelete {
+		end := min(start+maxBatchDelete, len(ids))
+		var body struct {
+			Data struct {
+				Failed map[string]string `json:"failed"`
+			} `json:"data"`
+		}
+		if err := c.Do(ctx, http.MethodPost, "/api/files/batch-delete", map[string][]string{"ids": ids[start:end]}, &body); err != nil {
+			return nil, err
+		}
+		for id, msg := range body.Data.Failed {
+			failed[id] = msg
+		}
+	}
+	return failed, nil
+}
+
+// DeleteFile deletes one file (DELETE /api/files/{fileId}), with the same permissions as
+// DeleteFiles.
+func (c *Client) DeleteFile(ctx context.Context, fileID string) error {
+	return c.Do(ctx, http.MethodDelete, "/api/files/"+url.PathEscape(fileID), nil, nil)
+}
+
+// ReportFile reports a file for abuse (POST /api/files/{fileId}/report), which anyone may, and
+// returns the ID of the report. contact, optional, lets moderators follow up.
+func (c *Client) ReportFile(ctx context.Context, fileID, reason, contact string) (string, error) {
+	report, err := doData[struct {
+		ID string `json:"id"`
+	}](ctx, c, http.MethodPost, "/api/files/"+url.PathEscape(fileID)+"/report", map[string]string{"reason": reason, "contact": contact})
+	return report.ID, err
+}
+
+// TransformOp is an edit of TransformFile: {Op: "rotate", Degrees: 90, 180 or 270}, {Op: "flip",
+// Direction: "horizontal" or "vertical"} or {Op: "crop", X, Y, Width, Height}.
+type TransformOp struct {
+	Op        string `json:"op"`
+	Degrees   int    `json:"degrees,omitempty"`
+	Direction string `json:"direction,omitempty"`
+	X         int    `json:"x,omitempty"`
+	Y         int    `json:"y,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+}
+
+// TransformFile rotates, flips or crops an image in place, in order, and returns the changed
+// file (POST /api/files/{fileId}/transform). Needs the token of an editor or admin.
+func (c *Client) TransformFile(ctx context.Context, fileID string, ops []TransformOp) (*File, error) {
+	file, err := doData[File](ctx, c, http.MethodPost, "/api/files/"+url.PathEscape(fileID)+"/transform", map[string][]TransformOp{"operations": ops})
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// SetFilePeople tags a file with the profiles of the people in it, replacing the previous tags,
+// and returns the file (PUT /api/files/{fileId}/people). No IDs clear them.
+func (c *Client) SetFilePeople(ctx context.Context, fileID string, profileIDs []string) (*File, error) {
+	if profileIDs == nil {
+		profileIDs = []string{}
+	}
+	file, err := doData[File](ctx, c, http.MethodPut, "/api/files/"+url.PathEscape(fileID)+"/people", map[string][]string{"profileIds": profileIDs})
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// SetFileMimeType corrects the MIME type of a file (POST /api/update/file-metadata), with the
+// same permissions as DeleteFiles.
+func (c *Client) SetFileMimeType(ctx context.Context, fileID, mimeType string) error {
+	return c.Do(ctx, http.MethodPost, "/api/update/file-metadata", map[string]string{"id": fileID, "mime_type": mimeType}, nil)
+}
@@ -0,0 +1,300 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Folder is a logical folder of the gallery.
+type Folder struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	Slug         string            `json:"slug,omitempty"`       // Public link /g/{slug}
+	Names        map[string]string `json:"names,omitempty"`      // Display names by language ("ja", "en")
+	Visibility   string            `json:"visibility,omitempty"` // "private", or empty for public
+	Archived     bool              `json:"archived,omitempty"`   // Read-only
+	PublishAt    *time.Time        `json:"publishAt,omitempty"`  // Hidden from visitors until then
+	ExpireAt     *time.Time        `json:"expireAt,omitempty"`
+	ExpireAction string            `json:"expireAction,omitempty"` // "archive" or "hide", at ExpireAt
+	Bucket       string            `json:"bucket,omitempty"`       // Storage bucket of new uploads; empty for the default
+	LegalHold    bool              `json:"legalHold,omitempty"`    // Cannot be deleted (see SetFolderLegalHold)
+
+	UploadSettings *UploadSettings `json:"uploadSettings,omitempty"` // nil for none
+}
+
+// UploadSettings are the rules for new uploads to a folder.
+type UploadSettings struct {
+	AllowedMediaTypes []string `json:"allowedMediaTypes,omitempty"` // "image", "video", "other"; empty allows any
+	Tags              []string `json:"tags,omitempty"`              // Added to every upload
+	RequireApproval   bool     `json:"requireApproval,omitempty"`   // Uploads stay hidden until ApproveUploads
+	Watermark         bool     `json:"watermark,omitempty"`         // On thumbnails and resized renditions
+}
+
+// ListFolders returns the folders the caller can see (GET /api/folders).
+func (c *Client) ListFolders(ctx context.Context) ([]Folder, error) {
+	var body struct {
+		Data []Folder `json:"data"`
+	}
+	if err := c.Do(ctx, http.MethodGet, "/api/folders", nil, &body); err != nil {
+		return nil, err
+	}
+	return body.Data, nil
+}
+
+// FindFolder returns the folder named name, or nil if there is none.
+func (c *Client) FindFolder(ctx context.Context, name string) (*Folder, error) {
+	folders, err := c.ListFolders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range folders {
+		if folders[i].Name == name {
+			return &folders[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// FolderBySlug returns the folder of a public link /g/{slug}, following the slug it had before
+// a rename, or nil if there is none (GET /api/folders/by-slug/{slug}).
+func (c *Client) FolderBySlug(ctx context.Context, slug string) (*Folder, error) {
+	folder, err := doData[Folder](ctx, c, http.MethodGet, "/api/folders/by-slug/"+url.PathEscape(slug), nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// FolderName returns the display name of a folder in lang ("ja" or "en"), or its default name
+// if lang is empty (GET /api/folder-name/{id}).
+func (c *Client) FolderName(ctx context.Context, folderID, lang string) (string, error) {
+	path := "/api/folder-name/" + url.PathEscape(folderID)
+	if lang != "" {
+		path += "?" + url.Values{"lang": {lang}}.Encode()
+	}
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := c.Do(ctx, http.MethodGet, path, nil, &body); err != nil {
+		return "", err
+	}
+	return body.Name, nil
+}
+
+// MediaStats counts the files of one media type.
+type MediaStats struct {
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"` // Files without a recorded size count as 0
+}
+
+// FolderStats are the file counts and byte totals of a folder.
+type FolderStats struct {
+	FolderID    string                `json:"folderId"`
+	Files       int64                 `json:"files"`
+	Bytes       int64                 `json:"bytes"`
+	ByMediaType map[string]MediaStats `json:"byMediaType"` // By "image", "video", "audio" and "other"
+}
+
+// FolderStats returns the file counts and byte totals of a folder by media type (GET
+// /api/stats/folders/{id}).
+func (c *Client) FolderStats(ctx context.Context, folderID string) (*FolderStats, error) {
+	stats, err := doData[FolderStats](ctx, c, http.MethodGet, "/api/stats/folders/"+url.PathEscape(folderID), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// FolderExists reports whether a folder exists and is visible to the caller, without listing
+// it (GET /api/folders/{id}/exists).
+func (c *Client) FolderExists(ctx context.Context, folderID string) (bool, error) {
+	var body struct {
+		Data struct {
+			Exists bool `json:"exists"`
+		} `json:"data"`
+	}
+	if err := c.Do(ctx, http.MethodGet, "/api/folders/"+url.PathEscape(folderID)+"/exists", nil, &body); err != nil {
+		return false, err
+	}
+	return body.Data.Exists, nil
+}
+
+// CountFiles returns how many files of a folder the caller can list, without reading them (HEAD
+// /api/files/{folderId}/count). filter "image" or "video" counts only those; "" counts all.
+func (c *Client) CountFiles(ctx context.Context, folderID, filter string) (int64, error) {
+	path := "/api/files/" + url.PathEscape(folderID) + "/count"
+	if filter != "" {
+		path += "?" + url.Values{"filter": {filter}}.Encode()
+	}
+	req, err := c.newRequest(ctx, http.MethodHead, path, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, newAPIError(req, resp)
+	}
+	return strconv.ParseInt(resp.Header.Get("X-Total-Count"), 10, 64)
+}
+
+// FolderManifest returns the signed manifest of the files uploaded to a folder since since, or
+// of all its files if since is zero (GET /api/folders/{id}/manifest). Keep it as proof of
+// delivery; POST /api/manifests/verify checks it.
+func (c *Client) FolderManifest(ctx context.Context, folderID string, since time.Time) (json.RawMessage, error) {
+	path := "/api/folders/" + url.PathEscape(folderID) + "/manifest"
+	if !since.IsZero() {
+		path += "?" + url.Values{"since": {since.UTC().Format(time.RFC3339)}}.Encode()
+	}
+	var body struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := c.Do(ctx, http.MethodGet, path, nil, &body); err != nil {
+		return nil, err
+	}
+	return body.Data, nil
+}
+
+// ManifestVerification is the answer to VerifyManifest.
+type ManifestVerification struct {
+	Valid   bool     `json:"valid"`   // The signature is the backend's and covers the manifest as given
+	Changed []string `json:"changed"` // IDs of listed files whose content changed since
+	Missing []string `json:"missing"` // IDs of listed files deleted since
+}
+
+// VerifyManifest checks a manifest returned by FolderManifest (POST /api/manifests/verify):
+// whether its signature is valid, and which of its files changed or were deleted since.
+func (c *Client) VerifyManifest(ctx context.Context, manifest json.RawMessage) (*ManifestVerification, error) {
+	result, err := doData[ManifestVerification](ctx, c, http.MethodPost, "/api/manifests/verify", manifest)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// FolderSnapshot returns the snapshot of a public folder, its metadata and files as one JSON
+// document (GET /api/folders/{id}/snapshot.json).
+func (c *Client) FolderSnapshot(ctx context.Context, folderID string) (json.RawMessage, error) {
+	var snapshot json.RawMessage
+	if err := c.Do(ctx, http.MethodGet, "/api/folders/"+url.PathEscape(folderID)+"/snapshot.json", nil, &snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// FolderCredits returns the credits.txt of a folder: the photographer and license of its files
+// (GET /api/folders/{id}/credits.txt).
+func (c *Client) FolderCredits(ctx context.Context, folderID string) (string, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/folders/"+url.PathEscape(folderID)+"/credits.txt", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", newAPIError(req, resp)
+	}
+	credits, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%s %s: failed to read response: %v", req.Method, req.URL.Path, err)
+	}
+	return string(credits), nil
+}
+
+// FolderRename is the outcome of RenameFolder.
+type FolderRename struct {
+	Folder        Folder `json:"folder"`
+	OldName       string `json:"oldName"`
+	OldSlug       string `json:"oldSlug,omitempty"`       // Set when the slug followed the name
+	DriveFolderID string `json:"driveFolderId,omitempty"` // Drive folder renamed along, if any
+	Job           *Job   `json:"job,omitempty"`           // Moves the files stored by folder name, if any
+}
+
+// RenameFolder renames a folder along with its slug and Drive folder (POST
+// /api/folders/{id}/rename). Needs the token of an editor or admin, like the other folder actions
+// below except DeleteFolder.
+func (c *Client) RenameFolder(ctx context.Context, folderID, name string) (*FolderRename, error) {
+	rename, err := doData[FolderRename](ctx, c, http.MethodPost, "/api/folders/"+url.PathEscape(folderID)+"/rename", map[string]string{"name": name})
+	if err != nil {
+		return nil, err
+	}
+	return &rename, nil
+}
+
+// DuplicateFolder creates a folder named name and copies the files of a folder into it in the
+// background, returning the new folder and the job of the copy (POST
+// /api/folders/{id}/duplicate).
+func (c *Client) DuplicateFolder(ctx context.Context, folderID, name string) (*Folder, *Job, error) {
+	result, err := doData[struct {
+		Folder Folder `json:"folder"`
+		Job    Job    `json:"job"`
+	}](ctx, c, http.MethodPost, "/api/folders/"+url.PathEscape(folderID)+"/duplicate", map[string]string{"name": name})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &result.Folder, &result.Job, nil
+}
+
+// SetFolderArchived makes a folder read-only, or writable again, and returns it (POST
+// /api/folders/{id}/archive and /unarchive).
+func (c *Client) SetFolderArchived(ctx context.Context, folderID string, archived bool) (*Folder, error) {
+	action := "/unarchive"
+	if archived {
+		action = "/archive"
+	}
+	return c.folderAction(ctx, http.MethodPost, folderID, action, nil)
+}
+
+// SetUploadSettings replaces the upload settings of a folder and returns it (PUT
+// /api/folders/{id}/upload-settings). The zero value removes them.
+func (c *Client) SetUploadSettings(ctx context.Context, folderID string, settings UploadSettings) (*Folder, error) {
+	return c.folderAction(ctx, http.MethodPut, folderID, "/upload-settings", settings)
+}
+
+// ScheduleFolder hides a folder from visitors until publishAt, or publishes it now if nil, and
+// returns it (PUT /api/folders/{id}/schedule).
+func (c *Client) ScheduleFolder(ctx context.Context, folderID string, publishAt *time.Time) (*Folder, error) {
+	return c.folderAction(ctx, http.MethodPut, folderID, "/schedule", map[string]*time.Time{"publishAt": publishAt})
+}
+
+// SetFolderExpiry makes a folder expire at expireAt, or never if nil, and returns it (PUT
+// /api/folders/{id}/expiry). action is "archive" (the default) or "hide".
+func (c *Client) SetFolderExpiry(ctx context.Context, folderID string, expireAt *time.Time, action string) (*Folder, error) {
+	return c.folderAction(ctx, http.MethodPut, folderID, "/expiry", map[string]interface{}{"expireAt": expireAt, "action": action})
+}
+
+// folderAction sends in to an action of a folder and returns the folder of the response.
+func (c *Client) folderAction(ctx context.Context, method, folderID, action string, in interface{}) (*Folder, error) {
+	folder, err := doData[Folder](ctx, c, method, "/api/folders/"+url.PathEscape(folderID)+action, in)
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// DeleteFolder deletes a folder with all of its files and returns how many files were deleted
+// (DELETE /api/folders/{id}). Needs the token of an admin; folders under legal hold return 409.
+func (c *Client) DeleteFolder(ctx context.Context, folderID string) (int, error) {
+	result, err := doData[struct {
+		DeletedFiles int `json:"deletedFiles"`
+	}](ctx, c, http.MethodDelete, "/api/folders/"+url.PathEscape(folderID), nil)
+	return result.DeletedFiles, err
+}
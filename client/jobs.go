@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Statuses of Job.
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed" // The operation stopped; Error says why
+)
+
+// Job is the progress of a background job started by another endpoint, e.g. DuplicateFolder.
+type Job struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"` // e.g. "duplicate_folder"
+	Status    string            `json:"status"`
+	Total     int               `json:"total"`
+	Done      int               `json:"done"`
+	Failed    map[string]string `json:"failed,omitempty"` // Error messages of failed items, by ID
+	Error     string            `json:"error,omitempty"`
+	Result    map[string]string `json:"result,omitempty"` // Type-specific, e.g. {"folderId": ...}
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// GetJob returns the progress of a job (GET /api/jobs/{jobId}). Jobs are kept for 7 days.
+func (c *Client) GetJob(ctx context.Context, jobID string) (*Job, error) {
+	job, err := doData[Job](ctx, c, http.MethodGet, "/api/jobs/"+url.PathEscape(jobID), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// WaitForJob polls a job every interval until it is no longer running, or ctx is done, and
+// returns it. progress, if not nil, is called with every poll.
+func (c *Client) WaitForJob(ctx context.Context, jobID string, interval time.Duration, progress func(*Job)) (*Job, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		job, err := c.GetJob(ctx, jobID)
+		if err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress(job)
+		}
+		if job.Status != JobRunning {
+			return job, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
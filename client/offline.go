@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OfflineEntry is a URL for a service worker to precache.
+type OfflineEntry struct {
+	URL      string `json:"url"`      // Backend path, signed for private folders
+	Revision string `json:"revision"` // Changes whenever the content does
+	FileID   string `json:"fileId"`
+	Kind     string `json:"kind"`             // "thumbnail" or "original"
+	Size     int64  `json:"size"`             // Bytes; estimated for thumbnails
+	SHA256   string `json:"sha256,omitempty"` // Of an original's content
+}
+
+// OfflineManifest lists what to precache to make a folder available offline.
+type OfflineManifest struct {
+	FolderID  string         `json:"folderId"`
+	Entries   []OfflineEntry `json:"entries"`
+	Bytes     int64          `json:"bytes"`
+	Budget    int64          `json:"budget"`
+	Skipped   []string       `json:"skipped"`             // IDs of files that did not fit the budget
+	ExpiresAt *time.Time     `json:"expiresAt,omitempty"` // When the signed URLs of a private folder expire
+}
+
+// OfflineManifest returns the thumbnails of a folder's images, then the originals of the files
+// given by ID, within budget bytes, 0 for the backend's default of 200 MiB (GET
+// /api/offline-manifest).
+func (c *Client) OfflineManifest(ctx context.Context, folderID string, budget int64, originals []string) (*OfflineManifest, error) {
+	query := url.Values{"folderId": {folderID}}
+	if budget > 0 {
+		query.Set("budget", strconv.FormatInt(budget, 10))
+	}
+	if len(originals) > 0 {
+		query.Set("originals", strings.Join(originals, ","))
+	}
+	manifest, err := doData[OfflineManifest](ctx, c, http.MethodGet, "/api/offline-manifest?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// SlideshowItem is an image or video of a Slideshow.
+type SlideshowItem struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	MimeType  string   `json:"mimeType"`
+	MediaType string   `json:"mediaType"`        // "image" or "video"
+	Duration  float64  `json:"duration"`         // Seconds to show an image; 0 for videos
+	Preload   []string `json:"preload"`          // URLs of the next items
+	Credit    string   `json:"credit,omitempty"` // Photographer and license
+}
+
+// Slideshow is the playlist of a folder.
+type Slideshow struct {
+	FolderID string          `json:"folderId"`
+	Shuffled bool            `json:"shuffled"`
+	Seed     int64           `json:"seed,omitempty"` // Pass back to get the same order again
+	Items    []SlideshowItem `json:"items"`
+}
+
+// SlideshowOptions change the order and pace of Slideshow. The zero value plays the files in
+// order, 5 seconds per image.
+type SlideshowOptions struct {
+	Shuffle  bool
+	Seed     int64   // Order of a previous shuffle; a new one if zero
+	Duration float64 // Seconds per image, from 1 to 60
+}
+
+// Slideshow returns the images and videos of a folder as a slideshow playlist (GET
+// /api/slideshow).
+func (c *Client) Slideshow(ctx context.Context, folderID string, opts *SlideshowOptions) (*Slideshow, error) {
+	query := url.Values{"folderId": {folderID}}
+	if opts != nil {
+		if opts.Shuffle {
+			query.Set("shuffle", "true")
+		}
+		if opts.Seed != 0 {
+			query.Set("seed", strconv.FormatInt(opts.Seed, 10))
+		}
+		if opts.Duration > 0 {
+			query.Set("duration", strconv.FormatFloat(opts.Duration, 'f', -1, 64))
+		}
+	}
+	slideshow, err := doData[Slideshow](ctx, c, http.MethodGet, "/api/slideshow?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &slideshow, nil
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Profile is the profile of a band member.
+type Profile struct {
+	ID             string `json:"id,omitempty"`
+	Name           string `json:"name"`
+	Bio            string `json:"bio"` // Markdown
+	IconURL        string `json:"icon_url,omitempty"`
+	Archived       bool   `json:"archived"` // A former member, hidden from the lineup
+	Order          int    `json:"order"`    // Position in the lineup (see ReorderProfiles); 0 comes last
+	Deleted        bool   `json:"deleted,omitempty"`
+	HideFromPublic bool   `json:"hide_from_public"` // Files tagged with the profile are hidden from visitors
+}
+
+// Modes of DeleteProfile, for what becomes of the references to a profile.
+const (
+	ProfileDeleteAnonymize = "anonymize"
+	ProfileDeleteTombstone = "tombstone"
+)
+
+// ListProfiles returns the profiles in lineup order, with the archived ones if includeArchived
+// (GET /api/profiles).
+func (c *Client) ListProfiles(ctx context.Context, includeArchived bool) ([]Profile, error) {
+	path := "/api/profiles"
+	if includeArchived {
+		path += "?includeArchived=true"
+	}
+	return doData[[]Profile](ctx, c, http.MethodGet, path, nil)
+}
+
+// GetProfile returns a profile (GET /api/profiles/{id}).
+func (c *Client) GetProfile(ctx context.Context, profileID string) (*Profile, error) {
+	var profile Profile
+	if err := c.Do(ctx, http.MethodGet, "/api/profiles/"+url.PathEscape(profileID), nil, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// CreateProfile creates a profile and returns it with its ID (POST /api/profiles). It needs the
+// manage_profiles permission (see Me), as do UpdateProfile, DeleteProfile, ReorderProfiles and
+// UploadIcon.
+func (c *Client) CreateProfile(ctx context.Context, profile Profile) (*Profile, error) {
+	var created Profile
+	if err := c.Do(ctx, http.MethodPost, "/api/profiles", profile, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateProfile replaces a profile with profile (PUT /api/profiles/{id}).
+func (c *Client) UpdateProfile(ctx context.Context, profileID string, profile Profile) error {
+	return c.Do(ctx, http.MethodPut, "/api/profiles/"+url.PathEscape(profileID), profile, nil)
+}
+
+// DeleteProfile deletes a profile (DELETE /api/profiles/{id}). mode is ProfileDeleteAnonymize,
+// ProfileDeleteTombstone or "" for the backend's default; see ProfileReferences.
+func (c *Client) DeleteProfile(ctx context.Context, profileID, mode string) error {
+	path := "/api/profiles/" + url.PathEscape(profileID)
+	if mode != "" {
+		path += "?" + url.Values{"mode": {mode}}.Encode()
+	}
+	return c.Do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// ReorderProfiles sets the lineup order; profiles not listed follow the others (PUT
+// /api/profiles/reorder).
+func (c *Client) ReorderProfiles(ctx context.Context, ids []string) error {
+	return c.Do(ctx, http.MethodPut, "/api/profiles/reorder", map[string][]string{"ids": ids}, nil)
+}
+
+// ProfileReferences counts what refers to a profile, to check before deleting it.
+type ProfileReferences struct {
+	ProfileID   string `json:"profileId"`
+	Changes     int    `json:"changes"`               // Entries of the change log naming the profile
+	IconObjects int    `json:"iconObjects"`           // Uploaded icons
+	Files       int    `json:"files"`                 // Files tagged with the profile
+	SearchIndex string `json:"searchIndex,omitempty"` // Search service that may hold a copy
+}
+
+// ProfileReferences returns what refers to a profile (GET /api/profiles/{id}/references).
+func (c *Client) ProfileReferences(ctx context.Context, profileID string) (*ProfileReferences, error) {
+	refs, err := doData[ProfileReferences](ctx, c, http.MethodGet, "/api/profiles/"+url.PathEscape(profileID)+"/references", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &refs, nil
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// BuildInfo is the build metadata of the backend.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+	Module    string `json:"module"`
+	Modified  bool   `json:"modified,omitempty"` // Built from a dirty working tree
+}
+
+// Version returns the build metadata of the backend (GET /api/version).
+func (c *Client) Version(ctx context.Context) (*BuildInfo, error) {
+	var info BuildInfo
+	if err := c.Do(ctx, http.MethodGet, "/api/version", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Health is whether the backend can reach Firestore and Storage.
+type Health struct {
+	Ready               bool      `json:"ready"`
+	LastCheck           time.Time `json:"lastCheck"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastError           string    `json:"lastError,omitempty"`
+	Reinitializations   int       `json:"reinitializations"`
+}
+
+// Health returns the readiness of the backend (GET /readyz), also while it answers 503.
+func (c *Client) Health(ctx context.Context) (*Health, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/readyz", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil, newAPIError(req, resp)
+	}
+	var body struct {
+		Data Health `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("%s %s: failed to decode response: %v", req.Method, req.URL.Path, err)
+	}
+	return &body.Data, nil
+}
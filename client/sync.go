@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Change is an entry of the change log of the gallery or a folder.
+type Change struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"` // "added", "removed" or "edited"
+	FolderID  string    `json:"folderId,omitempty"`
+	FileID    string    `json:"fileId,omitempty"`    // Empty for changes of the folder itself
+	ProfileID string    `json:"profileId,omitempty"` // For changes of profiles
+	Name      string    `json:"name,omitempty"`      // File name, or folder name for changes of the folder
+	Fields    []string  `json:"fields,omitempty"`    // Fields an edit changed
+	Actor     string    `json:"actor,omitempty"`     // UID of the signed-in user who made it
+	Reason    string    `json:"reason,omitempty"`    // Why a file was taken down
+	Cursor    string    `json:"cursor"`              // Pass as since to list the changes after this one
+	At        time.Time `json:"at"`
+}
+
+// ChangePage is a page of the change log of a folder.
+type ChangePage struct {
+	Changes []Change `json:"changes"`
+	Cursor  string   `json:"cursor"`  // Of the last change, or the since given; pass it as since next time
+	HasMore bool     `json:"hasMore"` // More changes follow the page
+}
+
+// FolderChanges returns the changes of a folder after the cursor since, oldest first, or its
+// latest changes if since is empty (GET /api/folders/{id}/changes). limit bounds the page; 0 for
+// the backend's default of 100.
+func (c *Client) FolderChanges(ctx context.Context, folderID, since string, limit int) (*ChangePage, error) {
+	page, err := doData[ChangePage](ctx, c, http.MethodGet, "/api/folders/"+url.PathEscape(folderID)+"/changes?"+cursorQuery(since, limit), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// SyncRemoved are the IDs of what a sync removed, or hid from the caller.
+type SyncRemoved struct {
+	Folders  []string `json:"folders"`
+	Files    []string `json:"files"`
+	Profiles []string `json:"profiles"`
+}
+
+// SyncDelta is the answer to Sync: the changes after a cursor, with the current state of what
+// they touched.
+type SyncDelta struct {
+	Changes  []Change    `json:"changes"`
+	Folders  []Folder    `json:"folders"`
+	Files    []File      `json:"files"`
+	Profiles []Profile   `json:"profiles"`
+	Removed  SyncRemoved `json:"removed"`
+	Cursor   string      `json:"cursor"`  // Pass as since next time
+	HasMore  bool        `json:"hasMore"` // Call again with Cursor for the rest
+}
+
+// Sync returns the changes of all folders, files and profiles after the cursor since, for
+// clients keeping an offline copy (GET /api/sync). With since empty it returns the current cursor
+// only. limit bounds the changes; 0 for the backend's default of 500.
+func (c *Client) Sync(ctx context.Context, since string, limit int) (*SyncDelta, error) {
+	delta, err := doData[SyncDelta](ctx, c, http.MethodGet, "/api/sync?"+cursorQuery(since, limit), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &delta, nil
+}
+
+// cursorQuery encodes the since and limit parameters of a change listing.
+func cursorQuery(since string, limit int) string {
+	query := url.Values{}
+	if since != "" {
+		query.Set("since", since)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	return query.Encode()
+}
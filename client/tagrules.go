@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// TagRule tags uploads, or moves them to another folder, by file name, MIME type or camera model.
+// The patterns are globs, e.g. "*.mov", "video/*" or "*iPhone*".
+type TagRule struct {
+	Name        string   `json:"name"`
+	NamePattern string   `json:"namePattern,omitempty"` // Matched against the file name without directories
+	MimeType    string   `json:"mimeType,omitempty"`
+	CameraModel string   `json:"cameraModel,omitempty"` // EXIF camera model of JPEG images
+	Tags        []string `json:"tags,omitempty"`
+	MoveTo      string   `json:"moveTo,omitempty"` // Folder name; the first matching rule with one wins
+}
+
+// TagRuleInput is a sample file for EvaluateTagRules.
+type TagRuleInput struct {
+	Name        string `json:"name"`
+	MimeType    string `json:"mimeType"`
+	CameraModel string `json:"cameraModel,omitempty"`
+}
+
+// TagRuleEvaluation is what the tag rules would do to a file.
+type TagRuleEvaluation struct {
+	FileID string       `json:"fileId,omitempty"` // For stored files
+	File   TagRuleInput `json:"file"`
+	Rules  []string     `json:"rules"` // Names of the matching rules, in order
+	Tags   []string     `json:"tags,omitempty"`
+	MoveTo string       `json:"moveTo,omitempty"`
+}
+
+// TagRules returns the gallery's tag rules (GET /api/admin/tag-rules).
+func (c *Client) TagRules(ctx context.Context) ([]TagRule, error) {
+	return doData[[]TagRule](ctx, c, http.MethodGet, "/api/admin/tag-rules", nil)
+}
+
+// SetTagRules replaces the gallery's tag rules and returns them (PUT /api/admin/tag-rules). No
+// rules remove them all.
+func (c *Client) SetTagRules(ctx context.Context, rules []TagRule) ([]TagRule, error) {
+	if rules == nil {
+		rules = []TagRule{}
+	}
+	return doData[[]TagRule](ctx, c, http.MethodPut, "/api/admin/tag-rules", map[string][]TagRule{"rules": rules})
+}
+
+// EvaluateTagRules returns what tag rules would do to sample files and stored ones given by ID,
+// without changing anything (POST /api/admin/tag-rules/evaluate). rules are tried instead of the
+// saved ones unless nil.
+func (c *Client) EvaluateTagRules(ctx context.Context, rules []TagRule, files []TagRuleInput, fileIDs []string) ([]TagRuleEvaluation, error) {
+	payload := map[string]interface{}{"files": files, "fileIds": fileIDs}
+	if rules != nil {
+		payload["rules"] = rules
+	}
+	return doData[[]TagRuleEvaluation](ctx, c, http.MethodPost, "/api/admin/tag-rules/evaluate", payload)
+}
@@ -0,0 +1,227 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strings"
+	"time"
+)
+
+// UploadFile describes a file to upload with Upload. Only FolderName and RelativePath are
+// required.
+type UploadFile struct {
+	FolderName   string // Logical folder, created if it does not exist
+	RelativePath string // Path inside the folder, e.g. "day1/IMG_0001.jpg"
+	MimeType     string // Detected by the backend from the content if empty
+	Size         int64  // Bytes of the content, sent as Content-Length; -1 if unknown
+
+	// Checksums of the content, verified by the backend, which rejects content corrupted in
+	// transit with 422: the hex SHA-256, and the base64 big-endian CRC32C (Castagnoli).
+	SHA256 string
+	CRC32C string
+
+	ModifiedAt       time.Time // Fallback shoot date (capturedAt)
+	OriginalPath     string    // Path on the uploading machine
+	License          string    // e.g. "CC-BY"
+	PhotographerName string
+}
+
+// UploadResult is the outcome of Upload.
+type UploadResult struct {
+	File         File `json:"data"`
+	Deduplicated bool `json:"deduplicated"` // The content was already stored, so that file was returned
+}
+
+// Upload uploads content as multipart form data (POST /api/upload/file). The content is streamed
+// rather than buffered, with a Content-Length unless f.Size is -1, so large videos don't have to
+// fit in memory; wrap it to report progress or limit the rate. Failed uploads can be retried with
+// a fresh reader.
+func (c *Client) Upload(ctx context.Context, f UploadFile, content io.Reader) (*UploadResult, error) {
+	// The fields and the file part header are written up front and the closing boundary after the
+	// content, so the body length is known without reading the content
+	var head bytes.Buffer
+	writer := multipart.NewWriter(&head)
+	fields := [][2]string{
+		{"folder_name", f.FolderName},
+		{"relative_path", f.RelativePath},
+		{"mime_type", f.MimeType},
+		{"sha256", f.SHA256},
+		{"crc32c", f.CRC32C},
+		{"original_path", f.OriginalPath},
+		{"license", f.License},
+		{"photographer_name", f.PhotographerName},
+	}
+	if !f.ModifiedAt.IsZero() {
+		fields = append(fields, [2]string{"modified_at", f.ModifiedAt.UTC().Format(time.RFC3339)})
+	}
+	for _, field := range fields {
+		if field[1] == "" {
+			continue
+		}
+		if err := writer.WriteField(field[0], field[1]); err != nil {
+			return nil, fmt.Errorf("failed to write form field %s: %v", field[0], err)
+		}
+	}
+	if _, err := writer.CreateFormFile("file", path.Base(f.RelativePath)); err != nil {
+		return nil, fmt.Errorf("failed to create form file: %v", err)
+	}
+	headLen := head.Len()
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close multipart writer: %v", err)
+	}
+	tail := bytes.NewReader(bytes.Clone(head.Bytes()[headLen:]))
+	head.Truncate(headLen)
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/upload/file", io.MultiReader(&head, content, tail))
+	if err != nil {
+		return nil, err
+	}
+	if f.Size >= 0 {
+		req.ContentLength = int64(headLen) + f.Size + int64(tail.Len())
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	var result UploadResult
+	if err := c.send(req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UploadCheckFile is a file to check with CheckUploads.
+type UploadCheckFile struct {
+	Hash         string `json:"hash"` // Hex SHA-256 of the content
+	Size         int64  `json:"size"`
+	RelativePath string `json:"relativePath,omitempty"` // Path inside the target folder, optional
+}
+
+// Statuses of UploadCheckResult.
+const (
+	UploadStored    = "stored"    // Already at the same path of the target folder
+	UploadDuplicate = "duplicate" // Stored elsewhere; uploading it returns that file
+	UploadMissing   = "missing"   // To upload
+)
+
+// UploadCheckResult tells whether an UploadCheckFile is already stored.
+type UploadCheckResult struct {
+	UploadCheckFile
+	Status string `json:"status"`         // UploadStored, UploadDuplicate or UploadMissing
+	File   *File  `json:"file,omitempty"` // The stored file, unless the status is UploadMissing
+}
+
+// UploadCheck is the answer to CheckUploads, in the order the files were given.
+type UploadCheck struct {
+	Results      []UploadCheckResult `json:"results"`
+	Stored       int                 `json:"stored"`
+	Missing      int                 `json:"missing"`
+	MissingBytes int64               `json:"missingBytes"`
+}
+
+// CheckUploads tells which files are already stored before any of their bytes are sent (POST
+// /api/upload/check), at most 500 at a time. folderName, optional, tells files already at the
+// same path of that folder apart from content stored elsewhere.
+func (c *Client) CheckUploads(ctx context.Context, folderName string, files []UploadCheckFile) (*UploadCheck, error) {
+	var body struct {
+		Data UploadCheck `json:"data"`
+	}
+	payload := map[string]interface{}{"folder_name": folderName, "files": files}
+	if err := c.Do(ctx, http.MethodPost, "/api/upload/check", payload, &body); err != nil {
+		return nil, err
+	}
+	return &body.Data, nil
+}
+
+// WarmThumbnails asks the backend to render the thumbnails of the new images of a folder in the
+// background, so the first visitor doesn't wait for them (POST /api/admin/thumbnails/warm).
+func (c *Client) WarmThumbnails(ctx context.Context, folderName string) error {
+	return c.Do(ctx, http.MethodPost, "/api/admin/thumbnails/warm", map[string]string{"folder_name": folderName}, nil)
+}
+
+// DirectUploadFile is a file to upload straight to Storage with SignedUploadURLs and
+// FinalizeUploads, so its bytes bypass the backend.
+type DirectUploadFile struct {
+	RelativePath     string    `json:"relative_path"`
+	MimeType         string    `json:"mime_type"`
+	Hash             string    `json:"hash"` // Hex SHA-256 of the content
+	Size             int64     `json:"size"`
+	ModifiedAt       time.Time `json:"modified_at"` // Fallback shoot date
+	OriginalPath     string    `json:"original_path,omitempty"`
+	License          string    `json:"license,omitempty"`
+	PhotographerName string    `json:"photographer_name,omitempty"`
+}
+
+// SignedUpload is where to PUT the content of a DirectUploadFile.
+type SignedUpload struct {
+	RelativePath string    `json:"relativePath"`
+	StoragePath  string    `json:"storagePath"`
+	UploadURL    string    `json:"uploadUrl,omitempty"` // Empty if the content is already stored
+	Exists       bool      `json:"exists"`
+	ExpiresAt    time.Time `json:"expiresAt,omitempty"`
+	Error        string    `json:"error,omitempty"` // Why no URL was issued, e.g. the path holds another file
+}
+
+// SignedUploadURLs returns signed PUT URLs for up to 100 files of a folder, in the order given
+// (POST /api/upload/signed-urls). Save their metadata with FinalizeUploads once uploaded.
+func (c *Client) SignedUploadURLs(ctx context.Context, folderName string, files []DirectUploadFile) ([]SignedUpload, error) {
+	payload := map[string]interface{}{"folder_name": folderName, "files": files}
+	return doData[[]SignedUpload](ctx, c, http.MethodPost, "/api/upload/signed-urls", payload)
+}
+
+// FinalizeUploads saves the metadata of files uploaded with SignedUploadURLs (POST
+// /api/upload/finalize). It returns the saved files and the reason each other file failed, both
+// by relative path.
+func (c *Client) FinalizeUploads(ctx context.Context, folderName string, files []DirectUploadFile) (map[string]File, map[string]string, error) {
+	var body struct {
+		Data   map[string]File   `json:"data"`
+		Failed map[string]string `json:"failed"`
+	}
+	payload := map[string]interface{}{"folder_name": folderName, "files": files}
+	if err := c.Do(ctx, http.MethodPost, "/api/upload/finalize", payload, &body); err != nil {
+		return nil, nil, err
+	}
+	return body.Data, body.Failed, nil
+}
+
+// quoteEscaper escapes the file name of a form part, as mime/multipart does.
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"", "\r", "%0D", "\n", "%0A")
+
+// UploadIcon uploads the icon image of a profile and returns its URL (POST /api/upload/icon),
+// which UpdateProfile then stores as IconURL. Icons are at most 10 MiB.
+func (c *Client) UploadIcon(ctx context.Context, profileID, fileName, mimeType string, content io.Reader) (string, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if err := writer.WriteField("profile_id", profileID); err != nil {
+		return "", fmt.Errorf("failed to write form field profile_id: %v", err)
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="icon"; filename="%s"`, quoteEscaper.Replace(fileName)))
+	header.Set("Content-Type", mimeType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to create form file: %v", err)
+	}
+	if _, err := io.Copy(part, content); err != nil {
+		return "", fmt.Errorf("failed to read icon: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to close multipart writer: %v", err)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/upload/icon", &buf)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	var body struct {
+		IconURL string `json:"icon_url"`
+	}
+	if err := c.send(req, &body); err != nil {
+		return "", err
+	}
+	return body.IconURL, nil
+}
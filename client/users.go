@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Roles a user can be given with SetUserRole, least privileged first.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// Me is who the caller is to the backend.
+type Me struct {
+	SignedIn    bool     `json:"signedIn"`
+	UID         string   `json:"uid,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	Role        string   `json:"role"`        // RoleViewer for anonymous callers
+	Permissions []string `json:"permissions"` // e.g. "upload", "edit", "manage_profiles"
+}
+
+// Can reports whether the caller has a permission.
+func (m *Me) Can(permission string) bool {
+	return slices.Contains(m.Permissions, permission)
+}
+
+// Me returns the caller's role and permissions, those of a viewer without a token (GET
+// /api/me).
+func (c *Client) Me(ctx context.Context) (*Me, error) {
+	me, err := doData[Me](ctx, c, http.MethodGet, "/api/me", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &me, nil
+}
+
+// User is the stored role of a user.
+type User struct {
+	UID       string    `json:"uid"`
+	Email     string    `json:"email,omitempty"`
+	Role      string    `json:"role"`
+	UpdatedBy string    `json:"updatedBy,omitempty"` // UID of the admin
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Users returns the users with a stored role (GET /api/admin/users). Admins only.
+func (c *Client) Users(ctx context.Context) ([]User, error) {
+	return doData[[]User](ctx, c, http.MethodGet, "/api/admin/users", nil)
+}
+
+// SetUserRole gives a user a role, which takes precedence over the role claim of their ID token
+// (PUT /api/admin/users/{uid}). Admins only, and not for themselves.
+func (c *Client) SetUserRole(ctx context.Context, uid, role string) (*User, error) {
+	user, err := doData[User](ctx, c, http.MethodPut, "/api/admin/users/"+url.PathEscape(uid), map[string]string{"role": role})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Webhook is an outgoing webhook, which receives gallery events as signed POST requests.
+type Webhook struct {
+	ID           string           `json:"id"`
+	URL          string           `json:"url"`
+	Secret       string           `json:"secret,omitempty"`     // Signs the deliveries; only returned by CreateWebhook
+	EventTypes   []string         `json:"eventTypes,omitempty"` // Empty for all
+	Description  string           `json:"description,omitempty"`
+	CreatedBy    string           `json:"createdBy,omitempty"`
+	CreatedAt    time.Time        `json:"createdAt"`
+	LastDelivery *WebhookDelivery `json:"lastDelivery,omitempty"`
+}
+
+// WebhookDelivery is the outcome of delivering an event to a webhook, after its retries.
+type WebhookDelivery struct {
+	ID         string    `json:"id"`
+	EventType  string    `json:"eventType"`
+	StatusCode int       `json:"statusCode,omitempty"` // 0 if the webhook did not answer
+	Error      string    `json:"error,omitempty"`      // Empty if delivered
+	Attempts   int       `json:"attempts"`
+	At         time.Time `json:"at"`
+}
+
+// Webhooks returns the outgoing webhooks (GET /api/admin/webhooks). Admins only, like the other
+// webhook methods.
+func (c *Client) Webhooks(ctx context.Context) ([]Webhook, error) {
+	return doData[[]Webhook](ctx, c, http.MethodGet, "/api/admin/webhooks", nil)
+}
+
+// CreateWebhook registers a webhook for the event types, or all of them if none, and returns it
+// with its signing secret, which is not shown again (POST /api/admin/webhooks).
+func (c *Client) CreateWebhook(ctx context.Context, webhookURL string, eventTypes []string, description string) (*Webhook, error) {
+	payload := map[string]interface{}{"url": webhookURL, "eventTypes": eventTypes, "description": description}
+	webhook, err := doData[Webhook](ctx, c, http.MethodPost, "/api/admin/webhooks", payload)
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// DeleteWebhook unregisters a webhook (DELETE /api/admin/webhooks/{id}).
+func (c *Client) DeleteWebhook(ctx context.Context, webhookID string) error {
+	return c.Do(ctx, http.MethodDelete, "/api/admin/webhooks/"+url.PathEscape(webhookID), nil, nil)
+}
+
+// TestWebhook sends a webhook a ping and returns the outcome (POST
+// /api/admin/webhooks/{id}/test).
+func (c *Client) TestWebhook(ctx context.Context, webhookID string) (*WebhookDelivery, error) {
+	delivery, err := doData[WebhookDelivery](ctx, c, http.MethodPost, "/api/admin/webhooks/"+url.PathEscape(webhookID)+"/test", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"drive-gallery/backend"
+
+	"github.com/spf13/cobra"
+)
+
+// newBackfillCmd builds the "backfill" command group, with one subcommand per field.
+func newBackfillCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backfill",
+		Short: "既存ファイルのメタデータに不足しているフィールドを補完する (Firestoreを直接更新)",
+	}
+	cmd.AddCommand(
+		newBackfillFieldCmd("hash", backend.BackfillHash, "コンテンツのSHA-256ハッシュ (未設定のファイルはStorageからダウンロードして計算)"),
+		newBackfillFieldCmd("media-type", backend.BackfillMediaType, "mimeType から導出する mediaType (image / video / other)"),
+		newBackfillFieldCmd("name-search", backend.BackfillNameSearch, "ファイル名検索用の小文字のキー"),
+		newBackfillFieldCmd("size", backend.BackfillSize, "Storageのオブジェクト属性から取得するファイルサイズ"),
+		newBackfillFieldCmd("color", backend.BackfillColor, "画像の代表色 (Storageからダウンロードして計算)"),
+		newBackfillFieldCmd("phash", backend.BackfillPHash, "類似画像の検出に使う画像の知覚ハッシュ (Storageからダウンロードして計算)"),
+		newBackfillFieldCmd("dimensions", backend.BackfillDimensions, "画像の幅と高さ (画像のヘッダーから取得)"),
+		newBackfillFieldCmd("duration", backend.BackfillDuration, "MP4 / MOV 動画の長さ (動画のヘッダーを範囲読み込みして取得)"),
+	)
+	return cmd
+}
+
+func newBackfillFieldCmd(use, field, description string) *cobra.Command {
+	var checkpointPath string
+	var pageSize int
+	var restart, dryRun bool
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: description + "を補完する",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if pageSize < 1 {
+				return fmt.Errorf("--page-size は1以上で指定してください")
+			}
+			if checkpointPath == "" {
+				checkpointPath = fmt.Sprintf("backfill-%s.checkpoint.json", use)
+			}
+			progress := &backend.BackfillProgress{Field: field}
+			if !restart && !dryRun {
+				saved, err := loadCheckpoint(checkpointPath, field)
+				if err != nil {
+					return err
+				}
+				if saved != nil {
+					progress = saved
+					fmt.Printf("チェックポイント %s から再開します。(処理済み: %d, 最後のID: %s)\n", checkpointPath, progress.Scanned, progress.LastDocID)
+				}
+			}
+
+			ctx := context.Background()
+			if err := initBackend(ctx); err != nil {
+				return err
+			}
+
+			started := time.Now()
+			err := backend.BackfillFiles(ctx, progress, pageSize, dryRun, func(p *backend.BackfillProgress) error {
+				fmt.Fprintf(os.Stderr, "処理済み: %d, 更新: %d, 失敗: %d (経過時間: %s)\n", p.Scanned, p.Updated, len(p.Failed), time.Since(started).Round(time.Second))
+				if dryRun {
+					return nil
+				}
+				return saveCheckpoint(checkpointPath, p)
+			})
+			if err != nil {
+				return fmt.Errorf("%v (チェックポイント %s から再開できます)", err, checkpointPath)
+			}
+
+			for id, msg := range progress.Failed {
+				fmt.Printf("  %s: %s\n", id, msg)
+			}
+			if dryRun {
+				fmt.Printf("ドライラン: %d 件中 %d 件が更新対象です。\n", progress.Scanned, progress.Updated)
+			} else {
+				fmt.Printf("%s の補完が完了しました。処理済み: %d, 更新: %d, 失敗: %d\n", field, progress.Scanned, progress.Updated, len(progress.Failed))
+				if err := os.Remove(checkpointPath); err != nil && !os.IsNotExist(err) {
+					fmt.Printf("警告: チェックポイントの削除に失敗しました: %v\n", err)
+				}
+			}
+			if len(progress.Failed) > 0 {
+				return fmt.Errorf("%d 件のファイルを補完できませんでした", len(progress.Failed))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&checkpointPath, "checkpoint", "", "進捗を保存するチェックポイントファイル (既定: backfill-"+use+".checkpoint.json)")
+	cmd.Flags().IntVar(&pageSize, "page-size", 300, "1回に読み込み・更新するドキュメント数")
+	cmd.Flags().BoolVar(&restart, "restart", false, "チェックポイントを無視して最初から処理する")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "実際には更新せず、更新対象の件数のみ表示する")
+	return cmd
+}
+
+// loadCheckpoint reads a saved backfill progress. It returns nil if the file does not exist.
+func loadCheckpoint(path, field string) (*backend.BackfillProgress, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("チェックポイントの読み込みに失敗しました: %v", err)
+	}
+	var progress backend.BackfillProgress
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil, fmt.Errorf("チェックポイントの解析に失敗しました %s: %v", path, err)
+	}
+	if progress.Field != field {
+		return nil, fmt.Errorf("チェックポイント %s は %s 用です (--restart で最初から処理できます)", path, progress.Field)
+	}
+	return &progress, nil
+}
+
+// saveCheckpoint writes progress atomically, so an interrupted write never corrupts the checkpoint.
+func saveCheckpoint(path string, progress *backend.BackfillProgress) error {
+	data, err := json.MarshalIndent(progress, "", "  ")
+	if err != nil {
+		return fmt.Errorf("チェックポイントの作成に失敗しました: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("チェックポイントの書き込みに失敗しました: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("チェックポイントの書き込みに失敗しました: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"drive-gallery/backend"
+
+	"github.com/spf13/cobra"
+)
+
+func newBackupCmd() *cobra.Command {
+	var out string
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "フォルダ・ファイル・プロフィールのメタデータをJSONにバックアップする (Storageのオブジェクトは含まない)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			if err := initBackend(ctx); err != nil {
+				return err
+			}
+			b, err := backend.CreateBackup(ctx)
+			if err != nil {
+				return err
+			}
+
+			var w io.Writer = os.Stdout
+			if out != "-" {
+				f, err := os.Create(out)
+				if err != nil {
+					return fmt.Errorf("バックアップファイルの作成に失敗しました: %v", err)
+				}
+				defer f.Close()
+				w = f
+			}
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(b); err != nil {
+				return fmt.Errorf("バックアップの書き込みに失敗しました: %v", err)
+			}
+			fmt.Fprintf(os.Stderr, "バックアップしました: フォルダ %d, ファイル %d, プロフィール %d\n", len(b.Folders), len(b.Files), len(b.Profiles))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&out, "out", "-", "出力先のファイル (- で標準出力)")
+	return cmd
+}
+
+func newRestoreCmd() *cobra.Command {
+	var in string
+	var overwrite bool
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "backup で作成したJSONからメタデータを復元する",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if in == "" {
+				return fmt.Errorf("--in は必須です")
+			}
+			data, err := os.ReadFile(in)
+			if err != nil {
+				return fmt.Errorf("バックアップファイルの読み込みに失敗しました: %v", err)
+			}
+			var b backend.Backup
+			if err := json.Unmarshal(data, &b); err != nil {
+				return fmt.Errorf("バックアップファイルの解析に失敗しました: %v", err)
+			}
+
+			ctx := context.Background()
+			if err := initBackend(ctx); err != nil {
+				return err
+			}
+			stats, err := backend.RestoreBackup(ctx, &b, overwrite)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("復元しました: フォルダ %d, ファイル %d, プロフィール %d (既存のためスキップ: %d)\n", stats.Folders, stats.Files, stats.Profiles, stats.Skipped)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&in, "in", "", "backup で作成したJSONファイル")
+	cmd.Flags().BoolVar(&overwrite, "overwrite", false, "既存のドキュメントも上書きする")
+	return cmd
+}
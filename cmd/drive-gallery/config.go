@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config is the YAML configuration file passed with --config. Flags given on the
+// command line take precedence over the values in the file.
+type config struct {
+	APIURL         string          `yaml:"api_url"`
+	ProjectID      string          `yaml:"project_id"`
+	ServiceAccount string          `yaml:"service_account"`
+	StorageBucket  string          `yaml:"storage_bucket"`
+	Concurrency    int             `yaml:"concurrency"`
+	Retries        *int            `yaml:"retries"` // Pointer so that an explicit 0 disables retries
+	LimitRate      string          `yaml:"limit_rate"`
+	Include        []string        `yaml:"include"`
+	Exclude        []string        `yaml:"exclude"`
+	Credentials    credentials     `yaml:"credentials"`
+	Folders        []folderMapping `yaml:"folders"`
+}
+
+// credentials authenticate the CLI against the backend API.
+type credentials struct {
+	Token    string `yaml:"token"`     // Sent as "Authorization: Bearer <token>"
+	TokenEnv string `yaml:"token_env"` // Name of an environment variable holding the token
+}
+
+// folderMapping uploads a local directory to a logical folder.
+type folderMapping struct {
+	Path       string `yaml:"path"`
+	FolderName string `yaml:"folder_name"`
+}
+
+// loadConfig reads a config file. Relative folder and service account paths are resolved against the file's directory.
+func loadConfig(name string) (*config, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("設定ファイルの読み込みに失敗しました: %v", err)
+	}
+	var cfg config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("設定ファイルの解析に失敗しました %s: %v", name, err)
+	}
+	if cfg.ServiceAccount != "" && !filepath.IsAbs(cfg.ServiceAccount) {
+		cfg.ServiceAccount = filepath.Join(filepath.Dir(name), cfg.ServiceAccount)
+	}
+	for i, m := range cfg.Folders {
+		if m.Path == "" || m.FolderName == "" {
+			return nil, fmt.Errorf("設定ファイルの folders[%d] には path と folder_name が必要です", i)
+		}
+		if !filepath.IsAbs(m.Path) {
+			cfg.Folders[i].Path = filepath.Join(filepath.Dir(name), m.Path)
+		}
+	}
+	return &cfg, nil
+}
+
+// token returns the configured API token, preferring the environment variable if set.
+func (c credentials) token() string {
+	if c.TokenEnv != "" {
+		if v := os.Getenv(c.TokenEnv); v != "" {
+			return v
+		}
+	}
+	return c.Token
+}
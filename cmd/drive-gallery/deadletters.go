@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"drive-gallery/backend"
+
+	"github.com/spf13/cobra"
+)
+
+// newDeadLettersCmd builds the "dead-letters" command group.
+func newDeadLettersCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dead-letters",
+		Short: "処理に失敗したDriveのWebhook通知を一覧・再処理する",
+	}
+	cmd.AddCommand(newDeadLettersListCmd(), newDeadLettersReplayCmd())
+	return cmd
+}
+
+func newDeadLettersListCmd() *cobra.Command {
+	var jsonOutput bool
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "処理に失敗した通知の一覧を表示する (Firestoreを直接参照)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			if err := initBackend(ctx); err != nil {
+				return err
+			}
+			letters, err := backend.ListDeadLetters(ctx)
+			if err != nil {
+				return err
+			}
+			if jsonOutput {
+				return printJSON(letters)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tFILE ID\tSTATE\tATTEMPTS\tLAST ATTEMPT\tERROR")
+			for _, l := range letters {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", l.ID, l.FileID, l.ResourceState, l.Attempts, l.LastAttemptAt.Local().Format(time.DateTime), l.Error)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "JSONで出力する")
+	return cmd
+}
+
+func newDeadLettersReplayCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "処理に失敗した通知を再処理する (WebSocketクライアントへ配信するためAPI経由で実行)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := apiClient().ReplayDeadLetters(context.Background())
+			if err != nil {
+				return fmt.Errorf("通知の再処理に失敗しました: %v", err)
+			}
+			for id, msg := range result.Failed {
+				fmt.Fprintf(os.Stderr, "  %s: %s\n", id, msg)
+			}
+			fmt.Printf("再処理: %d, 失敗: %d\n", len(result.Replayed), len(result.Failed))
+			if len(result.Failed) > 0 {
+				return fmt.Errorf("%d 件の通知を再処理できませんでした", len(result.Failed))
+			}
+			return nil
+		},
+	}
+	return cmd
+}
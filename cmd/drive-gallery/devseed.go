@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"drive-gallery/client"
+
+	"github.com/spf13/cobra"
+)
+
+// seedParts are the instruments given to generated profiles.
+var seedParts = []string{"ボーカル", "ギター", "ベース", "ドラム", "キーボード", "サックス"}
+
+func newDevSeedCmd() *cobra.Command {
+	var numFolders, filesPerFolder, numProfiles int
+	var prefix string
+	var seed int64
+	cmd := &cobra.Command{
+		Use:   "devseed",
+		Short: "開発用の合成データ (フォルダ・画像・プロフィール) をAPI経由で作成する",
+		Long: "小さなPNG画像を含む論理フォルダとプロフィールをバックエンドAPI経由で作成し、本番データなしでフロントエンド開発や負荷試験ができるようにします。\n" +
+			"エミュレータ (FIRESTORE_EMULATOR_HOST, STORAGE_EMULATOR_HOST) か開発用プロジェクトに接続したバックエンドに対して実行してください。",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if numFolders < 0 || filesPerFolder < 0 || numProfiles < 0 {
+				return fmt.Errorf("--folders, --files, --profiles は0以上で指定してください")
+			}
+
+			rng := rand.New(rand.NewSource(seed))
+			api := apiClient()
+			api.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+			ctx := context.Background()
+
+			fmt.Printf("シードデータを作成します: フォルダ %d 件 × ファイル %d 件, プロフィール %d 件 (シード: %d)\n", numFolders, filesPerFolder, numProfiles, seed)
+
+			for i := 1; i <= numFolders; i++ {
+				folderName := fmt.Sprintf("%s-第%d回", prefix, i)
+				for j := 1; j <= filesPerFolder; j++ {
+					content, err := generateSeedImage(rng, seed, i, j)
+					if err != nil {
+						return fmt.Errorf("画像の生成に失敗しました: %v", err)
+					}
+					relativePath := fmt.Sprintf("IMG_%04d.png", j)
+					file := client.UploadFile{FolderName: folderName, RelativePath: relativePath, MimeType: "image/png", Size: int64(len(content))}
+					if _, err := api.Upload(ctx, file, bytes.NewReader(content)); err != nil {
+						return fmt.Errorf("%s/%s のアップロードに失敗しました: %v", folderName, relativePath, err)
+					}
+				}
+				fmt.Printf("フォルダ作成完了: %s (%d ファイル)\n", folderName, filesPerFolder)
+			}
+
+			for i := 1; i <= numProfiles; i++ {
+				profile := client.Profile{
+					Name: fmt.Sprintf("%s-メンバー%d", prefix, i),
+					Bio:  fmt.Sprintf("**テスト用プロフィール** #%d\n\n担当: %s", i, seedParts[rng.Intn(len(seedParts))]),
+				}
+				if _, err := api.CreateProfile(ctx, profile); err != nil {
+					return fmt.Errorf("プロフィール %s の作成に失敗しました: %v", profile.Name, err)
+				}
+			}
+			if numProfiles > 0 {
+				fmt.Printf("プロフィール作成完了: %d 件\n", numProfiles)
+			}
+
+			fmt.Println("シードデータの作成が完了しました。")
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntVar(&numFolders, "folders", 3, "作成する論理フォルダ数")
+	flags.IntVar(&filesPerFolder, "files", 20, "フォルダごとに作成するファイル数")
+	flags.IntVar(&numProfiles, "profiles", 5, "作成するプロフィール数")
+	flags.StringVar(&prefix, "prefix", "seed", "生成するフォルダ名・プロフィール名の接頭辞")
+	flags.Int64Var(&seed, "seed", time.Now().UnixNano(), "画像生成用の乱数シード (同じ値で同じデータを再生成)")
+	return cmd
+}
+
+// generateSeedImage renders a tiny PNG. The folder and file indexes are encoded in the
+// first pixels so every generated file has a distinct hash and is not deduplicated.
+func generateSeedImage(rng *rand.Rand, seed int64, folderIndex, fileIndex int) ([]byte, error) {
+	const size = 16
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	base := color.RGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: 255}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, color.RGBA{R: base.R + uint8(x*4), G: base.G + uint8(y*4), B: base.B, A: 255})
+		}
+	}
+	marker := []int{int(seed), int(seed >> 8), folderIndex, folderIndex >> 8, fileIndex, fileIndex >> 8}
+	for x, v := range marker {
+		img.Set(x, 0, color.RGBA{R: uint8(v), G: uint8(v), B: uint8(v), A: 255})
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
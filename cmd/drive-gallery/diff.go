@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"drive-gallery/client"
+)
+
+// diffAction classifies a file when comparing a local directory with a remote folder.
+type diffAction string
+
+const (
+	actionUpload     diffAction = "+" // Not stored remotely yet
+	actionChanged    diffAction = "~" // Stored under the same path with different content
+	actionSkip       diffAction = "=" // Content already stored
+	actionRemoteOnly diffAction = "-" // Only present in the remote folder
+)
+
+// diffEntry is one line of a dry-run diff.
+type diffEntry struct {
+	action       diffAction
+	relativePath string
+	job          *uploadJob   // Set for local files
+	remote       *client.File // Set when a remote file exists at the same path
+	hash         string
+}
+
+// folderID resolves a logical folder name to its ID. It returns "" if the folder does not exist yet.
+func (u *uploader) folderID(name string) (string, error) {
+	folder, err := u.api.FindFolder(context.Background(), name)
+	if err != nil {
+		return "", fmt.Errorf("フォルダ一覧の取得に失敗しました: %v", err)
+	}
+	if folder == nil {
+		return "", nil
+	}
+	return folder.ID, nil
+}
+
+// remoteFiles lists every file in the remote folder, following pagination.
+func (u *uploader) remoteFiles(folderID string) ([]client.File, error) {
+	var files []client.File
+	for file, err := range u.api.ListFiles(context.Background(), folderID, nil) {
+		if err != nil {
+			return nil, fmt.Errorf("ファイル一覧の取得に失敗しました: %v", err)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// existingHashes returns the subset of hashes already stored in the gallery.
+func (u *uploader) existingHashes(hashes []string) (map[string]bool, error) {
+	existing, err := u.api.ExistingHashes(context.Background(), hashes)
+	if err != nil {
+		return nil, fmt.Errorf("既存ファイルの確認に失敗しました: %v", err)
+	}
+	return existing, nil
+}
+
+// hashFile returns the hex-encoded SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// computeDiff compares local jobs with the remote folder without transferring any file content.
+func (u *uploader) computeDiff(jobs []uploadJob) ([]diffEntry, error) {
+	folderID, err := u.folderID(u.folderName)
+	if err != nil {
+		return nil, err
+	}
+	remoteByPath := make(map[string]client.File)
+	if folderID != "" {
+		remote, err := u.remoteFiles(folderID)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range remote {
+			remoteByPath[f.Path()] = f
+		}
+	}
+
+	entries := make([]diffEntry, 0, len(jobs))
+	hashes := make([]string, 0, len(jobs))
+	for i := range jobs {
+		hash, err := hashFile(jobs[i].path)
+		if err != nil {
+			return nil, fmt.Errorf("ハッシュの計算に失敗しました %s: %v", jobs[i].path, err)
+		}
+		entries = append(entries, diffEntry{job: &jobs[i], relativePath: jobs[i].relativePath, hash: hash})
+		hashes = append(hashes, hash)
+	}
+	existing, err := u.existingHashes(hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	localPaths := make(map[string]bool, len(entries))
+	for i := range entries {
+		e := &entries[i]
+		localPaths[e.relativePath] = true
+		remote, atSamePath := remoteByPath[e.relativePath]
+		if atSamePath {
+			e.remote = &remote
+		}
+		switch {
+		case existing[e.hash]:
+			e.action = actionSkip
+		case atSamePath:
+			e.action = actionChanged
+		default:
+			e.action = actionUpload
+		}
+	}
+	for path, remote := range remoteByPath {
+		// Excluded files are out of scope, so they are neither listed nor deleted by --sync
+		if !localPaths[path] && u.filter.match(path) {
+			remote := remote
+			entries = append(entries, diffEntry{action: actionRemoteOnly, relativePath: path, remote: &remote, hash: remote.Hash})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].relativePath < entries[j].relativePath })
+	return entries, nil
+}
+
+// printDiff writes the diff in a unified-diff-like format followed by totals,
+// or as one JSON object per line in jsonMode.
+func printDiff(entries []diffEntry, jsonMode bool) {
+	if jsonMode {
+		names := map[diffAction]string{actionUpload: "upload", actionChanged: "changed", actionSkip: "skip", actionRemoteOnly: "remote-only"}
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			enc.Encode(map[string]string{"type": "diff", "action": names[e.action], "relativePath": e.relativePath, "hash": e.hash})
+		}
+		return
+	}
+
+	labels := map[diffAction]string{
+		actionUpload:     "新規アップロード",
+		actionChanged:    "内容の変更あり",
+		actionSkip:       "アップロード済みのためスキップ",
+		actionRemoteOnly: "リモートのみに存在",
+	}
+	counts := make(map[diffAction]int)
+	for _, e := range entries {
+		counts[e.action]++
+		fmt.Printf("%s %s  (%s)\n", e.action, e.relativePath, labels[e.action])
+	}
+	fmt.Println()
+	fmt.Printf("アップロード: %d (新規 %d, 変更 %d), スキップ: %d, リモートのみ: %d\n",
+		counts[actionUpload]+counts[actionChanged], counts[actionUpload], counts[actionChanged], counts[actionSkip], counts[actionRemoteOnly])
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"drive-gallery/client"
+)
+
+// directBatchSize matches the backend's per-request limit for signed-urls and finalize.
+const directBatchSize = 100
+
+// directJob is a file on its way through the direct upload pipeline.
+type directJob struct {
+	job      uploadJob
+	file     client.DirectUploadFile
+	signed   client.SignedUpload
+	started  time.Time
+	attempts int
+}
+
+// runDirect uploads jobs straight to Storage with signed URLs, so file bytes bypass the backend:
+// URLs are requested in batches, files are PUT by a bounded worker pool, and the metadata of
+// finished uploads is saved in batches through /api/upload/finalize.
+func runDirect(u *uploader, jobs []uploadJob, concurrency int) *runSummary {
+	summary := &runSummary{total: len(jobs), started: time.Now()}
+	u.report.start(jobs)
+
+	done := func(d *directJob, status fileStatus, err error) {
+		switch status {
+		case statusFailed:
+			summary.addFailed(d.job, err)
+		case statusSkipped:
+			summary.addSkipped()
+		default:
+			summary.addUploaded()
+		}
+		u.report.fileDone(fileResult{job: d.job, status: status, hash: d.file.Hash, attempts: d.attempts, duration: time.Since(d.started), err: err})
+	}
+
+	// Stage 1: hash files and request signed URLs in batches
+	toUpload := make(chan *directJob, concurrency)
+	go func() {
+		defer close(toUpload)
+		for start := 0; start < len(jobs); start += directBatchSize {
+			end := start + directBatchSize
+			if end > len(jobs) {
+				end = len(jobs)
+			}
+			var batch []*directJob
+			for _, job := range jobs[start:end] {
+				d := &directJob{job: job, started: time.Now()}
+				hash, mimeType, err := hashAndSniff(job.path)
+				if err != nil {
+					done(d, statusFailed, fmt.Errorf("ファイルの読み込みに失敗しました %s: %v", job.path, err))
+					continue
+				}
+				d.file = client.DirectUploadFile{RelativePath: job.relativePath, MimeType: mimeType, Hash: hash, Size: job.size, ModifiedAt: job.modTime.UTC()}
+				if absPath, err := filepath.Abs(job.path); err == nil {
+					d.file.OriginalPath = absPath
+				}
+				batch = append(batch, d)
+			}
+			if len(batch) == 0 {
+				continue
+			}
+			if err := u.sign(batch); err != nil {
+				for _, d := range batch {
+					done(d, statusFailed, err)
+				}
+				continue
+			}
+			for _, d := range batch {
+				if d.signed.Exists {
+					done(d, statusSkipped, nil)
+					continue
+				}
+				if d.signed.Error != "" {
+					done(d, statusFailed, fmt.Errorf("署名付きURLが発行されませんでした: %s", d.signed.Error))
+					continue
+				}
+				toUpload <- d
+			}
+		}
+	}()
+
+	// Stage 2: PUT file bytes to Storage
+	toFinalize := make(chan *directJob, directBatchSize)
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for d := range toUpload {
+				var err error
+				d.attempts, err = u.withRetry(d.job, func() error { return u.put(d) })
+				if err != nil {
+					done(d, statusFailed, err)
+					continue
+				}
+				u.throttle.succeeded()
+				toFinalize <- d
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(toFinalize)
+	}()
+
+	// Stage 3: save metadata of uploaded files in batches, flushing at least every few seconds
+	var batch []*directJob
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		failed, err := u.finalize(batch)
+		for _, d := range batch {
+			switch {
+			case err != nil:
+				done(d, statusFailed, err)
+			case failed[d.file.RelativePath] != "":
+				done(d, statusFailed, fmt.Errorf("メタデータの保存に失敗しました: %s", failed[d.file.RelativePath]))
+			default:
+				done(d, statusUploaded, nil)
+			}
+		}
+		batch = nil
+	}
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case d, ok := <-toFinalize:
+			if !ok {
+				flush()
+				return summary
+			}
+			batch = append(batch, d)
+			if len(batch) == directBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// sign requests signed upload URLs for batch.
+func (u *uploader) sign(batch []*directJob) error {
+	files := make([]client.DirectUploadFile, len(batch))
+	for i, d := range batch {
+		files[i] = d.file
+	}
+	var signed []client.SignedUpload
+	_, err := u.withRetry(batch[0].job, func() error {
+		var err error
+		signed, err = u.api.SignedUploadURLs(context.Background(), u.folderName, files)
+		return apiError(err)
+	})
+	if err != nil {
+		return fmt.Errorf("署名付きURLの取得に失敗しました: %v", err)
+	}
+	if len(signed) != len(batch) {
+		return fmt.Errorf("署名付きURLの数が一致しません (要求: %d, 応答: %d)", len(batch), len(signed))
+	}
+	for i := range batch {
+		batch[i].signed = signed[i]
+	}
+	return nil
+}
+
+// put uploads the file of d to its signed URL, renewing the URL if it is about to expire.
+func (u *uploader) put(d *directJob) error {
+	if time.Until(d.signed.ExpiresAt) < time.Minute {
+		if err := u.sign([]*directJob{d}); err != nil {
+			return err
+		}
+		if d.signed.Exists {
+			return nil // Stored by someone else in the meantime; finalize links the existing file
+		}
+		if d.signed.Error != "" {
+			return &permanentError{fmt.Errorf("署名付きURLが発行されませんでした: %s", d.signed.Error)}
+		}
+	}
+
+	f, err := os.Open(d.job.path)
+	if err != nil {
+		return &permanentError{fmt.Errorf("ファイルを開けませんでした %s: %v", d.job.path, err)}
+	}
+	defer f.Close()
+
+	progress := &progressReader{r: u.throttle.reader(f), report: func(read int64) { u.report.fileProgress(d.job, read) }}
+	req, err := http.NewRequest("PUT", d.signed.UploadURL, progress)
+	if err != nil {
+		return &permanentError{fmt.Errorf("リクエスト作成に失敗しました: %v", err)}
+	}
+	req.ContentLength = d.job.size
+	req.Header.Set("Content-Type", d.file.MimeType) // Must match the Content-Type the URL was signed for
+
+	// Signed URLs carry their own authorization, so the API client's bearer token is not sent
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTPリクエストの送信に失敗しました: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return statusError(resp, fmt.Errorf("Storageへのアップロードに失敗しました。ステータス: %d, レスポンス: %s", resp.StatusCode, string(respBody)))
+	}
+	return nil
+}
+
+// finalize saves metadata for uploaded files and returns per-file errors keyed by relative path.
+func (u *uploader) finalize(batch []*directJob) (map[string]string, error) {
+	files := make([]client.DirectUploadFile, len(batch))
+	for i, d := range batch {
+		files[i] = d.file
+	}
+	var failed map[string]string
+	_, err := u.withRetry(batch[0].job, func() error {
+		var err error
+		_, failed, err = u.api.FinalizeUploads(context.Background(), u.folderName, files)
+		return apiError(err)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("メタデータの保存に失敗しました: %v", err)
+	}
+	return failed, nil
+}
+
+// hashAndSniff streams the file at path once, returning its SHA-256 and detected MIME type.
+func hashAndSniff(path string) (string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512) // http.DetectContentType considers at most 512 bytes
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", "", err
+	}
+	hasher := sha256.New()
+	hasher.Write(head[:n])
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), http.DetectContentType(head[:n]), nil
+}
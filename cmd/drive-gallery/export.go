@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"drive-gallery/backend"
+
+	"github.com/spf13/cobra"
+)
+
+func newExportStaticCmd() *cobra.Command {
+	var out, bucket, prefix, lang string
+	var originals bool
+	cmd := &cobra.Command{
+		Use:   "export-static",
+		Short: "ギャラリー全体 (フォルダ・サムネイル・メタデータJSON) を静的サイトとして書き出す",
+		Long: "全フォルダのページ、サムネイル、メタデータJSONを --out のディレクトリまたは --bucket のCloud Storageバケットに書き出します。\n" +
+			"バックエンドなしでGitHub Pagesなどに置いて閲覧・保存できます。",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (out == "") == (bucket == "") {
+				return fmt.Errorf("--out と --bucket のどちらか一方を指定してください")
+			}
+			ctx := context.Background()
+			if err := initBackend(ctx); err != nil {
+				return err
+			}
+			var w backend.StaticSiteWriter = backend.DirSiteWriter{Dir: out}
+			dest := out
+			if bucket != "" {
+				handle, err := backend.StorageClient.Bucket(bucket)
+				if err != nil {
+					return fmt.Errorf("バケット %s を開けませんでした: %v", bucket, err)
+				}
+				w = backend.BucketSiteWriter{Bucket: handle, Prefix: prefix}
+				dest = "gs://" + bucket + "/" + prefix
+			}
+
+			opts := backend.StaticExportOptions{Lang: lang, Originals: originals}
+			stats, err := backend.ExportStaticSite(ctx, w, opts, func(folder backend.FolderMetadata, s backend.StaticExportStats) {
+				fmt.Printf("[%d] %s を書き出しました (累計 ファイル %d, サムネイル %d)\n", s.Folders, folder.Name, s.Files, s.Thumbnails)
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s に書き出しました: フォルダ %d, ファイル %d, サムネイル %d, 元ファイル %d, 失敗 %d\n",
+				dest, stats.Folders, stats.Files, stats.Thumbnails, stats.Originals, stats.Failed)
+			if stats.Failed > 0 {
+				return fmt.Errorf("%d 件のファイルを書き出せませんでした", stats.Failed)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&out, "out", "", "書き出し先のディレクトリ")
+	cmd.Flags().StringVar(&bucket, "bucket", "", "書き出し先のCloud Storageバケット名")
+	cmd.Flags().StringVar(&prefix, "prefix", "", "--bucket 内の書き出し先のパス")
+	cmd.Flags().StringVar(&lang, "lang", "", "フォルダ名の言語 (ja, en; 省略時は既定の名前)")
+	cmd.Flags().BoolVar(&originals, "originals", true, "元ファイルもコピーする (false にするとダウンロードURLにリンクする)")
+	return cmd
+}
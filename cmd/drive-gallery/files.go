@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"drive-gallery/client"
+
+	"github.com/spf13/cobra"
+)
+
+// newFilesCmd builds the "files" command group.
+func newFilesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "files",
+		Short: "論理フォルダ内のファイルを一覧・削除し、ダウンロードURLを再生成する",
+	}
+	cmd.AddCommand(newFilesListCmd(), newFilesDeleteCmd(), newFilesRegenerateURLsCmd())
+	return cmd
+}
+
+func newFilesListCmd() *cobra.Command {
+	var folderName string
+	var jsonOutput bool
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "論理フォルダ内のファイルの一覧を表示する",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if folderName == "" {
+				return fmt.Errorf("--folder-name は必須です")
+			}
+			u := &uploader{api: apiClient()}
+			folderID, err := u.folderID(folderName)
+			if err != nil {
+				return err
+			}
+			if folderID == "" {
+				return fmt.Errorf("論理フォルダ '%s' が見つかりません", folderName)
+			}
+			files, err := u.remoteFiles(folderID)
+			if err != nil {
+				return err
+			}
+			if jsonOutput {
+				return printJSON(files)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tPATH\tMIME TYPE\tHASH")
+			for _, f := range files {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%.12s\n", f.ID, f.Path(), f.MimeType, f.Hash)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().StringVar(&folderName, "folder-name", "", "一覧を表示する論理フォルダ名")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "JSONで出力する")
+	return cmd
+}
+
+func newFilesDeleteCmd() *cobra.Command {
+	var assumeYes bool
+	cmd := &cobra.Command{
+		Use:   "delete ID...",
+		Short: "ファイルをIDで指定して削除する (Storageのオブジェクトも削除)",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !assumeYes && !confirm(fmt.Sprintf("%d 件のファイルを削除します。よろしいですか?", len(args))) {
+				return fmt.Errorf("中止しました")
+			}
+			files := make([]client.File, len(args))
+			for i, id := range args {
+				files[i] = client.File{ID: id}
+			}
+			u := &uploader{api: apiClient()}
+			failed, err := u.deleteFiles(files)
+			if err != nil {
+				return err
+			}
+			for id, msg := range failed {
+				fmt.Fprintf(os.Stderr, "  %s: %s\n", id, msg)
+			}
+			fmt.Printf("削除: %d, 失敗: %d\n", len(args)-len(failed), len(failed))
+			if len(failed) > 0 {
+				return fmt.Errorf("%d 件のファイルを削除できませんでした", len(failed))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&assumeYes, "yes", false, "確認を省略する (スクリプト・CI向け)")
+	return cmd
+}
+
+func newFilesRegenerateURLsCmd() *cobra.Command {
+	var folderName string
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "regenerate-urls [ID...]",
+		Short: "ファイルのダウンロードURLを現在の設定 (公開URL・署名付きURL) で再生成する",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			selectors := 0
+			for _, set := range []bool{len(args) > 0, folderName != "", all} {
+				if set {
+					selectors++
+				}
+			}
+			if selectors != 1 {
+				return fmt.Errorf("ファイルID、--folder-name、--all のいずれか1つを指定してください")
+			}
+
+			u := &uploader{api: apiClient()}
+			var folderID string
+			if folderName != "" {
+				var err error
+				if folderID, err = u.folderID(folderName); err != nil {
+					return err
+				}
+				if folderID == "" {
+					return fmt.Errorf("論理フォルダ '%s' が見つかりません", folderName)
+				}
+			}
+
+			result, err := u.api.RegenerateDownloadURLs(context.Background(), args, folderID)
+			if err != nil {
+				return fmt.Errorf("ダウンロードURLの再生成に失敗しました: %v", err)
+			}
+			for id, msg := range result.Failed {
+				fmt.Fprintf(os.Stderr, "  %s: %s\n", id, msg)
+			}
+			fmt.Printf("ダウンロードURLを再生成しました (%s)。対象: %d, 更新: %d, 失敗: %d\n", result.Mode, result.Scanned, result.Updated, len(result.Failed))
+			if len(result.Failed) > 0 {
+				return fmt.Errorf("%d 件のファイルのURLを再生成できませんでした", len(result.Failed))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&folderName, "folder-name", "", "対象の論理フォルダ名")
+	cmd.Flags().BoolVar(&all, "all", false, "すべてのファイルを対象にする")
+	return cmd
+}
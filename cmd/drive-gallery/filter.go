@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFileName is read from the root of the uploaded directory; each line is an exclude pattern.
+const ignoreFileName = ".galleryignore"
+
+// fileFilter decides which files under the upload root are part of an upload.
+//
+// Patterns use path.Match syntax. A pattern containing "/" is matched against the whole
+// relative path; otherwise it is matched against every path element, so "*.tmp" excludes
+// temporary files at any depth and "RAW" excludes everything inside RAW directories.
+// Excludes win over includes; with no include patterns every file is included.
+type fileFilter struct {
+	include []string
+	exclude []string
+}
+
+// newFileFilter builds a filter from the given patterns and the root's .galleryignore, if any.
+func newFileFilter(root string, include, exclude []string) (*fileFilter, error) {
+	f := &fileFilter{include: include, exclude: append([]string{ignoreFileName}, exclude...)}
+	for _, p := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("不正なパターンです %q: %v", p, err)
+		}
+	}
+
+	ignored, err := readIgnoreFile(filepath.Join(root, ignoreFileName))
+	if err != nil {
+		return nil, err
+	}
+	f.exclude = append(f.exclude, ignored...)
+	return f, nil
+}
+
+// readIgnoreFile returns the patterns in an ignore file. Blank lines and lines starting with "#" are skipped.
+func readIgnoreFile(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s の読み込みに失敗しました: %v", ignoreFileName, err)
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		p := strings.TrimSpace(scanner.Text())
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		p = strings.TrimSuffix(strings.TrimPrefix(p, "/"), "/")
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: 不正なパターンです %q: %v", ignoreFileName, line, p, err)
+		}
+		patterns = append(patterns, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s の読み込みに失敗しました: %v", ignoreFileName, err)
+	}
+	return patterns, nil
+}
+
+// match reports whether the file at relativePath ("/"-separated) should be uploaded.
+func (f *fileFilter) match(relativePath string) bool {
+	if f == nil {
+		return true
+	}
+	for _, p := range f.exclude {
+		if matchPattern(p, relativePath) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, p := range f.include {
+		if matchPattern(p, relativePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludesDir reports whether everything below the directory at relativePath is excluded.
+func (f *fileFilter) excludesDir(relativePath string) bool {
+	if f == nil || relativePath == "." {
+		return false
+	}
+	for _, p := range f.exclude {
+		if matchPattern(p, relativePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern matches a single pattern as described on fileFilter.
+func matchPattern(pattern, relativePath string) bool {
+	if strings.Contains(pattern, "/") {
+		// Also match directory prefixes, so "raw/2024" covers "raw/2024/a.jpg"
+		elems := strings.Split(relativePath, "/")
+		for i := 1; i <= len(elems); i++ {
+			if ok, _ := path.Match(pattern, strings.Join(elems[:i], "/")); ok {
+				return true
+			}
+		}
+		return false
+	}
+	for _, elem := range strings.Split(relativePath, "/") {
+		if ok, _ := path.Match(pattern, elem); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"drive-gallery/backend"
+
+	"github.com/spf13/cobra"
+)
+
+// newFoldersCmd builds the "folders" command group.
+func newFoldersCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "folders",
+		Short: "論理フォルダを一覧・名前変更・削除する",
+	}
+	cmd.AddCommand(newFoldersListCmd(), newFoldersRenameCmd(), newFoldersSlugCmd(), newFoldersVisibilityCmd(), newFoldersBucketCmd(), newFoldersDeleteCmd())
+	return cmd
+}
+
+func newFoldersListCmd() *cobra.Command {
+	var jsonOutput bool
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "論理フォルダの一覧を表示する",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			folders, err := apiClient().ListFolders(context.Background())
+			if err != nil {
+				return fmt.Errorf("フォルダ一覧の取得に失敗しました: %v", err)
+			}
+			if jsonOutput {
+				return printJSON(folders)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tNAME\tSLUG\tCREATED")
+			for _, f := range folders {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.ID, f.Name, f.Slug, f.CreatedAt.Local().Format(time.DateTime))
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "JSONで出力する")
+	return cmd
+}
+
+func newFoldersRenameCmd() *cobra.Command {
+	var folderName, newName, lang string
+	cmd := &cobra.Command{
+		Use:   "rename",
+		Short: "論理フォルダの名前を変更する (Firestoreを直接更新)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if folderName == "" || (newName == "" && lang == "") {
+				return fmt.Errorf("--folder-name と --new-name は必須です")
+			}
+			ctx := context.Background()
+			if err := initBackend(ctx); err != nil {
+				return err
+			}
+			folder, err := findFolder(ctx, folderName)
+			if err != nil {
+				return err
+			}
+			if lang != "" {
+				if err := backend.SetFolderLocalizedName(ctx, folder.ID, lang, newName); err != nil {
+					return err
+				}
+				if newName == "" {
+					fmt.Printf("フォルダ '%s' の表示名 (%s) を削除しました。\n", folderName, lang)
+				} else {
+					fmt.Printf("フォルダ '%s' の表示名 (%s) を '%s' に設定しました。\n", folderName, lang, newName)
+				}
+				return nil
+			}
+			rename, err := backend.RenameFolder(ctx, folder.ID, newName)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("フォルダ '%s' の名前を '%s' に変更しました。\n", folderName, newName)
+			if rename.OldSlug != "" {
+				fmt.Printf("スラッグを '%s' から '%s' に変更しました (旧スラッグはリダイレクトされます)。\n", rename.OldSlug, rename.Folder.Slug)
+			}
+			if rename.DriveFolderID != "" {
+				fmt.Printf("Driveフォルダ %s の名前も変更しました。\n", rename.DriveFolderID)
+			}
+			// Objects stored by folder name (STORAGE_PATH_STRATEGY=folder-name) follow the new name
+			failed := 0
+			moved, err := backend.MoveFolderNameObjects(ctx, rename.Folder, func(file backend.FileMetadata, err error) {
+				if err != nil {
+					failed++
+					fmt.Fprintf(os.Stderr, "ファイル %s の移動に失敗しました: %v\n", file.ID, err)
+				}
+			})
+			if moved > 0 || failed > 0 {
+				fmt.Printf("フォルダ名で保存されたファイルを %d 件移動しました (失敗 %d 件)。\n", moved, failed)
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&folderName, "folder-name", "", "変更する論理フォルダ名")
+	cmd.Flags().StringVar(&newName, "new-name", "", "新しい論理フォルダ名")
+	cmd.Flags().StringVar(&lang, "lang", "", "指定した言語 (ja, en) の表示名だけを設定する (--new-name を空にすると削除)")
+	return cmd
+}
+
+func newFoldersSlugCmd() *cobra.Command {
+	var folderName, slug string
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "slug",
+		Short: "論理フォルダの公開URL用スラッグを設定する (Firestoreを直接更新)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if folderName == "" && !all {
+				return fmt.Errorf("--folder-name または --all を指定してください")
+			}
+			ctx := context.Background()
+			if err := initBackend(ctx); err != nil {
+				return err
+			}
+			if all {
+				folders, err := backend.ListFoldersFromFirestore(ctx)
+				if err != nil {
+					return err
+				}
+				updated := 0
+				for _, f := range folders {
+					if f.Slug != "" {
+						continue
+					}
+					newSlug, err := backend.SetFolderSlug(ctx, f.ID, "")
+					if err != nil {
+						return err
+					}
+					fmt.Printf("%s -> %s\n", f.Name, newSlug)
+					updated++
+				}
+				fmt.Printf("%d 件のフォルダにスラッグを設定しました。\n", updated)
+				return nil
+			}
+			folder, err := findFolder(ctx, folderName)
+			if err != nil {
+				return err
+			}
+			newSlug, err := backend.SetFolderSlug(ctx, folder.ID, slug)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("フォルダ '%s' のスラッグを '%s' に設定しました (/g/%s)。\n", folderName, newSlug, newSlug)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&folderName, "folder-name", "", "スラッグを設定する論理フォルダ名")
+	cmd.Flags().StringVar(&slug, "slug", "", "新しいスラッグ (省略するとフォルダ名から生成する)")
+	cmd.Flags().BoolVar(&all, "all", false, "スラッグのない全フォルダにフォルダ名から生成したスラッグを設定する")
+	return cmd
+}
+
+func newFoldersVisibilityCmd() *cobra.Command {
+	var folderName, visibility string
+	cmd := &cobra.Command{
+		Use:   "visibility",
+		Short: "論理フォルダを公開 (public) または非公開 (private) にする (Firestoreを直接更新)",
+		Long: "private にするとファイルの公開ACLを外し、一覧APIは有効期限付きの署名URL (サムネイル・ダウンロード) だけを返します。\n" +
+			"public に戻すと公開ACLを付け直します。",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if folderName == "" || visibility == "" {
+				return fmt.Errorf("--folder-name と --set は必須です")
+			}
+			ctx := context.Background()
+			if err := initBackend(ctx); err != nil {
+				return err
+			}
+			folder, err := findFolder(ctx, folderName)
+			if err != nil {
+				return err
+			}
+			n, err := backend.SetFolderVisibility(ctx, folder.ID, visibility)
+			if err != nil {
+				return fmt.Errorf("%d 件のファイルを処理した時点で失敗しました: %v", n, err)
+			}
+			fmt.Printf("フォルダ '%s' (%d 件のファイル) を %s にしました。\n", folderName, n, visibility)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&folderName, "folder-name", "", "変更する論理フォルダ名")
+	cmd.Flags().StringVar(&visibility, "set", "", "public または private")
+	return cmd
+}
+
+func newFoldersBucketCmd() *cobra.Command {
+	var folderName, bucket string
+	var useDefault bool
+	cmd := &cobra.Command{
+		Use:   "bucket",
+		Short: "論理フォルダの新しいアップロードを保存するバケットを設定する (Firestoreを直接更新)",
+		Long: "既にアップロード済みのファイルは元のバケットに残ります。\n" +
+			"フォルダにバケットがない場合は MEDIA_TYPE_BUCKETS の設定、それもなければデフォルトバケットが使われます。",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if folderName == "" || (bucket == "") == !useDefault {
+				return fmt.Errorf("--folder-name と、--set または --default のどちらか一方は必須です")
+			}
+			ctx := context.Background()
+			if err := initBackend(ctx); err != nil {
+				return err
+			}
+			folder, err := findFolder(ctx, folderName)
+			if err != nil {
+				return err
+			}
+			if err := backend.SetFolderBucket(ctx, folder.ID, bucket); err != nil {
+				return err
+			}
+			if bucket == "" {
+				fmt.Printf("フォルダ '%s' の新しいアップロードをデフォルトのバケットに保存します。\n", folderName)
+			} else {
+				fmt.Printf("フォルダ '%s' の新しいアップロードをバケット '%s' に保存します。\n", folderName, bucket)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&folderName, "folder-name", "", "変更する論理フォルダ名")
+	cmd.Flags().StringVar(&bucket, "set", "", "保存先のバケット名")
+	cmd.Flags().BoolVar(&useDefault, "default", false, "フォルダのバケット設定を外す")
+	return cmd
+}
+
+func newFoldersDeleteCmd() *cobra.Command {
+	var folderName string
+	var assumeYes bool
+	cmd := &cobra.Command{
+		Use:   "delete",
+		Short: "論理フォルダをファイルごと削除する (Firestoreを直接更新)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if folderName == "" {
+				return fmt.Errorf("--folder-name は必須です")
+			}
+			ctx := context.Background()
+			if err := initBackend(ctx); err != nil {
+				return err
+			}
+			folder, err := findFolder(ctx, folderName)
+			if err != nil {
+				return err
+			}
+			files, err := backend.ListAllFilesInFolder(ctx, folder.ID)
+			if err != nil {
+				return err
+			}
+			if !assumeYes && !confirm(fmt.Sprintf("'%s' とその %d 件のファイルを削除します。よろしいですか?", folderName, len(files))) {
+				return fmt.Errorf("中止しました")
+			}
+			deleted, err := backend.DeleteFolder(ctx, folder.ID)
+			if err != nil {
+				return fmt.Errorf("%d 件のファイルを削除した時点で失敗しました: %v", deleted, err)
+			}
+			fmt.Printf("フォルダ '%s' と %d 件のファイルを削除しました。\n", folderName, deleted)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&folderName, "folder-name", "", "削除する論理フォルダ名")
+	cmd.Flags().BoolVar(&assumeYes, "yes", false, "確認を省略する (スクリプト・CI向け)")
+	return cmd
+}
+
+// findFolder looks up a logical folder by name in Firestore and fails if it does not exist.
+func findFolder(ctx context.Context, name string) (*backend.FolderMetadata, error) {
+	folder, err := backend.FindFolderByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil {
+		return nil, fmt.Errorf("論理フォルダ '%s' が見つかりません", name)
+	}
+	return folder, nil
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
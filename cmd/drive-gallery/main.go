@@ -0,0 +1,112 @@
+// Command drive-gallery manages a Drive Gallery deployment from the command line: uploading
+// and syncing folders, fixing and backfilling metadata, managing folders and files, and backing up Firestore.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"drive-gallery/backend"
+	"drive-gallery/client"
+
+	"github.com/spf13/cobra"
+)
+
+// globalOptions are the persistent flags shared by every subcommand.
+type globalOptions struct {
+	configPath     string
+	apiURL         string
+	projectID      string
+	serviceAccount string
+	storageBucket  string
+}
+
+var (
+	global globalOptions
+	cfg    = &config{} // Loaded from --config before any subcommand runs
+)
+
+func main() {
+	root := &cobra.Command{
+		Use:               "drive-gallery",
+		Short:             "Drive Gallery の管理用CLI",
+		SilenceUsage:      true,
+		SilenceErrors:     true,
+		PersistentPreRunE: loadGlobalConfig,
+	}
+	flags := root.PersistentFlags()
+	flags.StringVar(&global.configPath, "config", "", "YAML設定ファイルのパス (api_url, folders, credentials など)")
+	flags.StringVar(&global.apiURL, "api-url", "http://localhost:8080", "バックエンドAPIのベースURL")
+	flags.StringVar(&global.projectID, "project-id", os.Getenv("GCP_PROJECT"), "FirebaseプロジェクトID (Firestoreを直接操作するコマンドで使用)")
+	flags.StringVar(&global.serviceAccount, "service-account", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), "FirebaseサービスアカウントJSONファイルのパス (省略時はApplication Default Credentials)")
+	flags.StringVar(&global.storageBucket, "storage-bucket", os.Getenv("FIREBASE_STORAGE_BUCKET"), "Firebase Storageのバケット名")
+
+	root.AddCommand(
+		newUploadCmd(false),
+		newUploadCmd(true),
+		newMetadataCmd(),
+		newBackfillCmd(),
+		newFoldersCmd(),
+		newFilesCmd(),
+		newDeadLettersCmd(),
+		newBackupCmd(),
+		newRestoreCmd(),
+		newExportStaticCmd(),
+		newDevSeedCmd(),
+	)
+
+	if err := root.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// loadGlobalConfig reads --config. Values from the file apply unless the flag was given explicitly.
+func loadGlobalConfig(cmd *cobra.Command, args []string) error {
+	if global.configPath == "" {
+		return nil
+	}
+	loaded, err := loadConfig(global.configPath)
+	if err != nil {
+		return err
+	}
+	cfg = loaded
+
+	flags := cmd.Flags()
+	if cfg.APIURL != "" && !flags.Changed("api-url") {
+		global.apiURL = cfg.APIURL
+	}
+	if cfg.ProjectID != "" && !flags.Changed("project-id") {
+		global.projectID = cfg.ProjectID
+	}
+	if cfg.ServiceAccount != "" && !flags.Changed("service-account") {
+		global.serviceAccount = cfg.ServiceAccount
+	}
+	if cfg.StorageBucket != "" && !flags.Changed("storage-bucket") {
+		global.storageBucket = cfg.StorageBucket
+	}
+	return nil
+}
+
+// apiClient returns a client for the backend API that sends the configured token.
+func apiClient() *client.Client {
+	c := client.New(global.apiURL)
+	c.Token = cfg.Credentials.token()
+	c.Language = "ja" // The CLI speaks Japanese, so ask the backend for Japanese error messages too
+	return c
+}
+
+// initBackend connects to Firestore and Storage with the Firebase Admin SDK, for commands
+// that need more than the public API offers.
+func initBackend(ctx context.Context) error {
+	if global.projectID == "" || global.storageBucket == "" {
+		return fmt.Errorf("このコマンドには --project-id と --storage-bucket (または GCP_PROJECT / FIREBASE_STORAGE_BUCKET) が必要です")
+	}
+	// backend.InitFirebase reads the bucket from the environment, like the server does
+	os.Setenv("FIREBASE_STORAGE_BUCKET", global.storageBucket)
+	if err := backend.InitFirebase(ctx, global.projectID, global.serviceAccount); err != nil {
+		return fmt.Errorf("Firebaseの初期化に失敗しました: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"drive-gallery/backend"
+
+	"github.com/spf13/cobra"
+)
+
+// newMetadataCmd builds the "metadata" command group.
+func newMetadataCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "metadata",
+		Short: "アップロード済みファイルのメタデータを操作する",
+	}
+	cmd.AddCommand(newMetadataFixCmd(), newMetadataReconcileCmd())
+	return cmd
+}
+
+// newMetadataFixCmd builds "metadata fix", which re-detects the MIME type of every local file
+// and updates the stored metadata of the matching remote file where it differs.
+func newMetadataFixCmd() *cobra.Command {
+	var folderPath, folderName string
+	var dryRun, direct, viaAPI bool
+	cmd := &cobra.Command{
+		Use:   "fix",
+		Short: "ローカルファイルからMIMEタイプを再検出し、リモートのメタデータを更新する",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if folderPath == "" || folderName == "" {
+				return fmt.Errorf("--path と --folder-name は必須です")
+			}
+			u := &uploader{api: apiClient()}
+			if direct {
+				err := initBackend(context.Background())
+				if err == nil {
+					return fixMetadata(u, folderPath, folderName, dryRun, true)
+				}
+				if !viaAPI {
+					return err
+				}
+				fmt.Printf("警告: %v。APIを経由して更新します。\n", err)
+			}
+			return fixMetadata(u, folderPath, folderName, dryRun, false)
+		},
+	}
+	cmd.Flags().StringVar(&folderPath, "path", "", "メタデータを更新するフォルダのパス")
+	cmd.Flags().StringVar(&folderName, "folder-name", "", "更新対象の論理フォルダ名 (例: 第1回)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "実際には更新せず、変更内容のみ表示する")
+	cmd.Flags().BoolVar(&direct, "direct", false, "APIを経由せず、FirestoreのBulkWriterでまとめて更新する (mimeType に加え、未設定の capturedAt と originalPath も補完)")
+	cmd.Flags().BoolVar(&viaAPI, "via-api", false, "--direct でFirebaseに接続できない場合、APIを経由した更新に切り替える")
+	return cmd
+}
+
+// newMetadataReconcileCmd builds "metadata reconcile", which re-sniffs the stored objects
+// instead of local copies and corrects their MIME types in Firestore.
+func newMetadataReconcileCmd() *cobra.Command {
+	var folderName string
+	var all, dryRun bool
+	cmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "保存済みオブジェクトの先頭バイトからMIMEタイプを再検出し、Firestoreを修正する (Firestoreを直接更新)",
+		Long: "ローカルのファイルがなくても、Storageのオブジェクトを読んで mimeType を修正します。\n" +
+			"拡張子・登録済みのMIMEタイプ・内容が食い違うファイルには mimeMismatch が記録されます。",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (folderName == "") == !all {
+				return fmt.Errorf("--folder-name と --all のどちらか一方を指定してください")
+			}
+			ctx := context.Background()
+			if err := initBackend(ctx); err != nil {
+				return err
+			}
+			folderID := ""
+			if folderName != "" {
+				folder, err := findFolder(ctx, folderName)
+				if err != nil {
+					return err
+				}
+				folderID = folder.ID
+			}
+			failed := 0
+			corrected, flagged, err := backend.ReconcileMimeTypes(ctx, folderID, dryRun, func(file backend.FileMetadata, check *backend.MimeCheck, err error) {
+				switch {
+				case err != nil:
+					failed++
+					fmt.Printf("確認に失敗しました %s: %v\n", file.StoragePath, err)
+				case check.Corrected != "":
+					fmt.Printf("~ %s: %s -> %s\n", file.StoragePath, check.Declared, check.Corrected)
+				case check.Mismatch != "":
+					fmt.Printf("! %s: %s\n", file.StoragePath, check.Mismatch)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			if dryRun {
+				fmt.Printf("修正対象: %d, 不一致: %d, 失敗: %d (ドライランのため更新は行いませんでした)\n", corrected, flagged, failed)
+			} else {
+				fmt.Printf("修正: %d, 不一致: %d, 失敗: %d\n", corrected, flagged, failed)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&folderName, "folder-name", "", "確認する論理フォルダ名")
+	cmd.Flags().BoolVar(&all, "all", false, "全フォルダのファイルを確認する")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "実際には更新せず、変更内容のみ表示する")
+	return cmd
+}
+
+// metadataFix is the set of field changes for one remote file.
+type metadataFix struct {
+	relativePath string
+	id           string
+	fields       map[string]interface{} // Firestore field -> new value
+}
+
+// fixMetadata walks root and updates the metadata of each matching file in folderName.
+// Through the API only the MIME type can be changed, one request per file; with direct set,
+// missing capturedAt and originalPath values are filled in as well and all changes are written
+// to Firestore in bulk.
+func fixMetadata(u *uploader, root, folderName string, dryRun, direct bool) error {
+	remote, err := remoteMetadata(u, folderName, direct)
+	if err != nil {
+		return err
+	}
+	byPath := make(map[string]backend.FileMetadata, len(remote))
+	for _, f := range remote {
+		rel := f.RelativePath
+		if rel == "" {
+			rel = strings.TrimPrefix(f.StoragePath, f.FolderID+"/")
+		}
+		byPath[rel] = f
+	}
+
+	fmt.Printf("フォルダ '%s' 内のファイルのメタデータを確認しています。\n", root)
+	var fixes []metadataFix
+	unchanged, missing := 0, 0
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil // ディレクトリはスキップ
+		}
+
+		// ルートフォルダからの相対パスを取得
+		relativePath, err := filepath.Rel(root, path)
+		if err != nil {
+			return fmt.Errorf("相対パスの取得に失敗しました: %v", err)
+		}
+		// Windowsパス区切り文字をUnix形式に変換
+		relativePath = strings.ReplaceAll(relativePath, "\\", "/")
+
+		file, ok := byPath[relativePath]
+		if !ok {
+			fmt.Printf("警告: '%s' に対応する既存のメタデータが見つかりませんでした。スキップします。\n", relativePath)
+			missing++
+			return nil
+		}
+
+		_, detectedMimeType, err := hashAndSniff(path)
+		if err != nil {
+			return fmt.Errorf("ファイル内容の読み込みに失敗しました %s: %v", path, err)
+		}
+		fix := metadataFix{relativePath: relativePath, id: file.ID, fields: make(map[string]interface{})}
+		if detectedMimeType != file.MimeType {
+			fix.fields["mimeType"] = detectedMimeType
+		}
+		if direct {
+			if file.CapturedAt.IsZero() {
+				fix.fields["capturedAt"] = info.ModTime().UTC()
+			}
+			if file.OriginalPath == "" {
+				if absPath, err := filepath.Abs(path); err == nil {
+					fix.fields["originalPath"] = absPath
+				}
+			}
+		}
+		if len(fix.fields) == 0 {
+			unchanged++
+			return nil
+		}
+		fixes = append(fixes, fix)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		for _, fix := range fixes {
+			fmt.Printf("~ %s: %s\n", fix.relativePath, formatFields(fix.fields))
+		}
+		fmt.Printf("更新対象: %d, 変更なし: %d, 未登録: %d (ドライランのため更新は行いませんでした)\n", len(fixes), unchanged, missing)
+		return nil
+	}
+
+	var failed map[string]string
+	if direct {
+		failed = applyFixesDirect(fixes)
+	} else {
+		failed = applyFixesViaAPI(u, fixes)
+	}
+	for _, fix := range fixes {
+		if msg, ok := failed[fix.id]; ok {
+			fmt.Printf("メタデータ更新に失敗しました %s: %s\n", fix.relativePath, msg)
+		}
+	}
+
+	fmt.Printf("更新: %d, 失敗: %d, 変更なし: %d, 未登録: %d\n", len(fixes)-len(failed), len(failed), unchanged, missing)
+	if len(failed) > 0 {
+		return fmt.Errorf("%d 件のファイルのメタデータを更新できませんでした", len(failed))
+	}
+	return nil
+}
+
+// remoteMetadata lists the files of folderName, from Firestore if direct is set and through the API otherwise.
+func remoteMetadata(u *uploader, folderName string, direct bool) ([]backend.FileMetadata, error) {
+	if direct {
+		folder, err := findFolder(context.Background(), folderName)
+		if err != nil {
+			return nil, err
+		}
+		return backend.ListAllFilesInFolder(context.Background(), folder.ID)
+	}
+
+	folderID, err := u.folderID(folderName)
+	if err != nil {
+		return nil, err
+	}
+	if folderID == "" {
+		return nil, fmt.Errorf("論理フォルダ '%s' が見つかりません", folderName)
+	}
+	files, err := u.remoteFiles(folderID)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make([]backend.FileMetadata, len(files))
+	for i, f := range files {
+		metadata[i] = backend.FileMetadata{ID: f.ID, Name: f.Name, MimeType: f.MimeType, StoragePath: f.StoragePath, FolderID: f.FolderID, Hash: f.Hash, RelativePath: f.RelativePath}
+	}
+	return metadata, nil
+}
+
+// applyFixesDirect writes fixes to Firestore in bulk and returns error messages keyed by file ID.
+func applyFixesDirect(fixes []metadataFix) map[string]string {
+	updates := make([]backend.FileFieldUpdate, len(fixes))
+	for i, fix := range fixes {
+		updates[i] = backend.FileFieldUpdate{ID: fix.id, Fields: fix.fields}
+	}
+	started := time.Now()
+	fmt.Printf("%d 件のファイルをFirestoreで直接更新します。\n", len(updates))
+	failed := backend.BulkUpdateFiles(context.Background(), updates)
+	fmt.Printf("Firestoreの更新が完了しました。(所要時間: %s)\n", time.Since(started).Round(time.Millisecond))
+	return failed
+}
+
+// applyFixesViaAPI posts each MIME type change to /api/update/file-metadata and returns error
+// messages keyed by file ID.
+func applyFixesViaAPI(u *uploader, fixes []metadataFix) map[string]string {
+	failed := make(map[string]string)
+	for _, fix := range fixes {
+		mimeType, _ := fix.fields["mimeType"].(string)
+		if err := u.api.SetFileMimeType(context.Background(), fix.id, mimeType); err != nil {
+			failed[fix.id] = err.Error()
+			continue
+		}
+		fmt.Printf("メタデータ更新成功: %s (MIMEタイプ: %s)\n", fix.relativePath, mimeType)
+	}
+	return failed
+}
+
+// formatFields renders field changes as "name=value" pairs in a stable order.
+func formatFields(fields map[string]interface{}) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%v", name, fields[name])
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"drive-gallery/client"
+)
+
+// fileStatus is the outcome of processing a single file.
+type fileStatus string
+
+const (
+	statusUploaded fileStatus = "uploaded"
+	statusSkipped  fileStatus = "skipped"
+	statusFailed   fileStatus = "failed"
+	statusDeleted  fileStatus = "deleted"
+)
+
+// fileResult describes how a single file was processed.
+type fileResult struct {
+	job      uploadJob
+	status   fileStatus
+	hash     string
+	attempts int
+	duration time.Duration
+	err      error
+}
+
+// jsonResult is the --json representation of a fileResult, one per output line.
+type jsonResult struct {
+	Type         string `json:"type"`
+	Path         string `json:"path"`
+	RelativePath string `json:"relativePath"`
+	Status       string `json:"status"`
+	Bytes        int64  `json:"bytes"`
+	Hash         string `json:"hash,omitempty"`
+	Attempts     int    `json:"attempts,omitempty"`
+	DurationMs   int64  `json:"durationMs"`
+	Error        string `json:"error,omitempty"`
+}
+
+// jsonSummary is the final line written in --json mode.
+type jsonSummary struct {
+	Type       string `json:"type"`
+	Total      int    `json:"total"`
+	Uploaded   int    `json:"uploaded"`
+	Skipped    int    `json:"skipped"`
+	Failed     int    `json:"failed"`
+	Deleted    int    `json:"deleted,omitempty"`
+	Bytes      int64  `json:"bytes"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// reporter owns all output of an upload run: human-readable log lines with optional
+// live progress bars, or one JSON object per line for scripting.
+type reporter struct {
+	mu       sync.Mutex
+	out      io.Writer
+	jsonMode bool
+	bars     bool // Redraw progress bars on the terminal
+
+	started     time.Time
+	totalFiles  int
+	totalBytes  int64
+	doneFiles   int
+	doneBytes   int64 // Bytes of finished files, including skipped ones
+	sentBytes   int64 // Bytes actually transferred by finished uploads
+	active      map[string]*activeFile
+	drawnLines  int
+	stopRedraw  chan struct{}
+	redrawGroup sync.WaitGroup
+}
+
+// activeFile tracks the progress of a file currently being uploaded.
+type activeFile struct {
+	name string
+	size int64
+	sent int64
+}
+
+// newReporter creates a reporter. Progress bars are only drawn when stderr is a terminal.
+func newReporter(jsonMode, bars bool) *reporter {
+	r := &reporter{out: os.Stdout, jsonMode: jsonMode, active: make(map[string]*activeFile)}
+	if bars && !jsonMode && isTerminal(os.Stderr) {
+		r.bars = true
+	}
+	return r
+}
+
+// isTerminal reports whether f is attached to a character device.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// start begins a run over the given jobs.
+func (r *reporter) start(jobs []uploadJob) {
+	r.mu.Lock()
+	r.started = time.Now()
+	r.totalFiles = len(jobs)
+	r.totalBytes, r.doneFiles, r.doneBytes, r.sentBytes = 0, 0, 0, 0
+	for _, job := range jobs {
+		r.totalBytes += job.size
+	}
+	r.mu.Unlock()
+
+	if r.bars {
+		r.stopRedraw = make(chan struct{})
+		r.redrawGroup.Add(1)
+		go func() {
+			defer r.redrawGroup.Done()
+			ticker := time.NewTicker(200 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					r.mu.Lock()
+					r.redraw()
+					r.mu.Unlock()
+				case <-r.stopRedraw:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// logf prints a human-readable message. It is suppressed in --json mode.
+func (r *reporter) logf(format string, args ...interface{}) {
+	if r.jsonMode {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clearBars()
+	fmt.Fprintf(r.out, format, args...)
+	r.redraw()
+}
+
+// fileProgress records that sent bytes of the current attempt for job have been transferred.
+func (r *reporter) fileProgress(job uploadJob, sent int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.active[job.relativePath]
+	if !ok {
+		f = &activeFile{name: job.relativePath, size: job.size}
+		r.active[job.relativePath] = f
+	}
+	if sent > f.size {
+		sent = f.size // The multipart envelope is slightly larger than the file itself
+	}
+	f.sent = sent
+}
+
+// fileDone records the result of a file and prints it.
+func (r *reporter) fileDone(res fileResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.active, res.job.relativePath)
+	r.doneFiles++
+	r.doneBytes += res.job.size
+	if res.status == statusUploaded {
+		r.sentBytes += res.job.size
+	}
+
+	if r.jsonMode {
+		line := jsonResult{
+			Type:         "file",
+			Path:         res.job.path,
+			RelativePath: res.job.relativePath,
+			Status:       string(res.status),
+			Bytes:        res.job.size,
+			Hash:         res.hash,
+			Attempts:     res.attempts,
+			DurationMs:   res.duration.Milliseconds(),
+		}
+		if res.err != nil {
+			line.Error = res.err.Error()
+		}
+		json.NewEncoder(r.out).Encode(line)
+		return
+	}
+
+	r.clearBars()
+	switch res.status {
+	case statusFailed:
+		fmt.Fprintf(r.out, "アップロード失敗: %s: %v\n", res.job.relativePath, res.err)
+	case statusSkipped:
+		fmt.Fprintf(r.out, "スキップ (アップロード済み): %s\n", res.job.relativePath)
+	default:
+		if res.attempts > 1 {
+			fmt.Fprintf(r.out, "アップロード成功: %s (%s, %d回目)\n", res.job.relativePath, formatBytes(res.job.size), res.attempts)
+		} else {
+			fmt.Fprintf(r.out, "アップロード成功: %s (%s)\n", res.job.relativePath, formatBytes(res.job.size))
+		}
+	}
+	r.redraw()
+}
+
+// fileDeleted prints the result of removing a remote file during --sync.
+func (r *reporter) fileDeleted(f client.File, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.jsonMode {
+		line := jsonResult{Type: "file", RelativePath: f.Path(), Status: string(statusDeleted), Hash: f.Hash}
+		if err != nil {
+			line.Status = string(statusFailed)
+			line.Error = err.Error()
+		}
+		json.NewEncoder(r.out).Encode(line)
+		return
+	}
+
+	r.clearBars()
+	if err != nil {
+		fmt.Fprintf(r.out, "削除失敗: %s: %v\n", f.Path(), err)
+	} else {
+		fmt.Fprintf(r.out, "削除しました: %s\n", f.Path())
+	}
+	r.redraw()
+}
+
+// finish stops the progress display and prints the run summary.
+func (r *reporter) finish(s *runSummary) {
+	if r.stopRedraw != nil {
+		close(r.stopRedraw)
+		r.redrawGroup.Wait()
+		r.stopRedraw = nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clearBars()
+	r.started = time.Time{} // Stop drawing bars until the next run starts
+
+	if r.jsonMode {
+		json.NewEncoder(r.out).Encode(jsonSummary{
+			Type:       "summary",
+			Total:      s.total,
+			Uploaded:   s.uploaded,
+			Skipped:    s.skipped,
+			Failed:     len(s.failed),
+			Deleted:    s.deleted,
+			Bytes:      r.sentBytes,
+			DurationMs: time.Since(s.started).Milliseconds(),
+		})
+		return
+	}
+	s.print(r.out, r.sentBytes)
+}
+
+// clearBars erases previously drawn progress bars. The caller must hold r.mu.
+func (r *reporter) clearBars() {
+	for ; r.drawnLines > 0; r.drawnLines-- {
+		fmt.Fprint(os.Stderr, "\033[1A\033[2K")
+	}
+}
+
+// redraw renders per-file bars and the total bar with ETA to stderr. The caller must hold r.mu.
+func (r *reporter) redraw() {
+	if !r.bars || r.started.IsZero() {
+		return
+	}
+	r.clearBars()
+
+	names := make([]string, 0, len(r.active))
+	var inFlight int64
+	for name, f := range r.active {
+		names = append(names, name)
+		inFlight += f.sent
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := r.active[name]
+		fmt.Fprintf(os.Stderr, "  %s %s/%s %s\n", bar(f.sent, f.size, 20), formatBytes(f.sent), formatBytes(f.size), truncateName(filepath.ToSlash(name), 40))
+		r.drawnLines++
+	}
+
+	done := r.doneBytes + inFlight
+	elapsed := time.Since(r.started)
+	rate := float64(r.sentBytes+inFlight) / elapsed.Seconds()
+	eta := "--:--"
+	if rate > 0 {
+		remaining := time.Duration(float64(r.totalBytes-done)/rate) * time.Second
+		eta = formatDuration(remaining)
+	}
+	fmt.Fprintf(os.Stderr, "合計 %s %s/%s %s/s 残り %s (%d/%d ファイル)\n",
+		bar(done, r.totalBytes, 30), formatBytes(done), formatBytes(r.totalBytes), formatBytes(int64(rate)), eta, r.doneFiles, r.totalFiles)
+	r.drawnLines++
+}
+
+// bar renders a fixed-width progress bar with a percentage.
+func bar(done, total int64, width int) string {
+	ratio := 1.0
+	if total > 0 {
+		ratio = float64(done) / float64(total)
+	}
+	if ratio > 1 {
+		ratio = 1
+	}
+	filled := int(ratio * float64(width))
+	b := strings.Repeat("=", filled)
+	if filled < width {
+		b += ">" + strings.Repeat(" ", width-filled-1)
+	}
+	return fmt.Sprintf("[%s] %3.0f%%", b, ratio*100)
+}
+
+// formatBytes renders a byte count with a binary unit suffix.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatDuration renders d as mm:ss, or hh:mm:ss for long durations.
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := int(d / time.Hour)
+	m := int(d/time.Minute) % 60
+	s := int(d/time.Second) % 60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%02d:%02d", m, s)
+}
+
+// truncateName shortens long paths from the left so the file name stays visible.
+func truncateName(name string, max int) string {
+	runes := []rune(name)
+	if len(runes) <= max {
+		return name
+	}
+	return "…" + string(runes[len(runes)-max+1:])
+}
+
+// progressReader reports how many bytes have been read from the wrapped reader.
+type progressReader struct {
+	r      io.Reader
+	read   int64
+	report func(read int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	p.report(p.read)
+	return n, err
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// parseRate parses a bandwidth such as "5MB/s", "500KB/s" or "1.5M" into bytes per second.
+// Units are binary (1KB = 1024 bytes); "/s" is optional.
+func parseRate(s string) (float64, error) {
+	v := strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	v = strings.TrimSuffix(v, "IB")
+	v = strings.TrimSuffix(v, "B")
+	multiplier := 1.0
+	if v != "" {
+		switch v[len(v)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			v = v[:len(v)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("不正な転送レートです: %q (例: 5MB/s, 500KB/s)", s)
+	}
+	return n * multiplier, nil
+}
+
+// throttle limits the upload bandwidth shared by all workers and backs off when the API
+// answers 429. On every 429 the rate is halved (down to 1/8 of the configured limit) and
+// all workers pause; each successful upload then restores the rate by 10%.
+type throttle struct {
+	limiter *rate.Limiter // nil without --limit-rate
+	max     rate.Limit
+
+	mu         sync.Mutex
+	pauseUntil time.Time
+}
+
+// newThrottle creates a throttle. A bytesPerSec of 0 means unlimited bandwidth.
+func newThrottle(bytesPerSec float64) *throttle {
+	t := &throttle{}
+	if bytesPerSec > 0 {
+		t.max = rate.Limit(bytesPerSec)
+		// The burst is also the largest chunk read at once, so keep it small enough for smooth pacing
+		burst := int(bytesPerSec / 10)
+		if burst < 32<<10 {
+			burst = 32 << 10
+		}
+		t.limiter = rate.NewLimiter(t.max, burst)
+	}
+	return t
+}
+
+// wait blocks while the API has asked us to slow down.
+func (t *throttle) wait() {
+	t.mu.Lock()
+	d := time.Until(t.pauseUntil)
+	t.mu.Unlock()
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// tooManyRequests pauses all workers for d and lowers the bandwidth limit.
+func (t *throttle) tooManyRequests(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(d); until.After(t.pauseUntil) {
+		t.pauseUntil = until
+	}
+	if t.limiter != nil {
+		if limit := t.limiter.Limit() / 2; limit >= t.max/8 {
+			t.limiter.SetLimit(limit)
+		}
+	}
+}
+
+// succeeded gradually restores the bandwidth limit after a 429.
+func (t *throttle) succeeded() {
+	if t.limiter == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if limit := t.limiter.Limit() * 1.1; limit < t.max {
+		t.limiter.SetLimit(limit)
+	} else {
+		t.limiter.SetLimit(t.max)
+	}
+}
+
+// reader wraps r so that reads are paced by the bandwidth limit.
+func (t *throttle) reader(r io.Reader) io.Reader {
+	if t.limiter == nil {
+		return r
+	}
+	return &limitedReader{r: r, limiter: t.limiter}
+}
+
+// limitedReader is a token-bucket reader: every byte read consumes a token.
+type limitedReader struct {
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (l *limitedReader) Read(b []byte) (int, error) {
+	if burst := l.limiter.Burst(); len(b) > burst {
+		b = b[:burst]
+	}
+	n, err := l.r.Read(b)
+	if n > 0 {
+		if werr := l.limiter.WaitN(context.Background(), n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// rateLimitedError is returned for 429 responses, and 503s of a saturated backend, and carries
+// the server's Retry-After hint.
+type rateLimitedError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *rateLimitedError) Error() string { return e.err.Error() }
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// options are the settings shared by every folder uploaded in a run.
+type options struct {
+	concurrency  int
+	dryRun       bool
+	jsonOutput   bool
+	syncMode     bool
+	deleteRemote bool
+	assumeYes    bool
+	watchMode    bool
+	debounce     time.Duration
+	include      []string
+	exclude      []string
+	manifestDir  string
+}
+
+// newUploadCmd builds the "upload" command, or "sync" if syncMode is set.
+func newUploadCmd(syncMode bool) *cobra.Command {
+	var (
+		folderPath   string
+		folderName   string
+		concurrency  int
+		retries      int
+		showProgress bool
+		limitRate    string
+		direct       bool
+		precheck     bool
+		opts         = options{syncMode: syncMode}
+	)
+	cmd := &cobra.Command{
+		Use:   "upload",
+		Short: "ローカルフォルダを論理フォルダにアップロードする",
+		Args:  cobra.NoArgs,
+	}
+	if syncMode {
+		cmd.Use = "sync"
+		cmd.Short = "リモートの論理フォルダをローカルフォルダと一致させる (新規・変更ファイルをアップロードし、変更前のファイルを置き換える)"
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&folderPath, "path", "", "アップロードするフォルダのパス")
+	flags.StringVar(&folderName, "folder-name", "", "アップロード先の論理フォルダ名 (例: 第1回)")
+	flags.IntVar(&concurrency, "concurrency", 4, "同時にアップロードするファイル数")
+	flags.IntVar(&retries, "retries", 3, "一時的なエラー時にファイルごとにリトライする回数")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "実際にはアップロードせず、アップロード・スキップ・リモートのみのファイルを差分形式で表示する")
+	flags.BoolVar(&opts.jsonOutput, "json", false, "結果をファイルごとに1行のJSONで出力する (スクリプト・CI向け)")
+	flags.BoolVar(&showProgress, "progress", true, "端末にファイルごとと全体のプログレスバーを表示する")
+	flags.BoolVar(&opts.watchMode, "watch", false, "アップロード後も終了せず、フォルダを監視して新規・変更ファイルを自動でアップロードする")
+	flags.DurationVar(&opts.debounce, "debounce", 2*time.Second, "--watch 時、ファイルの書き込みが止まってからアップロードするまでの待ち時間")
+	flags.StringVar(&limitRate, "limit-rate", "", "アップロードの最大転送レート (例: 5MB/s, 500KB/s)。会場の共有回線を使い切らないようにする")
+	flags.BoolVar(&direct, "direct", false, "署名付きURLでStorageへ直接アップロードし、バックエンドを経由しない (大容量の動画向け)")
+	flags.BoolVar(&precheck, "precheck", true, "アップロード前にSHA-256ハッシュで既存ファイルを確認し、存在する場合はスキップする")
+	flags.StringSliceVar(&opts.include, "include", nil, "アップロード対象に含めるglobパターン (複数指定・カンマ区切り可)")
+	flags.StringSliceVar(&opts.exclude, "exclude", nil, "アップロード対象から除外するglobパターン (複数指定・カンマ区切り可、"+ignoreFileName+" も参照)")
+	flags.StringVar(&opts.manifestDir, "manifest-dir", "", "アップロード後、今回アップロードしたファイルのハッシュ・サイズ・URLを記載した署名付きマニフェストをこのディレクトリに保存する (納品の証明用)")
+	if syncMode {
+		flags.BoolVar(&opts.deleteRemote, "delete", false, "ローカルに存在しないリモートのファイルを削除する (実行前に確認あり)")
+		flags.BoolVar(&opts.assumeYes, "yes", false, "--delete の確認を省略する (スクリプト・CI向け)")
+	}
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		// Values from the config file apply unless the flag was given explicitly
+		if cfg.Concurrency != 0 && !flags.Changed("concurrency") {
+			concurrency = cfg.Concurrency
+		}
+		if cfg.Retries != nil && !flags.Changed("retries") {
+			retries = *cfg.Retries
+		}
+		if cfg.LimitRate != "" && !flags.Changed("limit-rate") {
+			limitRate = cfg.LimitRate
+		}
+
+		targets := cfg.Folders
+		if folderPath != "" || folderName != "" {
+			targets = []folderMapping{{Path: folderPath, FolderName: folderName}}
+		}
+		if len(targets) == 0 || targets[0].Path == "" || targets[0].FolderName == "" {
+			return fmt.Errorf("--path と --folder-name (または --config の folders) は必須です")
+		}
+		if concurrency < 1 || retries < 0 {
+			return fmt.Errorf("--concurrency は1以上、--retries は0以上で指定してください")
+		}
+		var bytesPerSec float64
+		if limitRate != "" {
+			var err error
+			if bytesPerSec, err = parseRate(limitRate); err != nil {
+				return err
+			}
+		}
+		if opts.watchMode && (opts.dryRun || opts.debounce <= 0 || len(targets) > 1) {
+			return fmt.Errorf("--watch は1つのフォルダでのみ使用でき、--dry-run と併用できません。--debounce は正の値で指定してください")
+		}
+
+		base := uploader{
+			api:      apiClient(),
+			retries:  retries,
+			backoff:  time.Second,
+			precheck: precheck,
+			direct:   direct,
+			throttle: newThrottle(bytesPerSec),
+			report:   newReporter(opts.jsonOutput, showProgress),
+		}
+		opts.concurrency = concurrency
+		opts.include = append(cfg.Include, opts.include...)
+		opts.exclude = append(cfg.Exclude, opts.exclude...)
+
+		failed := 0
+		for _, target := range targets {
+			if !runTarget(base, target, opts) {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d 件のフォルダで失敗したファイルがあります", failed)
+		}
+		return nil
+	}
+	return cmd
+}
+
+// runTarget uploads a single local directory to its logical folder and reports whether it succeeded.
+func runTarget(base uploader, target folderMapping, opts options) bool {
+	u := &base
+	u.folderName = target.FolderName
+	report := u.report
+	if opts.dryRun {
+		report.logf("フォルダ '%s' と '%s' の差分を表示します。(ドライラン)\n", target.Path, target.FolderName)
+	} else {
+		report.logf("フォルダ '%s' を '%s' としてアップロードします。(並列数: %d)\n", target.Path, target.FolderName, opts.concurrency)
+	}
+
+	filter, err := newFileFilter(target.Path, opts.include, opts.exclude)
+	if err != nil {
+		fmt.Printf("エラーが発生しました: %v\n", err)
+		return false
+	}
+	u.filter = filter
+
+	jobs, err := collectJobs(target.Path, filter)
+	if err != nil {
+		fmt.Printf("エラーが発生しました: %v\n", err)
+		return false
+	}
+
+	if opts.dryRun {
+		entries, err := u.computeDiff(jobs)
+		if err != nil {
+			fmt.Printf("エラーが発生しました: %v\n", err)
+			return false
+		}
+		printDiff(entries, opts.jsonOutput)
+		report.logf("ドライランのため、アップロードは行いませんでした。\n")
+		return true
+	}
+
+	started := time.Now()
+	var summary *runSummary
+	if opts.syncMode {
+		summary = runSync(u, jobs, opts.concurrency, opts.deleteRemote, opts.assumeYes)
+	} else {
+		summary = runPool(u, jobs, opts.concurrency)
+	}
+	report.finish(summary)
+	if summary.uploaded > 0 {
+		if err := u.warmThumbnails(); err != nil {
+			report.logf("警告: サムネイルの事前生成を依頼できませんでした: %v\n", err)
+		}
+	}
+	if opts.manifestDir != "" && summary.uploaded > 0 {
+		if path, err := u.saveManifest(opts.manifestDir, started); err != nil {
+			report.logf("警告: マニフェストを保存できませんでした: %v\n", err)
+		} else {
+			report.logf("マニフェストを %s に保存しました。\n", path)
+		}
+	}
+
+	if opts.watchMode {
+		// Failures of the initial run are reported above; watching continues regardless
+		if err := watch(u, target.Path, opts.concurrency, opts.debounce, opts.syncMode); err != nil {
+			fmt.Printf("エラーが発生しました: %v\n", err)
+			return false
+		}
+		return true
+	}
+
+	if len(summary.failed) > 0 {
+		return false
+	}
+	if opts.syncMode {
+		report.logf("同期が完了しました。\n")
+	} else {
+		report.logf("すべてのファイルのアップロードが完了しました。\n")
+	}
+	return true
+}
+
+// collectJobs walks root and returns an upload job for every regular file accepted by filter.
+func collectJobs(root string, filter *fileFilter) ([]uploadJob, error) {
+	var jobs []uploadJob
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// ルートフォルダからの相対パスを取得
+		relativePath, err := filepath.Rel(root, path)
+		if err != nil {
+			return fmt.Errorf("相対パスの取得に失敗しました: %v", err)
+		}
+
+		// Windowsパス区切り文字をUnix形式に変換
+		relativePath = strings.ReplaceAll(relativePath, "\\", "/")
+
+		if info.IsDir() {
+			if filter.excludesDir(relativePath) {
+				return filepath.SkipDir // 除外されたディレクトリは中身ごとスキップ
+			}
+			return nil // ディレクトリはスキップ
+		}
+		if !filter.match(relativePath) {
+			return nil
+		}
+
+		jobs = append(jobs, uploadJob{path: path, relativePath: relativePath, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	return jobs, err
+}
+
+// runPool uploads jobs with a bounded number of workers and collects the results.
+func runPool(u *uploader, jobs []uploadJob, concurrency int) *runSummary {
+	if u.direct {
+		return runDirect(u, jobs, concurrency)
+	}
+	summary := &runSummary{total: len(jobs), started: time.Now()}
+	u.report.start(jobs)
+	queue := make(chan uploadJob)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				res := u.process(job)
+				switch res.status {
+				case statusFailed:
+					summary.addFailed(job, res.err)
+				case statusSkipped:
+					summary.addSkipped()
+				default:
+					summary.addUploaded()
+				}
+				u.report.fileDone(res)
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+	return summary
+}
+
+// runSync makes the remote folder match jobs: new and changed files are uploaded and,
+// if deleteRemote is set, remote files without a local counterpart are deleted.
+func runSync(u *uploader, jobs []uploadJob, concurrency int, deleteRemote, assumeYes bool) *runSummary {
+	entries, err := u.computeDiff(jobs)
+	if err != nil {
+		fmt.Printf("エラーが発生しました: %v\n", err)
+		os.Exit(1)
+	}
+	plan := newSyncPlan(entries)
+
+	if len(plan.stale) > 0 {
+		if !deleteRemote {
+			u.report.logf("リモートのみに存在するファイルが %d 件あります。削除するには --delete を指定してください。\n", len(plan.stale))
+		} else if !assumeYes {
+			for _, f := range plan.stale {
+				fmt.Fprintf(os.Stderr, "- %s\n", f.Path())
+			}
+			if !confirm(fmt.Sprintf("'%s' から上記 %d 件のファイルを削除します。よろしいですか?", u.folderName, len(plan.stale))) {
+				fmt.Println("中止しました。")
+				os.Exit(1)
+			}
+		}
+	}
+
+	if !deleteRemote {
+		plan.stale = nil
+	}
+	return applySync(u, plan, jobs, concurrency)
+}
+
+// applySync carries out plan for jobs: outdated records of changed files are replaced,
+// new and changed files are uploaded and the plan's stale files are deleted.
+func applySync(u *uploader, plan syncPlan, jobs []uploadJob, concurrency int) *runSummary {
+	// A changed file is re-uploaded to the same storage path, so the outdated record is
+	// removed first; deleting it afterwards would also delete the newly written object.
+	replaced := &runSummary{}
+	u.removeRemote(plan.replaced, replaced)
+	failedPaths := make(map[string]bool)
+	for _, f := range replaced.failed {
+		failedPaths[f.relativePath] = true
+	}
+	var uploads []uploadJob
+	for _, job := range plan.upload {
+		if !failedPaths[job.relativePath] {
+			uploads = append(uploads, job)
+		}
+	}
+
+	summary := runPool(u, uploads, concurrency)
+	summary.total += len(jobs) - len(uploads)
+	summary.skipped += len(jobs) - len(plan.upload)
+	summary.failed = append(summary.failed, replaced.failed...)
+	summary.deleted = u.removeRemote(plan.stale, summary)
+	return summary
+}
+
+// failedUpload records why a file could not be uploaded.
+type failedUpload struct {
+	relativePath string
+	err          error
+}
+
+// runSummary collects the outcome of an upload run.
+type runSummary struct {
+	mu       sync.Mutex
+	total    int
+	uploaded int
+	skipped  int
+	failed   []failedUpload
+	deleted  int // Remote files removed by --sync --delete
+	started  time.Time
+}
+
+func (s *runSummary) addUploaded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploaded++
+}
+
+func (s *runSummary) addSkipped() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped++
+}
+
+func (s *runSummary) addFailed(job uploadJob, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = append(s.failed, failedUpload{relativePath: job.relativePath, err: err})
+}
+
+func (s *runSummary) print(w io.Writer, sentBytes int64) {
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "=== アップロード結果 ===")
+	fmt.Fprintf(w, "対象: %d, 成功: %d, スキップ: %d, 失敗: %d (転送量: %s, 所要時間: %s)\n",
+		s.total, s.uploaded, s.skipped, len(s.failed), formatBytes(sentBytes), time.Since(s.started).Round(time.Second))
+	if s.deleted > 0 {
+		fmt.Fprintf(w, "削除: %d\n", s.deleted)
+	}
+	if len(s.failed) > 0 {
+		fmt.Fprintln(w, "失敗したファイル:")
+		for _, f := range s.failed {
+			fmt.Fprintf(w, "  %s: %v\n", f.relativePath, f.err)
+		}
+	}
+}
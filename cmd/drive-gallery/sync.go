@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"drive-gallery/client"
+)
+
+// syncPlan lists the changes --sync makes so that the remote folder matches the local directory.
+type syncPlan struct {
+	upload   []uploadJob   // New and changed local files
+	replaced []client.File // Remote records superseded by a changed local file at the same path
+	stale    []client.File // Remote files with no local counterpart, removed only with --delete
+}
+
+// newSyncPlan derives a sync plan from a diff of the local directory against the remote folder.
+func newSyncPlan(entries []diffEntry) syncPlan {
+	var plan syncPlan
+	for _, e := range entries {
+		switch e.action {
+		case actionUpload:
+			plan.upload = append(plan.upload, *e.job)
+		case actionChanged:
+			plan.upload = append(plan.upload, *e.job)
+			plan.replaced = append(plan.replaced, *e.remote)
+		case actionSkip:
+			// The content is already stored elsewhere, but the record at this path is outdated
+			if e.remote != nil && e.remote.Hash != e.hash {
+				plan.stale = append(plan.stale, *e.remote)
+			}
+		case actionRemoteOnly:
+			plan.stale = append(plan.stale, *e.remote)
+		}
+	}
+	return plan
+}
+
+// deleteFiles removes remote files through POST /api/files/batch-delete and returns
+// an error message for every file that could not be deleted, keyed by ID. IDs reported as not
+// found were already deleted by someone else, which is fine.
+func (u *uploader) deleteFiles(files []client.File) (map[string]string, error) {
+	ids := make([]string, len(files))
+	for i, f := range files {
+		ids[i] = f.ID
+	}
+	failed, err := u.api.DeleteFiles(context.Background(), ids)
+	if err != nil {
+		return nil, fmt.Errorf("ファイルの削除に失敗しました: %v", err)
+	}
+	return failed, nil
+}
+
+// removeRemote deletes files and reports each outcome. It returns the number of files deleted.
+func (u *uploader) removeRemote(files []client.File, summary *runSummary) int {
+	if len(files) == 0 {
+		return 0
+	}
+	failed, err := u.deleteFiles(files)
+	if err != nil {
+		for _, f := range files {
+			summary.addFailed(uploadJob{relativePath: f.Path()}, err)
+			u.report.fileDeleted(f, err)
+		}
+		return 0
+	}
+	deleted := 0
+	for _, f := range files {
+		if msg, ok := failed[f.ID]; ok {
+			err := fmt.Errorf("%s", msg)
+			summary.addFailed(uploadJob{relativePath: f.Path()}, err)
+			u.report.fileDeleted(f, err)
+			continue
+		}
+		deleted++
+		u.report.fileDeleted(f, nil)
+	}
+	return deleted
+}
+
+// confirm asks a yes/no question on stderr and reads the answer from stdin.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"drive-gallery/client"
+)
+
+// uploadJob is a single local file to be uploaded.
+type uploadJob struct {
+	path         string // Local path
+	relativePath string // Path relative to the upload root, using "/" separators
+	size         int64
+	modTime      time.Time
+}
+
+// permanentError marks failures that retrying cannot fix (e.g. 4xx responses).
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+// uploader posts files to the backend's /api/upload/file endpoint.
+type uploader struct {
+	api        *client.Client
+	folderName string
+	retries    int
+	backoff    time.Duration // Initial backoff, doubled after every failed attempt
+	precheck   bool          // Skip files whose hash is already stored
+	direct     bool          // Upload to Storage with signed URLs instead of through the API
+	filter     *fileFilter
+	throttle   *throttle
+	report     *reporter
+}
+
+// process uploads a single job unless its content is already stored remotely.
+func (u *uploader) process(job uploadJob) fileResult {
+	started := time.Now()
+	res := fileResult{job: job}
+	finish := func(status fileStatus, err error) fileResult {
+		res.status = status
+		res.err = err
+		res.duration = time.Since(started)
+		return res
+	}
+
+	// ファイル内容を読み込み
+	fileContent, err := os.ReadFile(job.path)
+	if err != nil {
+		return finish(statusFailed, fmt.Errorf("ファイル内容の読み込みに失敗しました %s: %v", job.path, err))
+	}
+	hash := sha256.Sum256(fileContent)
+	res.hash = hex.EncodeToString(hash[:])
+
+	if u.precheck {
+		var exists bool
+		_, err := u.withRetry(job, func() error {
+			var err error
+			exists, err = u.isStored(job, res.hash)
+			return err
+		})
+		if err != nil {
+			return finish(statusFailed, fmt.Errorf("既存ファイルの確認に失敗しました: %v", err))
+		}
+		if exists {
+			return finish(statusSkipped, nil)
+		}
+	}
+
+	var deduplicated bool
+	res.attempts, err = u.withRetry(job, func() error {
+		var err error
+		deduplicated, err = u.upload(job, fileContent, res.hash)
+		return err
+	})
+	if err != nil {
+		return finish(statusFailed, err)
+	}
+	u.throttle.succeeded()
+	if deduplicated {
+		return finish(statusSkipped, nil)
+	}
+	return finish(statusUploaded, nil)
+}
+
+// withRetry calls fn, retrying transient failures with exponential backoff.
+// It returns the number of attempts made and the last error.
+func (u *uploader) withRetry(job uploadJob, fn func() error) (int, error) {
+	var err error
+	delay := u.backoff
+	for attempt := 1; ; attempt++ {
+		u.throttle.wait()
+		err = fn()
+		if err == nil {
+			return attempt, nil
+		}
+		if _, ok := err.(*permanentError); ok || attempt > u.retries {
+			return attempt, err
+		}
+		// Add up to 50% jitter so parallel workers don't retry in lockstep
+		sleep := delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+		if limited, ok := err.(*rateLimitedError); ok {
+			// Honor Retry-After and make every worker slow down, not just this one
+			if limited.retryAfter > sleep {
+				sleep = limited.retryAfter
+			}
+			u.throttle.tooManyRequests(sleep)
+		}
+		u.report.logf("リトライします (%d/%d, %s後): %s: %v\n", attempt, u.retries, sleep.Round(time.Millisecond), job.relativePath, err)
+		time.Sleep(sleep)
+		delay *= 2
+	}
+}
+
+// isStored asks the backend whether the job's content, with the given SHA-256 hash, is already
+// stored, so an interrupted upload resumes where it stopped without sending any bytes again.
+func (u *uploader) isStored(job uploadJob, hash string) (bool, error) {
+	check, err := u.api.CheckUploads(context.Background(), u.folderName, []client.UploadCheckFile{{Hash: hash, Size: job.size, RelativePath: job.relativePath}})
+	if err != nil {
+		return false, apiError(err)
+	}
+	return check.Missing == 0, nil
+}
+
+// warmThumbnails asks the backend to pre-render the thumbnails of the folder's new images, so
+// the first visitor doesn't wait for them. The backend renders them in the background.
+func (u *uploader) warmThumbnails() error {
+	return u.api.WarmThumbnails(context.Background(), u.folderName)
+}
+
+// saveManifest downloads the signed manifest of the files uploaded to the folder since the run
+// started and writes it into dir as a receipt. It returns the path written.
+func (u *uploader) saveManifest(dir string, since time.Time) (string, error) {
+	folderID, err := u.folderID(u.folderName)
+	if err != nil {
+		return "", err
+	}
+	if folderID == "" {
+		return "", fmt.Errorf("フォルダ '%s' が見つかりません", u.folderName)
+	}
+	data, err := u.api.FolderManifest(context.Background(), folderID, since)
+	if err != nil {
+		return "", err
+	}
+	var manifest bytes.Buffer
+	if err := json.Indent(&manifest, data, "", "  "); err != nil {
+		return "", fmt.Errorf("レスポンスのデコードに失敗しました: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("ディレクトリの作成に失敗しました: %v", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("manifest-%s-%s.json", folderID, since.Format("20060102-150405")))
+	if err := os.WriteFile(path, manifest.Bytes(), 0o644); err != nil {
+		return "", fmt.Errorf("ファイルの書き込みに失敗しました: %v", err)
+	}
+	return path, nil
+}
+
+// upload sends a single file through the API client, along with its checksums so the backend
+// can reject content corrupted in transit. It reports whether the backend already had the same
+// content and kept the existing file instead.
+func (u *uploader) upload(job uploadJob, fileContent []byte, hash string) (bool, error) {
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(fileContent, crc32.MakeTable(crc32.Castagnoli)))
+	file := client.UploadFile{
+		FolderName:   u.folderName,
+		RelativePath: job.relativePath,
+		MimeType:     http.DetectContentType(fileContent),
+		Size:         int64(len(fileContent)),
+		SHA256:       hash,
+		CRC32C:       base64.StdEncoding.EncodeToString(crc),
+		ModifiedAt:   job.modTime, // 撮影日時の代わりとなる更新日時
+	}
+	if absPath, err := filepath.Abs(job.path); err == nil {
+		file.OriginalPath = absPath
+	}
+
+	progress := &progressReader{r: u.throttle.reader(bytes.NewReader(fileContent)), report: func(read int64) { u.report.fileProgress(job, read) }}
+	result, err := u.api.Upload(context.Background(), file, progress)
+	if err != nil {
+		return false, apiError(fmt.Errorf("アップロードに失敗しました: %w", err))
+	}
+	return result.Deduplicated, nil
+}
+
+// apiError classifies an error of the API client like statusError does for responses: network
+// errors and retryable status codes stay transient.
+func apiError(err error) error {
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	return statusCodeError(apiErr.StatusCode, apiErr.Header, err)
+}
+
+// statusError wraps err according to the status code of resp (see statusCodeError).
+func statusError(resp *http.Response, err error) error {
+	return statusCodeError(resp.StatusCode, resp.Header, err)
+}
+
+// statusCodeError wraps err as permanent unless the status code is worth retrying (429 and 5xx).
+// A 503 with Retry-After comes from a backend at its concurrency limit and slows down like a 429.
+func statusCodeError(code int, header http.Header, err error) error {
+	if code == http.StatusTooManyRequests || (code == http.StatusServiceUnavailable && header.Get("Retry-After") != "") {
+		return &rateLimitedError{err: err, retryAfter: retryAfter(header)}
+	}
+	// 422 means the content was corrupted on the way, so sending it again may succeed
+	if code < 500 && code != http.StatusUnprocessableEntity {
+		return &permanentError{err}
+	}
+	return err
+}
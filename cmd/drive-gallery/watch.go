@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watch keeps uploading new and changed files under root until interrupted.
+// A file is uploaded once no write to it has been seen for the debounce period,
+// so files that are still being copied from a camera are not uploaded half-written.
+func watch(u *uploader, root string, concurrency int, debounce time.Duration, syncMode bool) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("ファイル監視の開始に失敗しました: %v", err)
+	}
+	defer watcher.Close()
+
+	// fsnotify does not watch subdirectories, so every directory is added individually
+	if err := addWatchDirs(watcher, root); err != nil {
+		return err
+	}
+
+	// Uploads run in a separate goroutine so events keep being consumed while a batch is in flight
+	batches := make(chan []uploadJob, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for jobs := range batches {
+			u.report.finish(uploadBatch(u, jobs, concurrency, syncMode))
+		}
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(debounce / 2)
+	defer ticker.Stop()
+
+	pending := make(map[string]time.Time) // Path -> time of the last write event
+	u.report.logf("'%s' を監視しています。(Ctrl+C で終了)\n", root)
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			info, err := os.Stat(event.Name)
+			if err != nil {
+				continue // Removed or renamed before we got to it
+			}
+			if info.IsDir() {
+				// A directory moved into the tree may already contain files
+				if err := addWatchDirs(watcher, event.Name); err != nil {
+					u.report.logf("警告: %v\n", err)
+				}
+				filepath.Walk(event.Name, func(path string, info os.FileInfo, err error) error {
+					if err == nil && !info.IsDir() {
+						pending[path] = time.Now()
+					}
+					return nil
+				})
+				continue
+			}
+			pending[event.Name] = time.Now()
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			u.report.logf("警告: ファイル監視でエラーが発生しました: %v\n", err)
+
+		case <-ticker.C:
+			jobs := readyJobs(root, pending, debounce, u.filter)
+			if len(jobs) > 0 {
+				batches <- jobs
+			}
+
+		case <-interrupt:
+			u.report.logf("監視を終了します。アップロード中のファイルが完了するまでお待ちください。\n")
+			close(batches)
+			<-done
+			return nil
+		}
+	}
+}
+
+// addWatchDirs adds root and all directories below it to watcher.
+func addWatchDirs(watcher *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if err := watcher.Add(path); err != nil {
+				return fmt.Errorf("ディレクトリの監視に失敗しました %s: %v", path, err)
+			}
+		}
+		return nil
+	})
+}
+
+// readyJobs removes the files that have been quiet for at least debounce from pending
+// and returns upload jobs for those accepted by filter.
+func readyJobs(root string, pending map[string]time.Time, debounce time.Duration, filter *fileFilter) []uploadJob {
+	var jobs []uploadJob
+	for path, lastEvent := range pending {
+		if time.Since(lastEvent) < debounce {
+			continue
+		}
+		delete(pending, path)
+
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		relativePath, err := filepath.Rel(root, path)
+		if err != nil {
+			continue
+		}
+		relativePath = strings.ReplaceAll(relativePath, "\\", "/")
+		if !filter.match(relativePath) {
+			continue
+		}
+		jobs = append(jobs, uploadJob{path: path, relativePath: relativePath, size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].relativePath < jobs[j].relativePath })
+	return jobs
+}
+
+// uploadBatch uploads files picked up by the watcher. In sync mode, changed files
+// replace their outdated remote record; remote-only files are never deleted here.
+func uploadBatch(u *uploader, jobs []uploadJob, concurrency int, syncMode bool) *runSummary {
+	if !syncMode {
+		return runPool(u, jobs, concurrency)
+	}
+	entries, err := u.computeDiff(jobs)
+	if err != nil {
+		summary := &runSummary{total: len(jobs), started: time.Now()}
+		for _, job := range jobs {
+			summary.addFailed(job, err)
+		}
+		return summary
+	}
+	plan := newSyncPlan(entries)
+	plan.stale = nil
+	return applySync(u, plan, jobs, concurrency)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route classes share a concurrency limit. Uploads hold whole files in memory, so a burst of
+// video uploads could otherwise exhaust the instance's memory; bulk admin operations, thumbnail
+// rendering and long media streams are expensive too. Every other route is in routeDefault.
+const (
+	routeUpload    = "upload"
+	routeBulk      = "bulk"
+	routeThumbnail = "thumbnail"
+	routeMedia     = "media"
+	routeDefault   = "default"
+)
+
+// Default limits of the route classes; override with CONCURRENCY_LIMITS ("upload=4,bulk=2") and
+// MAX_CONCURRENT_REQUESTS. 0 means unlimited. The global default matches Cloud Run's default
+// maximum concurrency per instance.
+var defaultConcurrencyLimits = map[string]int{
+	routeUpload:    8,
+	routeBulk:      4,
+	routeThumbnail: 16,
+	routeMedia:     32,
+	routeDefault:   0,
+}
+
+const defaultMaxConcurrentRequests = 80
+
+// Retry-After of saturated route classes, in seconds; uploads take longer to free a slot.
+var concurrencyRetryAfter = map[string]int{
+	routeUpload: 10,
+	routeBulk:   10,
+}
+
+const defaultRetryAfter = 1
+
+// concurrencyGate is a semaphore with saturation counters.
+type concurrencyGate struct {
+	limit int // 0 means unlimited
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	admitted    int64
+	rejected    int64
+	lastReject  time.Time
+}
+
+// ConcurrencyStats reports the load of a route class, or of the global limit ("global").
+type ConcurrencyStats struct {
+	Class          string     `json:"class"`
+	Limit          int        `json:"limit"` // 0 means unlimited
+	InFlight       int        `json:"inFlight"`
+	MaxInFlight    int        `json:"maxInFlight"` // Highest InFlight since startup
+	Admitted       int64      `json:"admitted"`
+	Rejected       int64      `json:"rejected"` // Requests answered with 503
+	LastRejectedAt *time.Time `json:"lastRejectedAt,omitempty"`
+}
+
+// tryAcquire takes a slot without waiting, reporting false if the gate is full.
+func (g *concurrencyGate) tryAcquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limit > 0 && g.inFlight >= g.limit {
+		g.rejected++
+		g.lastReject = time.Now()
+		return false
+	}
+	g.inFlight++
+	g.maxInFlight = max(g.maxInFlight, g.inFlight)
+	g.admitted++
+	return true
+}
+
+// unadmit gives back a slot taken by a request that another gate then rejected, so it counts
+// as rejected rather than admitted.
+func (g *concurrencyGate) unadmit() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inFlight--
+	g.admitted--
+	g.rejected++
+	g.lastReject = time.Now()
+}
+
+func (g *concurrencyGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inFlight--
+}
+
+func (g *concurrencyGate) stats(class string) ConcurrencyStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := ConcurrencyStats{Class: class, Limit: g.limit, InFlight: g.inFlight, MaxInFlight: g.maxInFlight, Admitted: g.admitted, Rejected: g.rejected}
+	if !g.lastReject.IsZero() {
+		t := g.lastReject
+		s.LastRejectedAt = &t
+	}
+	return s
+}
+
+var (
+	globalGate = &concurrencyGate{limit: intFromEnv("MAX_CONCURRENT_REQUESTS", defaultMaxConcurrentRequests)}
+	routeGates = newRouteGates(os.Getenv("CONCURRENCY_LIMITS"))
+)
+
+// intFromEnv parses the environment variable name, falling back to def if it is unset or invalid.
+func intFromEnv(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("WARNING: Invalid %s %q, using %d", name, value, def)
+		return def
+	}
+	return n
+}
+
+// newRouteGates builds the gates of the route classes from the defaults and spec
+// ("upload=4,bulk=2").
+func newRouteGates(spec string) map[string]*concurrencyGate {
+	limits := make(map[string]int, len(defaultConcurrencyLimits))
+	for class, limit := range defaultConcurrencyLimits {
+		limits[class] = limit
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		class, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		class = strings.TrimSpace(class)
+		limit, err := strconv.Atoi(strings.TrimSpace(value))
+		if _, known := limits[class]; !known || err != nil || limit < 0 {
+			log.Printf("WARNING: Ignoring invalid CONCURRENCY_LIMITS entry %q", pair)
+			continue
+		}
+		limits[class] = limit
+	}
+	gates := make(map[string]*concurrencyGate, len(limits))
+	for class, limit := range limits {
+		gates[class] = &concurrencyGate{limit: limit}
+	}
+	return gates
+}
+
+// withConcurrencyLimit admits a request only while both its route class and the global limit
+// have a free slot. Otherwise it answers 503 with Retry-After at once, so clients back off
+// instead of queueing on a saturated instance. Preflight requests are not limited.
+func withConcurrencyLimit(class string, handler http.HandlerFunc) http.HandlerFunc {
+	gate := routeGates[class]
+	retryAfter, ok := concurrencyRetryAfter[class]
+	if !ok {
+		retryAfter = defaultRetryAfter
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			handler(w, r)
+			return
+		}
+		if !gate.tryAcquire() {
+			writeSaturated(w, r, retryAfter)
+			return
+		}
+		if !globalGate.tryAcquire() {
+			gate.unadmit()
+			writeSaturated(w, r, retryAfter)
+			return
+		}
+		defer gate.release()
+		defer globalGate.release()
+		handler(w, r)
+	}
+}
+
+func writeSaturated(w http.ResponseWriter, r *http.Request, retryAfter int) {
+	log.Printf("Rejected %s %s from %s: server busy", r.Method, r.URL.Path, clientIP(r))
+	setCorsHeaders(w)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Server is busy; retry in %d seconds", retryAfter)})
+}
+
+// concurrencyStats returns the load of the global limit followed by the route classes by name.
+func concurrencyStats() []ConcurrencyStats {
+	stats := []ConcurrencyStats{globalGate.stats("global")}
+	classes := make([]string, 0, len(routeGates))
+	for class := range routeGates {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		stats = append(stats, routeGates[class].stats(class))
+	}
+	return stats
+}
+
+// adminConcurrencyHandler reports the in-flight requests and rejections of the concurrency limits.
+func adminConcurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": concurrencyStats()})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"drive-gallery/backend"
+)
+
+// devMode enables the /api/dev endpoints, which fake external services for local development.
+// Never set DEV_MODE in production: the endpoints are unauthenticated.
+var devMode = os.Getenv("DEV_MODE") == "true"
+
+// simulatedMessages numbers simulated notifications like Drive numbers a channel's messages. It
+// starts from the start time, so numbers keep increasing across restarts instead of being
+// ignored as replays.
+var simulatedMessages atomic.Int64
+
+func init() {
+	simulatedMessages.Store(time.Now().Unix())
+}
+
+// simulateWebhookHandler fabricates a Drive push notification and runs it through WebhookHandler,
+// with its dead-letter handling and WebSocket broadcast, so the pipeline can be exercised without
+// a Drive watch channel: POST /api/dev/simulate-webhook with
+// {"fileId": "...", "resourceState": "update", "file": {...}}. Without "file" the Drive metadata is
+// looked up as for a real notification, which fails into a dead letter without Drive credentials.
+func simulateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestBody struct {
+		FileID        string             `json:"fileId"`
+		ResourceState string             `json:"resourceState" validate:"required,oneof=sync add update remove trash untrash"`
+		ChannelID     string             `json:"channelId"`
+		File          *backend.DriveFile `json:"file"` // Drive metadata to use instead of looking the file up
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+	if requestBody.ChannelID == "" {
+		requestBody.ChannelID = "simulated-channel"
+	}
+	if requestBody.File != nil && requestBody.File.ID == "" {
+		requestBody.File.ID = requestBody.FileID
+	}
+
+	headers := map[string]string{
+		"X-Goog-Channel-ID":     requestBody.ChannelID,
+		"X-Goog-Channel-Token":  backend.DriveWebhookToken(),
+		"Date":                  time.Now().UTC().Format(http.TimeFormat),
+		"X-Goog-Resource-State": requestBody.ResourceState,
+		"X-Goog-Resource-ID":    "simulated-resource",
+		"X-Goog-Message-Number": strconv.FormatInt(simulatedMessages.Add(1), 10),
+	}
+	if requestBody.FileID != "" {
+		headers["X-Goog-Resource-URI"] = "https://www.googleapis.com/drive/v3/files/" + requestBody.FileID + "?alt=json"
+	}
+	notification := httptest.NewRequest(http.MethodPost, "/webhook", nil)
+	notification = notification.WithContext(backend.WithSimulatedDriveFile(r.Context(), requestBody.File))
+	for name, value := range headers {
+		notification.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	backend.WebhookHandler(rec, notification)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+		"headers": headers,  // The notification as Drive would have sent it
+		"status":  rec.Code, // WebhookHandler's answer; 503 means Drive would retry
+		"body":    rec.Body.String(),
+	}})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// frameAncestorsPolicy is the Content-Security-Policy sent with every response. It lists the
+// origins allowed to show the gallery in an iframe; main replaces it from EMBED_ORIGINS.
+var frameAncestorsPolicy = buildFrameAncestorsPolicy("")
+
+// defaultEmbedOrigin is allowed to embed the gallery when EMBED_ORIGINS is unset (Vite dev server).
+const defaultEmbedOrigin = "http://localhost:5173"
+
+// Limits of the number of images in the embeddable widget (?limit=).
+const (
+	defaultEmbedLimit = 12
+	maxEmbedLimit     = 100
+)
+
+// buildFrameAncestorsPolicy builds a frame-ancestors policy from a comma-separated list of origins,
+// such as "https://lukeavenue.example, https://www.lukeavenue.example". Entries that are not
+// http(s) origins are skipped with a warning.
+func buildFrameAncestorsPolicy(origins string) string {
+	if strings.TrimSpace(origins) == "" {
+		origins = defaultEmbedOrigin
+	}
+	sources := []string{"'self'"}
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			log.Printf("WARNING: Ignoring invalid embed origin %q (expected e.g. https://example.com)", origin)
+			continue
+		}
+		sources = append(sources, u.Scheme+"://"+u.Host)
+	}
+	return "frame-ancestors " + strings.Join(sources, " ") + ";"
+}
+
+// embedTemplate renders the embeddable gallery widget as a self-contained page.
+var embedTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}}</title>
+<style>
+body { margin: 0; font-family: sans-serif; }
+h1 { font-size: 1rem; margin: 0.5rem; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(120px, 1fr)); gap: 4px; padding: 0 4px 4px; }
+.grid img { width: 100%; aspect-ratio: 1; object-fit: cover; display: block; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<div class="grid">
+{{- range .Items}}
+<a href="{{.URL}}" target="_blank" rel="noopener"{{with .Credit}} title="{{.}}"{{end}}><img src="{{or .Thumbnail .URL}}" alt="{{.Name}}" loading="lazy"></a>
+{{- end}}
+</div>
+</body>
+</html>
+`))
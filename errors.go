@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"drive-gallery/backend"
+)
+
+// writeBackendError writes err from the backend as a JSON error: backend.ErrNotFound as 404 with
+// notFoundMessage, backend.ErrConflict as 409, and anything else as 500 with internalMessage.
+// Both messages are already translated.
+func writeBackendError(w http.ResponseWriter, r *http.Request, err error, notFoundMessage, internalMessage string) {
+	status, message := http.StatusInternalServerError, internalMessage
+	var existsErr *backend.FolderExistsError
+	var archivedErr *backend.ArchivedFolderError
+	var holdErr *backend.LegalHoldError
+	switch {
+	case errors.Is(err, backend.ErrNotFound):
+		status, message = http.StatusNotFound, notFoundMessage
+	case errors.As(err, &existsErr):
+		status, message = http.StatusConflict, tr(r, "Folder '%s' already exists", existsErr.Name)
+	case errors.As(err, &archivedErr):
+		status, message = http.StatusConflict, tr(r, "Folder '%s' is archived and read-only", archivedErr.Name)
+	case errors.As(err, &holdErr):
+		status, message = http.StatusConflict, tr(r, "Under legal hold, so it cannot be deleted: %v", holdErr)
+	case errors.Is(err, backend.ErrConflict):
+		status, message = http.StatusConflict, tr(r, "Conflict: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"drive-gallery/backend"
+)
+
+// parseFields reads the fields query parameter of a listing, e.g. fields=name,downloadUrl,thumbnailUrl:
+// the JSON names of the fields of v, a struct or slice of structs, to return. The "id" is always
+// returned, as clients key items and pages by it. It returns nil without the parameter, and writes
+// a 400 response and returns false if a name is unknown.
+func parseFields(w http.ResponseWriter, r *http.Request, v interface{}) ([]string, bool) {
+	param := r.URL.Query().Get("fields")
+	if param == "" {
+		return nil, true
+	}
+	known := backend.JSONFieldNames(v)
+	fields := []string{"id"}
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "id" {
+			continue
+		}
+		if _, ok := known[name]; !ok {
+			names := make([]string, 0, len(known))
+			for n := range known {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unknown field '%s' (available: %s)", name, strings.Join(names, ", "))})
+			return nil, false
+		}
+		fields = append(fields, name)
+	}
+	return fields, true
+}
+
+// projectFields returns items, a slice of structs, with every item trimmed to fields, or items
+// itself if fields is nil.
+func projectFields(items interface{}, fields []string) interface{} {
+	if fields == nil {
+		return items
+	}
+	v := reflect.ValueOf(items)
+	projected := make([]map[string]json.RawMessage, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		projected = append(projected, projectItem(v.Index(i).Interface(), fields))
+	}
+	return projected
+}
+
+// projectItem returns the JSON fields of item named in fields; those omitted as empty stay omitted.
+func projectItem(item interface{}, fields []string) map[string]json.RawMessage {
+	var all map[string]json.RawMessage
+	encoded, err := json.Marshal(item)
+	if err == nil {
+		err = json.Unmarshal(encoded, &all)
+	}
+	out := make(map[string]json.RawMessage, len(fields))
+	if err != nil {
+		return out // Only for types json cannot encode, which listings don't return
+	}
+	for _, name := range fields {
+		if value, ok := all[name]; ok {
+			out[name] = value
+		}
+	}
+	return out
+}
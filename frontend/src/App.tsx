@@ -14,6 +14,17 @@ interface FileMetadata {
   folderId: string; // Corresponds to a logical folder
   hash: string; // SHA256 hash for deduplication
   createdAt: string; // ISO string for time.Time
+  capturedAt?: string; // Shoot date (falls back to file modification time); absent on older files
+  originalPath?: string; // Absolute path on the uploading machine
+  size?: number; // Bytes; absent on older files until backfilled
+  mediaType?: string; // "image", "video" or "other"
+  thumbnailUrl?: string; // Backend path of the image thumbnail; signed and short-lived for private folders
+  srcset?: Record<string, string>; // Image URLs by width ("320", "768", "1600") and "original"
+  uploaderUid?: string; // Firebase Auth UID of the signed-in uploader
+  color?: string; // Dominant color of an image ("#rrggbb"), shown while the thumbnail loads
+  width?: number; // Image size in pixels
+  height?: number;
+  duration?: number; // Video length in seconds (MP4 and MOV)
 }
 
 // Define the structure for the paginated response from backend
@@ -26,18 +37,72 @@ interface PaginatedFilesResponse {
 interface FolderMetadata {
   id: string;
   name: string;
+  slug?: string; // Used in public links: /g/{slug}
+  names?: { ja?: string; en?: string }; // Localized display names
   createdAt: string; // ISO string for time.Time
+  archived?: boolean; // Read-only: uploads, deletes and edits are rejected
 }
 
+// An entry of a folder's change log (GET /api/folders/{id}/changes)
+interface FolderChange {
+  id: string;
+  type: 'added' | 'removed' | 'edited';
+  fileId?: string; // Absent for changes of the folder itself
+  name?: string;
+  fields?: string[]; // Fields an edit changed
+  actor?: string; // UID of the signed-in user who made the change
+  cursor: string;
+  at: string; // ISO string for time.Time
+}
+
+interface FolderChanges {
+  changes: FolderChange[];
+  cursor: string; // Pass as since to get the changes after these
+  hasMore: boolean;
+}
+
+const changeLabels: Record<FolderChange['type'], string> = { added: '追加', removed: '削除', edited: '編集' };
+
+// Language of localized folder names, from the browser's preferences
+const folderNameLang = navigator.language.toLowerCase().startsWith('ja') ? 'ja' : 'en';
+
+// The caller's role and permissions (GET /api/me), used to show only the actions they may take
+interface Me {
+  signedIn: boolean;
+  role: 'viewer' | 'editor' | 'admin';
+  permissions: string[]; // e.g. "upload", "edit", "manage_profiles"
+  uid?: string;
+  email?: string;
+}
+
+function useMe() {
+  return useQuery<Me, Error>({
+    queryKey: ['me'],
+    queryFn: async () => {
+      const response = await fetch(`${import.meta.env.VITE_API_BASE_URL}/api/me`);
+      if (!response.ok) {
+        const errorData = await response.json();
+        throw new Error(errorData.error || `HTTP error! status: ${response.status}`);
+      }
+      const result = await response.json();
+      return result.data;
+    },
+    staleTime: 60 * 1000, // Stored roles apply within a minute
+  });
+}
+
+// Reports whether the caller may do what permission allows; nothing is allowed until /api/me answers
+const can = (me: Me | undefined, permission: string) => !!me?.permissions.includes(permission);
+
 // --- HomePage Component ---
 function HomePage() {
   const navigate = useNavigate();
 
   const { data: folders, isLoading, error } = useQuery<FolderMetadata[], Error>({
-    queryKey: ['folders'],
+    queryKey: ['folders', folderNameLang],
     queryFn: async () => {
       console.log('Fetching folders...');
-      const response = await fetch(`${import.meta.env.VITE_API_BASE_URL}/api/folders`);
+      const response = await fetch(`${import.meta.env.VITE_API_BASE_URL}/api/folders?lang=${folderNameLang}`);
       if (!response.ok) {
         const errorData = await response.json();
         throw new Error(errorData.error || `HTTP error! status: ${response.status}`);
@@ -70,11 +135,11 @@ function HomePage() {
             <li key={folder.id} className="folder-item" 
                 onClick={() => {
                   console.log(`Item clicked for folder: ${folder.name}, ID: ${folder.id}`);
-                  navigate(`/folder/${folder.id}`);
+                  navigate(folder.slug ? `/g/${folder.slug}` : `/folder/${folder.id}`);
                 }}
                 style={{ cursor: 'pointer' }}
             >
-              📁 {folder.name}
+              📁 {folder.name}{folder.archived && ' 🔒'}
             </li>
           ))}
         </ul>
@@ -83,15 +148,46 @@ function HomePage() {
   );
 }
 
+// --- FolderBySlugPage Component ---
+// Resolves a public link (/g/{slug}) to its folder and shows it.
+function FolderBySlugPage() {
+  const { slug } = useParams<{ slug: string }>();
+
+  const { data: folder, isLoading, error } = useQuery<FolderMetadata, Error>({
+    queryKey: ['folderBySlug', slug],
+    queryFn: async () => {
+      const response = await fetch(`${import.meta.env.VITE_API_BASE_URL}/api/folders/by-slug/${encodeURIComponent(slug || '')}`);
+      if (!response.ok) {
+        const errorData = await response.json();
+        throw new Error(errorData.error || `HTTP error! status: ${response.status}`);
+      }
+      const result = await response.json();
+      return result.data;
+    },
+    enabled: !!slug,
+  });
+
+  if (isLoading) {
+    return <div className="page-container">Loading folder...</div>;
+  }
+
+  if (error || !folder) {
+    return <div className="page-container">Error fetching folder: {error?.message}</div>;
+  }
+
+  return <FolderPage folderId={folder.id} />;
+}
+
 // --- FolderPage Component ---
-function FolderPage() {
+function FolderPage({ folderId: folderIdProp }: { folderId?: string } = {}) {
   const params = useParams(); // params will now contain a key like '*'
-  const folderId = params['*']; // Get the full path after /folder/
+  const folderId = folderIdProp ?? params['*']; // Given by FolderBySlugPage, or the full path after /folder/
   console.log('FolderPage: folderId from params:', folderId); // Add this log
   const [selectedVideoUrl, setSelectedVideoUrl] = useState<string | null>(null); // Store URL directly
   const [selectedImageUrl, setSelectedImageUrl] = useState<string | null>(null); // Store URL for selected image
   const [filter, setFilter] = useState<'all' | 'image' | 'video'>('all');
   const queryClient = useQueryClient();
+  const { data: me } = useMe();
 
   // Pagination states
   const [currentPageToken, setCurrentPageToken] = useState<string>('');
@@ -131,10 +227,10 @@ function FolderPage() {
 
   // Fetch folder name using React Query
   const { data: folderName, isLoading: isLoadingFolderName, error: folderNameError } = useQuery<string, Error>({
-    queryKey: ['folderName', folderId],
+    queryKey: ['folderName', folderId, folderNameLang],
     queryFn: async () => {
       if (!folderId) throw new Error('Folder ID is missing');
-      const response = await fetch(`${import.meta.env.VITE_API_BASE_URL}/api/folder-name/${folderId}`);
+      const response = await fetch(`${import.meta.env.VITE_API_BASE_URL}/api/folder-name/${folderId}?lang=${folderNameLang}`);
       if (!response.ok) {
         const errorData = await response.json();
         throw new Error(errorData.error || `HTTP error! status: ${response.status}`);
@@ -167,6 +263,20 @@ function FolderPage() {
     enabled: !!folderId,
   });
 
+  // The latest changes of the folder, shown as its activity feed
+  const { data: folderChanges } = useQuery<FolderChanges, Error>({
+    queryKey: ['changes', folderId],
+    queryFn: async () => {
+      const response = await fetch(`${import.meta.env.VITE_API_BASE_URL}/api/folders/${folderId}/changes?limit=10`);
+      if (!response.ok) {
+        const errorData = await response.json();
+        throw new Error(errorData.error || `HTTP error! status: ${response.status}`);
+      }
+      return (await response.json()).data;
+    },
+    enabled: !!folderId,
+  });
+
   // Extract files and nextPageToken from the data
   const files = data?.data || [];
   const nextPageToken = data?.nextPageToken || '';
@@ -306,14 +416,76 @@ function FolderPage() {
   const [uploadProgress, setUploadProgress] = useState(0);
   const [uploadStatus, setUploadStatus] = useState('');
 
+  // Splits a selected file's path into the folder it is uploaded to and its path inside it
+  const uploadTarget = (file: File) => {
+    const webkitRelativePath = (file as any).webkitRelativePath || file.name; // Fallback to file.name if webkitRelativePath is not available
+
+    // Extract the top-level folder name from webkitRelativePath
+    let folderName = '';
+    let relativePath = webkitRelativePath;
+
+    const pathParts = webkitRelativePath.split('/');
+    if (pathParts.length > 1) {
+      folderName = pathParts[0];
+      relativePath = pathParts.slice(1).join('/');
+    } else {
+      // If it's a single file not in a folder, use a default folder name or handle as root
+      // For now, let's use the current folderId as the folderName if it's a single file upload
+      // Or, if the user explicitly selects a folder, the first part of webkitRelativePath is the folder name.
+      // If it's just a file, we can use a generic "Uploaded Files" folder or the current folderId.
+      // Given the user wants "第1回" etc., we should ensure folderName is derived from the selected folder.
+      // If a single file is selected without a folder, we might need a different approach or prompt the user.
+      // For now, if webkitRelativePath has no slashes, assume it's a file directly in the target folder.
+      // The user's request implies uploading *folders*, so webkitRelativePath will likely have a folder name.
+      folderName = folderId || 'Uploaded Files'; // Fallback if no folder context
+      relativePath = file.name; // Use original file name as relative path
+    }
+    return { folderName, relativePath };
+  };
+
   const handleFileChange = (event: React.ChangeEvent<HTMLInputElement>) => {
     if (event.target.files) {
-      setSelectedFiles(Array.from(event.target.files));
+      const files = Array.from(event.target.files);
+      setSelectedFiles(files);
+      checkStoredFiles(files);
     } else {
       setSelectedFiles([]);
     }
   };
 
+  // Tells the user how many of the selected files are already stored, before any bytes move
+  const checkStoredFiles = async (files: File[]) => {
+    if (files.length === 0 || !crypto.subtle) {
+      return;
+    }
+    setUploadStatus('アップロード済みのファイルを確認中...');
+    try {
+      let stored = 0;
+      for (let start = 0; start < files.length; start += 500) { // The backend's per-request limit
+        const batch = files.slice(start, start + 500);
+        const entries = [];
+        for (const file of batch) { // One at a time, so large folders are not read into memory at once
+          const digest = await crypto.subtle.digest('SHA-256', await file.arrayBuffer());
+          const hash = Array.from(new Uint8Array(digest), (b) => b.toString(16).padStart(2, '0')).join('');
+          entries.push({ hash, size: file.size, relativePath: uploadTarget(file).relativePath });
+        }
+        const response = await fetch(`${import.meta.env.VITE_API_BASE_URL}/api/upload/check`, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ folder_name: uploadTarget(batch[0]).folderName, files: entries }),
+        });
+        if (!response.ok) {
+          throw new Error(`HTTP error! status: ${response.status}`);
+        }
+        stored += (await response.json()).data.stored;
+      }
+      setUploadStatus(stored > 0 ? `${files.length} 件中 ${stored} 件は既にアップロード済みです。` : '');
+    } catch (error) {
+      console.error('アップロード済みファイルの確認に失敗しました:', error);
+      setUploadStatus('');
+    }
+  };
+
   // Mutation for uploading files
   const uploadFileMutation = useMutation({
     mutationFn: async ({ file, folderName, relativePath }: { file: File; folderName: string; relativePath: string }) => {
@@ -356,28 +528,7 @@ function FolderPage() {
 
     for (let i = 0; i < selectedFiles.length; i++) {
       const file = selectedFiles[i];
-      const webkitRelativePath = (file as any).webkitRelativePath || file.name; // Fallback to file.name if webkitRelativePath is not available
-
-      // Extract the top-level folder name from webkitRelativePath
-      let folderName = '';
-      let relativePath = webkitRelativePath;
-
-      const pathParts = webkitRelativePath.split('/');
-      if (pathParts.length > 1) {
-        folderName = pathParts[0];
-        relativePath = pathParts.slice(1).join('/');
-      } else {
-        // If it's a single file not in a folder, use a default folder name or handle as root
-        // For now, let's use the current folderId as the folderName if it's a single file upload
-        // Or, if the user explicitly selects a folder, the first part of webkitRelativePath is the folder name.
-        // If it's just a file, we can use a generic "Uploaded Files" folder or the current folderId.
-        // Given the user wants "第1回" etc., we should ensure folderName is derived from the selected folder.
-        // If a single file is selected without a folder, we might need a different approach or prompt the user.
-        // For now, if webkitRelativePath has no slashes, assume it's a file directly in the target folder.
-        // The user's request implies uploading *folders*, so webkitRelativePath will likely have a folder name.
-        folderName = folderId || 'Uploaded Files'; // Fallback if no folder context
-        relativePath = file.name; // Use original file name as relative path
-      }
+      const { folderName, relativePath } = uploadTarget(file);
 
       try {
         await uploadFileMutation.mutateAsync({ file, folderName, relativePath });
@@ -410,11 +561,59 @@ function FolderPage() {
   useEffect(() => {
     if (!folderId) return;
     const ws = new WebSocket(`${import.meta.env.VITE_API_BASE_URL.replace('http', 'ws')}/ws`);
-    ws.onopen = () => console.log(`WebSocket connection established for folder context: ${folderId}`);
+    ws.onopen = () => {
+      console.log(`WebSocket connection established for folder context: ${folderId}`);
+      // Only this folder's events; profile events are not shown on this page
+      ws.send(JSON.stringify({
+        type: 'filter',
+        eventTypes: ['file_uploaded', 'files_uploaded', 'file_deleted', 'folder_created', 'drive_file_added', 'drive_file_updated', 'drive_file_removed'],
+        folderIds: [folderId],
+      }));
+      // Events sent while disconnected are lost; the change log tells whether the cached files are stale
+      const cursor = queryClient.getQueryData<FolderChanges>(['changes', folderId])?.cursor;
+      if (cursor) {
+        fetch(`${import.meta.env.VITE_API_BASE_URL}/api/folders/${folderId}/changes?since=${encodeURIComponent(cursor)}`)
+          .then((response) => (response.ok ? response.json() : null))
+          .then((result: { data?: FolderChanges } | null) => {
+            if (result?.data && result.data.changes.length > 0) {
+              queryClient.invalidateQueries({ queryKey: ['files', folderId] });
+              queryClient.invalidateQueries({ queryKey: ['changes', folderId] });
+            }
+          })
+          .catch((error) => console.error('Error checking folder changes:', error));
+      }
+    };
     ws.onmessage = (event) => {
       console.log('WebSocket message received on FolderPage:', event.data);
-      queryClient.invalidateQueries({ queryKey: ['files', folderId] });
-      queryClient.invalidateQueries({ queryKey: ['folderName', folderId] });
+      let message: { type?: string; data?: { folderId?: string } } = {};
+      try {
+        message = JSON.parse(event.data);
+      } catch {
+        // Untyped message: refresh everything shown on this page
+      }
+      switch (message.type) {
+        case 'file_uploaded':
+        case 'files_uploaded':
+          if (message.data?.folderId === folderId) {
+            queryClient.invalidateQueries({ queryKey: ['files', folderId] });
+            queryClient.invalidateQueries({ queryKey: ['changes', folderId] });
+          }
+          break;
+        case 'file_deleted':
+          queryClient.invalidateQueries({ queryKey: ['files', folderId] });
+          queryClient.invalidateQueries({ queryKey: ['changes', folderId] });
+          break;
+        case 'folder_created':
+        case 'folder_updated':
+          queryClient.invalidateQueries({ queryKey: ['folders'] });
+          break;
+        case 'profile_updated':
+        case 'filter_applied':
+          break;
+        default:
+          queryClient.invalidateQueries({ queryKey: ['files', folderId] });
+          queryClient.invalidateQueries({ queryKey: ['folderName', folderId] });
+      }
     };
     ws.onerror = (error) => console.error('WebSocket error on FolderPage:', error);
     ws.onclose = (event) => console.log(`WebSocket connection closed on FolderPage: Code=${event.code}, Reason='${event.reason}'`);
@@ -447,7 +646,12 @@ function FolderPage() {
       );
     } else {
       if (file.mimeType.startsWith('image/')) {
-        return <img src={file.downloadUrl} alt={file.name} className="media-preview" loading="lazy" onClick={() => handleFileClick(file)} />;
+        const previewUrl = file.thumbnailUrl ? `${import.meta.env.VITE_API_BASE_URL}${file.thumbnailUrl}` : file.downloadUrl;
+        const srcSet = file.srcset && Object.entries(file.srcset)
+          .filter(([width]) => width !== 'original')
+          .map(([width, url]) => `${import.meta.env.VITE_API_BASE_URL}${url} ${width}w`)
+          .join(', ');
+        return <img src={previewUrl} srcSet={srcSet || undefined} sizes="(max-width: 600px) 50vw, 320px" alt={file.name} className="media-preview" loading="lazy" style={file.color ? { backgroundColor: file.color } : undefined} onClick={() => handleFileClick(file)} />;
       } else if (file.mimeType.startsWith('video/') || file.mimeType.startsWith('audio/')) {
         return (
           <div style={{ position: 'relative', width: '100%', height: '150px' }}>
@@ -526,19 +730,20 @@ function FolderPage() {
       </div>
 
       {/* File Upload Section */}
-      {/* File Upload Section */}
-      <div className="file-upload-section">
-        {/* @ts-ignore */}
-        <input type="file" onChange={handleFileChange} webkitdirectory="true" directory="true" multiple />
-        <button 
-          onClick={handleUploadAllFiles} 
-          disabled={selectedFiles.length === 0 || uploading}
-        >
-          {uploading ? `アップロード中... (${uploadProgress}%)` : 'フォルダ/ファイルをアップロード'}
-        </button>
-        {uploading && <p>進捗: {uploadProgress}%</p>}
-        {uploadStatus && <p>{uploadStatus}</p>}
-      </div>
+      {can(me, 'upload') && (
+        <div className="file-upload-section">
+          {/* @ts-ignore */}
+          <input type="file" onChange={handleFileChange} webkitdirectory="true" directory="true" multiple />
+          <button 
+            onClick={handleUploadAllFiles} 
+            disabled={selectedFiles.length === 0 || uploading}
+          >
+            {uploading ? `アップロード中... (${uploadProgress}%)` : 'フォルダ/ファイルをアップロード'}
+          </button>
+          {uploading && <p>進捗: {uploadProgress}%</p>}
+          {uploadStatus && <p>{uploadStatus}</p>}
+        </div>
+      )}
 
       {selectedVideoUrl && (
         <div className="selected-video-container">
@@ -600,6 +805,20 @@ function FolderPage() {
         
         <button onClick={handleNextPage} disabled={!hasNextPage}>次へ</button>
       </div>
+
+      {folderChanges && folderChanges.changes.length > 0 && (
+        <div className="folder-changes">
+          <h2>最近の変更</h2>
+          <ul>
+            {[...folderChanges.changes].reverse().map((change) => (
+              <li key={change.id}>
+                {new Date(change.at).toLocaleString()} {changeLabels[change.type]}: {change.name || change.fileId}
+                {change.fields && change.fields.length > 0 && ` (${change.fields.join(', ')})`}
+              </li>
+            ))}
+          </ul>
+        </div>
+      )}
     </div>
   );
 }
@@ -610,14 +829,20 @@ interface Profile {
   name: string;
   bio: string;
   icon_url: string;
+  archived?: boolean; // Former member, hidden from the lineup
+  order?: number; // Position in the lineup; 0 until reordered
 }
 
 // --- ProfileList Component ---
 function ProfileList() {
+  const queryClient = useQueryClient();
+  const [showArchived, setShowArchived] = useState(false);
+  const { data: me } = useMe();
+  const canManage = can(me, 'manage_profiles');
   const { data: profiles, isLoading, error } = useQuery<Profile[], Error>({
-    queryKey: ['profiles'],
+    queryKey: ['profiles', showArchived],
     queryFn: async () => {
-      const response = await fetch(`${import.meta.env.VITE_API_BASE_URL}/api/profiles`);
+      const response = await fetch(`${import.meta.env.VITE_API_BASE_URL}/api/profiles${showArchived ? '?includeArchived=true' : ''}`);
       if (!response.ok) {
         const errorData = await response.json();
         throw new Error(errorData.error || `HTTP error! status: ${response.status}`);
@@ -627,6 +852,30 @@ function ProfileList() {
     },
   });
 
+  const reorderMutation = useMutation({
+    mutationFn: async (ids: string[]) => {
+      const response = await fetch(`${import.meta.env.VITE_API_BASE_URL}/api/profiles/reorder`, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ ids }),
+      });
+      if (!response.ok) {
+        throw new Error(await response.text() || `HTTP error! status: ${response.status}`);
+      }
+    },
+    onSuccess: () => queryClient.invalidateQueries({ queryKey: ['profiles'] }),
+    onError: (reorderError) => alert(`並び替えに失敗しました: ${reorderError}`),
+  });
+
+  // Swaps a profile with its neighbour and saves the new lineup
+  const moveProfile = (index: number, offset: number) => {
+    const ids = (profiles || []).map((p) => p.id!);
+    const target = index + offset;
+    if (target < 0 || target >= ids.length) return;
+    [ids[index], ids[target]] = [ids[target], ids[index]];
+    reorderMutation.mutate(ids);
+  };
+
   if (isLoading) {
     return <div className="page-container">Loading profiles...</div>;
   }
@@ -639,19 +888,32 @@ function ProfileList() {
     <div className="page-container">
       <h1>メンバープロフィール</h1>
       <p className="breadcrumb-link"><Link to="/">↩ トップページに戻る</Link></p>
-      <Link to="/profiles/new/edit" className="add-profile-link">新しいプロフィールを追加</Link>
+      {canManage && <Link to="/profiles/new/edit" className="add-profile-link">新しいプロフィールを追加</Link>}
+      <label className="show-archived-toggle">
+        <input type="checkbox" checked={showArchived} onChange={(e) => setShowArchived(e.target.checked)} />
+        過去のメンバーも表示
+      </label>
       {(profiles || []).length === 0 ? (
         <p>プロフィールが見つかりませんでした。</p>
       ) : (
         <div className="profile-grid">
-          {(profiles || []).map((profile) => (
-            <div key={profile.id} className="profile-card">
+          {(profiles || []).map((profile, index) => (
+            <div key={profile.id} className={`profile-card${profile.archived ? ' archived' : ''}`}>
               <img src={profile.icon_url || '/vite.svg'} alt={profile.name} className="profile-icon" />
               <h2>{profile.name}</h2>
               <div className="profile-bio">
                 <ReactMarkdown>{profile.bio}</ReactMarkdown>
               </div>
-              <Link to={`/profiles/${profile.id}/edit`} className="edit-profile-link">編集</Link>
+              {profile.archived && <p className="archived-label">過去のメンバー</p>}
+              {canManage && (
+                <>
+                  <Link to={`/profiles/${profile.id}/edit`} className="edit-profile-link">編集</Link>
+                  <div className="profile-order-buttons">
+                    <button onClick={() => moveProfile(index, -1)} disabled={index === 0 || reorderMutation.isPending} aria-label="前へ">↑</button>
+                    <button onClick={() => moveProfile(index, 1)} disabled={index === (profiles || []).length - 1 || reorderMutation.isPending} aria-label="後へ">↓</button>
+                  </div>
+                </>
+              )}
             </div>
           ))}
         </div>
@@ -665,6 +927,7 @@ function ProfileEditForm() {
   const { id } = useParams<{ id: string }>();
   const navigate = useNavigate();
   const queryClient = useQueryClient();
+  const { data: me } = useMe();
 
   const isNew = id === 'new';
   const profileId: string | null = isNew ? null : (id || null);
@@ -675,6 +938,7 @@ function ProfileEditForm() {
 
   const [name, setName] = useState('');
   const [bio, setBio] = useState('');
+  const [archived, setArchived] = useState(false);
   const [iconFile, setIconFile] = useState<File | null>(null);
   const [currentIconUrl, setCurrentIconUrl] = useState<string>('');
 
@@ -698,6 +962,7 @@ function ProfileEditForm() {
       console.log('ProfileEditForm: existingProfile data received:', existingProfile);
       setName(existingProfile.name);
       setBio(existingProfile.bio);
+      setArchived(!!existingProfile.archived);
       setCurrentIconUrl(existingProfile.icon_url || '');
     } else {
       console.log('ProfileEditForm: existingProfile is null or undefined.');
@@ -800,9 +1065,9 @@ function ProfileEditForm() {
         if (!createdProfileId) {
           throw new Error('Failed to get ID for new profile.');
         }
-        profileToSave = { ...createdProfile, name, bio };
+        profileToSave = { ...createdProfile, name, bio, archived };
       } else {
-        profileToSave = { name, bio, icon_url: currentIconUrl, id: profileId! };
+        profileToSave = { name, bio, icon_url: currentIconUrl, archived, id: profileId! };
       }
 
       if (iconFile && createdProfileId) {
@@ -848,6 +1113,10 @@ function ProfileEditForm() {
     return <div className="page-container">Error fetching profile: {profileError.message}</div>;
   }
 
+  if (me && !can(me, 'manage_profiles')) {
+    return <div className="page-container">プロフィールを編集する権限がありません。</div>;
+  }
+
   return (
     <div className="page-container">
       <h1>{isNew ? '新しいプロフィールを作成' : `${name}のプロフィールを編集`}</h1>
@@ -889,6 +1158,17 @@ function ProfileEditForm() {
           />
           {iconFile && <p>選択中のファイル: {iconFile.name}</p>}
         </div>
+        <div className="form-group">
+          <label htmlFor="archived">
+            <input
+              type="checkbox"
+              id="archived"
+              checked={archived}
+              onChange={(e) => setArchived(e.target.checked)}
+            />
+            過去のメンバー (一覧に表示せず、履歴として残す)
+          </label>
+        </div>
         <div className="form-actions">
           <button type="submit" disabled={createProfileMutation.isPending || updateProfileMutation.isPending || uploadIconMutation.isPending}>
             {createProfileMutation.isPending || updateProfileMutation.isPending || uploadIconMutation.isPending ? '保存中...' : '保存'}
@@ -913,6 +1193,7 @@ function App() {
         <Routes>
           <Route path="/" element={<HomePage />} />
           <Route path="/folder/*" element={<FolderPage />} />
+          <Route path="/g/:slug" element={<FolderBySlugPage />} />
           <Route path="/profiles" element={<ProfileList />} />
           <Route path="/profiles/:id/edit" element={<ProfileEditForm />} />
         </Routes>
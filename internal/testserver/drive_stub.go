@@ -0,0 +1,210 @@
+package testserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// DriveStub is an in-memory stand-in for the parts of the Drive API v3 the backend calls:
+// getting, listing and creating files, and the batch endpoint. The backend is pointed at it with
+// DRIVE_API_ENDPOINT. Query parameters other than the parent of a listing, such as fields, are
+// ignored: whole files are returned.
+type DriveStub struct {
+	URL string
+
+	server *httptest.Server
+	mu     sync.Mutex
+	files  map[string]*drive.File
+	nextID int
+}
+
+// NewDriveStub starts a Drive stub; Close stops it.
+func NewDriveStub() *DriveStub {
+	s := &DriveStub{files: make(map[string]*drive.File)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/drive/v3/files", s.handleList)
+	mux.HandleFunc("/drive/v3/files/", s.handleGet)
+	mux.HandleFunc("/upload/drive/v3/files", s.handleCreate)
+	mux.HandleFunc("/batch/drive/v3", s.handleBatch)
+	s.server = httptest.NewServer(mux)
+	s.URL = s.server.URL
+	return s
+}
+
+// Close stops the stub.
+func (s *DriveStub) Close() {
+	s.server.Close()
+}
+
+// AddFile stores a file, e.g. before simulating a webhook notification about it, and returns it
+// with its ID, given one if it has none.
+func (s *DriveStub) AddFile(file drive.File) *drive.File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if file.Id == "" {
+		s.nextID++
+		file.Id = fmt.Sprintf("stub-file-%d", s.nextID)
+	}
+	if file.CreatedTime == "" {
+		file.CreatedTime = time.Now().UTC().Format(time.RFC3339)
+	}
+	s.files[file.Id] = &file
+	return &file
+}
+
+// RemoveFile deletes a file, so the backend's lookups of it fail with 404.
+func (s *DriveStub) RemoveFile(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, id)
+}
+
+// File returns a stored file, or nil.
+func (s *DriveStub) File(id string) *drive.File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.files[id]
+}
+
+func (s *DriveStub) handleGet(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/drive/v3/files/")
+	if file := s.File(id); file != nil {
+		writeJSON(w, http.StatusOK, file)
+		return
+	}
+	writeDriveError(w, http.StatusNotFound, "File not found: "+id)
+}
+
+// handleList answers listings with the files of the parent in a "'<id>' in parents" query, in one page.
+func (s *DriveStub) handleList(w http.ResponseWriter, r *http.Request) {
+	parent := ""
+	if q := r.URL.Query().Get("q"); strings.Contains(q, "in parents") {
+		if start := strings.Index(q, "'"); start >= 0 {
+			if end := strings.Index(q[start+1:], "'"); end >= 0 {
+				parent = q[start+1 : start+1+end]
+			}
+		}
+	}
+	s.mu.Lock()
+	files := []*drive.File{}
+	for _, file := range s.files {
+		for _, p := range file.Parents {
+			if parent == "" || p == parent {
+				files = append(files, file)
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, &drive.FileList{Files: files})
+}
+
+// handleCreate stores the metadata part of a multipart upload; the content is discarded.
+func (s *DriveStub) handleCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeDriveError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var file drive.File
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		writeDriveError(w, http.StatusBadRequest, "Only multipart uploads are supported")
+		return
+	}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	for i := 0; ; i++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeDriveError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if i == 0 {
+			if err := json.NewDecoder(part).Decode(&file); err != nil {
+				writeDriveError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			continue
+		}
+		n, _ := io.Copy(io.Discard, part)
+		file.Size = n
+		if file.MimeType == "" {
+			file.MimeType = part.Header.Get("Content-Type")
+		}
+	}
+	file.Id = ""
+	writeJSON(w, http.StatusOK, s.AddFile(file))
+}
+
+// handleBatch answers each "GET /drive/v3/files/{id}" part of a batch request like handleGet.
+func (s *DriveStub) handleBatch(w http.ResponseWriter, r *http.Request) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		writeDriveError(w, http.StatusBadRequest, "Batch requests must be multipart/mixed")
+		return
+	}
+	var body bytes.Buffer
+	out := multipart.NewWriter(&body)
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeDriveError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		line, _ := bufio.NewReader(part).ReadString('\n')
+		fields := strings.Fields(line)
+		status, payload := http.StatusBadRequest, interface{}(driveError(http.StatusBadRequest, "Unsupported batch request: "+strings.TrimSpace(line)))
+		if len(fields) >= 2 && fields[0] == http.MethodGet && strings.HasPrefix(fields[1], "/drive/v3/files/") {
+			id, _, _ := strings.Cut(strings.TrimPrefix(fields[1], "/drive/v3/files/"), "?")
+			if file := s.File(id); file != nil {
+				status, payload = http.StatusOK, file
+			} else {
+				status, payload = http.StatusNotFound, driveError(http.StatusNotFound, "File not found: "+id)
+			}
+		}
+		encoded, _ := json.Marshal(payload)
+		contentID := "<response-" + strings.Trim(part.Header.Get("Content-ID"), "<>") + ">"
+		w, err := out.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/http"}, "Content-ID": {contentID}})
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "HTTP/1.1 %d %s\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", status, http.StatusText(status), len(encoded), encoded)
+	}
+	out.Close()
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+out.Boundary())
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
+
+func driveError(code int, message string) map[string]interface{} {
+	return map[string]interface{}{"error": map[string]interface{}{"code": code, "message": message}}
+}
+
+func writeDriveError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, driveError(code, message))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
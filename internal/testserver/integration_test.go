@@ -0,0 +1,80 @@
+package testserver_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"drive-gallery/backend"
+	"drive-gallery/internal/testserver"
+)
+
+// startServer starts a backend against the emulators, skipping the test without them.
+func startServer(t *testing.T) *testserver.Server {
+	t.Helper()
+	cfg, ok := testserver.ConfigFromEnv()
+	if !ok {
+		t.Skip("FIRESTORE_EMULATOR_HOST and STORAGE_EMULATOR_HOST are not set")
+	}
+	return testserver.Start(t, cfg)
+}
+
+func TestUploadListDelete(t *testing.T) {
+	srv := startServer(t)
+	token := srv.IDToken("integration-editor", backend.RoleEditor)
+	folderName := fmt.Sprintf("Integration %d", time.Now().UnixNano()) // Unique, as emulator data is kept
+	content := []byte("drive-gallery integration test\n")
+	sum := sha256.Sum256(content)
+
+	file := srv.Upload(t, token, folderName, "notes/hello.txt", content)
+	if file.ID == "" || file.FolderID == "" {
+		t.Fatalf("uploaded file has no ID or folder: %+v", file)
+	}
+	if file.Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("hash = %q, want %x", file.Hash, sum)
+	}
+	if file.UploaderUID != "integration-editor" {
+		t.Errorf("uploaderUid = %q, want integration-editor", file.UploaderUID)
+	}
+
+	found := false
+	for _, folder := range srv.Folders(t) {
+		if folder.ID == file.FolderID {
+			found = true
+			if folder.Name != folderName {
+				t.Errorf("folder name = %q, want %q", folder.Name, folderName)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("folder %s of the upload is not listed", file.FolderID)
+	}
+
+	files := srv.ListFiles(t, file.FolderID)
+	if len(files) != 1 || files[0].ID != file.ID {
+		t.Fatalf("listed %d files (%+v), want only %s", len(files), files, file.ID)
+	}
+	if files[0].MediaURL == "" {
+		t.Errorf("listed file has no mediaUrl: %+v", files[0])
+	}
+
+	srv.DeleteFiles(t, token, file.ID)
+	if files := srv.ListFiles(t, file.FolderID); len(files) != 0 {
+		t.Errorf("listed %d files after deleting the upload, want none", len(files))
+	}
+}
+
+func TestDeleteFilesNeedsSignIn(t *testing.T) {
+	srv := startServer(t)
+	file := srv.Upload(t, srv.IDToken("integration-editor", backend.RoleEditor), fmt.Sprintf("Integration %d", time.Now().UnixNano()), "a.txt", []byte("a"))
+
+	if code := srv.Do(t, http.MethodPost, "/api/files/batch-delete", "", map[string]interface{}{"ids": []string{file.ID}}, nil, http.StatusUnauthorized); code != http.StatusUnauthorized {
+		t.Errorf("anonymous batch delete returned %d, want %d", code, http.StatusUnauthorized)
+	}
+	if files := srv.ListFiles(t, file.FolderID); len(files) != 1 {
+		t.Errorf("listed %d files after a rejected delete, want 1", len(files))
+	}
+}
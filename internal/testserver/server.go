@@ -0,0 +1,394 @@
+// Package testserver runs the HTTP API end to end for integration tests: the backend binary,
+// built from this module, against the Firestore emulator, a fake GCS server
+// (github.com/fsouza/fake-gcs-server) and a DriveStub, with helpers to upload, list and delete
+// files through the API as the frontend and CLI do.
+//
+// The emulators run outside the test, e.g.
+//
+//	gcloud emulators firestore start --host-port=localhost:8081
+//	docker run -p 4443:4443 fsouza/fake-gcs-server -scheme http -public-host localhost:4443
+//
+// and are found through FIRESTORE_EMULATOR_HOST and STORAGE_EMULATOR_HOST; tests skip when those
+// are not set:
+//
+//	cfg, ok := testserver.ConfigFromEnv()
+//	if !ok {
+//		t.Skip("FIRESTORE_EMULATOR_HOST and STORAGE_EMULATOR_HOST are not set")
+//	}
+//	srv := testserver.Start(t, cfg)
+//	file := srv.Upload(t, "", "Trip", "beach.jpg", jpegBytes)
+package testserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"drive-gallery/backend"
+)
+
+// Defaults for Config fields left empty.
+const (
+	DefaultProjectID        = "drive-gallery-test"
+	DefaultBucket           = "drive-gallery-test.appspot.com"
+	DefaultAuthEmulatorHost = "localhost:9099" // Only verifies tokens locally; nothing needs to listen there
+)
+
+// startTimeout bounds how long Start waits for the backend to become ready.
+const startTimeout = 30 * time.Second
+
+// Config says where the emulators are and how to run the backend.
+type Config struct {
+	FirestoreEmulatorHost string // host:port of the Firestore emulator
+	StorageEmulatorHost   string // URL of the fake GCS server, e.g. "http://localhost:4443"
+	AuthEmulatorHost      string // host:port of the Auth emulator; see IDToken
+	ProjectID             string
+	Bucket                string
+	Env                   []string // Extra "KEY=value" environment of the backend, e.g. "DEV_MODE=true"
+}
+
+// ConfigFromEnv returns the Config for the emulators in FIRESTORE_EMULATOR_HOST,
+// STORAGE_EMULATOR_HOST and FIREBASE_AUTH_EMULATOR_HOST, and false if the first two are not set.
+func ConfigFromEnv() (Config, bool) {
+	cfg := Config{
+		FirestoreEmulatorHost: os.Getenv("FIRESTORE_EMULATOR_HOST"),
+		StorageEmulatorHost:   os.Getenv("STORAGE_EMULATOR_HOST"),
+		AuthEmulatorHost:      os.Getenv("FIREBASE_AUTH_EMULATOR_HOST"),
+	}
+	return cfg, cfg.FirestoreEmulatorHost != "" && cfg.StorageEmulatorHost != ""
+}
+
+// Server is a running backend.
+type Server struct {
+	URL   string     // Base URL of the API, e.g. "http://127.0.0.1:54321"
+	Drive *DriveStub // The Drive API the backend talks to
+
+	cfg    Config
+	client *http.Client
+}
+
+var (
+	buildOnce sync.Once
+	binary    string
+	buildErr  error
+)
+
+// buildBackend builds the backend binary once per test process.
+func buildBackend() (string, error) {
+	buildOnce.Do(func() {
+		_, file, _, _ := runtime.Caller(0)
+		root := filepath.Join(filepath.Dir(file), "..", "..")
+		dir, err := os.MkdirTemp("", "drive-gallery-testserver")
+		if err != nil {
+			buildErr = err
+			return
+		}
+		binary = filepath.Join(dir, "drive-gallery")
+		cmd := exec.Command("go", "build", "-o", binary, ".")
+		cmd.Dir = root
+		if out, err := cmd.CombinedOutput(); err != nil {
+			buildErr = fmt.Errorf("failed to build backend: %v\n%s", err, out)
+		}
+	})
+	return binary, buildErr
+}
+
+// Start builds and starts the backend against the emulators of cfg and a new DriveStub, creating
+// the bucket on the fake GCS server, and waits until /readyz answers. Everything is stopped when
+// the test ends. Data of earlier tests stays in the emulators unless they call Reset.
+func Start(tb testing.TB, cfg Config) *Server {
+	tb.Helper()
+	if cfg.ProjectID == "" {
+		cfg.ProjectID = DefaultProjectID
+	}
+	if cfg.Bucket == "" {
+		cfg.Bucket = DefaultBucket
+	}
+	if cfg.AuthEmulatorHost == "" {
+		cfg.AuthEmulatorHost = DefaultAuthEmulatorHost
+	}
+	cfg.StorageEmulatorHost = strings.TrimRight(cfg.StorageEmulatorHost, "/")
+	if !strings.Contains(cfg.StorageEmulatorHost, "://") {
+		cfg.StorageEmulatorHost = "http://" + cfg.StorageEmulatorHost
+	}
+
+	bin, err := buildBackend()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := createBucket(cfg.StorageEmulatorHost, cfg.ProjectID, cfg.Bucket); err != nil {
+		tb.Fatal(err)
+	}
+	port, err := freePort()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	drive := NewDriveStub()
+	tb.Cleanup(drive.Close)
+
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(),
+		"PORT="+port,
+		"GCP_PROJECT="+cfg.ProjectID,
+		"FIREBASE_STORAGE_BUCKET="+cfg.Bucket,
+		"FIRESTORE_EMULATOR_HOST="+cfg.FirestoreEmulatorHost,
+		"STORAGE_EMULATOR_HOST="+cfg.StorageEmulatorHost,
+		"FIREBASE_AUTH_EMULATOR_HOST="+cfg.AuthEmulatorHost,
+		"DRIVE_API_ENDPOINT="+drive.URL,
+		"GOOGLE_APPLICATION_CREDENTIALS=",
+	)
+	cmd.Env = append(cmd.Env, cfg.Env...)
+	var logs bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &logs, &logs
+	if err := cmd.Start(); err != nil {
+		tb.Fatalf("failed to start backend: %v", err)
+	}
+	tb.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if tb.Failed() {
+			tb.Logf("backend output:\n%s", logs.String())
+		}
+	})
+
+	s := &Server{URL: "http://127.0.0.1:" + port, Drive: drive, cfg: cfg, client: &http.Client{Timeout: time.Minute}}
+	deadline := time.Now().Add(startTimeout)
+	for {
+		resp, err := s.client.Get(s.URL + "/readyz")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return s
+			}
+		}
+		if time.Now().After(deadline) {
+			tb.Fatalf("backend not ready after %s (last error: %v)\n%s", startTimeout, err, logs.String())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// createBucket creates the bucket on the fake GCS server, which keeps an existing one.
+func createBucket(host, projectID, bucket string) error {
+	body, _ := json.Marshal(map[string]string{"name": bucket})
+	resp, err := http.Post(host+"/storage/v1/b?project="+url.QueryEscape(projectID), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to reach fake GCS server at %s: %v", host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusConflict {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to create bucket %s: %s: %s", bucket, resp.Status, msg)
+	}
+	return nil
+}
+
+// freePort returns a TCP port nothing listens on right now.
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	return port, err
+}
+
+// Reset deletes every document in the Firestore emulator, for tests that need an empty gallery.
+// Objects on the fake GCS server are left, as nothing lists them without their metadata.
+func (s *Server) Reset(tb testing.TB) {
+	tb.Helper()
+	u := fmt.Sprintf("http://%s/emulator/v1/projects/%s/databases/(default)/documents", s.cfg.FirestoreEmulatorHost, s.cfg.ProjectID)
+	req, _ := http.NewRequest(http.MethodDelete, u, nil)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		tb.Fatalf("failed to reset Firestore emulator: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		tb.Fatalf("failed to reset Firestore emulator: %s", resp.Status)
+	}
+}
+
+// IDToken returns a Firebase ID token for the user uid with the given role (backend.RoleAdmin,
+// backend.RoleEditor or ""), for the Authorization header. It is unsigned, which the backend
+// accepts only when talking to the Auth emulator, as Start makes it do.
+func (s *Server) IDToken(uid, role string) string {
+	now := time.Now().Unix()
+	claims := map[string]interface{}{
+		"iss":       "https://securetoken.google.com/" + s.cfg.ProjectID,
+		"aud":       s.cfg.ProjectID,
+		"sub":       uid,
+		"user_id":   uid,
+		"iat":       now,
+		"auth_time": now,
+		"exp":       now + 3600,
+		"firebase":  map[string]interface{}{"sign_in_provider": "custom"},
+	}
+	if role != "" {
+		claims["role"] = role
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "none", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+// Do sends a request to the API, with body encoded as JSON unless it is nil or an io.Reader, and
+// an ID token if token is not empty. If out is not nil, the "data" of the response is decoded into
+// it. It returns the status code; responses of 400 and above fail the test unless expected.
+func (s *Server) Do(tb testing.TB, method, path, token string, body, out interface{}, expected ...int) int {
+	tb.Helper()
+	var reader io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = b
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			tb.Fatalf("failed to encode request body: %v", err)
+		}
+		reader, contentType = bytes.NewReader(encoded), "application/json"
+	}
+	req, err := http.NewRequest(method, s.URL+path, reader)
+	if err != nil {
+		tb.Fatalf("failed to build request %s %s: %v", method, path, err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return s.send(tb, req, out, expected)
+}
+
+func (s *Server) send(tb testing.TB, req *http.Request, out interface{}, expected []int) int {
+	tb.Helper()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		tb.Fatalf("%s %s failed: %v", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		tb.Fatalf("failed to read response of %s %s: %v", req.Method, req.URL.Path, err)
+	}
+	ok := resp.StatusCode < 400
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			ok = true
+		}
+	}
+	if !ok {
+		tb.Fatalf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, raw)
+	}
+	if out != nil && resp.StatusCode < 400 {
+		var envelope struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(raw, &envelope); err != nil {
+			tb.Fatalf("failed to decode response of %s %s: %v: %s", req.Method, req.URL.Path, err, raw)
+		}
+		if err := json.Unmarshal(envelope.Data, out); err != nil {
+			tb.Fatalf("failed to decode data of %s %s: %v: %s", req.Method, req.URL.Path, err, envelope.Data)
+		}
+	}
+	return resp.StatusCode
+}
+
+// Upload uploads a file into the folder named folderName through /api/upload/file, at
+// relativePath within it, as the user of token, and returns its metadata. Anonymous uploads (token
+// "") need "PUBLIC_UPLOADS=true" or "ENFORCE_ROLES=false" in Config.Env, like those of users
+// without a role.
+func (s *Server) Upload(tb testing.TB, token, folderName, relativePath string, content []byte) backend.FileMetadata {
+	tb.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("folder_name", folderName)
+	form.WriteField("relative_path", relativePath)
+	part, err := form.CreateFormFile("file", filepath.Base(relativePath))
+	if err != nil {
+		tb.Fatalf("failed to build upload of %s: %v", relativePath, err)
+	}
+	part.Write(content)
+	form.Close()
+
+	req, err := http.NewRequest(http.MethodPost, s.URL+"/api/upload/file", &body)
+	if err != nil {
+		tb.Fatalf("failed to build upload of %s: %v", relativePath, err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	var file backend.FileMetadata
+	s.send(tb, req, &file, nil)
+	return file
+}
+
+// Folders lists the folders of the gallery through /api/folders.
+func (s *Server) Folders(tb testing.TB) []backend.FolderMetadata {
+	tb.Helper()
+	var folders []backend.FolderMetadata
+	s.Do(tb, http.MethodGet, "/api/folders", "", nil, &folders)
+	return folders
+}
+
+// ListFiles lists every file of the folder with the given ID through /api/files/{id}, following
+// pagination.
+func (s *Server) ListFiles(tb testing.TB, folderID string) []backend.FileMetadata {
+	tb.Helper()
+	var all []backend.FileMetadata
+	pageToken := ""
+	for {
+		path := "/api/files/" + url.PathEscape(folderID) + "?pageSize=100"
+		if pageToken != "" {
+			path += "&pageToken=" + url.QueryEscape(pageToken)
+		}
+		req, _ := http.NewRequest(http.MethodGet, s.URL+path, nil)
+		resp, err := s.client.Do(req)
+		if err != nil {
+			tb.Fatalf("GET %s failed: %v", path, err)
+		}
+		var page struct {
+			Data          []backend.FileMetadata `json:"data"`
+			NextPageToken string                 `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || err != nil {
+			tb.Fatalf("GET %s: %s (%v)", path, resp.Status, err)
+		}
+		all = append(all, page.Data...)
+		if page.NextPageToken == "" || len(page.Data) == 0 {
+			return all
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// DeleteFiles deletes files through /api/files/batch-delete as the user of token, who must be
+// allowed to (see IDToken), and returns the result reported for each file.
+func (s *Server) DeleteFiles(tb testing.TB, token string, ids ...string) json.RawMessage {
+	tb.Helper()
+	var result json.RawMessage
+	s.Do(tb, http.MethodPost, "/api/files/batch-delete", token, map[string]interface{}{"ids": ids}, &result)
+	return result
+}
@@ -3,11 +3,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io" // Add io import
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -18,59 +21,3114 @@ import (
 )
 
 func main() {
+	// Keep recent error lines for GET /api/admin/stats
+	log.SetOutput(io.MultiWriter(os.Stderr, backend.RecentErrors))
+
 	if err := godotenv.Load(); err != nil {
 		log.Printf("WARNING: Error loading .env file: %v (This is normal if not running locally with a .env file)", err)
 	}
 
-	serviceAccountJSONPath := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
-	projectID := os.Getenv("GCP_PROJECT")
-	if projectID == "" {
-		projectID = "drivegallery-460509" // Fallback for local testing if GCP_PROJECT is not set
+	buildInfo := backend.GetBuildInfo()
+	log.Printf("Starting drive-gallery backend version %s (commit %s, built %s, %s)", buildInfo.Version, buildInfo.ShortCommit(), buildInfo.BuildTime, buildInfo.GoVersion)
+
+	frameAncestorsPolicy = buildFrameAncestorsPolicy(os.Getenv("EMBED_ORIGINS"))
+
+	// Signed URLs of private folders must verify on every instance, except in local development
+	if err := backend.CheckThumbnailSigningKey(devMode || os.Getenv("FIRESTORE_EMULATOR_HOST") != ""); err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+
+	serviceAccountJSONPath := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	projectID := os.Getenv("GCP_PROJECT")
+	if projectID == "" {
+		projectID = "drivegallery-460509" // Fallback for local testing if GCP_PROJECT is not set
+	}
+
+	ctx := context.Background()
+	err := backend.InitFirebase(ctx, projectID, serviceAccountJSONPath)
+	if err != nil {
+		log.Printf("ERROR: Unable to initialize Firebase: %v. Exiting in 30s.", err)
+		time.Sleep(30 * time.Second)
+		os.Exit(1)
+	}
+	// Drive is only needed for webhook notifications and the /api/drive endpoints, so the backend runs without it
+	if err := backend.InitDrive(ctx, serviceAccountJSONPath); err != nil {
+		log.Printf("WARNING: Unable to initialize Google Drive client: %v", err)
+	}
+
+	// Search falls back to the nameSearch field of Firestore when no search service is configured
+	backend.InitSearchIndexer()
+
+	// Faces are only grouped with FACE_GROUPING=enabled, as it is biometric processing
+	backend.InitFaceGrouping()
+
+	// Media requests are logged only with ACCESS_LOG_SINK set, and never for viewers opting out
+	if err := backend.InitAccessLog(ctx); err != nil {
+		log.Printf("WARNING: Access log disabled: %v", err)
+	}
+
+	// Set up HTTP routes. Uploads and operations on many files get the longer deadline;
+	// the WebSocket connection is long-lived and has none. API routes are admitted within the
+	// concurrency limit of their class (see concurrency.go).
+	http.HandleFunc("/api/folders", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, foldersHandler)))
+	http.HandleFunc("/api/folders/by-slug/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, folderBySlugHandler)))
+	http.HandleFunc("/api/folders/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, folderActionHandler)))
+	http.HandleFunc("/api/manifests/verify", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, verifyManifestHandler)))
+	http.HandleFunc("/api/offline-manifest", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, offlineManifestHandler)))
+	http.HandleFunc("/api/sync", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, syncHandler)))
+	http.HandleFunc("/api/jobs/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, jobHandler)))
+	http.HandleFunc("/api/files/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, filesHandler)))
+	http.HandleFunc("/api/files/exists", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, fileExistsHandler)))
+	http.HandleFunc("/api/thumbnails/", withConcurrencyLimit(routeThumbnail, withAccessLog("thumbnail", "/api/thumbnails/", withTimeout(requestTimeout, thumbnailHandler))))
+	http.HandleFunc("/api/media/", withConcurrencyLimit(routeMedia, withAccessLog("media", "/api/media/", withIdleTimeout(requestTimeout, mediaHandler))))
+	http.HandleFunc("/api/me", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, meHandler)))
+	http.HandleFunc("/api/me/files", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, myFilesHandler)))
+	http.HandleFunc("/api/files/batch-delete", withConcurrencyLimit(routeBulk, withTimeout(uploadTimeout, batchDeleteFilesHandler)))
+	http.HandleFunc("/api/admin/download-urls", withConcurrencyLimit(routeBulk, withTimeout(uploadTimeout, regenerateDownloadURLsHandler)))
+	http.HandleFunc("/api/admin/mime-types/reconcile", withConcurrencyLimit(routeBulk, withTimeout(requestTimeout, reconcileMimeTypesHandler)))
+	http.HandleFunc("/api/admin/orientation/normalize", withConcurrencyLimit(routeBulk, withTimeout(requestTimeout, normalizeOrientationHandler)))
+	http.HandleFunc("/api/admin/search/reindex", withConcurrencyLimit(routeBulk, withTimeout(requestTimeout, searchReindexHandler)))
+	http.HandleFunc("/api/admin/thumbnails/warm", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, warmThumbnailsHandler)))
+	http.HandleFunc("/api/admin/stats", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, adminStatsHandler)))
+	http.HandleFunc("/api/admin/concurrency", adminConcurrencyHandler) // Not limited, so saturation can be inspected
+	http.HandleFunc("/api/admin/ws", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, adminWebSocketHandler)))
+	http.HandleFunc("/api/admin/duplicates", withConcurrencyLimit(routeBulk, withTimeout(uploadTimeout, adminDuplicatesHandler)))
+	http.HandleFunc("/api/admin/dead-letters", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, deadLettersHandler)))
+	http.HandleFunc("/api/admin/pending", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, adminPendingHandler)))
+	http.HandleFunc("/api/admin/uploads/approve", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, approveUploadsHandler)))
+	http.HandleFunc("/api/admin/tag-rules", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, tagRulesHandler)))
+	http.HandleFunc("/api/admin/webhooks", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, outgoingWebhooksHandler)))
+	http.HandleFunc("/api/admin/webhooks/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, outgoingWebhookHandler)))
+	http.HandleFunc("/api/admin/users", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, usersHandler)))
+	http.HandleFunc("/api/admin/users/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, userRoleHandler)))
+	http.HandleFunc("/api/admin/tag-rules/evaluate", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, evaluateTagRulesHandler)))
+	http.HandleFunc("/api/admin/files/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, adminFileActionHandler)))
+	http.HandleFunc("/api/admin/folders/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, adminFolderActionHandler)))
+	http.HandleFunc("/api/admin/reports/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, resolveReportHandler)))
+	http.HandleFunc("/api/admin/faces", withConcurrencyLimit(routeBulk, withTimeout(uploadTimeout, purgeFacesHandler)))
+	http.HandleFunc("/api/admin/faces/clusters", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, faceClustersHandler)))
+	http.HandleFunc("/api/admin/faces/clusters/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, faceClusterHandler)))
+	http.HandleFunc("/api/admin/dead-letters/replay", withConcurrencyLimit(routeBulk, withTimeout(uploadTimeout, replayDeadLettersHandler)))
+	http.HandleFunc("/api/folder-name/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, folderNameHandler)))
+	http.HandleFunc("/api/stats/folders/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, folderStatsHandler)))
+	http.HandleFunc("/api/slideshow", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, slideshowHandler)))
+	http.HandleFunc("/api/profiles", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, profilesHandler)))
+	http.HandleFunc("/api/profiles/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, profileHandler)))
+	http.HandleFunc("/api/profiles/reorder", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, profilesReorderHandler)))
+	http.HandleFunc("/api/upload/icon", withConcurrencyLimit(routeUpload, withTimeout(uploadTimeout, uploadIconHandler)))
+	http.HandleFunc("/api/upload/file", withConcurrencyLimit(routeUpload, withTimeout(uploadTimeout, uploadFileHandler))) // New file upload handler
+	http.HandleFunc("/api/upload/check", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, uploadCheckHandler)))
+	http.HandleFunc("/api/upload/signed-urls", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, signedUploadURLsHandler)))
+	http.HandleFunc("/api/upload/finalize", withConcurrencyLimit(routeUpload, withTimeout(uploadTimeout, finalizeUploadsHandler)))
+	http.HandleFunc("/api/drive/folders", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, driveFoldersHandler)))
+	http.HandleFunc("/api/drive/files/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, driveFilesHandler)))
+	http.HandleFunc("/api/drive/thumbnail/", withConcurrencyLimit(routeThumbnail, withTimeout(requestTimeout, driveThumbnailHandler)))
+	http.HandleFunc("/api/drive/upload", withConcurrencyLimit(routeUpload, withTimeout(uploadTimeout, driveUploadHandler)))
+	http.HandleFunc("/api/import/drive-zip", withConcurrencyLimit(routeBulk, withTimeout(requestTimeout, importDriveZipHandler)))
+	http.HandleFunc("/api/update/file-metadata", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, updateFileMetadataHandler))) // New metadata update handler
+	http.HandleFunc("/embed/", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, embedHandler)))
+	http.HandleFunc("/webhook", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, webhookHandler)))
+	if devMode {
+		log.Printf("WARNING: DEV_MODE is on; /api/dev endpoints are enabled without authentication")
+		http.HandleFunc("/api/dev/simulate-webhook", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, simulateWebhookHandler)))
+	}
+	http.HandleFunc("/ws", wsHandler)
+	http.HandleFunc("/api/version", withConcurrencyLimit(routeDefault, withTimeout(requestTimeout, versionHandler)))
+	http.HandleFunc("/readyz", readyzHandler)
+
+	backend.InitHub()
+
+	// Combine the file_uploaded events of bulk uploads into one files_uploaded digest per folder
+	uploadDigestWindow := defaultUploadDigestWindow
+	if window := os.Getenv("UPLOAD_DIGEST_WINDOW"); window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil || d < 0 {
+			log.Printf("WARNING: Invalid UPLOAD_DIGEST_WINDOW %q, using %s", window, uploadDigestWindow)
+		} else {
+			uploadDigestWindow = d
+		}
+	}
+	backend.SetUploadDigestWindow(uploadDigestWindow)
+
+	// Fallback cleanup of expired temporary documents when Firestore TTL policies are not enabled
+	if interval := os.Getenv("TTL_SWEEP_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			log.Printf("WARNING: Invalid TTL_SWEEP_INTERVAL %q, expiry sweeper disabled", interval)
+		} else {
+			backend.StartExpirySweeper(ctx, d)
+			log.Printf("Expiry sweeper running every %s", d)
+		}
+	}
+
+	// Drive notifications whose Date is further off than this are rejected as replays
+	backend.SetDriveWebhookMaxSkew(durationFromEnv("DRIVE_WEBHOOK_MAX_SKEW", backend.DefaultDriveWebhookMaxSkew))
+	if token := os.Getenv("DRIVE_WEBHOOK_TOKEN"); token != "" {
+		backend.SetDriveWebhookToken(token)
+	} else {
+		log.Printf("WARNING: DRIVE_WEBHOOK_TOKEN is not set; Drive notifications will be rejected")
+	}
+
+	// Zips imported from Drive are spooled to TMPDIR, so keep them within its space (memory on Cloud Run)
+	backend.SetDriveZipLimits(int64(intFromEnv("DRIVE_ZIP_MAX_MIB", backend.DefaultDriveZipMaxMiB))<<20,
+		int64(intFromEnv("DRIVE_ZIP_MAX_ENTRY_MIB", backend.DefaultDriveZipMaxEntryMiB))<<20)
+
+	// Rebuild the Firebase clients when Firestore or Storage keep failing, instead of needing a restart
+	backend.StartHealthMonitor(ctx, projectID, serviceAccountJSONPath, durationFromEnv("HEALTH_CHECK_INTERVAL", defaultHealthCheckInterval))
+
+	// Sign the URLs of private folders that keep being listed ahead of each signing window
+	backend.StartSignedURLRefresher(ctx)
+
+	// Publish the folders scheduled with publishAt and expire those with expireAt on time, warning
+	// FOLDER_EXPIRY_WARNING before expiries
+	backend.SetFolderExpiryWarning(durationFromEnv("FOLDER_EXPIRY_WARNING", backend.DefaultFolderExpiryWarning))
+	backend.StartFolderScheduler(ctx, durationFromEnv("FOLDER_SCHEDULER_INTERVAL", defaultFolderSchedulerInterval))
+
+	if err := serve(withAuthz(http.DefaultServeMux)); err != nil {
+		log.Fatal("ListenAndServe: ", err)
+	}
+}
+
+func setCorsHeaders(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Be more specific in production
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Goog-Channel-ID, X-Goog-Channel-Token, X-Goog-Resource-State, X-Goog-Resource-ID, X-Goog-Message-Number")
+	// Allow embedding from self and the configured embed origins (EMBED_ORIGINS)
+	w.Header().Set("Content-Security-Policy", frameAncestorsPolicy)
+	// Expose the running build on every response so error reports can be matched to a deployment
+	w.Header().Set("X-Drive-Gallery-Version", versionHeader)
+	w.Header().Set("Access-Control-Expose-Headers", "X-Drive-Gallery-Version, X-Total-Count")
+}
+
+// versionHeader is the value of the X-Drive-Gallery-Version response header, e.g. "v1.2.0 (abc1234)".
+var versionHeader = func() string {
+	info := backend.GetBuildInfo()
+	return fmt.Sprintf("%s (%s)", info.Version, info.ShortCommit())
+}()
+
+// versionHandler returns the build metadata of the running backend.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(backend.GetBuildInfo())
+}
+
+// defaultUploadDigestWindow is how long uploads to a folder are collected into one files_uploaded
+// event; override with UPLOAD_DIGEST_WINDOW ("0" sends every file_uploaded event immediately).
+const defaultUploadDigestWindow = 5 * time.Second
+
+// defaultFolderSchedulerInterval is how often the folders due for publication or expiry are
+// looked up; override with FOLDER_SCHEDULER_INTERVAL.
+const defaultFolderSchedulerInterval = time.Minute
+
+// defaultHealthCheckInterval is how often Firestore and Storage are checked; override with HEALTH_CHECK_INTERVAL.
+const defaultHealthCheckInterval = 30 * time.Second
+
+// readyzHandler reports whether Firestore and Storage are reachable, for load balancer and
+// Cloud Run readiness probes. It returns 503 while the backend is not ready.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	health := backend.Health()
+	status := http.StatusOK
+	if !health.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": health})
+}
+
+// adminStatsHandler returns an overview of the gallery for the admin dashboard.
+func adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := backend.GetGalleryStats(r.Context())
+	if err != nil {
+		log.Printf("Error getting gallery stats: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to get gallery stats: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": stats})
+}
+
+// adminDuplicatesHandler reports groups of visually near-identical images (burst shots,
+// re-encodes) in a folder (folderId) or the whole gallery. distance overrides the largest
+// perceptual hash distance within a group.
+func adminDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireModerator(w, r); !ok {
+		return
+	}
+
+	distance := backend.NearDuplicateDistance
+	if distanceStr := r.URL.Query().Get("distance"); distanceStr != "" {
+		d, err := strconv.Atoi(distanceStr)
+		if err != nil || d < 0 || d > 64 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "distance must be between 0 and 64")})
+			return
+		}
+		distance = d
+	}
+
+	groups, err := backend.FindNearDuplicates(r.Context(), r.URL.Query().Get("folderId"), distance)
+	if err != nil {
+		log.Printf("Error finding near-duplicates: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to find near-duplicates: %v", err)})
+		return
+	}
+	if groups == nil {
+		groups = [][]backend.FileMetadata{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": groups})
+}
+
+// adminWebSocketHandler lists the connected WebSocket clients and the hub's delivery counters.
+func adminWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, err := backend.GetHubStatus(r.Context())
+	if err != nil {
+		log.Printf("Error getting WebSocket hub status: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to get WebSocket status: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": status})
+}
+
+func foldersHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	fields, ok := parseFields(w, r, backend.FolderMetadata{})
+	if !ok {
+		return
+	}
+
+	ctx := viewerContext(r)
+	folders, err := backend.ListFoldersFromFirestore(ctx)
+	if err != nil {
+		log.Printf("Error listing folders from Firestore: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to list folders: %v", err)})
+		return
+	}
+	// With ?lang=ja|en, name is the localized display name; names still holds all of them
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		for i := range folders {
+			folders[i].Name = folders[i].LocalizedName(lang)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": projectFields(folders, fields)})
+}
+
+// folderBySlugHandler resolves a folder from the slug used in public links.
+func folderBySlugHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug := strings.TrimPrefix(r.URL.Path, "/api/folders/by-slug/")
+	if slug == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Slug is missing in path")})
+		return
+	}
+
+	folder, err := backend.FindFolderBySlug(r.Context(), slug)
+	if err != nil {
+		log.Printf("Error finding folder by slug %s: %v", slug, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to find folder: %v", err)})
+		return
+	}
+	if folder != nil && !backend.IsFolderVisible(viewerContext(r), folder) {
+		folder = nil // Embargoed until its publishAt
+	}
+	if folder == nil {
+		// Links to a slug the folder had before it was renamed are redirected
+		if renamed, err := backend.FindFolderByPreviousSlug(r.Context(), slug); err != nil {
+			log.Printf("Error finding folder by previous slug %s: %v", slug, err)
+		} else if renamed != nil && renamed.Slug != "" {
+			http.Redirect(w, r, "/api/folders/by-slug/"+url.PathEscape(renamed.Slug), http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Folder not found")})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": folder})
+}
+
+func filesHandler(w http.ResponseWriter, r *http.Request) {
+	// /api/files/{fileId}/report, /transform and /people, DELETE /api/files/{fileId} and
+	// /api/files/{folderId}/count share the prefix of the folder listing
+	if r.Method == http.MethodDelete {
+		deleteFileHandler(w, r)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/report") {
+		reportFileHandler(w, r)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/transform") {
+		transformFileHandler(w, r)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/people") {
+		filePeopleHandler(w, r)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/count") {
+		fileCountHandler(w, r)
+		return
+	}
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	folderIDComponent := strings.TrimPrefix(r.URL.Path, "/api/files/")
+	if folderIDComponent == "" { // Allow '/' in folderIDComponent if it's part of the ID
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Folder ID is missing in path")})
+		return
+	}
+	folderID := folderIDComponent
+
+	pageSizeStr := r.URL.Query().Get("pageSize")
+	lastDocID := r.URL.Query().Get("pageToken") // Use pageToken as lastDocID for Firestore pagination
+
+	var pageSize int64 = 100
+	if pageSizeStr != "" {
+		parsedSize, err := strconv.ParseInt(pageSizeStr, 10, 64)
+		if err == nil && parsedSize > 0 {
+			pageSize = parsedSize
+		} else {
+			log.Printf("Invalid pageSize parameter: %s, using default %d", pageSizeStr, pageSize)
+		}
+	}
+
+	filterType := r.URL.Query().Get("filter")
+	sortBy := r.URL.Query().Get("sort") // "capturedAt" to order by shoot date instead of upload date
+	hideNearDuplicates := r.URL.Query().Get("hideNearDuplicates") == "true"
+
+	// Size and dimension filters, e.g. minWidth=1920 for print-quality shots
+	limits := make(map[string]int64)
+	for _, name := range []string{"minWidth", "minHeight", "minSize", "maxSize"} {
+		if v := r.URL.Query().Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "%s must be a non-negative integer", name)})
+				return
+			}
+			limits[name] = n
+		}
+	}
+	constraints := backend.FileConstraints{
+		MinWidth:  int(limits["minWidth"]),
+		MinHeight: int(limits["minHeight"]),
+		MinSize:   limits["minSize"],
+		MaxSize:   limits["maxSize"],
+	}
+	fields, ok := parseFields(w, r, backend.FileMetadata{})
+	if !ok {
+		return
+	}
+
+	ctx := backend.WithFileFields(viewerContext(r), fields)
+	folder, err := backend.GetFolder(ctx, folderID)
+	if err != nil {
+		log.Printf("Error getting folder %s from Firestore: %v", folderID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to list files: %v", err)})
+		return
+	}
+	if folder != nil && !backend.IsFolderVisible(ctx, folder) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Folder not found")})
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), ndjsonContentType) {
+		if hideNearDuplicates {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "hideNearDuplicates is not supported with %s", ndjsonContentType)})
+			return
+		}
+		// Without an explicit pageSize the stream runs to the end of the folder
+		var limit int64
+		if pageSizeStr != "" {
+			limit = pageSize
+		}
+		streamFiles(w, r.WithContext(ctx), folderID, folder, lastDocID, filterType, sortBy, constraints, limit, fields)
+		return
+	}
+	files, newLastDocID, err := backend.ListFilesMatching(ctx, folderID, pageSize, lastDocID, filterType, sortBy, constraints)
+	if err == nil {
+		// Thumbnail paths, and signed short-lived URLs instead of permanent links for private folders
+		err = backend.AttachAccessURLs(folder, files)
+	}
+	if err != nil {
+		log.Printf("Error listing files for folder %s from Firestore: %v", folderID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to list files: %v", err)})
+		return
+	}
+	if hideNearDuplicates {
+		// Within this page only; the page token still continues after the last file read
+		files = backend.HideNearDuplicates(files, backend.NearDuplicateDistance)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":          projectFields(files, fields),
+		"nextPageToken": newLastDocID, // Return newLastDocID as nextPageToken
+	})
+}
+
+// fileCountHandler answers GET and HEAD /api/files/{folderId}/count with how many files of the
+// folder the viewer can list, e.g. for badges, without reading them. The count is sent in the
+// X-Total-Count header, and for GET in the body as well. "filter" ("image" or "video") counts only
+// those.
+func fileCountHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	folderID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/files/"), "/count")
+	filterType := r.URL.Query().Get("filter")
+	if filterType != "" && filterType != "image" && filterType != "video" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "filter must be image or video")})
+		return
+	}
+	count, err := backend.CountFolderFiles(viewerContext(r), folderID, filterType)
+	if err != nil {
+		log.Printf("Error counting files of folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to count files: %v", err))
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.FormatInt(count, 10))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": map[string]interface{}{"folderId": folderID, "count": count},
+	})
+}
+
+// ndjsonContentType is the media type of newline-delimited JSON, one document per line.
+const ndjsonContentType = "application/x-ndjson"
+
+// streamFiles writes the files of a folder listing as NDJSON, flushing every line as it is read
+// from Firestore instead of buffering the listing. To resume an interrupted stream, pass the ID of
+// the last file received as pageToken. The status is sent with the first line, so an error after
+// it ends the stream with an {"error": ...} line instead.
+func streamFiles(w http.ResponseWriter, r *http.Request, folderID string, folder *backend.FolderMetadata, lastDocID, filterType, sortBy string, constraints backend.FileConstraints, limit int64, fields []string) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	started := false
+	err := backend.StreamFilesMatching(r.Context(), folderID, lastDocID, filterType, sortBy, constraints, limit, func(file *backend.FileMetadata) error {
+		files := []backend.FileMetadata{*file}
+		if err := backend.AttachAccessURLs(folder, files); err != nil {
+			return err
+		}
+		if !started {
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		var line interface{} = files[0]
+		if fields != nil {
+			line = projectItem(files[0], fields)
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+		rc.Flush() // A closed connection fails the next write
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error streaming files of folder %s: %v", folderID, err)
+		if !started {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		enc.Encode(map[string]string{"error": tr(r, "Unable to list files: %v", err)})
+		return
+	}
+	if !started {
+		w.WriteHeader(http.StatusOK) // An empty stream
+	}
+}
+
+// myFilesHandler lists the files uploaded by the signed-in caller across all folders, newest
+// first, paginated like filesHandler.
+func myFilesHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	caller, err := requestCaller(r)
+	if err != nil || caller == nil {
+		if err != nil {
+			log.Printf("Rejected request with invalid ID token: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Sign-in required")})
+		return
+	}
+
+	var pageSize int64 = 100
+	if pageSizeStr := r.URL.Query().Get("pageSize"); pageSizeStr != "" {
+		parsedSize, err := strconv.ParseInt(pageSizeStr, 10, 64)
+		if err == nil && parsedSize > 0 {
+			pageSize = parsedSize
+		} else {
+			log.Printf("Invalid pageSize parameter: %s, using default %d", pageSizeStr, pageSize)
+		}
+	}
+
+	fields, ok := parseFields(w, r, backend.FileMetadata{})
+	if !ok {
+		return
+	}
+
+	ctx := backend.WithFileFields(r.Context(), fields)
+	files, nextPageToken, err := backend.ListFilesByUploader(ctx, caller.UID, pageSize, r.URL.Query().Get("pageToken"))
+	if err == nil {
+		err = backend.AttachAccessURLsByFolder(ctx, files)
+	}
+	if err != nil {
+		log.Printf("Error listing files of uploader %s: %v", caller.UID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to list files: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":          projectFields(files, fields),
+		"nextPageToken": nextPageToken,
+	})
+}
+
+// reportFileHandler records a visitor's abuse report about a file:
+// POST /api/files/{fileId}/report with {"reason": "...", "contact": "..."}.
+func reportFileHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/files/"), "/report")
+	var requestBody struct {
+		Reason  string `json:"reason" validate:"required,max=1000"` // backend.MaxReportReasonLength
+		Contact string `json:"contact" validate:"max=200"`          // backend.MaxReportContactLength
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+	reason := strings.TrimSpace(requestBody.Reason)
+	contact := strings.TrimSpace(requestBody.Contact)
+	// Anyone may report; the reporter is recorded when signed in
+	caller, _ := requestCaller(r)
+
+	report, err := backend.ReportFile(r.Context(), fileID, reason, contact, callerUID(caller))
+	if err != nil {
+		log.Printf("Error reporting file %s: %v", fileID, err)
+		writeBackendError(w, r, err, tr(r, "File not found"), tr(r, "Unable to save report: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"id": report.ID, "status": report.Status}})
+}
+
+// transformFileHandler rotates, flips or crops an image in place:
+// POST /api/files/{fileId}/transform with {"operations": [{"op": "rotate", "degrees": 90}, ...]}.
+// The replaced content stays in Storage as a noncurrent version (see backend.TransformFile).
+func transformFileHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := requireModerator(w, r)
+	if !ok {
+		return
+	}
+
+	fileID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/files/"), "/transform")
+	var requestBody struct {
+		Operations []backend.TransformOp `json:"operations" validate:"required,max=20"` // backend.MaxTransformOps
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	file, err := backend.TransformFile(backend.WithActor(r.Context(), caller.UID), fileID, requestBody.Operations)
+	if err != nil {
+		log.Printf("Error transforming file %s: %v", fileID, err)
+		code := 0
+		switch err.(type) {
+		case *backend.InvalidTransformError:
+			code = http.StatusBadRequest
+		case *backend.UnsupportedImageError:
+			code = http.StatusUnsupportedMediaType
+		case *backend.VersioningDisabledError:
+			code = http.StatusConflict
+		}
+		if code == 0 {
+			writeBackendError(w, r, err, tr(r, "File not found"), tr(r, "Unable to transform file: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to transform file: %v", err)})
+		return
+	}
+	files := []backend.FileMetadata{*file}
+	if err := backend.AttachAccessURLsByFolder(r.Context(), files); err != nil {
+		log.Printf("Error attaching access URLs to file %s: %v", fileID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": files[0]})
+}
+
+// filePeopleHandler tags a file with the profiles of the people appearing in it:
+// PUT /api/files/{fileId}/people with {"profileIds": [...]}, replacing the previous tags; an empty
+// list clears them. Listings leave out files showing a profile hidden from public galleries for
+// visitors who are not signed in (see viewerContext).
+func filePeopleHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPut {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	fileID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/files/"), "/people")
+	caller, ok := authorizeFileChanges(w, r, []string{fileID})
+	if !ok {
+		return
+	}
+	var requestBody struct {
+		ProfileIDs []string `json:"profileIds" validate:"max=50"` // backend.MaxFilePeople
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	file, err := backend.SetFilePeople(backend.WithActor(r.Context(), caller.UID), fileID, requestBody.ProfileIDs)
+	if err != nil {
+		log.Printf("Error tagging people of file %s: %v", fileID, err)
+		writeBackendError(w, r, err, tr(r, "File or profile not found"), tr(r, "Unable to update file: %v", err))
+		return
+	}
+	files := []backend.FileMetadata{*file}
+	if err := backend.AttachAccessURLsByFolder(r.Context(), files); err != nil {
+		log.Printf("Error attaching access URLs to file %s: %v", fileID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": files[0]})
+}
+
+// thumbnailHandler serves the JPEG thumbnail of an image, or with ?w= a larger rendition (see
+// backend.ResponsiveWidths). Thumbnails of files in private folders
+// require the signature of a URL from a listing response, which expires after backend.PrivateURLTTL.
+func thumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := strings.TrimPrefix(r.URL.Path, "/api/thumbnails/")
+	if fileID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "File ID is missing in path")})
+		return
+	}
+
+	ctx := r.Context()
+	file, err := backend.GetFile(ctx, fileID)
+	var folder *backend.FolderMetadata
+	if err == nil && file != nil {
+		folder, err = backend.GetFolder(ctx, file.FolderID)
+	}
+	if err != nil {
+		log.Printf("Error getting file %s for thumbnail: %v", fileID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to create thumbnail: %v", err)})
+		return
+	}
+	if file == nil {
+		writeFileNotFound(w, r, fileID)
+		return
+	}
+	if !strings.HasPrefix(file.MimeType, "image/") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "File not found")})
+		return
+	}
+	private := folder != nil && folder.IsPrivate()
+	query := r.URL.Query()
+	if private && !backend.VerifyThumbnailSignature(fileID, query.Get("expires"), query.Get("sig")) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Thumbnail link is invalid or has expired")})
+		return
+	}
+
+	size := backend.ThumbnailSize
+	if width := query.Get("w"); width != "" {
+		size, err = strconv.Atoi(width)
+		if err != nil || !slices.Contains(backend.ResponsiveWidths, size) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "w must be one of %v", backend.ResponsiveWidths)})
+			return
+		}
+	}
+
+	if private {
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(backend.PrivateURLTTL.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	// The content hash identifies the image, so a thumbnail is unchanged as long as it is
+	etag := ""
+	if file.Hash != "" {
+		etag = fmt.Sprintf(`"%s-%d"`, file.Hash, size)
+	}
+	if checkNotModified(w, r, etag, file.CreatedAt) {
+		return
+	}
+
+	thumb, err := backend.FileThumbnail(ctx, file, size)
+	if err != nil {
+		log.Printf("Error creating thumbnail of file %s: %v", fileID, err)
+		code := http.StatusInternalServerError
+		if _, ok := err.(*backend.UnsupportedImageError); ok {
+			code = http.StatusUnsupportedMediaType
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to create thumbnail: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.WriteHeader(http.StatusOK)
+	w.Write(thumb)
+}
+
+// maxExistsHashes bounds the number of hashes accepted by a single /api/files/exists request.
+const maxExistsHashes = 100
+
+// fileExistsHandler reports which of the given SHA-256 content hashes are already stored.
+// Hashes are passed as repeated or comma-separated "hash" query parameters.
+func fileExistsHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var hashes []string
+	seen := make(map[string]bool)
+	for _, value := range r.URL.Query()["hash"] {
+		for _, hash := range strings.Split(value, ",") {
+			hash = strings.ToLower(strings.TrimSpace(hash))
+			if hash == "" || seen[hash] {
+				continue
+			}
+			if !isSHA256Hex(hash) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Invalid SHA-256 hash: %s", hash)})
+				return
+			}
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+	if len(hashes) == 0 || len(hashes) > maxExistsHashes {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Between 1 and %d hash parameters are required", maxExistsHashes)})
+		return
+	}
+
+	ctx := r.Context()
+	found, err := backend.FindFilesByHashes(ctx, hashes)
+	if err != nil {
+		log.Printf("Error checking existing hashes in Firestore: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to check existing files: %v", err)})
+		return
+	}
+
+	missing := []string{}
+	for _, hash := range hashes {
+		if _, ok := found[hash]; !ok {
+			missing = append(missing, hash)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":    found,   // Metadata of already stored files keyed by hash
+		"missing": missing, // Hashes that still need to be uploaded
+	})
+}
+
+// deleteFileHandler deletes a file, DELETE /api/files/{fileId}: its Storage object (unless other
+// files share it) and its Firestore metadata. The same callers as for batch deletes may.
+func deleteFileHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	fileID := strings.TrimPrefix(r.URL.Path, "/api/files/")
+	if fileID == "" || strings.Contains(fileID, "/") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "File not found")})
+		return
+	}
+
+	caller, ok := authorizeFileChanges(w, r, []string{fileID})
+	if !ok {
+		return
+	}
+	ctx := backend.WithActor(r.Context(), caller.UID)
+	file, err := backend.GetFile(ctx, fileID)
+	if err == nil && file == nil {
+		err = backend.ErrNotFound
+	}
+	if err == nil {
+		err = backend.DeleteFileFromStorageAndFirestore(ctx, file.StoragePath, fileID)
+	}
+	if err != nil {
+		log.Printf("Error deleting file %s: %v", fileID, err)
+		writeBackendError(w, r, err, tr(r, "File not found"), tr(r, "Unable to delete files: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": tr(r, "File deleted successfully")})
+}
+
+// maxBatchDeleteFiles bounds the number of files removed by a single /api/files/batch-delete request.
+const maxBatchDeleteFiles = 100
+
+// batchDeleteFilesHandler deletes several files by ID from Storage and Firestore.
+// Files that cannot be deleted are reported individually instead of failing the whole request.
+func batchDeleteFilesHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestBody struct {
+		IDs []string `json:"ids" validate:"required,max=100"` // maxBatchDeleteFiles
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	var ids []string
+	seen := make(map[string]bool)
+	for _, id := range requestBody.IDs {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 || len(ids) > maxBatchDeleteFiles {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Between 1 and %d file IDs are required", maxBatchDeleteFiles)})
+		return
+	}
+
+	// Nothing is deleted unless the caller may delete every file
+	caller, ok := authorizeFileChanges(w, r, ids)
+	if !ok {
+		return
+	}
+
+	ctx := backend.WithActor(r.Context(), caller.UID)
+	result, err := backend.DeleteFilesByIDs(ctx, ids)
+	if err != nil {
+		log.Printf("Error deleting files: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to delete files: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": result})
+}
+
+// regenerateDownloadURLsHandler re-derives the download URLs of the files given by "ids", of the
+// folder given by "folder_id", or of every file if neither is set.
+func regenerateDownloadURLsHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestBody struct {
+		IDs      []string `json:"ids"`
+		FolderID string   `json:"folder_id"`
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	ctx := r.Context()
+	result, err := backend.RegenerateDownloadURLs(ctx, requestBody.IDs, requestBody.FolderID)
+	if err != nil {
+		log.Printf("Error regenerating download URLs: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to regenerate download URLs: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": result})
+}
+
+// reconcileMimeTypesHandler starts a background job that re-sniffs the stored objects of a folder
+// ({"folder_id": "..."}) or of all files ({}) and corrects wrong MIME types; with "dry_run" it
+// only counts them. It returns 202 with the job.
+func reconcileMimeTypesHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireModerator(w, r); !ok {
+		return
+	}
+
+	var requestBody struct {
+		FolderID string `json:"folder_id"`
+		DryRun   bool   `json:"dry_run"`
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	job, err := backend.StartMimeTypeReconciliation(r.Context(), requestBody.FolderID, requestBody.DryRun)
+	if err != nil {
+		log.Printf("Error starting MIME type reconciliation: %v", err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to reconcile MIME types: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": job})
+}
+
+// normalizeOrientationHandler starts a background job dealing with JPEG images stored sideways
+// according to their EXIF orientation, in a folder or (without folder_id) the whole gallery, and
+// returns the job to follow its progress. Mode "rewrite" turns the originals upright, "thumbnails"
+// only their thumbnails.
+func normalizeOrientationHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireModerator(w, r); !ok {
+		return
+	}
+
+	var requestBody struct {
+		FolderID string `json:"folder_id"`
+		Mode     string `json:"mode" validate:"required,oneof=rewrite thumbnails"` // backend.OrientationRewrite or backend.OrientationThumbnails
+		DryRun   bool   `json:"dry_run"`
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	job, err := backend.StartOrientationNormalization(r.Context(), requestBody.FolderID, requestBody.Mode, requestBody.DryRun)
+	if err != nil {
+		log.Printf("Error starting orientation normalization: %v", err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to normalize orientations: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": job})
+}
+
+// searchReindexHandler starts a background job that sends every file and profile to the
+// configured search index, and returns the job to follow its progress.
+func searchReindexHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireModerator(w, r); !ok {
+		return
+	}
+
+	job, err := backend.StartSearchReindex(r.Context())
+	if err != nil {
+		log.Printf("Error starting search re-index: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to re-index search: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": job})
+}
+
+// folderActionHandler dispatches POST /api/folders/{id}/{action}, which editors and admins may
+// call: duplicate, archive and unarchive, and PUT /api/folders/{id}/upload-settings, schedule and
+// expiry.
+func folderActionHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	folderID, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/folders/"), "/")
+	if ok && action == "changes" && r.Method == http.MethodGet {
+		folderChanges(w, r, folderID)
+		return
+	}
+	if ok && action == "snapshot.json" && r.Method == http.MethodGet {
+		folderSnapshot(w, r, folderID)
+		return
+	}
+	if ok && action == "manifest" && r.Method == http.MethodGet {
+		folderManifest(w, r, folderID)
+		return
+	}
+	if ok && action == "credits.txt" && r.Method == http.MethodGet {
+		folderCredits(w, r, folderID)
+		return
+	}
+	if ok && action == "exists" && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		folderExists(w, r, folderID)
+		return
+	}
+	if !ok && r.Method == http.MethodDelete {
+		deleteFolder(w, r, folderID)
+		return
+	}
+	isSettings := ok && action == "upload-settings" && r.Method == http.MethodPut
+	isSchedule := ok && (action == "schedule" || action == "expiry") && r.Method == http.MethodPut
+	if !isSettings && !isSchedule && (r.Method != http.MethodPost || !ok || (action != "duplicate" && action != "rename" && action != "archive" && action != "unarchive")) {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := requireModerator(w, r)
+	if !ok {
+		return
+	}
+	r = r.WithContext(backend.WithActor(r.Context(), caller.UID))
+	if isSettings {
+		setUploadSettings(w, r, folderID)
+	} else if isSchedule && action == "expiry" {
+		setFolderExpiry(w, r, folderID)
+	} else if isSchedule {
+		scheduleFolder(w, r, folderID)
+	} else if action == "duplicate" {
+		duplicateFolder(w, r, folderID)
+	} else if action == "rename" {
+		renameFolder(w, r, folderID)
+	} else {
+		archiveFolder(w, r, folderID, action == "archive")
+	}
+}
+
+// folderChanges returns the change log of a folder after the cursor "since", oldest first, or its
+// latest changes without it. "limit" bounds the page (default 100, at most 500). Folders the caller
+// may not see are 404, like their listings.
+func folderChanges(w http.ResponseWriter, r *http.Request, folderID string) {
+	query := r.URL.Query()
+	limit := backend.DefaultChangesPageSize
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err == nil && parsedLimit >= 1 {
+			limit = parsedLimit
+		} else {
+			log.Printf("Invalid limit parameter: %s, using default %d", limitStr, limit)
+		}
+	}
+
+	changes, err := backend.ListFolderChanges(viewerContext(r), folderID, query.Get("since"), limit)
+	var cursorErr *backend.InvalidCursorError
+	if errors.As(err, &cursorErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Invalid cursor '%s'", cursorErr.Cursor)})
+		return
+	}
+	if err != nil {
+		log.Printf("Error listing changes of folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to list folder changes: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": changes})
+}
+
+// folderSnapshot returns the snapshot of a public folder: its metadata and files as one JSON
+// document (not wrapped in "data"). With "rev" the current revision, as the ETag of the plain URL
+// gives it, the response can be cached forever; an outdated "rev" redirects to the current one.
+func folderSnapshot(w http.ResponseWriter, r *http.Request, folderID string) {
+	data, revision, err := backend.GetFolderSnapshot(r.Context(), folderID)
+	if err != nil {
+		log.Printf("Error getting snapshot of folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to build folder snapshot: %v", err))
+		return
+	}
+
+	etag := `"` + revision + `"`
+	w.Header().Set("ETag", etag)
+	if rev := r.URL.Query().Get("rev"); rev == "" {
+		w.Header().Set("Cache-Control", "no-cache") // Revalidated with the ETag, which is cheap
+	} else if rev != revision {
+		w.Header().Set("Cache-Control", "no-cache")
+		http.Redirect(w, r, "/api/folders/"+url.PathEscape(folderID)+"/snapshot.json?rev="+url.QueryEscape(revision), http.StatusFound)
+		return
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// folderManifest returns the signed manifest of a folder's files, optionally only of those
+// uploaded at or after "since" (RFC 3339), as a receipt of an upload. Folders the caller may not
+// see are 404, like their listings.
+func folderManifest(w http.ResponseWriter, r *http.Request, folderID string) {
+	var since time.Time
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		t, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Invalid since (expected RFC 3339): %v", err)})
+			return
+		}
+		since = t
+	}
+
+	manifest, err := backend.BuildFolderManifest(viewerContext(r), folderID, since)
+	if err != nil {
+		log.Printf("Error building manifest of folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to build folder manifest: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": manifest})
+}
+
+// folderCredits returns the credits.txt of a folder: the photographer and license of its files.
+func folderCredits(w http.ResponseWriter, r *http.Request, folderID string) {
+	credits, err := backend.BuildFolderCredits(viewerContext(r), folderID)
+	if err != nil {
+		log.Printf("Error building credits of folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to build folder credits: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="credits.txt"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(credits)
+}
+
+// folderExists tells whether a folder exists and is visible to the viewer, with a single document
+// read, so deep links can be validated without listing the folder. GET always answers 200 with
+// {"data": {"exists": bool}}; HEAD answers 200 or 404 without a body.
+func folderExists(w http.ResponseWriter, r *http.Request, folderID string) {
+	exists, err := backend.FolderExists(viewerContext(r), folderID)
+	if err != nil {
+		log.Printf("Error checking folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to check folder: %v", err))
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		if exists {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": map[string]interface{}{"id": folderID, "exists": exists},
+	})
+}
+
+// verifyManifestHandler checks a manifest returned by GET /api/folders/{id}/manifest: whether its
+// signature is valid, and which of its files changed or were deleted since.
+func verifyManifestHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var manifest backend.FolderManifest
+	if !decodeJSONBody(w, r, &manifest) {
+		return
+	}
+	result, err := backend.VerifyFolderManifest(r.Context(), &manifest)
+	if err != nil {
+		log.Printf("Error verifying manifest of folder %s: %v", manifest.FolderID, err)
+		writeBackendError(w, r, err, tr(r, "File not found"), tr(r, "Unable to verify manifest: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": result})
+}
+
+// syncHandler returns the changes of all folders, files and profiles after the cursor "since",
+// with the current state of what they touched, for clients keeping an offline cache. Without
+// "since" it returns the current cursor only. "limit" bounds the changes per call (default 500,
+// at most 2000).
+func syncHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := backend.DefaultSyncPageSize
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err == nil && parsedLimit >= 1 {
+			limit = parsedLimit
+		} else {
+			log.Printf("Invalid limit parameter: %s, using default %d", limitStr, limit)
+		}
+	}
+
+	delta, err := backend.Sync(viewerContext(r), query.Get("since"), limit)
+	var cursorErr *backend.InvalidCursorError
+	if errors.As(err, &cursorErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Invalid cursor '%s'", cursorErr.Cursor)})
+		return
+	}
+	if err != nil {
+		log.Printf("Error syncing changes since '%s': %v", query.Get("since"), err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to sync changes: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": delta})
+}
+
+// offlineManifestHandler returns the URLs a service worker precaches to make a folder available
+// offline: the thumbnails of its images, then the originals of the files listed in "originals"
+// (comma-separated IDs), within "budget" bytes (default 200 MiB, at most 2 GiB).
+func offlineManifestHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	folderID := query.Get("folderId")
+	if folderID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "folderId query parameter is required")})
+		return
+	}
+	var budget int64
+	if budgetStr := query.Get("budget"); budgetStr != "" {
+		parsedBudget, err := strconv.ParseInt(budgetStr, 10, 64)
+		if err == nil && parsedBudget >= 1 {
+			budget = parsedBudget
+		} else {
+			log.Printf("Invalid budget parameter: %s, using default %d", budgetStr, backend.DefaultOfflineBudget)
+		}
+	}
+	var originals []string
+	for _, id := range strings.Split(query.Get("originals"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			originals = append(originals, id)
+		}
+	}
+
+	manifest, err := backend.BuildOfflineManifest(viewerContext(r), folderID, budget, originals)
+	if err != nil {
+		log.Printf("Error building offline manifest of folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder or file not found"), tr(r, "Unable to build offline manifest: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": manifest})
+}
+
+// archiveFolder makes a folder read-only, or writable again, and returns it.
+func archiveFolder(w http.ResponseWriter, r *http.Request, folderID string, archived bool) {
+	folder, err := backend.SetFolderArchived(r.Context(), folderID, archived)
+	if err != nil {
+		log.Printf("Error archiving folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to update folder: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": folder})
+}
+
+// deleteFolder deletes a folder with all of its files, in Storage and Firestore, and returns how
+// many files were deleted. Admins only; a folder or file under legal hold returns 409.
+func deleteFolder(w http.ResponseWriter, r *http.Request, folderID string) {
+	caller, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	folder, err := backend.GetFolder(r.Context(), folderID)
+	if err == nil && folder == nil {
+		err = backend.ErrNotFound
+	}
+	deleted := 0
+	if err == nil {
+		deleted, err = backend.DeleteFolder(backend.WithActor(r.Context(), caller.UID), folderID)
+	}
+	if err != nil {
+		log.Printf("Error deleting folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to delete folder: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"id": folderID, "deletedFiles": deleted}})
+}
+
+// setUploadSettings replaces the upload settings of a folder with the body, a
+// backend.UploadSettings; "{}" removes them.
+func setUploadSettings(w http.ResponseWriter, r *http.Request, folderID string) {
+	var settings backend.UploadSettings
+	if !decodeJSONBody(w, r, &settings) {
+		return
+	}
+	for _, mediaType := range settings.AllowedMediaTypes {
+		if !slices.Contains(backend.MediaTypes, mediaType) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Invalid media type '%s' (expected %s)", mediaType, strings.Join(backend.MediaTypes, ", "))})
+			return
+		}
+	}
+
+	folder, err := backend.SetFolderUploadSettings(r.Context(), folderID, settings)
+	if err != nil {
+		log.Printf("Error setting upload settings of folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to update folder: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": folder})
+}
+
+// scheduleFolder sets when a folder is published, {"publishAt": RFC 3339 timestamp}: it stays
+// hidden from public viewers until then. A null publishAt publishes it now.
+func scheduleFolder(w http.ResponseWriter, r *http.Request, folderID string) {
+	var requestBody struct {
+		PublishAt *time.Time `json:"publishAt"`
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	folder, err := backend.SetFolderPublishAt(r.Context(), folderID, requestBody.PublishAt)
+	if err != nil {
+		log.Printf("Error scheduling folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to update folder: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": folder})
+}
+
+// setFolderExpiry makes a folder expire, {"expireAt": RFC 3339 timestamp, "action": "archive" or
+// "hide"}: then it is archived (the default) or hidden from public viewers. A null expireAt
+// removes the expiry.
+func setFolderExpiry(w http.ResponseWriter, r *http.Request, folderID string) {
+	var requestBody struct {
+		ExpireAt *time.Time `json:"expireAt"`
+		Action   string     `json:"action" validate:"omitempty,oneof=archive hide"`
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	folder, err := backend.SetFolderExpireAt(r.Context(), folderID, requestBody.ExpireAt, requestBody.Action)
+	if err != nil {
+		log.Printf("Error setting expiry of folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to update folder: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": folder})
+}
+
+// renameFolder renames a folder to {"name": "..."} along with what is derived from its name (see
+// backend.RenameFolder). Files stored by folder name are moved by the returned job, if any.
+func renameFolder(w http.ResponseWriter, r *http.Request, folderID string) {
+	var requestBody struct {
+		Name string `json:"name" validate:"required,max=200"`
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	rename, err := backend.RenameFolder(r.Context(), folderID, requestBody.Name)
+	if err != nil {
+		log.Printf("Error renaming folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to rename folder: %v", err))
+		return
+	}
+	job, err := backend.StartFolderNameMoves(r.Context(), rename)
+	if err != nil {
+		log.Printf("Error starting the moves of renamed folder %s: %v", folderID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+		"folder": rename.Folder, "oldName": rename.OldName, "oldSlug": rename.OldSlug, "driveFolderId": rename.DriveFolderID, "job": job,
+	}})
+}
+
+// duplicateFolder creates the folder named in {"name": "..."} and copies the files of folderID
+// into it in the background. It returns 202 with the new folder and the job tracking the copy.
+func duplicateFolder(w http.ResponseWriter, r *http.Request, folderID string) {
+
+	var requestBody struct {
+		Name string `json:"name" validate:"required,max=200"`
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	folder, job, err := backend.DuplicateFolder(r.Context(), folderID, strings.TrimSpace(requestBody.Name))
+	if err != nil {
+		log.Printf("Error duplicating folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to duplicate folder: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"folder": folder, "job": job}})
+}
+
+// jobHandler returns the progress of a background job started by another endpoint.
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID := strings.TrimPrefix(r.URL.Path, "/api/jobs/")
+	job, err := backend.GetJob(r.Context(), jobID)
+	if err != nil {
+		log.Printf("Error getting job %s: %v", jobID, err)
+		writeBackendError(w, r, err, tr(r, "Job not found"), tr(r, "Unable to get job: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": job})
+}
+
+// warmThumbnailsHandler queues the rendering of the thumbnails of a folder's images that are not
+// cached yet, e.g. after a bulk upload. It returns 202 once the job is queued.
+func warmThumbnailsHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireModerator(w, r); !ok {
+		return
+	}
+
+	var requestBody struct {
+		FolderID   string `json:"folder_id"`
+		FolderName string `json:"folder_name"` // Used when folder_id is empty
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+	if requestBody.FolderID == "" && requestBody.FolderName == "" {
+		writeFieldErrors(w, r, []fieldError{{Field: "folder_id", Rule: "required", Message: tr(r, "is required")}})
+		return
+	}
+
+	ctx := r.Context()
+	folderID := requestBody.FolderID
+	var err error
+	if folderID == "" {
+		var folder *backend.FolderMetadata
+		if folder, err = backend.FindFolderByName(ctx, requestBody.FolderName); err == nil {
+			if folder == nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Folder not found")})
+				return
+			}
+			folderID = folder.ID
+		}
+	}
+	var files []backend.FileMetadata
+	if err == nil {
+		files, err = backend.ListAllFilesInFolder(ctx, folderID)
+	}
+	if err != nil {
+		log.Printf("Error listing files to warm thumbnails: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to list files: %v", err)})
+		return
+	}
+	if !backend.QueueThumbnailWarming(files) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Thumbnail warming queue is full; try again later")})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"folderId": folderID, "files": len(files)}})
+}
+
+// deadLettersHandler lists Drive notifications whose processing failed.
+func deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	letters, err := backend.ListDeadLetters(r.Context())
+	if err != nil {
+		log.Printf("Error listing dead letters: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to list dead letters: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": letters})
+}
+
+// faceClustersHandler lists the face clusters for editors to label, those with the most faces
+// first, and whether uploads are grouped at all (FACE_GROUPING).
+func faceClustersHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireModerator(w, r); !ok {
+		return
+	}
+
+	clusters, err := backend.ListFaceClusters(r.Context())
+	if err != nil {
+		log.Printf("Error listing face clusters: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to list face clusters: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": clusters, "enabled": backend.FaceGroupingEnabled()})
+}
+
+// faceClusterHandler labels a face cluster with the profile of the person:
+// PUT /api/admin/faces/clusters/{id} with {"profileId": "..."}, or "" to clear the label.
+func faceClusterHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPut {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := requireModerator(w, r)
+	if !ok {
+		return
+	}
+	clusterID := strings.TrimPrefix(r.URL.Path, "/api/admin/faces/clusters/")
+	var requestBody struct {
+		ProfileID *string `json:"profileId" validate:"required"`
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	cluster, err := backend.LabelFaceCluster(backend.WithActor(r.Context(), caller.UID), clusterID, *requestBody.ProfileID)
+	if err != nil {
+		log.Printf("Error labeling face cluster %s: %v", clusterID, err)
+		writeBackendError(w, r, err, tr(r, "Face cluster or profile not found"), tr(r, "Unable to label face cluster: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": cluster})
+}
+
+// purgeFacesHandler deletes all face embeddings and clusters: DELETE /api/admin/faces. Admins
+// only. Profiles files were tagged with stay.
+func purgeFacesHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	purge, err := backend.PurgeFaceData(r.Context())
+	if err != nil {
+		log.Printf("Error purging face data: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to purge face data: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": purge})
+}
+
+// adminPendingHandler lists what awaits moderation, oldest first: the open abuse reports, and
+// uploads to folders requiring approval. Reports may contain contact details, so only editors
+// and admins can see them.
+func adminPendingHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireModerator(w, r); !ok {
+		return
+	}
+
+	reports, err := backend.ListOpenReports(r.Context())
+	if err != nil {
+		log.Printf("Error listing open reports: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to list reports: %v", err)})
+		return
+	}
+	uploads, err := backend.ListPendingUploads(r.Context())
+	if err == nil {
+		err = backend.AttachAccessURLsByFolder(r.Context(), uploads)
+	}
+	if err != nil {
+		log.Printf("Error listing pending uploads: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to list pending uploads: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"reports": reports, "uploads": uploads}})
+}
+
+// maxApproveUploads bounds the number of uploads approved by a single request.
+const maxApproveUploads = 100
+
+// approveUploadsHandler publishes uploads awaiting approval: POST /api/admin/uploads/approve with
+// {"ids": [...]}. Rejected uploads are deleted with /api/files/batch-delete instead.
+func approveUploadsHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := requireModerator(w, r)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		IDs []string `json:"ids" validate:"required,max=100"` // maxApproveUploads
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	approved, skipped, err := backend.ApproveUploads(backend.WithActor(r.Context(), caller.UID), requestBody.IDs)
+	if err != nil {
+		log.Printf("Error approving uploads: %v", err)
+		writeBackendError(w, r, err, tr(r, "File not found"), tr(r, "Unable to approve uploads: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"approved": approved, "skipped": skipped}})
+}
+
+// tagRulesHandler reads (GET) and replaces (PUT with {"rules": [...]}) the gallery's tag rules,
+// which tag uploads or move them to another folder by name, MIME type or camera model.
+func tagRulesHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireModerator(w, r); !ok {
+		return
+	}
+
+	var rules []backend.TagRule
+	var err error
+	if r.Method == http.MethodGet {
+		rules, err = backend.GetTagRules(r.Context())
+	} else {
+		var requestBody struct {
+			Rules []backend.TagRule `json:"rules" validate:"max=100"` // backend.MaxTagRules
+		}
+		if !decodeJSONBody(w, r, &requestBody) {
+			return
+		}
+		rules, err = backend.SetTagRules(r.Context(), requestBody.Rules)
+	}
+	if invalid, ok := err.(*backend.InvalidTagRuleError); ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Invalid tag rule '%s': %s", invalid.Rule, invalid.Reason)})
+		return
+	}
+	if err != nil {
+		log.Printf("Error accessing tag rules: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to access tag rules: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": rules})
+}
+
+// outgoingWebhooksHandler lists the outgoing webhooks (GET) or registers one (POST {"url",
+// "eventTypes", "description"}), returning it with its signing secret, which is not shown again.
+// Admins only.
+func outgoingWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		webhooks, err := backend.ListWebhooks(r.Context())
+		if err != nil {
+			log.Printf("Error listing webhooks: %v", err)
+			writeBackendError(w, r, err, tr(r, "Webhook not found"), tr(r, "Unable to access webhooks: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": webhooks})
+		return
+	}
+
+	var requestBody struct {
+		URL         string   `json:"url" validate:"required,max=2000"`
+		EventTypes  []string `json:"eventTypes" validate:"max=20"`
+		Description string   `json:"description" validate:"max=200"`
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+	webhook, err := backend.CreateWebhook(backend.WithActor(r.Context(), caller.UID), requestBody.URL, requestBody.EventTypes, requestBody.Description)
+	var invalidErr *backend.InvalidWebhookError
+	if errors.As(err, &invalidErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Invalid webhook: %s", invalidErr.Reason)})
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating webhook: %v", err)
+		writeBackendError(w, r, err, tr(r, "Webhook not found"), tr(r, "Unable to access webhooks: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": webhook})
+}
+
+// outgoingWebhookHandler serves DELETE /api/admin/webhooks/{id}, which unregisters a webhook, and
+// POST /api/admin/webhooks/{id}/test, which sends it a ping and returns the outcome. Admins only.
+func outgoingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	webhookID, action, hasAction := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/admin/webhooks/"), "/")
+	isDelete := !hasAction && r.Method == http.MethodDelete
+	isTest := hasAction && action == "test" && r.Method == http.MethodPost
+	if webhookID == "" || (!isDelete && !isTest) {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	if isDelete {
+		if err := backend.DeleteWebhook(r.Context(), webhookID); err != nil {
+			log.Printf("Error deleting webhook %s: %v", webhookID, err)
+			writeBackendError(w, r, err, tr(r, "Webhook not found"), tr(r, "Unable to access webhooks: %v", err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	delivery, err := backend.TestWebhook(r.Context(), webhookID)
+	if err != nil {
+		log.Printf("Error testing webhook %s: %v", webhookID, err)
+		writeBackendError(w, r, err, tr(r, "Webhook not found"), tr(r, "Unable to access webhooks: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": delivery})
+}
+
+// meHandler returns the caller's UID, email, role and permissions (see backend.Caller.Can), so
+// the frontend can show only the actions the caller may take. Anonymous callers get the
+// permissions of viewers, with signedIn false.
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	caller, err := requestCaller(r)
+	if err != nil {
+		writeAuthError(w, r, nil, err, "")
+		return
+	}
+
+	me := map[string]interface{}{
+		"signedIn":    caller != nil,
+		"role":        caller.EffectiveRole(),
+		"permissions": caller.Permissions(),
+	}
+	if caller != nil {
+		me["uid"] = caller.UID
+		me["email"] = caller.Email
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": me})
+}
+
+// usersHandler lists the users with a stored role (GET /api/admin/users). Admins only.
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	users, err := backend.ListUsers(r.Context())
+	if err != nil {
+		log.Printf("Error listing users: %v", err)
+		writeBackendError(w, r, err, tr(r, "User not found"), tr(r, "Unable to access users: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": users})
+}
+
+// userRoleHandler gives a user a role: PUT /api/admin/users/{uid} with {"role": "viewer",
+// "editor" or "admin"}, which takes precedence over the role custom claim. Admins only, and not
+// for themselves, so the last admin cannot lock everyone out.
+func userRoleHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	uid := strings.TrimPrefix(r.URL.Path, "/api/admin/users/")
+	if uid == "" || strings.Contains(uid, "/") || r.Method != http.MethodPut {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if uid == caller.UID {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Admins cannot change their own role")})
+		return
+	}
+
+	var requestBody struct {
+		Role string `json:"role" validate:"required,oneof=viewer editor admin"` // backend.Roles
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+	user, err := backend.SetUserRole(backend.WithActor(r.Context(), caller.UID), uid, requestBody.Role)
+	if err != nil {
+		log.Printf("Error setting role of user %s: %v", uid, err)
+		writeBackendError(w, r, err, tr(r, "User not found"), tr(r, "Unable to access users: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": user})
+}
+
+// maxEvaluateTagRules bounds the number of files evaluated by a single dry run.
+const maxEvaluateTagRules = 100
+
+// evaluateTagRulesHandler is the dry run of tag rules: POST /api/admin/tag-rules/evaluate with
+// sample files {"files": [{"name", "mimeType", "cameraModel"}]} and/or stored ones {"fileIds": [...]}
+// returns what the rules would do to each, without changing anything. Given {"rules": [...]},
+// those are evaluated instead of the saved ones, to try rules out before saving them.
+func evaluateTagRulesHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireModerator(w, r); !ok {
+		return
+	}
+
+	var requestBody struct {
+		Rules   []backend.TagRule      `json:"rules" validate:"max=100"` // backend.MaxTagRules
+		Files   []backend.TagRuleInput `json:"files" validate:"max=100"` // maxEvaluateTagRules
+		FileIDs []string               `json:"fileIds" validate:"max=100"`
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	rules := requestBody.Rules
+	if rules == nil {
+		var err error
+		if rules, err = backend.GetTagRules(r.Context()); err != nil {
+			log.Printf("Error getting tag rules: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to access tag rules: %v", err)})
+			return
+		}
+	}
+
+	type evaluation struct {
+		FileID string               `json:"fileId,omitempty"`
+		File   backend.TagRuleInput `json:"file"`
+		backend.TagRuleResult
+	}
+	results := []evaluation{}
+	for _, file := range requestBody.Files {
+		results = append(results, evaluation{File: file, TagRuleResult: backend.EvaluateTagRules(rules, file)})
+	}
+	for _, id := range requestBody.FileIDs {
+		file, err := backend.GetFile(r.Context(), id)
+		if err != nil {
+			log.Printf("Error getting file %s to evaluate tag rules: %v", id, err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to evaluate tag rules: %v", err)})
+			return
+		}
+		if file == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "File not found")})
+			return
+		}
+		input := backend.TagRuleInput{Name: file.Name, MimeType: file.MimeType, CameraModel: file.CameraModel}
+		results = append(results, evaluation{FileID: id, File: input, TagRuleResult: backend.EvaluateTagRules(rules, input)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": results})
+}
+
+// resolveReportHandler closes an abuse report:
+// POST /api/admin/reports/{reportId}/resolve with an optional {"resolution": "..."}.
+func resolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	reportID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/admin/reports/"), "/resolve")
+	if r.Method != http.MethodPost || !ok {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := requireModerator(w, r)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		Resolution string `json:"resolution" validate:"max=1000"`
+	}
+	if r.ContentLength != 0 && !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	err := backend.ResolveReport(r.Context(), reportID, caller.UID, strings.TrimSpace(requestBody.Resolution))
+	if err != nil {
+		log.Printf("Error resolving report %s: %v", reportID, err)
+		writeBackendError(w, r, err, tr(r, "Report not found"), tr(r, "Unable to resolve report: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": tr(r, "Report resolved")})
+}
+
+// adminFileActionHandler serves the admin actions on a file: PUT /api/admin/files/{id}/legal-hold
+// places it under legal hold and DELETE clears the hold (admins only); POST
+// /api/admin/files/{id}/takedown takes it down (editors and admins).
+func adminFileActionHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	fileID, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/admin/files/"), "/")
+	if ok && action == "takedown" && r.Method == http.MethodPost {
+		takeDownFile(w, r, fileID)
+		return
+	}
+	if !ok || action != "legal-hold" || (r.Method != http.MethodPut && r.Method != http.MethodDelete) {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	file, err := backend.SetFileLegalHold(backend.WithActor(r.Context(), caller.UID), fileID, r.Method == http.MethodPut)
+	if err != nil {
+		log.Printf("Error setting legal hold of file %s: %v", fileID, err)
+		writeBackendError(w, r, err, tr(r, "File not found"), tr(r, "Unable to update file: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": file})
+}
+
+// adminFolderActionHandler serves the admin actions on a folder: PUT
+// /api/admin/folders/{id}/legal-hold places it and its files under legal hold and DELETE clears
+// the hold.
+func adminFolderActionHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	folderID, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/admin/folders/"), "/")
+	if !ok || action != "legal-hold" || (r.Method != http.MethodPut && r.Method != http.MethodDelete) {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	folder, err := backend.SetFolderLegalHold(backend.WithActor(r.Context(), caller.UID), folderID, r.Method == http.MethodPut)
+	if err != nil {
+		log.Printf("Error setting legal hold of folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to update folder: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": folder})
+}
+
+// replayDeadLettersHandler processes the recorded dead letters again.
+func replayDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := backend.ReplayDeadLetters(r.Context())
+	if err != nil {
+		log.Printf("Error replaying dead letters: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to replay dead letters: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": result})
+}
+
+// isSHA256Hex reports whether s is a hex-encoded SHA-256 digest.
+func isSHA256Hex(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	backend.WebhookHandler(w, r)
+}
+
+// wsHandler connects a WebSocket client, which only receives the events its viewer may see.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	backend.ServeWs(w, r.WithContext(viewerContext(r)))
+}
+
+func folderNameHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	folderIDComponent := strings.TrimPrefix(r.URL.Path, "/api/folder-name/")
+	if folderIDComponent == "" { // Allow '/' in folderIDComponent if it's part of the ID
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Folder ID is missing in path")})
+		return
+	}
+	folderID := folderIDComponent
+
+	ctx := viewerContext(r)
+	folderName, err := backend.GetFolderNameFromFirestore(ctx, folderID, r.URL.Query().Get("lang"))
+	if err != nil {
+		log.Printf("Error retrieving folder name for ID %s from Firestore: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to retrieve folder name: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": folderName})
+}
+
+// folderStatsHandler returns file counts and byte totals of a folder broken down by media type.
+func folderStatsHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	folderID := strings.TrimPrefix(r.URL.Path, "/api/stats/folders/")
+	if folderID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Folder ID is missing in path")})
+		return
+	}
+
+	ctx := r.Context()
+	stats, err := backend.GetFolderStats(ctx, folderID)
+	if err != nil {
+		log.Printf("Error getting stats for folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to get folder stats: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": stats})
+}
+
+// slideshowHandler returns a folder's images and videos as a slideshow playlist.
+// Query parameters: folderId (required), shuffle, seed and duration (seconds per image, default 5).
+func slideshowHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	folderID := query.Get("folderId")
+	if folderID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "folderId query parameter is required")})
+		return
+	}
+	shuffle := query.Get("shuffle") == "true"
+	var seed int64
+	if seedStr := query.Get("seed"); seedStr != "" {
+		parsedSeed, err := strconv.ParseInt(seedStr, 10, 64)
+		if err != nil {
+			log.Printf("Invalid seed parameter: %s, using a random seed", seedStr)
+		}
+		seed = parsedSeed
+	}
+	duration := 5.0
+	if durationStr := query.Get("duration"); durationStr != "" {
+		parsedDuration, err := strconv.ParseFloat(durationStr, 64)
+		if err == nil && parsedDuration >= 1 && parsedDuration <= 60 {
+			duration = parsedDuration
+		} else {
+			log.Printf("Invalid duration parameter: %s, using default %.0f", durationStr, duration)
+		}
+	}
+
+	ctx := viewerContext(r)
+	slideshow, err := backend.BuildSlideshow(ctx, folderID, shuffle, seed, duration)
+	if err != nil {
+		log.Printf("Error building slideshow for folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to build slideshow: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": slideshow})
+}
+
+// embedHandler serves the gallery widget embedded in iframes on the band's website: the latest
+// images of a folder as an HTML page when the client accepts HTML (or with ?format=html),
+// otherwise as JSON. Framing is restricted by the frame-ancestors policy set in setCorsHeaders.
+func embedHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	folderID := strings.TrimPrefix(r.URL.Path, "/embed/")
+	if folderID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Folder ID is missing in path")})
+		return
+	}
+	query := r.URL.Query()
+	limit := defaultEmbedLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err == nil && parsedLimit >= 1 && parsedLimit <= maxEmbedLimit {
+			limit = parsedLimit
+		} else {
+			log.Printf("Invalid limit parameter: %s, using default %d", limitStr, limit)
+		}
+	}
+
+	ctx := backend.WithPublicViewer(r.Context()) // Embedded on public pages, whoever is signed in here
+	gallery, err := backend.BuildEmbedGallery(ctx, folderID, query.Get("lang"), limit)
+	if err != nil {
+		log.Printf("Error building embed gallery for folder %s: %v", folderID, err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to list files: %v", err))
+		return
+	}
+
+	if query.Get("format") == "html" || (query.Get("format") == "" && strings.Contains(r.Header.Get("Accept"), "text/html")) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if err := embedTemplate.Execute(w, gallery); err != nil {
+			log.Printf("Error rendering embed gallery for folder %s: %v", folderID, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": gallery})
+}
+
+func profilesHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		// Archived profiles are former members, only listed on request (e.g. for a history page)
+		includeArchived := r.URL.Query().Get("includeArchived") == "true"
+		profiles, err := backend.GetProfiles(ctx, includeArchived)
+		if err != nil {
+			log.Printf("Error getting profiles: %v", err)
+			http.Error(w, tr(r, "Unable to get profiles"), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": profiles})
+	case http.MethodPost:
+		var profile backend.Profile
+		if !decodeJSONBody(w, r, &profile) {
+			return
+		}
+		id, err := backend.CreateProfile(ctx, profile)
+		if err != nil {
+			log.Printf("Error creating profile: %v", err)
+			http.Error(w, tr(r, "Unable to create profile"), http.StatusInternalServerError)
+			return
+		}
+		profile.ID = id
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(profile)
+	default:
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+	}
+}
+
+func profileHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	ctx := r.Context()
+
+	profileID := strings.TrimPrefix(r.URL.Path, "/api/profiles/")
+	if profileID == "" {
+		http.Error(w, tr(r, "Profile ID is missing in path"), http.StatusBadRequest)
+		return
+	}
+	if id, ok := strings.CutSuffix(profileID, "/references"); ok {
+		profileReferencesHandler(w, r, id)
+		return
+	}
+	if id, ok := strings.CutSuffix(profileID, "/files"); ok {
+		profileFilesHandler(w, r, id)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		profile, err := backend.GetProfile(ctx, profileID)
+		if err != nil {
+			log.Printf("Error getting profile %s: %v", profileID, err)
+			writeBackendError(w, r, err, tr(r, "Profile not found"), tr(r, "Unable to get profile"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profile)
+
+	case http.MethodPut:
+		var profileData backend.Profile
+		if !decodeJSONBody(w, r, &profileData) {
+			return
+		}
+
+		if err := backend.UpdateProfile(ctx, profileID, profileData); err != nil {
+			log.Printf("Error updating profile %s: %v", profileID, err)
+			writeBackendError(w, r, err, tr(r, "Profile not found"), tr(r, "Unable to update profile"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": tr(r, "Profile updated successfully")})
+
+	case http.MethodDelete:
+		// What becomes of references to the profile; see GET /api/profiles/{id}/references
+		mode := r.URL.Query().Get("mode")
+		if mode != "" && mode != backend.ProfileDeleteAnonymize && mode != backend.ProfileDeleteTombstone {
+			http.Error(w, tr(r, "Invalid mode '%s' (expected %s or %s)", mode, backend.ProfileDeleteAnonymize, backend.ProfileDeleteTombstone), http.StatusBadRequest)
+			return
+		}
+		if err := backend.DeleteProfile(ctx, profileID, mode); err != nil {
+			log.Printf("Error deleting profile %s: %v", profileID, err)
+			http.Error(w, tr(r, "Unable to delete profile"), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": tr(r, "Profile deleted successfully")})
+
+	default:
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+	}
+}
+
+// profileReferencesHandler reports what refers to a profile, to check before deleting it.
+func profileReferencesHandler(w http.ResponseWriter, r *http.Request, profileID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	refs, err := backend.GetProfileReferences(r.Context(), profileID)
+	if err != nil {
+		log.Printf("Error getting references of profile %s: %v", profileID, err)
+		writeBackendError(w, r, err, tr(r, "Profile not found"), tr(r, "Unable to get profile references"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": refs})
+}
+
+// profileFilesHandler lists the files tagged with a profile across all folders, newest first,
+// paginated like filesHandler: photos of one member, tagged by hand or through a labeled face
+// cluster. Only for signed-in users, as it spans private folders.
+func profileFilesHandler(w http.ResponseWriter, r *http.Request, profileID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	caller, err := requestCaller(r)
+	if err != nil || caller == nil {
+		if err != nil {
+			log.Printf("Rejected request with invalid ID token: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Sign-in required")})
+		return
+	}
+
+	var pageSize int64 = 100
+	if pageSizeStr := r.URL.Query().Get("pageSize"); pageSizeStr != "" {
+		parsedSize, err := strconv.ParseInt(pageSizeStr, 10, 64)
+		if err == nil && parsedSize > 0 {
+			pageSize = parsedSize
+		} else {
+			log.Printf("Invalid pageSize parameter: %s, using default %d", pageSizeStr, pageSize)
+		}
+	}
+	fields, ok := parseFields(w, r, backend.FileMetadata{})
+	if !ok {
+		return
+	}
+
+	ctx := backend.WithFileFields(r.Context(), fields)
+	files, nextPageToken, err := backend.ListFilesByPerson(ctx, profileID, pageSize, r.URL.Query().Get("pageToken"))
+	if err == nil {
+		err = backend.AttachAccessURLsByFolder(ctx, files)
+	}
+	if err != nil {
+		log.Printf("Error listing files of profile %s: %v", profileID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to list files: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":          projectFields(files, fields),
+		"nextPageToken": nextPageToken,
+	})
+}
+
+// profilesReorderRequest is the body of PUT /api/profiles/reorder.
+type profilesReorderRequest struct {
+	IDs []string `json:"ids" validate:"required"` // Profile IDs in display order; profiles not listed follow them
+}
+
+// profilesReorderHandler persists the display order of the profiles.
+func profilesReorderHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPut {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req profilesReorderRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	if err := backend.ReorderProfiles(r.Context(), req.IDs); err != nil {
+		if unknown, ok := err.(*backend.UnknownProfileError); ok {
+			http.Error(w, tr(r, "Profile not found: %s", unknown.ID), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error reordering profiles: %v", err)
+		http.Error(w, tr(r, "Unable to reorder profiles: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": tr(r, "Profiles reordered successfully")})
+}
+
+func uploadIconHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		http.Error(w, tr(r, "Error parsing form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	file, handler, err := r.FormFile("icon")
+	if err != nil {
+		http.Error(w, tr(r, "Error retrieving file from form: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	profileID := r.FormValue("profile_id")
+	if profileID == "" {
+		http.Error(w, tr(r, "Profile ID is missing in form data"), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	iconURL, err := backend.UploadProfileIcon(ctx, profileID, file, handler.Filename, handler.Header.Get("Content-Type"))
+	if err != nil {
+		log.Printf("Error uploading icon to Firebase Storage: %v", err)
+		http.Error(w, tr(r, "Error uploading icon to Firebase Storage"), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"icon_url": iconURL})
+}
+
+// uploadFileHandler handles file uploads to Firebase Storage and saves metadata to Firestore.
+func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Uploads of signed-in users are attributed to them; anonymous uploads are still accepted
+	caller, err := requestCaller(r)
+	if err != nil {
+		log.Printf("Rejected upload with invalid ID token: %v", err)
+		http.Error(w, tr(r, "Invalid or expired ID token"), http.StatusUnauthorized)
+		return
+	}
+
+	// Parse multipart form, 10MB limit for file size
+	err = r.ParseMultipartForm(10 << 20) // 10 MB
+	if err != nil {
+		http.Error(w, tr(r, "Error parsing form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	file, _, err := r.FormFile("file") // "file" is the expected form field name for the file
+	if err != nil {
+		http.Error(w, tr(r, "Error retrieving file from form: %v", err), http.StatusBadRequest)
+		return
 	}
+	defer file.Close()
 
-	ctx := context.Background()
-	err := backend.InitFirebase(ctx, projectID, serviceAccountJSONPath)
-	if err != nil {
-		log.Printf("ERROR: Unable to initialize Firebase: %v. Exiting in 30s.", err)
-		time.Sleep(30 * time.Second)
-		os.Exit(1)
+	folderName := r.FormValue("folder_name")     // "folder_name" is the expected form field name for the folder name
+	relativePath := r.FormValue("relative_path") // "relative_path" is the expected form field name for the relative path
+	mimeType := r.FormValue("mime_type")         // "mime_type" is the expected form field name for the MIME type
+
+	// Optional: how the file looked on the uploader's machine, and whom and how to credit
+	source := backend.SourceInfo{
+		OriginalPath:     r.FormValue("original_path"),
+		UploaderUID:      callerUID(caller),
+		License:          r.FormValue("license"),
+		PhotographerName: strings.TrimSpace(r.FormValue("photographer_name")),
+	}
+	if source.License != "" && !slices.Contains(backend.Licenses, source.License) {
+		http.Error(w, tr(r, "Invalid license '%s' (expected %s)", source.License, strings.Join(backend.Licenses, ", ")), http.StatusBadRequest)
+		return
+	}
+	if len(source.PhotographerName) > backend.MaxPhotographerNameLength {
+		http.Error(w, tr(r, "photographer_name must be at most %d bytes", backend.MaxPhotographerNameLength), http.StatusBadRequest)
+		return
+	}
+	if modifiedAt := r.FormValue("modified_at"); modifiedAt != "" {
+		t, err := time.Parse(time.RFC3339, modifiedAt)
+		if err != nil {
+			http.Error(w, tr(r, "Invalid modified_at (expected RFC 3339): %v", err), http.StatusBadRequest)
+			return
+		}
+		source.ModifiedAt = t
 	}
 
-	// Set up HTTP routes
-	http.HandleFunc("/api/folders", foldersHandler)
-	http.HandleFunc("/api/files/", filesHandler)
-	http.HandleFunc("/api/folder-name/", folderNameHandler)
-	http.HandleFunc("/api/profiles", profilesHandler)
-	http.HandleFunc("/api/profiles/", profileHandler)
-	http.HandleFunc("/api/upload/icon", uploadIconHandler)
-	http.HandleFunc("/api/upload/file", uploadFileHandler) // New file upload handler
-	http.HandleFunc("/api/update/file-metadata", updateFileMetadataHandler) // New metadata update handler
-	http.HandleFunc("/webhook", webhookHandler)
-	http.HandleFunc("/ws", wsHandler)
+	// Optional: checksums computed by the client, verified after the upload
+	expected := backend.ExpectedChecksums{SHA256: r.FormValue("sha256"), CRC32C: r.FormValue("crc32c")}
+	if expected.SHA256 != "" && !isSHA256Hex(strings.ToLower(expected.SHA256)) {
+		http.Error(w, tr(r, "Invalid sha256 (expected 64 hex characters)"), http.StatusBadRequest)
+		return
+	}
 
-	backend.InitHub()
+	if folderName == "" {
+		http.Error(w, tr(r, "Folder name is missing in form data"), http.StatusBadRequest)
+		return
+	}
+	if relativePath == "" {
+		http.Error(w, tr(r, "Relative path is missing in form data"), http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	// Read file content into a byte slice
+	fileContent, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, tr(r, "Error reading file content: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// If mimeType is not provided by the client, try to detect it from the file content
+	if mimeType == "" {
+		mimeType = http.DetectContentType(fileContent)
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	fileMetadata, deduplicated, err := backend.UploadFileToStorageAndFirestore(ctx, folderName, relativePath, mimeType, fileContent, source, expected)
+	if mismatch, ok := err.(*backend.ChecksumMismatchError); ok {
+		log.Printf("Rejected upload of %s: %v", relativePath, mismatch)
+		http.Error(w, tr(r, "%s checksum mismatch: expected %s, got %s", mismatch.Algorithm, mismatch.Expected, mismatch.Actual), http.StatusUnprocessableEntity)
+		return
+	}
+	if archived, ok := err.(*backend.ArchivedFolderError); ok {
+		http.Error(w, tr(r, "Folder '%s' is archived and read-only", archived.Name), http.StatusConflict)
+		return
+	}
+	if notAllowed, ok := err.(*backend.MediaTypeNotAllowedError); ok {
+		http.Error(w, tr(r, "Folder '%s' does not accept %s files (allowed: %s)", notAllowed.FolderName, notAllowed.MediaType, strings.Join(notAllowed.Allowed, ", ")), http.StatusUnsupportedMediaType)
+		return
 	}
-	serverAddr := fmt.Sprintf(":%s", port)
-	log.Printf("Backend server listening on %s", serverAddr)
-	err = http.ListenAndServe(serverAddr, nil)
 	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+		log.Printf("Error uploading file to Firebase Storage and Firestore: %v", err)
+		http.Error(w, tr(r, "Error uploading file to Firebase Storage and Firestore"), http.StatusInternalServerError)
+		return
 	}
-}
 
-func setCorsHeaders(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*") // Be more specific in production
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Goog-Channel-ID, X-Goog-Resource-State, X-Goog-Resource-ID, X-Goog-Message-Number")
-	// Allow embedding from self, Vite dev server
-	w.Header().Set("Content-Security-Policy", "frame-ancestors 'self' http://localhost:5173;")
+	// 201 when a new file was stored, 200 when an existing file with the same content was returned
+	status := http.StatusCreated
+	if deduplicated {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":         fileMetadata,
+		"deduplicated": deduplicated,
+		"download_url": fileMetadata.DownloadURL, // Kept for clients written before data was returned
+	})
 }
 
-func foldersHandler(w http.ResponseWriter, r *http.Request) {
+// driveFoldersHandler lists the Drive folders inside DRIVE_ROOT_FOLDER_ID.
+func driveFoldersHandler(w http.ResponseWriter, r *http.Request) {
 	setCorsHeaders(w)
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -78,17 +3136,16 @@ func foldersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
 		return
 	}
 
-	ctx := r.Context()
-	folders, err := backend.ListFoldersFromFirestore(ctx)
+	folders, err := backend.ListFoldersInRootFolder(r.Context())
 	if err != nil {
-		log.Printf("Error listing folders from Firestore: %v", err)
+		log.Printf("Error listing Drive folders: %v", err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Unable to list folders: %v", err)})
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to list Drive folders: %v", err)})
 		return
 	}
 
@@ -97,7 +3154,8 @@ func foldersHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{"data": folders})
 }
 
-func filesHandler(w http.ResponseWriter, r *http.Request) {
+// driveFilesHandler lists all files of a Drive folder with their size, dates and media metadata.
+func driveFilesHandler(w http.ResponseWriter, r *http.Request) {
 	setCorsHeaders(w)
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -105,66 +3163,36 @@ func filesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
 		return
 	}
 
-	folderIDComponent := strings.TrimPrefix(r.URL.Path, "/api/files/")
-	if folderIDComponent == "" { // Allow '/' in folderIDComponent if it's part of the ID
+	folderID := strings.TrimPrefix(r.URL.Path, "/api/drive/files/")
+	if folderID == "" {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Folder ID is missing in path"})
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Folder ID is missing in path")})
 		return
 	}
-	folderID := folderIDComponent
-
-	pageSizeStr := r.URL.Query().Get("pageSize")
-	lastDocID := r.URL.Query().Get("pageToken") // Use pageToken as lastDocID for Firestore pagination
-
-	var pageSize int64 = 100
-	if pageSizeStr != "" {
-		parsedSize, err := strconv.ParseInt(pageSizeStr, 10, 64)
-		if err == nil && parsedSize > 0 {
-			pageSize = parsedSize
-		} else {
-			log.Printf("Invalid pageSize parameter: %s, using default %d", pageSizeStr, pageSize)
-		}
-	}
-
-	filterType := r.URL.Query().Get("filter")
 
-	ctx := r.Context()
-	files, newLastDocID, err := backend.ListFilesFromFirestore(ctx, folderID, pageSize, lastDocID, filterType)
+	files, err := backend.ListFilesInFolder(r.Context(), folderID)
 	if err != nil {
-		log.Printf("Error listing files for folder %s from Firestore: %v", folderID, err)
+		log.Printf("Error listing files of Drive folder %s: %v", folderID, err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Unable to list files: %v", err)})
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to list Drive files: %v", err)})
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"data":          files,
-		"nextPageToken": newLastDocID, // Return newLastDocID as nextPageToken
-	})
-}
-
-func webhookHandler(w http.ResponseWriter, r *http.Request) {
-	setCorsHeaders(w)
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-	backend.WebhookHandler(w, r)
-}
-
-func wsHandler(w http.ResponseWriter, r *http.Request) {
-	backend.ServeWs(w, r)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": files})
 }
 
-func folderNameHandler(w http.ResponseWriter, r *http.Request) {
+// driveThumbnailHandler proxies the thumbnail of a Drive file, so Drive-backed grids keep working
+// after the listed thumbnailLink expires. sz sets the longest side in pixels (Drive's default
+// is 220).
+func driveThumbnailHandler(w http.ResponseWriter, r *http.Request) {
 	setCorsHeaders(w)
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -172,134 +3200,168 @@ func folderNameHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
 		return
 	}
 
-	folderIDComponent := strings.TrimPrefix(r.URL.Path, "/api/folder-name/")
-	if folderIDComponent == "" { // Allow '/' in folderIDComponent if it's part of the ID
+	fileID := strings.TrimPrefix(r.URL.Path, "/api/drive/thumbnail/")
+	if fileID == "" {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Folder ID is missing in path"})
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "File ID is missing in path")})
 		return
 	}
-	folderID := folderIDComponent
+	size := 0
+	if sz := r.URL.Query().Get("sz"); sz != "" {
+		var err error
+		if size, err = strconv.Atoi(sz); err != nil || size < 1 || size > maxDriveThumbnailSize {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "sz must be between 1 and %d", maxDriveThumbnailSize)})
+			return
+		}
+	}
 
-	ctx := r.Context()
-	folderName, err := backend.GetFolderNameFromFirestore(ctx, folderID)
+	thumb, contentType, err := backend.DriveThumbnail(r.Context(), fileID, size)
 	if err != nil {
-		log.Printf("Error retrieving folder name for ID %s from Firestore: %v", folderID, err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Unable to retrieve folder name: %v", err)})
+		log.Printf("Error getting thumbnail of Drive file %s: %v", fileID, err)
+		writeBackendError(w, r, err, tr(r, "Thumbnail not found"), tr(r, "Unable to get Drive thumbnail: %v", err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	if contentType == "" {
+		contentType = "image/jpeg"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=300")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{"name": folderName})
+	w.Write(thumb)
 }
 
-func profilesHandler(w http.ResponseWriter, r *http.Request) {
+// maxDriveThumbnailSize is the largest sz accepted by the Drive thumbnail proxy.
+const maxDriveThumbnailSize = 1600
+
+// driveUploadHandler streams a multipart upload into Google Drive without buffering the file.
+// Form fields (drive_folder_id, mime_type) must come before the "file" part.
+func driveUploadHandler(w http.ResponseWriter, r *http.Request) {
 	setCorsHeaders(w)
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
-	ctx := r.Context()
 
-	switch r.Method {
-	case http.MethodGet:
-		profiles, err := backend.GetProfiles(ctx)
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, tr(r, "Error parsing form: %v", err), http.StatusBadRequest)
+		return
+	}
+	fields := map[string]string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			http.Error(w, tr(r, "File is missing in form data"), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
-			log.Printf("Error getting profiles: %v", err)
-			http.Error(w, "Unable to get profiles", http.StatusInternalServerError)
+			http.Error(w, tr(r, "Error reading form: %v", err), http.StatusBadRequest)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{"data": profiles})
-	case http.MethodPost:
-		var profile backend.Profile
-		if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if part.FormName() != "file" {
+			value, err := io.ReadAll(io.LimitReader(part, 1024))
+			if err != nil {
+				http.Error(w, tr(r, "Error reading form field %s: %v", part.FormName(), err), http.StatusBadRequest)
+				return
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+
+		name := part.FileName()
+		if name == "" {
+			http.Error(w, tr(r, "File name is missing in form data"), http.StatusBadRequest)
 			return
 		}
-		id, err := backend.CreateProfile(ctx, profile)
+		mimeType := fields["mime_type"]
+		if mimeType == "" {
+			mimeType = part.Header.Get("Content-Type")
+		}
+		upload, err := backend.UploadFileToDrive(r.Context(), fields["drive_folder_id"], name, mimeType, part)
 		if err != nil {
-			log.Printf("Error creating profile: %v", err)
-			http.Error(w, "Unable to create profile", http.StatusInternalServerError)
+			log.Printf("Error uploading file to Drive: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to upload file to Drive: %v", err)})
 			return
 		}
-		profile.ID = id
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(profile)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": upload})
+		return
 	}
 }
 
-func profileHandler(w http.ResponseWriter, r *http.Request) {
+// importDriveZipHandler starts a background job importing the files of a zip stored in Drive,
+// {"fileId": ..., "folderName": ...}, into a folder, and returns the job to follow its progress.
+// Optional "license" and "photographerName" credit every file, as for uploads.
+func importDriveZipHandler(w http.ResponseWriter, r *http.Request) {
 	setCorsHeaders(w)
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
-	ctx := r.Context()
 
-	profileID := strings.TrimPrefix(r.URL.Path, "/api/profiles/")
-	if profileID == "" {
-		http.Error(w, "Profile ID is missing in path", http.StatusBadRequest)
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	// The service account can read more of Drive than the caller may
+	caller, ok := requireModerator(w, r)
+	if !ok {
 		return
 	}
 
-	switch r.Method {
-	case http.MethodGet:
-		profile, err := backend.GetProfile(ctx, profileID)
-		if err != nil {
-			log.Printf("Error getting profile %s: %v", profileID, err)
-			http.Error(w, "Unable to get profile", http.StatusInternalServerError)
-			return
-		}
-		if profile == nil {
-			http.Error(w, "Profile not found", http.StatusNotFound)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(profile)
-
-	case http.MethodPut:
-		var profileData backend.Profile
-		if err := json.NewDecoder(r.Body).Decode(&profileData); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
-
-		if err := backend.UpdateProfile(ctx, profileID, profileData); err != nil {
-			log.Printf("Error updating profile %s: %v", profileID, err)
-			http.Error(w, "Unable to update profile", http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"message": "Profile updated successfully"})
+	var requestBody struct {
+		FileID           string `json:"fileId" validate:"required"`
+		FolderName       string `json:"folderName" validate:"required,max=200"`
+		License          string `json:"license" validate:"omitempty,oneof=CC-BY CC-BY-SA CC-BY-NC CC0 all-rights-reserved"`
+		PhotographerName string `json:"photographerName" validate:"max=200"`
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
 
-	case http.MethodDelete:
-		if err := backend.DeleteProfile(ctx, profileID); err != nil {
-			log.Printf("Error deleting profile %s: %v", profileID, err)
-			http.Error(w, "Unable to delete profile", http.StatusInternalServerError)
-			return
-		}
+	source := backend.SourceInfo{UploaderUID: caller.UID, License: requestBody.License, PhotographerName: strings.TrimSpace(requestBody.PhotographerName)}
+	job, err := backend.ImportDriveZip(backend.WithActor(r.Context(), caller.UID), requestBody.FileID, requestBody.FolderName, source)
+	var invalidErr *backend.InvalidDriveZipError
+	if errors.As(err, &invalidErr) {
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"message": "Profile deleted successfully"})
-
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Cannot import: %s", invalidErr.Reason)})
+		return
+	}
+	if err != nil {
+		log.Printf("Error starting import of Drive zip %s: %v", requestBody.FileID, err)
+		writeBackendError(w, r, err, tr(r, "Drive file not found"), tr(r, "Unable to import Drive zip: %v", err))
+		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": job})
 }
 
-func uploadIconHandler(w http.ResponseWriter, r *http.Request) {
+// maxUploadCheckFiles bounds the number of files in a single /api/upload/check request.
+const maxUploadCheckFiles = 500
+
+// uploadCheckHandler reports which files of an upload are already stored, so clients can skip
+// them and tell the user before any bytes move.
+func uploadCheckHandler(w http.ResponseWriter, r *http.Request) {
 	setCorsHeaders(w)
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -307,44 +3369,57 @@ func uploadIconHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
 		return
 	}
 
-	err := r.ParseMultipartForm(10 << 20)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error parsing form: %v", err), http.StatusBadRequest)
-		return
+	var requestBody struct {
+		FolderName string                    `json:"folder_name"`                       // Target folder, optional
+		Files      []backend.UploadCheckFile `json:"files" validate:"required,max=500"` // maxUploadCheckFiles
 	}
-
-	file, handler, err := r.FormFile("icon")
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error retrieving file from form: %v", err), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &requestBody) {
 		return
 	}
-	defer file.Close()
-
-	profileID := r.FormValue("profile_id")
-	if profileID == "" {
-		http.Error(w, "Profile ID is missing in form data", http.StatusBadRequest)
-		return
+	for i, f := range requestBody.Files {
+		requestBody.Files[i].Hash = strings.ToLower(f.Hash)
 	}
 
 	ctx := r.Context()
-	iconURL, err := backend.UploadProfileIcon(ctx, profileID, file, handler.Filename, handler.Header.Get("Content-Type"))
+	check, err := backend.CheckUploads(ctx, requestBody.FolderName, requestBody.Files)
 	if err != nil {
-		log.Printf("Error uploading icon to Firebase Storage: %v", err)
-		http.Error(w, "Error uploading icon to Firebase Storage", http.StatusInternalServerError)
+		log.Printf("Error checking uploads in Firestore: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to check existing files: %v", err)})
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"icon_url": iconURL})
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": check})
 }
 
-// uploadFileHandler handles file uploads to Firebase Storage and saves metadata to Firestore.
-func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
+// maxDirectUploadFiles bounds the number of files in a single signed-urls or finalize request.
+const maxDirectUploadFiles = 100
+
+// decodeDirectUploadRequest reads the body shared by the signed-urls and finalize endpoints.
+// It writes a 400 response and returns false if the request is invalid.
+func decodeDirectUploadRequest(w http.ResponseWriter, r *http.Request) (string, []backend.DirectUploadFile, bool) {
+	var requestBody struct {
+		FolderName string                     `json:"folder_name" validate:"required"`
+		Files      []backend.DirectUploadFile `json:"files" validate:"required,max=100"` // maxDirectUploadFiles
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return "", nil, false
+	}
+	for i, f := range requestBody.Files {
+		requestBody.Files[i].Hash = strings.ToLower(f.Hash)
+	}
+	return requestBody.FolderName, requestBody.Files, true
+}
+
+// signedUploadURLsHandler issues signed PUT URLs so clients can upload large files directly to Storage.
+func signedUploadURLsHandler(w http.ResponseWriter, r *http.Request) {
 	setCorsHeaders(w)
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -352,59 +3427,66 @@ func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Parse multipart form, 10MB limit for file size
-	err := r.ParseMultipartForm(10 << 20) // 10 MB
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error parsing form: %v", err), http.StatusBadRequest)
+	folderName, files, ok := decodeDirectUploadRequest(w, r)
+	if !ok {
 		return
 	}
 
-	file, _, err := r.FormFile("file") // "file" is the expected form field name for the file
+	ctx := r.Context()
+	uploads, err := backend.CreateSignedUploadURLs(ctx, folderName, files)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error retrieving file from form: %v", err), http.StatusBadRequest)
+		log.Printf("Error creating signed upload URLs: %v", err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to create signed upload URLs: %v", err))
 		return
 	}
-	defer file.Close()
 
-	folderName := r.FormValue("folder_name")     // "folder_name" is the expected form field name for the folder name
-	relativePath := r.FormValue("relative_path") // "relative_path" is the expected form field name for the relative path
-	mimeType := r.FormValue("mime_type")         // "mime_type" is the expected form field name for the MIME type
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": uploads})
+}
 
-	if folderName == "" {
-		http.Error(w, "Folder name is missing in form data", http.StatusBadRequest)
+// finalizeUploadsHandler saves metadata for files uploaded directly to Storage with signed URLs.
+func finalizeUploadsHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
 		return
 	}
-	if relativePath == "" {
-		http.Error(w, "Relative path is missing in form data", http.StatusBadRequest)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
 		return
 	}
-	ctx := r.Context()
-	// Read file content into a byte slice
-	fileContent, err := io.ReadAll(file)
+
+	caller, err := requestCaller(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error reading file content: %v", err), http.StatusInternalServerError)
+		log.Printf("Rejected finalize with invalid ID token: %v", err)
+		http.Error(w, tr(r, "Invalid or expired ID token"), http.StatusUnauthorized)
 		return
 	}
-
-	// If mimeType is not provided by the client, try to detect it from the file content
-	if mimeType == "" {
-		mimeType = http.DetectContentType(fileContent)
+	folderName, files, ok := decodeDirectUploadRequest(w, r)
+	if !ok {
+		return
 	}
 
-	downloadURL, err := backend.UploadFileToStorageAndFirestore(ctx, folderName, relativePath, mimeType, fileContent)
+	ctx := r.Context()
+	finalized, failed, err := backend.FinalizeDirectUploads(ctx, folderName, callerUID(caller), files)
 	if err != nil {
-		log.Printf("Error uploading file to Firebase Storage and Firestore: %v", err)
-		http.Error(w, "Error uploading file to Firebase Storage and Firestore", http.StatusInternalServerError)
+		log.Printf("Error finalizing direct uploads: %v", err)
+		writeBackendError(w, r, err, tr(r, "Folder not found"), tr(r, "Unable to finalize uploads: %v", err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"download_url": downloadURL})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":   finalized, // Metadata keyed by relative path
+		"failed": failed,    // Error messages keyed by relative path
+	})
 }
 
 // updateFileMetadataHandler handles requests to update file metadata in Firestore.
@@ -416,34 +3498,32 @@ func updateFileMetadataHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
 		return
 	}
 
 	var requestBody struct {
-		ID       string `json:"id"`
-		MimeType string `json:"mime_type"`
+		ID       string `json:"id" validate:"required"`
+		MimeType string `json:"mime_type" validate:"required"`
 	}
-
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &requestBody) {
 		return
 	}
 
-	if requestBody.ID == "" || requestBody.MimeType == "" {
-		http.Error(w, "Missing file ID or mime type in request body", http.StatusBadRequest)
+	caller, ok := authorizeFileChanges(w, r, []string{requestBody.ID})
+	if !ok {
 		return
 	}
 
-	ctx := r.Context()
+	ctx := backend.WithActor(r.Context(), caller.UID)
 	err := backend.UpdateFileMetadata(ctx, requestBody.ID, requestBody.MimeType)
 	if err != nil {
 		log.Printf("Error updating file metadata: %v", err)
-		http.Error(w, "Error updating file metadata", http.StatusInternalServerError)
+		writeBackendError(w, r, err, tr(r, "File not found"), tr(r, "Error updating file metadata"))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "File metadata updated successfully"})
+	json.NewEncoder(w).Encode(map[string]string{"message": tr(r, "File metadata updated successfully")})
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"drive-gallery/backend"
+)
+
+// checkNotModified sets the ETag and Last-Modified validators of a response and reports whether
+// the request's If-None-Match or, without it, If-Modified-Since shows the client already has this
+// version, in which case a 304 has been written. etag is quoted; modified may be zero.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	notModified := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		notModified = etag != "" && etagMatches(inm, etag)
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		t, err := http.ParseTime(ims)
+		notModified = err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	if notModified {
+		// A 304 carries the validators and caching headers already set, but no body headers
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return notModified
+}
+
+// etagMatches reports whether an If-None-Match header lists etag or is "*", using the weak
+// comparison If-None-Match calls for.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseByteRange parses a Range header with a single range ("bytes=0-99", "bytes=100-" or
+// "bytes=-100") against an object of size bytes, returning the offset and length to send.
+// ok is false if the range cannot be satisfied; a header with several ranges is ignored, and the
+// whole object is sent.
+func parseByteRange(header string, size int64) (offset, length int64, partial, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, size, false, true
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, false
+	}
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false, false
+		}
+		n = min(n, size)
+		return size - n, n, true, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, true, true
+}
+
+// inlineMediaType reports whether media of contentType may be shown inline on the backend's
+// origin: images, videos and audio, except SVG images, which can run scripts.
+func inlineMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "image/svg+xml" {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "image/") || strings.HasPrefix(mediaType, "video/") || strings.HasPrefix(mediaType, "audio/")
+}
+
+// mediaHandler streams the original of a file from Storage, e.g. for video playback through the
+// backend. The object's generation is the ETag and its update time Last-Modified, so repeat views
+// are answered with 304; single byte ranges are supported for seeking. Files in private folders
+// require the signature of their listed mediaUrl. download=true sends the file as an
+// attachment under its original name, as are files that are not shown inline (see
+// inlineMediaType), since their Content-Type comes from the uploader.
+func mediaHandler(w http.ResponseWriter, r *http.Request) {
+	setCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := strings.TrimPrefix(r.URL.Path, "/api/media/")
+	if fileID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "File ID is missing in path")})
+		return
+	}
+
+	ctx := r.Context()
+	file, err := backend.GetFile(ctx, fileID)
+	var folder *backend.FolderMetadata
+	if err == nil && file != nil {
+		folder, err = backend.GetFolder(ctx, file.FolderID)
+	}
+	if err != nil {
+		log.Printf("Error getting file %s for streaming: %v", fileID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Unable to read file: %v", err)})
+		return
+	}
+	if file == nil {
+		writeFileNotFound(w, r, fileID)
+		return
+	}
+	private := folder != nil && folder.IsPrivate()
+	query := r.URL.Query()
+	if private && !backend.VerifyThumbnailSignature(fileID, query.Get("expires"), query.Get("sig")) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "Media link is invalid or has expired")})
+		return
+	}
+
+	obj, err := backend.StatFileObject(ctx, file)
+	if err != nil {
+		log.Printf("Error getting object of file %s: %v", fileID, err)
+		writeBackendError(w, r, err, tr(r, "File not found"), tr(r, "Unable to read file: %v", err))
+		return
+	}
+
+	if private {
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(backend.PrivateURLTTL.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	if checkNotModified(w, r, fmt.Sprintf(`"%d"`, obj.Generation), obj.Updated) {
+		return
+	}
+
+	offset, length, partial, ok := parseByteRange(r.Header.Get("Range"), obj.Size)
+	if !ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", obj.Size))
+		http.Error(w, tr(r, "Requested range not satisfiable"), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	// A range of an older version must not be combined with the current one
+	if ifRange := r.Header.Get("If-Range"); partial && ifRange != "" && ifRange != w.Header().Get("ETag") {
+		offset, length, partial = 0, obj.Size, false
+	}
+
+	var body io.ReadCloser
+	if r.Method == http.MethodGet && length > 0 {
+		if body, err = backend.OpenFileObject(ctx, file, obj.Generation, offset, length); err != nil {
+			log.Printf("Error opening object of file %s: %v", fileID, err)
+			writeBackendError(w, r, err, tr(r, "File not found"), tr(r, "Unable to read file: %v", err))
+			return
+		}
+		defer body.Close()
+	}
+
+	w.Header().Set("Content-Type", obj.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if query.Get("download") == "true" || !inlineMediaType(obj.ContentType) {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(file.Name)}))
+	}
+	status := http.StatusOK
+	if partial {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, obj.Size))
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	if body != nil {
+		if _, err := io.Copy(w, body); err != nil {
+			log.Printf("Error streaming file %s: %v", fileID, err)
+		}
+	}
+}
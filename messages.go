@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// jaMessages translates user-facing API messages to Japanese. Keys are the English format
+// strings passed to tr, so untranslated messages fall back to English.
+var jaMessages = map[string]string{
+	"%s checksum mismatch: expected %s, got %s":                    "%s チェックサムが一致しません (期待値: %s, 実際: %s)",
+	"%s must be a non-negative integer":                            "%s は0以上の整数で指定してください",
+	"Admin role required":                                          "管理者の権限が必要です",
+	"Admins cannot change their own role":                          "管理者は自分の権限を変更できません",
+	"Between 1 and %d file IDs are required":                       "ファイルIDは1〜%d件指定してください",
+	"Between 1 and %d hash parameters are required":                "hashパラメータは1〜%d件指定してください",
+	"Cannot import: %s":                                            "インポートできません: %s",
+	"Conflict: %v":                                                 "競合が発生しました: %v",
+	"Drive file not found":                                         "Drive のファイルが見つかりません",
+	"Editor or admin role required":                                "編集者または管理者の権限が必要です",
+	"Error parsing form: %v":                                       "フォームの解析に失敗しました: %v",
+	"Error reading file content: %v":                               "ファイル内容の読み込みに失敗しました: %v",
+	"Error reading form field %s: %v":                              "フォーム項目 %s の読み込みに失敗しました: %v",
+	"Error reading form: %v":                                       "フォームの読み込みに失敗しました: %v",
+	"Error retrieving file from form: %v":                          "フォームからファイルを取得できませんでした: %v",
+	"Error updating file metadata":                                 "ファイルのメタデータを更新できませんでした",
+	"Error uploading file to Firebase Storage and Firestore":       "ファイルのアップロードに失敗しました",
+	"Error uploading icon to Firebase Storage":                     "アイコンのアップロードに失敗しました",
+	"Face cluster or profile not found":                            "顔のグループまたはプロフィールが見つかりません",
+	"File ID is missing in path":                                   "パスにファイルIDがありません",
+	"File deleted successfully":                                    "ファイルを削除しました",
+	"File is missing in form data":                                 "フォームにファイルがありません",
+	"File metadata updated successfully":                           "ファイルのメタデータを更新しました",
+	"File name is missing in form data":                            "フォームにファイル名がありません",
+	"File not found":                                               "ファイルが見つかりません",
+	"File or profile not found":                                    "ファイルまたはプロフィールが見つかりません",
+	"File removed":                                                 "削除されたファイル",
+	"Files in archived folders cannot be changed":                  "アーカイブされたフォルダのファイルは変更できません",
+	"Folder '%s' already exists":                                   "フォルダ '%s' は既に存在します",
+	"Folder '%s' does not accept %s files (allowed: %s)":           "フォルダ '%s' には%sファイルをアップロードできません (許可: %s)",
+	"Folder '%s' is archived and read-only":                        "フォルダ '%s' はアーカイブされているため読み取り専用です",
+	"Folder ID is missing in path":                                 "パスにフォルダIDがありません",
+	"Folder name is missing in form data":                          "フォームにフォルダ名がありません",
+	"Folder not found":                                             "フォルダが見つかりません",
+	"Folder or file not found":                                     "フォルダまたはファイルが見つかりません",
+	"Invalid SHA-256 hash: %s":                                     "SHA-256ハッシュが不正です: %s",
+	"Invalid cursor '%s'":                                          "無効なカーソルです: '%s'",
+	"Invalid license '%s' (expected %s)":                           "ライセンス '%s' は不正です (%s のいずれかを指定してください)",
+	"Invalid media type '%s' (expected %s)":                        "メディアタイプ '%s' は不正です (%s のいずれかを指定してください)",
+	"Invalid mode '%s' (expected %s or %s)":                        "mode '%s' は不正です (%s または %s を指定してください)",
+	"Invalid modified_at (expected RFC 3339): %v":                  "modified_at が不正です (RFC 3339形式で指定してください): %v",
+	"Invalid or expired ID token":                                  "IDトークンが無効か期限切れです",
+	"Invalid request body":                                         "リクエスト本文が不正です",
+	"Invalid sha256 (expected 64 hex characters)":                  "sha256 が不正です (16進数64文字で指定してください)",
+	"Invalid since (expected RFC 3339): %v":                        "since が不正です (RFC 3339形式で指定してください): %v",
+	"Invalid tag rule '%s': %s":                                    "タグルール '%s' は不正です: %s",
+	"Invalid webhook: %s":                                          "Webhook が不正です: %s",
+	"Job not found":                                                "ジョブが見つかりません",
+	"Media link is invalid or has expired":                         "メディアのリンクが無効か、有効期限が切れています",
+	"Method not allowed":                                           "許可されていないメソッドです",
+	"Only the uploader, editors and admins may change these files": "これらのファイルを変更できるのは、アップロードした本人、編集者、管理者のみです",
+	"Profile ID is missing in form data":                           "フォームにプロフィールIDがありません",
+	"Profile ID is missing in path":                                "パスにプロフィールIDがありません",
+	"Profile deleted successfully":                                 "プロフィールを削除しました",
+	"Profile not found":                                            "プロフィールが見つかりません",
+	"Profile not found: %s":                                        "プロフィールが見つかりません: %s",
+	"Profile updated successfully":                                 "プロフィールを更新しました",
+	"Profiles reordered successfully":                              "プロフィールの並び順を保存しました",
+	"Relative path is missing in form data":                        "フォームに相対パスがありません",
+	"Report not found":                                             "報告が見つかりません",
+	"Report resolved":                                              "報告を対応済みにしました",
+	"Request timed out after %s":                                   "リクエストが %s でタイムアウトしました",
+	"Requested range not satisfiable":                              "要求された範囲を返せません",
+	"Server is busy; retry in %d seconds":                          "サーバーが混み合っています。%d 秒後に再試行してください",
+	"Sign-in required":                                             "サインインが必要です",
+	"Slug is missing in path":                                      "パスにスラッグがありません",
+	"This file was removed on %s: %s":                              "このファイルは %s に削除されました: %s",
+	"Thumbnail link is invalid or has expired":                     "サムネイルのリンクが無効か、有効期限が切れています",
+	"Thumbnail not found":                                          "サムネイルが見つかりません",
+	"Thumbnail warming queue is full; try again later":             "サムネイル生成のキューがいっぱいです。しばらくしてから再試行してください",
+	"Unable to access tag rules: %v":                               "タグルールにアクセスできませんでした: %v",
+	"Unable to access users: %v":                                   "ユーザーにアクセスできませんでした: %v",
+	"Unable to access webhooks: %v":                                "Webhook にアクセスできませんでした: %v",
+	"Unable to approve uploads: %v":                                "アップロードを承認できませんでした: %v",
+	"Unable to build folder credits: %v":                           "フォルダのクレジットを作成できませんでした: %v",
+	"Unable to build folder manifest: %v":                          "フォルダのマニフェストを作成できませんでした: %v",
+	"Unable to build folder snapshot: %v":                          "フォルダのスナップショットを作成できませんでした: %v",
+	"Unable to build offline manifest: %v":                         "オフライン用マニフェストを作成できませんでした: %v",
+	"Unable to build slideshow: %v":                                "スライドショーを作成できませんでした: %v",
+	"Unable to check existing files: %v":                           "既存ファイルを確認できませんでした: %v",
+	"Unable to check folder: %v":                                   "フォルダを確認できませんでした: %v",
+	"Unable to check permissions: %v":                              "権限を確認できませんでした: %v",
+	"Unable to count files: %v":                                    "ファイル数を数えられませんでした: %v",
+	"Unable to create profile":                                     "プロフィールを作成できませんでした",
+	"Unable to create signed upload URLs: %v":                      "署名付きアップロードURLを作成できませんでした: %v",
+	"Unable to create thumbnail: %v":                               "サムネイルを作成できませんでした: %v",
+	"Unable to delete files: %v":                                   "ファイルを削除できませんでした: %v",
+	"Unable to delete folder: %v":                                  "フォルダを削除できませんでした: %v",
+	"Unable to delete profile":                                     "プロフィールを削除できませんでした",
+	"Unable to duplicate folder: %v":                               "フォルダを複製できませんでした: %v",
+	"Unable to evaluate tag rules: %v":                             "タグルールを評価できませんでした: %v",
+	"Unable to finalize uploads: %v":                               "アップロードを完了できませんでした: %v",
+	"Unable to find folder: %v":                                    "フォルダを検索できませんでした: %v",
+	"Unable to find near-duplicates: %v":                           "類似画像を検索できませんでした: %v",
+	"Unable to get Drive thumbnail: %v":                            "Driveのサムネイルを取得できませんでした: %v",
+	"Unable to get WebSocket status: %v":                           "WebSocketの状態を取得できませんでした: %v",
+	"Unable to get folder stats: %v":                               "フォルダの統計を取得できませんでした: %v",
+	"Unable to get gallery stats: %v":                              "ギャラリーの統計を取得できませんでした: %v",
+	"Unable to get job: %v":                                        "ジョブを取得できませんでした: %v",
+	"Unable to get profile references":                             "プロフィールの参照を取得できませんでした",
+	"Unable to get profile":                                        "プロフィールを取得できませんでした",
+	"Unable to get profiles":                                       "プロフィール一覧を取得できませんでした",
+	"Unable to import Drive zip: %v":                               "Drive の zip をインポートできませんでした: %v",
+	"Unable to label face cluster: %v":                             "顔のグループにラベルを付けられませんでした: %v",
+	"Unable to list Drive files: %v":                               "Driveのファイル一覧を取得できませんでした: %v",
+	"Unable to list Drive folders: %v":                             "Driveのフォルダ一覧を取得できませんでした: %v",
+	"Unable to list dead letters: %v":                              "失敗した通知の一覧を取得できませんでした: %v",
+	"Unable to list face clusters: %v":                             "顔のグループを一覧できませんでした: %v",
+	"Unable to list files: %v":                                     "ファイル一覧を取得できませんでした: %v",
+	"Unable to list folder changes: %v":                            "フォルダの変更履歴を取得できませんでした: %v",
+	"Unable to list folders: %v":                                   "フォルダ一覧を取得できませんでした: %v",
+	"Unable to list pending uploads: %v":                           "承認待ちのアップロードを取得できませんでした: %v",
+	"Unable to list reports: %v":                                   "報告の一覧を取得できませんでした: %v",
+	"Unable to normalize orientations: %v":                         "画像の向きを補正できませんでした: %v",
+	"Unable to purge face data: %v":                                "顔データを削除できませんでした: %v",
+	"Unable to re-index search: %v":                                "検索インデックスを再構築できませんでした: %v",
+	"Unable to read file: %v":                                      "ファイルを読み込めませんでした: %v",
+	"Unable to reconcile MIME types: %v":                           "MIMEタイプの照合を開始できませんでした: %v",
+	"Unable to regenerate download URLs: %v":                       "ダウンロードURLを再生成できませんでした: %v",
+	"Unable to rename folder: %v":                                  "フォルダ名を変更できませんでした: %v",
+	"Unable to reorder profiles: %v":                               "プロフィールを並べ替えられませんでした: %v",
+	"Unable to replay dead letters: %v":                            "失敗した通知を再処理できませんでした: %v",
+	"Unable to resolve report: %v":                                 "報告を対応済みにできませんでした: %v",
+	"Unable to retrieve folder name: %v":                           "フォルダ名を取得できませんでした: %v",
+	"Unable to save report: %v":                                    "報告を保存できませんでした: %v",
+	"Unable to sync changes: %v":                                   "変更を同期できませんでした: %v",
+	"Unable to take down file: %v":                                 "ファイルを取り下げできませんでした: %v",
+	"Unable to transform file: %v":                                 "ファイルを編集できませんでした: %v",
+	"Unable to update file: %v":                                    "ファイルを更新できませんでした: %v",
+	"Unable to update folder: %v":                                  "フォルダを更新できませんでした: %v",
+	"Unable to update profile":                                     "プロフィールを更新できませんでした",
+	"Unable to upload file to Drive: %v":                           "Driveへのアップロードに失敗しました: %v",
+	"Unable to verify manifest: %v":                                "マニフェストを検証できませんでした: %v",
+	"Under legal hold, so it cannot be deleted: %v":                "リーガルホールド中のため削除できません: %v",
+	"Unknown field '%s' (available: %s)":                           "不明なフィールド '%s' です (使用可能: %s)",
+	"Uploading requires the editor or admin role":                  "アップロードには編集者または管理者の権限が必要です",
+	"User not found":                                               "ユーザーが見つかりません",
+	"Webhook not found":                                            "Webhook が見つかりません",
+	"distance must be between 0 and 64":                            "distance は0〜64で指定してください",
+	"filter must be image or video":                                "filter は image または video を指定してください",
+	"folderId query parameter is required":                         "folderId クエリパラメータは必須です",
+	"hideNearDuplicates is not supported with %s":                  "%s では hideNearDuplicates を使用できません",
+	"is required":                                                  "必須です",
+	"must be a SHA-256 hash (64 hex characters)":                   "SHA-256ハッシュ (16進数64文字) で指定してください",
+	"must be at least %d bytes":                                    "%d バイト以上で指定してください",
+	"must be at least %d":                                          "%d 以上で指定してください",
+	"must be at most %d bytes":                                     "%d バイト以内で指定してください",
+	"must be at most %d":                                           "%d 以下で指定してください",
+	"must be of type %s":                                           "%s 型で指定してください",
+	"must be one of: %s":                                           "次のいずれかで指定してください: %s",
+	"must have at least %d items":                                  "%d 件以上指定してください",
+	"must have at most %d items":                                   "%d 件以内で指定してください",
+	"photographer_name must be at most %d bytes":                   "photographer_name は %d バイト以内で指定してください",
+	"sz must be between 1 and %d":                                  "sz は1〜%dで指定してください",
+	"w must be one of %v":                                          "w は %v のいずれかで指定してください",
+}
+
+// tr formats a user-facing message in the language the client prefers (Accept-Language),
+// English or Japanese. Messages without a translation stay in English.
+func tr(r *http.Request, format string, args ...interface{}) string {
+	if requestLanguage(r) == "ja" {
+		if translated, ok := jaMessages[format]; ok {
+			format = translated
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// requestLanguage returns "ja" or "en", whichever the Accept-Language header ranks higher.
+// English is the default.
+func requestLanguage(r *http.Request) string {
+	best, bestQ := "en", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		lang := strings.ToLower(tag)
+		if i := strings.Index(lang, "-"); i != -1 {
+			lang = lang[:i]
+		}
+		if (lang == "ja" || lang == "en") && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
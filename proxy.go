@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	// trustedProxies are the peers whose X-Forwarded-For and X-Forwarded-Proto headers are
+	// believed, from TRUSTED_PROXIES: comma-separated IPs or CIDRs, e.g. "127.0.0.1,10.0.0.0/8".
+	// Headers from anyone else are ignored, as clients can send whatever they like.
+	trustedProxies = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	// unixSocket is set when serving on UNIX_SOCKET, whose peers are always the local proxy.
+	unixSocket bool
+)
+
+// parseTrustedProxies parses TRUSTED_PROXIES, logging and skipping invalid entries.
+func parseTrustedProxies(spec string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("WARNING: Ignoring invalid TRUSTED_PROXIES entry %q", entry)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// withForwardedHeaders rewrites requests relayed by a trusted proxy as the proxy received them:
+// RemoteAddr becomes the client's address from X-Forwarded-For, the rightmost entry not itself a
+// trusted proxy, and URL.Scheme the X-Forwarded-Proto. Logs and anything else keyed by client
+// then see the viewer rather than nginx or Caddy. Requests from other peers are left alone.
+func withForwardedHeaders(handler http.Handler) http.Handler {
+	if len(trustedProxies) == 0 && !unixSocket {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := net.ParseIP(clientIP(r))
+		if unixSocket || (peer != nil && isTrustedProxy(peer)) {
+			if client := forwardedClient(r.Header.Values("X-Forwarded-For")); client != "" {
+				r.RemoteAddr = net.JoinHostPort(client, "0")
+			}
+			if proto := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+				r.URL.Scheme = proto
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// forwardedClient returns the client's IP from X-Forwarded-For values: proxies append the address
+// they received a request from, so the first entry from the right that is not a trusted proxy is
+// the last one a trusted proxy vouched for.
+func forwardedClient(values []string) string {
+	var hops []string
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			return "" // Garbage; trust none of it
+		}
+		if i == 0 || !isTrustedProxy(ip) {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// clientIP returns the IP address of the client of r, as rewritten by withForwardedHeaders, or ""
+// for a peer on the Unix socket without X-Forwarded-For.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// defaultAutocertCacheDir is where Let's Encrypt certificates are kept between restarts unless
+// AUTOCERT_CACHE_DIR says otherwise.
+const defaultAutocertCacheDir = "autocert-cache"
+
+// serve runs the API server until it fails. Cloud Run terminates TLS itself, so by default it
+// serves plain HTTP on PORT. Self-hosted deployments can serve HTTPS instead, with HTTP/2:
+//
+//	TLS_CERT_FILE and TLS_KEY_FILE  a certificate and key in PEM files
+//	AUTOCERT_DOMAINS                comma-separated domains to get Let's Encrypt certificates for;
+//	                                PORT then defaults to 443, and HTTP_ADDR (":80") answers the
+//	                                ACME challenges and redirects everything else to HTTPS
+//
+// Without TLS, H2C=true accepts HTTP/2 over cleartext, for proxies that speak it to backends.
+// UNIX_SOCKET listens on a Unix domain socket instead of PORT, for a proxy on the same host.
+func serve(handler http.Handler) error {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	var domains []string
+	for _, d := range strings.Split(os.Getenv("AUTOCERT_DOMAINS"), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+		if len(domains) > 0 {
+			port = "443"
+		}
+	}
+	listener, err := listen(":" + port)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Handler:           withForwardedHeaders(handler), // After listen, which tells whether peers are on the socket
+		ReadHeaderTimeout: 10 * time.Second,              // Slow clients can't hold connections open without sending a request
+	}
+
+	switch {
+	case len(domains) > 0:
+		cacheDir := os.Getenv("AUTOCERT_CACHE_DIR")
+		if cacheDir == "" {
+			cacheDir = defaultAutocertCacheDir
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      os.Getenv("AUTOCERT_EMAIL"),
+		}
+		server.TLSConfig = manager.TLSConfig() // Offers h2 and the ACME TLS-ALPN challenge
+		httpAddr := os.Getenv("HTTP_ADDR")
+		if httpAddr == "" {
+			httpAddr = ":80"
+		}
+		go func() {
+			redirect := &http.Server{Addr: httpAddr, Handler: manager.HTTPHandler(nil), ReadHeaderTimeout: 10 * time.Second}
+			if err := redirect.ListenAndServe(); err != nil {
+				log.Printf("WARNING: HTTP listener on %s for ACME challenges stopped: %v", httpAddr, err)
+			}
+		}()
+		log.Printf("Backend server listening on %s with Let's Encrypt certificates for %s (HTTP/2 enabled)", listener.Addr(), strings.Join(domains, ", "))
+		return server.ServeTLS(listener, "", "")
+
+	case certFile != "" || keyFile != "":
+		log.Printf("Backend server listening on %s with TLS from %s (HTTP/2 enabled)", listener.Addr(), certFile)
+		return server.ServeTLS(listener, certFile, keyFile) // net/http negotiates HTTP/2 over TLS itself
+
+	default:
+		if os.Getenv("H2C") == "true" {
+			server.Handler = h2c.NewHandler(server.Handler, &http2.Server{})
+			log.Printf("Backend server listening on %s (HTTP/2 over cleartext enabled)", listener.Addr())
+		} else {
+			log.Printf("Backend server listening on %s", listener.Addr())
+		}
+		return server.Serve(listener)
+	}
+}
+
+// listen opens the Unix socket UNIX_SOCKET, replacing a stale one left by an earlier run, with the
+// permissions UNIX_SOCKET_MODE (octal, default 0660) so the proxy's group can connect; without
+// UNIX_SOCKET it listens on the TCP address addr.
+func listen(addr string) (net.Listener, error) {
+	path := os.Getenv("UNIX_SOCKET")
+	if path == "" {
+		return net.Listen("tcp", addr)
+	}
+	mode := os.FileMode(0o660)
+	if value := os.Getenv("UNIX_SOCKET_MODE"); value != "" {
+		m, err := strconv.ParseUint(value, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UNIX_SOCKET_MODE %q: %v", value, err)
+		}
+		mode = os.FileMode(m)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket %s: %v", path, err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set permissions of socket %s: %v", path, err)
+	}
+	unixSocket = true
+	return listener, nil
+}
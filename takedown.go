@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"drive-gallery/backend"
+)
+
+// tombstoneTemplate renders the page shown instead of a file that was taken down.
+var tombstoneTemplate = template.Must(template.New("tombstone").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { margin: 2rem; font-family: sans-serif; color: #333; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+</body>
+</html>
+`))
+
+// writeFileNotFound answers a request for a file that does not exist: 410 with the reason if it
+// was taken down (an HTML page for browsers, JSON otherwise), or a plain 404.
+func writeFileNotFound(w http.ResponseWriter, r *http.Request, fileID string) {
+	tombstone, err := backend.GetTombstone(r.Context(), fileID)
+	if err != nil {
+		log.Printf("Error getting tombstone of file %s: %v", fileID, err)
+	}
+	if tombstone == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": tr(r, "File not found")})
+		return
+	}
+
+	message := tr(r, "This file was removed on %s: %s", tombstone.TakenDownAt.Format("2006-01-02"), tombstone.Reason)
+	w.Header().Set("Cache-Control", "no-store")
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusGone)
+		if err := tombstoneTemplate.Execute(w, map[string]string{"Title": tr(r, "File removed"), "Message": message}); err != nil {
+			log.Printf("Error rendering tombstone of file %s: %v", fileID, err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusGone)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":       message,
+		"takenDownAt": tombstone.TakenDownAt,
+		"reason":      tombstone.Reason,
+	})
+}
+
+// takeDownFile removes a file with a reason, leaving a tombstone its URLs answer with.
+func takeDownFile(w http.ResponseWriter, r *http.Request, fileID string) {
+	caller, ok := requireModerator(w, r)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		Reason string `json:"reason" validate:"required,max=2000"`
+	}
+	if !decodeJSONBody(w, r, &requestBody) {
+		return
+	}
+
+	tombstone, err := backend.TakeDownFile(backend.WithActor(r.Context(), caller.UID), fileID, strings.TrimSpace(requestBody.Reason))
+	if err != nil {
+		log.Printf("Error taking down file %s: %v", fileID, err)
+		writeBackendError(w, r, err, tr(r, "File not found"), tr(r, "Unable to take down file: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": tombstone})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Default request deadlines; override with REQUEST_TIMEOUT and UPLOAD_TIMEOUT (Go durations, e.g. "45s").
+const (
+	defaultRequestTimeout = 30 * time.Second
+	defaultUploadTimeout  = 10 * time.Minute
+)
+
+var (
+	// requestTimeout bounds ordinary API requests.
+	requestTimeout = durationFromEnv("REQUEST_TIMEOUT", defaultRequestTimeout)
+	// uploadTimeout bounds uploads and admin operations that touch many files.
+	uploadTimeout = durationFromEnv("UPLOAD_TIMEOUT", defaultUploadTimeout)
+)
+
+// durationFromEnv parses the environment variable name, falling back to def if it is unset or invalid.
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("WARNING: Invalid %s %q, using %s", name, value, def)
+		return def
+	}
+	return d
+}
+
+// withTimeout gives each request a context deadline, so Firestore, Storage and Drive calls made
+// with r.Context() are cancelled instead of hanging. If the handler fails after the deadline,
+// its error response is replaced by a 504 with a JSON error.
+func withTimeout(timeout time.Duration, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+		handler(&deadlineWriter{ResponseWriter: w, r: r, timeout: timeout}, r)
+	}
+}
+
+// withIdleTimeout is withTimeout for streaming responses, such as long videos: the request is
+// cancelled once the handler has not written for timeout, rather than after a fixed time, and
+// each write must reach the client within timeout.
+func withIdleTimeout(timeout time.Duration, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+		idle := time.AfterFunc(timeout, func() { cancel(context.DeadlineExceeded) })
+		defer idle.Stop()
+		r = r.WithContext(ctx)
+		handler(&idleWriter{ResponseWriter: w, controller: http.NewResponseController(w), idle: idle, timeout: timeout}, r)
+	}
+}
+
+// idleWriter restarts the idle timer of withIdleTimeout on every write.
+type idleWriter struct {
+	http.ResponseWriter
+	controller *http.ResponseController
+	idle       *time.Timer
+	timeout    time.Duration
+}
+
+func (w *idleWriter) Write(p []byte) (int, error) {
+	w.idle.Reset(w.timeout)
+	w.controller.SetWriteDeadline(time.Now().Add(w.timeout)) // Not supported by every writer, e.g. in tests
+	n, err := w.ResponseWriter.Write(p)
+	w.idle.Reset(w.timeout)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *idleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// deadlineWriter turns 5xx responses written after the request deadline into 504s.
+type deadlineWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	timeout  time.Duration
+	timedOut bool // The 504 has been written; the handler's own body is discarded
+}
+
+func (w *deadlineWriter) WriteHeader(code int) {
+	if code >= 500 && w.r.Context().Err() == context.DeadlineExceeded {
+		w.timedOut = true
+		w.Header().Set("Content-Type", "application/json")
+		w.ResponseWriter.WriteHeader(http.StatusGatewayTimeout)
+		json.NewEncoder(w.ResponseWriter).Encode(map[string]string{"error": tr(w.r, "Request timed out after %s", w.timeout)})
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	if w.timedOut {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush streams.
+func (w *deadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,3 @@
+module cli_loadgen
+
+go 1.23.2
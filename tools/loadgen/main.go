@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// result is the outcome of a single HTTP request issued by a worker.
+type result struct {
+	op      string
+	latency time.Duration
+	err     error
+}
+
+func main() {
+	apiBaseURL := flag.String("api-url", "http://localhost:8080", "負荷をかけるバックエンドAPIのベースURL")
+	folderID := flag.String("folder-id", "", "ページングを試験するフォルダID (/api/files/{folderId})")
+	pageSize := flag.Int("page-size", 100, "1ページあたりの件数 (pageSize)")
+	filter := flag.String("filter", "", "一覧のフィルタ (image / video / 空)")
+	concurrency := flag.Int("concurrency", 8, "同時実行ワーカー数")
+	duration := flag.Duration("duration", 30*time.Second, "負荷をかける時間")
+	uploadRatio := flag.Float64("upload-ratio", 0, "アップロードを行うリクエストの割合 (0.0〜1.0)")
+	uploadSize := flag.Int("upload-size", 256<<10, "アップロードするダミーファイルのバイト数")
+	uploadFolder := flag.String("upload-folder", "loadgen", "アップロード先の論理フォルダ名")
+	token := flag.String("token", os.Getenv("GALLERY_TOKEN"), "アップロードに使う編集者または管理者のFirebase IDトークン")
+
+	flag.Parse()
+
+	if *concurrency <= 0 || *uploadRatio < 0 || *uploadRatio > 1 {
+		fmt.Println("エラー: --concurrency は1以上、--upload-ratio は0.0〜1.0で指定してください。")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *folderID == "" && *uploadRatio < 1 {
+		fmt.Println("エラー: 一覧の負荷試験には --folder-id が必須です (アップロードのみの場合は --upload-ratio 1)。")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	client := &http.Client{
+		Timeout:   5 * time.Minute,
+		Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency},
+	}
+
+	fmt.Printf("%s に %d 並列で %s 負荷をかけます (アップロード割合: %.2f)\n", *apiBaseURL, *concurrency, *duration, *uploadRatio)
+
+	results := make(chan result, *concurrency*4)
+	deadline := time.Now().Add(*duration)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			pageToken := ""
+			for n := 0; time.Now().Before(deadline); n++ {
+				// Spread uploads evenly instead of randomly so runs are comparable
+				if *uploadRatio > 0 && float64(n%100) < *uploadRatio*100 {
+					results <- uploadOnce(client, *apiBaseURL, *token, *uploadFolder, worker, n, *uploadSize)
+					continue
+				}
+				var res result
+				res, pageToken = listPage(client, *apiBaseURL, *folderID, *pageSize, *filter, pageToken)
+				results <- res
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	stats := map[string]*opStats{}
+	for res := range results {
+		s, ok := stats[res.op]
+		if !ok {
+			s = &opStats{}
+			stats[res.op] = s
+		}
+		s.add(res)
+	}
+
+	fmt.Println()
+	fmt.Printf("%-8s %8s %8s %8s %10s %10s %10s %10s\n", "op", "count", "errors", "err%", "p50", "p95", "p99", "max")
+	for _, op := range []string{"list", "upload"} {
+		s, ok := stats[op]
+		if !ok {
+			continue
+		}
+		s.print(op, *duration)
+	}
+
+	var samples []string
+	for _, s := range stats {
+		samples = append(samples, s.errorSamples...)
+	}
+	if len(samples) > 0 {
+		fmt.Println("\nエラー例:")
+		for _, e := range samples {
+			fmt.Printf("  %s\n", e)
+		}
+	}
+}
+
+// listPage fetches one page of /api/files/{folderId}. It follows nextPageToken and
+// wraps around to the first page once the listing is exhausted.
+func listPage(client *http.Client, apiBaseURL, folderID string, pageSize int, filter, pageToken string) (result, string) {
+	query := url.Values{}
+	query.Set("pageSize", fmt.Sprintf("%d", pageSize))
+	if pageToken != "" {
+		query.Set("pageToken", pageToken)
+	}
+	if filter != "" {
+		query.Set("filter", filter)
+	}
+	endpoint := fmt.Sprintf("%s/api/files/%s?%s", apiBaseURL, url.PathEscape(folderID), query.Encode())
+
+	start := time.Now()
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return result{op: "list", latency: time.Since(start), err: err}, ""
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		NextPageToken string `json:"nextPageToken"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return result{op: "list", latency: time.Since(start), err: fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))}, ""
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return result{op: "list", latency: time.Since(start), err: fmt.Errorf("decode response: %v", err)}, ""
+	}
+	return result{op: "list", latency: time.Since(start)}, body.NextPageToken
+}
+
+// uploadOnce posts a random (and therefore never deduplicated) payload to /api/upload/file.
+func uploadOnce(client *http.Client, apiBaseURL, token, folderName string, worker, n, size int) result {
+	content := make([]byte, size)
+	if _, err := rand.Read(content); err != nil {
+		return result{op: "upload", err: err}
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	relativePath := fmt.Sprintf("w%02d/%06d.bin", worker, n)
+	part, err := writer.CreateFormFile("file", relativePath)
+	if err != nil {
+		return result{op: "upload", err: err}
+	}
+	part.Write(content)
+	writer.WriteField("folder_name", folderName)
+	writer.WriteField("relative_path", relativePath)
+	writer.WriteField("mime_type", "application/octet-stream")
+	writer.Close()
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/upload/file", apiBaseURL), body)
+	if err != nil {
+		return result{op: "upload", err: err}
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return result{op: "upload", latency: time.Since(start), err: err}
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return result{op: "upload", latency: time.Since(start), err: fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))}
+	}
+	return result{op: "upload", latency: time.Since(start)}
+}
+
+// opStats aggregates the results of one operation type.
+type opStats struct {
+	latencies    []time.Duration
+	errors       int
+	errorSamples []string
+}
+
+func (s *opStats) add(res result) {
+	s.latencies = append(s.latencies, res.latency)
+	if res.err != nil {
+		s.errors++
+		if len(s.errorSamples) < 5 {
+			s.errorSamples = append(s.errorSamples, fmt.Sprintf("%s: %v", res.op, res.err))
+		}
+	}
+}
+
+func (s *opStats) print(op string, duration time.Duration) {
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	count := len(s.latencies)
+	errRate := 100 * float64(s.errors) / float64(count)
+	fmt.Printf("%-8s %8d %8d %7.2f%% %10s %10s %10s %10s  (%.1f req/s)\n",
+		op, count, s.errors, errRate,
+		percentile(s.latencies, 50), percentile(s.latencies, 95), percentile(s.latencies, 99), s.latencies[count-1].Round(time.Millisecond),
+		float64(count)/duration.Seconds())
+}
+
+// percentile returns the p-th percentile of sorted latencies (nearest-rank).
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Round(time.Millisecond)
+}
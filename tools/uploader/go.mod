@@ -1,3 +0,0 @@
-module cli_uploader
-
-go 1.23.2
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// fieldError is a validation failure of one field of a JSON request body.
+type fieldError struct {
+	Field   string `json:"field"` // JSON path, e.g. "files[2].hash"
+	Rule    string `json:"rule"`  // The failed rule, e.g. "required" or "max"
+	Message string `json:"message"`
+}
+
+// decodeJSONBody decodes the JSON body of r into v, a pointer to a struct, and checks the
+// `validate` tags of its fields (see validateFields). On failure it writes a 400 JSON error with
+// the failed fields as "fields" and returns false.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var fields []fieldError
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+			fields = append(fields, fieldError{Field: typeErr.Field, Rule: "type", Message: tr(r, "must be of type %s", typeErr.Type.String())})
+		}
+		writeFieldErrors(w, r, fields)
+		return false
+	}
+	if fields := validateFields(r, reflect.ValueOf(v).Elem(), ""); len(fields) > 0 {
+		writeFieldErrors(w, r, fields)
+		return false
+	}
+	return true
+}
+
+// writeFieldErrors writes a 400 "Invalid request body" JSON error listing the failed fields.
+func writeFieldErrors(w http.ResponseWriter, r *http.Request, fields []fieldError) {
+	body := map[string]interface{}{"error": tr(r, "Invalid request body")}
+	if len(fields) > 0 {
+		body["fields"] = fields
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(body)
+}
+
+// validateFields checks the fields of the struct v against their `validate` tags, a
+// comma-separated list of rules:
+//
+//	required  strings must not be blank, slices not empty, numbers not zero
+//	min=N     minimum length in bytes of a string, items of a slice, or value of a number
+//	max=N     maximum, likewise
+//	oneof=a b the value must be one of the space-separated words
+//	sha256    a SHA-256 hash in hex, in either case
+//	omitempty skips the rules after it when the value is zero, e.g. "omitempty,oneof=a b"
+//
+// Structs and slices of structs are checked recursively. Field names in the result are the JSON
+// names, prefixed with path.
+func validateFields(r *http.Request, v reflect.Value, path string) []fieldError {
+	var errs []fieldError
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if path != "" {
+			name = path + "." + name
+		}
+		fv := v.Field(i)
+
+		for _, rule := range strings.Split(sf.Tag.Get("validate"), ",") {
+			if rule == "" {
+				continue
+			}
+			if rule == "omitempty" {
+				if fv.IsZero() {
+					break
+				}
+				continue
+			}
+			if msg := checkRule(r, fv, rule); msg != "" {
+				ruleName, _, _ := strings.Cut(rule, "=")
+				errs = append(errs, fieldError{Field: name, Rule: ruleName, Message: msg})
+				break // One error per field
+			}
+		}
+
+		switch {
+		case fv.Kind() == reflect.Struct && sf.Type.PkgPath() != "time":
+			errs = append(errs, validateFields(r, fv, name)...)
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Struct:
+			for j := 0; j < fv.Len(); j++ {
+				errs = append(errs, validateFields(r, fv.Index(j), fmt.Sprintf("%s[%d]", name, j))...)
+			}
+		}
+	}
+	return errs
+}
+
+// checkRule returns the message of a failed rule, or "" if fv satisfies it.
+func checkRule(r *http.Request, fv reflect.Value, rule string) string {
+	ruleName, arg, _ := strings.Cut(rule, "=")
+	n, _ := strconv.ParseInt(arg, 10, 64)
+
+	var size int64 // Length of strings and slices, value of numbers
+	isNumber := false
+	switch fv.Kind() {
+	case reflect.String:
+		size = int64(len(fv.String()))
+	case reflect.Slice, reflect.Map:
+		size = int64(fv.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size, isNumber = fv.Int(), true
+	}
+
+	switch ruleName {
+	case "required":
+		blank := fv.IsZero()
+		switch fv.Kind() {
+		case reflect.String:
+			blank = strings.TrimSpace(fv.String()) == ""
+		case reflect.Slice, reflect.Map:
+			blank = fv.Len() == 0
+		}
+		if blank {
+			return tr(r, "is required")
+		}
+	case "min", "max":
+		if (ruleName == "min" && size >= n) || (ruleName == "max" && size <= n) {
+			return ""
+		}
+		switch {
+		case isNumber && ruleName == "min":
+			return tr(r, "must be at least %d", n)
+		case isNumber:
+			return tr(r, "must be at most %d", n)
+		case fv.Kind() == reflect.String && ruleName == "min":
+			return tr(r, "must be at least %d bytes", n)
+		case fv.Kind() == reflect.String:
+			return tr(r, "must be at most %d bytes", n)
+		case ruleName == "min":
+			return tr(r, "must have at least %d items", n)
+		default:
+			return tr(r, "must have at most %d items", n)
+		}
+	case "oneof":
+		value := fmt.Sprint(fv.Interface())
+		for _, allowed := range strings.Fields(arg) {
+			if value == allowed {
+				return ""
+			}
+		}
+		return tr(r, "must be one of: %s", strings.Join(strings.Fields(arg), ", "))
+	case "sha256":
+		if !isSHA256Hex(strings.ToLower(fv.String())) {
+			return tr(r, "must be a SHA-256 hash (64 hex characters)")
+		}
+	}
+	return ""
+}